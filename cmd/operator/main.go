@@ -2,10 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	openshiftconfig "github.com/openshift/api/config/v1"
 	mapi "github.com/openshift/api/machine/v1beta1"
@@ -14,9 +16,12 @@ import (
 	"github.com/operator-framework/operator-lib/leader"
 	"github.com/spf13/pflag"
 	"go.uber.org/zap/zapcore"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -24,13 +29,16 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	"github.com/openshift/windows-machine-config-operator/controllers"
 	"github.com/openshift/windows-machine-config-operator/pkg/cluster"
+	"github.com/openshift/windows-machine-config-operator/pkg/instance"
 	"github.com/openshift/windows-machine-config-operator/pkg/metrics"
 	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig/payload"
 	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
 	"github.com/openshift/windows-machine-config-operator/pkg/windows"
+	"github.com/openshift/windows-machine-config-operator/pkg/wiparser"
 	"github.com/openshift/windows-machine-config-operator/version"
 	//+kubebuilder:scaffold:imports
 )
@@ -46,6 +54,19 @@ import (
 // ServiceAccount permissions used to watch operator on secrets.
 //+kubebuilder:rbac:groups="",resources=secrets,verbs=watch
 
+// leaderElectionBackoff bounds the retries of failed attempts to become the leader, giving a slow API server roughly
+// four minutes to become available before the operator exits
+var leaderElectionBackoff = wait.Backoff{
+	Duration: 2 * time.Second,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    8,
+	Cap:      time.Minute,
+}
+
+// nodeSelectorEnvVar is the environment variable giving the label selector of the Windows nodes the operator manages
+const nodeSelectorEnvVar = "WINDOWS_NODE_SELECTOR"
+
 var (
 	scheme   = runtime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
@@ -63,10 +84,13 @@ func init() {
 func main() {
 	var debugLogging bool
 	var metricsAddr string
+	var webhookPort int
 
 	flag.BoolVar(&debugLogging, "debugLogging", false, "Log debug messages")
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0.0.0.0:9182",
 		"The address and port the metric endpoint binds to 0.0.0.0:9182")
+	flag.IntVar(&webhookPort, "webhook-port", 0,
+		"The port the validating webhook of the windows-instances ConfigMap is served on. Disabled if 0")
 
 	// Add flags registered by imported packages (e.g. glog and
 	// controller-runtime)
@@ -138,14 +162,14 @@ func main() {
 	}
 
 	if err := payload.PopulateNetworkConfScript(clusterConfig.Network().GetServiceCIDR(), windows.OVNKubeOverlayNetwork,
-		windows.HNSPSModule, windows.CniConfDir+"\\cni.conf"); err != nil {
+		windows.HNSPSModule, windows.CNIConfigPath, windows.HNSEndpointPoliciesPath); err != nil {
 		setupLog.Error(err, "unable to generate CNI config script")
 		os.Exit(1)
 	}
 
 	ctx := context.TODO()
 	// Become the leader before proceeding
-	err = leader.Become(ctx, "windows-machine-config-operator-lock")
+	err = becomeLeader(ctx, "windows-machine-config-operator-lock")
 	if err != nil {
 		setupLog.Error(err, "failed to become a leader within current namespace")
 		os.Exit(1)
@@ -164,6 +188,7 @@ func main() {
 			SecureServing:  true,
 			FilterProvider: filters.WithAuthenticationAndAuthorization,
 		},
+		WebhookServer: webhook.NewServer(webhook.Options{Port: webhookPort}),
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
@@ -190,6 +215,17 @@ func main() {
 	}
 	setupLog.Info("operator", "namespace", watchNamespace)
 
+	nodeSelector, err := getNodeSelector()
+	if err != nil {
+		setupLog.Error(err, "invalid node selector")
+		os.Exit(1)
+	}
+	if nodeSelector.Empty() {
+		setupLog.Info("managing all Windows nodes")
+	} else {
+		setupLog.Info("managing Windows nodes matching selector", "selector", nodeSelector.String())
+	}
+
 	// Setup all Controllers
 	winMachineReconciler, err := controllers.NewWindowsMachineReconciler(mgr, clusterConfig, watchNamespace)
 	if err != nil {
@@ -201,7 +237,7 @@ func main() {
 		os.Exit(1)
 	}
 
-	nodeReconciler, err := controllers.NewNodeReconciler(mgr, clusterConfig, watchNamespace)
+	nodeReconciler, err := controllers.NewNodeReconciler(mgr, clusterConfig, watchNamespace, nodeSelector)
 	if err != nil {
 		setupLog.Error(err, "unable to create Node reconciler")
 		os.Exit(1)
@@ -221,6 +257,16 @@ func main() {
 		os.Exit(1)
 	}
 
+	wicdTokenReconciler, err := controllers.NewWICDTokenReconciler(mgr, clusterConfig, watchNamespace)
+	if err != nil {
+		setupLog.Error(err, "unable to create WICD token reconciler")
+		os.Exit(1)
+	}
+	if err = wicdTokenReconciler.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create WICD token controller")
+		os.Exit(1)
+	}
+
 	secretReconciler, err := controllers.NewSecretReconciler(mgr, clusterConfig, watchNamespace)
 	if err != nil {
 		setupLog.Error(err, "unable to create Secret reconciler")
@@ -266,6 +312,19 @@ func main() {
 		os.Exit(1)
 	}
 
+	runtimeClassReconciler := controllers.NewRuntimeClassReconciler(mgr, watchNamespace)
+	if err = runtimeClassReconciler.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "RuntimeClass")
+		os.Exit(1)
+	}
+
+	// The webhook server is only started once a webhook is registered with it
+	if webhookPort > 0 {
+		mgr.GetWebhookServer().Register(wiparser.ValidatingWebhookPath, &webhook.Admission{
+			Handler: wiparser.NewValidator(scheme, watchNamespace, instance.DefaultUsername(clusterConfig.Platform())),
+		})
+	}
+
 	//+kubebuilder:scaffold:builder
 	// The above marker tells kubebuilder that this is where the SetupWithManager function should be inserted when new
 	// controllers are generated by Operator SDK.
@@ -329,6 +388,35 @@ func checkIfRequiredFilesExist(requiredFiles []string) error {
 	return nil
 }
 
+// becomeLeader blocks until the operator pod holds the leader lock with the given name. Transient errors, such as
+// the API server being unavailable during cluster startup, are retried with backoff. Permanent errors are returned
+// immediately, as are transient errors once the retries have been exhausted.
+func becomeLeader(ctx context.Context, lockName string) error {
+	var lastErr error
+	err := wait.ExponentialBackoffWithContext(ctx, leaderElectionBackoff, func(ctx context.Context) (bool, error) {
+		lastErr = leader.Become(ctx, lockName)
+		if lastErr == nil {
+			return true, nil
+		}
+		if isPermanentLeaderError(lastErr) {
+			return false, lastErr
+		}
+		setupLog.Info("retrying leader election after transient error", "error", lastErr.Error())
+		return false, nil
+	})
+	if wait.Interrupted(err) && lastErr != nil {
+		return fmt.Errorf("unable to become the leader after retrying: %w", lastErr)
+	}
+	return err
+}
+
+// isPermanentLeaderError returns true if the given error from a leader election attempt will not clear by retrying,
+// such as the operator not running within a namespace, or lacking the permissions required to take the lock
+func isPermanentLeaderError(err error) bool {
+	return errors.Is(err, leader.ErrNoNamespace) || apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err) ||
+		apierrors.IsInvalid(err) || apierrors.IsBadRequest(err)
+}
+
 // getWatchNamespace returns the Namespace the operator should be watching for changes
 // An empty value means the operator is running with cluster scope.
 func getWatchNamespace() (string, error) {
@@ -340,3 +428,13 @@ func getWatchNamespace() (string, error) {
 	}
 	return ns, nil
 }
+
+// getNodeSelector returns the label selector of the Windows nodes the operator manages, given by the
+// WINDOWS_NODE_SELECTOR environment variable. All Windows nodes are managed if it is not set or empty.
+func getNodeSelector() (labels.Selector, error) {
+	selector, err := labels.Parse(os.Getenv(nodeSelectorEnvVar))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid label selector: %w", nodeSelectorEnvVar, err)
+	}
+	return selector, nil
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"fmt"
 	"testing"
 
+	"github.com/operator-framework/operator-lib/leader"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 // TestCheckIfRequiredFilesExist tests if checkIfRequiredFilesExist function is throwing appropriate error when some
@@ -23,3 +27,88 @@ func TestCheckIfRequiredFilesExist(t *testing.T) {
 		"Expected error message is absent")
 
 }
+
+func TestIsPermanentLeaderError(t *testing.T) {
+	configMaps := schema.GroupResource{Resource: "configmaps"}
+	testCases := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{
+			name:     "not running in a namespace",
+			err:      leader.ErrNoNamespace,
+			expected: true,
+		},
+		{
+			name:     "forbidden",
+			err:      apierrors.NewForbidden(configMaps, "lock", fmt.Errorf("denied")),
+			expected: true,
+		},
+		{
+			name:     "unauthorized",
+			err:      apierrors.NewUnauthorized("unauthorized"),
+			expected: true,
+		},
+		{
+			name:     "API server timeout",
+			err:      apierrors.NewServerTimeout(configMaps, "get", 1),
+			expected: false,
+		},
+		{
+			name:     "API server unavailable",
+			err:      apierrors.NewServiceUnavailable("unavailable"),
+			expected: false,
+		},
+		{
+			name:     "connection error",
+			err:      fmt.Errorf("dial tcp 172.30.0.1:443: connect: connection refused"),
+			expected: false,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, isPermanentLeaderError(test.err))
+		})
+	}
+}
+
+func TestGetNodeSelector(t *testing.T) {
+	testCases := []struct {
+		name          string
+		value         string
+		expectedEmpty bool
+		expectedErr   bool
+	}{
+		{
+			name:          "not set",
+			value:         "",
+			expectedEmpty: true,
+		},
+		{
+			name:  "equality selector",
+			value: "team=a",
+		},
+		{
+			name:  "set based selector",
+			value: "team in (a,b),!legacy",
+		},
+		{
+			name:        "invalid selector",
+			value:       "team in a",
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("WINDOWS_NODE_SELECTOR", test.value)
+			selector, err := getNodeSelector()
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedEmpty, selector.Empty())
+		})
+	}
+}
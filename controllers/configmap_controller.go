@@ -19,18 +19,24 @@ package controllers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"reflect"
+	"strconv"
 	"strings"
 
-	config "github.com/openshift/api/config/v1"
+	mcfg "github.com/openshift/api/machineconfiguration/v1"
 	core "k8s.io/api/core/v1"
 	rbac "k8s.io/api/rbac/v1"
 	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	kubeTypes "k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -44,15 +50,19 @@ import (
 	"github.com/openshift/windows-machine-config-operator/pkg/cluster"
 	"github.com/openshift/windows-machine-config-operator/pkg/condition"
 	"github.com/openshift/windows-machine-config-operator/pkg/crypto"
+	"github.com/openshift/windows-machine-config-operator/pkg/hostprocess"
 	"github.com/openshift/windows-machine-config-operator/pkg/ignition"
 	"github.com/openshift/windows-machine-config-operator/pkg/instance"
+	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
 	"github.com/openshift/windows-machine-config-operator/pkg/metrics"
 	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig"
 	"github.com/openshift/windows-machine-config-operator/pkg/patch"
 	"github.com/openshift/windows-machine-config-operator/pkg/secrets"
 	"github.com/openshift/windows-machine-config-operator/pkg/services"
 	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
+	"github.com/openshift/windows-machine-config-operator/pkg/settings"
 	"github.com/openshift/windows-machine-config-operator/pkg/signer"
+	"github.com/openshift/windows-machine-config-operator/pkg/windows"
 	"github.com/openshift/windows-machine-config-operator/pkg/wiparser"
 	"github.com/openshift/windows-machine-config-operator/version"
 )
@@ -64,12 +74,19 @@ import (
 //+kubebuilder:rbac:groups="",resources=pods/eviction,verbs=create
 //+kubebuilder:rbac:groups="rbac.authorization.k8s.io",resources=rolebindings,verbs=get;create;delete
 //+kubebuilder:rbac:groups="rbac.authorization.k8s.io",resources=clusterrolebindings,verbs=get;create;delete
+//+kubebuilder:rbac:groups="apps",resources=daemonsets,verbs=get;create;update;delete
+//+kubebuilder:rbac:groups="node.k8s.io",resources=runtimeclasses,verbs=get;create;delete
 
 const (
 	// BYOHLabel is a label that should be applied to all Windows nodes not associated with a Machine.
 	BYOHLabel = "windowsmachineconfig.openshift.io/byoh"
 	// UsernameAnnotation is a node annotation that contains the username used to log into the Windows instance
 	UsernameAnnotation = "windowsmachineconfig.openshift.io/username"
+	// SSHPortAnnotation is a node annotation that contains the port used to SSH into the Windows instance
+	SSHPortAnnotation = "windowsmachineconfig.openshift.io/ssh-port"
+	// ExternallyRegisteredAnnotation is a node annotation set to true when the Node is registered out-of-band rather
+	// than by kubelet
+	ExternallyRegisteredAnnotation = "windowsmachineconfig.openshift.io/externally-registered"
 	// ConfigMapController is the name of this controller in logs and other outputs.
 	ConfigMapController = "configmap"
 	// wicdRBACResourceName is the name of the resources associated with WICD's RBAC permissions
@@ -78,11 +95,22 @@ const (
 	InjectionRequestLabel = "config.openshift.io/inject-trusted-cabundle"
 )
 
+// byohNodeLabels are the labels WMCO applies to BYOH nodes, and restores if they are removed or changed
+var byohNodeLabels = map[string]string{BYOHLabel: "true", nodeconfig.WorkerLabel: ""}
+
 // ConfigMapReconciler reconciles a ConfigMap object
 type ConfigMapReconciler struct {
 	instanceReconciler
 	servicesManifest *servicescm.Data
-	proxyEnabled     bool
+	// generateServicesManifest returns the expected services ConfigMap data for the given settings
+	generateServicesManifest func(*settings.Settings) (*servicescm.Data, error)
+	// kubeletCertDir is the kubelet certificate directory setting servicesManifest was generated with
+	kubeletCertDir string
+	// credentialProviders indicates servicesManifest was generated with credential providers given by the settings
+	credentialProviders bool
+	// logDir is the log directory setting servicesManifest was generated with
+	logDir       string
+	proxyEnabled bool
 }
 
 // NewConfigMapReconciler returns a pointer to a ConfigMapReconciler
@@ -111,8 +139,19 @@ func NewConfigMapReconciler(mgr manager.Manager, clusterConfig cluster.Config, w
 	if err != nil {
 		return nil, err
 	}
-	svcData, err := services.GenerateManifest(argsFromIgnition, clusterConfig.Network().VXLANPort(),
-		clusterConfig.Platform(), ctrl.Log.V(1).Enabled())
+	generateServicesManifest := func(s *settings.Settings) (*servicescm.Data, error) {
+		return services.GenerateManifest(argsFromIgnition, clusterConfig.Network().VXLANPort(),
+			clusterConfig.Platform(), ctrl.Log.V(1).Enabled(), s.KubeletCertDir, len(s.KubeletCredentialProviders) > 0,
+			s.LogDir)
+	}
+	// Invalid settings are reported once the settings ConfigMap is reconciled, until then kubelet's default
+	// certificate directory and the default log directory are used, without any credential providers beyond the
+	// platform's
+	s, err := settings.Get(context.TODO(), directClient, watchNamespace)
+	if err != nil {
+		s = &settings.Settings{}
+	}
+	svcData, err := generateServicesManifest(s)
 	if err != nil {
 		return nil, fmt.Errorf("error generating expected Windows service state: %w", err)
 	}
@@ -121,15 +160,19 @@ func NewConfigMapReconciler(mgr manager.Manager, clusterConfig cluster.Config, w
 		instanceReconciler: instanceReconciler{
 			client:               mgr.GetClient(),
 			k8sclientset:         clientset,
-			clusterServiceCIDR:   clusterConfig.Network().GetServiceCIDR(),
+			clusterServiceCIDRs:  clusterConfig.Network().GetServiceCIDRs(),
 			log:                  ctrl.Log.WithName("controllers").WithName(ConfigMapController),
 			watchNamespace:       watchNamespace,
 			recorder:             mgr.GetEventRecorderFor(ConfigMapController),
 			prometheusNodeConfig: pc,
 			platform:             clusterConfig.Platform(),
 		},
-		servicesManifest: svcData,
-		proxyEnabled:     proxyEnabled,
+		servicesManifest:         svcData,
+		generateServicesManifest: generateServicesManifest,
+		kubeletCertDir:           s.KubeletCertDir,
+		credentialProviders:      len(s.KubeletCredentialProviders) > 0,
+		logDir:                   s.LogDir,
+		proxyEnabled:             proxyEnabled,
 	}, nil
 }
 
@@ -164,6 +207,7 @@ func (r *ConfigMapReconciler) Reconcile(ctx context.Context,
 	// 2. windows-services, describing expected configuration of WMCO-managed services on all Windows instances
 	// 3. kube-apiserver-to-kubelet-client-ca, contains the CA for the kubelet to recognize the kube-apiserver client cert
 	// 4. trusted-ca, where CNO will publish user-provided certs when there is an active cluster-wide proxy
+	// 5. wmco-settings, holding optional user provided settings for all Windows instances
 	configMap := &core.ConfigMap{}
 	if err := r.client.Get(ctx, req.NamespacedName, configMap); err != nil {
 		if !k8sapierrors.IsNotFound(err) {
@@ -191,6 +235,10 @@ func (r *ConfigMapReconciler) Reconcile(ctx context.Context,
 		return ctrl.Result{}, r.reconcileNodes(ctx, configMap)
 	case certificates.ProxyCertsConfigMap:
 		return ctrl.Result{}, r.reconcileProxyCerts(ctx, configMap)
+	case settings.ConfigMap:
+		return ctrl.Result{}, r.reconcileSettings(ctx, configMap)
+	case certificates.KubeAPIServerServingCAConfigMapName:
+		return ctrl.Result{}, r.reconcileKubeletClientCA(ctx)
 	default:
 		// Unexpected configmap, log and return no error so we don't requeue
 		r.log.Error(fmt.Errorf("unexpected resource triggered reconcile"), "ConfigMap", req.NamespacedName)
@@ -204,6 +252,9 @@ func (r *ConfigMapReconciler) reconcileServices(ctx context.Context, windowsServ
 	if err := r.removeOutdatedServicesConfigMaps(ctx); err != nil {
 		return err
 	}
+	if err := r.reportNodeVersions(ctx); err != nil {
+		return err
+	}
 
 	// If a ConfigMap with invalid values is found, WMCO will delete and recreate it with proper values
 	data, err := servicescm.Parse(windowsServices.Data)
@@ -212,11 +263,44 @@ func (r *ConfigMapReconciler) reconcileServices(ctx context.Context, windowsServ
 		if err = r.client.Delete(ctx, windowsServices); err != nil {
 			return err
 		}
+		metrics.ServicesConfigMapRegenerations.Inc()
 		r.log.Info("Deleted invalid resource", "ConfigMap",
 			kubeTypes.NamespacedName{Namespace: r.watchNamespace, Name: windowsServices.Name}, "Error", err.Error())
 		return nil
 	}
-	// TODO: actually react to changes to the services ConfigMap
+	return nil
+}
+
+// updateParseErrorAnnotation ensures the parse error annotation of the given instance ConfigMap describes the given
+// parse error, removing the annotation if there is no error
+func (r *ConfigMapReconciler) updateParseErrorAnnotation(ctx context.Context, windowsInstances *core.ConfigMap,
+	parseErr error) error {
+	expected := ""
+	if parseErr != nil {
+		entryErr := &wiparser.ParseError{Reason: parseErr.Error()}
+		errors.As(parseErr, &entryErr)
+		detail, err := json.Marshal(entryErr)
+		if err != nil {
+			return fmt.Errorf("error marshalling parse error: %w", err)
+		}
+		expected = string(detail)
+	}
+	if windowsInstances.GetAnnotations()[wiparser.ParseErrorAnnotation] == expected {
+		return nil
+	}
+	patchBase := client.MergeFrom(windowsInstances.DeepCopy())
+	if expected == "" {
+		delete(windowsInstances.Annotations, wiparser.ParseErrorAnnotation)
+	} else {
+		if windowsInstances.Annotations == nil {
+			windowsInstances.Annotations = make(map[string]string)
+		}
+		windowsInstances.Annotations[wiparser.ParseErrorAnnotation] = expected
+	}
+	if err := r.client.Patch(ctx, windowsInstances, patchBase); err != nil {
+		return fmt.Errorf("error updating %s annotation on ConfigMap %s: %w", wiparser.ParseErrorAnnotation,
+			windowsInstances.Name, err)
+	}
 	return nil
 }
 
@@ -227,6 +311,7 @@ func (r *ConfigMapReconciler) removeOutdatedServicesConfigMaps(ctx context.Conte
 		return err
 	}
 	versionAnnotations := getVersionAnnotations(nodes.Items)
+	overrides := getServicesConfigMapOverrides(nodes.Items)
 
 	servicesConfigMaps, err := servicescm.List(r.client, ctx, r.watchNamespace)
 	if err != nil {
@@ -237,6 +322,10 @@ func (r *ConfigMapReconciler) removeOutdatedServicesConfigMaps(ctx context.Conte
 		if isTiedToRelevantVersion(cmVersion, versionAnnotations) {
 			continue
 		}
+		// A ConfigMap a node has been pointed at is kept, even if it happens to be named like a services ConfigMap
+		if _, present := overrides[cm.Name]; present {
+			continue
+		}
 		// Remove any services ConfigMap tied to a WMCO version that no Windows nodes are at anymore
 		if err := r.client.Delete(ctx, &cm); err != nil {
 			return fmt.Errorf("could not delete outdated services ConfigMap %s: %w", cm.Name, err)
@@ -247,6 +336,23 @@ func (r *ConfigMapReconciler) removeOutdatedServicesConfigMaps(ctx context.Conte
 	return nil
 }
 
+// reportNodeVersions reports the number of Windows nodes configured by each WMCO version through the
+// wmco_nodes_by_version metric, and the progress of their upgrade to the current version through the
+// NodeUpgradeProgressing condition
+func (r *ConfigMapReconciler) reportNodeVersions(ctx context.Context) error {
+	nodes := &core.NodeList{}
+	if err := r.client.List(ctx, nodes, client.MatchingLabels{core.LabelOSStable: "windows"}); err != nil {
+		return fmt.Errorf("error listing Windows nodes: %w", err)
+	}
+	counts := countNodesByVersion(nodes.Items)
+	metrics.NodesByVersion.Reset()
+	for nodeVersion, count := range counts {
+		metrics.NodesByVersion.WithLabelValues(nodeVersion).Set(float64(count))
+	}
+	return condition.SetNodeUpgradeProgress(r.client, r.watchNamespace, r.recorder, counts[version.Get()],
+		len(nodes.Items), version.Get())
+}
+
 // isTiedToRelevantVersion checks if the given version is the current WMCO version or is in the given map of versions
 func isTiedToRelevantVersion(v string, versions map[string]struct{}) bool {
 	if v == version.Get() {
@@ -269,16 +375,35 @@ func (r *ConfigMapReconciler) reconcileNodes(ctx context.Context, windowsInstanc
 	if err != nil {
 		return fmt.Errorf("error listing nodes: %w", err)
 	}
+	relabeled, err := r.restoreBYOHLabels(ctx)
+	if err != nil {
+		return err
+	}
+	nodes.Items = append(nodes.Items, relabeled...)
 
 	// Get the list of instances that are expected to be Nodes
-	instances, err := wiparser.Parse(windowsInstances.Data, nodes)
+	instances, err := wiparser.Parse(windowsInstances.Data, nodes, instance.DefaultUsername(r.platform))
+	if statusErr := r.updateParseErrorAnnotation(ctx, windowsInstances, err); statusErr != nil {
+		return statusErr
+	}
 	if err != nil {
+		r.recorder.Eventf(windowsInstances, core.EventTypeWarning, "InvalidInstanceEntry", err.Error())
 		return fmt.Errorf("unable to parse instances from ConfigMap: %w", err)
 	}
 
 	r.log.Info("processing", "instances in", wiparser.InstanceConfigMap)
 	// For each instance, ensure that it is configured into a node
 	if err := r.ensureInstancesAreUpToDate(instances); err != nil {
+		var checksumErr *windows.ChecksumMismatchErr
+		if errors.As(err, &checksumErr) {
+			r.recorder.Eventf(windowsInstances, core.EventTypeWarning, "PayloadChecksumMismatch", err.Error())
+			return err
+		}
+		var conflictErr *nodeNameConflictErr
+		if errors.As(err, &conflictErr) {
+			r.recorder.Eventf(windowsInstances, core.EventTypeWarning, "NodeNameConflict", err.Error())
+			return err
+		}
 		r.recorder.Eventf(windowsInstances, core.EventTypeWarning, "InstanceSetupFailure", err.Error())
 		return err
 	}
@@ -306,15 +431,39 @@ func (r *ConfigMapReconciler) ensureInstancesAreUpToDate(instances []*instance.I
 	windowsInstances := &core.ConfigMap{ObjectMeta: meta.ObjectMeta{Name: wiparser.InstanceConfigMap,
 		Namespace: r.watchNamespace}}
 	for _, instanceInfo := range instances {
-		// When platform type is none or Nutanix, kubelet will pick a random interface to use for the Node's IP. In that case we
-		// should override that with the IP that the user is providing via the ConfigMap.
-		instanceInfo.SetNodeIP = r.platform == config.NonePlatformType || r.platform == config.NutanixPlatformType
 		encryptedUsername, err := crypto.EncryptToJSONString(instanceInfo.Username, privateKeyBytes)
 		if err != nil {
 			return fmt.Errorf("unable to encrypt username for instance %s: %w", instanceInfo.Address, err)
 		}
-		err = r.ensureInstanceIsUpToDate(instanceInfo, map[string]string{BYOHLabel: "true", nodeconfig.WorkerLabel: ""},
-			map[string]string{UsernameAnnotation: encryptedUsername})
+		if instanceInfo.Node != nil && isExternallyManaged(instanceInfo.Node) {
+			r.log.V(1).Info("skipping externally managed node", "node", instanceInfo.Node.GetName())
+			continue
+		}
+		if instanceInfo.Node == nil {
+			if err = r.ensureUniqueNodeName(instanceInfo, instances); err != nil {
+				return err
+			}
+		}
+		sshPort := strconv.Itoa(instanceInfo.GetSSHPort())
+		if instanceInfo.UpToDate() && instanceInfo.Node.GetAnnotations()[SSHPortAnnotation] != sshPort {
+			// The port may be changed without the node needing to be reconfigured
+			if err = metadata.ApplyLabelsAndAnnotations(context.TODO(), r.client, *instanceInfo.Node, nil,
+				map[string]string{SSHPortAnnotation: sshPort}); err != nil {
+				return fmt.Errorf("error updating SSH port of node %s: %w", instanceInfo.Node.GetName(), err)
+			}
+		}
+		if instanceInfo.UpToDate() {
+			if drifted := missingLabels(instanceInfo.Node, byohNodeLabels); len(drifted) > 0 {
+				if err = metadata.ApplyLabelsAndAnnotations(context.TODO(), r.client, *instanceInfo.Node, drifted,
+					nil); err != nil {
+					return fmt.Errorf("error restoring labels of node %s: %w", instanceInfo.Node.GetName(), err)
+				}
+				r.log.Info("restored WMCO-managed labels", "node", instanceInfo.Node.GetName(), "labels", drifted)
+			}
+		}
+		configurationID, err := r.ensureInstanceIsUpToDate(instanceInfo, byohNodeLabels,
+			map[string]string{UsernameAnnotation: encryptedUsername, SSHPortAnnotation: sshPort,
+				ExternallyRegisteredAnnotation: strconv.FormatBool(instanceInfo.ExternallyRegistered)})
 		if err != nil {
 			// It is better to return early like this, instead of trying to configure as many instances as possible in a
 			// single reconcile call, as it simplifies error collection. The order the map is read from is
@@ -323,22 +472,165 @@ func (r *ConfigMapReconciler) ensureInstancesAreUpToDate(instances []*instance.I
 			return fmt.Errorf("error configuring host with address %s: %w", instanceInfo.Address, err)
 		}
 		r.recorder.Eventf(windowsInstances, core.EventTypeNormal, "InstanceSetup",
-			"Configured instance with address %s as a worker node", instanceInfo.Address)
+			"Configured instance with address %s as a worker node%s", instanceInfo.Address,
+			configurationSuffix(configurationID))
+	}
+	return nil
+}
+
+// restoreBYOHLabels applies the BYOH label to the BYOH nodes it was removed from, returning the updated nodes. Such a
+// node is otherwise no longer recognized as the node of its instance, and the instance would be configured again.
+// BYOH nodes are recognized by the SSH port annotation, which is only applied to BYOH nodes.
+func (r *ConfigMapReconciler) restoreBYOHLabels(ctx context.Context) ([]core.Node, error) {
+	nodes := &core.NodeList{}
+	if err := r.client.List(ctx, nodes, client.MatchingLabels{core.LabelOSStable: "windows"}); err != nil {
+		return nil, fmt.Errorf("error listing nodes: %w", err)
+	}
+	var relabeled []core.Node
+	for _, node := range nodes.Items {
+		if _, present := node.GetAnnotations()[SSHPortAnnotation]; !present || node.GetLabels()[BYOHLabel] == "true" ||
+			isExternallyManaged(&node) {
+			continue
+		}
+		if err := metadata.ApplyLabelsAndAnnotations(ctx, r.client, node, map[string]string{BYOHLabel: "true"},
+			nil); err != nil {
+			return nil, fmt.Errorf("error restoring %s label of node %s: %w", BYOHLabel, node.GetName(), err)
+		}
+		r.log.Info("restored BYOH label", "node", node.GetName())
+		if node.Labels == nil {
+			node.Labels = make(map[string]string)
+		}
+		node.Labels[BYOHLabel] = "true"
+		relabeled = append(relabeled, node)
+	}
+	return relabeled, nil
+}
+
+// missingLabels returns the labels of the given set which the given node does not have with the given value
+func missingLabels(node *core.Node, labels map[string]string) map[string]string {
+	missing := make(map[string]string)
+	for key, value := range labels {
+		if current, present := node.GetLabels()[key]; !present || current != value {
+			missing[key] = value
+		}
+	}
+	return missing
+}
+
+// nodeNameConflictErr describes an instance which would be joined to the cluster as the Node of another instance
+type nodeNameConflictErr struct {
+	address      string
+	otherAddress string
+	nodeName     string
+}
+
+func (e *nodeNameConflictErr) Error() string {
+	return fmt.Sprintf("instance %s has the same hostname as instance %s and would be joined to the cluster as its "+
+		"node %s, each instance must have a unique hostname", e.address, e.otherAddress, e.nodeName)
+}
+
+// ensureUniqueNodeName returns a nodeNameConflictErr if the given instance, which is not yet a node, has the same
+// hostname as the node of another of the given instances. Configuring it would cause both instances to fight over
+// the same Node object.
+func (r *ConfigMapReconciler) ensureUniqueNodeName(instanceInfo *instance.Info, instances []*instance.Info) error {
+	s, err := settings.Get(context.TODO(), r.client, r.watchNamespace)
+	if err != nil {
+		return err
+	}
+	win, err := windows.New(instanceInfo, r.signer, windows.Options{Platform: &r.platform,
+		SSHAlgorithms: nodeconfig.SSHAlgorithms(s), HostKeys: nodeconfig.SSHHostKeys(s),
+		SFTPOptions: nodeconfig.SFTPOptions(s), LogDir: s.LogDir})
+	if err != nil {
+		return fmt.Errorf("error instantiating Windows instance: %w", err)
+	}
+	defer func() {
+		if err := win.Close(); err != nil {
+			r.log.Error(err, "unable to close connection to instance")
+		}
+	}()
+	hostname, err := win.GetHostname()
+	if err != nil {
+		return err
+	}
+	nodes := &core.NodeList{}
+	if err = r.client.List(context.TODO(), nodes, client.MatchingLabels{BYOHLabel: "true"}); err != nil {
+		return fmt.Errorf("error listing nodes: %w", err)
+	}
+	return findNodeNameConflict(instanceInfo, hostname, nodes.Items, instances)
+}
+
+// findNodeNameConflict returns a nodeNameConflictErr if a node whose name starts with the given hostname of the given
+// instance is associated with another of the given instances. Node names are compared with the short hostname, as the
+// name a node registers with may or may not include the instance's domain depending on the platform.
+func findNodeNameConflict(instanceInfo *instance.Info, hostname string, nodes []core.Node,
+	instances []*instance.Info) error {
+	shortHostname, _, _ := strings.Cut(strings.ToLower(hostname), ".")
+	for _, node := range nodes {
+		if nodeHostname, _, _ := strings.Cut(node.GetName(), "."); nodeHostname != shortHostname {
+			continue
+		}
+		for _, other := range instances {
+			if other.Address == instanceInfo.Address {
+				continue
+			}
+			if hasAssociatedInstance(node.Status.Addresses, []*instance.Info{other}) {
+				return &nodeNameConflictErr{address: instanceInfo.Address, otherAddress: other.Address,
+					nodeName: node.GetName()}
+			}
+		}
 	}
 	return nil
 }
 
 // deconfigureInstances removes all BYOH nodes that are not specified in the given instances slice, and
 // deconfigures the instances associated with them. The nodes parameter should be a list of all Windows BYOH nodes.
+// Nodes hosting workloads which no remaining Windows node can run are not removed unless they have the force
+// deconfigure annotation.
 func (r *ConfigMapReconciler) deconfigureInstances(instances []*instance.Info, nodes *core.NodeList) error {
 	windowsInstances := &core.ConfigMap{ObjectMeta: meta.ObjectMeta{Name: wiparser.InstanceConfigMap,
 		Namespace: r.watchNamespace}}
+	var removals []core.Node
 	for _, node := range nodes.Items {
 		// Check for instances associated with this node
 		if hasAssociatedInstance(node.Status.Addresses, instances) {
 			continue
 		}
+		if isExternallyManaged(&node) {
+			r.log.Info("skipping deconfiguration of externally managed node", "node", node.GetName())
+			continue
+		}
+		removals = append(removals, node)
+	}
+	if len(removals) == 0 {
+		return nil
+	}
+
+	winNodes := &core.NodeList{}
+	if err := r.client.List(context.TODO(), winNodes, client.MatchingLabels{core.LabelOSStable: "windows"}); err != nil {
+		return fmt.Errorf("error listing nodes: %w", err)
+	}
+	removing := sets.NewString()
+	for _, node := range removals {
+		removing.Insert(node.GetName())
+	}
+	hasEligibleNode := false
+	for _, node := range winNodes.Items {
+		if !removing.Has(node.GetName()) && canRunWorkloads(&node) {
+			hasEligibleNode = true
+			break
+		}
+	}
 
+	for _, node := range removals {
+		if !hasEligibleNode {
+			blocked, err := r.isDeconfigurationBlocked(context.TODO(), &node, windowsInstances)
+			if err != nil {
+				return err
+			}
+			if blocked {
+				continue
+			}
+		}
 		// no instance found in the provided list, remove the node from the cluster
 		if err := r.deconfigureInstance(&node); err != nil {
 			return fmt.Errorf("unable to deconfigure instance with node %s: %w", node.GetName(), err)
@@ -349,6 +641,68 @@ func (r *ConfigMapReconciler) deconfigureInstances(instances []*instance.Info, n
 	return nil
 }
 
+// isDeconfigurationBlocked returns true if the given node, which is the only Windows node able to run workloads,
+// hosts workloads and does not have the force deconfigure annotation. A warning event is emitted on the given
+// ConfigMap whenever such workloads are found, as they are left unable to run once the node is removed.
+func (r *ConfigMapReconciler) isDeconfigurationBlocked(ctx context.Context, node *core.Node,
+	windowsInstances *core.ConfigMap) (bool, error) {
+	pods, err := r.k8sclientset.CoreV1().Pods("").List(ctx, meta.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", node.GetName()).String()})
+	if err != nil {
+		return false, fmt.Errorf("error listing pods on node %s: %w", node.GetName(), err)
+	}
+	var workloads []string
+	for _, pod := range pods.Items {
+		if isNodeWorkload(&pod) {
+			workloads = append(workloads, pod.GetNamespace()+"/"+pod.GetName())
+		}
+	}
+	if len(workloads) == 0 {
+		return false, nil
+	}
+	if node.GetAnnotations()[metadata.ForceDeconfigureAnnotation] == "true" {
+		r.recorder.Eventf(windowsInstances, core.EventTypeWarning, "StrandedWorkloads",
+			"Deconfiguring node %s as requested by the %s annotation, no other Windows node can run pods %v",
+			node.GetName(), metadata.ForceDeconfigureAnnotation, workloads)
+		return false, nil
+	}
+	r.recorder.Eventf(windowsInstances, core.EventTypeWarning, "DeconfigurationBlocked",
+		"Node %s was not deconfigured as no other Windows node can run pods %v. Add another Windows node, or set "+
+			"the %s annotation on the node to \"true\" to deconfigure it anyway", node.GetName(), workloads,
+		metadata.ForceDeconfigureAnnotation)
+	r.log.Info("blocked deconfiguration of the last Windows node hosting workloads", "node", node.GetName(),
+		"pods", workloads)
+	return true, nil
+}
+
+// canRunWorkloads returns true if the given node is ready and schedulable
+func canRunWorkloads(node *core.Node) bool {
+	if node.Spec.Unschedulable {
+		return false
+	}
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == core.NodeReady {
+			return condition.Status == core.ConditionTrue
+		}
+	}
+	return false
+}
+
+// isNodeWorkload returns true if the given pod is a running workload which would have to be rescheduled onto another
+// node if its node is removed. DaemonSet and static pods are tied to their node, so they are not considered workloads.
+func isNodeWorkload(pod *core.Pod) bool {
+	if pod.Status.Phase == core.PodSucceeded || pod.Status.Phase == core.PodFailed {
+		return false
+	}
+	if _, isMirror := pod.GetAnnotations()[core.MirrorPodAnnotationKey]; isMirror {
+		return false
+	}
+	if owner := meta.GetControllerOf(pod); owner != nil && owner.Kind == "DaemonSet" {
+		return false
+	}
+	return true
+}
+
 // hasAssociatedInstance returns true if any of the given addresses is associated with any instance in the given slice.
 // The instance's network address must be a valid IPv4 address or resolve to one.
 func hasAssociatedInstance(nodeAddresses []core.NodeAddress, instances []*instance.Info) bool {
@@ -417,11 +771,27 @@ func (r *ConfigMapReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		Complete(r)
 }
 
-// isValidConfigMap returns true if the ConfigMap object is the InstanceConfigMap or a WMCO-managed ConfigMap
+// isValidConfigMap returns true if the ConfigMap object is the InstanceConfigMap, the settings ConfigMap, a
+// WMCO-managed ConfigMap, or the ConfigMap holding the kubelet client CA
 func (r *ConfigMapReconciler) isValidConfigMap(o client.Object) bool {
+	if o.GetNamespace() == certificates.KubeApiServerOperatorNamespace {
+		return o.GetName() == certificates.KubeAPIServerServingCAConfigMapName
+	}
 	return o.GetNamespace() == r.watchNamespace &&
 		(o.GetName() == wiparser.InstanceConfigMap || o.GetName() == servicescm.Name ||
-			(r.proxyEnabled && o.GetName() == certificates.ProxyCertsConfigMap))
+			o.GetName() == settings.ConfigMap || (r.proxyEnabled && o.GetName() == certificates.ProxyCertsConfigMap))
+}
+
+// reconcileKubeletClientCA updates the kubelet client CA in all Windows nodes once the
+// kube-apiserver-to-kubelet-client-ca ConfigMap changes, without waiting for the CA to be synced to the
+// ControllerConfig
+func (r *ConfigMapReconciler) reconcileKubeletClientCA(ctx context.Context) error {
+	cc := &mcfg.ControllerConfig{}
+	err := r.client.Get(ctx, kubeTypes.NamespacedName{Name: nodeconfig.MccName}, cc)
+	if err != nil && !k8sapierrors.IsNotFound(err) {
+		return fmt.Errorf("unable to get ControllerConfig %s: %w", nodeconfig.MccName, err)
+	}
+	return r.updateKubeletCAInNodes(ctx, cc.Spec.KubeAPIServerServingCAData)
 }
 
 // createServicesConfigMap creates a valid ServicesConfigMap and returns it
@@ -478,6 +848,7 @@ func (r *ConfigMapReconciler) EnsureServicesConfigMapExists() error {
 		meta.DeleteOptions{}); err != nil {
 		return err
 	}
+	metrics.ServicesConfigMapRegenerations.Inc()
 	r.log.Info("Deleted invalid resource", "ConfigMap",
 		kubeTypes.NamespacedName{Namespace: r.watchNamespace, Name: servicescm.Name})
 	return r.createServicesConfigMapOnBootup()
@@ -503,32 +874,247 @@ func (r *ConfigMapReconciler) reconcileProxyCerts(ctx context.Context, trustedCA
 	return r.ensureTrustedCABundleInNodes(ctx)
 }
 
-// ensureTrustedCABundleInNodes copies over the trust CA bundle onto each Windows instance in the cluster
+// ensureTrustedCABundleInNodes copies over the trust CA bundle onto each Windows instance in the cluster, skipping
+// nodes annotated to opt out of the sync
 func (r *ConfigMapReconciler) ensureTrustedCABundleInNodes(ctx context.Context) error {
 	winNodes := &core.NodeList{}
 	if err := r.client.List(ctx, winNodes, client.MatchingLabels{core.LabelOSStable: "windows"}); err != nil {
 		return fmt.Errorf("error listing nodes: %w", err)
 	}
+	s, err := settings.Get(ctx, r.client, r.watchNamespace)
+	if err != nil {
+		return err
+	}
+	concurrency := settings.DefaultTrustedCABundleSyncConcurrency
+	if s.TrustedCABundleSyncConcurrency > 0 {
+		concurrency = s.TrustedCABundleSyncConcurrency
+	}
+	var nodes []core.Node
 	for _, node := range winNodes.Items {
+		if node.GetAnnotations()[metadata.SkipTrustedCABundleSyncAnnotation] == "true" || isExternallyManaged(&node) {
+			r.log.V(1).Info("skipping trusted CA bundle sync", "node", node.GetName())
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	// each sync creates its own nodeconfig, and so its own SSH connection, so concurrent syncs share no connection
+	return syncNodes(ctx, nodes, concurrency, func(node core.Node) error {
 		if err := r.ensureTrustedCABundleInNode(ctx, node); err != nil {
 			return fmt.Errorf("error ensuring trusted CA bundle is up-to-date on node %s: %w", node.Name, err)
 		}
-	}
-	return nil
+		return nil
+	})
+}
+
+// syncNodes calls the given function for each of the given nodes, with at most the given number of calls running at
+// the same time. A node which fails does not stop the others from being synced, and the errors of all failed nodes
+// are returned as an aggregate, in the order of the nodes.
+func syncNodes(ctx context.Context, nodes []core.Node, concurrency int, syncNode func(core.Node) error) error {
+	errs := make([]error, len(nodes))
+	workqueue.ParallelizeUntil(ctx, concurrency, len(nodes), func(i int) {
+		errs[i] = syncNode(nodes[i])
+	})
+	return utilerrors.NewAggregate(errs)
 }
 
 // ensureTrustedCABundleInNodes places the trusted CA bundle data into a file on the given node
 func (r *ConfigMapReconciler) ensureTrustedCABundleInNode(ctx context.Context, node core.Node) error {
-	winInstance, err := r.instanceFromNode(&node)
+	nc, err := r.nodeConfigFromNode(&node, r.signer)
+	if err != nil {
+		return err
+	}
+	defer r.closeNodeConfig(nc)
+	return nc.SyncTrustedCABundle()
+}
+
+// reconcileSettings ensures the settings given by the settings ConfigMap are applied to all configured Windows nodes.
+// Nodes which have not been configured by the current WMCO version will have the settings applied during configuration.
+func (r *ConfigMapReconciler) reconcileSettings(ctx context.Context, settingsCM *core.ConfigMap) error {
+	s, err := settings.Parse(settingsCM.Data)
 	if err != nil {
+		// No need to requeue, the ConfigMap will be reconciled again once it is changed
+		r.recorder.Eventf(settingsCM, core.EventTypeWarning, "InvalidSettings", err.Error())
+		r.log.Error(err, "invalid settings", "ConfigMap", settings.ConfigMap)
+		return nil
+	}
+	if err = r.ensureHostProcessHelper(ctx, s.HostProcessHelperImage); err != nil {
 		return err
 	}
-	nc, err := nodeconfig.NewNodeConfig(r.client, r.k8sclientset, r.clusterServiceCIDR, r.watchNamespace,
-		winInstance, r.signer, nil, nil, r.platform)
+	winNodes := &core.NodeList{}
+	if err := r.client.List(ctx, winNodes, client.MatchingLabels{core.LabelOSStable: "windows"}); err != nil {
+		return fmt.Errorf("error listing nodes: %w", err)
+	}
+	for _, node := range winNodes.Items {
+		if node.GetAnnotations()[metadata.VersionAnnotation] != version.Get() || isExternallyManaged(&node) {
+			continue
+		}
+		if err := r.ensureSettingsInNode(node); err != nil {
+			return fmt.Errorf("error applying settings on node %s: %w", node.Name, err)
+		}
+	}
+	// The services ConfigMap is regenerated once the settings are applied on the nodes, so that the log directories
+	// the services are pointed at exist
+	return r.ensureServicesManifestSettings(ctx, s)
+}
+
+// ensureServicesManifestSettings ensures the services ConfigMap reflects the settings affecting the service commands:
+// the kubelet certificate directory, whether kubelet is given credential providers, and the log directory. The
+// ConfigMap is regenerated if any has changed.
+func (r *ConfigMapReconciler) ensureServicesManifestSettings(ctx context.Context, s *settings.Settings) error {
+	credentialProviders := len(s.KubeletCredentialProviders) > 0
+	if s.KubeletCertDir == r.kubeletCertDir && credentialProviders == r.credentialProviders && s.LogDir == r.logDir {
+		return nil
+	}
+	svcData, err := r.generateServicesManifest(s)
 	if err != nil {
-		return fmt.Errorf("failed to create new nodeconfig: %w", err)
+		return fmt.Errorf("error generating expected Windows service state: %w", err)
 	}
-	return nc.SyncTrustedCABundle()
+	r.servicesManifest = svcData
+	r.kubeletCertDir = s.KubeletCertDir
+	r.credentialProviders = credentialProviders
+	r.logDir = s.LogDir
+	// Deleting the outdated ConfigMap causes it to be re-created with the new expected state
+	windowsServices := &core.ConfigMap{ObjectMeta: meta.ObjectMeta{Name: servicescm.Name,
+		Namespace: r.watchNamespace}}
+	if err = r.client.Delete(ctx, windowsServices); err != nil && !k8sapierrors.IsNotFound(err) {
+		return fmt.Errorf("error deleting outdated ConfigMap %s: %w", servicescm.Name, err)
+	}
+	r.log.Info("regenerating services ConfigMap with new settings", "kubelet certificate directory",
+		s.KubeletCertDir, "credential providers", credentialProviders, "log directory", s.LogDir)
+	return nil
+}
+
+// ensureHostProcessHelper ensures the host-process helper workload runs the given image on all Windows nodes. The
+// helper workload is removed if no image is given.
+func (r *ConfigMapReconciler) ensureHostProcessHelper(ctx context.Context, image string) error {
+	if image == "" {
+		return r.removeHostProcessHelper(ctx)
+	}
+	if err := r.ensureHostProcessRuntimeClass(ctx); err != nil {
+		return err
+	}
+	expectedDS := hostprocess.NewDaemonSet(r.watchNamespace, image)
+	existingDS, err := r.k8sclientset.AppsV1().DaemonSets(r.watchNamespace).Get(ctx, hostprocess.Name,
+		meta.GetOptions{})
+	if err != nil {
+		if !k8sapierrors.IsNotFound(err) {
+			return fmt.Errorf("unable to get DaemonSet %s/%s: %w", r.watchNamespace, hostprocess.Name, err)
+		}
+		if _, err = r.k8sclientset.AppsV1().DaemonSets(r.watchNamespace).Create(ctx, expectedDS,
+			meta.CreateOptions{}); err != nil {
+			return fmt.Errorf("unable to create DaemonSet %s/%s: %w", r.watchNamespace, hostprocess.Name, err)
+		}
+		r.log.Info("Created resource", "DaemonSet", kubeTypes.NamespacedName{Namespace: r.watchNamespace,
+			Name: hostprocess.Name}, "image", image)
+		return nil
+	}
+	containers := existingDS.Spec.Template.Spec.Containers
+	if len(containers) == 1 && containers[0].Image == image {
+		return nil
+	}
+	// the pod template is owned by WMCO, so any changes made to it are overwritten along with the image
+	existingDS.Spec.Template = expectedDS.Spec.Template
+	if _, err = r.k8sclientset.AppsV1().DaemonSets(r.watchNamespace).Update(ctx, existingDS,
+		meta.UpdateOptions{}); err != nil {
+		return fmt.Errorf("unable to update DaemonSet %s/%s: %w", r.watchNamespace, hostprocess.Name, err)
+	}
+	r.log.Info("Updated resource", "DaemonSet", kubeTypes.NamespacedName{Namespace: r.watchNamespace,
+		Name: hostprocess.Name}, "image", image)
+	return nil
+}
+
+// ensureHostProcessRuntimeClass ensures the RuntimeClass used by the host-process helper workload exists as expected.
+// Creates it if it doesn't exist, deletes and re-creates it if it exists with improper spec, as the handler and
+// scheduling of a RuntimeClass cannot be changed. A RuntimeClass of the same name created by a user is left as is.
+func (r *ConfigMapReconciler) ensureHostProcessRuntimeClass(ctx context.Context) error {
+	expectedRC := hostprocess.NewRuntimeClass()
+	existingRC, err := r.k8sclientset.NodeV1().RuntimeClasses().Get(ctx, hostprocess.RuntimeClassName,
+		meta.GetOptions{})
+	if err != nil && !k8sapierrors.IsNotFound(err) {
+		return fmt.Errorf("unable to get RuntimeClass %s: %w", hostprocess.RuntimeClassName, err)
+	}
+	if err == nil {
+		if !hostprocess.IsManaged(existingRC) {
+			r.log.V(1).Info("not managing user created resource", "RuntimeClass", existingRC.GetName())
+			return nil
+		}
+		if existingRC.Handler == expectedRC.Handler && reflect.DeepEqual(existingRC.Scheduling, expectedRC.Scheduling) {
+			return nil
+		}
+		if err = r.k8sclientset.NodeV1().RuntimeClasses().Delete(ctx, hostprocess.RuntimeClassName,
+			meta.DeleteOptions{}); err != nil {
+			return fmt.Errorf("unable to delete RuntimeClass %s: %w", hostprocess.RuntimeClassName, err)
+		}
+		r.log.Info("Deleted malformed resource", "RuntimeClass", existingRC.Name, "Handler", existingRC.Handler)
+	}
+	if _, err = r.k8sclientset.NodeV1().RuntimeClasses().Create(ctx, expectedRC, meta.CreateOptions{}); err != nil {
+		return fmt.Errorf("unable to create RuntimeClass %s: %w", hostprocess.RuntimeClassName, err)
+	}
+	r.log.Info("Created resource", "RuntimeClass", expectedRC.Name)
+	return nil
+}
+
+// removeHostProcessHelper deletes the host-process helper DaemonSet and its RuntimeClass, if they exist. A RuntimeClass
+// of the same name created by a user is not deleted.
+func (r *ConfigMapReconciler) removeHostProcessHelper(ctx context.Context) error {
+	err := r.k8sclientset.AppsV1().DaemonSets(r.watchNamespace).Delete(ctx, hostprocess.Name, meta.DeleteOptions{})
+	if err == nil {
+		r.log.Info("Deleted resource", "DaemonSet", kubeTypes.NamespacedName{Namespace: r.watchNamespace,
+			Name: hostprocess.Name})
+	} else if !k8sapierrors.IsNotFound(err) {
+		return fmt.Errorf("unable to delete DaemonSet %s/%s: %w", r.watchNamespace, hostprocess.Name, err)
+	}
+	existingRC, err := r.k8sclientset.NodeV1().RuntimeClasses().Get(ctx, hostprocess.RuntimeClassName,
+		meta.GetOptions{})
+	if err != nil {
+		if k8sapierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("unable to get RuntimeClass %s: %w", hostprocess.RuntimeClassName, err)
+	}
+	if !hostprocess.IsManaged(existingRC) {
+		return nil
+	}
+	// the UID precondition keeps a RuntimeClass re-created by a user in the meantime from being deleted
+	err = r.k8sclientset.NodeV1().RuntimeClasses().Delete(ctx, hostprocess.RuntimeClassName,
+		meta.DeleteOptions{Preconditions: &meta.Preconditions{UID: &existingRC.UID}})
+	if err == nil {
+		r.log.Info("Deleted resource", "RuntimeClass", hostprocess.RuntimeClassName)
+	} else if !k8sapierrors.IsNotFound(err) {
+		return fmt.Errorf("unable to delete RuntimeClass %s: %w", hostprocess.RuntimeClassName, err)
+	}
+	return nil
+}
+
+// ensureSettingsInNode applies the instance-level settings to the instance associated with the given node
+func (r *ConfigMapReconciler) ensureSettingsInNode(node core.Node) error {
+	nc, err := r.nodeConfigFromNode(&node, r.signer)
+	if err != nil {
+		return err
+	}
+	defer r.closeNodeConfig(nc)
+	if err = nc.EnsureLogDirectories(); err != nil {
+		return err
+	}
+	if err = nc.EnsureHostSettings(); err != nil {
+		return err
+	}
+	if err = nc.EnsureContainerdConfig(); err != nil {
+		return err
+	}
+	if err = nc.EnsureWICDRecoveryActions(); err != nil {
+		return err
+	}
+	if err = nc.EnsureWICDServiceAccount(); err != nil {
+		return err
+	}
+	if err = nc.EnsureHNSEndpointPolicies(); err != nil {
+		return err
+	}
+	if err = nc.EnsureCredentialProviders(); err != nil {
+		return err
+	}
+	return nc.EnsureKubeletConfig()
 }
 
 // ensureProxyCertsCMIsValid ensures the trusted CA ConfigMap has the expected injection request. Patches the object if not.
@@ -1,15 +1,26 @@
 package controllers
 
 import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	core "k8s.io/api/core/v1"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	"github.com/openshift/windows-machine-config-operator/pkg/certificates"
 	"github.com/openshift/windows-machine-config-operator/pkg/instance"
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig"
 	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
+	"github.com/openshift/windows-machine-config-operator/pkg/settings"
 	"github.com/openshift/windows-machine-config-operator/pkg/wiparser"
 )
 
@@ -42,6 +53,46 @@ func TestIsValidConfigMap(t *testing.T) {
 			},
 			isValidConfigMap: true,
 		},
+		{
+			name: "valid settings ConfigMap",
+			configMapObj: &core.ConfigMap{
+				ObjectMeta: meta.ObjectMeta{
+					Name:      settings.ConfigMap,
+					Namespace: watchNamespace,
+				},
+			},
+			isValidConfigMap: true,
+		},
+		{
+			name: "valid kubelet client CA ConfigMap",
+			configMapObj: &core.ConfigMap{
+				ObjectMeta: meta.ObjectMeta{
+					Name:      certificates.KubeAPIServerServingCAConfigMapName,
+					Namespace: certificates.KubeApiServerOperatorNamespace,
+				},
+			},
+			isValidConfigMap: true,
+		},
+		{
+			name: "invalid kubelet client CA ConfigMap namespace",
+			configMapObj: &core.ConfigMap{
+				ObjectMeta: meta.ObjectMeta{
+					Name:      certificates.KubeAPIServerServingCAConfigMapName,
+					Namespace: watchNamespace,
+				},
+			},
+			isValidConfigMap: false,
+		},
+		{
+			name: "other ConfigMap in the kube-apiserver operator namespace",
+			configMapObj: &core.ConfigMap{
+				ObjectMeta: meta.ObjectMeta{
+					Name:      settings.ConfigMap,
+					Namespace: certificates.KubeApiServerOperatorNamespace,
+				},
+			},
+			isValidConfigMap: false,
+		},
 		{
 			name:             "empty ConfigMap",
 			configMapObj:     &core.ConfigMap{},
@@ -150,3 +201,235 @@ func TestHasAssociatedInstance(t *testing.T) {
 		})
 	}
 }
+
+func TestFindNodeNameConflict(t *testing.T) {
+	newInstance := &instance.Info{Address: "new.example.com", IPv4Address: "10.0.0.2"}
+	configured := &instance.Info{Address: "configured.example.com", IPv4Address: "10.0.0.1"}
+	nodes := []core.Node{
+		{
+			ObjectMeta: meta.ObjectMeta{Name: "win-1"},
+			Status:     core.NodeStatus{Addresses: []core.NodeAddress{{Type: core.NodeInternalIP, Address: "10.0.0.1"}}},
+		},
+		{
+			ObjectMeta: meta.ObjectMeta{Name: "win-2.example.com"},
+			Status:     core.NodeStatus{Addresses: []core.NodeAddress{{Type: core.NodeInternalIP, Address: "10.0.0.1"}}},
+		},
+	}
+	testCases := []struct {
+		name        string
+		hostname    string
+		expectedErr bool
+	}{
+		{
+			name:        "unique hostname",
+			hostname:    "WIN-3",
+			expectedErr: false,
+		},
+		{
+			name:        "hostname of another instance's node",
+			hostname:    "WIN-1",
+			expectedErr: true,
+		},
+		{
+			name:        "short hostname of another instance's node",
+			hostname:    "win-2.other.example.com",
+			expectedErr: true,
+		},
+		{
+			name:        "hostname prefix of another instance's node",
+			hostname:    "win",
+			expectedErr: false,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			err := findNodeNameConflict(newInstance, test.hostname, nodes, []*instance.Info{newInstance, configured})
+			if !test.expectedErr {
+				require.NoError(t, err)
+				return
+			}
+			var conflictErr *nodeNameConflictErr
+			require.ErrorAs(t, err, &conflictErr)
+			require.Equal(t, configured.Address, conflictErr.otherAddress)
+		})
+	}
+}
+
+func TestCanRunWorkloads(t *testing.T) {
+	ready := []core.NodeCondition{{Type: core.NodeReady, Status: core.ConditionTrue}}
+	tests := []struct {
+		name string
+		node *core.Node
+		want bool
+	}{
+		{
+			name: "ready and schedulable",
+			node: &core.Node{Status: core.NodeStatus{Conditions: ready}},
+			want: true,
+		},
+		{
+			name: "cordoned",
+			node: &core.Node{Spec: core.NodeSpec{Unschedulable: true}, Status: core.NodeStatus{Conditions: ready}},
+			want: false,
+		},
+		{
+			name: "not ready",
+			node: &core.Node{Status: core.NodeStatus{Conditions: []core.NodeCondition{
+				{Type: core.NodeReady, Status: core.ConditionFalse}}}},
+			want: false,
+		},
+		{
+			name: "no ready condition",
+			node: &core.Node{},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, canRunWorkloads(tt.node))
+		})
+	}
+}
+
+func TestIsNodeWorkload(t *testing.T) {
+	isController := true
+	tests := []struct {
+		name string
+		pod  *core.Pod
+		want bool
+	}{
+		{
+			name: "ReplicaSet pod",
+			pod: &core.Pod{ObjectMeta: meta.ObjectMeta{OwnerReferences: []meta.OwnerReference{
+				{Kind: "ReplicaSet", Name: "web", Controller: &isController}}}},
+			want: true,
+		},
+		{
+			name: "unowned pod",
+			pod:  &core.Pod{},
+			want: true,
+		},
+		{
+			name: "DaemonSet pod",
+			pod: &core.Pod{ObjectMeta: meta.ObjectMeta{OwnerReferences: []meta.OwnerReference{
+				{Kind: "DaemonSet", Name: "agent", Controller: &isController}}}},
+			want: false,
+		},
+		{
+			name: "mirror pod",
+			pod: &core.Pod{ObjectMeta: meta.ObjectMeta{
+				Annotations: map[string]string{core.MirrorPodAnnotationKey: "hash"}}},
+			want: false,
+		},
+		{
+			name: "completed pod",
+			pod:  &core.Pod{Status: core.PodStatus{Phase: core.PodSucceeded}},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, isNodeWorkload(tt.pod))
+		})
+	}
+}
+
+func TestMissingLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   map[string]string
+	}{
+		{
+			name:   "all labels present",
+			labels: map[string]string{BYOHLabel: "true", nodeconfig.WorkerLabel: "", "user": "label"},
+			want:   map[string]string{},
+		},
+		{
+			name:   "worker label removed",
+			labels: map[string]string{BYOHLabel: "true", "user": "label"},
+			want:   map[string]string{nodeconfig.WorkerLabel: ""},
+		},
+		{
+			name:   "BYOH label changed",
+			labels: map[string]string{BYOHLabel: "false", nodeconfig.WorkerLabel: ""},
+			want:   map[string]string{BYOHLabel: "true"},
+		},
+		{
+			name:   "no labels",
+			labels: nil,
+			want:   map[string]string{BYOHLabel: "true", nodeconfig.WorkerLabel: ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &core.Node{ObjectMeta: meta.ObjectMeta{Labels: tt.labels}}
+			require.Equal(t, tt.want, missingLabels(node, byohNodeLabels))
+		})
+	}
+}
+
+func TestSyncNodes(t *testing.T) {
+	var nodes []core.Node
+	for i := 0; i < 10; i++ {
+		nodes = append(nodes, core.Node{ObjectMeta: meta.ObjectMeta{Name: fmt.Sprintf("node-%d", i)}})
+	}
+	testCases := []struct {
+		name         string
+		concurrency  int
+		failing      []string
+		expectedErrs []string
+	}{
+		{
+			name:        "all nodes synced",
+			concurrency: 3,
+		},
+		{
+			name:         "failed nodes do not stop the others",
+			concurrency:  3,
+			failing:      []string{"node-7", "node-2"},
+			expectedErrs: []string{"node-2 failed", "node-7 failed"},
+		},
+		{
+			name:         "serial sync",
+			concurrency:  1,
+			failing:      []string{"node-0"},
+			expectedErrs: []string{"node-0 failed"},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var running, maxRunning int
+			synced := sets.New[string]()
+			err := syncNodes(context.Background(), nodes, test.concurrency, func(node core.Node) error {
+				mu.Lock()
+				running++
+				maxRunning = max(maxRunning, running)
+				synced.Insert(node.GetName())
+				mu.Unlock()
+				time.Sleep(10 * time.Millisecond)
+				mu.Lock()
+				running--
+				mu.Unlock()
+				if slices.Contains(test.failing, node.GetName()) {
+					return fmt.Errorf("%s failed", node.GetName())
+				}
+				return nil
+			})
+			assert.Equal(t, len(nodes), synced.Len())
+			assert.LessOrEqual(t, maxRunning, test.concurrency)
+			if len(test.expectedErrs) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			var aggregate utilerrors.Aggregate
+			require.ErrorAs(t, err, &aggregate)
+			var errs []string
+			for _, e := range aggregate.Errors() {
+				errs = append(errs, e.Error())
+			}
+			assert.Equal(t, test.expectedErrs, errs)
+		})
+	}
+}
@@ -21,13 +21,11 @@ import (
 	"fmt"
 
 	mcfg "github.com/openshift/api/machineconfiguration/v1"
-	core "k8s.io/api/core/v1"
 	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
 	kubeTypes "k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
-	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
@@ -39,6 +37,7 @@ import (
 )
 
 //+kubebuilder:rbac:groups="machineconfiguration.openshift.io",resources=controllerconfigs,verbs=list;watch
+//+kubebuilder:rbac:groups="",resources=nodes/proxy,verbs=get
 
 const (
 	// ControllerConfigController is the name of this controller in logs and other outputs.
@@ -60,12 +59,12 @@ func NewControllerConfigReconciler(mgr manager.Manager, clusterConfig cluster.Co
 
 	return &ControllerConfigReconciler{
 		instanceReconciler: instanceReconciler{
-			client:             mgr.GetClient(),
-			log:                ctrl.Log.WithName("controllers").WithName(ControllerConfigController),
-			k8sclientset:       clientset,
-			clusterServiceCIDR: clusterConfig.Network().GetServiceCIDR(),
-			watchNamespace:     watchNamespace,
-			recorder:           mgr.GetEventRecorderFor(ControllerConfigController),
+			client:              mgr.GetClient(),
+			log:                 ctrl.Log.WithName("controllers").WithName(ControllerConfigController),
+			k8sclientset:        clientset,
+			clusterServiceCIDRs: clusterConfig.Network().GetServiceCIDRs(),
+			watchNamespace:      watchNamespace,
+			recorder:            mgr.GetEventRecorderFor(ControllerConfigController),
 		},
 	}, nil
 }
@@ -86,18 +85,7 @@ func (r *ControllerConfigReconciler) Reconcile(ctx context.Context, req ctrl.Req
 		return ctrl.Result{}, fmt.Errorf("unable to create signer from private key secret: %w", err)
 	}
 
-	// fetch all Windows nodes (Machine and BYOH instances)
-	winNodes := &core.NodeList{}
-	if err = r.client.List(ctx, winNodes, client.MatchingLabels{core.LabelOSStable: "windows"}); err != nil {
-		return ctrl.Result{}, fmt.Errorf("error listing Windows nodes: %w", err)
-	}
-	// loop Windows nodes and trigger kubelet CA update
-	for _, winNode := range winNodes.Items {
-		if err := r.updateKubeletCA(winNode, cc.Spec.KubeAPIServerServingCAData); err != nil {
-			return ctrl.Result{}, fmt.Errorf("error updating kubelet CA certificate in node %s: %w", winNode.Name, err)
-		}
-	}
-	return ctrl.Result{}, nil
+	return ctrl.Result{}, r.updateKubeletCAInNodes(ctx, cc.Spec.KubeAPIServerServingCAData)
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -2,8 +2,10 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
 	"sync"
 
 	"github.com/go-logr/logr"
@@ -45,8 +47,8 @@ type instanceReconciler struct {
 	log    logr.Logger
 	// k8sclientset holds the kube client that is needed for nodeconfig
 	k8sclientset *kubernetes.Clientset
-	// clusterServiceCIDR holds the cluster network service CIDR
-	clusterServiceCIDR string
+	// clusterServiceCIDRs holds the cluster network service CIDRs
+	clusterServiceCIDRs []string
 	// watchNamespace is the namespace that should be watched for configmaps
 	watchNamespace string
 	// signer is a signer created from the user's private key
@@ -61,10 +63,18 @@ type instanceReconciler struct {
 
 // ensureInstanceIsUpToDate ensures that the given instance is configured as a node and upgraded to the specifications
 // defined by the current version of WMCO. If labelsToApply/annotationsToApply is not nil, the node will have the
-// specified annotations and/or labels applied to it.
-func (r *instanceReconciler) ensureInstanceIsUpToDate(instanceInfo *instance.Info, labelsToApply, annotationsToApply map[string]string) error {
+// specified annotations and/or labels applied to it. The ID of the configuration performed is returned, which is empty
+// if the instance did not need to be configured.
+func (r *instanceReconciler) ensureInstanceIsUpToDate(instanceInfo *instance.Info, labelsToApply,
+	annotationsToApply map[string]string) (string, error) {
 	if instanceInfo == nil {
-		return fmt.Errorf("instance cannot be nil")
+		return "", fmt.Errorf("instance cannot be nil")
+	}
+
+	// The node controller deconfigures and configures nodes which are being forcibly reconfigured
+	if instanceInfo.Node != nil && instanceInfo.Node.GetAnnotations()[metadata.ForceReconfigureAnnotation] == "true" {
+		r.log.Info("instance is being reconfigured by request, skipping", "node", instanceInfo.Node.GetName())
+		return "", nil
 	}
 
 	// Instance is up to date, do nothing
@@ -72,14 +82,15 @@ func (r *instanceReconciler) ensureInstanceIsUpToDate(instanceInfo *instance.Inf
 		// Instance being up to date indicates that node object is present with the version annotation
 		r.log.Info("instance is up to date", "node", instanceInfo.Node.GetName(), "version",
 			instanceInfo.Node.GetAnnotations()[metadata.VersionAnnotation])
-		return nil
+		return "", nil
 	}
 
-	nc, err := nodeconfig.NewNodeConfig(r.client, r.k8sclientset, r.clusterServiceCIDR, r.watchNamespace,
+	nc, err := nodeconfig.NewNodeConfig(r.client, r.k8sclientset, r.clusterServiceCIDRs, r.watchNamespace,
 		instanceInfo, r.signer, labelsToApply, annotationsToApply, r.platform)
 	if err != nil {
-		return fmt.Errorf("failed to create new nodeconfig: %w", err)
+		return "", fmt.Errorf("failed to create new nodeconfig: %w", err)
 	}
+	defer r.closeNodeConfig(nc)
 
 	// Check if the instance was configured by a previous version of WMCO and must be deconfigured before being
 	// configured again.
@@ -88,14 +99,26 @@ func (r *instanceReconciler) ensureInstanceIsUpToDate(instanceInfo *instance.Inf
 		r.log.Info("instance requires upgrade", "node", instanceInfo.Node.GetName(), "version",
 			instanceInfo.Node.GetAnnotations()[metadata.VersionAnnotation], "expected version", version.Get())
 		if err := markNodeAsUpgrading(context.TODO(), r.client, instanceInfo.Node); err != nil {
-			return err
+			return "", err
 		}
 		if err := nc.Deconfigure(); err != nil {
-			return err
+			r.reportRefusedDrain(instanceInfo.Node, err)
+			return "", err
 		}
 	}
 
-	return nc.Configure()
+	err = nc.Configure()
+	r.reportFailedValidation(err)
+	return nc.ConfigurationID(), err
+}
+
+// configurationSuffix returns the suffix identifying the configuration with the given ID in event messages, which is
+// empty if no configuration was performed
+func configurationSuffix(configurationID string) string {
+	if configurationID == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (configuration %s)", configurationID)
 }
 
 // instanceFromNode returns an instance object for the given node. Requires a username that can be used to SSH into the
@@ -121,22 +144,75 @@ func (r *instanceReconciler) instanceFromNode(node *core.Node) (*instance.Info,
 		return nil, fmt.Errorf("unable to decrypt username annotation for node %s: %w", node.Name, err)
 	}
 
-	return instance.NewInfo(addr, username, "", false, node)
+	instanceInfo, err := instance.NewInfo(addr, username, "", node)
+	if err != nil {
+		return nil, err
+	}
+	if portAnnotation, present := node.Annotations[SSHPortAnnotation]; present {
+		if instanceInfo.SSHPort, err = strconv.Atoi(portAnnotation); err != nil {
+			return nil, fmt.Errorf("invalid SSH port annotation on node %s: %w", node.Name, err)
+		}
+	}
+	instanceInfo.ExternallyRegistered = node.Annotations[ExternallyRegisteredAnnotation] == "true"
+	return instanceInfo, nil
 }
 
-// updateKubeletCA updates the kubelet CA in the node, by copying the kubelet CA file content to the Windows instance
-func (r *instanceReconciler) updateKubeletCA(node core.Node, contents []byte) error {
-	winInstance, err := r.instanceFromNode(&node)
+// nodeConfigFromNode returns a NodeConfig for the instance of the given node, connected to it over SSH with the given
+// signer. The caller must close the NodeConfig once it is done with the instance.
+func (r *instanceReconciler) nodeConfigFromNode(node *core.Node, keySigner ssh.Signer) (*nodeconfig.NodeConfig,
+	error) {
+	instanceInfo, err := r.instanceFromNode(node)
 	if err != nil {
-		return fmt.Errorf("error creating instance for node %s: %w", node.Name, err)
+		return nil, err
 	}
-	nodeConfig, err := nodeconfig.NewNodeConfig(r.client, r.k8sclientset, r.clusterServiceCIDR,
-		r.watchNamespace, winInstance, r.signer, nil, nil, r.platform)
+	nc, err := nodeconfig.NewNodeConfig(r.client, r.k8sclientset, r.clusterServiceCIDRs, r.watchNamespace,
+		instanceInfo, keySigner, nil, nil, r.platform)
 	if err != nil {
-		return fmt.Errorf("error creating nodeConfig for instance %s: %w", winInstance.Address, err)
+		return nil, fmt.Errorf("failed to create new nodeconfig: %w", err)
+	}
+	return nc, nil
+}
+
+// closeNodeConfig closes the SSH connection of the given NodeConfig. Errors are logged rather than returned, as the
+// work done over the connection has already completed.
+func (r *instanceReconciler) closeNodeConfig(nc *nodeconfig.NodeConfig) {
+	if err := nc.Close(); err != nil {
+		r.log.Error(err, "unable to close connection to instance")
+	}
+}
+
+// updateKubeletCAInNodes updates the kubelet CA in all Windows nodes, merging the given CA data of the ControllerConfig
+// with the kube-apiserver-to-kubelet-client-ca ConfigMap
+func (r *instanceReconciler) updateKubeletCAInNodes(ctx context.Context, controllerConfigCA []byte) error {
+	contents, err := nodeconfig.KubeletClientCA(ctx, r.client, controllerConfigCA)
+	if err != nil {
+		return err
+	}
+	// fetch all Windows nodes (Machine and BYOH instances)
+	winNodes := &core.NodeList{}
+	if err = r.client.List(ctx, winNodes, client.MatchingLabels{core.LabelOSStable: "windows"}); err != nil {
+		return fmt.Errorf("error listing Windows nodes: %w", err)
+	}
+	for _, winNode := range winNodes.Items {
+		if isExternallyManaged(&winNode) {
+			continue
+		}
+		if err := r.updateKubeletCA(winNode, contents); err != nil {
+			return fmt.Errorf("error updating kubelet CA certificate in node %s: %w", winNode.Name, err)
+		}
+	}
+	return nil
+}
+
+// updateKubeletCA updates the kubelet CA in the node, by copying the kubelet CA file content to the Windows instance
+func (r *instanceReconciler) updateKubeletCA(node core.Node, contents []byte) error {
+	nc, err := r.nodeConfigFromNode(&node, r.signer)
+	if err != nil {
+		return err
 	}
+	defer r.closeNodeConfig(nc)
 	r.log.Info("updating kubelet CA client certificates in", "node", node.Name)
-	return nodeConfig.UpdateKubeletClientCA(contents)
+	return nc.UpdateKubeletClientCA(contents)
 }
 
 // GetAddress returns a non-ipv6 address that can be used to reach a Windows node. This can be either an ipv4
@@ -154,24 +230,51 @@ func GetAddress(addresses []core.NodeAddress) (string, error) {
 	return "", fmt.Errorf("no usable address")
 }
 
+// reportRefusedDrain records an event on the given node if the given error shows the node was not drained because it
+// runs drain protected pods, so that users can find which pods are blocking the node
+func (r *instanceReconciler) reportRefusedDrain(node *core.Node, err error) {
+	var protectedErr *nodeconfig.ProtectedPodsError
+	if errors.As(err, &protectedErr) {
+		r.recorder.Event(node, core.EventTypeWarning, "DrainRefused", protectedErr.Error())
+	}
+}
+
+// reportFailedValidation records an event on the node named by the given error if it shows the node failed its
+// post-configuration validation, so that users can find why the node was left cordoned
+func (r *instanceReconciler) reportFailedValidation(err error) {
+	var validationErr *nodeconfig.ValidationFailedError
+	if !errors.As(err, &validationErr) {
+		return
+	}
+	node := &core.Node{}
+	if err := r.client.Get(context.TODO(), kubeTypes.NamespacedName{Name: validationErr.Node}, node); err != nil {
+		r.log.Error(err, "unable to get node to report its failed validation", "node", validationErr.Node)
+		return
+	}
+	r.recorder.Event(node, core.EventTypeWarning, "PostConfigurationValidationFailed", validationErr.Error())
+}
+
 // deconfigureInstance deconfigures the instance associated with the given node, removing the node from the cluster.
 func (r *instanceReconciler) deconfigureInstance(node *core.Node) error {
-	instance, err := r.instanceFromNode(node)
+	nc, err := r.nodeConfigFromNode(node, r.signer)
 	if err != nil {
-		return fmt.Errorf("unable to create instance object from node: %w", err)
+		return fmt.Errorf("unable to connect to the instance of node %s: %w", node.GetName(), err)
 	}
+	defer r.closeNodeConfig(nc)
 
-	nc, err := nodeconfig.NewNodeConfig(r.client, r.k8sclientset, r.clusterServiceCIDR, r.watchNamespace,
-		instance, r.signer, nil, nil, r.platform)
-	if err != nil {
-		return fmt.Errorf("failed to create new nodeconfig: %w", err)
+	if node.GetAnnotations()[metadata.SoftDeconfigureAnnotation] == "true" {
+		r.log.Info("soft deconfiguring instance as requested", "node", node.GetName(), "annotation",
+			metadata.SoftDeconfigureAnnotation)
+		err = nc.SoftDeconfigure()
+	} else {
+		err = nc.Deconfigure()
 	}
-
-	if err = nc.Deconfigure(); err != nil {
+	if err != nil {
+		r.reportRefusedDrain(node, err)
 		return err
 	}
-	if err = r.client.Delete(context.TODO(), instance.Node); err != nil {
-		return fmt.Errorf("error deleting node %s: %w", instance.Node.GetName(), err)
+	if err = r.client.Delete(context.TODO(), node); err != nil {
+		return fmt.Errorf("error deleting node %s: %w", node.GetName(), err)
 	}
 	return nil
 }
@@ -211,12 +314,18 @@ func outdatedWindowsNodePredicate(byoh bool) predicate.Funcs {
 				e.Object.GetAnnotations()[metadata.VersionAnnotation] != version.Get()
 		},
 		UpdateFunc: func(e event.UpdateEvent) bool {
+			// the BYOH label itself may have been removed, so this is checked before the new node's labels are
+			if byoh && isWindowsNode(e.ObjectNew) && byohLabelsRemoved(e.ObjectOld, e.ObjectNew) {
+				return true
+			}
 			if !isValidWindowsNode(e.ObjectNew, byoh) {
 				return false
 			}
 			if e.ObjectNew.GetAnnotations()[metadata.VersionAnnotation] != version.Get() ||
 				e.ObjectNew.GetAnnotations()[nodeconfig.PubKeyHashAnnotation] !=
-					e.ObjectOld.GetAnnotations()[nodeconfig.PubKeyHashAnnotation] {
+					e.ObjectOld.GetAnnotations()[nodeconfig.PubKeyHashAnnotation] ||
+				e.ObjectNew.GetAnnotations()[metadata.ForceDeconfigureAnnotation] !=
+					e.ObjectOld.GetAnnotations()[metadata.ForceDeconfigureAnnotation] {
 				return true
 			}
 			return false
@@ -232,6 +341,23 @@ func outdatedWindowsNodePredicate(byoh bool) predicate.Funcs {
 
 }
 
+// byohLabelsRemoved returns true if the given update of a BYOH node removed or changed one of the labels WMCO applies
+// to BYOH nodes
+func byohLabelsRemoved(oldNode, newNode client.Object) bool {
+	if oldNode.GetLabels()[BYOHLabel] != "true" {
+		return false
+	}
+	for key, value := range byohNodeLabels {
+		if previous, present := oldNode.GetLabels()[key]; !present || previous != value {
+			continue
+		}
+		if current, present := newNode.GetLabels()[key]; !present || current != value {
+			return true
+		}
+	}
+	return false
+}
+
 // getVersionAnnotations returns a map whose keys are the WMCO versions that have configured any Windows nodes
 func getVersionAnnotations(nodes []core.Node) map[string]struct{} {
 	versions := make(map[string]struct{})
@@ -243,6 +369,36 @@ func getVersionAnnotations(nodes []core.Node) map[string]struct{} {
 	return versions
 }
 
+// isExternallyManaged returns true if the lifecycle of the given node has been taken over from WMCO through the
+// externally managed annotation. WMCO leaves such nodes and their instances as they are.
+func isExternallyManaged(node *core.Node) bool {
+	return node.GetAnnotations()[metadata.ExternallyManagedAnnotation] == "true"
+}
+
+// getServicesConfigMapOverrides returns the names of the services ConfigMaps the given nodes are pointed at by their
+// services ConfigMap override annotation
+func getServicesConfigMapOverrides(nodes []core.Node) map[string]struct{} {
+	overrides := make(map[string]struct{})
+	for _, node := range nodes {
+		if override := node.Annotations[metadata.ServicesConfigMapOverrideAnnotation]; override != "" {
+			overrides[override] = struct{}{}
+		}
+	}
+	return overrides
+}
+
+// countNodesByVersion returns the number of the given nodes configured by each WMCO version. Nodes without a version
+// annotation are not counted.
+func countNodesByVersion(nodes []core.Node) map[string]int {
+	counts := make(map[string]int)
+	for _, node := range nodes {
+		if versionAnnotation, present := node.Annotations[metadata.VersionAnnotation]; present {
+			counts[versionAnnotation]++
+		}
+	}
+	return counts
+}
+
 // isValidWindowsNode returns true if the node object has the Windows label and the BYOH
 // label present for only the BYOH nodes based on the value of the byoh boolean parameter.
 func isValidWindowsNode(o client.Object, byoh bool) bool {
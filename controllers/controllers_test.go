@@ -1,11 +1,33 @@
 package controllers
 
 import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/go-logr/logr"
+	config "github.com/openshift/api/config/v1"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
+	"github.com/openshift/windows-machine-config-operator/pkg/metrics"
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/settings"
+	"github.com/openshift/windows-machine-config-operator/pkg/windows"
+	"github.com/openshift/windows-machine-config-operator/version"
 )
 
 func TestGetAddress(t *testing.T) {
@@ -62,3 +84,639 @@ func TestGetAddress(t *testing.T) {
 		})
 	}
 }
+
+func TestExternalAddress(t *testing.T) {
+	testCases := []struct {
+		name      string
+		addresses []core.NodeAddress
+		expected  string
+	}{
+		{
+			name:      "internal addresses only",
+			addresses: []core.NodeAddress{{Type: core.NodeInternalIP, Address: "10.0.0.5"}},
+			expected:  "",
+		},
+		{
+			name: "external IP preferred over external DNS",
+			addresses: []core.NodeAddress{
+				{Type: core.NodeInternalIP, Address: "10.0.0.5"},
+				{Type: core.NodeExternalDNS, Address: "ec2-203-0-113-5.compute.amazonaws.com"},
+				{Type: core.NodeExternalIP, Address: "203.0.113.5"},
+			},
+			expected: "203.0.113.5",
+		},
+		{
+			name: "external DNS",
+			addresses: []core.NodeAddress{
+				{Type: core.NodeInternalIP, Address: "10.0.0.5"},
+				{Type: core.NodeExternalDNS, Address: "ec2-203-0-113-5.compute.amazonaws.com"},
+			},
+			expected: "ec2-203-0-113-5.compute.amazonaws.com",
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			node := &core.Node{Status: core.NodeStatus{Addresses: test.addresses}}
+			assert.Equal(t, test.expected, externalAddress(node))
+		})
+	}
+}
+
+func TestCheckTCPConnectivity(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	assert.NoError(t, checkTCPConnectivity(listener.Addr().String(), time.Second))
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedAddress := closed.Addr().String()
+	require.NoError(t, closed.Close())
+	assert.Error(t, checkTCPConnectivity(closedAddress, time.Second))
+}
+
+func TestCheckExternalConnectivityRetries(t *testing.T) {
+	namespace := "openshift-windows-machine-config-operator"
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	host, port, err := net.SplitHostPort(closed.Addr().String())
+	require.NoError(t, err)
+	require.NoError(t, closed.Close())
+	node := &core.Node{
+		ObjectMeta: meta.ObjectMeta{Name: "unreachable-node",
+			Annotations: map[string]string{metadata.VersionAnnotation: version.Get()}},
+		Status: core.NodeStatus{Addresses: []core.NodeAddress{{Type: core.NodeExternalIP, Address: host}}},
+	}
+	cm := &core.ConfigMap{ObjectMeta: meta.ObjectMeta{Name: settings.ConfigMap, Namespace: namespace},
+		Data: map[string]string{"externalConnectivityCheckPort": port, "externalConnectivityCheckRetries": "2"}}
+	recorder := record.NewFakeRecorder(1)
+	r := &nodeReconciler{
+		instanceReconciler: instanceReconciler{
+			client:         clientfake.NewClientBuilder().WithObjects(node, cm).WithStatusSubresource(node).Build(),
+			log:            logr.Discard(),
+			watchNamespace: namespace,
+			platform:       config.AWSPlatformType,
+			recorder:       recorder,
+		},
+		externalConnectivityChecked:  make(map[string]time.Time),
+		externalConnectivityFailures: make(map[string]int),
+	}
+
+	// the first failed attempt is retried in a later reconcile rather than reported
+	assert.Equal(t, externalConnectivityRetryInterval, r.checkExternalConnectivity(context.TODO(), node))
+	assert.Empty(t, recorder.Events)
+	assert.NotContains(t, r.externalConnectivityChecked, node.GetName())
+
+	assert.Equal(t, time.Duration(0), r.checkExternalConnectivity(context.TODO(), node))
+	require.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, "no connection after 2 attempts")
+	assert.Contains(t, r.externalConnectivityChecked, node.GetName())
+	assert.NotContains(t, r.externalConnectivityFailures, node.GetName())
+	// the check is not due again until externalConnectivityInterval has passed
+	assert.Equal(t, time.Duration(0), r.checkExternalConnectivity(context.TODO(), node))
+	assert.Empty(t, recorder.Events)
+}
+
+func TestSoonestRequeue(t *testing.T) {
+	assert.Equal(t, time.Duration(0), soonestRequeue(0, 0))
+	assert.Equal(t, time.Minute, soonestRequeue(time.Minute, 0))
+	assert.Equal(t, time.Minute, soonestRequeue(0, time.Minute))
+	assert.Equal(t, time.Second, soonestRequeue(time.Minute, time.Second))
+	assert.Equal(t, time.Second, soonestRequeue(time.Second, time.Minute))
+}
+
+func TestScrapeWindowsExporter(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metrics":
+			fmt.Fprintln(w, "# HELP windows_cpu_time_total Time that processor spent in different modes")
+			fmt.Fprintln(w, "windows_cpu_time_total{core=\"0,0\",mode=\"idle\"} 1234.5")
+			fmt.Fprintln(w, "# TYPE "+windows.ServiceTerminationsMetric+" gauge")
+			fmt.Fprintln(w, windows.ServiceTerminationsMetric+"{service=\"kubelet\"} 3")
+			fmt.Fprintln(w, windows.ServiceTerminationsMetric+"{service=\"containerd\"} 0")
+			fmt.Fprintln(w, "# TYPE "+windows.HNSSubnetSizeMetric+" gauge")
+			fmt.Fprintln(w, windows.HNSSubnetSizeMetric+"{subnet=\"10.132.1.0/24\"} 253")
+			fmt.Fprintln(w, "# TYPE "+windows.HNSSubnetAddressesUsedMetric+" gauge")
+			fmt.Fprintln(w, windows.HNSSubnetAddressesUsedMetric+"{subnet=\"10.132.1.0/24\"} 20")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	other := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "go_goroutines 10")
+	}))
+	defer other.Close()
+	// an instance without the hybrid overlay HNS network serves no HNS subnet metrics
+	noNetwork := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "windows_cpu_time_total{core=\"0,0\",mode=\"idle\"} 1234.5")
+	}))
+	defer noNetwork.Close()
+	servingCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	// httptest servers share a certificate, so the certificate a server should serve is replaced instead
+	otherCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("other certificate")})
+
+	testCases := []struct {
+		name                 string
+		target               string
+		servingCert          []byte
+		expectedTerminations map[string]float64
+		expectedHNSIPUsage   *windows.HNSNetworkIPUsage
+		expectedErr          string
+	}{
+		{
+			name:                 "scrapeable",
+			target:               server.Listener.Addr().String(),
+			servingCert:          servingCert,
+			expectedTerminations: map[string]float64{"kubelet": 3, "containerd": 0},
+			expectedHNSIPUsage:   &windows.HNSNetworkIPUsage{Subnet: "10.132.1.0/24", Size: 253, Used: 20},
+		},
+		{
+			name:                 "no HNS network",
+			target:               noNetwork.Listener.Addr().String(),
+			servingCert:          servingCert,
+			expectedTerminations: map[string]float64{},
+		},
+		{
+			name:        "different certificate served",
+			target:      server.Listener.Addr().String(),
+			servingCert: otherCert,
+			expectedErr: "served certificate does not match",
+		},
+		{
+			name:        "no windows_exporter metrics",
+			target:      other.Listener.Addr().String(),
+			servingCert: servingCert,
+			expectedErr: "response has no windows_ metrics",
+		},
+		{
+			name:        "invalid serving certificate",
+			target:      server.Listener.Addr().String(),
+			servingCert: []byte("not a certificate"),
+			expectedErr: "no PEM encoded certificate",
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			scraped, err := scrapeWindowsExporter(test.target, test.servingCert, time.Second)
+			if test.expectedErr == "" {
+				require.NoError(t, err)
+				assert.Equal(t, test.expectedTerminations, scraped.serviceTerminations)
+				assert.Equal(t, test.expectedHNSIPUsage, scraped.hnsIPUsage)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.expectedErr)
+		})
+	}
+}
+
+func TestIsManagedNode(t *testing.T) {
+	windowsLabels := map[string]string{core.LabelOSStable: "windows", "team": "a"}
+	testCases := []struct {
+		name     string
+		selector string
+		obj      runtime.Object
+		expected bool
+	}{
+		{
+			name:     "all Windows nodes managed",
+			selector: "",
+			obj:      &core.Node{ObjectMeta: meta.ObjectMeta{Labels: windowsLabels}},
+			expected: true,
+		},
+		{
+			name:     "Windows node matching selector",
+			selector: "team=a",
+			obj:      &core.Node{ObjectMeta: meta.ObjectMeta{Labels: windowsLabels}},
+			expected: true,
+		},
+		{
+			name:     "Windows node not matching selector",
+			selector: "team in (b,c)",
+			obj:      &core.Node{ObjectMeta: meta.ObjectMeta{Labels: windowsLabels}},
+			expected: false,
+		},
+		{
+			name:     "configured Windows node not matching selector",
+			selector: "team in (b,c)",
+			obj: &core.Node{ObjectMeta: meta.ObjectMeta{Labels: windowsLabels,
+				Annotations: map[string]string{metadata.VersionAnnotation: "10.18.0"}}},
+			expected: true,
+		},
+		{
+			name:     "Windows node given a WICD token not matching selector",
+			selector: "team in (b,c)",
+			obj: &core.Node{ObjectMeta: meta.ObjectMeta{Labels: windowsLabels,
+				Annotations: map[string]string{metadata.WICDTokenAnnotation: "windows-instance-config-daemon-token"}}},
+			expected: true,
+		},
+		{
+			name:     "Linux node matching selector",
+			selector: "team=a",
+			obj: &core.Node{ObjectMeta: meta.ObjectMeta{
+				Labels: map[string]string{core.LabelOSStable: "linux", "team": "a"}}},
+			expected: false,
+		},
+		{
+			name:     "not a node",
+			selector: "",
+			obj:      &core.Pod{ObjectMeta: meta.ObjectMeta{Labels: windowsLabels}},
+			expected: false,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			selector, err := labels.Parse(test.selector)
+			require.NoError(t, err)
+			r := &nodeReconciler{nodeSelector: selector}
+			assert.Equal(t, test.expected, r.isManagedNode(test.obj))
+		})
+	}
+}
+
+func TestHNSSubnetNearlyExhausted(t *testing.T) {
+	testCases := []struct {
+		name     string
+		usage    windows.HNSNetworkIPUsage
+		expected bool
+	}{
+		{
+			name:     "no addresses in use",
+			usage:    windows.HNSNetworkIPUsage{Subnet: "10.132.1.0/24", Size: 253, Used: 0},
+			expected: false,
+		},
+		{
+			name:     "below threshold",
+			usage:    windows.HNSNetworkIPUsage{Subnet: "10.132.1.0/24", Size: 253, Used: 227},
+			expected: false,
+		},
+		{
+			name:     "above threshold",
+			usage:    windows.HNSNetworkIPUsage{Subnet: "10.132.1.0/28", Size: 13, Used: 12},
+			expected: true,
+		},
+		{
+			name:     "exhausted",
+			usage:    windows.HNSNetworkIPUsage{Subnet: "10.132.1.0/24", Size: 253, Used: 253},
+			expected: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, hnsSubnetNearlyExhausted(&test.usage))
+		})
+	}
+}
+
+// gaugeValue returns the current value of the given gauge
+func gaugeValue(t *testing.T, gauge prometheus.Gauge) float64 {
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(gauge))
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 1)
+	return families[0].GetMetric()[0].GetGauge().GetValue()
+}
+
+func TestSetServiceRestartMetrics(t *testing.T) {
+	node := &core.Node{ObjectMeta: meta.ObjectMeta{Name: "restarts-node"}}
+	t.Cleanup(func() {
+		metrics.ServiceRestarts.DeletePartialMatch(prometheus.Labels{"node": node.GetName()})
+	})
+	metrics.ServiceRestarts.WithLabelValues(node.GetName(), "containerd").Set(1)
+
+	setServiceRestartMetrics(node, map[string]float64{"kubelet": 3})
+	assert.Equal(t, float64(3), gaugeValue(t, metrics.ServiceRestarts.WithLabelValues(node.GetName(), "kubelet")))
+	// the count of containerd was not served, so its previous value is kept
+	assert.Equal(t, float64(1), gaugeValue(t, metrics.ServiceRestarts.WithLabelValues(node.GetName(), "containerd")))
+}
+
+func TestSetHNSIPUsageMetrics(t *testing.T) {
+	testCases := []struct {
+		name          string
+		usage         *windows.HNSNetworkIPUsage
+		expectedSize  float64
+		expectedUsed  float64
+		expectedEvent string
+	}{
+		{
+			name:         "addresses available",
+			usage:        &windows.HNSNetworkIPUsage{Subnet: "10.132.1.0/24", Size: 253, Used: 20},
+			expectedSize: 253,
+			expectedUsed: 20,
+		},
+		{
+			name:         "nearly exhausted",
+			usage:        &windows.HNSNetworkIPUsage{Subnet: "10.132.1.0/28", Size: 13, Used: 12},
+			expectedSize: 13,
+			expectedUsed: 12,
+			expectedEvent: "Warning HNSSubnetNearlyExhausted 12 of the 13 assignable addresses of HNS subnet " +
+				"10.132.1.0/28 are in use, new pods may fail to get an IP",
+		},
+		{
+			name:         "usage unavailable",
+			expectedSize: 1,
+			expectedUsed: 1,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			node := &core.Node{ObjectMeta: meta.ObjectMeta{Name: "hns-node"}}
+			t.Cleanup(func() {
+				metrics.HNSSubnetSize.DeleteLabelValues(node.GetName())
+				metrics.HNSSubnetAddressesUsed.DeleteLabelValues(node.GetName())
+			})
+			metrics.HNSSubnetSize.WithLabelValues(node.GetName()).Set(1)
+			metrics.HNSSubnetAddressesUsed.WithLabelValues(node.GetName()).Set(1)
+			recorder := record.NewFakeRecorder(1)
+			r := &nodeReconciler{instanceReconciler: instanceReconciler{recorder: recorder}}
+
+			r.setHNSIPUsageMetrics(node, test.usage)
+			assert.Equal(t, test.expectedSize, gaugeValue(t, metrics.HNSSubnetSize.WithLabelValues(node.GetName())))
+			assert.Equal(t, test.expectedUsed,
+				gaugeValue(t, metrics.HNSSubnetAddressesUsed.WithLabelValues(node.GetName())))
+			if test.expectedEvent == "" {
+				assert.Empty(t, recorder.Events)
+				return
+			}
+			require.Len(t, recorder.Events, 1)
+			assert.Equal(t, test.expectedEvent, <-recorder.Events)
+		})
+	}
+}
+
+func TestBYOHLabelsRemoved(t *testing.T) {
+	byohLabels := map[string]string{BYOHLabel: "true", nodeconfig.WorkerLabel: "", "user": "label"}
+	tests := []struct {
+		name      string
+		oldLabels map[string]string
+		newLabels map[string]string
+		want      bool
+	}{
+		{
+			name:      "labels unchanged",
+			oldLabels: byohLabels,
+			newLabels: byohLabels,
+			want:      false,
+		},
+		{
+			name:      "user label removed",
+			oldLabels: byohLabels,
+			newLabels: map[string]string{BYOHLabel: "true", nodeconfig.WorkerLabel: ""},
+			want:      false,
+		},
+		{
+			name:      "worker label removed",
+			oldLabels: byohLabels,
+			newLabels: map[string]string{BYOHLabel: "true", "user": "label"},
+			want:      true,
+		},
+		{
+			name:      "BYOH label removed",
+			oldLabels: byohLabels,
+			newLabels: map[string]string{nodeconfig.WorkerLabel: "", "user": "label"},
+			want:      true,
+		},
+		{
+			name:      "worker label previously absent",
+			oldLabels: map[string]string{BYOHLabel: "true"},
+			newLabels: map[string]string{BYOHLabel: "true"},
+			want:      false,
+		},
+		{
+			name:      "not a BYOH node",
+			oldLabels: map[string]string{nodeconfig.WorkerLabel: ""},
+			newLabels: map[string]string{},
+			want:      false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldNode := &core.Node{ObjectMeta: meta.ObjectMeta{Labels: tt.oldLabels}}
+			newNode := &core.Node{ObjectMeta: meta.ObjectMeta{Labels: tt.newLabels}}
+			assert.Equal(t, tt.want, byohLabelsRemoved(oldNode, newNode))
+		})
+	}
+}
+
+func TestCountNodesByVersion(t *testing.T) {
+	newVersionedNode := func(name, version string) core.Node {
+		node := core.Node{ObjectMeta: meta.ObjectMeta{Name: name, Annotations: map[string]string{}}}
+		if version != "" {
+			node.Annotations[metadata.VersionAnnotation] = version
+		}
+		return node
+	}
+	testCases := []struct {
+		name     string
+		nodes    []core.Node
+		expected map[string]int
+	}{
+		{
+			name:     "no nodes",
+			nodes:    nil,
+			expected: map[string]int{},
+		},
+		{
+			name:     "all nodes at one version",
+			nodes:    []core.Node{newVersionedNode("a", "10.0.0"), newVersionedNode("b", "10.0.0")},
+			expected: map[string]int{"10.0.0": 2},
+		},
+		{
+			name: "nodes upgrading with one being configured",
+			nodes: []core.Node{newVersionedNode("a", "10.0.0"), newVersionedNode("b", "9.0.0"),
+				newVersionedNode("c", "9.0.0"), newVersionedNode("d", "")},
+			expected: map[string]int{"10.0.0": 1, "9.0.0": 2},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, countNodesByVersion(test.nodes))
+		})
+	}
+}
+
+func TestGetServicesConfigMapOverrides(t *testing.T) {
+	newNode := func(name, override string) core.Node {
+		return core.Node{ObjectMeta: meta.ObjectMeta{Name: name,
+			Annotations: map[string]string{metadata.ServicesConfigMapOverrideAnnotation: override}}}
+	}
+	testCases := []struct {
+		name     string
+		nodes    []core.Node
+		expected map[string]struct{}
+	}{
+		{
+			name:     "no overrides",
+			nodes:    []core.Node{{ObjectMeta: meta.ObjectMeta{Name: "a"}}, newNode("b", "")},
+			expected: map[string]struct{}{},
+		},
+		{
+			name: "nodes sharing an override",
+			nodes: []core.Node{newNode("a", "windows-services-canary"), newNode("b", "windows-services-canary"),
+				newNode("c", "other")},
+			expected: map[string]struct{}{"windows-services-canary": {}, "other": {}},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, getServicesConfigMapOverrides(test.nodes))
+		})
+	}
+}
+
+func TestChangedServiceNetwork(t *testing.T) {
+	current := []string{"172.30.0.0/16"}
+	testCases := []struct {
+		name           string
+		serviceNetwork []string
+		expected       []string
+		expectedErr    bool
+	}{
+		{
+			name:           "unchanged",
+			serviceNetwork: []string{"172.30.0.0/16"},
+			expected:       nil,
+		},
+		{
+			name:           "changed",
+			serviceNetwork: []string{"172.31.0.0/16"},
+			expected:       []string{"172.31.0.0/16"},
+		},
+		{
+			name:           "IP family added",
+			serviceNetwork: []string{"172.30.0.0/16", "fd02::/112"},
+			expected:       []string{"172.30.0.0/16", "fd02::/112"},
+		},
+		{
+			name:           "invalid CIDR",
+			serviceNetwork: []string{"172.31.0.0"},
+			expectedErr:    true,
+		},
+		{
+			name:           "no service network",
+			serviceNetwork: nil,
+			expectedErr:    true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			network := &config.Network{Spec: config.NetworkSpec{ServiceNetwork: test.serviceNetwork}}
+			out, err := changedServiceNetwork(network, current)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, out)
+		})
+	}
+}
+
+func TestReportRefusedDrain(t *testing.T) {
+	node := &core.Node{ObjectMeta: meta.ObjectMeta{Name: "winnode"}}
+	protectedErr := &nodeconfig.ProtectedPodsError{Node: "winnode", Pods: []string{"ops/agent"}}
+	testCases := []struct {
+		name          string
+		err           error
+		expectedEvent string
+	}{
+		{
+			name:          "drain refused",
+			err:           fmt.Errorf("full instance reboot failed: %w", protectedErr),
+			expectedEvent: "Warning DrainRefused " + protectedErr.Error(),
+		},
+		{
+			name: "other error",
+			err:  fmt.Errorf("unable to drain node winnode"),
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(1)
+			r := instanceReconciler{recorder: recorder}
+			r.reportRefusedDrain(node, test.err)
+			if test.expectedEvent == "" {
+				assert.Empty(t, recorder.Events)
+				return
+			}
+			require.Len(t, recorder.Events, 1)
+			assert.Equal(t, test.expectedEvent, <-recorder.Events)
+		})
+	}
+}
+
+func TestReportFailedValidation(t *testing.T) {
+	node := &core.Node{ObjectMeta: meta.ObjectMeta{Name: "winnode"}}
+	validationErr := &nodeconfig.ValidationFailedError{Node: "winnode", Output: "firewall is disabled",
+		Err: fmt.Errorf("exit status 1")}
+	testCases := []struct {
+		name          string
+		err           error
+		expectedEvent string
+	}{
+		{
+			name:          "validation failed",
+			err:           fmt.Errorf("configuration 1234 failed: %w", validationErr),
+			expectedEvent: "Warning PostConfigurationValidationFailed " + validationErr.Error(),
+		},
+		{
+			name: "node of failed validation removed",
+			err:  &nodeconfig.ValidationFailedError{Node: "removed", Err: fmt.Errorf("exit status 1")},
+		},
+		{
+			name: "other error",
+			err:  fmt.Errorf("bootstrapping the Windows instance failed"),
+		},
+		{
+			name: "no error",
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(1)
+			r := instanceReconciler{client: clientfake.NewClientBuilder().WithObjects(node).Build(),
+				recorder: recorder}
+			r.reportFailedValidation(test.err)
+			if test.expectedEvent == "" {
+				assert.Empty(t, recorder.Events)
+				return
+			}
+			require.Len(t, recorder.Events, 1)
+			assert.Equal(t, test.expectedEvent, <-recorder.Events)
+		})
+	}
+}
+
+func TestInstanceConnection(t *testing.T) {
+	node := &core.Node{ObjectMeta: meta.ObjectMeta{Name: "conn-node"}}
+	// without the private key secret the instance cannot be connected to
+	r := &nodeReconciler{instanceReconciler: instanceReconciler{client: clientfake.NewClientBuilder().Build(),
+		watchNamespace: "openshift-windows-machine-config-operator"}}
+	conn := &instanceConnection{r: r, node: node}
+	_, err := conn.get()
+	require.Error(t, err)
+	_, again := conn.get()
+	assert.Equal(t, err, again)
+	// nothing was connected to, so there is nothing to close
+	conn.close()
+}
+
+func TestRecoverStalledUpgradeInvalidSettings(t *testing.T) {
+	namespace := "openshift-windows-machine-config-operator"
+	node := &core.Node{ObjectMeta: meta.ObjectMeta{Name: "stalled-node", Annotations: map[string]string{
+		metadata.VersionAnnotation:                 "previous",
+		metadata.DesiredVersionAnnotation:          version.Get(),
+		metadata.DesiredVersionTimestampAnnotation: time.Now().Add(-10 * time.Minute).Format(time.RFC3339),
+	}}}
+	cm := &core.ConfigMap{ObjectMeta: meta.ObjectMeta{Name: settings.ConfigMap, Namespace: namespace},
+		Data: map[string]string{"upgradeStallTimeout": "forever"}}
+	r := &nodeReconciler{instanceReconciler: instanceReconciler{
+		client: clientfake.NewClientBuilder().WithObjects(node, cm).Build(), watchNamespace: namespace}}
+
+	// the default timeout is used, which has not elapsed
+	requeueAfter, err := r.recoverStalledUpgrade(context.TODO(), &instanceConnection{r: r, node: node})
+	require.NoError(t, err)
+	assert.InDelta(t, (defaultUpgradeStallTimeout - 10*time.Minute).Seconds(), requeueAfter.Seconds(), 5)
+}
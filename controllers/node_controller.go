@@ -17,40 +17,133 @@ limitations under the License.
 package controllers
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	config "github.com/openshift/api/config/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
 	core "k8s.io/api/core/v1"
 	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"github.com/openshift/windows-machine-config-operator/pkg/cluster"
 	"github.com/openshift/windows-machine-config-operator/pkg/condition"
 	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
+	"github.com/openshift/windows-machine-config-operator/pkg/metrics"
 	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig/payload"
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeutil"
 	"github.com/openshift/windows-machine-config-operator/pkg/secrets"
+	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
+	"github.com/openshift/windows-machine-config-operator/pkg/settings"
 	"github.com/openshift/windows-machine-config-operator/pkg/signer"
+	"github.com/openshift/windows-machine-config-operator/pkg/windows"
+	"github.com/openshift/windows-machine-config-operator/version"
 )
 
+//+kubebuilder:rbac:groups="",resources=nodes/status,verbs=patch
+
 const (
 	// NodeController is the name of this controller in logs and other outputs.
 	NodeController = "node"
+	// externalConnectivityInterval is the minimum time between external connectivity checks of a node
+	externalConnectivityInterval = 5 * time.Minute
+	// externalConnectivityRetryInterval is how long after a failed connection attempt to a node it is attempted again
+	externalConnectivityRetryInterval = 2 * time.Second
+	// defaultExternalConnectivityCheckRetries is the number of connection attempts made to a node, if not configured
+	defaultExternalConnectivityCheckRetries = 3
+	// defaultExternalConnectivityCheckTimeout is how long each connection attempt to a node waits, if not configured
+	defaultExternalConnectivityCheckTimeout = 10 * time.Second
+	// processDumpDir is the directory of the operator's container that collected process dumps are written to
+	processDumpDir = "/tmp/process-dumps"
+	// defaultUpgradeStallTimeout is how long the version of a node can differ from its desired version before WICD is
+	// restarted on it, if not configured. This is longer than the default time WMCO waits for WICD to configure a node.
+	defaultUpgradeStallTimeout = 30 * time.Minute
+	// hnsSubnetExhaustionThreshold is the fraction of the addresses of a node's HNS subnet which can be in use before
+	// the node is reported as nearly out of pod IPs
+	hnsSubnetExhaustionThreshold = 0.9
+	// tempFileCleanupInterval is the minimum time between removals of the stale temporary files of a node, when they
+	// are removed periodically
+	tempFileCleanupInterval = time.Hour
+	// containerdConfigCheckInterval is the minimum time between checks of the containerd config of a node for manual
+	// edits
+	containerdConfigCheckInterval = 10 * time.Minute
+	// timezoneCheckInterval is the minimum time between checks of the timezone of a node for changes made on the
+	// instance
+	timezoneCheckInterval = 10 * time.Minute
+	// credentialFileACLCheckInterval is the minimum time between checks of the ACLs of the credential files of a node,
+	// which can be loosened on the instance, and are not set for the key kubelet writes when rotating its certificate
+	credentialFileACLCheckInterval = 10 * time.Minute
+	// kubeletFlagsCheckInterval is the minimum time between checks of the kubelet service of a node for flags which
+	// have drifted from its services ConfigMap
+	kubeletFlagsCheckInterval = 10 * time.Minute
+	// windowsExporterScrapeInterval is the minimum time between scrapes of the windows_exporter metrics of a node
+	windowsExporterScrapeInterval = 10 * time.Minute
+	// windowsExporterScrapeTimeout is how long a scrape of the windows_exporter metrics of a node can take
+	windowsExporterScrapeTimeout = 30 * time.Second
+	// windowsExporterMetricPrefix is the prefix of the names of the metrics served by windows_exporter
+	windowsExporterMetricPrefix = "windows_"
 )
 
 // nodeReconciler holds the info required to reconcile a Node object, inclduing that of the underlying Windows instance
 type nodeReconciler struct {
 	instanceReconciler
+	// externalConnectivityChecked holds the time the external connectivity of each node was last checked, by node name
+	externalConnectivityChecked map[string]time.Time
+	// externalConnectivityFailures holds the number of failed connection attempts of the external connectivity check
+	// in progress on each node, by node name
+	externalConnectivityFailures map[string]int
+	// wicdKubeconfigServerChecked holds the names of the nodes whose WICD kubeconfig is known to point at the current
+	// API server endpoint. The endpoint is only discovered when the operator starts, so each node is checked once.
+	wicdKubeconfigServerChecked map[string]bool
+	// cniConfigChecked holds the names of the nodes whose CNI config is known to match the current cluster network.
+	// Like the API server endpoint, the cluster network is only read when the operator starts.
+	cniConfigChecked map[string]bool
+	// containerdConfigChecked holds the time the containerd config of each node was last checked, by node name
+	containerdConfigChecked map[string]time.Time
+	// timezoneChecked holds the time the timezone of each node was last checked, by node name
+	timezoneChecked map[string]time.Time
+	// credentialFileACLsChecked holds the time the ACLs of the credential files of each node were last checked, by
+	// node name
+	credentialFileACLsChecked map[string]time.Time
+	// kubeletFlagsChecked holds the time the kubelet flags of each node were last checked, by node name
+	kubeletFlagsChecked map[string]time.Time
+	// windowsExporterScraped holds the time the windows_exporter metrics of each node were last scraped, by node name
+	windowsExporterScraped map[string]time.Time
+	// tempFilesCleaned holds the time the stale temporary files of each node were last removed, by node name
+	tempFilesCleaned map[string]time.Time
+	// nodeSelector selects the Windows nodes the reconciler acts on, other Windows nodes are ignored
+	nodeSelector labels.Selector
 }
 
-// NewNodeReconciler returns a pointer to a new nodeReconciler
-func NewNodeReconciler(mgr manager.Manager, clusterConfig cluster.Config, watchNamespace string) (*nodeReconciler, error) {
+// NewNodeReconciler returns a pointer to a new nodeReconciler, which acts on the Windows nodes matching the given
+// label selector
+func NewNodeReconciler(mgr manager.Manager, clusterConfig cluster.Config, watchNamespace string,
+	nodeSelector labels.Selector) (*nodeReconciler, error) {
 	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
 	if err != nil {
 		return nil, fmt.Errorf("error creating kubernetes clientset: %w", err)
@@ -58,13 +151,25 @@ func NewNodeReconciler(mgr manager.Manager, clusterConfig cluster.Config, watchN
 
 	return &nodeReconciler{
 		instanceReconciler: instanceReconciler{
-			client:             mgr.GetClient(),
-			log:                ctrl.Log.WithName("controllers").WithName(NodeController),
-			k8sclientset:       clientset,
-			clusterServiceCIDR: clusterConfig.Network().GetServiceCIDR(),
-			watchNamespace:     watchNamespace,
-			recorder:           mgr.GetEventRecorderFor(NodeController),
+			client:              mgr.GetClient(),
+			log:                 ctrl.Log.WithName("controllers").WithName(NodeController),
+			k8sclientset:        clientset,
+			clusterServiceCIDRs: clusterConfig.Network().GetServiceCIDRs(),
+			watchNamespace:      watchNamespace,
+			platform:            clusterConfig.Platform(),
+			recorder:            mgr.GetEventRecorderFor(NodeController),
 		},
+		externalConnectivityChecked:  make(map[string]time.Time),
+		externalConnectivityFailures: make(map[string]int),
+		wicdKubeconfigServerChecked:  make(map[string]bool),
+		cniConfigChecked:             make(map[string]bool),
+		containerdConfigChecked:      make(map[string]time.Time),
+		timezoneChecked:              make(map[string]time.Time),
+		credentialFileACLsChecked:    make(map[string]time.Time),
+		kubeletFlagsChecked:          make(map[string]time.Time),
+		windowsExporterScraped:       make(map[string]time.Time),
+		tempFilesCleaned:             make(map[string]time.Time),
+		nodeSelector:                 nodeSelector,
 	}, nil
 }
 
@@ -87,47 +192,849 @@ func (r *nodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (resul
 			// Request object not found, could have been deleted after reconcile request.
 			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
 			// Return and don't requeue
+			metrics.ServiceRestarts.DeletePartialMatch(prometheus.Labels{"node": req.Name})
+			delete(r.externalConnectivityChecked, req.Name)
+			delete(r.externalConnectivityFailures, req.Name)
+			delete(r.wicdKubeconfigServerChecked, req.Name)
+			delete(r.cniConfigChecked, req.Name)
+			delete(r.containerdConfigChecked, req.Name)
+			delete(r.timezoneChecked, req.Name)
+			delete(r.credentialFileACLsChecked, req.Name)
+			delete(r.kubeletFlagsChecked, req.Name)
+			delete(r.windowsExporterScraped, req.Name)
+			delete(r.tempFilesCleaned, req.Name)
+			metrics.HNSSubnetSize.DeleteLabelValues(req.Name)
+			metrics.HNSSubnetAddressesUsed.DeleteLabelValues(req.Name)
 			return ctrl.Result{}, nil
 		}
 		// Error reading the object - return error to requeue the request.
 		return ctrl.Result{}, err
 	}
 
+	if isExternallyManaged(node) {
+		r.log.V(1).Info("skipping externally managed node", "node", node.GetName())
+		return ctrl.Result{}, nil
+	}
+	// the instance is connected to at most once per reconcile, and only if an operation needs it
+	conn := &instanceConnection{r: r, node: node}
+	defer conn.close()
 	if _, ok := node.GetAnnotations()[metadata.RebootAnnotation]; ok {
-		// Create a new signer using the private key that the instances will be reconciled with
-		signer, err := signer.Create(types.NamespacedName{Namespace: r.watchNamespace,
-			Name: secrets.PrivateKeySecret}, r.client)
+		s, err := settings.Get(ctx, r.client, r.watchNamespace)
 		if err != nil {
-			return ctrl.Result{}, fmt.Errorf("unable to create signer from private key secret: %w", err)
+			// an invalid settings ConfigMap is reported by the ConfigMap controller, and gives no reboot window
+			if !errors.Is(err, settings.ErrInvalid) {
+				return ctrl.Result{}, err
+			}
+			s = &settings.Settings{}
+		}
+		if wait := s.UntilRebootWindow(time.Now()); wait > 0 {
+			r.log.Info("deferring reboot until the next reboot window", "node", node.GetName(), "wait", wait)
+			return ctrl.Result{RequeueAfter: wait}, nil
 		}
-		instanceInfo, err := r.instanceFromNode(node)
+		nc, err := conn.get()
 		if err != nil {
 			return ctrl.Result{}, err
 		}
-		nc, err := nodeconfig.NewNodeConfig(r.client, r.k8sclientset, r.clusterServiceCIDR, r.watchNamespace,
-			instanceInfo, signer, nil, nil, r.platform)
+		if err := nc.SafeReboot(ctx); err != nil {
+			r.reportRefusedDrain(node, err)
+			return ctrl.Result{}, fmt.Errorf("full instance reboot failed: %w", err)
+		}
+		return ctrl.Result{}, nil
+	}
+	// WICD is paused on a node until it is rebooted, and token rotation waits for every configured node to use the
+	// newest token, so nodes outside of the node selector are only left out of the other operations
+	if !r.isSelectedNode(node) {
+		return ctrl.Result{}, r.ensureWICDTokenIsCurrent(ctx, conn)
+	}
+	if node.GetAnnotations()[metadata.ForceReconfigureAnnotation] == "true" {
+		return ctrl.Result{}, r.forceReconfigure(ctx, node)
+	}
+	if err := r.ensureWindowsTaint(node); err != nil {
+		return ctrl.Result{}, err
+	}
+	r.reportWICDDegraded(node)
+	r.validateServicesConfigMapOverride(ctx, node)
+	if err := r.ensureServingCertMatchesAddresses(conn); err != nil {
+		return ctrl.Result{}, err
+	}
+	retryAfter := r.checkExternalConnectivity(ctx, node)
+	r.checkWindowsExporterScrapeable(ctx, node)
+	r.removeStaleTempFiles(ctx, conn)
+	if err := r.captureProcessDump(ctx, conn); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.ensureWICDTokenIsCurrent(ctx, conn); err != nil {
+		return ctrl.Result{}, err
+	}
+	requeueAfter, err := r.recoverStalledUpgrade(ctx, conn)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if err = r.ensureNetworkConfScript(ctx); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err = r.ensureCNIConfig(conn); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err = r.ensureContainerdConfig(conn); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err = r.ensureTimezone(ctx, conn); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err = r.ensureCredentialFileACLs(conn); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err = r.checkKubeletFlags(ctx, conn); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: soonestRequeue(requeueAfter, retryAfter)}, r.ensureWICDKubeconfigServer(conn)
+}
+
+// soonestRequeue returns the shorter of the given requeue durations, ignoring a duration of 0 which means no requeue
+func soonestRequeue(a, b time.Duration) time.Duration {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// instanceConnection is the connection to the instance of a node shared by the operations of a single reconcile of
+// the node. The instance is only connected to once an operation needs it.
+type instanceConnection struct {
+	r    *nodeReconciler
+	node *core.Node
+	nc   *nodeconfig.NodeConfig
+	err  error
+}
+
+// get returns a NodeConfig connected to the instance of the node, connecting to the instance on first use. A failure
+// to connect is returned to every later caller rather than the instance being connected to again.
+func (c *instanceConnection) get() (*nodeconfig.NodeConfig, error) {
+	if c.nc == nil && c.err == nil {
+		c.nc, c.err = c.r.newNodeConfig(c.node)
+	}
+	return c.nc, c.err
+}
+
+// close closes the connection to the instance, if one was made
+func (c *instanceConnection) close() {
+	if c.nc != nil {
+		c.r.closeNodeConfig(c.nc)
+	}
+}
+
+// ensureWindowsTaint applies the Windows taint again to a node configured by WMCO if it was removed, as Linux pods
+// would otherwise be scheduled onto the node. Nodes whose lifecycle has been taken over from WMCO are left alone.
+func (r *nodeReconciler) ensureWindowsTaint(node *core.Node) error {
+	if _, configured := node.GetAnnotations()[metadata.VersionAnnotation]; !configured || isExternallyManaged(node) {
+		return nil
+	}
+	applied, err := nodeconfig.EnsureWindowsTaint(r.k8sclientset, node)
+	if err != nil {
+		return err
+	}
+	if applied {
+		r.log.Info("restored missing Windows taint", "node", node.GetName())
+		r.recorder.Event(node, core.EventTypeWarning, "WindowsTaintRestored",
+			"the Windows taint was missing from the node and has been applied again")
+	}
+	return nil
+}
+
+// newNodeConfig returns a NodeConfig for the instance of the given node, connected to it over SSH with the private key
+// that the instances are reconciled with. The caller must close the NodeConfig once it is done with the instance.
+func (r *nodeReconciler) newNodeConfig(node *core.Node) (*nodeconfig.NodeConfig, error) {
+	keySigner, err := signer.Create(types.NamespacedName{Namespace: r.watchNamespace,
+		Name: secrets.PrivateKeySecret}, r.client)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create signer from private key secret: %w", err)
+	}
+	return r.nodeConfigFromNode(node, keySigner)
+}
+
+// forceReconfigure deconfigures the node and configures it again, as requested through the node's force reconfigure
+// annotation. The node is cordoned and drained before being deconfigured. The annotation is only removed once the node
+// has been configured, so that a reconfiguration interrupted by an error or an operator restart is started over.
+func (r *nodeReconciler) forceReconfigure(ctx context.Context, node *core.Node) error {
+	nc, err := r.newNodeConfig(node)
+	if err != nil {
+		return err
+	}
+	defer r.closeNodeConfig(nc)
+
+	r.log.Info("reconfiguring node as requested", "node", node.GetName())
+	r.recorder.Eventf(node, core.EventTypeNormal, "ForceReconfigure", "reconfiguring node as requested by the %s "+
+		"annotation", metadata.ForceReconfigureAnnotation)
+	if err := markNodeAsUpgrading(ctx, r.client, node); err != nil {
+		return err
+	}
+	if err := nc.Deconfigure(); err != nil {
+		r.recorder.Eventf(node, core.EventTypeWarning, "ForceReconfigureFailed", "error deconfiguring node: %v", err)
+		return fmt.Errorf("error deconfiguring node %s for reconfiguration: %w", node.GetName(), err)
+	}
+	if err := nc.Configure(); err != nil {
+		r.reportFailedValidation(err)
+		r.recorder.Eventf(node, core.EventTypeWarning, "ForceReconfigureFailed", "error configuring node: %v", err)
+		return fmt.Errorf("error reconfiguring node %s: %w", node.GetName(), err)
+	}
+	r.recorder.Eventf(node, core.EventTypeNormal, "Reconfigured", "node has been reconfigured%s",
+		configurationSuffix(nc.ConfigurationID()))
+	// Annotations of the node have changed during the reconfiguration, get it again before patching it
+	if err := r.client.Get(ctx, types.NamespacedName{Name: node.GetName()}, node); err != nil {
+		return fmt.Errorf("error getting node %s: %w", node.GetName(), err)
+	}
+	return metadata.RemoveForceReconfigureAnnotation(ctx, r.client, *node)
+}
+
+// recoverStalledUpgrade restarts WICD on a node whose version has differed from the desired version set by this
+// operator for longer than the upgrade stall timeout, as happens when the operator is stopped after setting the
+// desired version but before WICD configured the node for it. The recovery is reported through an event and the
+// node's UpgradeStalled condition, and is attempted again each time the timeout elapses. The returned duration is how
+// long until the node should be checked again, which is 0 if the node is not waiting for WICD.
+func (r *nodeReconciler) recoverStalledUpgrade(ctx context.Context, conn *instanceConnection) (time.Duration, error) {
+	node := conn.node
+	desiredVersion := node.GetAnnotations()[metadata.DesiredVersionAnnotation]
+	// Nodes with the desired version of an earlier operator version are reconfigured by the instance controllers
+	if desiredVersion != version.Get() {
+		return 0, nil
+	}
+	if node.GetAnnotations()[metadata.VersionAnnotation] == desiredVersion {
+		stalledCondition := nodeutil.GetCondition(node, nodeutil.UpgradeStalledCondition)
+		if stalledCondition == nil || stalledCondition.Status != core.ConditionTrue {
+			return 0, nil
+		}
+		return 0, nodeutil.SetUpgradeStalledCondition(ctx, r.client, node, desiredVersion, 0)
+	}
+	stalledFor := metadata.VersionMismatchDuration(node, time.Now())
+	if stalledFor == 0 {
+		return 0, nil
+	}
+	timeout := defaultUpgradeStallTimeout
+	s, err := settings.Get(ctx, r.client, r.watchNamespace)
+	if err != nil {
+		// an invalid settings ConfigMap is reported by the ConfigMap controller, and must not block the recovery
+		if !errors.Is(err, settings.ErrInvalid) {
+			return 0, err
+		}
+	} else if s.UpgradeStallTimeout > 0 {
+		timeout = s.UpgradeStallTimeout
+	}
+	if stalledFor < timeout {
+		return timeout - stalledFor, nil
+	}
+
+	r.log.Info("WARNING: node has not reached its desired version, restarting WICD", "node", node.GetName(),
+		"desiredVersion", desiredVersion, "since", stalledFor.Round(time.Second))
+	r.recorder.Eventf(node, core.EventTypeWarning, "UpgradeStalled",
+		"node not configured for desired version %s after %s, restarting %s", desiredVersion,
+		stalledFor.Round(time.Second), windows.WicdServiceName)
+	if err = nodeutil.SetUpgradeStalledCondition(ctx, r.client, node, desiredVersion, stalledFor); err != nil {
+		return 0, err
+	}
+	nc, err := conn.get()
+	if err != nil {
+		return 0, err
+	}
+	if err = nc.Windows.RestartService(windows.WicdServiceName); err != nil {
+		return 0, fmt.Errorf("error restarting WICD on node %s: %w", node.GetName(), err)
+	}
+	// Reapplying the desired version restarts the stall timeout, so that WICD is given time to configure the node
+	if err = metadata.ApplyDesiredVersionAnnotation(ctx, r.client, *node, desiredVersion); err != nil {
+		return 0, fmt.Errorf("error updating desired version annotation on node %s: %w", node.GetName(), err)
+	}
+	return timeout, nil
+}
+
+// reportWICDDegraded emits a warning event on the node, and logs, the last error WICD reported through the node's
+// WICDDegraded condition, as WICD's own log is only available on the instance
+func (r *nodeReconciler) reportWICDDegraded(node *core.Node) {
+	wicdCondition := nodeutil.GetCondition(node, nodeutil.WICDDegradedCondition)
+	if wicdCondition == nil || wicdCondition.Status != core.ConditionTrue {
+		return
+	}
+	r.log.Info("WARNING: WICD failed to configure node", "node", node.GetName(), "since",
+		wicdCondition.LastTransitionTime, "error", wicdCondition.Message)
+	r.recorder.Eventf(node, core.EventTypeWarning, "WICDDegraded", wicdCondition.Message)
+}
+
+// validateServicesConfigMapOverride emits a warning event on the node, and logs, if the services ConfigMap its
+// override annotation points WICD at does not exist or is not a valid services ConfigMap. WICD refuses to configure
+// the node with such a ConfigMap, so the event lets the admin know the override is not in effect.
+func (r *nodeReconciler) validateServicesConfigMapOverride(ctx context.Context, node *core.Node) {
+	override := node.GetAnnotations()[metadata.ServicesConfigMapOverrideAnnotation]
+	if override == "" {
+		return
+	}
+	err := func() error {
+		cm := &core.ConfigMap{}
+		if err := r.client.Get(ctx, types.NamespacedName{Namespace: r.watchNamespace, Name: override}, cm); err != nil {
+			return err
+		}
+		_, err := servicescm.Parse(cm.Data)
+		return err
+	}()
+	if err != nil {
+		r.log.Error(err, "invalid services ConfigMap override", "node", node.GetName(), "ConfigMap", override)
+		r.recorder.Eventf(node, core.EventTypeWarning, "InvalidServicesConfigMapOverride",
+			"services ConfigMap %s given by annotation %s cannot be used: %v", override,
+			metadata.ServicesConfigMapOverrideAnnotation, err)
+	}
+}
+
+// ensureServingCertMatchesAddresses causes kubelet to request a new serving certificate if the addresses of the node
+// have changed since its serving certificate was requested, as is the case when a BYOH instance is given a new DHCP
+// lease. Without this, the API server cannot reach kubelet until the certificate is next rotated.
+func (r *nodeReconciler) ensureServingCertMatchesAddresses(conn *instanceConnection) error {
+	node := conn.node
+	// Nodes which are still being configured request a serving certificate as part of their configuration
+	if node.GetAnnotations()[metadata.VersionAnnotation] != version.Get() {
+		return nil
+	}
+	if node.GetAnnotations()[metadata.ServingCertAddressesAnnotation] == nodeconfig.ServingCertAddresses(node) {
+		return nil
+	}
+	nc, err := conn.get()
+	if err != nil {
+		return err
+	}
+	return nc.RenewKubeletServingCert()
+}
+
+// setHNSIPUsageMetrics sets the HNS subnet usage metrics of the node to the given usage, emitting a warning event if
+// the node is nearly out of addresses to assign to pods. Nothing is done if the usage is not known.
+func (r *nodeReconciler) setHNSIPUsageMetrics(node *core.Node, usage *windows.HNSNetworkIPUsage) {
+	if usage == nil {
+		return
+	}
+	metrics.HNSSubnetSize.WithLabelValues(node.GetName()).Set(float64(usage.Size))
+	metrics.HNSSubnetAddressesUsed.WithLabelValues(node.GetName()).Set(float64(usage.Used))
+	r.log.V(1).Info("HNS subnet usage", "node", node.GetName(), "subnet", usage.Subnet, "size", usage.Size,
+		"used", usage.Used)
+	if hnsSubnetNearlyExhausted(usage) {
+		r.log.Info("WARNING: node is nearly out of pod IPs", "node", node.GetName(), "subnet", usage.Subnet,
+			"size", usage.Size, "used", usage.Used)
+		r.recorder.Eventf(node, core.EventTypeWarning, "HNSSubnetNearlyExhausted",
+			"%d of the %d assignable addresses of HNS subnet %s are in use, new pods may fail to get an IP",
+			usage.Used, usage.Size, usage.Subnet)
+	}
+}
+
+// hnsSubnetNearlyExhausted returns true if at least hnsSubnetExhaustionThreshold of the assignable addresses of the
+// given HNS subnet are in use
+func hnsSubnetNearlyExhausted(usage *windows.HNSNetworkIPUsage) bool {
+	return float64(usage.Used) >= hnsSubnetExhaustionThreshold*float64(usage.Size)
+}
+
+// checkExternalConnectivity verifies that a configured node can be connected to through its external address, on the
+// port given in the settings ConfigMap, and reports the result through the node's ExternallyReachable condition. This
+// is only done when a port is configured, on platforms which give nodes external addresses, at most once every
+// externalConnectivityInterval. A single connection attempt is made per reconcile, and a failed attempt is retried
+// externalConnectivityRetryInterval later, as given by the returned duration, until the configured number of attempts
+// have failed. The returned duration is 0 if no attempt is pending. Failures are logged rather than returned, as they
+// should not block the reconciliation of the node.
+func (r *nodeReconciler) checkExternalConnectivity(ctx context.Context, node *core.Node) time.Duration {
+	if node.GetAnnotations()[metadata.VersionAnnotation] != version.Get() ||
+		!cluster.HasExternalNodeAddresses(r.platform) {
+		return 0
+	}
+	// the check time is only updated once the check completes, so a check in progress is always due
+	if time.Since(r.externalConnectivityChecked[node.GetName()]) < externalConnectivityInterval {
+		return 0
+	}
+	s, err := settings.Get(ctx, r.client, r.watchNamespace)
+	if err != nil {
+		r.log.Error(err, "unable to get settings to check external connectivity")
+		return 0
+	}
+	if s.ExternalConnectivityCheckPort == 0 {
+		return 0
+	}
+	address := externalAddress(node)
+	if address == "" {
+		r.log.V(1).Info("node has no external address, skipping external connectivity check", "node", node.GetName())
+		return 0
+	}
+	retries := defaultExternalConnectivityCheckRetries
+	if s.ExternalConnectivityCheckRetries > 0 {
+		retries = s.ExternalConnectivityCheckRetries
+	}
+	timeout := defaultExternalConnectivityCheckTimeout
+	if s.ExternalConnectivityCheckTimeout > 0 {
+		timeout = s.ExternalConnectivityCheckTimeout
+	}
+	target := net.JoinHostPort(address, strconv.Itoa(s.ExternalConnectivityCheckPort))
+	checkErr := checkTCPConnectivity(target, timeout)
+	if checkErr != nil {
+		r.externalConnectivityFailures[node.GetName()]++
+		if failures := r.externalConnectivityFailures[node.GetName()]; failures < retries {
+			r.log.V(1).Info("unable to connect to node through its external address, retrying", "node",
+				node.GetName(), "address", target, "attempt", failures, "error", checkErr)
+			return externalConnectivityRetryInterval
+		}
+		checkErr = fmt.Errorf("no connection after %d attempts: %w", retries, checkErr)
+	}
+	delete(r.externalConnectivityFailures, node.GetName())
+	r.externalConnectivityChecked[node.GetName()] = time.Now()
+	if checkErr != nil {
+		r.log.Info("WARNING: node is not reachable through its external address", "node", node.GetName(),
+			"address", target, "error", checkErr)
+		r.recorder.Eventf(node, core.EventTypeWarning, "ExternallyUnreachable", "unable to connect to %s: %v",
+			target, checkErr)
+	}
+	if err := nodeutil.SetExternallyReachableCondition(ctx, r.client, node, target, checkErr); err != nil {
+		r.log.Error(err, "unable to report the external connectivity of the node", "node", node.GetName())
+	}
+	return 0
+}
+
+// checkWindowsExporterScrapeable scrapes the metrics of the windows_exporter of a configured node, in the same way as
+// Prometheus, and reports the result through the node's WindowsExporterScrapeable condition. This catches an exporter
+// which is running and reachable but cannot be scraped, such as one bound to another address or serving an outdated
+// certificate, which would otherwise only show as missing metrics. The WMCO metrics of the node are updated from the
+// scraped metrics. Nodes are scraped at most once every windowsExporterScrapeInterval. Failures are logged rather than
+// returned, as they should not block the reconciliation of the node.
+func (r *nodeReconciler) checkWindowsExporterScrapeable(ctx context.Context, node *core.Node) {
+	// windows_exporter is only configured once the node has been configured
+	if node.GetAnnotations()[metadata.VersionAnnotation] != version.Get() {
+		return
+	}
+	if time.Since(r.windowsExporterScraped[node.GetName()]) < windowsExporterScrapeInterval {
+		return
+	}
+	address, err := GetAddress(node.Status.Addresses)
+	if err != nil {
+		r.log.Error(err, "unable to get address to scrape windows_exporter", "node", node.GetName())
+		return
+	}
+	tlsSecret := &core.Secret{}
+	if err = r.client.Get(ctx, types.NamespacedName{Namespace: r.watchNamespace, Name: secrets.TLSSecret},
+		tlsSecret); err != nil {
+		r.log.Error(err, "unable to get windows_exporter serving certificate", "secret", secrets.TLSSecret)
+		return
+	}
+	target := net.JoinHostPort(address, strconv.Itoa(int(metrics.Port)))
+	scraped, scrapeErr := scrapeWindowsExporter(target, tlsSecret.Data[core.TLSCertKey], windowsExporterScrapeTimeout)
+	r.windowsExporterScraped[node.GetName()] = time.Now()
+	if scrapeErr != nil {
+		r.log.Info("WARNING: unable to scrape windows_exporter, metrics of the node will be missing", "node",
+			node.GetName(), "address", target, "error", scrapeErr)
+		r.recorder.Eventf(node, core.EventTypeWarning, "WindowsExporterUnscrapeable",
+			"unable to scrape metrics from %s: %v", target, scrapeErr)
+	} else {
+		setServiceRestartMetrics(node, scraped.serviceTerminations)
+		r.setHNSIPUsageMetrics(node, scraped.hnsIPUsage)
+	}
+	if err := nodeutil.SetWindowsExporterScrapeableCondition(ctx, r.client, node, target, scrapeErr); err != nil {
+		r.log.Error(err, "unable to report whether windows_exporter is scrapeable", "node", node.GetName())
+	}
+}
+
+// setServiceRestartMetrics sets the restart count metric of each of the WMCO-managed services of the node to the given
+// number of unexpected terminations. Services missing from the given counts keep their previous metric.
+func setServiceRestartMetrics(node *core.Node, terminations map[string]float64) {
+	for service, count := range terminations {
+		metrics.ServiceRestarts.WithLabelValues(node.GetName(), service).Set(count)
+	}
+}
+
+// removeStaleTempFiles removes the stale files in WMCO's temporary directory on a configured node, at most once every
+// tempFileCleanupInterval, if the temp file cleanup policy requires them to be removed periodically. Failures are
+// logged rather than returned, as leftover files do not affect the node.
+func (r *nodeReconciler) removeStaleTempFiles(ctx context.Context, conn *instanceConnection) {
+	node := conn.node
+	if node.GetAnnotations()[metadata.VersionAnnotation] != version.Get() {
+		return
+	}
+	if time.Since(r.tempFilesCleaned[node.GetName()]) < tempFileCleanupInterval {
+		return
+	}
+	s, err := settings.Get(ctx, r.client, r.watchNamespace)
+	if err != nil {
+		r.log.Error(err, "unable to get settings to remove stale temporary files")
+		return
+	}
+	if s.TempFileCleanupPolicy != settings.TempFileCleanupAlways {
+		return
+	}
+	nc, err := conn.get()
+	if err != nil {
+		r.log.Error(err, "unable to connect to instance", "node", node.GetName())
+		return
+	}
+	if err := nc.Windows.RemoveStaleTempFiles(); err != nil {
+		r.log.Error(err, "unable to remove stale temporary files", "node", node.GetName())
+		return
+	}
+	r.tempFilesCleaned[node.GetName()] = time.Now()
+}
+
+// captureProcessDump collects a memory dump of the process of the service given by the node's process dump annotation,
+// writing it to processDumpDir. The annotation is removed whether or not a dump could be collected, so that each
+// request results in at most one dump, as dumps are large.
+func (r *nodeReconciler) captureProcessDump(ctx context.Context, conn *instanceConnection) error {
+	node := conn.node
+	serviceName, present := node.GetAnnotations()[metadata.ProcessDumpAnnotation]
+	if !present {
+		return nil
+	}
+	path, err := r.writeProcessDump(conn, serviceName)
+	if err != nil {
+		if errors.Is(err, windows.ErrNoProcessDump) {
+			r.log.Info("no process dump available", "node", node.GetName(), "service", serviceName, "reason", err)
+		} else {
+			r.log.Error(err, "unable to collect process dump", "node", node.GetName(), "service", serviceName)
+		}
+		r.recorder.Eventf(node, core.EventTypeWarning, "ProcessDumpFailed", "unable to collect dump of %s: %v",
+			serviceName, err)
+	} else {
+		r.recorder.Eventf(node, core.EventTypeNormal, "ProcessDumpCollected",
+			"collected dump of %s, written to %s in the operator's container", serviceName, path)
+	}
+	return metadata.RemoveProcessDumpAnnotation(ctx, r.client, *node)
+}
+
+// writeProcessDump collects a memory dump of the process of the given service on the node's instance, and returns
+// the path it was written to. A previous dump of the same service is overwritten.
+func (r *nodeReconciler) writeProcessDump(conn *instanceConnection, serviceName string) (string, error) {
+	if _, ok := windows.ProcessDumpServices[serviceName]; !ok {
+		return "", fmt.Errorf("capturing a dump of the %s service is not supported", serviceName)
+	}
+	nc, err := conn.get()
+	if err != nil {
+		return "", err
+	}
+	dump, err := nc.CaptureProcessDump(serviceName)
+	if err != nil {
+		return "", err
+	}
+	// dumps can hold credentials, so they are only readable by the operator
+	if err = os.MkdirAll(processDumpDir, 0700); err != nil {
+		return "", fmt.Errorf("error creating %s: %w", processDumpDir, err)
+	}
+	path := filepath.Join(processDumpDir, conn.node.GetName()+"-"+serviceName+".dmp")
+	if err = os.WriteFile(path, dump, 0600); err != nil {
+		return "", fmt.Errorf("error writing %s: %w", path, err)
+	}
+	return path, nil
+}
+
+// ensureWICDTokenIsCurrent updates the WICD kubeconfig on the node's instance if it was not generated from the newest
+// WICD ServiceAccount token, which is the case while a token rotation is in progress
+func (r *nodeReconciler) ensureWICDTokenIsCurrent(ctx context.Context, conn *instanceConnection) error {
+	node := conn.node
+	// Nodes which are still being configured are given the newest token as part of their configuration
+	if node.GetAnnotations()[metadata.VersionAnnotation] != version.Get() {
+		return nil
+	}
+	tokenSecrets, err := nodeconfig.ListWICDTokenSecrets(ctx, r.client, r.watchNamespace)
+	if err != nil {
+		return err
+	}
+	if len(tokenSecrets) == 0 || node.GetAnnotations()[metadata.WICDTokenAnnotation] == tokenSecrets[0].GetName() {
+		return nil
+	}
+	nc, err := conn.get()
+	if err != nil {
+		return err
+	}
+	return nc.UpdateWICDKubeconfig()
+}
+
+// ensureWICDKubeconfigServer updates the WICD kubeconfig on the node's instance if it points at an API server endpoint
+// other than the cluster's current one, such as after the API server address was changed
+func (r *nodeReconciler) ensureWICDKubeconfigServer(conn *instanceConnection) error {
+	node := conn.node
+	// Nodes which are still being configured are given a kubeconfig for the current endpoint as part of configuration
+	if node.GetAnnotations()[metadata.VersionAnnotation] != version.Get() ||
+		r.wicdKubeconfigServerChecked[node.GetName()] {
+		return nil
+	}
+	nc, err := conn.get()
+	if err != nil {
+		return err
+	}
+	if err = nc.EnsureWICDKubeconfigServer(); err != nil {
+		return fmt.Errorf("error ensuring WICD kubeconfig server of node %s: %w", node.GetName(), err)
+	}
+	r.wicdKubeconfigServerChecked[node.GetName()] = true
+	return nil
+}
+
+// ensureCNIConfig repairs the CNI config on the node's instance if it does not match the current cluster network and
+// HNS endpoint policy settings
+func (r *nodeReconciler) ensureCNIConfig(conn *instanceConnection) error {
+	node := conn.node
+	// Nodes which are still being configured are given a CNI config for the current network as part of configuration
+	if node.GetAnnotations()[metadata.VersionAnnotation] != version.Get() || r.cniConfigChecked[node.GetName()] {
+		return nil
+	}
+	nc, err := conn.get()
+	if err != nil {
+		return err
+	}
+	if err = nc.EnsureCNIConfig(); err != nil {
+		return fmt.Errorf("error ensuring CNI config of node %s: %w", node.GetName(), err)
+	}
+	r.cniConfigChecked[node.GetName()] = true
+	return nil
+}
+
+// ensureContainerdConfig periodically repairs the containerd config on the node's instance if it has been edited by
+// hand, as the edits would otherwise only take effect when containerd is next restarted
+func (r *nodeReconciler) ensureContainerdConfig(conn *instanceConnection) error {
+	node := conn.node
+	// Nodes which are still being configured are given the generated containerd config as part of configuration
+	if node.GetAnnotations()[metadata.VersionAnnotation] != version.Get() ||
+		time.Since(r.containerdConfigChecked[node.GetName()]) < containerdConfigCheckInterval {
+		return nil
+	}
+	nc, err := conn.get()
+	if err != nil {
+		return err
+	}
+	if err = nc.EnsureContainerdConfig(); err != nil {
+		return fmt.Errorf("error ensuring containerd config of node %s: %w", node.GetName(), err)
+	}
+	r.containerdConfigChecked[node.GetName()] = time.Now()
+	return nil
+}
+
+// ensureTimezone periodically sets the timezone given in the settings ConfigMap on the node's instance again, as the
+// timezone can be changed on the instance after it was configured
+func (r *nodeReconciler) ensureTimezone(ctx context.Context, conn *instanceConnection) error {
+	node := conn.node
+	// Nodes which are still being configured are given the timezone as part of configuration
+	if node.GetAnnotations()[metadata.VersionAnnotation] != version.Get() ||
+		time.Since(r.timezoneChecked[node.GetName()]) < timezoneCheckInterval {
+		return nil
+	}
+	s, err := settings.Get(ctx, r.client, r.watchNamespace)
+	if err != nil {
+		// an invalid settings ConfigMap is reported by the ConfigMap controller, and must not block the node
+		if errors.Is(err, settings.ErrInvalid) {
+			return nil
+		}
+		return err
+	}
+	if s.Timezone != "" {
+		nc, err := conn.get()
 		if err != nil {
-			return ctrl.Result{}, fmt.Errorf("failed to create new nodeconfig: %w", err)
+			return err
+		}
+		if err = nc.EnsureTimezone(); err != nil {
+			return fmt.Errorf("error ensuring timezone of node %s: %w", node.GetName(), err)
 		}
+	}
+	r.timezoneChecked[node.GetName()] = time.Now()
+	return nil
+}
 
-		if err := nc.SafeReboot(ctx); err != nil {
-			return ctrl.Result{}, fmt.Errorf("full instance reboot failed: %w", err)
+// ensureCredentialFileACLs periodically restricts access to the credential files of the node's instance again, as
+// their ACLs can be changed on the instance and kubelet writes a new key file whenever it rotates its certificate
+func (r *nodeReconciler) ensureCredentialFileACLs(conn *instanceConnection) error {
+	node := conn.node
+	// Nodes which are still being configured have the ACLs set as part of configuration
+	if node.GetAnnotations()[metadata.VersionAnnotation] != version.Get() ||
+		time.Since(r.credentialFileACLsChecked[node.GetName()]) < credentialFileACLCheckInterval {
+		return nil
+	}
+	nc, err := conn.get()
+	if err != nil {
+		return err
+	}
+	if err = nc.Windows.EnsureCredentialFileACLs(); err != nil {
+		return fmt.Errorf("error restricting access to the credential files of node %s: %w", node.GetName(), err)
+	}
+	r.credentialFileACLsChecked[node.GetName()] = time.Now()
+	return nil
+}
+
+// checkKubeletFlags periodically compares the flags the kubelet service of the node's instance is configured with
+// against the services ConfigMap the node is configured with. WICD is the only writer of the service's command, so on
+// drift the ConfigMap is annotated to have WICD reconcile the services of its nodes, rather than the command being
+// changed on the instance.
+func (r *nodeReconciler) checkKubeletFlags(ctx context.Context, conn *instanceConnection) error {
+	node := conn.node
+	// Nodes which are still being configured are given the expected kubelet flags by WICD as part of configuration
+	if node.GetAnnotations()[metadata.VersionAnnotation] != version.Get() ||
+		time.Since(r.kubeletFlagsChecked[node.GetName()]) < kubeletFlagsCheckInterval {
+		return nil
+	}
+	cmName := servicescm.Name
+	if override := node.GetAnnotations()[metadata.ServicesConfigMapOverrideAnnotation]; override != "" {
+		cmName = override
+	}
+	cm := &core.ConfigMap{}
+	if err := r.client.Get(ctx, types.NamespacedName{Namespace: r.watchNamespace, Name: cmName}, cm); err != nil {
+		return fmt.Errorf("error getting services ConfigMap %s: %w", cmName, err)
+	}
+	data, err := servicescm.Parse(cm.Data)
+	if err != nil {
+		// an invalid override is reported by validateServicesConfigMapOverride, and an invalid services ConfigMap is
+		// replaced by the ConfigMap controller
+		return nil
+	}
+	var kubelet *servicescm.Service
+	for i := range data.Services {
+		if data.Services[i].Name == windows.KubeletServiceName {
+			kubelet = &data.Services[i]
+			break
 		}
 	}
-	return ctrl.Result{}, nil
+	if kubelet != nil {
+		nc, err := conn.get()
+		if err != nil {
+			return err
+		}
+		drifted, err := nc.KubeletFlagDrift(*kubelet)
+		if err != nil {
+			return fmt.Errorf("error checking kubelet flags of node %s: %w", node.GetName(), err)
+		}
+		if len(drifted) > 0 {
+			flags := make([]string, 0, len(drifted))
+			for flag := range drifted {
+				flags = append(flags, flag)
+			}
+			slices.Sort(flags)
+			r.log.Info("kubelet flags drifted from the services ConfigMap, requesting WICD reconciliation",
+				"node", node.GetName(), "ConfigMap", cmName, "expected", drifted)
+			r.recorder.Eventf(node, core.EventTypeWarning, "KubeletFlagsDrifted",
+				"kubelet flags %v differ from services ConfigMap %s and will be reconfigured by WICD",
+				flags, cmName)
+			patchBase := client.MergeFrom(cm.DeepCopy())
+			if cm.Annotations == nil {
+				cm.Annotations = make(map[string]string)
+			}
+			cm.Annotations[servicescm.ReconcileRequestedAnnotation] = time.Now().UTC().Format(time.RFC3339)
+			if err = r.client.Patch(ctx, cm, patchBase); err != nil {
+				return fmt.Errorf("error requesting reconciliation of services ConfigMap %s: %w", cmName, err)
+			}
+		}
+	}
+	r.kubeletFlagsChecked[node.GetName()] = time.Now()
+	return nil
+}
+
+// ensureNetworkConfScript regenerates the network configuration script in the payload if the service network of the
+// cluster has changed since the script was generated, and has the CNI config of every node checked again so that the
+// new script is pushed to the nodes whose CNI config no longer matches the service network
+func (r *nodeReconciler) ensureNetworkConfScript(ctx context.Context) error {
+	network := &config.Network{}
+	if err := r.client.Get(ctx, types.NamespacedName{Name: "cluster"}, network); err != nil {
+		return fmt.Errorf("error getting cluster network config: %w", err)
+	}
+	serviceCIDRs, err := changedServiceNetwork(network, r.clusterServiceCIDRs)
+	if err != nil {
+		// the nodes are left with the script for the previous service network rather than failing every reconcile
+		r.log.Error(err, "unable to use the cluster service network")
+		return nil
+	}
+	if serviceCIDRs == nil {
+		return nil
+	}
+	if err = payload.PopulateNetworkConfScript(serviceCIDRs[0], windows.OVNKubeOverlayNetwork, windows.HNSPSModule,
+		windows.CNIConfigPath, windows.HNSEndpointPoliciesPath); err != nil {
+		return fmt.Errorf("unable to regenerate network configuration script: %w", err)
+	}
+	r.log.Info("regenerated network configuration script for changed cluster service network",
+		"previous", r.clusterServiceCIDRs, "current", serviceCIDRs)
+	r.clusterServiceCIDRs = serviceCIDRs
+	clear(r.cniConfigChecked)
+	return nil
+}
+
+// changedServiceNetwork returns the service CIDRs given by the cluster network config if they differ from the given
+// CIDRs, or nil if they are the same. An error is returned if the config gives no service CIDR or an invalid one.
+func changedServiceNetwork(network *config.Network, current []string) ([]string, error) {
+	serviceCIDRs := network.Spec.ServiceNetwork
+	if len(serviceCIDRs) == 0 {
+		return nil, fmt.Errorf("cluster network config gives no service network")
+	}
+	if slices.Equal(serviceCIDRs, current) {
+		return nil, nil
+	}
+	for _, serviceCIDR := range serviceCIDRs {
+		if err := cluster.ValidateCIDR(serviceCIDR); err != nil {
+			return nil, fmt.Errorf("invalid cluster service CIDR: %w", err)
+		}
+	}
+	return slices.Clone(serviceCIDRs), nil
+}
+
+// mapToWindowsNodes maps a change to an object to requests for all Windows nodes the reconciler acts on
+func (r *nodeReconciler) mapToWindowsNodes(ctx context.Context, _ client.Object) []reconcile.Request {
+	nodes := &core.NodeList{}
+	if err := r.client.List(ctx, nodes, client.MatchingLabels{core.LabelOSStable: "windows"}); err != nil {
+		r.log.Error(err, "unable to list Windows nodes")
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(nodes.Items))
+	for _, node := range nodes.Items {
+		if !r.isManagedNode(&node) {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: node.GetName()}})
+	}
+	return requests
+}
+
+// isManagedNode returns true if the given object is a Windows node the reconciler acts on. These are the Windows nodes
+// matching the node selector of the reconciler, and the nodes configured by WMCO, which must keep being rebooted and
+// given new WICD tokens whether or not they are selected.
+func (r *nodeReconciler) isManagedNode(obj runtime.Object) bool {
+	node, ok := obj.(*core.Node)
+	return ok && isWindowsNode(node) && (r.isSelectedNode(node) || isConfiguredNode(node))
+}
+
+// isSelectedNode returns true if the given node matches the node selector of the reconciler
+func (r *nodeReconciler) isSelectedNode(node *core.Node) bool {
+	return r.nodeSelector.Matches(labels.Set(node.GetLabels()))
+}
+
+// isConfiguredNode returns true if the given node has been configured by WMCO, or given a WICD token by it
+func isConfiguredNode(node *core.Node) bool {
+	_, configured := node.GetAnnotations()[metadata.VersionAnnotation]
+	_, tracked := node.GetAnnotations()[metadata.WICDTokenAnnotation]
+	return configured || tracked
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *nodeReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	windowsNodePredicate := predicate.Funcs{
 		CreateFunc: func(e event.CreateEvent) bool {
-			return isWindowsNode(e.Object)
+			return r.isManagedNode(e.Object)
 		},
 		UpdateFunc: func(e event.UpdateEvent) bool {
-			return isWindowsNode(e.ObjectNew)
+			return r.isManagedNode(e.ObjectNew)
 		},
 		GenericFunc: func(e event.GenericEvent) bool {
-			return isWindowsNode(e.Object)
+			return r.isManagedNode(e.Object)
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			// the metrics of deleted nodes are removed
+			return r.isManagedNode(e.Object)
+		},
+	}
+	wicdTokenSecretPredicate := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return isWICDTokenSecret(obj, r.watchNamespace)
+	})
+	// only a change of the service network requires the nodes to be reconciled
+	clusterNetworkPredicate := predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return false
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldNetwork, ok := e.ObjectOld.(*config.Network)
+			if !ok {
+				return false
+			}
+			newNetwork, ok := e.ObjectNew.(*config.Network)
+			return ok && newNetwork.GetName() == "cluster" &&
+				!slices.Equal(oldNetwork.Spec.ServiceNetwork, newNetwork.Spec.ServiceNetwork)
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
 		},
 		DeleteFunc: func(e event.DeleteEvent) bool {
 			return false
@@ -135,9 +1042,125 @@ func (r *nodeReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&core.Node{}, builder.WithPredicates(windowsNodePredicate)).
+		Watches(&core.Secret{}, handler.EnqueueRequestsFromMapFunc(r.mapToWindowsNodes),
+			builder.WithPredicates(wicdTokenSecretPredicate)).
+		Watches(&config.Network{}, handler.EnqueueRequestsFromMapFunc(r.mapToWindowsNodes),
+			builder.WithPredicates(clusterNetworkPredicate)).
 		Complete(r)
 }
 
+// externalAddress returns the first external IP address of the given node, or its first external DNS name if it has
+// no external IP address. An empty string is returned if the node has neither.
+func externalAddress(node *core.Node) string {
+	for _, addressType := range []core.NodeAddressType{core.NodeExternalIP, core.NodeExternalDNS} {
+		for _, address := range node.Status.Addresses {
+			if address.Type == addressType {
+				return address.Address
+			}
+		}
+	}
+	return ""
+}
+
+// checkTCPConnectivity attempts to establish a TCP connection to the given host:port target, waiting up to the given
+// timeout, and returns an error if no connection could be established
+func checkTCPConnectivity(target string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", target, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// exporterMetrics are the WMCO metrics of a node's instance, as served by its windows_exporter
+type exporterMetrics struct {
+	// serviceTerminations maps the WMCO-managed services of the instance to their number of unexpected terminations
+	serviceTerminations map[string]float64
+	// hnsIPUsage is the usage of the subnet of the hybrid overlay HNS network of the instance, nil if not served
+	hnsIPUsage *windows.HNSNetworkIPUsage
+}
+
+// scrapeWindowsExporter scrapes the metrics of the windows_exporter serving on the given host:port target over HTTPS,
+// waiting up to the given timeout, and returns the WMCO metrics found in the response. An error is returned if the
+// exporter does not serve the given PEM encoded certificate, if the certificate has expired, if the scrape is not
+// successful, or if the response has no windows_exporter metrics.
+func scrapeWindowsExporter(target string, servingCert []byte, timeout time.Duration) (*exporterMetrics, error) {
+	block, _ := pem.Decode(servingCert)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM encoded certificate in secret %s", secrets.TLSSecret)
+	}
+	httpClient := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			// The service CA the certificate is verified against by Prometheus is not available to the operator, so
+			// the served certificate is instead compared to the one the exporter should serve
+			InsecureSkipVerify: true,
+			VerifyConnection: func(state tls.ConnectionState) error {
+				if len(state.PeerCertificates) == 0 || !bytes.Equal(state.PeerCertificates[0].Raw, block.Bytes) {
+					return fmt.Errorf("served certificate does not match the certificate of secret %s",
+						secrets.TLSSecret)
+				}
+				if notAfter := state.PeerCertificates[0].NotAfter; time.Now().After(notAfter) {
+					return fmt.Errorf("served certificate expired at %s", notAfter.Format(time.RFC3339))
+				}
+				return nil
+			},
+		}},
+	}
+	resp, err := httpClient.Get("https://" + target + "/metrics")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %w", err)
+	}
+	hasExporterMetrics := false
+	for name := range families {
+		if strings.HasPrefix(name, windowsExporterMetricPrefix) {
+			hasExporterMetrics = true
+			break
+		}
+	}
+	if !hasExporterMetrics {
+		return nil, fmt.Errorf("response has no %s metrics", windowsExporterMetricPrefix)
+	}
+	// the WMCO metrics are written as gauges, each with a single label
+	scraped := &exporterMetrics{serviceTerminations: make(map[string]float64)}
+	for _, m := range families[windows.ServiceTerminationsMetric].GetMetric() {
+		for _, label := range m.GetLabel() {
+			if label.GetName() == "service" {
+				scraped.serviceTerminations[label.GetValue()] = m.GetGauge().GetValue()
+			}
+		}
+	}
+	usage := &windows.HNSNetworkIPUsage{}
+	var hasSize, hasUsed bool
+	for _, m := range families[windows.HNSSubnetSizeMetric].GetMetric() {
+		for _, label := range m.GetLabel() {
+			if label.GetName() == "subnet" {
+				usage.Subnet, usage.Size, hasSize = label.GetValue(), int(m.GetGauge().GetValue()), true
+			}
+		}
+	}
+	for _, m := range families[windows.HNSSubnetAddressesUsedMetric].GetMetric() {
+		for _, label := range m.GetLabel() {
+			if label.GetName() == "subnet" && label.GetValue() == usage.Subnet {
+				usage.Used, hasUsed = int(m.GetGauge().GetValue()), true
+			}
+		}
+	}
+	if hasSize && hasUsed {
+		scraped.hnsIPUsage = usage
+	}
+	return scraped, nil
+}
+
 // isWindowsNode returns true if the given object is a Windows node
 func isWindowsNode(obj runtime.Object) bool {
 	node, ok := obj.(*core.Node)
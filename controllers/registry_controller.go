@@ -34,7 +34,6 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"github.com/openshift/windows-machine-config-operator/pkg/cluster"
-	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig"
 	"github.com/openshift/windows-machine-config-operator/pkg/registries"
 	"github.com/openshift/windows-machine-config-operator/pkg/secrets"
 	"github.com/openshift/windows-machine-config-operator/pkg/signer"
@@ -64,12 +63,12 @@ func NewRegistryReconciler(mgr manager.Manager, clusterConfig cluster.Config,
 
 	return &registryReconciler{
 		instanceReconciler: instanceReconciler{
-			client:             mgr.GetClient(),
-			log:                ctrl.Log.WithName("controllers").WithName(RegistryController),
-			k8sclientset:       clientset,
-			clusterServiceCIDR: clusterConfig.Network().GetServiceCIDR(),
-			watchNamespace:     watchNamespace,
-			recorder:           mgr.GetEventRecorderFor(RegistryController),
+			client:              mgr.GetClient(),
+			log:                 ctrl.Log.WithName("controllers").WithName(RegistryController),
+			k8sclientset:        clientset,
+			clusterServiceCIDRs: clusterConfig.Network().GetServiceCIDRs(),
+			watchNamespace:      watchNamespace,
+			recorder:            mgr.GetEventRecorderFor(RegistryController),
 		},
 	}, nil
 }
@@ -95,26 +94,30 @@ func (r *registryReconciler) Reconcile(ctx context.Context, req ctrl.Request) (r
 		return ctrl.Result{}, fmt.Errorf("unable to create signer from private key secret: %w", err)
 	}
 	for _, node := range nodes.Items {
-		winInstance, err := r.instanceFromNode(&node)
-		if err != nil {
-			return ctrl.Result{}, fmt.Errorf("unable to create instance object from node: %w", err)
+		if isExternallyManaged(&node) {
+			continue
 		}
-		nc, err := nodeconfig.NewNodeConfig(r.client, r.k8sclientset, r.clusterServiceCIDR, r.watchNamespace,
-			winInstance, r.signer, nil, nil, r.platform)
-		if err != nil {
-			return ctrl.Result{}, fmt.Errorf("failed to create new nodeconfig: %w", err)
-		}
-
-		r.log.Info("updating containerd config", "directory", windows.ContainerdConfigDir, "node", node.Name)
 		// TODO: If this flakes for any one node, we have to loop over all nodes again and re-transfer the directory to
 		// all nodes. We should fix this as part of https://issues.redhat.com/browse/WINC-1306
-		if err := nc.Windows.ReplaceDir(configFiles, windows.ContainerdConfigDir); err != nil {
+		if err := r.transferRegistryConfig(node, configFiles); err != nil {
 			return ctrl.Result{}, err
 		}
 	}
 	return ctrl.Result{}, nil
 }
 
+// transferRegistryConfig replaces the containerd registry config directory on the instance associated with the given
+// node with the given files
+func (r *registryReconciler) transferRegistryConfig(node core.Node, configFiles map[string][]byte) error {
+	nc, err := r.nodeConfigFromNode(&node, r.signer)
+	if err != nil {
+		return err
+	}
+	defer r.closeNodeConfig(nc)
+	r.log.Info("updating containerd config", "directory", windows.ContainerdConfigDir, "node", node.Name)
+	return nc.Windows.ReplaceDir(configFiles, windows.ContainerdConfigDir)
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *registryReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	mirrorSetPredicate := predicate.Funcs{
@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	core "k8s.io/api/core/v1"
+	nodev1 "k8s.io/api/node/v1"
+	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
+	kubeTypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
+	"github.com/openshift/windows-machine-config-operator/pkg/runtimeclass"
+	"github.com/openshift/windows-machine-config-operator/pkg/settings"
+)
+
+//+kubebuilder:rbac:groups="node.k8s.io",resources=runtimeclasses,verbs=list;watch
+
+const (
+	// RuntimeClassController is the name of this controller in logs and other outputs.
+	RuntimeClassController = "runtimeclass"
+)
+
+// runtimeClassReconciler manages a RuntimeClass for each Windows build of the configured Windows nodes, when enabled
+// through the settings ConfigMap. RuntimeClasses which were not created by WMCO are never changed.
+type runtimeClassReconciler struct {
+	instanceReconciler
+}
+
+// NewRuntimeClassReconciler returns a pointer to a new runtimeClassReconciler
+func NewRuntimeClassReconciler(mgr manager.Manager, watchNamespace string) *runtimeClassReconciler {
+	return &runtimeClassReconciler{
+		instanceReconciler: instanceReconciler{
+			client:         mgr.GetClient(),
+			log:            ctrl.Log.WithName("controllers").WithName(RuntimeClassController),
+			watchNamespace: watchNamespace,
+			recorder:       mgr.GetEventRecorderFor(RuntimeClassController),
+		},
+	}
+}
+
+// Reconcile ensures a RuntimeClass exists for each Windows build of the configured Windows nodes if RuntimeClasses
+// are managed, and removes the RuntimeClasses created by WMCO which are no longer needed. The request is not used, as
+// the RuntimeClasses of all builds are always considered together.
+func (r *runtimeClassReconciler) Reconcile(ctx context.Context, _ ctrl.Request) (ctrl.Result, error) {
+	s, err := settings.Get(ctx, r.client, r.watchNamespace)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	var builds []string
+	if s.ManageRuntimeClasses {
+		nodes := &core.NodeList{}
+		if err = r.client.List(ctx, nodes, client.MatchingLabels{core.LabelOSStable: "windows"}); err != nil {
+			return ctrl.Result{}, fmt.Errorf("error listing Windows nodes: %w", err)
+		}
+		builds = windowsBuilds(nodes.Items)
+	}
+
+	managed := &nodev1.RuntimeClassList{}
+	if err = r.client.List(ctx, managed, client.HasLabels{runtimeclass.BuildLabel}); err != nil {
+		return ctrl.Result{}, fmt.Errorf("error listing RuntimeClasses: %w", err)
+	}
+	for _, stale := range staleRuntimeClasses(managed.Items, builds) {
+		if err = r.client.Delete(ctx, &stale); err != nil && !k8sapierrors.IsNotFound(err) {
+			return ctrl.Result{}, fmt.Errorf("unable to delete RuntimeClass %s: %w", stale.GetName(), err)
+		}
+		r.log.Info("Deleted resource", "RuntimeClass", stale.GetName())
+	}
+
+	for _, build := range builds {
+		if err = r.ensureRuntimeClass(ctx, build); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+// ensureRuntimeClass ensures the RuntimeClass of the given Windows build exists as expected. Creates it if it doesn't
+// exist, deletes and re-creates it if it was created by WMCO and has an improper spec, as the handler of a
+// RuntimeClass cannot be changed. A RuntimeClass of the same name which was not created by WMCO is left as is.
+func (r *runtimeClassReconciler) ensureRuntimeClass(ctx context.Context, build string) error {
+	expectedRC := runtimeclass.New(build)
+	existingRC := &nodev1.RuntimeClass{}
+	err := r.client.Get(ctx, kubeTypes.NamespacedName{Name: expectedRC.GetName()}, existingRC)
+	if err != nil && !k8sapierrors.IsNotFound(err) {
+		return fmt.Errorf("unable to get RuntimeClass %s: %w", expectedRC.GetName(), err)
+	}
+	if err == nil {
+		if !runtimeclass.IsManaged(existingRC) {
+			r.log.V(1).Info("not managing user created resource", "RuntimeClass", existingRC.GetName())
+			return nil
+		}
+		if existingRC.GetLabels()[runtimeclass.BuildLabel] == build && existingRC.Handler == expectedRC.Handler &&
+			reflect.DeepEqual(existingRC.Scheduling, expectedRC.Scheduling) {
+			return nil
+		}
+		if err = r.client.Delete(ctx, existingRC); err != nil && !k8sapierrors.IsNotFound(err) {
+			return fmt.Errorf("unable to delete RuntimeClass %s: %w", existingRC.GetName(), err)
+		}
+		r.log.Info("Deleted malformed resource", "RuntimeClass", existingRC.GetName(), "Handler",
+			existingRC.Handler)
+	}
+	if err = r.client.Create(ctx, expectedRC); err != nil {
+		return fmt.Errorf("unable to create RuntimeClass %s: %w", expectedRC.GetName(), err)
+	}
+	r.log.Info("Created resource", "RuntimeClass", expectedRC.GetName(), "build", build)
+	return nil
+}
+
+// windowsBuilds returns the sorted, unique Windows builds of the given nodes which have been configured by WMCO, as
+// given by their node.kubernetes.io/windows-build label
+func windowsBuilds(nodes []core.Node) []string {
+	builds := sets.NewString()
+	for _, node := range nodes {
+		if _, configured := node.GetAnnotations()[metadata.VersionAnnotation]; !configured {
+			continue
+		}
+		if build := node.GetLabels()[core.LabelWindowsBuild]; build != "" {
+			builds.Insert(build)
+		}
+	}
+	return builds.List()
+}
+
+// staleRuntimeClasses returns the given RuntimeClasses created by WMCO whose Windows build is not one of the given
+// builds
+func staleRuntimeClasses(runtimeClasses []nodev1.RuntimeClass, builds []string) []nodev1.RuntimeClass {
+	expected := sets.NewString(builds...)
+	var stale []nodev1.RuntimeClass
+	for _, rc := range runtimeClasses {
+		if runtimeclass.IsManaged(&rc) && !expected.Has(rc.GetLabels()[runtimeclass.BuildLabel]) {
+			stale = append(stale, rc)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i].GetName() < stale[j].GetName() })
+	return stale
+}
+
+// mapToSettingsConfigMap fulfills the MapFn type, while always returning a request to the settings ConfigMap
+func (r *runtimeClassReconciler) mapToSettingsConfigMap(_ context.Context, _ client.Object) []reconcile.Request {
+	return []reconcile.Request{{
+		NamespacedName: kubeTypes.NamespacedName{Namespace: r.watchNamespace, Name: settings.ConfigMap},
+	}}
+}
+
+// SetupWithManager sets up the controller with the Manager
+func (r *runtimeClassReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	buildChangePredicate := predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return isWindowsNode(e.Object)
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return isWindowsNode(e.ObjectNew) &&
+				(e.ObjectOld.GetLabels()[core.LabelWindowsBuild] != e.ObjectNew.GetLabels()[core.LabelWindowsBuild] ||
+					e.ObjectOld.GetAnnotations()[metadata.VersionAnnotation] !=
+						e.ObjectNew.GetAnnotations()[metadata.VersionAnnotation])
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return isWindowsNode(e.Object)
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return isWindowsNode(e.Object)
+		},
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(RuntimeClassController).
+		For(&core.ConfigMap{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return obj.GetNamespace() == r.watchNamespace && obj.GetName() == settings.ConfigMap
+		}))).
+		Watches(&core.Node{}, handler.EnqueueRequestsFromMapFunc(r.mapToSettingsConfigMap),
+			builder.WithPredicates(buildChangePredicate)).
+		Watches(&nodev1.RuntimeClass{}, handler.EnqueueRequestsFromMapFunc(r.mapToSettingsConfigMap),
+			builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+				_, managed := obj.GetLabels()[runtimeclass.BuildLabel]
+				return managed
+			}))).
+		Complete(r)
+}
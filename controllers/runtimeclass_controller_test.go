@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+	nodev1 "k8s.io/api/node/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
+	"github.com/openshift/windows-machine-config-operator/pkg/runtimeclass"
+)
+
+// newBuildNode returns a Windows node of the given build, configured by WMCO if configured is true
+func newBuildNode(name, build string, configured bool) core.Node {
+	node := core.Node{ObjectMeta: meta.ObjectMeta{
+		Name:   name,
+		Labels: map[string]string{core.LabelOSStable: "windows"},
+	}}
+	if build != "" {
+		node.Labels[core.LabelWindowsBuild] = build
+	}
+	if configured {
+		node.Annotations = map[string]string{metadata.VersionAnnotation: "1.0.0"}
+	}
+	return node
+}
+
+func TestWindowsBuilds(t *testing.T) {
+	testCases := []struct {
+		name     string
+		nodes    []core.Node
+		expected []string
+	}{
+		{
+			name:     "no nodes",
+			nodes:    nil,
+			expected: []string{},
+		},
+		{
+			name: "multiple nodes of the same build",
+			nodes: []core.Node{newBuildNode("node1", "10.0.20348", true),
+				newBuildNode("node2", "10.0.20348", true)},
+			expected: []string{"10.0.20348"},
+		},
+		{
+			name: "multiple builds",
+			nodes: []core.Node{newBuildNode("node1", "10.0.20348", true),
+				newBuildNode("node2", "10.0.17763", true)},
+			expected: []string{"10.0.17763", "10.0.20348"},
+		},
+		{
+			name: "unconfigured and unlabeled nodes are ignored",
+			nodes: []core.Node{newBuildNode("node1", "10.0.20348", true),
+				newBuildNode("node2", "10.0.17763", false), newBuildNode("node3", "", true)},
+			expected: []string{"10.0.20348"},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, windowsBuilds(test.nodes))
+		})
+	}
+}
+
+func TestStaleRuntimeClasses(t *testing.T) {
+	user := nodev1.RuntimeClass{ObjectMeta: meta.ObjectMeta{Name: runtimeclass.Name("10.0.17763")}}
+
+	testCases := []struct {
+		name           string
+		runtimeClasses []nodev1.RuntimeClass
+		builds         []string
+		expected       []string
+	}{
+		{
+			name:           "all builds present",
+			runtimeClasses: []nodev1.RuntimeClass{*runtimeclass.New("10.0.17763"), *runtimeclass.New("10.0.20348")},
+			builds:         []string{"10.0.17763", "10.0.20348"},
+			expected:       nil,
+		},
+		{
+			name:           "build no longer present",
+			runtimeClasses: []nodev1.RuntimeClass{*runtimeclass.New("10.0.20348"), *runtimeclass.New("10.0.17763")},
+			builds:         []string{"10.0.20348"},
+			expected:       []string{"windows-10.0.17763"},
+		},
+		{
+			name:           "RuntimeClasses no longer managed",
+			runtimeClasses: []nodev1.RuntimeClass{*runtimeclass.New("10.0.20348"), *runtimeclass.New("10.0.17763")},
+			builds:         nil,
+			expected:       []string{"windows-10.0.17763", "windows-10.0.20348"},
+		},
+		{
+			name:           "user created RuntimeClasses are kept",
+			runtimeClasses: []nodev1.RuntimeClass{user},
+			builds:         nil,
+			expected:       nil,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			var out []string
+			for _, rc := range staleRuntimeClasses(test.runtimeClasses, test.builds) {
+				out = append(out, rc.GetName())
+			}
+			assert.Equal(t, test.expected, out)
+		})
+	}
+}
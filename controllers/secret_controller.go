@@ -24,6 +24,7 @@ import (
 	"github.com/openshift/windows-machine-config-operator/pkg/cluster"
 	"github.com/openshift/windows-machine-config-operator/pkg/condition"
 	"github.com/openshift/windows-machine-config-operator/pkg/crypto"
+	"github.com/openshift/windows-machine-config-operator/pkg/instance"
 	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
 	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig"
 	"github.com/openshift/windows-machine-config-operator/pkg/secrets"
@@ -51,13 +52,13 @@ func NewSecretReconciler(mgr manager.Manager, clusterConfig cluster.Config,
 	reconciler := &SecretReconciler{
 		scheme: mgr.GetScheme(),
 		instanceReconciler: instanceReconciler{
-			client:             mgr.GetClient(),
-			k8sclientset:       clientset,
-			clusterServiceCIDR: clusterConfig.Network().GetServiceCIDR(),
-			log:                ctrl.Log.WithName("controllers").WithName(SecretController),
-			watchNamespace:     watchNamespace,
-			recorder:           mgr.GetEventRecorderFor(SecretController),
-			platform:           clusterConfig.Platform(),
+			client:              mgr.GetClient(),
+			k8sclientset:        clientset,
+			clusterServiceCIDRs: clusterConfig.Network().GetServiceCIDRs(),
+			log:                 ctrl.Log.WithName("controllers").WithName(SecretController),
+			watchNamespace:      watchNamespace,
+			recorder:            mgr.GetEventRecorderFor(SecretController),
+			platform:            clusterConfig.Platform(),
 		},
 	}
 	return reconciler, nil
@@ -238,22 +239,35 @@ func (r *SecretReconciler) reconcileTLSSecret(ctx context.Context) error {
 		return fmt.Errorf("error getting node list: %w", err)
 	}
 	for _, node := range nodes.Items {
-		winInstance, err := r.instanceFromNode(&node)
-		if err != nil {
-			return fmt.Errorf("unable to create instance object from node: %w", err)
-		}
-		nc, err := nodeconfig.NewNodeConfig(r.client, r.k8sclientset, r.clusterServiceCIDR, r.watchNamespace,
-			winInstance, r.signer, nil, nil, r.platform)
-		if err != nil {
-			return fmt.Errorf("failed to create new nodeconfig: %w", err)
+		if isExternallyManaged(&node) {
+			continue
 		}
-		if err = nc.Windows.ReplaceDir(certFiles, windows.TLSCertsPath); err != nil {
-			return fmt.Errorf("unable to transfer TLS certs: %w", err)
+		if err := r.transferTLSCerts(node, certFiles); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// transferTLSCerts replaces the TLS certificate directory on the instance associated with the given node with the
+// given files
+func (r *SecretReconciler) transferTLSCerts(node core.Node, certFiles map[string][]byte) error {
+	nc, err := r.nodeConfigFromNode(&node, r.signer)
+	if err != nil {
+		return fmt.Errorf("unable to connect to the instance of node %s: %w", node.Name, err)
+	}
+	defer r.closeNodeConfig(nc)
+	if err = nc.Windows.ReplaceDir(certFiles, windows.TLSCertsPath); err != nil {
+		return fmt.Errorf("unable to transfer TLS certs: %w", err)
+	}
+	// the replaced key no longer has the restricted ACL it was given when the node was configured
+	if err = nc.Windows.EnsureFileACL(windows.TLSKeyPath, windows.AdministratorsSID,
+		windows.CredentialFileSIDs); err != nil {
+		return fmt.Errorf("unable to restrict access to TLS key: %w", err)
+	}
+	return nil
+}
+
 // updateUserData updates the userdata secret to the expected state
 func (r *SecretReconciler) updateUserData(ctx context.Context, keySigner ssh.Signer, expected *core.Secret) error {
 	nodes := &core.NodeList{}
@@ -270,6 +284,10 @@ func (r *SecretReconciler) updateUserData(ctx context.Context, keySigner ssh.Sig
 		return err
 	}
 	for _, node := range nodes.Items {
+		// externally managed nodes are no longer accessed by WMCO, so they keep the key they were configured with
+		if isExternallyManaged(&node) {
+			continue
+		}
 		annotationsToApply := make(map[string]string)
 		if _, present := node.GetLabels()[BYOHLabel]; present {
 			// Since the public key hash and username annotations are both dependent on the private key secret as well
@@ -317,7 +335,8 @@ func (r *SecretReconciler) getEncryptedUsername(ctx context.Context, node core.N
 		Name: wiparser.InstanceConfigMap}, instancesConfigMap); err != nil {
 		return "", fmt.Errorf("unable to get instance configmap: %w", err)
 	}
-	instanceUsername, err := wiparser.GetNodeUsername(instancesConfigMap.Data, &node)
+	instanceUsername, err := wiparser.GetNodeUsername(instancesConfigMap.Data, &node,
+		instance.DefaultUsername(r.platform))
 	if err != nil {
 		return "", err
 	}
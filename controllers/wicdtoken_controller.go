@@ -0,0 +1,211 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/cluster"
+	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/retry"
+)
+
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=delete
+
+const (
+	// WICDTokenController is the name of this controller in logs and other outputs.
+	WICDTokenController = "wicdtoken"
+	// wicdTokenRotationInterval is how long a WICD ServiceAccount token is used before it is replaced
+	wicdTokenRotationInterval = 30 * 24 * time.Hour
+)
+
+// wicdTokenReconciler periodically rotates the WICD ServiceAccount token. A rotation creates a new token secret,
+// waits for the node controller to give every Windows node a WICD kubeconfig using the new token, and only then
+// deletes the previous token secret, so that WICD is never left with an invalidated token.
+type wicdTokenReconciler struct {
+	instanceReconciler
+}
+
+// NewWICDTokenReconciler returns a pointer to a new wicdTokenReconciler
+func NewWICDTokenReconciler(mgr manager.Manager, clusterConfig cluster.Config,
+	watchNamespace string) (*wicdTokenReconciler, error) {
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return nil, fmt.Errorf("error creating kubernetes clientset: %w", err)
+	}
+
+	return &wicdTokenReconciler{
+		instanceReconciler: instanceReconciler{
+			client:              mgr.GetClient(),
+			log:                 ctrl.Log.WithName("controllers").WithName(WICDTokenController),
+			k8sclientset:        clientset,
+			clusterServiceCIDRs: clusterConfig.Network().GetServiceCIDRs(),
+			watchNamespace:      watchNamespace,
+			recorder:            mgr.GetEventRecorderFor(WICDTokenController),
+			platform:            clusterConfig.Platform(),
+		},
+	}, nil
+}
+
+// Reconcile rotates the WICD ServiceAccount token once it is older than the rotation interval, and completes any
+// rotation in progress. The request is not used, as both token secrets are always considered together.
+func (r *wicdTokenReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	r.log = r.log.WithValues(WICDTokenController, req.NamespacedName)
+
+	tokenSecrets, err := nodeconfig.ListWICDTokenSecrets(ctx, r.client, r.watchNamespace)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if len(tokenSecrets) == 0 {
+		// The first token is created when the first instance is configured
+		return ctrl.Result{}, nil
+	}
+	newest := tokenSecrets[0]
+
+	if len(tokenSecrets) > 1 {
+		done, err := r.allNodesUseToken(ctx, newest.GetName())
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if !done {
+			// Nodes are updated by the node controller, check back until all of them use the new token
+			return ctrl.Result{RequeueAfter: retry.Interval}, nil
+		}
+		for _, old := range tokenSecrets[1:] {
+			if err = r.client.Delete(ctx, &old); err != nil && !k8sapierrors.IsNotFound(err) {
+				return ctrl.Result{}, fmt.Errorf("error deleting previous WICD token secret %s: %w", old.GetName(),
+					err)
+			}
+			r.log.Info("deleted previous WICD token", "secret", old.GetName())
+		}
+	}
+
+	if err = r.removeOrphanedTokenSecrets(ctx, newest.GetName()); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if age := time.Since(newest.GetCreationTimestamp().Time); age < wicdTokenRotationInterval {
+		return ctrl.Result{RequeueAfter: wicdTokenRotationInterval - age}, nil
+	}
+
+	// Rotate to the token secret name which is not in use. Any secret still holding that name is invalid, and must
+	// be removed before the name can be reused.
+	next := nodeconfig.WICDTokenSecretNames[0]
+	if newest.GetName() == next {
+		next = nodeconfig.WICDTokenSecretNames[1]
+	}
+	invalid := &core.Secret{ObjectMeta: meta.ObjectMeta{Name: next, Namespace: r.watchNamespace}}
+	if err = r.client.Delete(ctx, invalid); err != nil && !k8sapierrors.IsNotFound(err) {
+		return ctrl.Result{}, fmt.Errorf("error deleting invalid WICD token secret %s: %w", next, err)
+	}
+	if _, err = nodeconfig.CreateWICDTokenSecret(ctx, r.client, r.k8sclientset, r.watchNamespace,
+		next); err != nil {
+		return ctrl.Result{}, err
+	}
+	r.log.Info("rotating WICD token", "previous", newest.GetName(), "new", next)
+	return ctrl.Result{RequeueAfter: retry.Interval}, nil
+}
+
+// removeOrphanedTokenSecrets deletes the WICD ServiceAccount token secrets labeled as created by WMCO which are neither
+// the secret with the given name, the newest token, nor in use by any Windows node. Such secrets can be left behind
+// when a token secret is created but a failure prevents it from being given to nodes.
+func (r *wicdTokenReconciler) removeOrphanedTokenSecrets(ctx context.Context, newest string) error {
+	tokenSecrets := &core.SecretList{}
+	if err := r.client.List(ctx, tokenSecrets, client.InNamespace(r.watchNamespace),
+		client.HasLabels{metadata.WICDTokenSecretLabel}); err != nil {
+		return fmt.Errorf("error listing secrets: %w", err)
+	}
+	nodes := &core.NodeList{}
+	if err := r.client.List(ctx, nodes, client.MatchingLabels{core.LabelOSStable: "windows"}); err != nil {
+		return fmt.Errorf("error listing Windows nodes: %w", err)
+	}
+	for _, orphan := range orphanedTokenSecrets(tokenSecrets.Items, nodes.Items, newest) {
+		if err := r.client.Delete(ctx, &orphan); err != nil && !k8sapierrors.IsNotFound(err) {
+			return fmt.Errorf("error deleting orphaned WICD token secret %s: %w", orphan.GetName(), err)
+		}
+		r.log.Info("deleted orphaned WICD token", "secret", orphan.GetName())
+	}
+	return nil
+}
+
+// orphanedTokenSecrets returns the given secrets which are WICD ServiceAccount token secrets labeled as created by
+// WMCO, other than the secret with the given name, and which are not in use by any of the given nodes. Nodes
+// configured before tokens were tracked are treated as using the original token secret. Secrets without the label,
+// such as secrets of the same name created by an earlier WMCO version or by a user, and secrets with owners are never
+// returned, as WMCO cannot tell it owns them.
+func orphanedTokenSecrets(tokenSecrets []core.Secret, nodes []core.Node, newest string) []core.Secret {
+	inUse := sets.NewString(newest)
+	for _, node := range nodes {
+		annotations := node.GetAnnotations()
+		if secretName, tracked := annotations[metadata.WICDTokenAnnotation]; tracked {
+			inUse.Insert(secretName)
+		} else if _, configured := annotations[metadata.VersionAnnotation]; configured {
+			inUse.Insert(nodeconfig.WICDTokenSecretNames[0])
+		}
+	}
+	var orphans []core.Secret
+	for _, secret := range tokenSecrets {
+		_, labeled := secret.GetLabels()[metadata.WICDTokenSecretLabel]
+		if !labeled || !nodeconfig.ValidWICDServiceAccountTokenSecret(secret) || inUse.Has(secret.GetName()) ||
+			len(secret.GetOwnerReferences()) > 0 {
+			continue
+		}
+		orphans = append(orphans, secret)
+	}
+	return orphans
+}
+
+// allNodesUseToken returns true if the WICD kubeconfig of every configured Windows node has been generated from the
+// token secret with the given name. Nodes configured before tokens were tracked are treated as using an old token, and
+// externally managed nodes are not considered.
+func (r *wicdTokenReconciler) allNodesUseToken(ctx context.Context, secretName string) (bool, error) {
+	nodes := &core.NodeList{}
+	if err := r.client.List(ctx, nodes, client.MatchingLabels{core.LabelOSStable: "windows"}); err != nil {
+		return false, fmt.Errorf("error listing Windows nodes: %w", err)
+	}
+	for _, node := range nodes.Items {
+		// externally managed nodes are not given new tokens, they keep using the token they were configured with
+		if isExternallyManaged(&node) {
+			continue
+		}
+		annotations := node.GetAnnotations()
+		_, configured := annotations[metadata.VersionAnnotation]
+		inUse, tracked := annotations[metadata.WICDTokenAnnotation]
+		if (configured || tracked) && inUse != secretName {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// SetupWithManager sets up the controller with the Manager
+func (r *wicdTokenReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(WICDTokenController).
+		For(&core.Secret{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return isWICDTokenSecret(obj, r.watchNamespace)
+		}))).
+		Complete(r)
+}
+
+// isWICDTokenSecret returns true if the given object is one of the WICD ServiceAccount token secrets created by WMCO
+func isWICDTokenSecret(obj client.Object, namespace string) bool {
+	if obj.GetNamespace() != namespace {
+		return false
+	}
+	_, labeled := obj.GetLabels()[metadata.WICDTokenSecretLabel]
+	return labeled || slices.Contains(nodeconfig.WICDTokenSecretNames, obj.GetName())
+}
@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
+	"github.com/openshift/windows-machine-config-operator/pkg/windows"
+)
+
+// newTokenSecret returns a WICD ServiceAccount token secret with the given name, labeled as created by WMCO if
+// labeled is true
+func newTokenSecret(name string, labeled bool) core.Secret {
+	secret := core.Secret{
+		ObjectMeta: meta.ObjectMeta{
+			Name:        name,
+			Annotations: map[string]string{core.ServiceAccountNameKey: windows.WicdServiceName},
+		},
+		Type: core.SecretTypeServiceAccountToken,
+	}
+	if labeled {
+		secret.Labels = map[string]string{metadata.WICDTokenSecretLabel: ""}
+	}
+	return secret
+}
+
+// newTokenNode returns a configured Windows node whose WICD kubeconfig was generated from the given token secret. The
+// token is untracked if the secret name is empty.
+func newTokenNode(name, secretName string) core.Node {
+	node := core.Node{ObjectMeta: meta.ObjectMeta{
+		Name:        name,
+		Annotations: map[string]string{metadata.VersionAnnotation: "1.0.0"},
+	}}
+	if secretName != "" {
+		node.Annotations[metadata.WICDTokenAnnotation] = secretName
+	}
+	return node
+}
+
+func TestOrphanedTokenSecrets(t *testing.T) {
+	original := windows.WicdServiceName
+	rotated := windows.WicdServiceName + "-rotated"
+	owned := newTokenSecret("owned-token", true)
+	owned.OwnerReferences = []meta.OwnerReference{{Kind: "ServiceAccount", Name: windows.WicdServiceName}}
+	otherSA := newTokenSecret("other-token", true)
+	otherSA.Annotations[core.ServiceAccountNameKey] = "other"
+
+	testCases := []struct {
+		name     string
+		secrets  []core.Secret
+		nodes    []core.Node
+		newest   string
+		expected []string
+	}{
+		{
+			name:     "only the newest token",
+			secrets:  []core.Secret{newTokenSecret(rotated, true)},
+			nodes:    []core.Node{newTokenNode("node1", rotated)},
+			newest:   rotated,
+			expected: nil,
+		},
+		{
+			name: "multiple stale secrets",
+			secrets: []core.Secret{newTokenSecret(rotated, true), newTokenSecret(original, true),
+				newTokenSecret("stale-1", true), newTokenSecret("stale-2", true)},
+			nodes:    []core.Node{newTokenNode("node1", rotated), newTokenNode("node2", rotated)},
+			newest:   rotated,
+			expected: []string{original, "stale-1", "stale-2"},
+		},
+		{
+			name: "secrets in use by nodes are kept",
+			secrets: []core.Secret{newTokenSecret(rotated, true), newTokenSecret(original, true),
+				newTokenSecret("stale", true)},
+			nodes:    []core.Node{newTokenNode("node1", rotated), newTokenNode("node2", original)},
+			newest:   rotated,
+			expected: []string{"stale"},
+		},
+		{
+			name:     "original secret is in use by untracked node",
+			secrets:  []core.Secret{newTokenSecret(rotated, true), newTokenSecret(original, false)},
+			nodes:    []core.Node{newTokenNode("node1", rotated), newTokenNode("node2", "")},
+			newest:   rotated,
+			expected: nil,
+		},
+		{
+			name: "secrets not created by WMCO are kept",
+			secrets: []core.Secret{newTokenSecret(original, false), newTokenSecret("user-token", false), owned,
+				otherSA},
+			nodes:    []core.Node{newTokenNode("node1", original)},
+			newest:   original,
+			expected: nil,
+		},
+		{
+			name:     "unlabeled secret with a WMCO token name",
+			secrets:  []core.Secret{newTokenSecret(rotated, true), newTokenSecret(original, false)},
+			nodes:    []core.Node{newTokenNode("node1", rotated)},
+			newest:   rotated,
+			expected: nil,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			var out []string
+			for _, orphan := range orphanedTokenSecrets(test.secrets, test.nodes, test.newest) {
+				out = append(out, orphan.GetName())
+			}
+			assert.Equal(t, test.expected, out)
+		})
+	}
+}
+
+func TestAllNodesUseToken(t *testing.T) {
+	externallyManaged := newTokenNode("external", "wicd-token-1")
+	externallyManaged.Annotations[metadata.ExternallyManagedAnnotation] = "true"
+	testCases := []struct {
+		name     string
+		nodes    []core.Node
+		expected bool
+	}{
+		{
+			name:     "all nodes use the token",
+			nodes:    []core.Node{newTokenNode("a", "wicd-token-2"), newTokenNode("b", "wicd-token-2")},
+			expected: true,
+		},
+		{
+			name:     "node uses an older token",
+			nodes:    []core.Node{newTokenNode("a", "wicd-token-2"), newTokenNode("b", "wicd-token-1")},
+			expected: false,
+		},
+		{
+			name:     "untracked node",
+			nodes:    []core.Node{newTokenNode("a", "wicd-token-2"), newTokenNode("b", "")},
+			expected: false,
+		},
+		{
+			name:     "externally managed node uses an older token",
+			nodes:    []core.Node{newTokenNode("a", "wicd-token-2"), externallyManaged},
+			expected: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			builder := clientfake.NewClientBuilder()
+			for i := range test.nodes {
+				node := test.nodes[i].DeepCopy()
+				node.Labels = map[string]string{core.LabelOSStable: "windows"}
+				builder = builder.WithObjects(node)
+			}
+			r := &wicdTokenReconciler{instanceReconciler: instanceReconciler{client: builder.Build()}}
+			useToken, err := r.allNodesUseToken(context.Background(), "wicd-token-2")
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, useToken)
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sort"
 	"strings"
 
 	oconfig "github.com/openshift/api/config/v1"
@@ -15,6 +16,7 @@ import (
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	kubeTypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/kubernetes"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
@@ -55,6 +57,8 @@ const (
 	WindowsMachineController = "windowsmachine"
 	// IgnoreLabel is a label that will cause machines to be ignored by the Windows Machine controller
 	IgnoreLabel = "windowsmachineconfig.openshift.io/ignore"
+	// wmcoMetadataDomain is the domain of the labels and annotations WMCO manages on nodes
+	wmcoMetadataDomain = "windowsmachineconfig.openshift.io"
 )
 
 // WindowsMachineReconciler is used to create a controller which manages Windows Machine objects
@@ -93,7 +97,7 @@ func NewWindowsMachineReconciler(mgr manager.Manager, clusterConfig cluster.Conf
 			client:               mgr.GetClient(),
 			log:                  ctrl.Log.WithName("controller").WithName(WindowsMachineController),
 			k8sclientset:         clientset,
-			clusterServiceCIDR:   clusterConfig.Network().GetServiceCIDR(),
+			clusterServiceCIDRs:  clusterConfig.Network().GetServiceCIDRs(),
 			recorder:             mgr.GetEventRecorderFor(WindowsMachineController),
 			watchNamespace:       watchNamespace,
 			prometheusNodeConfig: pc,
@@ -344,7 +348,8 @@ func (r *WindowsMachineReconciler) Reconcile(ctx context.Context,
 
 	log.Info("processing", "address", ipAddress)
 	// Configure the Machine as an up-to-date Windows Worker node
-	if err := r.configureMachine(ipAddress, instanceID, machine.Name, node); err != nil {
+	configurationID, err := r.configureMachine(ipAddress, providerID, instanceID, machine, node)
+	if err != nil {
 		var authErr *windows.AuthErr
 		if errors.As(err, &authErr) {
 			// SSH authentication errors with the Machine are non recoverable, stemming from a mismatch with the
@@ -354,12 +359,19 @@ func (r *WindowsMachineReconciler) Reconcile(ctx context.Context,
 				"Machine %s authentication failure", machine.Name)
 			return ctrl.Result{}, r.deleteMachine(machine)
 		}
+		var checksumErr *windows.ChecksumMismatchErr
+		if errors.As(err, &checksumErr) {
+			r.recorder.Eventf(machine, core.EventTypeWarning, "PayloadChecksumMismatch",
+				"Machine %s configuration failure%s: %s", machine.Name, configurationSuffix(configurationID),
+				checksumErr.Error())
+			return ctrl.Result{}, err
+		}
 		r.recorder.Eventf(machine, core.EventTypeWarning, "MachineSetupFailure",
-			"Machine %s configuration failure", machine.Name)
+			"Machine %s configuration failure%s", machine.Name, configurationSuffix(configurationID))
 		return ctrl.Result{}, err
 	}
 	r.recorder.Eventf(machine, core.EventTypeNormal, "MachineSetup",
-		"Machine %s configured successfully", machine.Name)
+		"Machine %s configured successfully%s", machine.Name, configurationSuffix(configurationID))
 	// configure Prometheus after a Windows machine is configured as a Node.
 	if err := r.prometheusNodeConfig.Configure(); err != nil {
 		return ctrl.Result{}, fmt.Errorf("unable to configure Prometheus: %w", err)
@@ -385,18 +397,10 @@ func (r *WindowsMachineReconciler) deleteMachine(machine *mapi.Machine) error {
 	return nil
 }
 
-// getDefaultUsername returns the default username for a Windows instance
-func (r *WindowsMachineReconciler) getDefaultUsername() string {
-	// TODO: This should be changed so that the "core" user is used on all platforms for SSH connections.
-	// https://issues.redhat.com/browse/WINC-430
-	if r.platform == oconfig.AzurePlatformType {
-		return "capi"
-	}
-	return "Administrator"
-}
-
 // configureMachine configures the given Windows VM, adding it as a node object to the cluster or upgrading it in place.
-func (r *WindowsMachineReconciler) configureMachine(ipAddress, instanceID, machineName string, node *core.Node) error {
+// The ID of the configuration performed is returned, which is empty if the VM did not need to be configured.
+func (r *WindowsMachineReconciler) configureMachine(ipAddress, providerID, instanceID string, machine *mapi.Machine,
+	node *core.Node) (string, error) {
 	// The name of the Machine must be the same as the hostname of the associated VM. This is currently not true in the
 	// case of vSphere VMs provisioned by MAPI. In case of Linux, ignition was handling it. As we don't have an
 	// equivalent of ignition in Windows, WMCO must correct this by changing the VM's hostname.
@@ -405,30 +409,83 @@ func (r *WindowsMachineReconciler) configureMachine(ipAddress, instanceID, machi
 	// Windows Hostname could be changed in initial customizing, however Nutanix is using the same workflow as with vSphere
 	hostname := ""
 	if r.platform == oconfig.VSpherePlatformType || r.platform == oconfig.NutanixPlatformType {
-		hostname = machineName
+		hostname = machine.GetName()
 	}
-	username := r.getDefaultUsername()
-	instanceInfo, err := instance.NewInfo(ipAddress, username, hostname, false, node)
+	username := instance.DefaultUsername(r.platform)
+	instanceInfo, err := instance.NewInfo(ipAddress, username, hostname, node)
 	if err != nil {
-		return err
+		return "", err
 	}
+	// The Node may not exist yet, so use the provider ID of the Machine
+	instanceInfo.ProviderID = providerID
 	// Get private key to encrypt instance usernames
 	privateKeyBytes, err := secrets.GetPrivateKey(kubeTypes.NamespacedName{Namespace: r.watchNamespace,
 		Name: secrets.PrivateKeySecret}, r.client)
 	if err != nil {
-		return err
+		return "", err
 	}
 	encryptedUsername, err := crypto.EncryptToJSONString(username, privateKeyBytes)
 	if err != nil {
-		return fmt.Errorf("unable to encrypt username for instance %s: %w", instanceInfo.Address, err)
+		return "", fmt.Errorf("unable to encrypt username for instance %s: %w", instanceInfo.Address, err)
+	}
+
+	labels, annotations, dropped := nodeMetadataFromMachine(machine, nil,
+		map[string]string{UsernameAnnotation: encryptedUsername})
+	if len(dropped) > 0 {
+		r.log.Info("ignoring invalid or WMCO-managed node metadata given by Machine", "machine", machine.GetName(),
+			"keys", dropped)
+	}
+	configurationID, err := r.ensureInstanceIsUpToDate(instanceInfo, labels, annotations)
+	if err != nil {
+		return configurationID, fmt.Errorf("unable to configure instance %s: %w", instanceID, err)
 	}
+	return configurationID, nil
+}
+
+// nodeMetadataFromMachine returns the labels and annotations given by the spec.metadata of the Machine, merged with the
+// given WMCO-managed labels and annotations, which take precedence on conflict. Applying these when the node is
+// configured ensures they are present before any workloads are scheduled onto it. Entries of the Machine which are not
+// valid, or whose keys are in the domain of WMCO's own metadata, are dropped and their keys returned.
+func nodeMetadataFromMachine(machine *mapi.Machine, managedLabels,
+	managedAnnotations map[string]string) (map[string]string, map[string]string, []string) {
+	labels, droppedLabels := mergeNodeMetadata(machine.Spec.ObjectMeta.Labels, managedLabels,
+		func(key, value string) bool {
+			return len(validation.IsQualifiedName(key)) == 0 && len(validation.IsValidLabelValue(value)) == 0
+		})
+	annotations, droppedAnnotations := mergeNodeMetadata(machine.Spec.ObjectMeta.Annotations, managedAnnotations,
+		func(key, _ string) bool {
+			return len(validation.IsQualifiedName(strings.ToLower(key))) == 0
+		})
+	return labels, annotations, append(droppedLabels, droppedAnnotations...)
+}
 
-	if err := r.ensureInstanceIsUpToDate(instanceInfo, nil,
-		map[string]string{UsernameAnnotation: encryptedUsername}); err != nil {
-		return fmt.Errorf("unable to configure instance %s: %w", instanceID, err)
+// mergeNodeMetadata returns the entries of fromMachine accepted by isValid, overridden by the entries of managed,
+// along with the sorted keys of the entries of fromMachine which were dropped
+func mergeNodeMetadata(fromMachine, managed map[string]string,
+	isValid func(string, string) bool) (map[string]string, []string) {
+	if len(fromMachine) == 0 {
+		return managed, nil
+	}
+	merged := make(map[string]string, len(fromMachine)+len(managed))
+	var dropped []string
+	for key, value := range fromMachine {
+		if isWMCOMetadataKey(key) || !isValid(key, value) {
+			dropped = append(dropped, key)
+			continue
+		}
+		merged[key] = value
+	}
+	for key, value := range managed {
+		merged[key] = value
 	}
+	sort.Strings(dropped)
+	return merged, dropped
+}
 
-	return nil
+// isWMCOMetadataKey returns true if the given label or annotation key is in the domain of WMCO's own metadata
+func isWMCOMetadataKey(key string) bool {
+	prefix, _, found := strings.Cut(key, "/")
+	return found && (prefix == wmcoMetadataDomain || strings.HasSuffix(prefix, "."+wmcoMetadataDomain))
 }
 
 // validateUserData validates the userData secret. It returns error if the secret doesn`t contain the expected public
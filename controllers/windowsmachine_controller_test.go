@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	mapi "github.com/openshift/api/machine/v1beta1"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	core "k8s.io/api/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -63,3 +64,63 @@ func TestIsValidMachine(t *testing.T) {
 	}
 
 }
+
+func TestNodeMetadataFromMachine(t *testing.T) {
+	managedAnnotations := map[string]string{UsernameAnnotation: "encrypted"}
+	testCases := []struct {
+		name                string
+		machineLabels       map[string]string
+		machineAnnotations  map[string]string
+		managedLabels       map[string]string
+		expectedLabels      map[string]string
+		expectedAnnotations map[string]string
+		expectedDropped     []string
+	}{
+		{
+			name:                "no Machine metadata",
+			expectedAnnotations: managedAnnotations,
+		},
+		{
+			name:               "Machine metadata is merged",
+			machineLabels:      map[string]string{"example.com/zone": "a", "tier": "gpu"},
+			machineAnnotations: map[string]string{"example.com/owner": "team a"},
+			expectedLabels:     map[string]string{"example.com/zone": "a", "tier": "gpu"},
+			expectedAnnotations: map[string]string{"example.com/owner": "team a",
+				UsernameAnnotation: "encrypted"},
+		},
+		{
+			name:                "WMCO-managed entries take precedence",
+			machineLabels:       map[string]string{BYOHLabel: "false", "tier": "gpu"},
+			machineAnnotations:  map[string]string{UsernameAnnotation: "other", "example.com/owner": "team a"},
+			managedLabels:       map[string]string{"tier": "cpu"},
+			expectedLabels:      map[string]string{"tier": "cpu"},
+			expectedAnnotations: map[string]string{"example.com/owner": "team a", UsernameAnnotation: "encrypted"},
+			expectedDropped:     []string{BYOHLabel, UsernameAnnotation},
+		},
+		{
+			name:                "WMCO subdomain",
+			machineAnnotations:  map[string]string{"sub." + wmcoMetadataDomain + "/key": "value"},
+			expectedAnnotations: managedAnnotations,
+			expectedDropped:     []string{"sub." + wmcoMetadataDomain + "/key"},
+		},
+		{
+			name:                "invalid entries",
+			machineLabels:       map[string]string{"invalid key": "a", "valid": "invalid value", "zone": "a"},
+			machineAnnotations:  map[string]string{"-invalid": "value", "example.com/owner": "team a"},
+			expectedLabels:      map[string]string{"zone": "a"},
+			expectedAnnotations: map[string]string{"example.com/owner": "team a", UsernameAnnotation: "encrypted"},
+			expectedDropped:     []string{"invalid key", "valid", "-invalid"},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			machine := &mapi.Machine{}
+			machine.Spec.ObjectMeta.Labels = test.machineLabels
+			machine.Spec.ObjectMeta.Annotations = test.machineAnnotations
+			labels, annotations, dropped := nodeMetadataFromMachine(machine, test.managedLabels, managedAnnotations)
+			assert.Equal(t, test.expectedLabels, labels)
+			assert.Equal(t, test.expectedAnnotations, annotations)
+			assert.Equal(t, test.expectedDropped, dropped)
+		})
+	}
+}
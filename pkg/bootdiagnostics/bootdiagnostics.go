@@ -0,0 +1,89 @@
+package bootdiagnostics
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	config "github.com/openshift/api/config/v1"
+)
+
+// ErrUnavailable is returned when boot diagnostics cannot be retrieved for an instance, either because the platform
+// does not support it or because the instance is not associated with a cloud provider instance
+var ErrUnavailable = errors.New("boot diagnostics unavailable")
+
+// awsProviderIDRegex matches AWS provider IDs, such as aws:///us-east-1a/i-078285fdadccb2eaa, capturing the region
+// the instance's availability zone belongs to and the ID of the instance
+var awsProviderIDRegex = regexp.MustCompile(`^aws:///([a-z]+-[a-z]+-[0-9]+)[a-z0-9-]*/(i-[0-9a-f]+)$`)
+
+// Provider retrieves boot diagnostics of instances from the cloud provider hosting them
+type Provider interface {
+	// Get returns the console output of the instance with the given provider ID
+	Get(ctx context.Context, providerID string) (string, error)
+}
+
+// New returns the Provider for the given platform. Boot diagnostics are unavailable on platforms other than AWS.
+func New(platform *config.PlatformType) Provider {
+	if platform == nil {
+		return &unavailable{}
+	}
+	switch *platform {
+	case config.AWSPlatformType:
+		return &awsProvider{}
+	default:
+		// Azure boot diagnostics require the Azure SDK and credentials which WMCO is not given
+		return &unavailable{}
+	}
+}
+
+// unavailable is the Provider used on platforms where boot diagnostics cannot be retrieved
+type unavailable struct{}
+
+func (p *unavailable) Get(context.Context, string) (string, error) {
+	return "", ErrUnavailable
+}
+
+// awsProvider retrieves the EC2 console output of instances, using the credentials available to the operator pod
+type awsProvider struct{}
+
+func (p *awsProvider) Get(ctx context.Context, providerID string) (string, error) {
+	region, instanceID, err := parseAWSProviderID(providerID)
+	if err != nil {
+		return "", err
+	}
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return "", fmt.Errorf("unable to create AWS session: %w", err)
+	}
+	out, err := ec2.New(sess).GetConsoleOutputWithContext(ctx,
+		&ec2.GetConsoleOutputInput{InstanceId: aws.String(instanceID)})
+	if err != nil {
+		return "", fmt.Errorf("unable to get console output of instance %s: %w", instanceID, err)
+	}
+	if out.Output == nil {
+		return "", nil
+	}
+	output, err := base64.StdEncoding.DecodeString(*out.Output)
+	if err != nil {
+		return "", fmt.Errorf("unable to decode console output of instance %s: %w", instanceID, err)
+	}
+	return string(output), nil
+}
+
+// parseAWSProviderID returns the region and the instance ID referenced by the given AWS provider ID
+func parseAWSProviderID(providerID string) (string, string, error) {
+	if providerID == "" {
+		return "", "", ErrUnavailable
+	}
+	matches := awsProviderIDRegex.FindStringSubmatch(strings.TrimSpace(providerID))
+	if matches == nil {
+		return "", "", fmt.Errorf("invalid AWS provider ID %q", providerID)
+	}
+	return matches[1], matches[2], nil
+}
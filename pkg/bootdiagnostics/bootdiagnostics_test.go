@@ -0,0 +1,66 @@
+package bootdiagnostics
+
+import (
+	"context"
+	"testing"
+
+	config "github.com/openshift/api/config/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAWSProviderID(t *testing.T) {
+	testCases := []struct {
+		name       string
+		providerID string
+		region     string
+		instanceID string
+		expectErr  bool
+	}{
+		{
+			name:       "availability zone",
+			providerID: "aws:///us-east-1e/i-078285fdadccb2eaa",
+			region:     "us-east-1",
+			instanceID: "i-078285fdadccb2eaa",
+		},
+		{
+			name:       "local zone",
+			providerID: "aws:///us-east-1-bos-1a/i-0123456789abcdef0",
+			region:     "us-east-1",
+			instanceID: "i-0123456789abcdef0",
+		},
+		{
+			name:       "empty provider ID",
+			providerID: "",
+			expectErr:  true,
+		},
+		{
+			name:       "provider ID of another platform",
+			providerID: "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm",
+			expectErr:  true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			region, instanceID, err := parseAWSProviderID(test.providerID)
+			if test.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.region, region)
+			assert.Equal(t, test.instanceID, instanceID)
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	aws := config.AWSPlatformType
+	azure := config.AzurePlatformType
+	none := config.NonePlatformType
+	assert.IsType(t, &awsProvider{}, New(&aws))
+	for _, platform := range []*config.PlatformType{nil, &azure, &none} {
+		_, err := New(platform).Get(context.Background(), "provider:///id")
+		assert.ErrorIs(t, err, ErrUnavailable)
+	}
+}
@@ -1,8 +1,11 @@
 package certificates
 
 import (
+	"bytes"
 	"encoding/base64"
+	"encoding/pem"
 	"fmt"
+	"slices"
 
 	core "k8s.io/api/core/v1"
 )
@@ -44,3 +47,33 @@ func GetCAsFromConfigMap(configMap *core.ConfigMap, key string) ([]byte, error)
 		return nil, fmt.Errorf("%s not found in %s/%s", key, configMap.Namespace, configMap.Name)
 	}
 }
+
+// MergeCABundles returns a PEM encoded bundle of the certificates in the given PEM encoded CA bundles, in the order
+// they are first found. Certificates present in more than one bundle are only included once.
+func MergeCABundles(bundles ...[]byte) ([]byte, error) {
+	var merged bytes.Buffer
+	var seen [][]byte
+	for _, bundle := range bundles {
+		for {
+			var block *pem.Block
+			block, bundle = pem.Decode(bundle)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				return nil, fmt.Errorf("unexpected PEM block of type %s in CA bundle", block.Type)
+			}
+			if slices.ContainsFunc(seen, func(cert []byte) bool { return bytes.Equal(cert, block.Bytes) }) {
+				continue
+			}
+			seen = append(seen, block.Bytes)
+			if err := pem.Encode(&merged, block); err != nil {
+				return nil, fmt.Errorf("error encoding certificate: %w", err)
+			}
+		}
+		if len(bytes.TrimSpace(bundle)) != 0 {
+			return nil, fmt.Errorf("CA bundle contains data which is not PEM encoded")
+		}
+	}
+	return merged.Bytes(), nil
+}
@@ -0,0 +1,97 @@
+package certificates
+
+import (
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pemCert returns a PEM encoded certificate block with the given contents
+func pemCert(contents string) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte(contents)})
+}
+
+// bundle returns a CA bundle of the given PEM encoded certificates
+func bundle(certs ...[]byte) []byte {
+	var out []byte
+	for _, cert := range certs {
+		out = append(out, cert...)
+	}
+	return out
+}
+
+func TestMergeCABundles(t *testing.T) {
+	oldCA := pemCert("old-ca")
+	newCA := pemCert("new-ca")
+
+	testCases := []struct {
+		name        string
+		bundles     [][]byte
+		expected    []byte
+		expectedErr bool
+	}{
+		{
+			name:     "no bundles",
+			bundles:  nil,
+			expected: nil,
+		},
+		{
+			name:     "single bundle",
+			bundles:  [][]byte{bundle(oldCA)},
+			expected: bundle(oldCA),
+		},
+		{
+			name:     "identical bundles",
+			bundles:  [][]byte{bundle(oldCA), bundle(oldCA)},
+			expected: bundle(oldCA),
+		},
+		{
+			name:     "rotation started in the ConfigMap",
+			bundles:  [][]byte{bundle(oldCA), bundle(newCA, oldCA)},
+			expected: bundle(oldCA, newCA),
+		},
+		{
+			name:     "rotation synced to the ControllerConfig",
+			bundles:  [][]byte{bundle(newCA, oldCA), bundle(newCA, oldCA)},
+			expected: bundle(newCA, oldCA),
+		},
+		{
+			name:     "old CA removed from the ConfigMap first",
+			bundles:  [][]byte{bundle(newCA, oldCA), bundle(newCA)},
+			expected: bundle(newCA, oldCA),
+		},
+		{
+			name:     "old CA removed from both",
+			bundles:  [][]byte{bundle(newCA), bundle(newCA)},
+			expected: bundle(newCA),
+		},
+		{
+			name:     "empty bundle",
+			bundles:  [][]byte{bundle(oldCA), {}},
+			expected: bundle(oldCA),
+		},
+		{
+			name:        "non certificate PEM block",
+			bundles:     [][]byte{pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: []byte("key")})},
+			expectedErr: true,
+		},
+		{
+			name:        "data which is not PEM encoded",
+			bundles:     [][]byte{bundle(oldCA, []byte("not a certificate"))},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := MergeCABundles(test.bundles...)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, out)
+		})
+	}
+}
@@ -18,7 +18,7 @@ import (
 )
 
 //+kubebuilder:rbac:groups=config.openshift.io,resources=infrastructures,verbs=get
-//+kubebuilder:rbac:groups=config.openshift.io;operator.openshift.io,resources=networks,verbs=get
+//+kubebuilder:rbac:groups=config.openshift.io;operator.openshift.io,resources=networks,verbs=get;list;watch
 
 const (
 	ovnKubernetesNetwork = "OVNKubernetes"
@@ -40,6 +40,9 @@ var (
 type Network interface {
 	Validate() error
 	GetServiceCIDR() string
+	// GetServiceCIDRs returns every service CIDR of the cluster, one per IP family on dual-stack clusters. The first
+	// entry is the CIDR returned by GetServiceCIDR.
+	GetServiceCIDRs() []string
 	VXLANPort() string
 }
 
@@ -162,8 +165,8 @@ func (c *config) Validate() error {
 
 // clusterNetworkCfg struct holds the information for the cluster network
 type clusterNetworkCfg struct {
-	// serviceCIDR holds the value for cluster network service CIDR
-	serviceCIDR string
+	// serviceCIDRs holds the values for cluster network service CIDRs, the primary CIDR being the first
+	serviceCIDRs []string
 	// vxlanPort is the port to be used for VXLAN communication
 	vxlanPort string
 }
@@ -181,9 +184,9 @@ func networkConfigurationFactory(oclient configclient.Interface, operatorClient
 		return nil, fmt.Errorf("error getting cluster network type: %w", err)
 	}
 
-	// retrieve serviceCIDR using cluster config required for cni configurations
-	serviceCIDR, err := getServiceNetworkCIDR(oclient)
-	if err != nil || serviceCIDR == "" {
+	// retrieve serviceCIDRs using cluster config required for cni configurations
+	serviceCIDRs, err := getServiceNetworkCIDRs(oclient)
+	if err != nil {
 		return nil, fmt.Errorf("error getting service network CIDR: %w", err)
 	}
 
@@ -193,7 +196,7 @@ func networkConfigurationFactory(oclient configclient.Interface, operatorClient
 		return nil, fmt.Errorf("error getting the custom vxlan port: %w", err)
 	}
 
-	clusterNetworkCfg, err := NewClusterNetworkCfg(serviceCIDR, vxlanPort)
+	clusterNetworkCfg, err := NewClusterNetworkCfg(serviceCIDRs, vxlanPort)
 	if err != nil {
 		return nil, fmt.Errorf("error getting cluster network config: %w", err)
 	}
@@ -211,21 +214,26 @@ func networkConfigurationFactory(oclient configclient.Interface, operatorClient
 	}
 }
 
-// NewClusterNetworkCfg assigns a serviceCIDR value and returns a pointer to the clusterNetworkCfg struct
-func NewClusterNetworkCfg(serviceCIDR, vxlanPort string) (*clusterNetworkCfg, error) {
-	if serviceCIDR == "" {
-		return nil, fmt.Errorf("can't instantiate cluster network config" +
+// NewClusterNetworkCfg assigns the serviceCIDRs value and returns a pointer to the clusterNetworkCfg struct
+func NewClusterNetworkCfg(serviceCIDRs []string, vxlanPort string) (*clusterNetworkCfg, error) {
+	if len(serviceCIDRs) == 0 || serviceCIDRs[0] == "" {
+		return nil, fmt.Errorf("can't instantiate cluster network config " +
 			"with empty service CIDR value")
 	}
 	return &clusterNetworkCfg{
-		serviceCIDR: serviceCIDR,
-		vxlanPort:   vxlanPort,
+		serviceCIDRs: serviceCIDRs,
+		vxlanPort:    vxlanPort,
 	}, nil
 }
 
-// GetServiceCIDR returns the serviceCIDR string
+// GetServiceCIDR returns the primary serviceCIDR string
 func (ovn *ovnKubernetes) GetServiceCIDR() string {
-	return ovn.clusterNetworkConfig.serviceCIDR
+	return ovn.clusterNetworkConfig.serviceCIDRs[0]
+}
+
+// GetServiceCIDRs returns every serviceCIDR string of the cluster, the primary serviceCIDR being the first
+func (ovn *ovnKubernetes) GetServiceCIDRs() []string {
+	return ovn.clusterNetworkConfig.serviceCIDRs
 }
 
 // GetVXLANPort gets the VXLAN port to be used for VXLAN tunnel establishment
@@ -262,21 +270,23 @@ func getNetworkType(oclient configclient.Interface) (string, error) {
 	return networkCR.Spec.NetworkType, nil
 }
 
-// getServiceNetworkCIDR gets the serviceCIDR using cluster config required for cni configuration
-func getServiceNetworkCIDR(oclient configclient.Interface) (string, error) {
+// getServiceNetworkCIDRs gets the serviceCIDRs using cluster config required for cni configuration. Dual-stack
+// clusters have a service network for each IP family.
+func getServiceNetworkCIDRs(oclient configclient.Interface) ([]string, error) {
 	// Get the cluster network object so that we can find the service network
 	networkCR, err := oclient.ConfigV1().Networks().Get(context.TODO(), "cluster", meta.GetOptions{})
 	if err != nil {
-		return "", fmt.Errorf("error getting cluster network object: %w", err)
+		return nil, fmt.Errorf("error getting cluster network object: %w", err)
 	}
 	if len(networkCR.Spec.ServiceNetwork) == 0 {
-		return "", fmt.Errorf("error getting cluster service CIDR," + "received empty value for service networks")
+		return nil, fmt.Errorf("error getting cluster service CIDR," + "received empty value for service networks")
 	}
-	serviceCIDR := networkCR.Spec.ServiceNetwork[0]
-	if err := ValidateCIDR(serviceCIDR); err != nil {
-		return "", fmt.Errorf("invalid cluster service CIDR: %w", err)
+	for _, serviceCIDR := range networkCR.Spec.ServiceNetwork {
+		if err := ValidateCIDR(serviceCIDR); err != nil {
+			return nil, fmt.Errorf("invalid cluster service CIDR: %w", err)
+		}
 	}
-	return serviceCIDR, nil
+	return networkCR.Spec.ServiceNetwork, nil
 }
 
 // getVXLANPort gets the VXLAN port to establish tunnel as a string. The return type doesn't matter as we want to pass
@@ -323,6 +333,53 @@ func GetDNS(subnet string) (string, error) {
 	return clusterDNS.String(), nil
 }
 
+// GetClusterDNS validates each of the given service subnets and returns the Cluster DNS IP address, derived from the
+// first subnet. The cluster DNS service is only given an address of the primary service network, so on dual-stack
+// clusters the other subnets are only validated. If the given override address is not empty, it is returned in place
+// of the derived address and must be within the primary service network.
+// Example: [172.30.0.0/16, fd02::/112] returns 172.30.0.10
+func GetClusterDNS(subnets []string, override string) (string, error) {
+	if len(subnets) == 0 {
+		return "", fmt.Errorf("no service CIDR given")
+	}
+	for _, subnet := range subnets {
+		if err := ValidateCIDR(subnet); err != nil {
+			return "", err
+		}
+	}
+	if override == "" {
+		clusterDNS, err := GetDNS(subnets[0])
+		if err != nil {
+			return "", fmt.Errorf("error getting cluster DNS from service CIDR %s: %w", subnets[0], err)
+		}
+		return clusterDNS, nil
+	}
+	ip := net.ParseIP(override)
+	if ip == nil {
+		return "", fmt.Errorf("invalid cluster DNS address %s", override)
+	}
+	_, network, err := net.ParseCIDR(subnets[0])
+	if err != nil {
+		return "", err
+	}
+	if !network.Contains(ip) {
+		return "", fmt.Errorf("cluster DNS address %s is not within the primary service network %s", override,
+			subnets[0])
+	}
+	return ip.String(), nil
+}
+
+// HasExternalNodeAddresses returns true if nodes on the given platform can be given external addresses by the cloud
+// provider, through which they are reachable from outside of the cluster network
+func HasExternalNodeAddresses(platform oconfig.PlatformType) bool {
+	switch platform {
+	case oconfig.AWSPlatformType, oconfig.AzurePlatformType, oconfig.GCPPlatformType:
+		return true
+	default:
+		return false
+	}
+}
+
 // IsProxyEnabled returns whether a global egress proxy is active in the cluster
 func IsProxyEnabled() bool {
 	return len(GetProxyVars()) > 0
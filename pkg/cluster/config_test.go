@@ -235,3 +235,86 @@ func TestGetDNS(t *testing.T) {
 		})
 	}
 }
+
+// TestGetClusterDNS tests the DNS server IP generation from the service subnets of single and dual-stack clusters
+func TestGetClusterDNS(t *testing.T) {
+	tests := []struct {
+		name     string
+		subnets  []string
+		override string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:    "no subnets",
+			subnets: nil,
+			wantErr: true,
+		},
+		{
+			name:    "single subnet",
+			subnets: []string{"172.30.0.0/16"},
+			want:    "172.30.0.10",
+		},
+		{
+			name:    "dual-stack subnets",
+			subnets: []string{"172.30.0.0/16", "fd02::/112"},
+			want:    "172.30.0.10",
+		},
+		{
+			name:    "IPv6 primary subnet",
+			subnets: []string{"fd02::/112", "172.30.0.0/16"},
+			want:    "fd02::a",
+		},
+		{
+			name:    "invalid secondary subnet",
+			subnets: []string{"172.30.0.0/16", "invalid"},
+			wantErr: true,
+		},
+		{
+			name:    "no IP in primary subnet",
+			subnets: []string{"fd02::/128", "172.30.0.0/16"},
+			wantErr: true,
+		},
+		{
+			name:     "override within the primary subnet",
+			subnets:  []string{"172.30.0.0/16", "fd02::/112"},
+			override: "172.30.0.53",
+			want:     "172.30.0.53",
+		},
+		{
+			name:     "override within the secondary subnet",
+			subnets:  []string{"172.30.0.0/16", "fd02::/112"},
+			override: "fd02::35",
+			wantErr:  true,
+		},
+		{
+			name:     "override outside of the service subnets",
+			subnets:  []string{"172.30.0.0/16"},
+			override: "10.0.0.10",
+			wantErr:  true,
+		},
+		{
+			name:     "invalid override",
+			subnets:  []string{"172.30.0.0/16"},
+			override: "dns",
+			wantErr:  true,
+		},
+		{
+			name:     "override with invalid subnet",
+			subnets:  []string{"invalid"},
+			override: "172.30.0.53",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetClusterDNS(tt.subnets, tt.override)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
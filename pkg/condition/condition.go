@@ -26,6 +26,11 @@ const (
 	upgradeableTrueMessage  = "The operator is safe for upgrade"
 	upgradeableFalseReason  = "upgradeIsNotSafe"
 	upgradeableFalseMessage = "The operator is currently processing sub-components. At least one controller is busy."
+	// NodeUpgradeProgressing is the type of the condition reporting whether Windows nodes are still being upgraded to
+	// the running WMCO version
+	NodeUpgradeProgressing = "NodeUpgradeProgressing"
+	nodesUpgradingReason   = "NodesUpgrading"
+	nodesUpToDateReason    = "NodesUpToDate"
 	// OperatorConditionName is an environment variable set by OLM identifying the operator's OperatorCondition CR
 	OperatorConditionName = "OPERATOR_CONDITION_NAME"
 )
@@ -120,6 +125,37 @@ func MarkAsBusy(c client.Client, watchNamespace string, recorder record.EventRec
 	return nil
 }
 
+// SetNodeUpgradeProgress sets the NodeUpgradeProgressing condition to report that the given number of the given total
+// of Windows nodes are at the given WMCO version. No-op if operator is not OLM-managed
+func SetNodeUpgradeProgress(c client.Client, watchNamespace string, recorder record.EventRecorder, upToDate,
+	total int, version string) error {
+	if opCondName == "" {
+		return nil
+	}
+
+	opCondBusyControllersLock.Lock()
+	defer opCondBusyControllersLock.Unlock()
+
+	opCond, err := get(c, watchNamespace)
+	if err != nil {
+		recorder.Eventf(opCond, core.EventTypeWarning, "OperatorConditionError",
+			"Failed to get OperatorCondition CR: %v", err)
+		return err
+	}
+	newCond := nodeUpgradeProgressCondition(upToDate, total, version)
+	// If the condition already reports the same progress, no-op to avoid redundant API calls
+	if current := find(opCond.Spec.Conditions, NodeUpgradeProgressing); current != nil &&
+		current.Status == newCond.Status && current.Reason == newCond.Reason && current.Message == newCond.Message {
+		return nil
+	}
+	if err = patch(c, opCond, newCond); err != nil {
+		recorder.Eventf(opCond, core.EventTypeWarning, "OperatorConditionError",
+			"Failed to patch OperatorCondition CR: %v", err)
+		return err
+	}
+	return nil
+}
+
 // Validate checks that the given condition is present and holds the expected status value within the given list
 func Validate(conditions []meta.Condition, conditionType string, expectedStatus meta.ConditionStatus) bool {
 	for _, cond := range conditions {
@@ -132,6 +168,57 @@ func Validate(conditions []meta.Condition, conditionType string, expectedStatus
 
 // interal helper methods
 
+// nodeUpgradeProgressCondition returns the NodeUpgradeProgressing condition reporting that the given number of the
+// given total of Windows nodes are at the given WMCO version
+func nodeUpgradeProgressCondition(upToDate, total int, version string) meta.Condition {
+	if upToDate >= total {
+		return meta.Condition{
+			Type:    NodeUpgradeProgressing,
+			Status:  meta.ConditionFalse,
+			Reason:  nodesUpToDateReason,
+			Message: fmt.Sprintf("All %d Windows nodes are at version %s", total, version),
+		}
+	}
+	return meta.Condition{
+		Type:    NodeUpgradeProgressing,
+		Status:  meta.ConditionTrue,
+		Reason:  nodesUpgradingReason,
+		Message: fmt.Sprintf("%d of %d Windows nodes are at version %s", upToDate, total, version),
+	}
+}
+
+// find returns the condition of the given type within the given list, or nil if there is none
+func find(conditions []meta.Condition, conditionType string) *meta.Condition {
+	for i := range conditions {
+		if conditions[i].Type == conditionType {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+// withCondition returns the given conditions with the given condition added, replacing any condition of the same type.
+// The transition time of the condition is kept if its status is unchanged.
+func withCondition(conditions []meta.Condition, newCond meta.Condition) []meta.Condition {
+	merged := make([]meta.Condition, 0, len(conditions)+1)
+	replaced := false
+	for _, cond := range conditions {
+		if cond.Type != newCond.Type {
+			merged = append(merged, cond)
+			continue
+		}
+		if cond.Status == newCond.Status {
+			newCond.LastTransitionTime = cond.LastTransitionTime
+		}
+		merged = append(merged, newCond)
+		replaced = true
+	}
+	if !replaced {
+		merged = append(merged, newCond)
+	}
+	return merged
+}
+
 // set sets the given condition's values within the given OperatorCondition.
 // Only returns after waiting for a made change to take effect.
 func set(c client.Client, opCond *operators.OperatorCondition, conditionType string, status meta.ConditionStatus,
@@ -161,11 +248,11 @@ func get(c client.Client, watchNamespace string) (*operators.OperatorCondition,
 }
 
 // patch modifies the given condition within the given OperatorCondition object.
-// Creates the Condition if not present, overrides it otherwise
+// Creates the Condition if not present, overrides it otherwise. Conditions of other types are kept.
 func patch(c client.Client, opCond *operators.OperatorCondition, newCond meta.Condition) error {
 	newCond.LastTransitionTime = meta.Now()
 	patchData, err := json.Marshal([]*patcher.JSONPatch{
-		patcher.NewJSONPatch("add", "/spec/conditions", []meta.Condition{newCond})})
+		patcher.NewJSONPatch("add", "/spec/conditions", withCondition(opCond.Spec.Conditions, newCond))})
 	if err != nil {
 		return fmt.Errorf("unable to generate patch request body for Condition %v: %w", newCond, err)
 	}
@@ -2,6 +2,7 @@ package condition
 
 import (
 	"testing"
+	"time"
 
 	operators "github.com/operator-framework/api/pkg/operators/v2"
 	"github.com/stretchr/testify/assert"
@@ -89,3 +90,92 @@ func TestValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestNodeUpgradeProgressCondition(t *testing.T) {
+	testCases := []struct {
+		name           string
+		upToDate       int
+		total          int
+		expectedStatus meta.ConditionStatus
+		expectedMsg    string
+	}{
+		{
+			name:           "no nodes",
+			upToDate:       0,
+			total:          0,
+			expectedStatus: meta.ConditionFalse,
+			expectedMsg:    "All 0 Windows nodes are at version 10.0.0",
+		},
+		{
+			name:           "all nodes upgraded",
+			upToDate:       3,
+			total:          3,
+			expectedStatus: meta.ConditionFalse,
+			expectedMsg:    "All 3 Windows nodes are at version 10.0.0",
+		},
+		{
+			name:           "upgrade in progress",
+			upToDate:       1,
+			total:          3,
+			expectedStatus: meta.ConditionTrue,
+			expectedMsg:    "1 of 3 Windows nodes are at version 10.0.0",
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			cond := nodeUpgradeProgressCondition(test.upToDate, test.total, "10.0.0")
+			assert.Equal(t, NodeUpgradeProgressing, cond.Type)
+			assert.Equal(t, test.expectedStatus, cond.Status)
+			assert.Equal(t, test.expectedMsg, cond.Message)
+		})
+	}
+}
+
+func TestWithCondition(t *testing.T) {
+	earlier := meta.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	now := meta.NewTime(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC))
+	upgradeable := meta.Condition{Type: operators.Upgradeable, Status: meta.ConditionTrue, LastTransitionTime: earlier}
+	progressing := meta.Condition{Type: NodeUpgradeProgressing, Status: meta.ConditionTrue, Message: "1 of 3",
+		LastTransitionTime: earlier}
+
+	testCases := []struct {
+		name     string
+		input    []meta.Condition
+		newCond  meta.Condition
+		expected []meta.Condition
+	}{
+		{
+			name:     "condition added to empty list",
+			input:    nil,
+			newCond:  meta.Condition{Type: operators.Upgradeable, Status: meta.ConditionFalse, LastTransitionTime: now},
+			expected: []meta.Condition{{Type: operators.Upgradeable, Status: meta.ConditionFalse, LastTransitionTime: now}},
+		},
+		{
+			name:     "other conditions are kept",
+			input:    []meta.Condition{upgradeable},
+			newCond:  progressing,
+			expected: []meta.Condition{upgradeable, progressing},
+		},
+		{
+			name:  "status change replaces the condition",
+			input: []meta.Condition{upgradeable, progressing},
+			newCond: meta.Condition{Type: operators.Upgradeable, Status: meta.ConditionFalse,
+				LastTransitionTime: now},
+			expected: []meta.Condition{{Type: operators.Upgradeable, Status: meta.ConditionFalse,
+				LastTransitionTime: now}, progressing},
+		},
+		{
+			name:  "unchanged status keeps the transition time",
+			input: []meta.Condition{upgradeable, progressing},
+			newCond: meta.Condition{Type: NodeUpgradeProgressing, Status: meta.ConditionTrue, Message: "2 of 3",
+				LastTransitionTime: now},
+			expected: []meta.Condition{upgradeable, {Type: NodeUpgradeProgressing, Status: meta.ConditionTrue,
+				Message: "2 of 3", LastTransitionTime: earlier}},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, withCondition(test.input, test.newCond))
+		})
+	}
+}
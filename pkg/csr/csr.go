@@ -17,6 +17,7 @@ import (
 	"strings"
 
 	"github.com/go-logr/logr"
+	config "github.com/openshift/api/config/v1"
 	"golang.org/x/crypto/ssh"
 	certificates "k8s.io/api/certificates/v1"
 	core "k8s.io/api/core/v1"
@@ -30,7 +31,9 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/openshift/windows-machine-config-operator/pkg/instance"
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig"
 	"github.com/openshift/windows-machine-config-operator/pkg/secrets"
+	"github.com/openshift/windows-machine-config-operator/pkg/settings"
 	"github.com/openshift/windows-machine-config-operator/pkg/signer"
 	"github.com/openshift/windows-machine-config-operator/pkg/windows"
 	"github.com/openshift/windows-machine-config-operator/pkg/wiparser"
@@ -84,11 +87,14 @@ type Approver struct {
 	recorder record.EventRecorder
 	// namespace is the namespace in which CSR's are present
 	namespace string
+	// platform is the platform the cluster is running on, used to determine the default username of instances
+	platform config.PlatformType
 }
 
 // NewApprover returns a pointer to the Approver
 func NewApprover(client client.Client, clientSet *kubernetes.Clientset, csr *certificates.CertificateSigningRequest,
-	log logr.Logger, recorder record.EventRecorder, watchNamespace string) (*Approver, error) {
+	log logr.Logger, recorder record.EventRecorder, watchNamespace string,
+	platform config.PlatformType) (*Approver, error) {
 	if client == nil || csr == nil || clientSet == nil {
 		return nil, fmt.Errorf("kubernetes client, clientSet or CSR should not be nil")
 	}
@@ -97,7 +103,8 @@ func NewApprover(client client.Client, clientSet *kubernetes.Clientset, csr *cer
 		csr,
 		log,
 		recorder,
-		watchNamespace}, nil
+		watchNamespace,
+		platform}, nil
 }
 
 // Approve determines if a CSR should be approved by WMCO, and if so, approves it by updating its status. This function
@@ -174,7 +181,7 @@ func (a *Approver) validateCSRContents() (bool, error) {
 			return false, fmt.Errorf("%s node already exists, cannot validate CSR: %s", nodeName, a.csr.Name)
 		}
 	} else {
-		if err := a.validateKubeletServingCSR(parsedCSR); err != nil {
+		if err := a.validateKubeletServingCSR(nodeName, parsedCSR); err != nil {
 			return false, fmt.Errorf("unable to validate kubelet serving CSR: %s: %w", a.csr.Name, err)
 		}
 	}
@@ -186,7 +193,7 @@ func (a *Approver) validateCSRContents() (bool, error) {
 // present in the configMap.
 func (a *Approver) validateNodeName(nodeName string) (bool, error) {
 	// Get the list of instances that are expected to be Nodes
-	windowsInstances, err := wiparser.GetInstances(a.client, a.namespace)
+	windowsInstances, err := wiparser.GetInstances(a.client, a.namespace, instance.DefaultUsername(a.platform))
 	if err != nil {
 		return false, fmt.Errorf("unable to retrieve Windows instances: %w", err)
 	}
@@ -210,8 +217,13 @@ func (a *Approver) validateWithHostName(nodeName string, windowsInstances []*ins
 	if err != nil {
 		return false, fmt.Errorf("unable to create signer from private key secret: %w", err)
 	}
+	s, err := settings.Get(context.TODO(), a.client, a.namespace)
+	if err != nil {
+		return false, err
+	}
 	// check if the node name matches any of the instances host names
-	hasEntry, err := matchesHostname(nodeName, windowsInstances, instanceSigner)
+	hasEntry, err := matchesHostname(nodeName, windowsInstances, instanceSigner, nodeconfig.SSHAlgorithms(s),
+		nodeconfig.SSHHostKeys(s))
 	if err != nil {
 		return false, fmt.Errorf("unable to map node name to the host names of Windows instances: %w", err)
 	}
@@ -232,7 +244,7 @@ func (a *Approver) validateWithHostName(nodeName string, windowsInstances []*ins
 }
 
 // validateKubeletServingCSR validates a kubelet serving CSR for its contents
-func (a *Approver) validateKubeletServingCSR(parsedCsr *x509.CertificateRequest) error {
+func (a *Approver) validateKubeletServingCSR(nodeName string, parsedCsr *x509.CertificateRequest) error {
 	if a.csr == nil || parsedCsr == nil {
 		return fmt.Errorf("CSR or request should not be nil")
 	}
@@ -261,6 +273,45 @@ func (a *Approver) validateKubeletServingCSR(parsedCsr *x509.CertificateRequest)
 	if !hasOrg {
 		return fmt.Errorf("CSR %s does not contain required subject organization", a.csr.Name)
 	}
+
+	// The serving certificate must only be valid for the node's current addresses, which may have changed since the
+	// node was configured
+	node := &core.Node{}
+	if err := a.client.Get(context.TODO(), kubeTypes.NamespacedName{Name: nodeName}, node); err != nil {
+		return fmt.Errorf("unable to get node %s: %w", nodeName, err)
+	}
+	return validateSANs(node, parsedCsr)
+}
+
+// validateSANs returns an error if the given CSR requests a subject alternative name which is not one of the current
+// addresses of the given node. Kubelet requests a DNS name for each hostname and DNS address of its node, and an IP
+// address for each IP address of its node.
+func validateSANs(node *core.Node, parsedCsr *x509.CertificateRequest) error {
+	dnsNames := sets.NewString()
+	ipAddresses := sets.NewString()
+	for _, address := range node.Status.Addresses {
+		switch address.Type {
+		case core.NodeHostName, core.NodeInternalDNS, core.NodeExternalDNS:
+			dnsNames.Insert(address.Address)
+		case core.NodeInternalIP, core.NodeExternalIP:
+			if ip := net.ParseIP(address.Address); ip != nil {
+				ipAddresses.Insert(ip.String())
+			}
+		}
+	}
+	for _, dnsName := range parsedCsr.DNSNames {
+		if !dnsNames.Has(dnsName) {
+			return fmt.Errorf("DNS name %s is not an address of node %s", dnsName, node.GetName())
+		}
+	}
+	for _, ip := range parsedCsr.IPAddresses {
+		if !ipAddresses.Has(ip.String()) {
+			return fmt.Errorf("IP address %s is not an address of node %s", ip, node.GetName())
+		}
+	}
+	if len(parsedCsr.EmailAddresses) > 0 || len(parsedCsr.URIs) > 0 {
+		return fmt.Errorf("only DNS names and IP addresses of node %s are allowed", node.GetName())
+	}
 	return nil
 }
 
@@ -300,10 +351,10 @@ func hasUsages(csr *certificates.CertificateSigningRequest, usages []certificate
 
 // matchesHostname returns true if given node name matches with host name of any of the instances present
 // in the given instance list
-func matchesHostname(nodeName string, windowsInstances []*instance.Info,
-	instanceSigner ssh.Signer) (bool, error) {
+func matchesHostname(nodeName string, windowsInstances []*instance.Info, instanceSigner ssh.Signer,
+	sshAlgorithms *windows.SSHAlgorithms, hostKeys *windows.HostKeyVerification) (bool, error) {
 	for _, instanceInfo := range windowsInstances {
-		hostName, err := findHostName(instanceInfo, instanceSigner)
+		hostName, err := findHostName(instanceInfo, instanceSigner, sshAlgorithms, hostKeys)
 		if err != nil {
 			return false, fmt.Errorf("unable to find host name for instance with address %s: %w",
 				instanceInfo.Address, err)
@@ -317,12 +368,15 @@ func matchesHostname(nodeName string, windowsInstances []*instance.Info,
 }
 
 // findHostName returns the actual host name of the instance by running the 'hostname' command
-func findHostName(instanceInfo *instance.Info, instanceSigner ssh.Signer) (string, error) {
-	// We don't need to pass most args here as we just need to be able to run commands on the instance.
-	win, err := windows.New("", instanceInfo, instanceSigner, nil)
+func findHostName(instanceInfo *instance.Info, instanceSigner ssh.Signer,
+	sshAlgorithms *windows.SSHAlgorithms, hostKeys *windows.HostKeyVerification) (string, error) {
+	// We don't need to pass most options here as we just need to be able to run commands on the instance.
+	win, err := windows.New(instanceInfo, instanceSigner,
+		windows.Options{SSHAlgorithms: sshAlgorithms, HostKeys: hostKeys})
 	if err != nil {
 		return "", fmt.Errorf("error instantiating Windows instance: %w", err)
 	}
+	defer win.Close()
 	// get the instance host name  by running hostname command on remote VM
 	return win.GetHostname()
 }
@@ -1,10 +1,15 @@
 package csr
 
 import (
+	"crypto/x509"
+	"net"
+	"net/url"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/openshift/windows-machine-config-operator/pkg/instance"
 )
@@ -169,3 +174,63 @@ func TestAddressesEqual(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateSANs(t *testing.T) {
+	node := &core.Node{
+		ObjectMeta: meta.ObjectMeta{Name: "winhost"},
+		Status: core.NodeStatus{
+			Addresses: []core.NodeAddress{
+				{Type: core.NodeHostName, Address: "winhost"},
+				{Type: core.NodeInternalDNS, Address: "winhost.example.com"},
+				{Type: core.NodeInternalIP, Address: "10.0.0.20"},
+			},
+		},
+	}
+	testCases := []struct {
+		name        string
+		csr         *x509.CertificateRequest
+		expectedErr bool
+	}{
+		{
+			name: "SANs match node addresses",
+			csr: &x509.CertificateRequest{
+				DNSNames:    []string{"winhost", "winhost.example.com"},
+				IPAddresses: []net.IP{net.ParseIP("10.0.0.20")},
+			},
+			expectedErr: false,
+		},
+		{
+			name:        "subset of node addresses",
+			csr:         &x509.CertificateRequest{IPAddresses: []net.IP{net.ParseIP("10.0.0.20")}},
+			expectedErr: false,
+		},
+		{
+			name: "IP address from before the node address changed",
+			csr: &x509.CertificateRequest{
+				DNSNames:    []string{"winhost"},
+				IPAddresses: []net.IP{net.ParseIP("10.0.0.10")},
+			},
+			expectedErr: true,
+		},
+		{
+			name:        "unknown DNS name",
+			csr:         &x509.CertificateRequest{DNSNames: []string{"otherhost.example.com"}},
+			expectedErr: true,
+		},
+		{
+			name:        "URI SAN",
+			csr:         &x509.CertificateRequest{URIs: []*url.URL{{Scheme: "spiffe", Host: "winhost"}}},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateSANs(node, test.csr)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
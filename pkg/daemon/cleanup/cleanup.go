@@ -22,6 +22,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	core "k8s.io/api/core/v1"
 	"k8s.io/client-go/rest"
@@ -38,6 +39,9 @@ import (
 	"github.com/openshift/windows-machine-config-operator/pkg/windows"
 )
 
+// containerStopTimeout is how long running containers are given to exit before containerd is stopped
+const containerStopTimeout = 30 * time.Second
+
 // Deconfigure removes all managed services from the instance and the version annotation, if it has an associated node.
 // If we are able to get the services ConfigMap tied to the desired version, all services defined in it are cleaned up.
 // Otherwise, cleanup is based on the latest services ConfigMap.
@@ -65,7 +69,7 @@ func Deconfigure(cfg *rest.Config, ctx context.Context, configMapNamespace strin
 	if err != nil {
 		return err
 	}
-	if err = removeServices(svcMgr, mergedCMData.Services, removeAllTaggedServices); err != nil {
+	if err = removeServices(svcMgr, mergedCMData.Services, removeAllTaggedServices, stopContainers); err != nil {
 		return err
 	}
 	envVarsRemoved, err := ensureEnvVarsAreRemoved(mergedCMData.WatchedEnvironmentVars)
@@ -185,13 +189,19 @@ func merge(e1, e2 []string) []string {
 
 // removeServices uses the given manager to remove all the given Windows services from this instance.
 // The removeAllTaggedServices flag is used to also remove OpenShift-managed services that may not be in the given slice
-func removeServices(svcMgr manager.Manager, services []servicescm.Service, removeAllTaggedServices bool) error {
+// The given stopContainers function is called before the containerd service is removed.
+func removeServices(svcMgr manager.Manager, services []servicescm.Service, removeAllTaggedServices bool,
+	stopContainers func()) error {
 	// Build up log message and failures
 	servicesRemoved := []string{}
 	failedRemovals := []error{}
 	// The services are ordered by increasing priority already, so stop them in reverse order to avoid dependency issues
 	for i := len(services) - 1; i >= 0; i-- {
 		service := services[i]
+		if service.Name == windows.ContainerdServiceName {
+			// kubelet has been removed at this point, so the stopped containers will not be restarted
+			stopContainers()
+		}
 		if err := svcMgr.DeleteService(service.Name); err != nil {
 			failedRemovals = append(failedRemovals, err)
 		} else {
@@ -244,6 +254,17 @@ func ensureEnvVarsAreRemoved(watchedEnvVars []string) (bool, error) {
 	return envvar.Reconcile(map[string]string{}, watchedEnvVars)
 }
 
+// stopContainers asks containerd to stop all running containers, giving them a chance to exit cleanly before the
+// containerd service is stopped. Containers which do not exit within the timeout are forcefully stopped along with
+// containerd.
+func stopContainers() {
+	cmdRunner := powershell.NewCommandRunner()
+	if out, err := cmdRunner.Run(windows.StopContainerTasksCmd(containerStopTimeout)); err != nil {
+		klog.Infof("containers did not stop within %s, they will be forcefully stopped: %s: %v",
+			containerStopTimeout, out, err)
+	}
+}
+
 // cleanupContainers makes a best effort to stop all processes with the name containerd-shim-runhcs-v1, stopping
 // any containers which were not able to be drained from the Node.
 func cleanupContainers() {
@@ -113,7 +113,7 @@ func TestRemoveServices(t *testing.T) {
 	for _, test := range testIO {
 		t.Run(test.name, func(t *testing.T) {
 			winSvcMgr := fake.NewTestMgr(test.existingServices)
-			err := removeServices(winSvcMgr, test.configMapServices, test.removeAllTaggedServices)
+			err := removeServices(winSvcMgr, test.configMapServices, test.removeAllTaggedServices, func() {})
 			require.NoError(t, err)
 			allServices, err := winSvcMgr.GetServices()
 			require.NoError(t, err)
@@ -24,6 +24,7 @@ import (
 	"fmt"
 	"net"
 	"reflect"
+	"slices"
 	"strings"
 	"time"
 
@@ -202,9 +203,14 @@ func (sc *ServiceController) SetupWithManager(ctx context.Context, mgr ctrl.Mana
 				e.Object.GetAnnotations()[metadata.DesiredVersionAnnotation] != ""
 		},
 		UpdateFunc: func(e event.UpdateEvent) bool {
-			// Only process update events if the desired version has changed and there is no reboot required
+			// Only process update events if the desired version, the services ConfigMap override or a service log
+			// level has changed and there is no reboot required
+			desiredVersionChanged := e.ObjectOld.GetAnnotations()[metadata.DesiredVersionAnnotation] !=
+				e.ObjectNew.GetAnnotations()[metadata.DesiredVersionAnnotation]
+			overrideChanged := e.ObjectOld.GetAnnotations()[metadata.ServicesConfigMapOverrideAnnotation] !=
+				e.ObjectNew.GetAnnotations()[metadata.ServicesConfigMapOverrideAnnotation]
 			return sc.nodeName == e.ObjectNew.GetName() && !isAwaitingReboot(e.ObjectNew) &&
-				e.ObjectOld.GetAnnotations()[metadata.DesiredVersionAnnotation] != e.ObjectNew.GetAnnotations()[metadata.DesiredVersionAnnotation]
+				(desiredVersionChanged || overrideChanged || logLevelsChanged(e.ObjectOld, e.ObjectNew))
 		},
 		GenericFunc: func(e event.GenericEvent) bool {
 			return sc.nodeName == e.Object.GetName() && !isAwaitingReboot(e.Object) &&
@@ -260,19 +266,30 @@ func (sc *ServiceController) Reconcile(_ context.Context, req ctrl.Request) (res
 		// node missing desired version annotation, don't requeue
 		return ctrl.Result{}, nil
 	}
+	awaitingRestart := false
+	defer func() {
+		// the result is only known once the instance has been restarted
+		if reconcileErr == nil && awaitingRestart {
+			return
+		}
+		if err := nodeutil.SetWICDDegradedCondition(sc.ctx, sc.client, &node, desiredVersion,
+			reconcileErr); err != nil {
+			klog.Errorf("unable to report the result of the reconciliation on node %s: %v", sc.nodeName, err)
+		}
+	}()
 
-	// Fetch the CM of the desired version
+	// Fetch the CM of the desired version, unless the node is pointed at another one
+	cmName := servicesConfigMapName(&node, desiredVersion)
 	var cm core.ConfigMap
-	if err := sc.client.Get(sc.ctx,
-		client.ObjectKey{Namespace: sc.watchNamespace, Name: servicescm.NamePrefix + desiredVersion}, &cm); err != nil {
-		return ctrl.Result{}, err
+	if err := sc.client.Get(sc.ctx, client.ObjectKey{Namespace: sc.watchNamespace, Name: cmName}, &cm); err != nil {
+		return ctrl.Result{}, fmt.Errorf("error getting services ConfigMap %s: %w", cmName, err)
 	}
 	cmData, err := servicescm.Parse(cm.Data)
 	if err != nil {
-		return ctrl.Result{}, err
+		return ctrl.Result{}, fmt.Errorf("invalid services ConfigMap %s: %w", cmName, err)
 	}
 
-	awaitingRestart, err := sc.reconcileEnvVarsAndCerts(cmData.EnvironmentVars, cmData.WatchedEnvironmentVars, node)
+	awaitingRestart, err = sc.reconcileEnvVarsAndCerts(cmData.EnvironmentVars, cmData.WatchedEnvironmentVars, node)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
@@ -455,13 +472,23 @@ func (sc *ServiceController) expectedServiceCommand(expected servicescm.Service)
 // variable with
 func (sc *ServiceController) resolveNodeVariables(nodevars []servicescm.NodeCmdArg) (map[string]string, error) {
 	vars := make(map[string]string)
+	if sc.nodeName == "" {
+		// the Node does not exist yet when bootstrapping, only variables with a default value can be resolved
+		for _, nodeVar := range nodevars {
+			if nodeVar.Default == "" {
+				return nil, fmt.Errorf("expected node value %s missing", nodeVar.NodeObjectJsonPath)
+			}
+			vars[nodeVar.Name] = nodeVar.Default
+		}
+		return vars, nil
+	}
 	var node core.Node
 	err := sc.client.Get(sc.ctx, client.ObjectKey{Name: sc.nodeName}, &node)
 	if err != nil {
 		return nil, err
 	}
 	for _, nodeVar := range nodevars {
-		nodeParser := jsonpath.New("nodeParser")
+		nodeParser := jsonpath.New("nodeParser").AllowMissingKeys(nodeVar.Default != "")
 		if err := nodeParser.Parse(nodeVar.NodeObjectJsonPath); err != nil {
 			return nil, err
 		}
@@ -469,6 +496,10 @@ func (sc *ServiceController) resolveNodeVariables(nodevars []servicescm.NodeCmdA
 		if err != nil {
 			return nil, err
 		}
+		if nodeVar.Default != "" && (len(values) == 0 || len(values[0]) == 0) {
+			vars[nodeVar.Name] = nodeVar.Default
+			continue
+		}
 		if len(values) == 0 {
 			return nil, fmt.Errorf("expected node value %s missing", nodeVar.NodeObjectJsonPath)
 		}
@@ -478,7 +509,16 @@ func (sc *ServiceController) resolveNodeVariables(nodevars []servicescm.NodeCmdA
 		if len(values[0]) != 1 || values[0][0].Kind() != reflect.String {
 			return nil, fmt.Errorf("unexpected value type for %s", nodeVar.NodeObjectJsonPath)
 		}
-		vars[nodeVar.Name] = values[0][0].String()
+		value := values[0][0].String()
+		if len(nodeVar.AllowedValues) > 0 && !slices.Contains(nodeVar.AllowedValues, value) {
+			klog.Warningf("ignoring invalid value %q of %s, using %q", value, nodeVar.NodeObjectJsonPath,
+				nodeVar.Default)
+			sc.recorder.Eventf(&node, core.EventTypeWarning, "InvalidNodeValue",
+				"ignoring invalid value %q of %s, expected one of %v", value, nodeVar.NodeObjectJsonPath,
+				nodeVar.AllowedValues)
+			value = nodeVar.Default
+		}
+		vars[nodeVar.Name] = value
 	}
 	return vars, nil
 }
@@ -639,6 +679,28 @@ func slicesEquivalent(s1, s2 []string) bool {
 
 }
 
+// servicesConfigMapName returns the name of the services ConfigMap the given node is to be configured with: the
+// ConfigMap given by its services ConfigMap override annotation if set, and the ConfigMap of the desired version
+// otherwise
+func servicesConfigMapName(node *core.Node, desiredVersion string) string {
+	if override := node.GetAnnotations()[metadata.ServicesConfigMapOverrideAnnotation]; override != "" {
+		return override
+	}
+	return servicescm.NamePrefix + desiredVersion
+}
+
+// logLevelsChanged returns true if any of the annotations overriding the log level of a service differ between the
+// given objects
+func logLevelsChanged(oldObj, newObj client.Object) bool {
+	for _, annotation := range []string{metadata.KubeletLogLevelAnnotation, metadata.KubeProxyLogLevelAnnotation,
+		metadata.ContainerdLogLevelAnnotation} {
+		if oldObj.GetAnnotations()[annotation] != newObj.GetAnnotations()[annotation] {
+			return true
+		}
+	}
+	return false
+}
+
 // isAwaitingReboot returns true if the given object is a node that is awaiting a reboot by WMCO
 func isAwaitingReboot(obj runtime.Object) bool {
 	node, ok := obj.(*core.Node)
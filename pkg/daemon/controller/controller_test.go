@@ -16,6 +16,7 @@ import (
 	core "k8s.io/api/core/v1"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
@@ -23,6 +24,7 @@ import (
 	"github.com/openshift/windows-machine-config-operator/pkg/daemon/fake"
 	"github.com/openshift/windows-machine-config-operator/pkg/daemon/manager"
 	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeutil"
 	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
 	"github.com/openshift/windows-machine-config-operator/pkg/windows"
 )
@@ -107,6 +109,84 @@ func TestResolveNodeVariables(t *testing.T) {
 			expected:  map[string]string{"replace": "desiredvalue", "label": "labelvalue"},
 			expectErr: false,
 		},
+		{
+			name:            "Missing annotation with default",
+			nodeName:        "node",
+			nodeAnnotations: map[string]string{"foo": "fah"},
+			service: servicescm.Service{
+				NodeVariablesInCommand: []servicescm.NodeCmdArg{
+					{
+						Name:               "LOG_LEVEL",
+						NodeObjectJsonPath: "{.metadata.annotations.log-level}",
+						Default:            "2",
+					},
+				},
+			},
+			expected:  map[string]string{"LOG_LEVEL": "2"},
+			expectErr: false,
+		},
+		{
+			name:            "Allowed annotation value",
+			nodeName:        "node",
+			nodeAnnotations: map[string]string{"log-level": "4"},
+			service: servicescm.Service{
+				NodeVariablesInCommand: []servicescm.NodeCmdArg{
+					{
+						Name:               "LOG_LEVEL",
+						NodeObjectJsonPath: "{.metadata.annotations.log-level}",
+						Default:            "2",
+						AllowedValues:      []string{"2", "4"},
+					},
+				},
+			},
+			expected:  map[string]string{"LOG_LEVEL": "4"},
+			expectErr: false,
+		},
+		{
+			name:            "Invalid annotation value falls back to default",
+			nodeName:        "node",
+			nodeAnnotations: map[string]string{"log-level": "verbose"},
+			service: servicescm.Service{
+				NodeVariablesInCommand: []servicescm.NodeCmdArg{
+					{
+						Name:               "LOG_LEVEL",
+						NodeObjectJsonPath: "{.metadata.annotations.log-level}",
+						Default:            "2",
+						AllowedValues:      []string{"2", "4"},
+					},
+				},
+			},
+			expected:  map[string]string{"LOG_LEVEL": "2"},
+			expectErr: false,
+		},
+		{
+			name:     "Bootstrapping uses defaults",
+			nodeName: "",
+			service: servicescm.Service{
+				NodeVariablesInCommand: []servicescm.NodeCmdArg{
+					{
+						Name:               "LOG_LEVEL",
+						NodeObjectJsonPath: "{.metadata.annotations.log-level}",
+						Default:            "2",
+					},
+				},
+			},
+			expected:  map[string]string{"LOG_LEVEL": "2"},
+			expectErr: false,
+		},
+		{
+			name:     "Bootstrapping without default",
+			nodeName: "",
+			service: servicescm.Service{
+				NodeVariablesInCommand: []servicescm.NodeCmdArg{
+					{
+						Name:               "replace",
+						NodeObjectJsonPath: "{.metadata.annotations.desiredkey}",
+					},
+				},
+			},
+			expectErr: true,
+		},
 	}
 	for _, test := range testIO {
 		t.Run(test.name, func(t *testing.T) {
@@ -120,6 +200,7 @@ func TestResolveNodeVariables(t *testing.T) {
 				}).Build(),
 				Mgr:       fake.NewTestMgr(nil),
 				cmdRunner: &fakePSCmdRunner{},
+				recorder:  record.NewFakeRecorder(1),
 			})
 			require.NoError(t, err)
 			actual, err := c.resolveNodeVariables(test.service.NodeVariablesInCommand)
@@ -790,11 +871,15 @@ func TestReconcile(t *testing.T) {
 			node := &core.Node{}
 			require.NoError(t, c.client.Get(c.ctx, client.ObjectKey{Name: c.nodeName}, node))
 			_, exists := node.GetAnnotations()[metadata.RebootAnnotation]
+			wicdCondition := nodeutil.GetCondition(node, nodeutil.WICDDegradedCondition)
 			if reflect.DeepEqual(test.existingEnvVars, test.configMapEnvVars) {
 				assert.False(t, exists, "expected no reboot annotation on node")
-
+				require.NotNil(t, wicdCondition)
+				assert.Equal(t, core.ConditionFalse, wicdCondition.Status)
+				assert.Contains(t, wicdCondition.Message, desiredVersion)
 			} else {
 				assert.True(t, exists, "expected reboot annotation to be applied on node")
+				assert.Nil(t, wicdCondition, "expected no result to be reported while awaiting a reboot")
 			}
 		})
 	}
@@ -946,6 +1031,36 @@ func TestFindNodeByAddress(t *testing.T) {
 	}
 }
 
+func TestServicesConfigMapName(t *testing.T) {
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		expected    string
+	}{
+		{
+			name:        "no override",
+			annotations: nil,
+			expected:    servicescm.NamePrefix + "1.0.0",
+		},
+		{
+			name:        "empty override",
+			annotations: map[string]string{metadata.ServicesConfigMapOverrideAnnotation: ""},
+			expected:    servicescm.NamePrefix + "1.0.0",
+		},
+		{
+			name:        "override",
+			annotations: map[string]string{metadata.ServicesConfigMapOverrideAnnotation: "canary-services"},
+			expected:    "canary-services",
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			node := &core.Node{ObjectMeta: meta.ObjectMeta{Name: "node", Annotations: test.annotations}}
+			assert.Equal(t, test.expected, servicesConfigMapName(node, "1.0.0"))
+		})
+	}
+}
+
 func TestSlicesEquivalent(t *testing.T) {
 	testIO := []struct {
 		name     string
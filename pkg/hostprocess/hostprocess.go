@@ -0,0 +1,95 @@
+package hostprocess
+
+import (
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	nodev1 "k8s.io/api/node/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// Name is the name of the host-process helper DaemonSet, and of the ServiceAccount its pods run as
+	Name = "windows-host-process-helper"
+	// RuntimeClassName is the name of the RuntimeClass used to schedule host-process pods onto Windows nodes
+	RuntimeClassName = "windows-host-process"
+	// ManagedLabel is a RuntimeClass label identifying the host-process RuntimeClass created by WMCO, so that a
+	// RuntimeClass of the same name created by a user is left alone
+	ManagedLabel = "windowsmachineconfig.openshift.io/host-process-helper"
+	// containerdHandler is the containerd runtime handler for process isolated Windows containers
+	containerdHandler = "runhcs-wcow-process"
+	// systemUser is the user host-process containers are run as
+	systemUser = "NT AUTHORITY\\SYSTEM"
+	// appLabel is the label used to select the pods of the helper DaemonSet
+	appLabel = "app"
+)
+
+// NewRuntimeClass returns a RuntimeClass which schedules pods onto Windows nodes, tolerating the taint WMCO
+// registers Windows nodes with
+func NewRuntimeClass() *nodev1.RuntimeClass {
+	return &nodev1.RuntimeClass{
+		ObjectMeta: meta.ObjectMeta{
+			Name:   RuntimeClassName,
+			Labels: map[string]string{ManagedLabel: "true"},
+		},
+		Handler: containerdHandler,
+		Scheduling: &nodev1.Scheduling{
+			NodeSelector: map[string]string{
+				core.LabelOSStable: string(core.Windows),
+			},
+			Tolerations: []core.Toleration{
+				{
+					Key:      "os",
+					Operator: core.TolerationOpEqual,
+					Value:    "Windows",
+					Effect:   core.TaintEffectNoSchedule,
+				},
+			},
+		},
+	}
+}
+
+// IsManaged returns true if the given RuntimeClass was created by WMCO, as opposed to by a user
+func IsManaged(rc *nodev1.RuntimeClass) bool {
+	return rc.GetLabels()[ManagedLabel] == "true"
+}
+
+// NewDaemonSet returns a DaemonSet in the given namespace which runs the given image as a host-process container on
+// every Windows node
+func NewDaemonSet(namespace, image string) *apps.DaemonSet {
+	hostProcess := true
+	runAsUserName := systemUser
+	runtimeClassName := RuntimeClassName
+	labels := map[string]string{appLabel: Name}
+	return &apps.DaemonSet{
+		ObjectMeta: meta.ObjectMeta{
+			Name:      Name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: apps.DaemonSetSpec{
+			Selector: &meta.LabelSelector{MatchLabels: labels},
+			Template: core.PodTemplateSpec{
+				ObjectMeta: meta.ObjectMeta{Labels: labels},
+				Spec: core.PodSpec{
+					RuntimeClassName:   &runtimeClassName,
+					ServiceAccountName: Name,
+					// host-process containers share the host's network namespace
+					HostNetwork: true,
+					SecurityContext: &core.PodSecurityContext{
+						WindowsOptions: &core.WindowsSecurityContextOptions{
+							HostProcess:   &hostProcess,
+							RunAsUserName: &runAsUserName,
+						},
+					},
+					Containers: []core.Container{
+						{
+							Name:            Name,
+							Image:           image,
+							ImagePullPolicy: core.PullIfNotPresent,
+						},
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,96 @@
+package hostprocess
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	core "k8s.io/api/core/v1"
+	nodev1 "k8s.io/api/node/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewRuntimeClass(t *testing.T) {
+	rc := NewRuntimeClass()
+	assert.Equal(t, RuntimeClassName, rc.GetName())
+	assert.Equal(t, "runhcs-wcow-process", rc.Handler)
+	assert.True(t, IsManaged(rc))
+	require.NotNil(t, rc.Scheduling)
+	assert.Equal(t, map[string]string{core.LabelOSStable: "windows"}, rc.Scheduling.NodeSelector)
+	// the toleration must match the taint WMCO registers kubelet with
+	taint := &core.Taint{Key: "os", Value: "Windows", Effect: core.TaintEffectNoSchedule}
+	require.Len(t, rc.Scheduling.Tolerations, 1)
+	assert.True(t, rc.Scheduling.Tolerations[0].ToleratesTaint(taint))
+}
+
+func TestIsManaged(t *testing.T) {
+	testCases := []struct {
+		name     string
+		labels   map[string]string
+		expected bool
+	}{
+		{
+			name:     "no labels",
+			labels:   nil,
+			expected: false,
+		},
+		{
+			name:     "other labels",
+			labels:   map[string]string{"app": "windows"},
+			expected: false,
+		},
+		{
+			name:     "managed label",
+			labels:   map[string]string{ManagedLabel: "true"},
+			expected: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			rc := &nodev1.RuntimeClass{ObjectMeta: meta.ObjectMeta{Name: RuntimeClassName, Labels: test.labels}}
+			assert.Equal(t, test.expected, IsManaged(rc))
+		})
+	}
+}
+
+func TestNewDaemonSet(t *testing.T) {
+	testCases := []struct {
+		name      string
+		namespace string
+		image     string
+	}{
+		{
+			name:      "image with tag",
+			namespace: "openshift-windows-machine-config-operator",
+			image:     "quay.io/example/helper:v1",
+		},
+		{
+			name:      "image with digest",
+			namespace: "test",
+			image:     "quay.io/example/helper@sha256:0123456789abcdef",
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			ds := NewDaemonSet(test.namespace, test.image)
+			assert.Equal(t, Name, ds.GetName())
+			assert.Equal(t, test.namespace, ds.GetNamespace())
+			require.NotNil(t, ds.Spec.Selector)
+			assert.Equal(t, ds.Spec.Selector.MatchLabels, ds.Spec.Template.GetLabels())
+
+			podSpec := ds.Spec.Template.Spec
+			require.NotNil(t, podSpec.RuntimeClassName)
+			assert.Equal(t, RuntimeClassName, *podSpec.RuntimeClassName)
+			assert.Equal(t, Name, podSpec.ServiceAccountName)
+			assert.True(t, podSpec.HostNetwork)
+			require.NotNil(t, podSpec.SecurityContext)
+			require.NotNil(t, podSpec.SecurityContext.WindowsOptions)
+			require.NotNil(t, podSpec.SecurityContext.WindowsOptions.HostProcess)
+			assert.True(t, *podSpec.SecurityContext.WindowsOptions.HostProcess)
+			require.NotNil(t, podSpec.SecurityContext.WindowsOptions.RunAsUserName)
+			assert.Equal(t, "NT AUTHORITY\\SYSTEM", *podSpec.SecurityContext.WindowsOptions.RunAsUserName)
+			require.Len(t, podSpec.Containers, 1)
+			assert.Equal(t, test.image, podSpec.Containers[0].Image)
+		})
+	}
+}
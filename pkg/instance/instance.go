@@ -4,12 +4,16 @@ import (
 	"fmt"
 	"net"
 
+	config "github.com/openshift/api/config/v1"
 	core "k8s.io/api/core/v1"
 
 	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
 	"github.com/openshift/windows-machine-config-operator/version"
 )
 
+// DefaultSSHPort is the port SSH connections are made on when an instance does not specify one
+const DefaultSSHPort = 22
+
 // Info represents a instance that is meant to be joined to the cluster
 type Info struct {
 	// Address is the network address of the instance as specified by the associated ConfigMap entry.
@@ -19,23 +23,50 @@ type Info struct {
 	IPv4Address string
 	// Username is the name of a user that can be ssh'd into.
 	Username string
+	// SSHPort is the port the instance's SSH server listens on. DefaultSSHPort is used if this is not set.
+	SSHPort int
 	// NewHostname being set means that the instance's hostname should be changed. An empty value is a no-op.
 	NewHostname string
-	// SetNodeIP indicates if the instance should have the node-ip arg set when bootstrapping.
-	SetNodeIP bool
+	// ExternallyRegistered indicates that the instance's Node is created out-of-band rather than by kubelet, so kubelet
+	// must not register the Node itself.
+	ExternallyRegistered bool
 	// Node is an optional pointer to the Node object associated with the instance, if it has one.
 	Node *core.Node
+	// ProviderID identifies the instance with the cloud provider hosting it, such as aws:///us-east-1a/i-0123. This is
+	// empty if the instance is not known to a cloud provider.
+	ProviderID string
+}
+
+// DefaultUsername returns the username used to SSH into instances on the given platform, when no username is given
+func DefaultUsername(platform config.PlatformType) string {
+	// TODO: This should be changed so that the "core" user is used on all platforms for SSH connections.
+	// https://issues.redhat.com/browse/WINC-430
+	if platform == config.AzurePlatformType {
+		return "capi"
+	}
+	return "Administrator"
 }
 
 // NewInfo returns a new Info. newHostname being set means that the instance's hostname should be
 // changed. An empty value is a no-op.
-func NewInfo(address, username, newHostname string, setNodeIP bool, node *core.Node) (*Info, error) {
+func NewInfo(address, username, newHostname string, node *core.Node) (*Info, error) {
 	ip, err := net.ResolveIPAddr("ip4", address)
 	if err != nil {
 		return nil, fmt.Errorf("invalid address %s, unable to create instance info: %w", address, err)
 	}
-	return &Info{Address: address, IPv4Address: ip.String(), Username: username, NewHostname: newHostname,
-		SetNodeIP: setNodeIP, Node: node}, nil
+	info := &Info{Address: address, IPv4Address: ip.String(), Username: username, NewHostname: newHostname, Node: node}
+	if node != nil {
+		info.ProviderID = node.Spec.ProviderID
+	}
+	return info, nil
+}
+
+// GetSSHPort returns the port SSH connections to the instance should be made on
+func (i *Info) GetSSHPort() int {
+	if i.SSHPort == 0 {
+		return DefaultSSHPort
+	}
+	return i.SSHPort
 }
 
 // UpToDate returns true if the instance was configured by the current WMCO version
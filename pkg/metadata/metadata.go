@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"path"
 	"strings"
+	"time"
 
 	core "k8s.io/api/core/v1"
 	kubeTypes "k8s.io/apimachinery/pkg/types"
@@ -21,8 +22,64 @@ const (
 	VersionAnnotation = "windowsmachineconfig.openshift.io/version"
 	// DesiredVersionAnnotation is a Node annotation, indicating the Service ConfigMap that should be used to configure it
 	DesiredVersionAnnotation = "windowsmachineconfig.openshift.io/desired-version"
+	// DesiredVersionTimestampAnnotation is a Node annotation holding the time, in RFC 3339 format, at which WMCO last
+	// set the desired version annotation of the node
+	DesiredVersionTimestampAnnotation = "windowsmachineconfig.openshift.io/desired-version-timestamp"
 	// RebootAnnotation indicates the node's underlying instance needs to be restarted
 	RebootAnnotation = "windowsmachineconfig.openshift.io/reboot-required"
+	// ExternallyManagedAnnotation is a Node annotation which, when set to "true" by an admin, indicates the node's
+	// lifecycle has been taken over from WMCO. WMCO neither deconfigures such nodes when their instance is removed from
+	// the windows-instances ConfigMap, nor connects to their instances to keep them configured.
+	ExternallyManagedAnnotation = "windowsmachineconfig.openshift.io/externally-managed"
+	// WICDTokenAnnotation is a Node annotation holding the name of the WICD ServiceAccount token secret that the
+	// kubeconfig used by WICD on the node was generated from
+	WICDTokenAnnotation = "windowsmachineconfig.openshift.io/wicd-token"
+	// PendingUncordonAnnotation is a Node annotation indicating WMCO has configured the node but left it cordoned, as
+	// requested by the user, so that it can be validated before it is manually uncordoned
+	PendingUncordonAnnotation = "windowsmachineconfig.openshift.io/pending-uncordon"
+	// ForceDeconfigureAnnotation is a Node annotation which, when set to "true" by an admin, allows WMCO to deconfigure
+	// a BYOH node hosting workloads which no other Windows node is able to run
+	ForceDeconfigureAnnotation = "windowsmachineconfig.openshift.io/force-deconfigure"
+	// SoftDeconfigureAnnotation is a Node annotation which, when set to "true" by an admin, requests WMCO to only remove
+	// what it manages when the BYOH node is removed from the cluster, leaving the rest of the instance untouched. The
+	// node is not drained, and the HNS networks of the instance are kept.
+	SoftDeconfigureAnnotation = "windowsmachineconfig.openshift.io/soft-deconfigure"
+	// ForceReconfigureAnnotation is a Node annotation which, when set to "true" by an admin, requests WMCO to fully
+	// deconfigure and configure the node again. WMCO removes it once the node has been reconfigured.
+	ForceReconfigureAnnotation = "windowsmachineconfig.openshift.io/force-reconfigure"
+	// OSBuildAnnotation is a Node annotation holding the build number of the Windows instance backing the node, as
+	// found when WMCO last configured it
+	OSBuildAnnotation = "windowsmachineconfig.openshift.io/os-build"
+	// OSEditionAnnotation is a Node annotation holding the edition ID of the Windows instance backing the node, such
+	// as ServerDatacenter, as found when WMCO last configured it
+	OSEditionAnnotation = "windowsmachineconfig.openshift.io/os-edition"
+	// ServingCertAddressesAnnotation is a Node annotation holding the node addresses kubelet's serving certificate was
+	// last requested for, used to detect when the serving certificate no longer matches the node
+	ServingCertAddressesAnnotation = "windowsmachineconfig.openshift.io/serving-cert-addresses"
+	// SkipTrustedCABundleSyncAnnotation is a Node annotation which, when set to "true" by an admin, stops WMCO from
+	// syncing the trusted CA bundle onto the node, leaving a trust store managed by other means untouched
+	SkipTrustedCABundleSyncAnnotation = "windowsmachineconfig.openshift.io/skip-trusted-ca-sync"
+	// KubeletLogLevelAnnotation is a Node annotation which, when set by an admin, overrides the verbosity of kubelet's
+	// logs on the node, given as a klog verbosity from 0 to 10
+	KubeletLogLevelAnnotation = "windowsmachineconfig.openshift.io/kubelet-log-level"
+	// KubeProxyLogLevelAnnotation is a Node annotation which, when set by an admin, overrides the verbosity of
+	// kube-proxy's logs on the node, given as a klog verbosity from 0 to 10
+	KubeProxyLogLevelAnnotation = "windowsmachineconfig.openshift.io/kube-proxy-log-level"
+	// ContainerdLogLevelAnnotation is a Node annotation which, when set by an admin, overrides the level of containerd's
+	// logs on the node, given as one of containerd's log levels such as debug
+	ContainerdLogLevelAnnotation = "windowsmachineconfig.openshift.io/containerd-log-level"
+	// ProcessDumpAnnotation is a Node annotation which, when set by an admin to the name of a service such as kubelet,
+	// requests a memory dump of the service's process to be collected. WMCO removes it once the request is handled.
+	ProcessDumpAnnotation = "windowsmachineconfig.openshift.io/capture-process-dump"
+	// ConfigurationIDAnnotation is a Node annotation holding the ID of the configuration which last configured the
+	// node. The operator's logs and events for that configuration carry the same ID.
+	ConfigurationIDAnnotation = "windowsmachineconfig.openshift.io/configuration-id"
+	// ServicesConfigMapOverrideAnnotation is a Node annotation which, when set by an admin to the name of a ConfigMap in
+	// the WMCO namespace, overrides the services ConfigMap WICD configures the node with, so that a new service
+	// configuration can be validated on a single node before it is rolled out
+	ServicesConfigMapOverrideAnnotation = "windowsmachineconfig.openshift.io/services-configmap-override"
+	// WICDTokenSecretLabel is a Secret label identifying the secrets created by WMCO to hold a WICD ServiceAccount token
+	WICDTokenSecretLabel = "windowsmachineconfig.openshift.io/wicd-token-secret"
 	// UpgradingLabel indicates the node's underlying instance is performing an upgrade
 	UpgradingLabel = "windowsmachineconfig.openshift.io/upgrading"
 )
@@ -116,9 +173,26 @@ func ApplyVersionAnnotation(ctx context.Context, c client.Client, node core.Node
 	return ApplyLabelsAndAnnotations(ctx, c, node, nil, map[string]string{VersionAnnotation: value})
 }
 
-// ApplyDesiredVersionAnnotation applies this operator's version as the desired version annotation to the given Node
+// ApplyDesiredVersionAnnotation applies this operator's version as the desired version annotation to the given Node,
+// along with the time at which it was applied
 func ApplyDesiredVersionAnnotation(ctx context.Context, c client.Client, node core.Node, value string) error {
-	return ApplyLabelsAndAnnotations(ctx, c, node, nil, map[string]string{DesiredVersionAnnotation: value})
+	return ApplyLabelsAndAnnotations(ctx, c, node, nil, map[string]string{DesiredVersionAnnotation: value,
+		DesiredVersionTimestampAnnotation: time.Now().UTC().Format(time.RFC3339)})
+}
+
+// VersionMismatchDuration returns how long the version annotation of the given node has differed from its desired
+// version annotation, as of the given time. 0 is returned if the annotations match, or if the time the desired version
+// was set is unknown, as is the case for nodes whose desired version was set by an earlier version of WMCO.
+func VersionMismatchDuration(node *core.Node, now time.Time) time.Duration {
+	desiredVersion, present := node.GetAnnotations()[DesiredVersionAnnotation]
+	if !present || node.GetAnnotations()[VersionAnnotation] == desiredVersion {
+		return 0
+	}
+	setAt, err := time.Parse(time.RFC3339, node.GetAnnotations()[DesiredVersionTimestampAnnotation])
+	if err != nil || now.Before(setAt) {
+		return 0
+	}
+	return now.Sub(setAt)
 }
 
 // ApplyRebootAnnotation applies an annotation to the given Node communicating that the instance needs to be restarted
@@ -156,11 +230,65 @@ func RemoveRebootAnnotation(ctx context.Context, c client.Client, node core.Node
 	return nil
 }
 
+// ApplyPendingUncordonAnnotation applies an annotation to the given Node communicating that it has been left cordoned
+// and must be manually uncordoned
+func ApplyPendingUncordonAnnotation(ctx context.Context, c client.Client, node core.Node) error {
+	return ApplyLabelsAndAnnotations(ctx, c, node, nil, map[string]string{PendingUncordonAnnotation: "true"})
+}
+
+// RemovePendingUncordonAnnotation clears the pending uncordon annotation from the node, indicating WMCO no longer
+// intends to leave it cordoned
+func RemovePendingUncordonAnnotation(ctx context.Context, c client.Client, node core.Node) error {
+	if _, present := node.GetAnnotations()[PendingUncordonAnnotation]; present {
+		patchData, err := GenerateRemovePatch([]string{}, []string{PendingUncordonAnnotation})
+		if err != nil {
+			return fmt.Errorf("error creating pending uncordon annotation remove request: %w", err)
+		}
+		err = c.Patch(ctx, &node, client.RawPatch(kubeTypes.JSONPatchType, patchData))
+		if err != nil {
+			return fmt.Errorf("error removing pending uncordon annotation from node %s: %w", node.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// RemoveProcessDumpAnnotation clears the process dump annotation from the node, indicating the requested dump has been
+// handled
+func RemoveProcessDumpAnnotation(ctx context.Context, c client.Client, node core.Node) error {
+	if _, present := node.GetAnnotations()[ProcessDumpAnnotation]; present {
+		patchData, err := GenerateRemovePatch([]string{}, []string{ProcessDumpAnnotation})
+		if err != nil {
+			return fmt.Errorf("error creating process dump annotation remove request: %w", err)
+		}
+		err = c.Patch(ctx, &node, client.RawPatch(kubeTypes.JSONPatchType, patchData))
+		if err != nil {
+			return fmt.Errorf("error removing process dump annotation from node %s: %w", node.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// RemoveForceReconfigureAnnotation clears the force reconfigure annotation from the node, indicating the requested
+// reconfiguration is complete
+func RemoveForceReconfigureAnnotation(ctx context.Context, c client.Client, node core.Node) error {
+	if _, present := node.GetAnnotations()[ForceReconfigureAnnotation]; present {
+		patchData, err := GenerateRemovePatch([]string{}, []string{ForceReconfigureAnnotation})
+		if err != nil {
+			return fmt.Errorf("error creating force reconfigure annotation remove request: %w", err)
+		}
+		err = c.Patch(ctx, &node, client.RawPatch(kubeTypes.JSONPatchType, patchData))
+		if err != nil {
+			return fmt.Errorf("error removing force reconfigure annotation from node %s: %w", node.GetName(), err)
+		}
+	}
+	return nil
+}
+
 // WaitForVersionAnnotation checks if the node object has equivalent version and desiredVersion annotations.
-// Waits for retry.Interval seconds and returns an error if the version annotation does not appear in that time frame.
-func WaitForVersionAnnotation(ctx context.Context, c client.Client, nodeName string) error {
+// Returns an error if the version annotation does not match within the given timeout.
+func WaitForVersionAnnotation(ctx context.Context, c client.Client, nodeName string, timeout time.Duration) error {
 	node := &core.Node{}
-	err := wait.Poll(retry.Interval, retry.Timeout, func() (bool, error) {
+	err := wait.PollUntilContextTimeout(ctx, retry.Interval, timeout, false, func(ctx context.Context) (bool, error) {
 		err := c.Get(ctx, kubeTypes.NamespacedName{Name: nodeName}, node)
 		if err != nil {
 			return false, err
@@ -1,10 +1,15 @@
 package metadata
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
 
 	"github.com/openshift/windows-machine-config-operator/pkg/patch"
 )
@@ -138,3 +143,72 @@ func TestGeneratePatch(t *testing.T) {
 		})
 	}
 }
+
+func TestVersionMismatchDuration(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	setAt := now.Add(-45 * time.Minute).Format(time.RFC3339)
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		expected    time.Duration
+	}{
+		{
+			name:        "no desired version",
+			annotations: map[string]string{VersionAnnotation: "1.0.0"},
+			expected:    0,
+		},
+		{
+			name: "versions match",
+			annotations: map[string]string{VersionAnnotation: "2.0.0", DesiredVersionAnnotation: "2.0.0",
+				DesiredVersionTimestampAnnotation: setAt},
+			expected: 0,
+		},
+		{
+			name: "versions differ",
+			annotations: map[string]string{VersionAnnotation: "1.0.0", DesiredVersionAnnotation: "2.0.0",
+				DesiredVersionTimestampAnnotation: setAt},
+			expected: 45 * time.Minute,
+		},
+		{
+			name:        "version not yet applied",
+			annotations: map[string]string{DesiredVersionAnnotation: "2.0.0", DesiredVersionTimestampAnnotation: setAt},
+			expected:    45 * time.Minute,
+		},
+		{
+			name:        "desired version set by an earlier operator version",
+			annotations: map[string]string{VersionAnnotation: "1.0.0", DesiredVersionAnnotation: "2.0.0"},
+			expected:    0,
+		},
+		{
+			name: "invalid timestamp",
+			annotations: map[string]string{VersionAnnotation: "1.0.0", DesiredVersionAnnotation: "2.0.0",
+				DesiredVersionTimestampAnnotation: "yesterday"},
+			expected: 0,
+		},
+		{
+			name: "timestamp in the future",
+			annotations: map[string]string{VersionAnnotation: "1.0.0", DesiredVersionAnnotation: "2.0.0",
+				DesiredVersionTimestampAnnotation: now.Add(time.Minute).Format(time.RFC3339)},
+			expected: 0,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			node := &core.Node{ObjectMeta: meta.ObjectMeta{Annotations: test.annotations}}
+			assert.Equal(t, test.expected, VersionMismatchDuration(node, now))
+		})
+	}
+}
+
+func TestWaitForVersionAnnotationCancelled(t *testing.T) {
+	node := &core.Node{ObjectMeta: meta.ObjectMeta{Name: "winnode",
+		Annotations: map[string]string{DesiredVersionAnnotation: "10.18.0", VersionAnnotation: "10.17.0"}}}
+	c := clientfake.NewClientBuilder().WithObjects(node).Build()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	err := WaitForVersionAnnotation(ctx, c, node.GetName(), time.Hour)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), time.Minute)
+}
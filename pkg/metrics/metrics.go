@@ -9,6 +9,7 @@ import (
 
 	monv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
 	monclient "github.com/prometheus-operator/prometheus-operator/pkg/client/versioned/typed/monitoring/v1"
+	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -19,9 +20,11 @@ import (
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig"
 	"github.com/openshift/windows-machine-config-operator/pkg/patch"
+	"github.com/openshift/windows-machine-config-operator/pkg/windows"
 )
 
 //+kubebuilder:rbac:groups="",resources=services;services/finalizers,verbs=create;get;delete
@@ -35,15 +38,54 @@ var (
 	log = ctrl.Log.WithName("metrics")
 	// metricsEnabled specifies if metrics are enabled in the current cluster
 	metricsEnabled = true
+	// ServicesConfigMapRegenerations counts the number of times the services ConfigMap has been deleted and recreated
+	// by WMCO because it held invalid content. A steadily climbing value indicates either a bug in WMCO or something
+	// repeatedly modifying the ConfigMap.
+	ServicesConfigMapRegenerations = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "wmco_services_configmap_regenerations_total",
+		Help: "Number of times the services ConfigMap was deleted and recreated due to invalid content",
+	})
+	// ServiceRestarts holds the number of times each WMCO-managed service on each Windows node has terminated
+	// unexpectedly and been restarted. The count is derived from the node's System event log, and read from the
+	// node's windows_exporter, so it may decrease as old events are removed from the log. A climbing value indicates a
+	// flapping service.
+	ServiceRestarts = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "wmco_windows_service_restarts",
+		Help: "Number of unexpected terminations of a WMCO-managed service recorded in the node's System event log",
+	}, []string{"node", "service"})
+	// HNSSubnetSize holds the number of addresses of the hybrid overlay subnet of each Windows node which can be
+	// assigned to pods, as read from the node's windows_exporter
+	HNSSubnetSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "wmco_hns_subnet_size",
+		Help: "Number of addresses of the node's hybrid overlay HNS subnet which can be assigned to endpoints",
+	}, []string{"node"})
+	// HNSSubnetAddressesUsed holds the number of addresses of the hybrid overlay subnet of each Windows node which are
+	// assigned to HNS endpoints. New pods fail to get an IP once this reaches HNSSubnetSize.
+	HNSSubnetAddressesUsed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "wmco_hns_subnet_addresses_used",
+		Help: "Number of addresses of the node's hybrid overlay HNS subnet assigned to endpoints",
+	}, []string{"node"})
+	// NodesByVersion holds the number of Windows nodes configured by each WMCO version, as given by the version
+	// annotation of the nodes. Nodes which are being configured are not counted.
+	NodesByVersion = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "wmco_nodes_by_version",
+		Help: "Number of Windows nodes configured by each WMCO version",
+	}, []string{"version"})
 )
 
+func init() {
+	// metrics registered with the controller-runtime registry are served by the manager's metrics server
+	crmetrics.Registry.MustRegister(ServicesConfigMapRegenerations, ServiceRestarts, HNSSubnetSize,
+		HNSSubnetAddressesUsed, NodesByVersion)
+}
+
 const (
 	// metricsPortName specifies the portname used for Prometheus monitoring
 	PortName = "metrics"
 	// Host is the host address used by Windows metrics
 	Host = "0.0.0.0"
 	// Port is the port number on which windows-exporter is exposed.
-	Port int32 = 9182
+	Port int32 = windows.WindowsExporterPort
 	// WindowsMetricsResource is the name for objects created for Prometheus monitoring
 	// by current operator version. Its name is defined through the bundle manifests
 	WindowsMetricsResource = "windows-exporter"
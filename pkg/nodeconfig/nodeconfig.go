@@ -3,11 +3,22 @@ package nodeconfig
 import (
 	"context"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
+	"net/url"
+	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 
 	ignCfgTypes "github.com/coreos/ignition/v2/config/v3_4/types"
 	"github.com/go-logr/logr"
@@ -18,7 +29,12 @@ import (
 	core "k8s.io/api/core/v1"
 	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	clientcmdv1 "k8s.io/client-go/tools/clientcmd/api/v1"
@@ -36,10 +52,13 @@ import (
 	"github.com/openshift/windows-machine-config-operator/pkg/ignition"
 	"github.com/openshift/windows-machine-config-operator/pkg/instance"
 	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig/payload"
 	"github.com/openshift/windows-machine-config-operator/pkg/nodeutil"
 	"github.com/openshift/windows-machine-config-operator/pkg/registries"
 	"github.com/openshift/windows-machine-config-operator/pkg/retry"
 	"github.com/openshift/windows-machine-config-operator/pkg/secrets"
+	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
+	"github.com/openshift/windows-machine-config-operator/pkg/settings"
 	"github.com/openshift/windows-machine-config-operator/pkg/windows"
 	"github.com/openshift/windows-machine-config-operator/version"
 )
@@ -57,6 +76,8 @@ const (
 	WorkerLabel = "node-role.kubernetes.io/worker"
 	// PubKeyHashAnnotation corresponds to the public key present on the VM
 	PubKeyHashAnnotation = "windowsmachineconfig.openshift.io/pub-key-hash"
+	// configurationIDLogKey is the key of the log value holding the ID of the configuration a log entry was made during
+	configurationIDLogKey = "configurationID"
 	// KubeletClientCAFilename is the name of the CA certificate file required by kubelet to interact
 	// with the kube-apiserver client
 	KubeletClientCAFilename = "kubelet-ca.crt"
@@ -66,11 +87,86 @@ const (
 	mcoBootstrapSecret = "node-bootstrapper-token"
 	// MccName is the name of the Machine Config Controller object
 	MccName = "machine-config-controller"
+	// hybridOverlayVXLANOverhead is the number of bytes added to each packet by the VXLAN encapsulation used by the
+	// hybrid overlay
+	hybridOverlayVXLANOverhead = 50
+	// sandboxImageRegistry is the registry hosting the sandbox image given in containerd's config, every pod on the
+	// node requires it to be pulled
+	sandboxImageRegistry = "mcr.microsoft.com"
+	// subsystemsKey is the registry key holding the command lines of the Windows subsystems
+	subsystemsKey = "HKLM:\\SYSTEM\\CurrentControlSet\\Control\\Session Manager\\SubSystems"
+	// windowsSubsystemValue is the registry value holding the command line of the Windows subsystem, whose
+	// SharedSection parameter gives the desktop heap sizes
+	windowsSubsystemValue = "Windows"
+	// containerdCRIPluginTable is the header of the table of containerd's config holding the CRI plugin options
+	containerdCRIPluginTable = `[plugins."io.containerd.grpc.v1.cri"]`
+	// containerdCRIContainerdTable is the header of the table of containerd's config holding the options the CRI
+	// plugin uses when managing images and containers
+	containerdCRIContainerdTable = `[plugins."io.containerd.grpc.v1.cri".containerd]`
+	// containerdRuntimesTable is the header of the table of containerd's config holding the runtime handlers
+	containerdRuntimesTable = `[plugins."io.containerd.grpc.v1.cri".containerd.runtimes]`
+	// containerdRuntimeType is the containerd shim used by Windows runtime handlers
+	containerdRuntimeType = "io.containerd.runhcs.v1"
+	// kubeletClientCAReloadTimeout is how long kubelet is given to pick up a change to its client CA file on its own
+	// before being restarted. kubelet checks the file for changes about once a minute.
+	kubeletClientCAReloadTimeout = 2 * time.Minute
+	// bootstrapTokenMinValidity is how much longer the node-bootstrapper token must be valid for when the bootstrap
+	// kubeconfig is generated, so that kubelet does not fail to bootstrap because the token expired while the rest of
+	// the instance was being configured
+	bootstrapTokenMinValidity = 30 * time.Minute
+	// defaultServiceNodePortRange is the NodePort range of clusters whose network config does not give one
+	defaultServiceNodePortRange = "30000-32767"
 )
 
-// nodeConfig holds the information to make the given VM a kubernetes node. As of now, it holds the information
+// sharedSectionRegex matches the SharedSection parameter of the Windows subsystem command line, such as
+// "SharedSection=1024,20480,768", capturing the system-wide and interactive desktop heap sizes
+var sharedSectionRegex = regexp.MustCompile(`SharedSection=(\d+),(\d+)(,\d+)?`)
+
+// WICDTokenSecretNames are the names of the secrets which can hold a WICD ServiceAccount token. Token rotation
+// alternates between them, so the previous token stays valid until every node has been given the new one.
+var WICDTokenSecretNames = []string{windows.WicdServiceName, windows.WicdServiceName + "-rotated"}
+
+// kubeletSupportsPodPidsLimit indicates if the Windows kubelet enforces the podPidsLimit option. Pod PID limits are
+// implemented with the Linux pids cgroup, which has no equivalent on Windows, so the option is not written to the
+// kubelet config while this is false.
+var kubeletSupportsPodPidsLimit = false
+
+// kubeletSupportsGracefulNodeShutdown indicates if the Windows kubelet shipped with WMCO implements graceful node
+// shutdown, which was added to the Windows kubelet in Kubernetes 1.32 behind the WindowsGracefulNodeShutdown feature
+// gate. The shutdown grace periods are not written to the kubelet config while this is false.
+var kubeletSupportsGracefulNodeShutdown = false
+
+// hardenedStreamingConnectionIdleTimeout is the idle timeout of kubelet's streaming connections, such as those of exec
+// and port-forward sessions, in the kubelet hardening profile
+const hardenedStreamingConnectionIdleTimeout = 5 * time.Minute
+
+// hardenedEventRecordQPS is the rate at which kubelet may create events in the kubelet hardening profile. It is set
+// explicitly so that events needed for auditing are not dropped at a lower, unconfigured limit.
+const hardenedEventRecordQPS = int32(50)
+
+// fallbackKubeletMaxPods is the maximum number of pods on nodes of platforms absent from defaultKubeletMaxPods, if no
+// maximum is given. It matches the maximum used on Linux workers.
+const fallbackKubeletMaxPods = int32(250)
+
+// defaultKubeletMaxPods is the maximum number of pods on nodes of each platform whose network limits pod density, if
+// no maximum is given. Windows instances on AWS only assign IPs from their primary network interface, whose secondary
+// IPv4 addresses are limited by instance type, and Azure network interfaces are limited in their IP configurations. 110
+// is the pod density Windows nodes are validated at upstream.
+var defaultKubeletMaxPods = map[configv1.PlatformType]int32{
+	configv1.AWSPlatformType:   110,
+	configv1.AzurePlatformType: 110,
+}
+
+// cgroupsPerQOSMinBuild is the earliest Windows build, that of Windows Server 2025, on which the experimental
+// kubeletCgroupsPerQOS setting is applied
+const cgroupsPerQOSMinBuild = 26100
+
+// windowsTaint is the taint every Windows node must have, so that Linux pods are not scheduled onto it
+var windowsTaint = core.Taint{Key: "os", Value: "Windows", Effect: core.TaintEffectNoSchedule}
+
+// NodeConfig holds the information to make the given VM a kubernetes node. As of now, it holds the information
 // related to kubeclient and the windowsVM.
-type nodeConfig struct {
+type NodeConfig struct {
 	client client.Client
 	// k8sclientset holds the information related to kubernetes clientset
 	k8sclientset *kubernetes.Clientset
@@ -80,9 +176,9 @@ type nodeConfig struct {
 	node *core.Node
 	// publicKeyHash is the hash of the public key present on the VM
 	publicKeyHash string
-	// clusterServiceCIDR holds the service CIDR for cluster
-	clusterServiceCIDR string
-	log                logr.Logger
+	// clusterServiceCIDRs holds the service CIDRs for cluster, one per IP family on dual-stack clusters
+	clusterServiceCIDRs []string
+	log                 logr.Logger
 	// additionalAnnotations are extra annotations that should be applied to configured nodes
 	additionalAnnotations map[string]string
 	// additionalLabels are extra labels that should be applied to configured nodes
@@ -91,6 +187,17 @@ type nodeConfig struct {
 	platformType configv1.PlatformType
 	// wmcoNamespace is the namespace WMCO is deployed to
 	wmcoNamespace string
+	// settings holds the user provided configuration options for the instance
+	settings *settings.Settings
+	// settingsErr is the error the settings ConfigMap was rejected with, in which case settings holds the default
+	// settings. Operations applying settings to the instance are refused, rather than reverting it to the defaults.
+	settingsErr error
+	// registerNode indicates if kubelet registers the Node, it is false when the Node is registered out-of-band
+	registerNode bool
+	// configurationID identifies the most recent configuration of the instance, and is empty until it is configured
+	configurationID string
+	// newHostname is the hostname WMCO sets on the instance, empty if the hostname of the instance is left unchanged
+	newHostname string
 }
 
 // ErrWriter is a wrapper to enable error-level logging inside kubectl drainer implementation
@@ -115,45 +222,223 @@ func (ow OutWriter) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
-// NewNodeConfig creates a new instance of nodeConfig to be used by the caller.
+// NewNodeConfig creates a new instance of NodeConfig to be used by the caller.
 // hostName having a value will result in the VM's hostname being changed to the given value.
-func NewNodeConfig(c client.Client, clientset *kubernetes.Clientset, clusterServiceCIDR, wmcoNamespace string,
+func NewNodeConfig(c client.Client, clientset *kubernetes.Clientset, clusterServiceCIDRs []string, wmcoNamespace string,
 	instanceInfo *instance.Info, signer ssh.Signer, additionalLabels,
-	additionalAnnotations map[string]string, platformType configv1.PlatformType) (*nodeConfig, error) {
+	additionalAnnotations map[string]string, platformType configv1.PlatformType) (*NodeConfig, error) {
 
-	if err := cluster.ValidateCIDR(clusterServiceCIDR); err != nil {
-		return nil, fmt.Errorf("error receiving valid CIDR value for "+
-			"creating new node config: %w", err)
+	log := ctrl.Log.WithName(fmt.Sprintf("nc %s", instanceInfo.Address))
+	s, settingsErr := settings.Get(context.TODO(), c, wmcoNamespace)
+	if settingsErr != nil {
+		if !errors.Is(settingsErr, settings.ErrInvalid) {
+			return nil, settingsErr
+		}
+		// the instance can still be connected to, and operations which do not depend on the settings performed
+		log.Error(settingsErr, "using default settings to connect to the instance")
+		s = &settings.Settings{}
 	}
 
-	clusterDNS, err := cluster.GetDNS(clusterServiceCIDR)
+	clusterDNS, err := cluster.GetClusterDNS(clusterServiceCIDRs, s.KubeletClusterDNS)
 	if err != nil {
-		return nil, fmt.Errorf("error getting cluster DNS from service CIDR: %s: %w", clusterServiceCIDR, err)
+		return nil, fmt.Errorf("error receiving valid CIDR values for "+
+			"creating new node config: %w", err)
 	}
 
-	log := ctrl.Log.WithName(fmt.Sprintf("nc %s", instanceInfo.Address))
-	win, err := windows.New(clusterDNS, instanceInfo, signer, &platformType)
+	win, err := windows.New(instanceInfo, signer, windows.Options{
+		ClusterDNS:      clusterDNS,
+		Platform:        &platformType,
+		RebootDetection: &windows.RebootDetection{Delay: s.RebootDetectionDelay, Interval: s.RebootDetectionInterval},
+		SSHAlgorithms:   SSHAlgorithms(s),
+		HostKeys:        SSHHostKeys(s),
+		SFTPOptions:     SFTPOptions(s),
+		LogDir:          s.LogDir,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("error instantiating Windows instance from VM: %w", err)
 	}
 
-	return &nodeConfig{client: c, k8sclientset: clientset, Windows: win, node: instanceInfo.Node,
-		platformType: platformType, wmcoNamespace: wmcoNamespace, clusterServiceCIDR: clusterServiceCIDR,
+	return &NodeConfig{client: c, k8sclientset: clientset, Windows: win, node: instanceInfo.Node,
+		platformType: platformType, wmcoNamespace: wmcoNamespace, clusterServiceCIDRs: clusterServiceCIDRs,
 		publicKeyHash: CreatePubKeyHashAnnotation(signer.PublicKey()), log: log, additionalLabels: additionalLabels,
-		additionalAnnotations: additionalAnnotations}, nil
+		additionalAnnotations: additionalAnnotations, settings: s, settingsErr: settingsErr,
+		registerNode: !instanceInfo.ExternallyRegistered, newHostname: instanceInfo.NewHostname}, nil
+}
+
+// checkSettings returns an error if the settings ConfigMap is not valid, for operations which would apply the default
+// settings to the instance in place of the settings given by the user
+func (nc *NodeConfig) checkSettings() error {
+	if nc.settingsErr != nil {
+		return fmt.Errorf("refusing to apply default settings: %w", nc.settingsErr)
+	}
+	return nil
+}
+
+// SSHAlgorithms returns the SSH algorithms given by the settings, to be used when connecting to instances
+func SSHAlgorithms(s *settings.Settings) *windows.SSHAlgorithms {
+	return &windows.SSHAlgorithms{Ciphers: s.SSHCiphers, KeyExchanges: s.SSHKeyExchanges, MACs: s.SSHMACs,
+		HostKeyAlgorithms: s.SSHHostKeyAlgorithms}
+}
+
+// SFTPOptions returns the options of the SFTP client given by the settings, to be used when transferring files to
+// instances
+func SFTPOptions(s *settings.Settings) *windows.SFTPOptions {
+	return &windows.SFTPOptions{MaxPacket: s.SFTPMaxPacketSize, MaxConcurrentRequests: s.SFTPMaxConcurrentRequests}
+}
+
+// SSHHostKeys returns how the host keys of instances are verified as given by the settings, to be used when connecting
+// to instances. nil is returned if host keys are not verified.
+func SSHHostKeys(s *settings.Settings) *windows.HostKeyVerification {
+	if s.SSHHostKeyPolicy == settings.SSHHostKeyPolicyIgnore {
+		return nil
+	}
+	return &windows.HostKeyVerification{KnownHosts: s.SSHKnownHosts,
+		RequireKnownHost: s.SSHHostKeyPolicy == settings.SSHHostKeyPolicyStrict}
+}
+
+// configurationPhase is a phase of the configuration of an instance
+type configurationPhase string
+
+const (
+	phaseNotStarted           configurationPhase = "starting"
+	phasePreparingFiles       configurationPhase = "preparing files"
+	phaseApplyingHostSettings configurationPhase = "applying host settings"
+	phaseCheckingConnectivity configurationPhase = "checking connectivity"
+	phaseBootstrapping        configurationPhase = "bootstrapping"
+	phaseRegisteringNode      configurationPhase = "registering node"
+	phaseConfiguringWICD      configurationPhase = "configuring WICD"
+	phaseWaitingForWICD       configurationPhase = "waiting for WICD"
+	phaseValidatingNode       configurationPhase = "validating node"
+	phaseRunningValidation    configurationPhase = "running post-configuration validation"
+	phaseCompletingNodeConfig configurationPhase = "completing node configuration"
+)
+
+// errConfigurationAborted is returned by a configuration which was aborted while one of its steps was running
+var errConfigurationAborted = errors.New("configuration was aborted")
+
+// configurationProgress tracks the phase a configuration of an instance is in, so that a configuration which has run
+// out of time can be reported and stopped while one of its steps is still running
+type configurationProgress struct {
+	mu    sync.Mutex
+	phase configurationPhase
+	// aborted is true once the configuration has run out of time, after which it cannot enter another phase
+	aborted bool
+	// cleanup undoes the part of the configuration done so far, and is nil if there is nothing to undo or it has run
+	cleanup func()
+}
+
+// newConfigurationProgress returns the progress of a configuration which has not started yet
+func newConfigurationProgress() *configurationProgress {
+	return &configurationProgress{phase: phaseNotStarted}
+}
+
+// enter moves the configuration to the given phase, returning an error if the configuration has been aborted
+func (p *configurationProgress) enter(phase configurationPhase) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.aborted {
+		return errConfigurationAborted
+	}
+	p.phase = phase
+	return nil
+}
+
+// setCleanup sets the function undoing the part of the configuration done so far
+func (p *configurationProgress) setCleanup(cleanup func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cleanup = cleanup
+}
+
+// abort stops the configuration from entering another phase, and returns the phase it is in
+func (p *configurationProgress) abort() configurationPhase {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.aborted = true
+	return p.phase
+}
+
+// cleanUp runs the cleanup function set, if it has not already run. It is only run once, so that a configuration
+// which was aborted while one of its steps was running cannot undo a later configuration of the same instance.
+func (p *configurationProgress) cleanUp() {
+	p.mu.Lock()
+	cleanup := p.cleanup
+	p.cleanup = nil
+	p.mu.Unlock()
+	if cleanup != nil {
+		cleanup()
+	}
+}
+
+// Configure configures the Windows VM to make it a Windows worker node. Each configuration is given a new ID, which is
+// added to the log entries made during it, to errors returned by it and to the node's annotations. A configuration
+// which does not complete within the configured timeout is aborted, and the services started on the instance are
+// stopped so that the node is not left Ready. Steps waiting on the API server are cancelled when the timeout is
+// reached, while a step running commands on the instance is allowed to return. Configure only returns once the
+// aborted configuration has stopped, so that it cannot overlap with a later configuration of the same instance.
+func (nc *NodeConfig) Configure() error {
+	if err := nc.checkSettings(); err != nil {
+		return err
+	}
+	nc.configurationID = string(uuid.NewUUID())
+	nc.log = nc.log.WithValues(configurationIDLogKey, nc.configurationID)
+	nc.Windows.AddLogValues(configurationIDLogKey, nc.configurationID)
+	timeout := nc.settings.EffectiveConfigurationTimeout()
+	progress := newConfigurationProgress()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	result := make(chan error, 1)
+	go func() {
+		result <- nc.configure(ctx, progress)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-result:
+		if err != nil {
+			return fmt.Errorf("configuration %s failed: %w", nc.configurationID, err)
+		}
+		return nil
+	case <-timer.C:
+		phase := progress.abort()
+		cancel()
+		nc.log.Info("aborting configuration which did not complete in time, waiting for the running step to return",
+			"timeout", timeout, "phase", phase)
+		<-result
+		progress.cleanUp()
+		return fmt.Errorf("configuration %s did not complete within %s, timed out while %s", nc.configurationID,
+			timeout, phase)
+	}
+}
+
+// ConfigurationID returns the ID of the most recent configuration of the instance, or an empty string if Configure
+// has not been called
+func (nc *NodeConfig) ConfigurationID() string {
+	return nc.configurationID
 }
 
-// Configure configures the Windows VM to make it a Windows worker node
-func (nc *nodeConfig) Configure() error {
+// configure performs the configuration of the Windows VM done by Configure, reporting the phase it is in through the
+// given progress. Requests to the API server are made with the given context, so that they stop once it is cancelled.
+func (nc *NodeConfig) configure(ctx context.Context, progress *configurationProgress) error {
 	drainHelper := nc.newDrainHelper()
+	// A Node which WMCO never annotated was left partially joined by an earlier attempt which could not clean it up,
+	// such as when the operator was restarted during bootstrapping. It is removed so that it is registered again.
+	if nc.node != nil && nc.node.GetAnnotations()[PubKeyHashAnnotation] == "" {
+		nc.removePartiallyJoinedNode()
+	}
+	// joining indicates the instance is being joined to the cluster as a new node, rather than being reconfigured
+	joining := nc.node == nil
 	// If a Node object exists already, it implies that we are reconfiguring and we should cordon the node
-	if nc.node != nil {
+	if !joining {
 		// Make a best effort to cordon the node until it is fully configured
 		if err := drain.RunCordonOrUncordon(drainHelper, nc.node, true); err != nil {
 			nc.log.Info("unable to cordon", "node", nc.node.GetName(), "error", err)
 		}
 	}
 
+	if err := progress.enter(phasePreparingFiles); err != nil {
+		return err
+	}
 	if err := nc.createBootstrapFiles(); err != nil {
 		return err
 	}
@@ -166,21 +451,65 @@ func (nc *nodeConfig) Configure() error {
 	if err := nc.SyncTrustedCABundle(); err != nil {
 		return err
 	}
-	wicdKC, err := nc.generateWICDKubeconfig()
+	wicdKC, wicdTokenSecret, err := nc.generateWICDKubeconfig()
+	if err != nil {
+		return err
+	}
+	// kubelet may have registered the Node by the time a later step fails
+	progress.setCleanup(func() { nc.cleanupFailedConfiguration(wicdKC, joining) })
+
+	if err := progress.enter(phaseApplyingHostSettings); err != nil {
+		return err
+	}
+	// The node, if any, has been cordoned so the instance can be restarted directly if a setting requires it
+	rebootNeeded, err := nc.ensureHostSettings()
 	if err != nil {
 		return err
 	}
+	if rebootNeeded {
+		if err := nc.Windows.RebootAndReinitialize(); err != nil {
+			return fmt.Errorf("error restarting instance to apply settings: %w", err)
+		}
+	}
+
+	if nc.platformType == configv1.VSpherePlatformType {
+		nc.logVSphereNodeIP()
+	}
+
+	if err := progress.enter(phaseCheckingConnectivity); err != nil {
+		return err
+	}
+	if err := nc.checkAPIServerConnectivity(); err != nil {
+		return err
+	}
+	if err := nc.checkRegistryConnectivity(); err != nil {
+		return err
+	}
+	nc.removeStaleTempFiles()
 
+	if err := progress.enter(phaseBootstrapping); err != nil {
+		return err
+	}
 	wmcoVersion := version.Get()
 	// Start all required services to bootstrap a node object using WICD
-	if err := nc.Windows.Bootstrap(wmcoVersion, nc.wmcoNamespace, wicdKC); err != nil {
+	if err := nc.Windows.Bootstrap(wmcoVersion, nc.wmcoNamespace, wicdKC, nc.settings.MinFreeMemory); err != nil {
+		progress.cleanUp()
+		return fmt.Errorf("bootstrapping the Windows instance failed: %w", err)
+	}
+	// a containerd config left on a BYOH instance can point containerd at other CNI directories, which leaves pods
+	// without networking instead of failing the configuration
+	if err := nc.Windows.ValidateContainerdCNIConfig(); err != nil {
+		progress.cleanUp()
 		return fmt.Errorf("bootstrapping the Windows instance failed: %w", err)
 	}
 
 	// Perform rest of the configuration with the kubelet running
 	err = func() error {
+		if err := progress.enter(phaseRegisteringNode); err != nil {
+			return err
+		}
 		if nc.node == nil {
-			// populate node object in nodeConfig in the case of a new Windows instance
+			// populate node object in NodeConfig in the case of a new Windows instance
 			if err := nc.setNode(false); err != nil {
 				return fmt.Errorf("error setting node object: %w", err)
 			}
@@ -191,37 +520,88 @@ func (nc *nodeConfig) Configure() error {
 			nc.log.Info("unable to cordon", "node", nc.node.GetName(), "error", err)
 		}
 
+		// kubelet only applies the Windows taint when registering the Node, so it must be added to externally
+		// registered Nodes before any workloads can be scheduled onto them
+		if !nc.registerNode {
+			if err := cloudnodeutil.AddOrUpdateTaintOnNode(nc.k8sclientset, nc.node.GetName(),
+				&windowsTaint); err != nil {
+				return fmt.Errorf("error applying Windows taint to node %s: %w", nc.node.GetName(), err)
+			}
+		}
+
 		// Ensure we are labeling and annotating the node as soon as the Node object is created, so that we can identify
 		// which controller should be watching it
-		annotationsToApply := map[string]string{PubKeyHashAnnotation: nc.publicKeyHash}
+		annotationsToApply := make(map[string]string, len(nc.additionalAnnotations)+2)
 		for key, value := range nc.additionalAnnotations {
 			annotationsToApply[key] = value
 		}
-		if err := metadata.ApplyLabelsAndAnnotations(context.TODO(), nc.client, *nc.node, nc.additionalLabels,
+		annotationsToApply[PubKeyHashAnnotation] = nc.publicKeyHash
+		annotationsToApply[metadata.WICDTokenAnnotation] = wicdTokenSecret
+		annotationsToApply[metadata.ConfigurationIDAnnotation] = nc.configurationID
+		for key, value := range nc.inventoryAnnotations() {
+			annotationsToApply[key] = value
+		}
+		if err := metadata.ApplyLabelsAndAnnotations(ctx, nc.client, *nc.node, nc.additionalLabels,
 			annotationsToApply); err != nil {
 			return fmt.Errorf("error updating public key hash and additional annotations on node %s: %w",
 				nc.node.GetName(), err)
 		}
 
-		if err := nc.Windows.ConfigureWICD(nc.wmcoNamespace, wicdKC); err != nil {
+		if err := progress.enter(phaseConfiguringWICD); err != nil {
+			return err
+		}
+		if err := nc.Windows.ConfigureWICD(nc.wmcoNamespace, wicdKC, nc.wicdRecovery(),
+			nc.settings.WICDServiceAccount); err != nil {
 			return fmt.Errorf("configuring WICD failed: %w", err)
 		}
 		// Set the desired version annotation, communicating to WICD which Windows services configmap to use
-		if err := metadata.ApplyDesiredVersionAnnotation(context.TODO(), nc.client, *nc.node, wmcoVersion); err != nil {
+		if err := metadata.ApplyDesiredVersionAnnotation(ctx, nc.client, *nc.node, wmcoVersion); err != nil {
 			return fmt.Errorf("error updating desired version annotation on node %s: %w", nc.node.GetName(), err)
 		}
 
+		if err := progress.enter(phaseWaitingForWICD); err != nil {
+			return err
+		}
 		// Wait for version annotation. This prevents uncordoning the node until all node services and networks are up
-		if err := metadata.WaitForVersionAnnotation(context.TODO(), nc.client, nc.node.Name); err != nil {
+		wicdTimeout := retry.Timeout
+		if nc.settings.WICDConfigurationTimeout > 0 {
+			wicdTimeout = nc.settings.WICDConfigurationTimeout
+		}
+		if err := metadata.WaitForVersionAnnotation(ctx, nc.client, nc.node.Name,
+			wicdTimeout); err != nil {
+			nc.logWICDDiagnostics()
 			return fmt.Errorf("error waiting for proper %s annotation for node %s: %w", metadata.VersionAnnotation,
 				nc.node.GetName(), err)
 		}
 
-		// Now that the node has been fully configured, update the node object in nodeConfig once more
+		if err := progress.enter(phaseValidatingNode); err != nil {
+			return err
+		}
+		// Now that the node has been fully configured, update the node object in NodeConfig once more
 		if err := nc.setNode(false); err != nil {
 			return fmt.Errorf("error getting node object: %w", err)
 		}
+		// hybrid-overlay is running at this point, so the network it sends traffic over can be checked
+		nc.verifyOverlayMTU()
+		// WICD generates the CNI config as part of configuring the node's services, so it now references the plugins
+		// transferred during bootstrapping
+		if err := nc.Windows.ValidateCNIConsistency(); err != nil {
+			return fmt.Errorf("error validating CNI setup of node %s: %w", nc.node.GetName(), err)
+		}
+		if err := nc.ensureWindowsExporterReachable(); err != nil {
+			return err
+		}
+
+		if err := progress.enter(phaseRunningValidation); err != nil {
+			return err
+		}
+		if err := nc.runPostConfigurationValidation(); err != nil {
+			return err
+		}
 
+		if err := progress.enter(phaseCompletingNodeConfig); err != nil {
+			return err
+		}
 		// If we deploy on Azure, we have to explicitly remove the cloud taint, because the cloud node manager running
 		// on the node can't do it itself, due to lack of RBAC permissions given by the node kubeconfig it uses.
 		if nc.platformType == configv1.AzurePlatformType {
@@ -237,12 +617,12 @@ func (nc *nodeConfig) Configure() error {
 			}
 		}
 
-		// Uncordon the node now that it is fully configured
-		if err := drain.RunCordonOrUncordon(drainHelper, nc.node, false); err != nil {
-			return fmt.Errorf("error uncordoning the node %s: %w", nc.node.GetName(), err)
+		// Uncordon the node now that it is fully configured, unless the user wants to validate it first
+		if err := nc.uncordonConfiguredNode(drainHelper); err != nil {
+			return err
 		}
 
-		if err := metadata.RemoveUpgradingLabel(context.TODO(), nc.client, nc.node); err != nil {
+		if err := metadata.RemoveUpgradingLabel(ctx, nc.client, nc.node); err != nil {
 			return fmt.Errorf("error removing upgrading label from node %s: %w", nc.node.GetName(), err)
 		}
 
@@ -251,220 +631,1674 @@ func (nc *nodeConfig) Configure() error {
 		return nil
 	}()
 
-	// Stop the kubelet so that the node is marked NotReady in case of an error in configuration. We are stopping all
-	// the required services as they are interdependent and is safer to do so given the node is going to be NotReady.
+	// A node which failed its post-configuration validation is left running, but cordoned, so it can be investigated
+	var validationErr *ValidationFailedError
+	if err != nil && !errors.As(err, &validationErr) {
+		progress.cleanUp()
+	}
+	return err
+}
+
+// cleanupFailedConfiguration stops the services on the instance after its configuration failed. kubelet is stopped so
+// that the node is marked NotReady. All the required services are stopped, as they are interdependent and it is safer
+// to do so given the node is going to be NotReady. If the instance was joining the cluster, the Node it registered is
+// removed if it was left partially joined, so that it is registered again by the next attempt.
+func (nc *NodeConfig) cleanupFailedConfiguration(wicdKC string, joining bool) {
+	if err := nc.Windows.RunWICDCleanup(nc.wmcoNamespace, wicdKC); err != nil {
+		nc.log.Info("Unable to mark node as NotReady", "error", err)
+	}
+	if joining {
+		nc.removePartiallyJoinedNode()
+	}
+}
+
+// removePartiallyJoinedNode deletes the Node kubelet registered for the instance if it was left partially joined by a
+// failed configuration. Nodes registered out-of-band are never deleted.
+func (nc *NodeConfig) removePartiallyJoinedNode() {
+	if !nc.registerNode {
+		return
+	}
+	node := nc.node
+	if node == nil {
+		nodes, err := nc.k8sclientset.CoreV1().Nodes().List(context.TODO(),
+			meta.ListOptions{LabelSelector: WindowsOSLabel})
+		if err != nil {
+			nc.log.Info("unable to check for a partially joined node", "error", err)
+			return
+		}
+		if node = nodeutil.FindByAddress(nc.GetIPv4Address(), nodes); node == nil {
+			return
+		}
+	}
+	// The Node may have been configured by WICD since it was last read
+	node, err := nc.k8sclientset.CoreV1().Nodes().Get(context.TODO(), node.GetName(), meta.GetOptions{})
 	if err != nil {
-		if err := nc.Windows.RunWICDCleanup(nc.wmcoNamespace, wicdKC); err != nil {
-			nc.log.Info("Unable to mark node as NotReady", "error", err)
+		if !k8sapierrors.IsNotFound(err) {
+			nc.log.Info("unable to check for a partially joined node", "error", err)
 		}
+		return
 	}
-	return err
+	if !isPartiallyJoined(node) {
+		return
+	}
+	uid := node.GetUID()
+	if err = nc.k8sclientset.CoreV1().Nodes().Delete(context.TODO(), node.GetName(),
+		meta.DeleteOptions{Preconditions: &meta.Preconditions{UID: &uid}}); err != nil &&
+		!k8sapierrors.IsNotFound(err) {
+		nc.log.Error(err, "unable to delete partially joined node", "node", node.GetName())
+		return
+	}
+	nc.log.Info("deleted partially joined node", "node", node.GetName())
+	nc.node = nil
 }
 
-// safeReboot safely restarts the underlying instance, first cordoning and draining the associated node.
-// Waits for reboot to take effect before uncordoning the node.
-func (nc *nodeConfig) SafeReboot(ctx context.Context) error {
-	if nc.node == nil {
-		return fmt.Errorf("safe reboot of the instance requires an associated node")
+// isPartiallyJoined returns true if the given Node has not been configured by WICD and is not Ready. A Node with the
+// version annotation was configured by WICD, and a Ready Node may be running workloads, so neither is considered
+// partially joined.
+func isPartiallyJoined(node *core.Node) bool {
+	if _, present := node.GetAnnotations()[metadata.VersionAnnotation]; present {
+		return false
 	}
+	ready := nodeutil.GetCondition(node, core.NodeReady)
+	return ready == nil || ready.Status != core.ConditionTrue
+}
 
-	drainer := nc.newDrainHelper()
-	if err := drain.RunCordonOrUncordon(drainer, nc.node, true); err != nil {
-		return fmt.Errorf("unable to cordon node %s: %w", nc.node.Name, err)
+// logWICDDiagnostics logs the state of WICD on the instance, to help diagnose WICD failing to configure the node
+func (nc *NodeConfig) logWICDDiagnostics() {
+	diagnostics, err := nc.Windows.GetWICDDiagnostics()
+	if err != nil {
+		nc.log.Info("unable to collect all WICD diagnostics", "error", err.Error())
 	}
-	if err := drain.RunNodeDrain(drainer, nc.node.Name); err != nil {
-		return fmt.Errorf("unable to drain node %s: %w", nc.node.Name, err)
+	if diagnostics != "" {
+		nc.log.Info("WICD diagnostics", "output", diagnostics)
 	}
+}
 
-	// HNS networks conflicts with the persistent route to the metadata endpoint in AWS. Explicitly remove them
-	// to allow the same instance to be configured as a node again.
-	if nc.platformType == configv1.AWSPlatformType {
-		if err := nc.Windows.EnsureHNSNetworksAreRemoved(); err != nil {
-			return err
-		}
+// logVSphereNodeIP logs the IP address kubelet will register the node with, which on vSphere is the address reported
+// by VMware Tools. A warning is given if it differs from the address WMCO reaches the instance through, as that can
+// indicate kubelet will use a non-routable interface on an instance with multiple NICs.
+func (nc *NodeConfig) logVSphereNodeIP() {
+	guestIP, err := nc.Windows.GetVSphereGuestIP()
+	if err != nil {
+		nc.log.Info("unable to get VMware Tools reported IP, node IP falls back to the default route address",
+			"error", err.Error())
+		return
 	}
+	if guestIP != nc.Windows.GetIPv4Address() {
+		nc.log.Info("WARNING: node IP reported by VMware Tools differs from the instance address", "nodeIP", guestIP,
+			"address", nc.Windows.GetIPv4Address())
+		return
+	}
+	nc.log.V(1).Info("node IP reported by VMware Tools", "nodeIP", guestIP)
+}
 
-	if err := nc.Windows.RebootAndReinitialize(); err != nil {
+// EnsureHostSettings ensures the instance-level settings given through the settings ConfigMap are applied to the
+// instance. Settings that have not been given are left unchanged. If a setting only takes effect after a restart, the
+// node is annotated so that the instance is safely rebooted.
+func (nc *NodeConfig) EnsureHostSettings() error {
+	if err := nc.checkSettings(); err != nil {
 		return err
 	}
-	// Remove the reboot annotation after we can re-init an SSH connection so we know the reboot occurred successfully
-	if err := metadata.RemoveRebootAnnotation(ctx, nc.client, *nc.node); err != nil {
+	rebootNeeded, err := nc.ensureHostSettings()
+	if err != nil {
 		return err
 	}
+	if !rebootNeeded {
+		return nil
+	}
+	if nc.node == nil {
+		return fmt.Errorf("applied settings require a restart of the instance, but no associated node exists")
+	}
+	if err = metadata.ApplyRebootAnnotation(context.TODO(), nc.client, *nc.node); err != nil {
+		return fmt.Errorf("error requesting reboot of node %s: %w", nc.node.Name, err)
+	}
+	return nil
+}
 
-	if err := drain.RunCordonOrUncordon(drainer, nc.node, false); err != nil {
-		return fmt.Errorf("unable to uncordon node %s: %w", nc.node.Name, err)
+// EnsureTimezone sets the timezone given through the settings ConfigMap on the instance again if it has since been
+// changed on the instance, such as by an administrator logged on to it. Nothing is done if no timezone is given.
+func (nc *NodeConfig) EnsureTimezone() error {
+	if err := nc.checkSettings(); err != nil {
+		return err
+	}
+	if nc.settings.Timezone == "" {
+		return nil
+	}
+	if err := nc.Windows.SetTimezone(nc.settings.Timezone); err != nil {
+		return fmt.Errorf("error setting timezone: %w", err)
 	}
 	return nil
 }
 
-// getWICDServiceAccountSecret returns the secret which holds the credentials for the WICD ServiceAccount, creating one
-// if necessary
-func (nc *nodeConfig) getWICDServiceAccountSecret() (*core.Secret, error) {
-	var tokenSecret core.Secret
-	err := nc.client.Get(context.TODO(),
-		types.NamespacedName{Namespace: nc.wmcoNamespace, Name: windows.WicdServiceName}, &tokenSecret)
-	if err != nil {
-		if k8sapierrors.IsNotFound(err) {
-			return nc.createWICDServiceAccountTokenSecret()
+// ensureHostSettings applies the instance-level settings to the instance, returning true if the instance must be
+// restarted for the changes to take effect
+func (nc *NodeConfig) ensureHostSettings() (bool, error) {
+	rebootNeeded := false
+	if nc.settings.Timezone != "" {
+		if err := nc.Windows.SetTimezone(nc.settings.Timezone); err != nil {
+			return false, fmt.Errorf("error setting timezone: %w", err)
+		}
+	}
+	if len(nc.settings.NTPServers) > 0 {
+		if err := nc.Windows.SetNTPServers(nc.settings.NTPServers); err != nil {
+			return false, fmt.Errorf("error setting NTP servers: %w", err)
 		}
-		return nil, err
 	}
-	if validWICDServiceAccountTokenSecret(tokenSecret) {
-		return &tokenSecret, nil
+	if nc.settings.PowerPlan != "" {
+		if err := nc.Windows.SetPowerPlan(nc.settings.PowerPlan); err != nil {
+			return false, fmt.Errorf("error setting power plan: %w", err)
+		}
 	}
-
-	// If the secret is invalid, a new one should be created
-	if err = nc.client.Delete(context.TODO(), &tokenSecret); err != nil {
-		return nil, fmt.Errorf("error deleting invalid WICD service account token secret: %w", err)
+	if nc.settings.DynamicPortRange != nil {
+		if err := nc.ensureDynamicPortRange(*nc.settings.DynamicPortRange); err != nil {
+			return false, fmt.Errorf("error setting dynamic port range: %w", err)
+		}
+	}
+	profiles := make([]string, 0, len(nc.settings.FirewallProfiles))
+	for profile := range nc.settings.FirewallProfiles {
+		profiles = append(profiles, profile)
+	}
+	sort.Strings(profiles)
+	for _, profile := range profiles {
+		if err := nc.Windows.SetFirewallProfileState(profile, nc.settings.FirewallProfiles[profile]); err != nil {
+			return false, fmt.Errorf("error setting firewall profile state: %w", err)
+		}
+	}
+	if nc.settings.NonInteractiveDesktopHeapKB > 0 {
+		changed, err := ensureNonInteractiveDesktopHeap(nc.Windows, nc.settings.NonInteractiveDesktopHeapKB)
+		if err != nil {
+			return false, fmt.Errorf("error setting non-interactive desktop heap size: %w", err)
+		}
+		if changed {
+			nc.log.Info("set non-interactive desktop heap size, reboot required", "sizeKB",
+				nc.settings.NonInteractiveDesktopHeapKB)
+		}
+		rebootNeeded = rebootNeeded || changed
+	}
+	if nc.settings.PagefileMinSizeMB > 0 {
+		changed, err := nc.Windows.SetPagefile(nc.settings.PagefileMinSizeMB)
+		if err != nil {
+			return false, fmt.Errorf("error setting pagefile size: %w", err)
+		}
+		rebootNeeded = rebootNeeded || changed
+	}
+	if nc.settings.CrashDumpType != "" {
+		changed, err := nc.Windows.SetCrashDumpPolicy(nc.settings.CrashDumpType != settings.CrashDumpTypeNone,
+			nc.settings.CrashDumpType)
+		if err != nil {
+			return false, fmt.Errorf("error setting crash dump policy: %w", err)
+		}
+		rebootNeeded = rebootNeeded || changed
+	}
+	if len(nc.settings.SChannelDisabledProtocols) > 0 {
+		changed, err := nc.Windows.EnsureSChannelProtocols(nc.settings.SChannelDisabledProtocols)
+		if err != nil {
+			return false, fmt.Errorf("error disabling SChannel protocols: %w", err)
+		}
+		rebootNeeded = rebootNeeded || changed
 	}
-	return nc.createWICDServiceAccountTokenSecret()
+	if nc.settings.AddK8sDirsToPath {
+		for _, dir := range []string{windows.K8sDir, windows.ContainerdDir} {
+			if err := nc.Windows.EnsurePathEntry(dir); err != nil {
+				return false, err
+			}
+		}
+	}
+	return rebootNeeded, nil
 }
 
-// createWICDServiceAccountTokenSecret creates a secret with a long-lived API token for the WICD ServiceAccount and
-// waits for the secret data to be populated
-func (nc *nodeConfig) createWICDServiceAccountTokenSecret() (*core.Secret, error) {
-	ctx := context.TODO()
-	err := nc.client.Create(ctx, secrets.GenerateServiceAccountTokenSecret(nc.wmcoNamespace, windows.WicdServiceName))
+// ensureNonInteractiveDesktopHeap ensures the desktop heap of non-interactive desktops, used by processes started by
+// services, is the given size in KB. Each process of a non-interactive desktop, such as the containerd shim of each
+// container, consumes some of the desktop heap. Once the heap is exhausted, new processes fail to start with errors
+// which do not mention the cause, so nodes with high pod density or churn may require a larger heap than the default.
+// Returns true if the size was changed, which only takes effect after a reboot.
+func ensureNonInteractiveDesktopHeap(reg windows.Registry, sizeKB int) (bool, error) {
+	subsystem, err := reg.GetRegistryValue(subsystemsKey, windowsSubsystemValue)
 	if err != nil {
-		return nil, fmt.Errorf("error creating secret for WICD ServiceAccount: %w", err)
+		return false, err
 	}
-	secret := &core.Secret{}
-	// wait for the secret data to be populated
-	err = wait.PollUntilContextTimeout(ctx, time.Second, time.Minute, true,
-		func(ctx context.Context) (done bool, err error) {
-			secret, err = nc.k8sclientset.CoreV1().Secrets(nc.wmcoNamespace).Get(ctx, windows.WicdServiceName,
-				meta.GetOptions{})
-			if err != nil {
-				return false, nil
-			}
-			caCert := secret.Data[core.ServiceAccountRootCAKey]
-			if caCert == nil {
-				return false, nil
-			}
-			token := secret.Data[core.ServiceAccountTokenKey]
-			if token == nil {
-				return false, nil
-			}
-			return true, nil
-		})
-	return secret, err
+	updated, err := setNonInteractiveDesktopHeap(subsystem, sizeKB)
+	if err != nil {
+		return false, err
+	}
+	return reg.EnsureRegistryValue(subsystemsKey, windowsSubsystemValue, updated)
 }
 
-// createBootstrapFiles creates all prerequisite files on the node required to start kubelet using latest ignition spec
-func (nc *nodeConfig) createBootstrapFiles() error {
-	filePathsToContents := make(map[string]string)
-	filePathsToContents, err := nc.createFilesFromIgnition()
-	if err != nil {
+// setNonInteractiveDesktopHeap returns the given Windows subsystem command line with the non-interactive desktop heap
+// size, the third value of its SharedSection parameter, set to the given size in KB
+func setNonInteractiveDesktopHeap(subsystem string, sizeKB int) (string, error) {
+	match := sharedSectionRegex.FindStringSubmatchIndex(subsystem)
+	if match == nil {
+		return "", fmt.Errorf("SharedSection parameter not found in Windows subsystem command %q", subsystem)
+	}
+	// the first two values are the sizes of the system-wide and interactive desktop heaps, which are left unchanged
+	sharedSection := fmt.Sprintf("SharedSection=%s,%s,%d", subsystem[match[2]:match[3]], subsystem[match[4]:match[5]],
+		sizeKB)
+	return subsystem[:match[0]] + sharedSection + subsystem[match[1]:], nil
+}
+
+// EnsureKubeletConfig ensures the kubelet config file on the instance reflects the current settings. kubelet is
+// restarted if the file had to be updated, so that the new configuration takes effect.
+func (nc *NodeConfig) EnsureKubeletConfig() error {
+	if err := nc.checkSettings(); err != nil {
 		return err
 	}
-	filePathsToContents[windows.BootstrapKubeconfigPath], err = nc.generateBootstrapKubeconfig()
+	nc.warnUnsupportedKubeletSettings()
+	s, err := nc.kubeletSettings()
 	if err != nil {
 		return err
 	}
-	filePathsToContents[windows.KubeletConfigPath], err = createKubeletConf(nc.clusterServiceCIDR)
+	kubeletConf, err := createKubeletConf(nc.clusterServiceCIDRs, s, nc.platformType, nc.registerNode)
+	if err != nil {
+		return fmt.Errorf("error generating kubelet config: %w", err)
+	}
+	upToDate, err := nc.Windows.FileExists(windows.KubeletConfigPath,
+		fmt.Sprintf("%x", sha256.Sum256([]byte(kubeletConf))))
 	if err != nil {
+		return fmt.Errorf("error checking %s: %w", windows.KubeletConfigPath, err)
+	}
+	if upToDate {
+		return nil
+	}
+	if err = nc.createStaticPodDir(); err != nil {
 		return err
 	}
-	return nc.write(filePathsToContents)
-}
-
-// write outputs the data to the path on the underlying Windows instance for each given pair. Creates files if needed.
-func (nc *nodeConfig) write(pathToData map[string]string) error {
-	for path, data := range pathToData {
-		dir, fileName := windows.SplitPath(path)
-		if err := nc.Windows.EnsureFileContent([]byte(data), fileName, dir); err != nil {
-			return err
-		}
+	dir, fileName := windows.SplitPath(windows.KubeletConfigPath)
+	if err = nc.Windows.EnsureFileContent([]byte(kubeletConf), fileName, dir); err != nil {
+		return err
 	}
+	if err = nc.Windows.RestartService(windows.KubeletServiceName); err != nil {
+		return fmt.Errorf("error restarting kubelet after updating its config: %w", err)
+	}
+	nc.log.Info("updated kubelet config")
 	return nil
 }
 
-// createRegistryConfigFiles creates all files on the node required for containerd to mirror images
-func (nc *nodeConfig) createRegistryConfigFiles() error {
-	configFiles, err := registries.GenerateConfigFiles(context.TODO(), nc.client)
+// inventoryAnnotations returns the annotations describing the instance's operating system, to help with fleet
+// inventory. As they are informational only, no annotations are returned if the instance cannot be queried.
+func (nc *NodeConfig) inventoryAnnotations() map[string]string {
+	info, err := nc.Windows.GetComputerInfo()
 	if err != nil {
+		nc.log.Error(err, "unable to get computer info, skipping inventory annotations")
+		return nil
+	}
+	nc.log.V(1).Info("computer info", "os", info.OSName, "version", info.OSVersion, "edition", info.Edition,
+		"memory bytes", info.TotalMemoryBytes, "logical processors", info.LogicalProcessors,
+		"manufacturer", info.Manufacturer, "model", info.Model)
+	annotations := map[string]string{metadata.OSBuildAnnotation: strconv.Itoa(info.Build)}
+	if info.Edition != "" {
+		annotations[metadata.OSEditionAnnotation] = info.Edition
+	}
+	return annotations
+}
+
+// EnsureWICDRecoveryActions ensures the WICD service on the instance is restarted after crashes as given by the
+// current settings
+func (nc *NodeConfig) EnsureWICDRecoveryActions() error {
+	if err := nc.checkSettings(); err != nil {
 		return err
 	}
-	return nc.Windows.ReplaceDir(configFiles, windows.ContainerdConfigDir)
+	return nc.Windows.SetWICDRecoveryActions(nc.wicdRecovery())
 }
 
-// createFilesFromIgnition returns the contents and write locations on the instance for any file it can create from
-// ignition spec: kubelet CA cert, cloud-config file
-func (nc *nodeConfig) createFilesFromIgnition() (map[string]string, error) {
-	ign, err := ignition.New(nc.client)
+// EnsureWICDServiceAccount ensures the WICD service on the instance logs on as the account given by the settings
+func (nc *NodeConfig) EnsureWICDServiceAccount() error {
+	if err := nc.checkSettings(); err != nil {
+		return err
+	}
+	return nc.Windows.SetWICDServiceAccount(nc.settings.WICDServiceAccount)
+}
+
+// wicdRecovery returns how the WICD service should be restarted after crashes, as given by the settings
+func (nc *NodeConfig) wicdRecovery() *windows.ServiceRecovery {
+	return &windows.ServiceRecovery{Delays: nc.settings.WICDRecoveryDelays,
+		ResetPeriod: nc.settings.WICDRecoveryResetPeriod}
+}
+
+// EnsureCredentialProviders ensures the kubelet credential provider config on the instance includes the providers
+// given by the current settings, and that their binaries are present. kubelet is restarted if the config had to be
+// updated, as it only reads the config on startup.
+func (nc *NodeConfig) EnsureCredentialProviders() error {
+	if err := nc.checkSettings(); err != nil {
+		return err
+	}
+	platformConf, err := nc.platformCredentialProviderConfig()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	kubeletArgs, err := ign.GetKubeletArgs()
+	providerConf, binaries, err := credentialProviderConfig(platformConf, nc.settings.KubeletCredentialProviders)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	// create a map of 'ignition files':'desired path on a Windows instance'
-	filesToTransfer := map[string]string{}
+	if providerConf == "" {
+		return nil
+	}
+	upToDate, err := nc.Windows.FileExists(windows.CredentialProviderConfig,
+		fmt.Sprintf("%x", sha256.Sum256([]byte(providerConf))))
+	if err != nil {
+		return fmt.Errorf("error checking %s: %w", windows.CredentialProviderConfig, err)
+	}
+	if upToDate {
+		return nil
+	}
+	if err = nc.transferCredentialProviders(binaries); err != nil {
+		return err
+	}
+	dir, fileName := windows.SplitPath(windows.CredentialProviderConfig)
+	if err = nc.Windows.EnsureFileContent([]byte(providerConf), fileName, dir); err != nil {
+		return err
+	}
+	if err = nc.Windows.RestartService(windows.KubeletServiceName); err != nil {
+		return fmt.Errorf("error restarting kubelet after updating its credential provider config: %w", err)
+	}
+	nc.log.Info("updated kubelet credential provider config")
+	return nil
+}
+
+// platformCredentialProviderConfig returns the contents of the credential provider config given by the ignition spec
+// for the cluster's platform, converted for Windows. An empty string is returned if the platform has none.
+func (nc *NodeConfig) platformCredentialProviderConfig() (string, error) {
+	ign, err := ignition.New(nc.client)
+	if err != nil {
+		return "", err
+	}
+	files, err := translateIgnitionFilesForWindows(
+		map[string]string{ignition.ECRCredentialProviderPath: windows.CredentialProviderConfig}, ign.GetFiles())
+	if err != nil {
+		return "", fmt.Errorf("error processing ignition files: %w", err)
+	}
+	return files[windows.CredentialProviderConfig], nil
+}
+
+// transferCredentialProviders copies the given credential provider binaries from the payload to the directory kubelet
+// runs credential providers from
+func (nc *NodeConfig) transferCredentialProviders(binaries []string) error {
+	for _, binary := range binaries {
+		file, err := payload.NewFileInfo(payload.CredentialProvidersDir + binary)
+		if err != nil {
+			return fmt.Errorf("unable to find binary of credential provider %s in %s: %w", binary,
+				payload.CredentialProvidersDir, err)
+		}
+		if err = nc.Windows.EnsureFile(file, windows.K8sDir); err != nil {
+			return fmt.Errorf("error transferring credential provider %s: %w", binary, err)
+		}
+	}
+	return nil
+}
+
+// EnsureContainerdConfig ensures the containerd config file on the instance reflects the current settings, repairing
+// any manual edits to the tables WMCO generates. The configs are compared semantically, so edits which only change
+// formatting or comments are left in place. Tables added by hand are kept if their header has one of the
+// userContainerdTablePrefixes. containerd is restarted if the file had to be updated, so that the new configuration
+// takes effect.
+func (nc *NodeConfig) EnsureContainerdConfig() error {
+	if err := nc.checkSettings(); err != nil {
+		return err
+	}
+	containerdConf, err := createContainerdConf(nc.settings)
+	if err != nil {
+		return fmt.Errorf("error generating containerd config: %w", err)
+	}
+	upToDate, err := nc.Windows.FileExists(windows.ContainerdConfPath,
+		fmt.Sprintf("%x", sha256.Sum256([]byte(containerdConf))))
+	if err != nil {
+		return fmt.Errorf("error checking %s: %w", windows.ContainerdConfPath, err)
+	}
+	if upToDate {
+		return nil
+	}
+	current, err := nc.Windows.GetContainerdConfig()
+	if err != nil {
+		return err
+	}
+	if current != "" {
+		expectedTables, err := parseContainerdConf(containerdConf)
+		if err != nil {
+			return fmt.Errorf("error parsing generated containerd config: %w", err)
+		}
+		currentTables, err := parseContainerdConf(current)
+		if err != nil {
+			// a config which cannot be parsed is replaced as a whole
+			nc.log.Info("replacing invalid containerd config", "error", err.Error())
+		} else {
+			drift := containerdConfDrift(currentTables, expectedTables)
+			if len(drift) == 0 {
+				return nil
+			}
+			nc.log.Info("repairing drifted containerd config", "drift", drift)
+			containerdConf = repairContainerdConf(containerdConf, currentTables, expectedTables)
+		}
+	}
+	if err = nc.createContainerdDirs(); err != nil {
+		return err
+	}
+	dir, fileName := windows.SplitPath(windows.ContainerdConfPath)
+	if err = nc.Windows.EnsureFileContent([]byte(containerdConf), fileName, dir); err != nil {
+		return err
+	}
+	if err = nc.Windows.RestartService(windows.ContainerdServiceName); err != nil {
+		return fmt.Errorf("error restarting containerd after updating its config: %w", err)
+	}
+	nc.log.Info("updated containerd config")
+	return nil
+}
+
+// createStaticPodDir creates the kubelet static pod directory given through the settings ConfigMap so kubelet can watch
+// it before the user's tooling writes the first manifest. Unlike WMCO's own manifest directory, it is not removed when
+// the instance is deconfigured.
+func (nc *NodeConfig) createStaticPodDir() error {
+	if nc.settings.KubeletStaticPodPath == "" {
+		return nil
+	}
+	if err := nc.Windows.EnsureDirectory(nc.settings.KubeletStaticPodPath); err != nil {
+		return fmt.Errorf("error creating kubelet static pod directory: %w", err)
+	}
+	return nil
+}
+
+// createContainerdDirs creates the containerd root and state directories given through the settings ConfigMap, so that
+// a directory on a volume missing from the instance fails the configuration instead of containerd's startup. They are
+// not removed when the instance is deconfigured, as is the case for containerd's default directories.
+func (nc *NodeConfig) createContainerdDirs() error {
+	for _, dir := range []string{nc.settings.ContainerdRootDir, nc.settings.ContainerdStateDir} {
+		if dir == "" {
+			continue
+		}
+		if err := nc.Windows.EnsureDirectory(dir); err != nil {
+			return fmt.Errorf("error creating containerd directory: %w", err)
+		}
+	}
+	return nil
+}
+
+// EnsureHNSEndpointPolicies ensures the additional HNS endpoint policies on the instance reflect the current settings.
+// WICD runs the network configuration script, which adds the policies to the CNI config, whenever it reconciles the
+// node's services, so the policies apply to pods created after that.
+func (nc *NodeConfig) EnsureHNSEndpointPolicies() error {
+	if err := nc.checkSettings(); err != nil {
+		return err
+	}
+	policies, err := createHNSEndpointPolicies(nc.settings)
+	if err != nil {
+		return fmt.Errorf("error generating HNS endpoint policies: %w", err)
+	}
+	return nc.Windows.EnsureHNSEndpointPolicies(policies)
+}
+
+// EnsureCNIConfig compares the CNI config on the instance against the config expected for the current cluster network
+// and settings, and has the network configuration script generate it again if it has drifted, such as after the
+// cluster's service network was changed. The subnet and provider address are resolved from the instance's HNS network
+// by the script, so they are not compared.
+func (nc *NodeConfig) EnsureCNIConfig() error {
+	if err := nc.checkSettings(); err != nil {
+		return err
+	}
+	if len(nc.clusterServiceCIDRs) == 0 {
+		return fmt.Errorf("the service network of the cluster is unknown")
+	}
+	contents, err := nc.Windows.GetCNIConfig()
+	if err != nil {
+		return err
+	}
+	drift := cniConfigDrift(contents, nc.clusterServiceCIDRs[0], nc.settings)
+	if len(drift) == 0 {
+		return nil
+	}
+	nc.log.Info("repairing drifted CNI config", "drift", drift)
+	// the script adds the policies to the CNI config, so they must be current for the repair to take
+	if err = nc.EnsureHNSEndpointPolicies(); err != nil {
+		return err
+	}
+	if err = nc.Windows.RepairCNIConfig(); err != nil {
+		return fmt.Errorf("error repairing CNI config: %w", err)
+	}
+	return nil
+}
+
+// cniConfigPolicy is an HNS endpoint policy as read from the CNI config generated on an instance, whose settings
+// depend on the type of the policy
+type cniConfigPolicy struct {
+	Name  string `json:"name"`
+	Value struct {
+		Type     string          `json:"type"`
+		Settings json.RawMessage `json:"settings"`
+	} `json:"value"`
+}
+
+// cniRouteSettings are the settings of the OutBoundNAT and SDNRoute HNS endpoint policies
+type cniRouteSettings struct {
+	ExceptionList     []string `json:"exceptionList"`
+	DestinationPrefix string   `json:"destinationPrefix"`
+}
+
+// cniConfigDrift returns a description of each way the given CNI config differs from the config generated for the
+// given service network CIDR and settings. The configs are compared semantically, so that differences in formatting
+// and ordering, such as those introduced by PowerShell's ConvertTo-Json, are not reported.
+func cniConfigDrift(contents, serviceCIDR string, s *settings.Settings) []string {
+	var config struct {
+		Name     string            `json:"name"`
+		Type     string            `json:"type"`
+		Policies []cniConfigPolicy `json:"policies"`
+	}
+	if err := json.Unmarshal([]byte(contents), &config); err != nil {
+		return []string{fmt.Sprintf("invalid CNI config: %v", err)}
+	}
+	var drift []string
+	if config.Name != windows.OVNKubeOverlayNetwork {
+		drift = append(drift, fmt.Sprintf("network name is %q instead of %q", config.Name,
+			windows.OVNKubeOverlayNetwork))
+	}
+	if config.Type != "win-overlay" {
+		drift = append(drift, fmt.Sprintf("plugin type is %q instead of \"win-overlay\"", config.Type))
+	}
+
+	expectedExceptions := sets.NewString(serviceCIDR).Insert(s.HNSOutboundNATExceptions...)
+	expectedACLs := sets.New[hnsACLSettings]()
+	for _, acl := range s.HNSACLPolicies {
+		expectedACLs.Insert(newHNSACLSettings(acl))
+	}
+	var natExceptions, routePrefixes []string
+	acls := sets.New[hnsACLSettings]()
+	for _, policy := range config.Policies {
+		switch policy.Value.Type {
+		case "OutBoundNAT", "SDNRoute":
+			var route cniRouteSettings
+			if err := json.Unmarshal(policy.Value.Settings, &route); err != nil {
+				drift = append(drift, fmt.Sprintf("invalid %s policy: %v", policy.Value.Type, err))
+				continue
+			}
+			if policy.Value.Type == "OutBoundNAT" {
+				natExceptions = append(natExceptions, route.ExceptionList...)
+			} else {
+				routePrefixes = append(routePrefixes, route.DestinationPrefix)
+			}
+		case "ACL":
+			var aclSettings hnsACLSettings
+			if err := json.Unmarshal(policy.Value.Settings, &aclSettings); err != nil {
+				drift = append(drift, fmt.Sprintf("invalid ACL policy: %v", err))
+				continue
+			}
+			acls.Insert(aclSettings)
+		}
+	}
+	if !sets.NewString(natExceptions...).Equal(expectedExceptions) {
+		drift = append(drift, fmt.Sprintf("OutBoundNAT exceptions are %v instead of %v", natExceptions,
+			expectedExceptions.List()))
+	}
+	if len(routePrefixes) != 1 || routePrefixes[0] != serviceCIDR {
+		drift = append(drift, fmt.Sprintf("SDNRoute destinations are %v instead of [%s]", routePrefixes,
+			serviceCIDR))
+	}
+	if !acls.Equal(expectedACLs) {
+		drift = append(drift, fmt.Sprintf("%d ACL policies are missing and %d are unexpected",
+			expectedACLs.Difference(acls).Len(), acls.Difference(expectedACLs).Len()))
+	}
+	return drift
+}
+
+// KubeletFlagDrift returns the flags of the given expected kubelet service, with their expected values, which the
+// kubelet service on the instance is not configured with. Flags whose expected value is resolved on the instance, such
+// as the node IP, are not compared.
+func (nc *NodeConfig) KubeletFlagDrift(expected servicescm.Service) (map[string]string, error) {
+	effective, err := nc.Windows.GetEffectiveKubeletFlags()
+	if err != nil {
+		return nil, fmt.Errorf("error getting kubelet flags: %w", err)
+	}
+	return kubeletFlagDrift(expected, effective), nil
+}
+
+// kubeletFlagDrift returns the flags of the given expected kubelet service, with their expected values, which are
+// missing from or differ in the given effective flags
+func kubeletFlagDrift(expected servicescm.Service, effective map[string]string) map[string]string {
+	var variables []string
+	for _, arg := range expected.NodeVariablesInCommand {
+		variables = append(variables, arg.Name)
+	}
+	for _, script := range expected.PowershellPreScripts {
+		if script.VariableName != "" {
+			variables = append(variables, script.VariableName)
+		}
+	}
+	drifted := make(map[string]string)
+	for flag, value := range windows.ParseServiceFlags(expected.Command) {
+		if containsAny(value, variables) {
+			continue
+		}
+		if actual, ok := effective[flag]; !ok || actual != value {
+			drifted[flag] = value
+		}
+	}
+	return drifted
+}
+
+// containsAny returns true if the given string contains any of the given substrings
+func containsAny(s string, substrings []string) bool {
+	for _, sub := range substrings {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// warnUnsupportedKubeletSettings logs a warning for each kubelet setting that has been given but is not supported by
+// the Windows kubelet, and so will not be applied
+func (nc *NodeConfig) warnUnsupportedKubeletSettings() {
+	if nc.settings.KubeletPodPidsLimit > 0 && !kubeletSupportsPodPidsLimit {
+		nc.log.Info("WARNING: ignoring kubelet pod PID limit, as it is not supported on Windows",
+			"podPidsLimit", nc.settings.KubeletPodPidsLimit)
+	}
+	if nc.settings.KubeletShutdownGracePeriod > 0 && !kubeletSupportsGracefulNodeShutdown {
+		nc.log.Info("WARNING: ignoring kubelet shutdown grace period, as graceful node shutdown is not supported by "+
+			"this kubelet version", "shutdownGracePeriod", nc.settings.KubeletShutdownGracePeriod)
+	}
+}
+
+// kubeletSettings returns the settings the kubelet config of the instance is generated from, which are the node's
+// settings without any which the instance's Windows build does not support
+func (nc *NodeConfig) kubeletSettings() (*settings.Settings, error) {
+	if !nc.settings.KubeletCgroupsPerQOS {
+		return nc.settings, nil
+	}
+	build, err := nc.Windows.GetBuildNumber()
+	if err != nil {
+		return nil, err
+	}
+	s := kubeletSettingsForBuild(nc.settings, build)
+	if !s.KubeletCgroupsPerQOS {
+		nc.log.Info("WARNING: ignoring experimental kubelet cgroupsPerQOS setting, as it is not supported on this "+
+			"Windows build", "build", build, "minimumBuild", cgroupsPerQOSMinBuild)
+	}
+	return s, nil
+}
+
+// kubeletSettingsForBuild returns the given settings without the kubelet settings not supported on the given Windows
+// build. The given settings are not modified.
+func kubeletSettingsForBuild(s *settings.Settings, build int) *settings.Settings {
+	if !s.KubeletCgroupsPerQOS || build >= cgroupsPerQOSMinBuild {
+		return s
+	}
+	supported := *s
+	supported.KubeletCgroupsPerQOS = false
+	return &supported
+}
+
+// safeReboot safely restarts the underlying instance, first cordoning and draining the associated node.
+// Waits for reboot to take effect before uncordoning the node.
+func (nc *NodeConfig) SafeReboot(ctx context.Context) error {
+	if nc.node == nil {
+		return fmt.Errorf("safe reboot of the instance requires an associated node")
+	}
+	// the drain settings decide which pods may be evicted
+	if err := nc.checkSettings(); err != nil {
+		return err
+	}
+
+	// A node still waiting to be validated by the user must not be made schedulable by a reboot. The annotation is
+	// ignored once the user has uncordoned the node.
+	_, pendingUncordon := nc.node.GetAnnotations()[metadata.PendingUncordonAnnotation]
+	pendingUncordon = pendingUncordon && nc.node.Spec.Unschedulable
+
+	if err := nc.checkInteractiveSessions(); err != nil {
+		return err
+	}
+	drainer := nc.newDrainHelper()
+	if err := nc.cordonAndDrain(drainer, true); err != nil {
+		return err
+	}
+
+	// HNS networks conflicts with the persistent route to the metadata endpoint in AWS. Explicitly remove them
+	// to allow the same instance to be configured as a node again.
+	if nc.platformType == configv1.AWSPlatformType {
+		if err := nc.Windows.EnsureHNSNetworksAreRemoved(); err != nil {
+			return err
+		}
+	}
+
+	if err := nc.Windows.RebootAndReinitialize(); err != nil {
+		return err
+	}
+	// Remove the reboot annotation after we can re-init an SSH connection so we know the reboot occurred successfully
+	if err := metadata.RemoveRebootAnnotation(ctx, nc.client, *nc.node); err != nil {
+		return err
+	}
+
+	if pendingUncordon {
+		return nil
+	}
+	if err := drain.RunCordonOrUncordon(drainer, nc.node, false); err != nil {
+		return fmt.Errorf("unable to uncordon node %s: %w", nc.node.Name, err)
+	}
+	return metadata.RemovePendingUncordonAnnotation(ctx, nc.client, *nc.node)
+}
+
+// checkInteractiveSessions looks for users logged on to the instance before it is rebooted, returning an error if the
+// reboot should not proceed as per the settings
+func (nc *NodeConfig) checkInteractiveSessions() error {
+	policy := nc.settings.InteractiveSessionsOnReboot
+	if policy == settings.InteractiveSessionsIgnore {
+		return nil
+	}
+	users, err := nc.Windows.GetLoggedOnUsers()
+	if err != nil {
+		if policy == settings.InteractiveSessionsRefuse {
+			return fmt.Errorf("unable to check for interactive sessions before reboot: %w", err)
+		}
+		nc.log.Error(err, "unable to check for interactive sessions before reboot")
+		return nil
+	}
+	if len(users) == 0 {
+		return nil
+	}
+	if policy == settings.InteractiveSessionsRefuse {
+		return fmt.Errorf("refusing to reboot node %s while users are logged on: %s", nc.node.GetName(),
+			strings.Join(users, ", "))
+	}
+	nc.log.Info("WARNING: rebooting node with users logged on", "node", nc.node.GetName(), "users", users)
+	return nil
+}
+
+// runPostConfigurationValidation runs the user's post-configuration validation script on the instance, if one is
+// given by the settings. If the script fails, the node is left cordoned with the pending uncordon annotation and a
+// ValidationFailedError is returned.
+func (nc *NodeConfig) runPostConfigurationValidation() error {
+	if nc.settings.PostConfigurationValidationConfigMap == "" {
+		return nil
+	}
+	cm := &core.ConfigMap{}
+	if err := nc.client.Get(context.TODO(), types.NamespacedName{Namespace: nc.wmcoNamespace,
+		Name: nc.settings.PostConfigurationValidationConfigMap}, cm); err != nil {
+		return fmt.Errorf("error getting post-configuration validation ConfigMap %s: %w",
+			nc.settings.PostConfigurationValidationConfigMap, err)
+	}
+	script, ok := cm.Data[settings.PostConfigurationValidationScriptKey]
+	if !ok {
+		return fmt.Errorf("post-configuration validation ConfigMap %s has no %s key",
+			nc.settings.PostConfigurationValidationConfigMap, settings.PostConfigurationValidationScriptKey)
+	}
+	timeout := settings.DefaultPostConfigurationValidationTimeout
+	if nc.settings.PostConfigurationValidationTimeout > 0 {
+		timeout = nc.settings.PostConfigurationValidationTimeout
+	}
+	nc.log.Info("running post-configuration validation", "configmap",
+		nc.settings.PostConfigurationValidationConfigMap, "timeout", timeout)
+	out, err := nc.Windows.RunValidationScript(script, timeout)
+	if err == nil {
+		return nil
+	}
+	if err := metadata.ApplyPendingUncordonAnnotation(context.TODO(), nc.client, *nc.node); err != nil {
+		return fmt.Errorf("error marking node %s as pending uncordon: %w", nc.node.GetName(), err)
+	}
+	// the node is configured, so it must not hold back the upgrade of other nodes while it waits for the user
+	if err := metadata.RemoveUpgradingLabel(context.TODO(), nc.client, nc.node); err != nil {
+		return fmt.Errorf("error removing upgrading label from node %s: %w", nc.node.GetName(), err)
+	}
+	return &ValidationFailedError{Node: nc.node.GetName(), Output: out, Err: err}
+}
+
+// uncordonConfiguredNode uncordons the freshly configured node. If the user has asked for configured nodes to be left
+// cordoned, the node is instead annotated to indicate it is waiting to be manually uncordoned.
+func (nc *NodeConfig) uncordonConfiguredNode(drainHelper *drain.Helper) error {
+	if nc.settings.LeaveNodesCordoned {
+		if err := metadata.ApplyPendingUncordonAnnotation(context.TODO(), nc.client, *nc.node); err != nil {
+			return fmt.Errorf("error marking node %s as pending uncordon: %w", nc.node.GetName(), err)
+		}
+		nc.log.Info("leaving node cordoned, it must be manually uncordoned", "node", nc.node.GetName())
+		return nil
+	}
+	if err := drain.RunCordonOrUncordon(drainHelper, nc.node, false); err != nil {
+		return fmt.Errorf("error uncordoning the node %s: %w", nc.node.GetName(), err)
+	}
+	return metadata.RemovePendingUncordonAnnotation(context.TODO(), nc.client, *nc.node)
+}
+
+// getWICDServiceAccountSecret returns the newest secret which holds the credentials for the WICD ServiceAccount,
+// creating one if necessary
+func (nc *NodeConfig) getWICDServiceAccountSecret() (*core.Secret, error) {
+	ctx := context.TODO()
+	var tokenSecret core.Secret
+	err := nc.client.Get(ctx,
+		types.NamespacedName{Namespace: nc.wmcoNamespace, Name: windows.WicdServiceName}, &tokenSecret)
+	if err == nil && !ValidWICDServiceAccountTokenSecret(tokenSecret) {
+		// If the secret is invalid, a new one should be created
+		if err = nc.client.Delete(ctx, &tokenSecret); err != nil {
+			return nil, fmt.Errorf("error deleting invalid WICD service account token secret: %w", err)
+		}
+	} else if err != nil && !k8sapierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	tokenSecrets, err := ListWICDTokenSecrets(ctx, nc.client, nc.wmcoNamespace)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokenSecrets) > 0 {
+		return &tokenSecrets[0], nil
+	}
+	return CreateWICDTokenSecret(ctx, nc.client, nc.k8sclientset, nc.wmcoNamespace, windows.WicdServiceName)
+}
+
+// ListWICDTokenSecrets returns the valid WICD ServiceAccount token secrets in the given namespace, newest first. More
+// than one secret is returned only while a token rotation is in progress.
+func ListWICDTokenSecrets(ctx context.Context, c client.Client, namespace string) ([]core.Secret, error) {
+	var tokenSecrets []core.Secret
+	for _, name := range WICDTokenSecretNames {
+		var tokenSecret core.Secret
+		err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &tokenSecret)
+		if err != nil {
+			if k8sapierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("error getting WICD service account token secret %s: %w", name, err)
+		}
+		if ValidWICDServiceAccountTokenSecret(tokenSecret) {
+			tokenSecrets = append(tokenSecrets, tokenSecret)
+		}
+	}
+	sort.Slice(tokenSecrets, func(i, j int) bool {
+		return tokenSecrets[j].CreationTimestamp.Before(&tokenSecrets[i].CreationTimestamp)
+	})
+	return tokenSecrets, nil
+}
+
+// CreateWICDTokenSecret creates a secret with the given name holding a long-lived API token for the WICD
+// ServiceAccount, and waits for the secret data to be populated
+func CreateWICDTokenSecret(ctx context.Context, c client.Client, clientset *kubernetes.Clientset, namespace,
+	name string) (*core.Secret, error) {
+	tokenSecret := secrets.GenerateServiceAccountTokenSecret(namespace, windows.WicdServiceName)
+	tokenSecret.Name = name
+	tokenSecret.Labels = map[string]string{metadata.WICDTokenSecretLabel: ""}
+	if err := c.Create(ctx, tokenSecret); err != nil {
+		return nil, fmt.Errorf("error creating secret for WICD ServiceAccount: %w", err)
+	}
+	secret := &core.Secret{}
+	// wait for the secret data to be populated
+	err := wait.PollUntilContextTimeout(ctx, time.Second, time.Minute, true,
+		func(ctx context.Context) (done bool, err error) {
+			secret, err = clientset.CoreV1().Secrets(namespace).Get(ctx, name, meta.GetOptions{})
+			if err != nil {
+				return false, nil
+			}
+			caCert := secret.Data[core.ServiceAccountRootCAKey]
+			if caCert == nil {
+				return false, nil
+			}
+			token := secret.Data[core.ServiceAccountTokenKey]
+			if token == nil {
+				return false, nil
+			}
+			return true, nil
+		})
+	return secret, err
+}
+
+// createBootstrapFiles creates all prerequisite files on the node required to start kubelet using latest ignition spec
+func (nc *NodeConfig) createBootstrapFiles() error {
+	filePathsToContents := make(map[string]string)
+	filePathsToContents, err := nc.createFilesFromIgnition()
+	if err != nil {
+		return err
+	}
+	providerConf, binaries, err := credentialProviderConfig(filePathsToContents[windows.CredentialProviderConfig],
+		nc.settings.KubeletCredentialProviders)
+	if err != nil {
+		return err
+	}
+	if providerConf != "" {
+		filePathsToContents[windows.CredentialProviderConfig] = providerConf
+	}
+	if err = nc.transferCredentialProviders(binaries); err != nil {
+		return err
+	}
+	filePathsToContents[windows.BootstrapKubeconfigPath], err = nc.generateBootstrapKubeconfig()
+	if err != nil {
+		return err
+	}
+	nc.warnUnsupportedKubeletSettings()
+	kubeletSettings, err := nc.kubeletSettings()
+	if err != nil {
+		return err
+	}
+	filePathsToContents[windows.KubeletConfigPath], err = createKubeletConf(nc.clusterServiceCIDRs, kubeletSettings,
+		nc.platformType, nc.registerNode)
+	if err != nil {
+		return err
+	}
+	if err = nc.createStaticPodDir(); err != nil {
+		return err
+	}
+	filePathsToContents[windows.ContainerdConfPath], err = createContainerdConf(nc.settings)
+	if err != nil {
+		return err
+	}
+	if err = nc.createContainerdDirs(); err != nil {
+		return err
+	}
+	filePathsToContents[windows.HNSEndpointPoliciesPath], err = createHNSEndpointPolicies(nc.settings)
+	if err != nil {
+		return err
+	}
+	return nc.write(filePathsToContents)
+}
+
+// write outputs the data to the path on the underlying Windows instance for each given pair. Creates files if needed.
+func (nc *NodeConfig) write(pathToData map[string]string) error {
+	for path, data := range pathToData {
+		dir, fileName := windows.SplitPath(path)
+		if err := nc.Windows.EnsureFileContent([]byte(data), fileName, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureDynamicPortRange sets the dynamic port range of the instance to the given range, returning an error if it
+// overlaps the NodePort range of the cluster, as kube-proxy could then not bind the ports of NodePort services which a
+// local process was allocated
+func (nc *NodeConfig) ensureDynamicPortRange(dynamicPorts utilnet.PortRange) error {
+	network := &configv1.Network{}
+	if err := nc.client.Get(context.TODO(), types.NamespacedName{Name: "cluster"}, network); err != nil {
+		return fmt.Errorf("unable to get cluster network config: %w", err)
+	}
+	if err := validateDynamicPortRange(dynamicPorts, network.Spec.ServiceNodePortRange); err != nil {
+		return err
+	}
+	return nc.Windows.SetDynamicPortRange(dynamicPorts.Base, dynamicPorts.Size)
+}
+
+// validateDynamicPortRange returns an error if the given dynamic port range overlaps the given NodePort range of the
+// cluster, which is the Kubernetes default if empty
+func validateDynamicPortRange(dynamicPorts utilnet.PortRange, serviceNodePortRange string) error {
+	if serviceNodePortRange == "" {
+		serviceNodePortRange = defaultServiceNodePortRange
+	}
+	nodePorts, err := utilnet.ParsePortRange(serviceNodePortRange)
+	if err != nil {
+		return fmt.Errorf("unable to parse cluster NodePort range %q: %w", serviceNodePortRange, err)
+	}
+	if dynamicPorts.Base < nodePorts.Base+nodePorts.Size && nodePorts.Base < dynamicPorts.Base+dynamicPorts.Size {
+		return fmt.Errorf("dynamic port range %s overlaps the cluster NodePort range %s", dynamicPorts.String(),
+			nodePorts.String())
+	}
+	return nil
+}
+
+// verifyOverlayMTU logs a warning if the MTU of the host vEthernet adapter of the hybrid overlay HNS network cannot
+// carry pod traffic of the cluster network MTU once it is encapsulated by the hybrid overlay.
+// Such a mismatch causes large packets to be dropped.
+func (nc *NodeConfig) verifyOverlayMTU() {
+	network := &configv1.Network{}
+	if err := nc.client.Get(context.TODO(), types.NamespacedName{Name: "cluster"}, network); err != nil {
+		nc.log.Error(err, "unable to get cluster network config to verify the interface MTU")
+		return
+	}
+	if network.Status.ClusterNetworkMTU == 0 {
+		return
+	}
+	alias, err := nc.Windows.GetHNSNetworkInterfaceAlias(windows.OVNKubeOverlayNetwork)
+	if err != nil {
+		nc.log.Error(err, "unable to verify the interface MTU")
+		return
+	}
+	mtu, err := nc.Windows.GetInterfaceMTU(alias)
+	if err != nil {
+		nc.log.Error(err, "unable to verify the interface MTU")
+		return
+	}
+	if minMTU := network.Status.ClusterNetworkMTU + hybridOverlayVXLANOverhead; mtu < minMTU {
+		nc.log.Info("WARNING: interface MTU is too small for the cluster network MTU, large packets will be dropped",
+			"network", windows.OVNKubeOverlayNetwork, "interface", alias, "mtu", mtu, "clusterNetworkMTU", network.Status.ClusterNetworkMTU,
+			"requiredMTU", minMTU)
+		return
+	}
+	nc.log.V(1).Info("verified interface MTU", "interface", alias, "mtu", mtu)
+}
+
+// removeStaleTempFiles removes the stale files in WMCO's temporary directory on the instance, unless disabled by the
+// temp file cleanup policy. Failures are only logged, as leftover files do not affect the configuration of the node.
+func (nc *NodeConfig) removeStaleTempFiles() {
+	if nc.settings.TempFileCleanupPolicy == settings.TempFileCleanupNever {
+		return
+	}
+	if err := nc.Windows.RemoveStaleTempFiles(); err != nil {
+		nc.log.Error(err, "unable to remove stale temporary files")
+	}
+}
+
+// ensureWindowsExporterReachable ensures the instance's firewall allows windows_exporter to be scraped, and warns if
+// windows_exporter is not listening on its port, as the metrics of the node would then be missing
+func (nc *NodeConfig) ensureWindowsExporterReachable() error {
+	if err := nc.Windows.EnsureFirewallRule(windows.WindowsExporterFirewallRule,
+		windows.WindowsExporterPort); err != nil {
+		return err
+	}
+	listening, err := nc.Windows.IsPortListening(windows.WindowsExporterPort)
+	if err != nil {
+		return err
+	}
+	if !listening {
+		nc.log.Info("WARNING: windows_exporter is not listening, metrics of the node will be missing",
+			"service", windows.WindowsExporterServiceName, "port", windows.WindowsExporterPort)
+	}
+	return nil
+}
+
+// checkAPIServerConnectivity returns an error if the instance cannot open a TCP connection to the API server endpoint
+// the node's services are given, as kubelet would otherwise fail to register the node without a clear cause
+func (nc *NodeConfig) checkAPIServerConnectivity() error {
+	if nodeConfigCache.apiServerEndpoint == "" {
+		return nil
+	}
+	host, port, err := splitEndpoint(nodeConfigCache.apiServerEndpoint)
+	if err != nil {
+		return err
+	}
+	result, err := nc.Windows.TestConnectivity(host, port)
+	if err != nil {
+		return err
+	}
+	if result.RemoteAddress == "" {
+		return fmt.Errorf("instance cannot resolve the API server host %s, ensure the DNS servers of the instance "+
+			"resolve the cluster's API server name", host)
+	}
+	if !result.TCPTestSucceeded {
+		return fmt.Errorf("instance cannot connect to the API server at %s (%s), ensure firewalls and security "+
+			"groups allow TCP traffic from the instance to port %d of the control plane", host, result.RemoteAddress,
+			port)
+	}
+	nc.log.V(1).Info("API server is reachable", "host", host, "port", port, "address", result.RemoteAddress,
+		"pingSucceeded", result.PingSucceeded, "roundTripMs", result.RoundTripMs)
+	return nil
+}
+
+// splitEndpoint returns the host and port of the given https URL, defaulting to port 443 if it has none
+func splitEndpoint(endpoint string) (string, int, error) {
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid endpoint %s: %w", endpoint, err)
+	}
+	if parsed.Hostname() == "" {
+		return "", 0, fmt.Errorf("endpoint %s has no host", endpoint)
+	}
+	if parsed.Port() == "" {
+		return parsed.Hostname(), 443, nil
+	}
+	port, err := strconv.Atoi(parsed.Port())
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in endpoint %s: %w", endpoint, err)
+	}
+	return parsed.Hostname(), port, nil
+}
+
+// checkRegistryConnectivity checks if the instance can reach the endpoints, including mirrors, of the registry hosting
+// the images required for the node to run pods, logging the result of each check. Unreachable registries are not
+// treated as an error, as the images may already be present on the instance.
+func (nc *NodeConfig) checkRegistryConnectivity() error {
+	endpoints, err := registries.GetRegistryEndpoints(context.TODO(), nc.client, sandboxImageRegistry)
+	if err != nil {
+		return err
+	}
+	results, err := nc.Windows.TestRegistryConnectivity(endpoints)
+	if err != nil {
+		return err
+	}
+	reachable := false
+	for _, endpoint := range endpoints {
+		if results[endpoint] != nil {
+			nc.log.Info("WARNING: registry is not reachable", "registry", endpoint, "error", results[endpoint].Error())
+			continue
+		}
+		reachable = true
+		nc.log.V(1).Info("registry is reachable", "registry", endpoint)
+	}
+	if !reachable {
+		nc.log.Info("WARNING: pods will fail to start unless the required images are already present on the instance",
+			"registry", sandboxImageRegistry, "endpoints", endpoints)
+	}
+	return nil
+}
+
+// createRegistryConfigFiles creates all files on the node required for containerd to mirror images
+func (nc *NodeConfig) createRegistryConfigFiles() error {
+	configFiles, err := registries.GenerateConfigFiles(context.TODO(), nc.client)
+	if err != nil {
+		return err
+	}
+	return nc.Windows.ReplaceDir(configFiles, windows.ContainerdConfigDir)
+}
+
+// createFilesFromIgnition returns the contents and write locations on the instance for any file it can create from
+// ignition spec: kubelet CA cert, cloud-config file
+func (nc *NodeConfig) createFilesFromIgnition() (map[string]string, error) {
+	ign, err := ignition.New(nc.client)
+	if err != nil {
+		return nil, err
+	}
+	kubeletArgs, err := ign.GetKubeletArgs()
+	if err != nil {
+		return nil, err
+	}
+
+	// create a map of 'ignition files':'desired path on a Windows instance'
+	filesToTransfer := map[string]string{}
 	if _, ok := kubeletArgs[ignition.CloudConfigOption]; ok {
 		filesToTransfer[ignition.CloudConfigPath] = windows.K8sDir + "\\" + filepath.Base(ignition.CloudConfigPath)
 	}
 	filesToTransfer[ignition.ECRCredentialProviderPath] = windows.CredentialProviderConfig
 
-	filePathsToContents, err := translateIgnitionFilesForWindows(filesToTransfer, ign.GetFiles())
-	if err != nil {
-		return nil, fmt.Errorf("error processing ignition files: %w", err)
+	filePathsToContents, err := translateIgnitionFilesForWindows(filesToTransfer, ign.GetFiles())
+	if err != nil {
+		return nil, fmt.Errorf("error processing ignition files: %w", err)
+	}
+
+	kubeletCA, err := KubeletClientCA(context.TODO(), nc.client, ign.GetKubeletCAData())
+	if err != nil {
+		return nil, err
+	}
+	filePathsToContents[windows.K8sDir+"\\"+KubeletClientCAFilename] = string(kubeletCA)
+	return filePathsToContents, nil
+}
+
+// generateBootstrapKubeconfig returns contents of a kubeconfig for kubelet to initially communicate with the API server
+func (nc *NodeConfig) generateBootstrapKubeconfig() (string, error) {
+	var bootstrapSecret *core.Secret
+	var expiry time.Time
+	// A token close to expiry is about to be rotated by the Machine Config Operator, so the secret is read again until
+	// it holds the new token
+	err := wait.PollUntilContextTimeout(context.TODO(), retry.Interval, retry.ResourceChangeTimeout, true,
+		func(ctx context.Context) (bool, error) {
+			var err error
+			bootstrapSecret, err = nc.k8sclientset.CoreV1().Secrets(mcoNamespace).Get(ctx, mcoBootstrapSecret,
+				meta.GetOptions{})
+			if err != nil {
+				return false, err
+			}
+			expiry, err = tokenExpiry(bootstrapSecret.Data[core.ServiceAccountTokenKey])
+			if err != nil {
+				return false, fmt.Errorf("invalid token in secret %s/%s: %w", mcoNamespace, mcoBootstrapSecret, err)
+			}
+			valid, err := bootstrapTokenValid(expiry, time.Now())
+			if err != nil {
+				return false, fmt.Errorf("unable to use secret %s/%s: %w", mcoNamespace, mcoBootstrapSecret, err)
+			}
+			if !valid {
+				nc.log.Info("waiting for bootstrap token to be rotated", "secret", mcoBootstrapSecret,
+					"expiry", expiry)
+			}
+			return valid, nil
+		})
+	if err != nil {
+		if wait.Interrupted(err) {
+			return "", fmt.Errorf("token in secret %s/%s expires at %s and was not rotated: %w", mcoNamespace,
+				mcoBootstrapSecret, expiry.UTC().Format(time.RFC3339), err)
+		}
+		return "", err
+	}
+	return newKubeconfigFromSecret(bootstrapSecret, "kubelet")
+}
+
+// tokenExpiry returns the expiry given by the exp claim of the given JWT token. The zero time is returned if the
+// token is not a JWT or does not expire, as is the case for legacy ServiceAccount tokens.
+func tokenExpiry(token []byte) (time.Time, error) {
+	parts := strings.Split(strings.TrimSpace(string(token)), ".")
+	if len(parts) != 3 {
+		return time.Time{}, nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to decode token claims: %w", err)
+	}
+	var claims struct {
+		Expiry *int64 `json:"exp"`
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return time.Time{}, fmt.Errorf("unable to parse token claims: %w", err)
+	}
+	if claims.Expiry == nil {
+		return time.Time{}, nil
+	}
+	return time.Unix(*claims.Expiry, 0), nil
+}
+
+// bootstrapTokenValid returns true if a token with the given expiry remains valid for at least
+// bootstrapTokenMinValidity after the given time, and an error if the token has already expired
+func bootstrapTokenValid(expiry, now time.Time) (bool, error) {
+	if expiry.IsZero() {
+		return true, nil
+	}
+	if !now.Before(expiry) {
+		return false, fmt.Errorf("token expired at %s", expiry.UTC().Format(time.RFC3339))
+	}
+	return expiry.Sub(now) >= bootstrapTokenMinValidity, nil
+}
+
+// generateWICDKubeconfig returns the contents of a kubeconfig created from the WICD ServiceAccount, and the name of
+// the token secret it was created from
+func (nc *NodeConfig) generateWICDKubeconfig() (string, string, error) {
+	wicdSASecret, err := nc.getWICDServiceAccountSecret()
+	if err != nil {
+		return "", "", err
+	}
+	kubeconfig, err := newKubeconfigFromSecret(wicdSASecret, "wicd")
+	if err != nil {
+		return "", "", err
+	}
+	return kubeconfig, wicdSASecret.GetName(), nil
+}
+
+// newKubeconfigFromSecret returns the contents of a kubeconfig generated from the given service account token secret
+func newKubeconfigFromSecret(saSecret *core.Secret, username string) (string, error) {
+	// extract ca.crt and token data fields
+	caCert := saSecret.Data[core.ServiceAccountRootCAKey]
+	if caCert == nil {
+		return "", fmt.Errorf("unable to find %s CA cert in secret %s", core.ServiceAccountRootCAKey,
+			saSecret.GetName())
+	}
+	token := saSecret.Data[core.ServiceAccountTokenKey]
+	if token == nil {
+		return "", fmt.Errorf("unable to find %s token in secret %s", core.ServiceAccountTokenKey,
+			saSecret.GetName())
+	}
+	kc := generateKubeconfig(caCert, string(token), nodeConfigCache.apiServerEndpoint,
+		username)
+	kubeconfigData, err := json.Marshal(kc)
+	if err != nil {
+		return "", err
+	}
+	return string(kubeconfigData), nil
+}
+
+// hnsEndpointPolicies is the format of the file giving the additional HNS endpoint policies on an instance
+type hnsEndpointPolicies struct {
+	// OutboundNATExceptions are added to the exception list of the OutBoundNAT policy of the CNI config
+	OutboundNATExceptions []string `json:"outboundNATExceptions,omitempty"`
+	// Policies are added to the policies of the CNI config
+	Policies []cniEndpointPolicy `json:"policies,omitempty"`
+}
+
+// cniEndpointPolicy is an HNS endpoint policy, as given in the CNI config
+type cniEndpointPolicy struct {
+	Name  string                 `json:"name"`
+	Value cniEndpointPolicyValue `json:"value"`
+}
+
+// cniEndpointPolicyValue holds the type and settings of an HNS endpoint policy
+type cniEndpointPolicyValue struct {
+	Type     string         `json:"type"`
+	Settings hnsACLSettings `json:"settings"`
+}
+
+// hnsACLSettings are the settings of an HNS ACL endpoint policy
+type hnsACLSettings struct {
+	Action          string `json:"Action"`
+	Direction       string `json:"Direction"`
+	Protocols       string `json:"Protocols,omitempty"`
+	RemoteAddresses string `json:"RemoteAddresses,omitempty"`
+	LocalPorts      string `json:"LocalPorts,omitempty"`
+	RemotePorts     string `json:"RemotePorts,omitempty"`
+	// RuleType is Switch, so that the rule is enforced on the endpoint's port of the virtual switch
+	RuleType string `json:"RuleType"`
+	Priority int    `json:"Priority"`
+}
+
+// newHNSACLSettings returns the settings of the HNS ACL endpoint policy enforcing the given rule
+func newHNSACLSettings(acl settings.HNSACLPolicy) hnsACLSettings {
+	aclSettings := hnsACLSettings{Action: acl.Action, Direction: acl.Direction, Protocols: acl.Protocol,
+		RemoteAddresses: acl.RemoteAddress, RuleType: "Switch", Priority: acl.Priority}
+	if acl.LocalPort != 0 {
+		aclSettings.LocalPorts = strconv.Itoa(acl.LocalPort)
+	}
+	if acl.RemotePort != 0 {
+		aclSettings.RemotePorts = strconv.Itoa(acl.RemotePort)
+	}
+	return aclSettings
+}
+
+// createHNSEndpointPolicies returns the contents of the file giving the additional HNS endpoint policies configured
+// through the settings ConfigMap
+func createHNSEndpointPolicies(s *settings.Settings) (string, error) {
+	policies := hnsEndpointPolicies{OutboundNATExceptions: s.HNSOutboundNATExceptions}
+	for _, acl := range s.HNSACLPolicies {
+		policies.Policies = append(policies.Policies, cniEndpointPolicy{Name: "EndpointPolicy",
+			Value: cniEndpointPolicyValue{Type: "ACL", Settings: newHNSACLSettings(acl)}})
+	}
+	contents, err := json.Marshal(policies)
+	if err != nil {
+		return "", err
+	}
+	return string(contents), nil
+}
+
+// createContainerdConf returns contents of the config file for containerd, which is the config file shipped with WMCO
+// with the containerd options and additional runtime handlers given through the settings ConfigMap
+func createContainerdConf(s *settings.Settings) (string, error) {
+	conf, err := os.ReadFile(payload.ContainerdConfPath)
+	if err != nil {
+		return "", fmt.Errorf("error reading %s: %w", payload.ContainerdConfPath, err)
+	}
+	return generateContainerdConf(string(conf), s)
+}
+
+// generateContainerdConf returns the given containerd config with the containerd options and additional runtime
+// handlers given through the settings ConfigMap
+func generateContainerdConf(conf string, s *settings.Settings) (string, error) {
+	merged, err := mergeContainerdOptions(conf, containerdCRIPluginTable, s.ContainerdCRIOptions)
+	if err != nil {
+		return "", err
+	}
+	if s.ContainerdDiscardUnpackedLayers {
+		merged, err = mergeContainerdOptions(merged, containerdCRIContainerdTable,
+			map[string]string{"discard_unpacked_layers": "true"})
+		if err != nil {
+			return "", err
+		}
+	}
+	merged, err = mergeContainerdOptions(merged, "", containerdDirOptions(s))
+	if err != nil {
+		return "", err
+	}
+	return addContainerdRuntimeHandlers(merged, s.ContainerdRuntimeHandlers)
+}
+
+// containerdTable is a table of a containerd config. Its header and options are normalized by normalizeTOML.
+type containerdTable struct {
+	// header is the header of the table, empty for the top-level options
+	header string
+	// options are the values of the options of the table, keyed by name
+	options map[string]string
+	// lines are the lines of the table as written, including its header, comments and blank lines
+	lines []string
+}
+
+// userContainerdTablePrefixes are the prefixes of the headers of the containerd config tables which may be added to
+// the config of an instance by hand, such as to register a proxy plugin. Such tables are kept when the config is
+// repaired, unless WMCO generates a table with the same header.
+var userContainerdTablePrefixes = []string{"[proxy_plugins.", "[stream_processors."}
+
+// parseContainerdConf returns the tables of the given containerd config, in the order they are given in, starting
+// with the table of the top-level options. An error is returned if a line is neither a table header nor an option.
+func parseContainerdConf(conf string) ([]containerdTable, error) {
+	tables := []containerdTable{{options: make(map[string]string)}}
+	lines := strings.Split(strings.ReplaceAll(conf, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		statement, depth := normalizeTOML(lines[i])
+		raw := []string{lines[i]}
+		// arrays can span multiple lines
+		for depth > 0 && i+1 < len(lines) {
+			i++
+			continued, continuedDepth := normalizeTOML(lines[i])
+			statement += continued
+			depth += continuedDepth
+			raw = append(raw, lines[i])
+		}
+		current := &tables[len(tables)-1]
+		switch {
+		case statement == "":
+			current.lines = append(current.lines, raw...)
+		case strings.HasPrefix(statement, "["):
+			tables = append(tables, containerdTable{header: statement, options: make(map[string]string),
+				lines: raw})
+		default:
+			option, value, found := strings.Cut(statement, "=")
+			if !found {
+				return nil, fmt.Errorf("invalid line %q", strings.TrimSpace(lines[i]))
+			}
+			current.options[option] = value
+			current.lines = append(current.lines, raw...)
+		}
+	}
+	return tables, nil
+}
+
+// normalizeTOML returns the given line of TOML without its comment and without the whitespace outside of its strings,
+// along with the number of square brackets it opens, outside of its strings, without closing them
+func normalizeTOML(line string) (string, int) {
+	var normalized strings.Builder
+	depth := 0
+	var quote rune
+	escaped := false
+	for _, c := range line {
+		switch {
+		case quote != 0:
+			if escaped {
+				escaped = false
+			} else if c == '\\' && quote == '"' {
+				escaped = true
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '#':
+			return normalized.String(), depth
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case unicode.IsSpace(c):
+			continue
+		}
+		normalized.WriteRune(c)
+	}
+	return normalized.String(), depth
+}
+
+// containerdConfDrift returns a description of each way the given tables of the containerd config of an instance
+// differ from the given tables of the expected config. Tables which are not expected are reported, unless their header
+// has one of the userContainerdTablePrefixes.
+func containerdConfDrift(current, expected []containerdTable) []string {
+	describe := func(header string) string {
+		if header == "" {
+			return "top-level options"
+		}
+		return "table " + header
+	}
+	currentTables := make(map[string]containerdTable, len(current))
+	for _, table := range current {
+		currentTables[table.header] = table
+	}
+	var drift []string
+	for _, table := range expected {
+		found, present := currentTables[table.header]
+		if !present {
+			drift = append(drift, fmt.Sprintf("%s is missing", describe(table.header)))
+			continue
+		}
+		for _, option := range sets.List(sets.KeySet(table.options)) {
+			value, present := found.options[option]
+			if !present {
+				drift = append(drift, fmt.Sprintf("%s: option %s is missing", describe(table.header), option))
+			} else if value != table.options[option] {
+				drift = append(drift, fmt.Sprintf("%s: option %s is %s instead of %s", describe(table.header), option,
+					value, table.options[option]))
+			}
+		}
+		for _, option := range sets.List(sets.KeySet(found.options)) {
+			if _, present := table.options[option]; !present {
+				drift = append(drift, fmt.Sprintf("%s: unexpected option %s", describe(table.header), option))
+			}
+		}
+	}
+	expectedHeaders := containerdTableHeaders(expected)
+	for _, table := range current {
+		if !expectedHeaders.Has(table.header) && !isUserContainerdTable(table.header) {
+			drift = append(drift, fmt.Sprintf("unexpected %s", describe(table.header)))
+		}
+	}
+	return drift
+}
+
+// repairContainerdConf returns the given expected containerd config followed by the tables of the given current
+// config which were added by hand, so that they survive the repair
+func repairContainerdConf(expected string, current, expectedTables []containerdTable) string {
+	expectedHeaders := containerdTableHeaders(expectedTables)
+	var kept []string
+	for _, table := range current {
+		if !expectedHeaders.Has(table.header) && isUserContainerdTable(table.header) {
+			kept = append(kept, strings.TrimRight(strings.Join(table.lines, "\n"), " \t\n"))
+		}
+	}
+	if len(kept) == 0 {
+		return expected
+	}
+	return strings.TrimRight(expected, "\n") + "\n\n" + strings.Join(kept, "\n\n") + "\n"
+}
+
+// containerdTableHeaders returns the headers of the given containerd config tables
+func containerdTableHeaders(tables []containerdTable) sets.Set[string] {
+	headers := sets.New[string]()
+	for _, table := range tables {
+		headers.Insert(table.header)
 	}
+	return headers
+}
 
-	filePathsToContents[windows.K8sDir+"\\"+KubeletClientCAFilename] = string(ign.GetKubeletCAData())
-	return filePathsToContents, nil
+// isUserContainerdTable returns true if the given normalized containerd config table header has one of the
+// userContainerdTablePrefixes
+func isUserContainerdTable(header string) bool {
+	for _, prefix := range userContainerdTablePrefixes {
+		if strings.HasPrefix(header, prefix) {
+			return true
+		}
+	}
+	return false
 }
 
-// generateBootstrapKubeconfig returns contents of a kubeconfig for kubelet to initially communicate with the API server
-func (nc *nodeConfig) generateBootstrapKubeconfig() (string, error) {
-	bootstrapSecret, err := nc.k8sclientset.CoreV1().Secrets(mcoNamespace).Get(context.TODO(), mcoBootstrapSecret,
-		meta.GetOptions{})
-	if err != nil {
-		return "", err
+// containerdDirOptions returns the top-level containerd options giving the root and state directories set through
+// the settings ConfigMap, as TOML literals
+func containerdDirOptions(s *settings.Settings) map[string]string {
+	options := make(map[string]string)
+	if s.ContainerdRootDir != "" {
+		options["root"] = tomlPath(s.ContainerdRootDir)
 	}
-	return newKubeconfigFromSecret(bootstrapSecret, "kubelet")
+	if s.ContainerdStateDir != "" {
+		options["state"] = tomlPath(s.ContainerdStateDir)
+	}
+	return options
 }
 
-// generateWICDKubeconfig returns the contents of a kubeconfig created from the WICD ServiceAccount
-func (nc *nodeConfig) generateWICDKubeconfig() (string, error) {
-	wicdSASecret, err := nc.getWICDServiceAccountSecret()
-	if err != nil {
-		return "", err
+// tomlPath returns the given Windows path as a TOML basic string literal
+func tomlPath(path string) string {
+	return "\"" + strings.ReplaceAll(path, "\\", "\\\\") + "\""
+}
+
+// addContainerdRuntimeHandlers returns the given containerd config with a runtime handler table for each of the given
+// handlers, keyed by name, using the given settings.ContainerdIsolation mode. The tables are added after those of the
+// handlers already in the config. An error is returned if the config does not have the default runtime handler, or
+// already has a handler with one of the given names.
+func addContainerdRuntimeHandlers(conf string, handlers map[string]string) (string, error) {
+	lines := strings.Split(conf, "\n")
+	handlerPrefix := strings.TrimSuffix(containerdRuntimesTable, "]") + "."
+	defaultHeader := handlerPrefix + settings.DefaultContainerdRuntimeHandler + "]"
+	runtimesIndex, defaultIndex := -1, -1
+	for i, line := range lines {
+		switch strings.TrimSpace(line) {
+		case containerdRuntimesTable:
+			runtimesIndex = i
+		case defaultHeader:
+			defaultIndex = i
+		}
+	}
+	if runtimesIndex == -1 || defaultIndex == -1 {
+		return "", fmt.Errorf("default runtime handler %s not found in containerd config",
+			settings.DefaultContainerdRuntimeHandler)
+	}
+	if len(handlers) == 0 {
+		return conf, nil
+	}
+	// the handler tables end at the first table after the runtimes table which is not nested under it
+	end := len(lines)
+	for i := runtimesIndex + 1; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(trimmed, "[") && !strings.HasPrefix(trimmed, handlerPrefix) {
+			end = i
+			break
+		}
+	}
+	indent := lines[defaultIndex][:len(lines[defaultIndex])-len(strings.TrimLeft(lines[defaultIndex], " \t"))]
+	var added []string
+	for _, name := range sets.List(sets.KeySet(handlers)) {
+		header := handlerPrefix + name + "]"
+		if slices.ContainsFunc(lines, func(line string) bool { return strings.TrimSpace(line) == header }) {
+			return "", fmt.Errorf("runtime handler %s is already present in containerd config", name)
+		}
+		sandboxIsolation := 0
+		if handlers[name] == settings.ContainerdIsolationHyperV {
+			sandboxIsolation = 1
+		}
+		added = append(added,
+			indent+header,
+			indent+"  runtime_type = \""+containerdRuntimeType+"\"",
+			"",
+			indent+"  "+strings.TrimSuffix(header, "]")+".options]",
+			fmt.Sprintf("%s    SandboxIsolation = %d", indent, sandboxIsolation),
+			"")
 	}
-	return newKubeconfigFromSecret(wicdSASecret, "wicd")
+	return strings.Join(slices.Insert(lines, end, added...), "\n"), nil
 }
 
-// newKubeconfigFromSecret returns the contents of a kubeconfig generated from the given service account token secret
-func newKubeconfigFromSecret(saSecret *core.Secret, username string) (string, error) {
-	// extract ca.crt and token data fields
-	caCert := saSecret.Data[core.ServiceAccountRootCAKey]
-	if caCert == nil {
-		return "", fmt.Errorf("unable to find %s CA cert in secret %s", core.ServiceAccountRootCAKey,
-			saSecret.GetName())
+// mergeContainerdOptions returns the given containerd config with the value of each of the given options of the
+// table with the given header replaced, or of the top-level options if the header is empty. The values must be TOML
+// literals. An error is returned if the table does not have one of the options, so that options are only ever changed
+// from their default and never added.
+func mergeContainerdOptions(conf, table string, options map[string]string) (string, error) {
+	if len(options) == 0 {
+		return conf, nil
 	}
-	token := saSecret.Data[core.ServiceAccountTokenKey]
-	if token == nil {
-		return "", fmt.Errorf("unable to find %s token in secret %s", core.ServiceAccountTokenKey,
-			saSecret.GetName())
+	lines := strings.Split(conf, "\n")
+	merged := sets.New[string]()
+	// top-level options come before the first table
+	inTable := table == ""
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			// options of nested tables, such as the CNI options, belong to the nested table only
+			inTable = trimmed == table
+			continue
+		}
+		if !inTable {
+			continue
+		}
+		option, _, found := strings.Cut(trimmed, "=")
+		if !found {
+			continue
+		}
+		option = strings.TrimSpace(option)
+		value, ok := options[option]
+		if !ok {
+			continue
+		}
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		lines[i] = indent + option + " = " + value
+		merged.Insert(option)
 	}
-	kc := generateKubeconfig(caCert, string(token), nodeConfigCache.apiServerEndpoint,
-		username)
-	kubeconfigData, err := json.Marshal(kc)
-	if err != nil {
-		return "", err
+	for _, option := range sets.List(sets.KeySet(options)) {
+		if !merged.Has(option) {
+			if table == "" {
+				return "", fmt.Errorf("top-level containerd option %s not found", option)
+			}
+			return "", fmt.Errorf("containerd option %s not found in the %s table", option, table)
+		}
 	}
-	return string(kubeconfigData), nil
+	return strings.Join(lines, "\n"), nil
 }
 
-// createKubeletConf returns contents of the config file for kubelet, with Windows specific configuration
-func createKubeletConf(clusterServiceCIDR string) (string, error) {
-	clusterDNS, err := cluster.GetDNS(clusterServiceCIDR)
+// createKubeletConf returns contents of the config file for kubelet, with Windows specific configuration and the
+// kubelet options given through the settings ConfigMap. registerNode should be false if the Node is registered
+// out-of-band.
+func createKubeletConf(clusterServiceCIDRs []string, s *settings.Settings, platform configv1.PlatformType,
+	registerNode bool) (string, error) {
+	clusterDNS, err := cluster.GetClusterDNS(clusterServiceCIDRs, s.KubeletClusterDNS)
 	if err != nil {
 		return "", err
 	}
-	kubeletConfig := generateKubeletConfiguration(clusterDNS)
+	if err = validateStaticPodPath(s.KubeletStaticPodPath); err != nil {
+		return "", err
+	}
+	kubeletConfig := generateKubeletConfiguration(clusterDNS, s, platform, registerNode)
+	if err = validateKubeletRegistration(kubeletConfig); err != nil {
+		return "", err
+	}
 	kubeletConfigData, err := json.Marshal(kubeletConfig)
 	if err != nil {
 		return "", err
@@ -481,9 +2315,9 @@ func createKubeletConf(clusterServiceCIDR string) (string, error) {
 }
 
 // setNode finds the Node associated with the VM that has been configured, and sets the node field of the
-// nodeConfig object. If quickCheck is set, the function does a quicker check for the node which is useful in the node
+// NodeConfig object. If quickCheck is set, the function does a quicker check for the node which is useful in the node
 // reconfiguration case.
-func (nc *nodeConfig) setNode(quickCheck bool) error {
+func (nc *NodeConfig) setNode(quickCheck bool) error {
 	retryInterval := retry.Interval
 	retryTimeout := retry.Timeout
 	if quickCheck {
@@ -504,6 +2338,10 @@ func (nc *nodeConfig) setNode(quickCheck bool) error {
 		}
 		// get the node with IP address used to configure it
 		if node := nodeutil.FindByAddress(instanceAddress, nodes); node != nil {
+			if err := validateNodeHostname(node, nc.newHostname); err != nil {
+				nc.log.Error(err, "node found by address does not belong to the instance", "node", node.GetName())
+				return false, err
+			}
 			nc.node = node
 			return true, nil
 		}
@@ -515,8 +2353,25 @@ func (nc *nodeConfig) setNode(quickCheck bool) error {
 	return nil
 }
 
+// validateNodeHostname returns an error if the given Node was not registered with the given hostname, which WMCO set
+// on the instance, as recorded by its hostname label. The label may hold the FQDN of the instance, so only its first
+// DNS label is compared. Nothing is checked if the hostname is empty, as the Node name is then left to the platform.
+func validateNodeHostname(node *core.Node, hostname string) error {
+	if hostname == "" {
+		return nil
+	}
+	registered := node.GetLabels()[core.LabelHostname]
+	shortName, _, _ := strings.Cut(registered, ".")
+	if !strings.EqualFold(shortName, hostname) {
+		return fmt.Errorf("node %s was registered with hostname %q instead of the expected %q set on the instance, "+
+			"kubelet may have registered under a different name after a hostname change", node.GetName(),
+			registered, hostname)
+	}
+	return nil
+}
+
 // newDrainHelper returns new drain.Helper instance
-func (nc *nodeConfig) newDrainHelper() *drain.Helper {
+func (nc *NodeConfig) newDrainHelper() *drain.Helper {
 	return &drain.Helper{
 		Ctx:    context.TODO(),
 		Client: nc.k8sclientset,
@@ -531,26 +2386,223 @@ func (nc *nodeConfig) newDrainHelper() *drain.Helper {
 	}
 }
 
+// cordonAndDrain cordons the node and, if drainPods is true, drains it. Each is retried with a backoff when it fails
+// due to a transient API error, up to the number of attempts given by the settings.
+func (nc *NodeConfig) cordonAndDrain(drainer *drain.Helper, drainPods bool) error {
+	backoff := drainBackoff(nc.settings.DrainMaxAttempts)
+	err := retryOnTransientError(backoff, nc.log, func() error {
+		return drain.RunCordonOrUncordon(drainer, nc.node, true)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to cordon node %s: %w", nc.node.GetName(), err)
+	}
+	if !drainPods {
+		return nil
+	}
+	if nc.settings.DrainProtectedPodSelector != "" {
+		return nc.drainProtectingPods(drainer, backoff)
+	}
+	return nc.drain(drainer, backoff)
+}
+
+// drain drains the node with the given drain helper, retrying with the given backoff on transient API errors
+func (nc *NodeConfig) drain(drainer *drain.Helper, backoff wait.Backoff) error {
+	err := retryOnTransientError(backoff, nc.log, func() error {
+		return drain.RunNodeDrain(drainer, nc.node.GetName())
+	})
+	if err != nil {
+		return fmt.Errorf("unable to drain node %s: %w", nc.node.GetName(), err)
+	}
+	return nil
+}
+
+// drainProtectingPods drains the node, leaving the pods matching the drain protected pod selector running until all
+// other pods are evicted. They are then evicted with the drain protected pod grace period, or, with the Refuse drain
+// protected pod policy, a ProtectedPodsError is returned if any are running.
+func (nc *NodeConfig) drainProtectingPods(drainer *drain.Helper, backoff wait.Backoff) error {
+	// the selector is validated when the settings are parsed
+	selector, err := labels.Parse(nc.settings.DrainProtectedPodSelector)
+	if err != nil {
+		return fmt.Errorf("invalid drain protected pod selector: %w", err)
+	}
+	if err = nc.drain(withPodFilter(drainer, protectedPodFilter(selector, false)), backoff); err != nil {
+		return err
+	}
+	protectedDrainer := withPodFilter(drainer, protectedPodFilter(selector, true))
+	if nc.settings.DrainProtectedPodPolicy == settings.DrainProtectedPodPolicyRefuse {
+		// the filters of the drain helper leave out pods it would not evict, such as DaemonSet and mirror pods
+		pods, errs := protectedDrainer.GetPodsForDeletion(nc.node.GetName())
+		if len(errs) > 0 {
+			return fmt.Errorf("unable to list drain protected pods of node %s: %w", nc.node.GetName(),
+				utilerrors.NewAggregate(errs))
+		}
+		if protected := pods.Pods(); len(protected) > 0 {
+			return newProtectedPodsError(nc.node.GetName(), protected)
+		}
+		return nil
+	}
+	protectedDrainer.GracePeriodSeconds = -1
+	if nc.settings.DrainProtectedPodGracePeriod > 0 {
+		protectedDrainer.GracePeriodSeconds = int(nc.settings.DrainProtectedPodGracePeriod.Seconds())
+	}
+	nc.log.Info("evicting drain protected pods", "node", nc.node.GetName(), "selector", selector.String())
+	return nc.drain(protectedDrainer, backoff)
+}
+
+// withPodFilter returns a copy of the given drain helper which also applies the given pod filter
+func withPodFilter(drainer *drain.Helper, filter drain.PodFilter) *drain.Helper {
+	filtered := *drainer
+	filtered.AdditionalFilters = append(slices.Clone(drainer.AdditionalFilters), filter)
+	return &filtered
+}
+
+// protectedPodFilter returns a drain pod filter which deletes the pods matching the given selector if protected is
+// true, and the pods not matching it otherwise
+func protectedPodFilter(selector labels.Selector, protected bool) drain.PodFilter {
+	return func(pod core.Pod) drain.PodDeleteStatus {
+		if selector.Matches(labels.Set(pod.GetLabels())) == protected {
+			return drain.MakePodDeleteStatusOkay()
+		}
+		return drain.MakePodDeleteStatusSkip()
+	}
+}
+
+// ProtectedPodsError is returned when a node is not drained as it runs pods matching the drain protected pod selector
+// and the drain protected pod policy is Refuse
+type ProtectedPodsError struct {
+	// Node is the name of the node
+	Node string
+	// Pods are the protected pods running on the node, as namespace/name
+	Pods []string
+}
+
+// newProtectedPodsError returns a ProtectedPodsError for the given node and protected pods
+func newProtectedPodsError(node string, pods []core.Pod) *ProtectedPodsError {
+	names := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		names = append(names, pod.GetNamespace()+"/"+pod.GetName())
+	}
+	sort.Strings(names)
+	return &ProtectedPodsError{Node: node, Pods: names}
+}
+
+func (e *ProtectedPodsError) Error() string {
+	return fmt.Sprintf("node %s runs drain protected pods %s, it is not drained until they are removed from it",
+		e.Node, strings.Join(e.Pods, ", "))
+}
+
+// ValidationFailedError is returned when the post-configuration validation script failed on a configured node, which
+// is then left cordoned
+type ValidationFailedError struct {
+	// Node is the name of the node
+	Node string
+	// Output is the last lines of the output of the script
+	Output string
+	// Err is the error running the script
+	Err error
+}
+
+func (e *ValidationFailedError) Error() string {
+	return fmt.Sprintf("post-configuration validation of node %s failed, it is left cordoned: %v, output: %s", e.Node,
+		e.Err, e.Output)
+}
+
+func (e *ValidationFailedError) Unwrap() error {
+	return e.Err
+}
+
+// drainBackoff returns the backoff between attempts at cordoning or draining a node, making the given number of
+// attempts, or retry.DrainAttempts if it is 0
+func drainBackoff(attempts int) wait.Backoff {
+	if attempts == 0 {
+		attempts = retry.DrainAttempts
+	}
+	return wait.Backoff{
+		Duration: retry.WindowsAPIInterval,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    attempts,
+		Cap:      retry.ResourceChangeTimeout,
+	}
+}
+
+// retryOnTransientError calls f until it succeeds, returns an error which is not a transient API error, or the
+// attempts given by the backoff have been made. The last error is returned if all attempts failed.
+func retryOnTransientError(backoff wait.Backoff, log logr.Logger, f func() error) error {
+	var lastErr error
+	err := wait.ExponentialBackoff(backoff, func() (bool, error) {
+		lastErr = f()
+		if lastErr == nil {
+			return true, nil
+		}
+		if !isTransientAPIError(lastErr) {
+			return false, lastErr
+		}
+		log.Info("retrying after transient error", "error", lastErr.Error())
+		return false, nil
+	})
+	if wait.Interrupted(err) {
+		return fmt.Errorf("failed after %d attempts: %w", backoff.Steps, lastErr)
+	}
+	return err
+}
+
+// isTransientAPIError returns true if the given error, or every error it aggregates, is an error talking to the API
+// server which may not occur again, such as a timeout or the server being unavailable. Evictions refused by a
+// PodDisruptionBudget are not transient, as the drain helper already retries them until the budget allows them.
+func isTransientAPIError(err error) bool {
+	var agg utilerrors.Aggregate
+	if errors.As(err, &agg) {
+		for _, e := range agg.Errors() {
+			if !isTransientAPIError(e) {
+				return false
+			}
+		}
+		return len(agg.Errors()) > 0
+	}
+	return k8sapierrors.IsServerTimeout(err) || k8sapierrors.IsTimeout(err) ||
+		k8sapierrors.IsServiceUnavailable(err) || k8sapierrors.IsInternalError(err) ||
+		k8sapierrors.IsUnexpectedServerError(err) || k8sapierrors.IsConflict(err) ||
+		utilnet.IsConnectionRefused(err) || utilnet.IsConnectionReset(err) || utilnet.IsProbableEOF(err)
+}
+
 // Deconfigure removes the node from the cluster, reverting changes made by the Configure function
-func (nc *nodeConfig) Deconfigure() error {
+func (nc *NodeConfig) Deconfigure() error {
+	return nc.deconfigure(false)
+}
+
+// SoftDeconfigure removes the node from the cluster like Deconfigure, but does not drain the node nor remove the HNS
+// networks of the instance, so that processes on the instance which are not managed by WMCO are left undisturbed. The
+// pods of the node are deleted along with the node, without honoring their disruption budgets.
+func (nc *NodeConfig) SoftDeconfigure() error {
+	return nc.deconfigure(true)
+}
+
+// deconfigure reverts the changes made by the Configure function. If soft is true, the node is cordoned but not
+// drained, and the HNS networks of the instance are kept.
+func (nc *NodeConfig) deconfigure(soft bool) error {
 	if nc.node == nil {
 		return fmt.Errorf("instance does not a have an associated node to deconfigure")
 	}
-	nc.log.Info("deconfiguring")
-	// Cordon and drain the Node before we interact with the instance
-	drainHelper := nc.newDrainHelper()
-	if err := drain.RunCordonOrUncordon(drainHelper, nc.node, true); err != nil {
-		return fmt.Errorf("unable to cordon node %s: %w", nc.node.GetName(), err)
+	// the drain settings decide which pods may be evicted
+	if err := nc.checkSettings(); err != nil {
+		return err
 	}
-	if err := drain.RunNodeDrain(drainHelper, nc.node.GetName()); err != nil {
-		return fmt.Errorf("unable to drain node %s: %w", nc.node.GetName(), err)
+	nc.log.Info("deconfiguring", "soft", soft)
+	// Cordon and, unless soft, drain the Node before we interact with the instance
+	if err := nc.cordonAndDrain(nc.newDrainHelper(), !soft); err != nil {
+		return err
 	}
 
 	// Revert all changes we've made to the instance by removing installed services, files, and the version annotation
 	if err := nc.cleanupWithWICD(); err != nil {
 		return err
 	}
-	if err := nc.Windows.RemoveFilesAndNetworks(); err != nil {
+	removeFiles := nc.Windows.RemoveFilesAndNetworks
+	if soft {
+		removeFiles = nc.Windows.RemoveFiles
+	}
+	if err := removeFiles(); err != nil {
 		return fmt.Errorf("error deconfiguring instance: %w", err)
 	}
 
@@ -559,8 +2611,8 @@ func (nc *nodeConfig) Deconfigure() error {
 }
 
 // cleanupWithWICD runs WICD cleanup and waits until the cleanup effects are fully complete
-func (nc *nodeConfig) cleanupWithWICD() error {
-	wicdKC, err := nc.generateWICDKubeconfig()
+func (nc *NodeConfig) cleanupWithWICD() error {
+	wicdKC, _, err := nc.generateWICDKubeconfig()
 	if err != nil {
 		return err
 	}
@@ -571,10 +2623,146 @@ func (nc *nodeConfig) cleanupWithWICD() error {
 	return metadata.WaitForRebootAnnotationRemoval(context.TODO(), nc.client, nc.node.Name)
 }
 
-// UpdateKubeletClientCA updates the kubelet client CA certificate file in the Windows node. No service restart or
-// reboot required, kubelet detects the changes in the file system and use the new CA certificate. The file is replaced
-// if and only if it does not exist or there is a checksum mismatch.
-func (nc *nodeConfig) UpdateKubeletClientCA(contents []byte) error {
+// UpdateWICDKubeconfig ensures WICD on the instance uses a kubeconfig generated from the newest WICD ServiceAccount
+// token, and records the token secret used on the node. WICD is restarted if its kubeconfig had to be changed.
+func (nc *NodeConfig) UpdateWICDKubeconfig() error {
+	if nc.node == nil {
+		return fmt.Errorf("updating the WICD kubeconfig requires an associated node")
+	}
+	wicdKC, wicdTokenSecret, err := nc.generateWICDKubeconfig()
+	if err != nil {
+		return err
+	}
+	if err = nc.Windows.UpdateWICDKubeconfig(wicdKC); err != nil {
+		return fmt.Errorf("error updating WICD kubeconfig: %w", err)
+	}
+	if err = metadata.ApplyLabelsAndAnnotations(context.TODO(), nc.client, *nc.node, nil,
+		map[string]string{metadata.WICDTokenAnnotation: wicdTokenSecret}); err != nil {
+		return fmt.Errorf("error updating %s annotation on node %s: %w", metadata.WICDTokenAnnotation,
+			nc.node.GetName(), err)
+	}
+	nc.log.Info("updated WICD kubeconfig", "secret", wicdTokenSecret)
+	return nil
+}
+
+// EnsureWICDKubeconfigServer updates the WICD kubeconfig on the instance if it points at an API server endpoint other
+// than the cluster's current one, which WICD would otherwise silently fail to reach the cluster through
+func (nc *NodeConfig) EnsureWICDKubeconfigServer() error {
+	if nodeConfigCache.apiServerEndpoint == "" {
+		return fmt.Errorf("the API server endpoint of the cluster is unknown")
+	}
+	server, err := nc.Windows.GetWICDKubeconfigServer()
+	if err != nil {
+		return err
+	}
+	if server == nodeConfigCache.apiServerEndpoint {
+		return nil
+	}
+	nc.log.Info("WICD kubeconfig points at an outdated API server endpoint", "server", server,
+		"expected", nodeConfigCache.apiServerEndpoint)
+	return nc.UpdateWICDKubeconfig()
+}
+
+// kubeletCertDir returns the directory kubelet stores its certificates in on the instance
+func (nc *NodeConfig) kubeletCertDir() string {
+	if nc.settings.KubeletCertDir != "" {
+		return nc.settings.KubeletCertDir
+	}
+	return windows.KubeletCertDir
+}
+
+// RenewKubeletServingCert causes kubelet to request a new serving certificate if the node's addresses have changed
+// since the serving certificate was requested, so that the certificate is valid for the node's current addresses
+func (nc *NodeConfig) RenewKubeletServingCert() error {
+	if nc.node == nil {
+		return fmt.Errorf("renewing the kubelet serving certificate requires an associated node")
+	}
+	addresses := ServingCertAddresses(nc.node)
+	if recorded, present := nc.node.GetAnnotations()[metadata.ServingCertAddressesAnnotation]; !present {
+		// The initial serving certificate is requested for the addresses the node registered with
+		nc.log.V(1).Info("recording kubelet serving certificate addresses", "addresses", addresses)
+	} else if recorded != addresses {
+		nc.log.Info("node addresses changed, renewing kubelet serving certificate", "previous", recorded,
+			"current", addresses)
+		if err := nc.Windows.RenewKubeletServingCert(nc.kubeletCertDir()); err != nil {
+			return fmt.Errorf("error renewing kubelet serving certificate: %w", err)
+		}
+	} else {
+		return nil
+	}
+	if err := metadata.ApplyLabelsAndAnnotations(context.TODO(), nc.client, *nc.node, nil,
+		map[string]string{metadata.ServingCertAddressesAnnotation: addresses}); err != nil {
+		return fmt.Errorf("error updating %s annotation on node %s: %w", metadata.ServingCertAddressesAnnotation,
+			nc.node.GetName(), err)
+	}
+	return nil
+}
+
+// ServingCertAddresses returns the addresses of the given node which kubelet includes in its serving certificate, as a
+// sorted comma separated list
+func ServingCertAddresses(node *core.Node) string {
+	addresses := sets.NewString()
+	for _, address := range node.Status.Addresses {
+		switch address.Type {
+		case core.NodeHostName, core.NodeInternalDNS, core.NodeExternalDNS, core.NodeInternalIP, core.NodeExternalIP:
+			addresses.Insert(address.Address)
+		}
+	}
+	return strings.Join(addresses.List(), ",")
+}
+
+// HasWindowsTaint returns true if the given node has the taint every Windows node must have
+func HasWindowsTaint(node *core.Node) bool {
+	for _, taint := range node.Spec.Taints {
+		if taint.MatchTaint(&windowsTaint) && taint.Value == windowsTaint.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// EnsureWindowsTaint applies the taint every Windows node must have to the given node, if it is missing. Other taints
+// of the node are left untouched, apart from one with the same key and effect, which is replaced. Returns true if the
+// taint had to be applied.
+func EnsureWindowsTaint(clientset kubernetes.Interface, node *core.Node) (bool, error) {
+	if HasWindowsTaint(node) {
+		return false, nil
+	}
+	if err := cloudnodeutil.AddOrUpdateTaintOnNode(clientset, node.GetName(), &windowsTaint); err != nil {
+		return false, fmt.Errorf("error applying Windows taint to node %s: %w", node.GetName(), err)
+	}
+	return true, nil
+}
+
+// KubeletClientCA returns the CA bundle kubelet uses to verify kube-apiserver's client certificate, merging the given
+// CA data of the ControllerConfig with the kube-apiserver-to-kubelet-client-ca ConfigMap it is synced from. When the CA
+// is rotated, the ConfigMap is updated before the ControllerConfig, and merging both sources ensures kubelet trusts the
+// new CA as soon as either has it, while still trusting the CA kube-apiserver may be using until the other catches up.
+func KubeletClientCA(ctx context.Context, c client.Client, controllerConfigCA []byte) ([]byte, error) {
+	var configMapCA []byte
+	cm := &core.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Namespace: certificates.KubeApiServerOperatorNamespace,
+		Name: certificates.KubeAPIServerServingCAConfigMapName}, cm)
+	if err == nil {
+		if configMapCA, err = certificates.GetCAsFromConfigMap(cm, certificates.CABundleKey); err != nil {
+			return nil, err
+		}
+	} else if !k8sapierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("unable to get ConfigMap %s/%s: %w", certificates.KubeApiServerOperatorNamespace,
+			certificates.KubeAPIServerServingCAConfigMapName, err)
+	}
+	merged, err := certificates.MergeCABundles(controllerConfigCA, configMapCA)
+	if err != nil {
+		return nil, fmt.Errorf("error merging kubelet client CA bundles: %w", err)
+	}
+	return merged, nil
+}
+
+// UpdateKubeletClientCA updates the kubelet client CA certificate file in the Windows node. The file is replaced
+// if and only if it does not exist or there is a checksum mismatch. kubelet is expected to detect the change in the
+// file system and use the new CA certificate, so it is only restarted if it still rejects kube-apiserver's client
+// certificate once it has been given time to reload the file.
+func (nc *NodeConfig) UpdateKubeletClientCA(contents []byte) error {
 	// check CA bundle contents
 	if len(contents) == 0 {
 		// nothing do to, return
@@ -584,12 +2772,48 @@ func (nc *nodeConfig) UpdateKubeletClientCA(contents []byte) error {
 	if err != nil {
 		return err
 	}
+	if nc.node == nil {
+		return nil
+	}
+	var authenticated bool
+	err = wait.PollUntilContextTimeout(context.TODO(), retry.Interval, kubeletClientCAReloadTimeout, true,
+		func(ctx context.Context) (bool, error) {
+			authenticated, err = nc.kubeletAuthenticatesAPIServer(ctx)
+			return authenticated, err
+		})
+	if err != nil && !wait.Interrupted(err) {
+		// Other failures, such as the node being unreachable, say nothing about the CA kubelet is using
+		nc.log.Info("unable to verify kubelet loaded its client CA", "node", nc.node.GetName(), "error", err)
+		return nil
+	}
+	if authenticated {
+		return nil
+	}
+	nc.log.Info("kubelet has not loaded the updated client CA, restarting it", "node", nc.node.GetName())
+	if err = nc.Windows.RestartService(windows.KubeletServiceName); err != nil {
+		return fmt.Errorf("error restarting kubelet to load the updated client CA: %w", err)
+	}
 	return nil
 }
 
+// kubeletAuthenticatesAPIServer returns true if kubelet accepts the client certificate kube-apiserver presents when
+// proxying a request to the node, which is verified against kubelet's client CA. An error is returned if the request
+// fails for any reason other than the client certificate being rejected.
+func (nc *NodeConfig) kubeletAuthenticatesAPIServer(ctx context.Context) (bool, error) {
+	err := nc.k8sclientset.CoreV1().RESTClient().Get().Resource("nodes").Name(nc.node.GetName()).
+		SubResource("proxy").Suffix("healthz").Do(ctx).Error()
+	if err == nil {
+		return true, nil
+	}
+	if k8sapierrors.IsUnauthorized(err) {
+		return false, nil
+	}
+	return false, err
+}
+
 // SyncTrustedCABundle builds the trusted CA ConfigMap from image registry certificates and the proxy trust bundle
 // and ensures the cert bundle on the instance has up-to-date data
-func (nc *nodeConfig) SyncTrustedCABundle() error {
+func (nc *NodeConfig) SyncTrustedCABundle() error {
 	caBundle := ""
 	var cc mcfg.ControllerConfig
 	if err := nc.client.Get(context.TODO(), types.NamespacedName{Namespace: nc.wmcoNamespace,
@@ -614,13 +2838,13 @@ func (nc *nodeConfig) SyncTrustedCABundle() error {
 }
 
 // UpdateTrustedCABundleFile updates the file containing the trusted CA bundle in the Windows node, if needed
-func (nc *nodeConfig) UpdateTrustedCABundleFile(data string) error {
+func (nc *NodeConfig) UpdateTrustedCABundleFile(data string) error {
 	dir, fileName := windows.SplitPath(windows.TrustedCABundlePath)
 	return nc.Windows.EnsureFileContent([]byte(data), fileName, dir)
 }
 
 // createTLSCerts creates cert files containing the TLS cert and the key on the Windows node
-func (nc *nodeConfig) createTLSCerts() error {
+func (nc *NodeConfig) createTLSCerts() error {
 	tlsSecret := &core.Secret{}
 	if err := nc.client.Get(context.TODO(), types.NamespacedName{Name: secrets.TLSSecret,
 		Namespace: nc.wmcoNamespace}, tlsSecret); err != nil {
@@ -664,20 +2888,35 @@ func generateKubeconfig(caCert []byte, token, apiServerURL, username string) cli
 	return kubeconfig
 }
 
-// generateKubeletConfiguration returns the configuration spec for the kubelet Windows service
-func generateKubeletConfiguration(clusterDNS string) kubeletconfig.KubeletConfiguration {
+// generateKubeletConfiguration returns the configuration spec for the kubelet Windows service. clusterDNS is the
+// address of the cluster DNS service, and platform is the platform of the cluster. If registerNode
+// is false kubelet does not register the Node, leaving the Windows taint to be applied by whatever registers it.
+func generateKubeletConfiguration(clusterDNS string, s *settings.Settings, platform configv1.PlatformType,
+	registerNode bool) kubeletconfig.KubeletConfiguration {
 	// default numeric values chosen based on the OpenShift kubelet config recommendations for Linux worker nodes
 	falseBool := false
 	trueBool := true
 	kubeAPIQPS := int32(50)
 	emptyString := ""
-	return kubeletconfig.KubeletConfiguration{
+	cgroupsPerQOS := s.KubeletCgroupsPerQOS
+	serializeImagePulls := s.KubeletSerializeImagePulls
+	tlsMinVersion := settings.DefaultKubeletTLSMinVersion
+	if s.KubeletTLSMinVersion != "" {
+		tlsMinVersion = s.KubeletTLSMinVersion
+	}
+	tlsCipherSuites := settings.DefaultKubeletTLSCipherSuites
+	if len(s.KubeletTLSCipherSuites) > 0 {
+		tlsCipherSuites = s.KubeletTLSCipherSuites
+	}
+	kubeletConfig := kubeletconfig.KubeletConfiguration{
 		TypeMeta: meta.TypeMeta{
 			Kind:       "KubeletConfiguration",
 			APIVersion: "kubelet.config.k8s.io/v1beta1",
 		},
 		RotateCertificates: true,
 		ServerTLSBootstrap: true,
+		TLSMinVersion:      tlsMinVersion,
+		TLSCipherSuites:    tlsCipherSuites,
 		Authentication: kubeletconfig.KubeletAuthentication{
 			X509: kubeletconfig.KubeletX509Authentication{
 				ClientCAFile: windows.K8sDir + "\\" + KubeletClientCAFilename,
@@ -686,35 +2925,164 @@ func generateKubeletConfiguration(clusterDNS string) kubeletconfig.KubeletConfig
 				Enabled: &falseBool,
 			},
 		},
-		ClusterDomain:         "cluster.local",
-		ClusterDNS:            []string{clusterDNS},
-		CgroupsPerQOS:         &falseBool,
-		RuntimeRequestTimeout: meta.Duration{Duration: 10 * time.Minute},
-		MaxPods:               250,
-		KubeAPIQPS:            &kubeAPIQPS,
-		KubeAPIBurst:          100,
-		SerializeImagePulls:   &falseBool,
-		EnableSystemLogQuery:  &trueBool,
-		FeatureGates: map[string]bool{
-			"RotateKubeletServerCertificate": true,
-		},
-		ContainerLogMaxSize: "50Mi",
-		SystemReserved: map[string]string{
-			"cpu":               "500m",
-			"ephemeral-storage": "1Gi",
-			"memory":            "1Gi",
-		},
+		ClusterDomain:            "cluster.local",
+		ClusterDNS:               []string{clusterDNS},
+		CgroupsPerQOS:            &cgroupsPerQOS,
+		RuntimeRequestTimeout:    meta.Duration{Duration: 10 * time.Minute},
+		MaxPods:                  maxPods(s.KubeletMaxPods, platform),
+		KubeAPIQPS:               &kubeAPIQPS,
+		KubeAPIBurst:             100,
+		SerializeImagePulls:      &serializeImagePulls,
+		EnableSystemLogQuery:     &trueBool,
+		ContainerLogMaxSize:      "50Mi",
+		SystemReserved:           maps.Clone(settings.DefaultKubeletSystemReserved),
+		KubeReserved:             kubeReserved(s.KubeletKubeReserved),
 		ContainerRuntimeEndpoint: "npipe://./pipe/containerd-containerd",
 		// Registers the Kubelet with Windows specific taints so that linux pods won't get scheduled onto
 		// Windows nodes. Explicitly set RegisterNode to ensure RegisterWithTaints takes effect.
-		RegisterNode: &trueBool,
-		RegisterWithTaints: []core.Taint{
-			{Key: "os", Value: "Windows", Effect: core.TaintEffectNoSchedule},
-		},
+		RegisterNode:       &registerNode,
+		RegisterWithTaints: []core.Taint{windowsTaint},
 		// Set to empty string to override the default. Network configuration in Windows is stored in the
 		// registry database rather than files like in Linux.
 		ResolverConfig: &emptyString,
 	}
+	kubeletConfig.FeatureGates = kubeletFeatureGates(s.KubeletFeatureGates)
+	if s.KubeletPodPidsLimit > 0 && kubeletSupportsPodPidsLimit {
+		podPidsLimit := s.KubeletPodPidsLimit
+		kubeletConfig.PodPidsLimit = &podPidsLimit
+	}
+	// kubelet rejects a maximum number of parallel pulls when image pulls are serialized
+	if !*kubeletConfig.SerializeImagePulls {
+		maxParallelImagePulls := settings.DefaultKubeletMaxParallelImagePulls
+		if s.KubeletMaxParallelImagePulls > 0 {
+			maxParallelImagePulls = s.KubeletMaxParallelImagePulls
+		}
+		kubeletConfig.MaxParallelImagePulls = &maxParallelImagePulls
+	}
+	kubeletConfig.EvictionMinimumReclaim = evictionMinimumReclaim(s.KubeletEvictionMinimumReclaim)
+	if s.KubeletShutdownGracePeriod > 0 && kubeletSupportsGracefulNodeShutdown {
+		kubeletConfig.FeatureGates["WindowsGracefulNodeShutdown"] = true
+		kubeletConfig.ShutdownGracePeriod = meta.Duration{Duration: s.KubeletShutdownGracePeriod}
+		kubeletConfig.ShutdownGracePeriodCriticalPods = meta.Duration{Duration: s.KubeletShutdownGracePeriodCriticalPods}
+	}
+	if s.KubeletHardening {
+		applyKubeletHardening(&kubeletConfig)
+	}
+	// user set event rate limits take precedence over the event rate of the hardening profile
+	if s.KubeletEventRecordQPS != nil {
+		eventRecordQPS := *s.KubeletEventRecordQPS
+		kubeletConfig.EventRecordQPS = &eventRecordQPS
+	}
+	if s.KubeletEventBurst > 0 {
+		kubeletConfig.EventBurst = s.KubeletEventBurst
+	}
+	// kubelet does not read static pods unless a manifest directory is given
+	kubeletConfig.StaticPodPath = s.KubeletStaticPodPath
+	if s.KubeletNodeStatusUpdateFrequency > 0 {
+		kubeletConfig.NodeStatusUpdateFrequency = meta.Duration{Duration: s.KubeletNodeStatusUpdateFrequency}
+	}
+	if s.KubeletSyncFrequency > 0 {
+		kubeletConfig.SyncFrequency = meta.Duration{Duration: s.KubeletSyncFrequency}
+	}
+	if !registerNode {
+		// registerWithTaints only has an effect when kubelet registers the Node
+		kubeletConfig.RegisterWithTaints = nil
+	}
+	return kubeletConfig
+}
+
+// kubeletFeatureGates returns the given user set feature gates merged with the feature gates WMCO requires
+func kubeletFeatureGates(gates map[string]bool) map[string]bool {
+	merged := maps.Clone(gates)
+	if merged == nil {
+		merged = make(map[string]bool)
+	}
+	merged[settings.RotateKubeletServerCertificateGate] = true
+	return merged
+}
+
+// validateStaticPodPath returns an error if the given static pod path is within a directory WMCO removes when
+// deconfiguring an instance, as the static pod manifests are managed by the user when a path is given
+func validateStaticPodPath(path string) error {
+	if path == "" {
+		return nil
+	}
+	for _, dir := range windows.RequiredDirectories {
+		if strings.EqualFold(path, dir) || strings.HasPrefix(strings.ToLower(path), strings.ToLower(dir)+"\\") {
+			return fmt.Errorf("kubelet static pod path %s must not be within %s, which is managed by WMCO", path, dir)
+		}
+	}
+	return nil
+}
+
+// applyKubeletHardening sets the options of the kubelet hardening profile in the given kubelet configuration. The
+// values are the ones the CIS profile of the OpenShift Compliance Operator checks Linux workers for. Options which have
+// no effect on Windows, such as protectKernelDefaults and makeIPTablesUtilChains, are left out, and options whose
+// default is already hardened are set explicitly so that scanners reading the kubelet config find them.
+func applyKubeletHardening(kubeletConfig *kubeletconfig.KubeletConfiguration) {
+	trueBool := true
+	eventRecordQPS := hardenedEventRecordQPS
+	kubeletConfig.StreamingConnectionIdleTimeout = meta.Duration{Duration: hardenedStreamingConnectionIdleTimeout}
+	kubeletConfig.EventRecordQPS = &eventRecordQPS
+	kubeletConfig.Authentication.Webhook.Enabled = &trueBool
+	kubeletConfig.Authorization.Mode = kubeletconfig.KubeletAuthorizationModeWebhook
+}
+
+// validateKubeletRegistration returns an error if the given kubelet configuration registers the Node with taints
+// without registering the Node, or registers the Node without the Windows taint
+func validateKubeletRegistration(kubeletConfig kubeletconfig.KubeletConfiguration) error {
+	registerNode := kubeletConfig.RegisterNode == nil || *kubeletConfig.RegisterNode
+	if !registerNode {
+		if len(kubeletConfig.RegisterWithTaints) > 0 {
+			return fmt.Errorf("registerWithTaints cannot be given when registerNode is false")
+		}
+		return nil
+	}
+	for _, taint := range kubeletConfig.RegisterWithTaints {
+		if taint.MatchTaint(&windowsTaint) && taint.Value == windowsTaint.Value {
+			return nil
+		}
+	}
+	return fmt.Errorf("node must be registered with the %s taint", windowsTaint.ToString())
+}
+
+// maxPods returns the given maximum number of pods, or the default for the given platform if none is given
+func maxPods(given int32, platform configv1.PlatformType) int32 {
+	if given > 0 {
+		return given
+	}
+	if pods, ok := defaultKubeletMaxPods[platform]; ok {
+		return pods
+	}
+	return fallbackKubeletMaxPods
+}
+
+// evictionMinimumReclaim returns the minimum reclaim for each eviction signal supported on Windows, using the default
+// for any signal not present in the given map. Signals which are not supported on Windows are dropped.
+func evictionMinimumReclaim(given map[string]string) map[string]string {
+	reclaim := make(map[string]string)
+	for _, signal := range settings.KubeletEvictionSignals {
+		if amount, ok := given[signal]; ok {
+			reclaim[signal] = amount
+		} else if amount, ok := settings.DefaultKubeletEvictionMinimumReclaim[signal]; ok {
+			reclaim[signal] = amount
+		}
+	}
+	return reclaim
+}
+
+// kubeReserved returns the amount of each resource reserved for kubelet and containerd, using the default for any
+// resource not present in the given map
+func kubeReserved(given map[string]string) map[string]string {
+	reserved := make(map[string]string)
+	for _, name := range settings.KubeletReservedResources {
+		if amount, ok := given[name]; ok {
+			reserved[name] = amount
+		} else {
+			reserved[name] = settings.DefaultKubeletKubeReserved[name]
+		}
+	}
+	return reserved
 }
 
 // translateIgnitionFilesForWindows returns a mapping of Windows file paths and contents, as specified by the given
@@ -765,6 +3133,48 @@ func modifyCredentialProviderConfig(fileContents []byte) ([]byte, error) {
 	return fileContents, nil
 }
 
+// credentialProviderConfig returns the contents of a CredentialProviderConfig yaml file combining the providers of the
+// given platform config, which may be empty, with the given providers. A given provider replaces the platform provider
+// of the same name. The names of the binaries of the given providers which are not provided by the platform are also
+// returned, as these must be transferred to the instance. An empty config is returned if there are no providers.
+func credentialProviderConfig(platformConf string,
+	providers []kubeletconfigv1.CredentialProvider) (string, []string, error) {
+	providerConf := kubeletconfigv1.CredentialProviderConfig{}
+	if err := yaml.Unmarshal([]byte(platformConf), &providerConf); err != nil {
+		return "", nil, fmt.Errorf("could not unmarshal provider config: %w", err)
+	}
+	var binaries []string
+	for _, provider := range providers {
+		binary := strings.TrimSuffix(provider.Name, ".exe") + ".exe"
+		i := slices.IndexFunc(providerConf.Providers, func(platformProvider kubeletconfigv1.CredentialProvider) bool {
+			return strings.EqualFold(platformProvider.Name, binary)
+		})
+		if i >= 0 {
+			providerConf.Providers[i] = provider
+			continue
+		}
+		providerConf.Providers = append(providerConf.Providers, provider)
+		binaries = append(binaries, binary)
+	}
+	if len(providerConf.Providers) == 0 {
+		return "", nil, nil
+	}
+	if providerConf.Kind == "" {
+		providerConf.Kind = "CredentialProviderConfig"
+		providerConf.APIVersion = kubeletconfigv1.SchemeGroupVersion.String()
+	}
+	fileContents, err := yaml.Marshal(&providerConf)
+	if err != nil {
+		return "", nil, fmt.Errorf("error marshalling provider config: %w", err)
+	}
+	// the given providers may not have the .exe suffix needed for them to be run on Windows
+	fileContents, err = modifyCredentialProviderConfig(fileContents)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(fileContents), binaries, nil
+}
+
 // CreatePubKeyHashAnnotation returns a formatted string which can be used for a public key annotation on a node.
 // The annotation is the sha256 of the public key
 func CreatePubKeyHashAnnotation(key ssh.PublicKey) string {
@@ -779,8 +3189,8 @@ func appendToCABundle(bundle mcfg.ImageRegistryBundle) string {
 	return fmt.Sprintf("# %s\n%s\n\n", strings.ReplaceAll(bundle.File, "..", ":"), bundle.Data)
 }
 
-// validWICDServiceAccountTokenSecret returns true if the given secret provides a token for the WICD SA
-func validWICDServiceAccountTokenSecret(secret core.Secret) bool {
+// ValidWICDServiceAccountTokenSecret returns true if the given secret provides a token for the WICD SA
+func ValidWICDServiceAccountTokenSecret(secret core.Secret) bool {
 	if secret.Type != core.SecretTypeServiceAccountToken {
 		return false
 	}
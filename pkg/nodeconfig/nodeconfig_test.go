@@ -1,13 +1,36 @@
 package nodeconfig
 
 import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-logr/logr"
+	configv1 "github.com/openshift/api/config/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	core "k8s.io/api/core/v1"
+	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/kubectl/pkg/drain"
 	config "k8s.io/kubelet/config/v1"
+	kubeletconfig "k8s.io/kubelet/config/v1beta1"
+	"k8s.io/utils/ptr"
 	"sigs.k8s.io/yaml"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
+	"github.com/openshift/windows-machine-config-operator/pkg/retry"
+	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
+	"github.com/openshift/windows-machine-config-operator/pkg/settings"
 )
 
 func TestNewKubeConfigFromSecret(t *testing.T) {
@@ -77,25 +100,71 @@ func TestNewKubeConfigFromSecret(t *testing.T) {
 func TestCreateKubeletConf(t *testing.T) {
 	testCases := []struct {
 		name         string
-		cidr         string
+		cidrs        []string
+		settings     *settings.Settings
 		expectedSpec string
 		expectedErr  bool
 	}{
 		{
 			name:         "valid cidr",
-			cidr:         "10.0.128.8/24",
-			expectedSpec: "{\"kind\":\"KubeletConfiguration\",\"apiVersion\":\"kubelet.config.k8s.io/v1beta1\",\"syncFrequency\":\"0s\",\"fileCheckFrequency\":\"0s\",\"httpCheckFrequency\":\"0s\",\"rotateCertificates\":true,\"serverTLSBootstrap\":true,\"authentication\":{\"x509\":{\"clientCAFile\":\"C:\\\\k\\\\kubelet-ca.crt\"},\"webhook\":{\"cacheTTL\":\"0s\"},\"anonymous\":{\"enabled\":false}},\"authorization\":{\"webhook\":{\"cacheAuthorizedTTL\":\"0s\",\"cacheUnauthorizedTTL\":\"0s\"}},\"clusterDomain\":\"cluster.local\",\"clusterDNS\":[\"10.0.128.10\"],\"streamingConnectionIdleTimeout\":\"0s\",\"nodeStatusUpdateFrequency\":\"0s\",\"nodeStatusReportFrequency\":\"0s\",\"imageMinimumGCAge\":\"0s\",\"imageMaximumGCAge\":\"0s\",\"volumeStatsAggPeriod\":\"0s\",\"cgroupsPerQOS\":false,\"cpuManagerReconcilePeriod\":\"0s\",\"runtimeRequestTimeout\":\"10m0s\",\"maxPods\":250,\"resolvConf\":\"\",\"kubeAPIQPS\":50,\"kubeAPIBurst\":100,\"serializeImagePulls\":false,\"evictionPressureTransitionPeriod\":\"0s\",\"featureGates\":{\"RotateKubeletServerCertificate\":true},\"memorySwap\":{},\"containerLogMaxSize\":\"50Mi\",\"systemReserved\":{\"cpu\":\"500m\",\"ephemeral-storage\":\"1Gi\",\"memory\":\"1Gi\"},\"logging\":{\"flushFrequency\":0,\"verbosity\":0,\"options\":{\"text\":{\"infoBufferSize\":\"0\"},\"json\":{\"infoBufferSize\":\"0\"}}},\"enableSystemLogQuery\":true,\"shutdownGracePeriod\":\"0s\",\"shutdownGracePeriodCriticalPods\":\"0s\",\"registerWithTaints\":[{\"key\":\"os\",\"value\":\"Windows\",\"effect\":\"NoSchedule\"}],\"registerNode\":true,\"containerRuntimeEndpoint\":\"npipe://./pipe/containerd-containerd\",\"enforceNodeAllocatable\":[]}",
+			cidrs:        []string{"10.0.128.8/24"},
+			settings:     &settings.Settings{},
+			expectedSpec: "{\"kind\":\"KubeletConfiguration\",\"apiVersion\":\"kubelet.config.k8s.io/v1beta1\",\"syncFrequency\":\"0s\",\"fileCheckFrequency\":\"0s\",\"httpCheckFrequency\":\"0s\",\"tlsCipherSuites\":[\"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256\",\"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256\",\"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384\",\"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384\",\"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256\",\"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256\"],\"tlsMinVersion\":\"VersionTLS12\",\"rotateCertificates\":true,\"serverTLSBootstrap\":true,\"authentication\":{\"x509\":{\"clientCAFile\":\"C:\\\\k\\\\kubelet-ca.crt\"},\"webhook\":{\"cacheTTL\":\"0s\"},\"anonymous\":{\"enabled\":false}},\"authorization\":{\"webhook\":{\"cacheAuthorizedTTL\":\"0s\",\"cacheUnauthorizedTTL\":\"0s\"}},\"clusterDomain\":\"cluster.local\",\"clusterDNS\":[\"10.0.128.10\"],\"streamingConnectionIdleTimeout\":\"0s\",\"nodeStatusUpdateFrequency\":\"0s\",\"nodeStatusReportFrequency\":\"0s\",\"imageMinimumGCAge\":\"0s\",\"imageMaximumGCAge\":\"0s\",\"volumeStatsAggPeriod\":\"0s\",\"cgroupsPerQOS\":false,\"cpuManagerReconcilePeriod\":\"0s\",\"runtimeRequestTimeout\":\"10m0s\",\"maxPods\":250,\"resolvConf\":\"\",\"kubeAPIQPS\":50,\"kubeAPIBurst\":100,\"serializeImagePulls\":false,\"maxParallelImagePulls\":5,\"evictionPressureTransitionPeriod\":\"0s\",\"evictionMinimumReclaim\":{\"imagefs.available\":\"2Gi\",\"memory.available\":\"100Mi\",\"nodefs.available\":\"500Mi\"},\"featureGates\":{\"RotateKubeletServerCertificate\":true},\"memorySwap\":{},\"containerLogMaxSize\":\"50Mi\",\"systemReserved\":{\"cpu\":\"300m\",\"ephemeral-storage\":\"500Mi\",\"memory\":\"600Mi\"},\"kubeReserved\":{\"cpu\":\"200m\",\"ephemeral-storage\":\"500Mi\",\"memory\":\"400Mi\"},\"logging\":{\"flushFrequency\":0,\"verbosity\":0,\"options\":{\"text\":{\"infoBufferSize\":\"0\"},\"json\":{\"infoBufferSize\":\"0\"}}},\"enableSystemLogQuery\":true,\"shutdownGracePeriod\":\"0s\",\"shutdownGracePeriodCriticalPods\":\"0s\",\"registerWithTaints\":[{\"key\":\"os\",\"value\":\"Windows\",\"effect\":\"NoSchedule\"}],\"registerNode\":true,\"containerRuntimeEndpoint\":\"npipe://./pipe/containerd-containerd\",\"enforceNodeAllocatable\":[]}",
+			expectedErr:  false,
+		},
+		{
+			name:         "cluster DNS override",
+			cidrs:        []string{"10.0.128.8/24"},
+			settings:     &settings.Settings{KubeletClusterDNS: "10.0.128.53"},
+			expectedSpec: "{\"kind\":\"KubeletConfiguration\",\"apiVersion\":\"kubelet.config.k8s.io/v1beta1\",\"syncFrequency\":\"0s\",\"fileCheckFrequency\":\"0s\",\"httpCheckFrequency\":\"0s\",\"tlsCipherSuites\":[\"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256\",\"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256\",\"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384\",\"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384\",\"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256\",\"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256\"],\"tlsMinVersion\":\"VersionTLS12\",\"rotateCertificates\":true,\"serverTLSBootstrap\":true,\"authentication\":{\"x509\":{\"clientCAFile\":\"C:\\\\k\\\\kubelet-ca.crt\"},\"webhook\":{\"cacheTTL\":\"0s\"},\"anonymous\":{\"enabled\":false}},\"authorization\":{\"webhook\":{\"cacheAuthorizedTTL\":\"0s\",\"cacheUnauthorizedTTL\":\"0s\"}},\"clusterDomain\":\"cluster.local\",\"clusterDNS\":[\"10.0.128.53\"],\"streamingConnectionIdleTimeout\":\"0s\",\"nodeStatusUpdateFrequency\":\"0s\",\"nodeStatusReportFrequency\":\"0s\",\"imageMinimumGCAge\":\"0s\",\"imageMaximumGCAge\":\"0s\",\"volumeStatsAggPeriod\":\"0s\",\"cgroupsPerQOS\":false,\"cpuManagerReconcilePeriod\":\"0s\",\"runtimeRequestTimeout\":\"10m0s\",\"maxPods\":250,\"resolvConf\":\"\",\"kubeAPIQPS\":50,\"kubeAPIBurst\":100,\"serializeImagePulls\":false,\"maxParallelImagePulls\":5,\"evictionPressureTransitionPeriod\":\"0s\",\"evictionMinimumReclaim\":{\"imagefs.available\":\"2Gi\",\"memory.available\":\"100Mi\",\"nodefs.available\":\"500Mi\"},\"featureGates\":{\"RotateKubeletServerCertificate\":true},\"memorySwap\":{},\"containerLogMaxSize\":\"50Mi\",\"systemReserved\":{\"cpu\":\"300m\",\"ephemeral-storage\":\"500Mi\",\"memory\":\"600Mi\"},\"kubeReserved\":{\"cpu\":\"200m\",\"ephemeral-storage\":\"500Mi\",\"memory\":\"400Mi\"},\"logging\":{\"flushFrequency\":0,\"verbosity\":0,\"options\":{\"text\":{\"infoBufferSize\":\"0\"},\"json\":{\"infoBufferSize\":\"0\"}}},\"enableSystemLogQuery\":true,\"shutdownGracePeriod\":\"0s\",\"shutdownGracePeriodCriticalPods\":\"0s\",\"registerWithTaints\":[{\"key\":\"os\",\"value\":\"Windows\",\"effect\":\"NoSchedule\"}],\"registerNode\":true,\"containerRuntimeEndpoint\":\"npipe://./pipe/containerd-containerd\",\"enforceNodeAllocatable\":[]}",
+			expectedErr:  false,
+		},
+		{
+			name:        "cluster DNS override outside of the service network",
+			cidrs:       []string{"10.0.128.8/24"},
+			settings:    &settings.Settings{KubeletClusterDNS: "10.0.129.10"},
+			expectedErr: true,
+		},
+		{
+			name:         "kubelet hardening profile",
+			cidrs:        []string{"10.0.128.8/24"},
+			settings:     &settings.Settings{KubeletHardening: true},
+			expectedSpec: "{\"kind\":\"KubeletConfiguration\",\"apiVersion\":\"kubelet.config.k8s.io/v1beta1\",\"syncFrequency\":\"0s\",\"fileCheckFrequency\":\"0s\",\"httpCheckFrequency\":\"0s\",\"tlsCipherSuites\":[\"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256\",\"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256\",\"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384\",\"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384\",\"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256\",\"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256\"],\"tlsMinVersion\":\"VersionTLS12\",\"rotateCertificates\":true,\"serverTLSBootstrap\":true,\"authentication\":{\"x509\":{\"clientCAFile\":\"C:\\\\k\\\\kubelet-ca.crt\"},\"webhook\":{\"enabled\":true,\"cacheTTL\":\"0s\"},\"anonymous\":{\"enabled\":false}},\"authorization\":{\"mode\":\"Webhook\",\"webhook\":{\"cacheAuthorizedTTL\":\"0s\",\"cacheUnauthorizedTTL\":\"0s\"}},\"eventRecordQPS\":50,\"clusterDomain\":\"cluster.local\",\"clusterDNS\":[\"10.0.128.10\"],\"streamingConnectionIdleTimeout\":\"5m0s\",\"nodeStatusUpdateFrequency\":\"0s\",\"nodeStatusReportFrequency\":\"0s\",\"imageMinimumGCAge\":\"0s\",\"imageMaximumGCAge\":\"0s\",\"volumeStatsAggPeriod\":\"0s\",\"cgroupsPerQOS\":false,\"cpuManagerReconcilePeriod\":\"0s\",\"runtimeRequestTimeout\":\"10m0s\",\"maxPods\":250,\"resolvConf\":\"\",\"kubeAPIQPS\":50,\"kubeAPIBurst\":100,\"serializeImagePulls\":false,\"maxParallelImagePulls\":5,\"evictionPressureTransitionPeriod\":\"0s\",\"evictionMinimumReclaim\":{\"imagefs.available\":\"2Gi\",\"memory.available\":\"100Mi\",\"nodefs.available\":\"500Mi\"},\"featureGates\":{\"RotateKubeletServerCertificate\":true},\"memorySwap\":{},\"containerLogMaxSize\":\"50Mi\",\"systemReserved\":{\"cpu\":\"300m\",\"ephemeral-storage\":\"500Mi\",\"memory\":\"600Mi\"},\"kubeReserved\":{\"cpu\":\"200m\",\"ephemeral-storage\":\"500Mi\",\"memory\":\"400Mi\"},\"logging\":{\"flushFrequency\":0,\"verbosity\":0,\"options\":{\"text\":{\"infoBufferSize\":\"0\"},\"json\":{\"infoBufferSize\":\"0\"}}},\"enableSystemLogQuery\":true,\"shutdownGracePeriod\":\"0s\",\"shutdownGracePeriodCriticalPods\":\"0s\",\"registerWithTaints\":[{\"key\":\"os\",\"value\":\"Windows\",\"effect\":\"NoSchedule\"}],\"registerNode\":true,\"containerRuntimeEndpoint\":\"npipe://./pipe/containerd-containerd\",\"enforceNodeAllocatable\":[]}",
+			expectedErr:  false,
+		},
+		{
+			name:         "custom node status update frequency",
+			cidrs:        []string{"10.0.128.8/24"},
+			settings:     &settings.Settings{KubeletNodeStatusUpdateFrequency: 20 * time.Second},
+			expectedSpec: "{\"kind\":\"KubeletConfiguration\",\"apiVersion\":\"kubelet.config.k8s.io/v1beta1\",\"syncFrequency\":\"0s\",\"fileCheckFrequency\":\"0s\",\"httpCheckFrequency\":\"0s\",\"tlsCipherSuites\":[\"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256\",\"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256\",\"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384\",\"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384\",\"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256\",\"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256\"],\"tlsMinVersion\":\"VersionTLS12\",\"rotateCertificates\":true,\"serverTLSBootstrap\":true,\"authentication\":{\"x509\":{\"clientCAFile\":\"C:\\\\k\\\\kubelet-ca.crt\"},\"webhook\":{\"cacheTTL\":\"0s\"},\"anonymous\":{\"enabled\":false}},\"authorization\":{\"webhook\":{\"cacheAuthorizedTTL\":\"0s\",\"cacheUnauthorizedTTL\":\"0s\"}},\"clusterDomain\":\"cluster.local\",\"clusterDNS\":[\"10.0.128.10\"],\"streamingConnectionIdleTimeout\":\"0s\",\"nodeStatusUpdateFrequency\":\"20s\",\"nodeStatusReportFrequency\":\"0s\",\"imageMinimumGCAge\":\"0s\",\"imageMaximumGCAge\":\"0s\",\"volumeStatsAggPeriod\":\"0s\",\"cgroupsPerQOS\":false,\"cpuManagerReconcilePeriod\":\"0s\",\"runtimeRequestTimeout\":\"10m0s\",\"maxPods\":250,\"resolvConf\":\"\",\"kubeAPIQPS\":50,\"kubeAPIBurst\":100,\"serializeImagePulls\":false,\"maxParallelImagePulls\":5,\"evictionPressureTransitionPeriod\":\"0s\",\"evictionMinimumReclaim\":{\"imagefs.available\":\"2Gi\",\"memory.available\":\"100Mi\",\"nodefs.available\":\"500Mi\"},\"featureGates\":{\"RotateKubeletServerCertificate\":true},\"memorySwap\":{},\"containerLogMaxSize\":\"50Mi\",\"systemReserved\":{\"cpu\":\"300m\",\"ephemeral-storage\":\"500Mi\",\"memory\":\"600Mi\"},\"kubeReserved\":{\"cpu\":\"200m\",\"ephemeral-storage\":\"500Mi\",\"memory\":\"400Mi\"},\"logging\":{\"flushFrequency\":0,\"verbosity\":0,\"options\":{\"text\":{\"infoBufferSize\":\"0\"},\"json\":{\"infoBufferSize\":\"0\"}}},\"enableSystemLogQuery\":true,\"shutdownGracePeriod\":\"0s\",\"shutdownGracePeriodCriticalPods\":\"0s\",\"registerWithTaints\":[{\"key\":\"os\",\"value\":\"Windows\",\"effect\":\"NoSchedule\"}],\"registerNode\":true,\"containerRuntimeEndpoint\":\"npipe://./pipe/containerd-containerd\",\"enforceNodeAllocatable\":[]}",
+			expectedErr:  false,
+		},
+		{
+			name:  "custom kubelet TLS settings",
+			cidrs: []string{"10.0.128.8/24"},
+			settings: &settings.Settings{KubeletTLSMinVersion: "VersionTLS13",
+				KubeletTLSCipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}},
+			expectedSpec: "{\"kind\":\"KubeletConfiguration\",\"apiVersion\":\"kubelet.config.k8s.io/v1beta1\",\"syncFrequency\":\"0s\",\"fileCheckFrequency\":\"0s\",\"httpCheckFrequency\":\"0s\",\"tlsCipherSuites\":[\"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384\"],\"tlsMinVersion\":\"VersionTLS13\",\"rotateCertificates\":true,\"serverTLSBootstrap\":true,\"authentication\":{\"x509\":{\"clientCAFile\":\"C:\\\\k\\\\kubelet-ca.crt\"},\"webhook\":{\"cacheTTL\":\"0s\"},\"anonymous\":{\"enabled\":false}},\"authorization\":{\"webhook\":{\"cacheAuthorizedTTL\":\"0s\",\"cacheUnauthorizedTTL\":\"0s\"}},\"clusterDomain\":\"cluster.local\",\"clusterDNS\":[\"10.0.128.10\"],\"streamingConnectionIdleTimeout\":\"0s\",\"nodeStatusUpdateFrequency\":\"0s\",\"nodeStatusReportFrequency\":\"0s\",\"imageMinimumGCAge\":\"0s\",\"imageMaximumGCAge\":\"0s\",\"volumeStatsAggPeriod\":\"0s\",\"cgroupsPerQOS\":false,\"cpuManagerReconcilePeriod\":\"0s\",\"runtimeRequestTimeout\":\"10m0s\",\"maxPods\":250,\"resolvConf\":\"\",\"kubeAPIQPS\":50,\"kubeAPIBurst\":100,\"serializeImagePulls\":false,\"maxParallelImagePulls\":5,\"evictionPressureTransitionPeriod\":\"0s\",\"evictionMinimumReclaim\":{\"imagefs.available\":\"2Gi\",\"memory.available\":\"100Mi\",\"nodefs.available\":\"500Mi\"},\"featureGates\":{\"RotateKubeletServerCertificate\":true},\"memorySwap\":{},\"containerLogMaxSize\":\"50Mi\",\"systemReserved\":{\"cpu\":\"300m\",\"ephemeral-storage\":\"500Mi\",\"memory\":\"600Mi\"},\"kubeReserved\":{\"cpu\":\"200m\",\"ephemeral-storage\":\"500Mi\",\"memory\":\"400Mi\"},\"logging\":{\"flushFrequency\":0,\"verbosity\":0,\"options\":{\"text\":{\"infoBufferSize\":\"0\"},\"json\":{\"infoBufferSize\":\"0\"}}},\"enableSystemLogQuery\":true,\"shutdownGracePeriod\":\"0s\",\"shutdownGracePeriodCriticalPods\":\"0s\",\"registerWithTaints\":[{\"key\":\"os\",\"value\":\"Windows\",\"effect\":\"NoSchedule\"}],\"registerNode\":true,\"containerRuntimeEndpoint\":\"npipe://./pipe/containerd-containerd\",\"enforceNodeAllocatable\":[]}",
 			expectedErr:  false,
 		},
 		{
 			name:         "empty cidr",
-			cidr:         "",
+			cidrs:        []string{""},
+			settings:     &settings.Settings{},
 			expectedSpec: "",
 			expectedErr:  true,
 		},
 		{
 			name:         "invalid cidr",
-			cidr:         "172.30.0.0",
+			cidrs:        []string{"172.30.0.0"},
+			settings:     &settings.Settings{},
+			expectedSpec: "",
+			expectedErr:  true,
+		},
+		{
+			name:         "invalid secondary cidr",
+			cidrs:        []string{"10.0.128.8/24", "fd02::"},
+			settings:     &settings.Settings{},
 			expectedSpec: "",
 			expectedErr:  true,
 		},
@@ -103,7 +172,7 @@ func TestCreateKubeletConf(t *testing.T) {
 
 	for _, test := range testCases {
 		t.Run(test.name, func(t *testing.T) {
-			actualSpec, err := createKubeletConf(test.cidr)
+			actualSpec, err := createKubeletConf(test.cidrs, test.settings, "", true)
 			if test.expectedErr {
 				assert.Error(t, err)
 				return
@@ -114,37 +183,1814 @@ func TestCreateKubeletConf(t *testing.T) {
 	}
 }
 
-func TestModifyCredentialProviderConfig(t *testing.T) {
-	input := config.CredentialProviderConfig{
-		Providers: []config.CredentialProvider{
-			{
-				Name:        "quay-provider",
-				MatchImages: []string{"*.quay.io"},
-			},
-			{
-				Name:        "other-provider.exe",
-				MatchImages: []string{"*.other.io"},
-			},
+func TestGenerateKubeletConfigurationPodPidsLimit(t *testing.T) {
+	limit := int64(4096)
+	testCases := []struct {
+		name      string
+		supported bool
+		settings  *settings.Settings
+		expected  *int64
+	}{
+		{
+			name:      "limit given and supported",
+			supported: true,
+			settings:  &settings.Settings{KubeletPodPidsLimit: limit},
+			expected:  &limit,
+		},
+		{
+			name:      "limit given but not supported",
+			supported: false,
+			settings:  &settings.Settings{KubeletPodPidsLimit: limit},
+			expected:  nil,
+		},
+		{
+			name:      "limit not given",
+			supported: true,
+			settings:  &settings.Settings{},
+			expected:  nil,
 		},
 	}
-	expected := config.CredentialProviderConfig{
-		Providers: []config.CredentialProvider{
-			{
-				Name:        "quay-provider.exe",
-				MatchImages: []string{"*.quay.io"},
-			},
-			{
-				Name:        "other-provider.exe",
-				MatchImages: []string{"*.other.io"},
-			},
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			defer func(original bool) { kubeletSupportsPodPidsLimit = original }(kubeletSupportsPodPidsLimit)
+			kubeletSupportsPodPidsLimit = test.supported
+			kubeletConfig := generateKubeletConfiguration("10.0.128.10", test.settings, "", true)
+			assert.Equal(t, test.expected, kubeletConfig.PodPidsLimit)
+		})
+	}
+}
+
+func TestGenerateKubeletConfigurationShutdownGracePeriod(t *testing.T) {
+	testCases := []struct {
+		name             string
+		supported        bool
+		settings         *settings.Settings
+		expectedPeriod   time.Duration
+		expectedCritical time.Duration
+	}{
+		{
+			name:      "grace periods given and supported",
+			supported: true,
+			settings: &settings.Settings{KubeletShutdownGracePeriod: time.Minute,
+				KubeletShutdownGracePeriodCriticalPods: 20 * time.Second},
+			expectedPeriod:   time.Minute,
+			expectedCritical: 20 * time.Second,
+		},
+		{
+			name:      "grace periods given but not supported",
+			supported: false,
+			settings: &settings.Settings{KubeletShutdownGracePeriod: time.Minute,
+				KubeletShutdownGracePeriodCriticalPods: 20 * time.Second},
+		},
+		{
+			name:      "grace period not given",
+			supported: true,
+			settings:  &settings.Settings{},
 		},
 	}
-	inputBytes, err := yaml.Marshal(input)
-	require.NoError(t, err)
-	outputBytes, err := modifyCredentialProviderConfig(inputBytes)
-	require.NoError(t, err)
-	output := config.CredentialProviderConfig{}
-	err = yaml.Unmarshal(outputBytes, &output)
-	require.NoError(t, err)
-	assert.Equal(t, expected, output)
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			defer func(original bool) { kubeletSupportsGracefulNodeShutdown = original }(
+				kubeletSupportsGracefulNodeShutdown)
+			kubeletSupportsGracefulNodeShutdown = test.supported
+			kubeletConfig := generateKubeletConfiguration("10.0.128.10", test.settings, "", true)
+			assert.Equal(t, test.expectedPeriod, kubeletConfig.ShutdownGracePeriod.Duration)
+			assert.Equal(t, test.expectedCritical, kubeletConfig.ShutdownGracePeriodCriticalPods.Duration)
+			_, gateSet := kubeletConfig.FeatureGates["WindowsGracefulNodeShutdown"]
+			assert.Equal(t, test.expectedPeriod > 0, gateSet)
+		})
+	}
+}
+
+func TestGenerateKubeletConfigurationMaxParallelImagePulls(t *testing.T) {
+	testCases := []struct {
+		name     string
+		settings *settings.Settings
+		expected int32
+	}{
+		{
+			name:     "default maximum",
+			settings: &settings.Settings{},
+			expected: settings.DefaultKubeletMaxParallelImagePulls,
+		},
+		{
+			name:     "maximum given",
+			settings: &settings.Settings{KubeletMaxParallelImagePulls: 2},
+			expected: 2,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			kubeletConfig := generateKubeletConfiguration("10.0.128.10", test.settings, "", true)
+			require.NotNil(t, kubeletConfig.MaxParallelImagePulls)
+			assert.Equal(t, test.expected, *kubeletConfig.MaxParallelImagePulls)
+		})
+	}
+}
+
+func TestCreateKubeletConfEventRateLimits(t *testing.T) {
+	hardenedQPS := hardenedEventRecordQPS
+	testCases := []struct {
+		name          string
+		settings      *settings.Settings
+		expectedQPS   *int32
+		expectedBurst *int32
+	}{
+		{
+			name:          "kubelet defaults",
+			settings:      &settings.Settings{},
+			expectedQPS:   nil,
+			expectedBurst: nil,
+		},
+		{
+			name:          "limits given",
+			settings:      &settings.Settings{KubeletEventRecordQPS: ptr.To(int32(20)), KubeletEventBurst: 40},
+			expectedQPS:   ptr.To(int32(20)),
+			expectedBurst: ptr.To(int32(40)),
+		},
+		{
+			name:          "unlimited event rate",
+			settings:      &settings.Settings{KubeletEventRecordQPS: ptr.To(int32(0))},
+			expectedQPS:   ptr.To(int32(0)),
+			expectedBurst: nil,
+		},
+		{
+			name:          "hardened event rate",
+			settings:      &settings.Settings{KubeletHardening: true},
+			expectedQPS:   &hardenedQPS,
+			expectedBurst: nil,
+		},
+		{
+			name:          "event rate given with hardening",
+			settings:      &settings.Settings{KubeletHardening: true, KubeletEventRecordQPS: ptr.To(int32(10))},
+			expectedQPS:   ptr.To(int32(10)),
+			expectedBurst: nil,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			spec, err := createKubeletConf([]string{"172.30.0.0/16"}, test.settings, "", true)
+			require.NoError(t, err)
+			var limits struct {
+				EventRecordQPS *int32 `json:"eventRecordQPS"`
+				EventBurst     *int32 `json:"eventBurst"`
+			}
+			require.NoError(t, yaml.Unmarshal([]byte(spec), &limits))
+			assert.Equal(t, test.expectedQPS, limits.EventRecordQPS)
+			assert.Equal(t, test.expectedBurst, limits.EventBurst)
+		})
+	}
+}
+
+func TestCreateKubeletConfSyncFrequency(t *testing.T) {
+	testCases := []struct {
+		name     string
+		settings *settings.Settings
+		expected string
+	}{
+		{
+			name:     "kubelet default",
+			settings: &settings.Settings{},
+			expected: "0s",
+		},
+		{
+			name:     "sync frequency given",
+			settings: &settings.Settings{KubeletSyncFrequency: 2 * time.Minute},
+			expected: "2m0s",
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			spec, err := createKubeletConf([]string{"172.30.0.0/16"}, test.settings, "", true)
+			require.NoError(t, err)
+			var config struct {
+				SyncFrequency string `json:"syncFrequency"`
+			}
+			require.NoError(t, yaml.Unmarshal([]byte(spec), &config))
+			assert.Equal(t, test.expected, config.SyncFrequency)
+		})
+	}
+}
+
+func TestGenerateKubeletConfigurationSerializeImagePulls(t *testing.T) {
+	defaultParallelPulls := settings.DefaultKubeletMaxParallelImagePulls
+	testCases := []struct {
+		name                  string
+		settings              *settings.Settings
+		expectedSerialize     bool
+		expectedParallelPulls *int32
+	}{
+		{
+			name:                  "parallel pulls by default",
+			settings:              &settings.Settings{},
+			expectedSerialize:     false,
+			expectedParallelPulls: &defaultParallelPulls,
+		},
+		{
+			name:                  "serialized pulls",
+			settings:              &settings.Settings{KubeletSerializeImagePulls: true},
+			expectedSerialize:     true,
+			expectedParallelPulls: nil,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			kubeletConfig := generateKubeletConfiguration("10.0.128.10", test.settings, "", true)
+			require.NotNil(t, kubeletConfig.SerializeImagePulls)
+			assert.Equal(t, test.expectedSerialize, *kubeletConfig.SerializeImagePulls)
+			assert.Equal(t, test.expectedParallelPulls, kubeletConfig.MaxParallelImagePulls)
+		})
+	}
+}
+
+func TestGenerateKubeletConfigurationStaticPodPath(t *testing.T) {
+	kubeletConfig := generateKubeletConfiguration("10.0.128.10", &settings.Settings{}, "", true)
+	assert.Empty(t, kubeletConfig.StaticPodPath)
+
+	kubeletConfig = generateKubeletConfiguration("10.0.128.10",
+		&settings.Settings{KubeletStaticPodPath: "D:\\monitoring\\manifests"}, "", true)
+	assert.Equal(t, "D:\\monitoring\\manifests", kubeletConfig.StaticPodPath)
+}
+
+func TestKubeletFeatureGates(t *testing.T) {
+	testCases := []struct {
+		name     string
+		gates    map[string]bool
+		expected map[string]bool
+	}{
+		{
+			name:     "no gates given",
+			expected: map[string]bool{"RotateKubeletServerCertificate": true},
+		},
+		{
+			name:  "gates merged",
+			gates: map[string]bool{"KubeletTracing": true, "SidecarContainers": false},
+			expected: map[string]bool{"RotateKubeletServerCertificate": true, "KubeletTracing": true,
+				"SidecarContainers": false},
+		},
+		{
+			name:     "mandatory gate cannot be disabled",
+			gates:    map[string]bool{"RotateKubeletServerCertificate": false},
+			expected: map[string]bool{"RotateKubeletServerCertificate": true},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			kubeletConfig := generateKubeletConfiguration("10.0.128.10",
+				&settings.Settings{KubeletFeatureGates: test.gates}, "", true)
+			assert.Equal(t, test.expected, kubeletConfig.FeatureGates)
+		})
+	}
+}
+
+func TestValidateStaticPodPath(t *testing.T) {
+	testCases := []struct {
+		name        string
+		path        string
+		expectedErr bool
+	}{
+		{
+			name: "default path",
+			path: "",
+		},
+		{
+			name: "path outside of WMCO directories",
+			path: "D:\\monitoring\\manifests",
+		},
+		{
+			name: "path sharing a prefix with a WMCO directory",
+			path: "C:\\k8s-manifests",
+		},
+		{
+			name:        "WMCO directory",
+			path:        "C:\\Temp",
+			expectedErr: true,
+		},
+		{
+			name:        "path within a WMCO directory",
+			path:        "c:\\K\\manifests",
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateStaticPodPath(test.path)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestGenerateKubeletConfigurationMaxPods(t *testing.T) {
+	testCases := []struct {
+		name     string
+		settings *settings.Settings
+		platform configv1.PlatformType
+		expected int32
+	}{
+		{
+			name:     "AWS default",
+			settings: &settings.Settings{},
+			platform: configv1.AWSPlatformType,
+			expected: 110,
+		},
+		{
+			name:     "Azure default",
+			settings: &settings.Settings{},
+			platform: configv1.AzurePlatformType,
+			expected: 110,
+		},
+		{
+			name:     "GCP default",
+			settings: &settings.Settings{},
+			platform: configv1.GCPPlatformType,
+			expected: 250,
+		},
+		{
+			name:     "vSphere default",
+			settings: &settings.Settings{},
+			platform: configv1.VSpherePlatformType,
+			expected: 250,
+		},
+		{
+			name:     "Nutanix default",
+			settings: &settings.Settings{},
+			platform: configv1.NutanixPlatformType,
+			expected: 250,
+		},
+		{
+			name:     "platform none default",
+			settings: &settings.Settings{},
+			platform: configv1.NonePlatformType,
+			expected: 250,
+		},
+		{
+			name:     "maximum given overrides platform default",
+			settings: &settings.Settings{KubeletMaxPods: 200},
+			platform: configv1.AWSPlatformType,
+			expected: 200,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			kubeletConfig := generateKubeletConfiguration("10.0.128.10", test.settings, test.platform, true)
+			assert.Equal(t, test.expected, kubeletConfig.MaxPods)
+		})
+	}
+}
+
+func TestGenerateKubeletConfigurationCgroupsPerQOS(t *testing.T) {
+	testCases := []struct {
+		name     string
+		settings *settings.Settings
+		build    int
+		expected bool
+	}{
+		{
+			name:     "not enabled",
+			settings: &settings.Settings{},
+			build:    cgroupsPerQOSMinBuild,
+			expected: false,
+		},
+		{
+			name:     "enabled on a supported build",
+			settings: &settings.Settings{KubeletCgroupsPerQOS: true},
+			build:    cgroupsPerQOSMinBuild,
+			expected: true,
+		},
+		{
+			name:     "enabled on an unsupported build",
+			settings: &settings.Settings{KubeletCgroupsPerQOS: true},
+			build:    20348,
+			expected: false,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			original := *test.settings
+			s := kubeletSettingsForBuild(test.settings, test.build)
+			kubeletConfig := generateKubeletConfiguration("10.0.128.10", s, "", true)
+			require.NotNil(t, kubeletConfig.CgroupsPerQOS)
+			assert.Equal(t, test.expected, *kubeletConfig.CgroupsPerQOS)
+			// the node's settings must be left unchanged
+			assert.Equal(t, original, *test.settings)
+		})
+	}
+}
+
+func TestGenerateKubeletConfigurationEvictionMinimumReclaim(t *testing.T) {
+	testCases := []struct {
+		name     string
+		settings *settings.Settings
+		expected map[string]string
+	}{
+		{
+			name:     "defaults",
+			settings: &settings.Settings{},
+			expected: map[string]string{"memory.available": "100Mi", "nodefs.available": "500Mi",
+				"imagefs.available": "2Gi"},
+		},
+		{
+			name: "given signals override defaults",
+			settings: &settings.Settings{KubeletEvictionMinimumReclaim: map[string]string{
+				"memory.available": "5%", "imagefs.available": "1Gi"}},
+			expected: map[string]string{"memory.available": "5%", "nodefs.available": "500Mi",
+				"imagefs.available": "1Gi"},
+		},
+		{
+			name: "only Windows signals are emitted",
+			settings: &settings.Settings{KubeletEvictionMinimumReclaim: map[string]string{
+				"nodefs.available": "1Gi", "nodefs.inodesFree": "5%", "pid.available": "10%"}},
+			expected: map[string]string{"memory.available": "100Mi", "nodefs.available": "1Gi",
+				"imagefs.available": "2Gi"},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			kubeletConfig := generateKubeletConfiguration("10.0.128.10", test.settings, "", true)
+			assert.Equal(t, test.expected, kubeletConfig.EvictionMinimumReclaim)
+			for signal := range kubeletConfig.EvictionMinimumReclaim {
+				assert.Contains(t, settings.KubeletEvictionSignals, signal)
+			}
+		})
+	}
+}
+
+func TestGenerateKubeletConfigurationKubeReserved(t *testing.T) {
+	testCases := []struct {
+		name     string
+		settings *settings.Settings
+		expected map[string]string
+	}{
+		{
+			name:     "defaults",
+			settings: &settings.Settings{},
+			expected: map[string]string{"cpu": "200m", "memory": "400Mi", "ephemeral-storage": "500Mi"},
+		},
+		{
+			name:     "given resources override defaults",
+			settings: &settings.Settings{KubeletKubeReserved: map[string]string{"memory": "1Gi", "cpu": "0"}},
+			expected: map[string]string{"cpu": "0", "memory": "1Gi", "ephemeral-storage": "500Mi"},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			kubeletConfig := generateKubeletConfiguration("10.0.128.10", test.settings, "", true)
+			assert.Equal(t, test.expected, kubeletConfig.KubeReserved)
+			assert.Equal(t, settings.DefaultKubeletSystemReserved, kubeletConfig.SystemReserved)
+		})
+	}
+}
+
+func TestGenerateKubeletConfigurationRegisterNode(t *testing.T) {
+	testCases := []struct {
+		name           string
+		registerNode   bool
+		expectedTaints []core.Taint
+	}{
+		{
+			name:           "kubelet registers the node",
+			registerNode:   true,
+			expectedTaints: []core.Taint{{Key: "os", Value: "Windows", Effect: core.TaintEffectNoSchedule}},
+		},
+		{
+			name:           "node registered externally",
+			registerNode:   false,
+			expectedTaints: nil,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			kubeletConfig := generateKubeletConfiguration("10.0.128.10", &settings.Settings{}, "",
+				test.registerNode)
+			require.NotNil(t, kubeletConfig.RegisterNode)
+			assert.Equal(t, test.registerNode, *kubeletConfig.RegisterNode)
+			assert.Equal(t, test.expectedTaints, kubeletConfig.RegisterWithTaints)
+			assert.NoError(t, validateKubeletRegistration(kubeletConfig))
+		})
+	}
+}
+
+func TestConfigurationProgress(t *testing.T) {
+	progress := newConfigurationProgress()
+	cleanups := 0
+	assert.Equal(t, phaseNotStarted, progress.phase)
+	require.NoError(t, progress.enter(phaseBootstrapping))
+	// nothing to undo yet
+	progress.cleanUp()
+	progress.setCleanup(func() { cleanups++ })
+	require.NoError(t, progress.enter(phaseWaitingForWICD))
+
+	assert.Equal(t, phaseWaitingForWICD, progress.abort())
+	assert.ErrorIs(t, progress.enter(phaseValidatingNode), errConfigurationAborted)
+	assert.Equal(t, phaseWaitingForWICD, progress.abort())
+	progress.cleanUp()
+	// the configuration returning after being aborted must not undo a later configuration
+	progress.cleanUp()
+	assert.Equal(t, 1, cleanups)
+}
+
+func TestValidateNodeHostname(t *testing.T) {
+	testCases := []struct {
+		name          string
+		hostnameLabel string
+		hostname      string
+		expectedErr   bool
+	}{
+		{
+			name:          "hostname not set by WMCO",
+			hostnameLabel: "ip-10-0-1-2.ec2.internal",
+			hostname:      "",
+		},
+		{
+			name:          "matching hostname",
+			hostnameLabel: "winhost-abcde",
+			hostname:      "winhost-abcde",
+		},
+		{
+			name:          "registered with the FQDN",
+			hostnameLabel: "winhost-abcde.example.com",
+			hostname:      "WINHOST-ABCDE",
+		},
+		{
+			name:          "registered with the previous hostname",
+			hostnameLabel: "win-1a2b3c4d5e",
+			hostname:      "winhost-abcde",
+			expectedErr:   true,
+		},
+		{
+			name:          "hostname is a prefix of the registered hostname",
+			hostnameLabel: "winhost-abcdef",
+			hostname:      "winhost-abcde",
+			expectedErr:   true,
+		},
+		{
+			name:          "no hostname label",
+			hostnameLabel: "",
+			hostname:      "winhost-abcde",
+			expectedErr:   true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			node := &core.Node{ObjectMeta: meta.ObjectMeta{Name: "node"}}
+			if test.hostnameLabel != "" {
+				node.Labels = map[string]string{core.LabelHostname: test.hostnameLabel}
+			}
+			err := validateNodeHostname(node, test.hostname)
+			if !test.expectedErr {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), fmt.Sprintf("%q instead of the expected %q", test.hostnameLabel,
+				test.hostname))
+		})
+	}
+}
+
+func TestValidateKubeletRegistration(t *testing.T) {
+	trueBool := true
+	falseBool := false
+	testCases := []struct {
+		name         string
+		registerNode *bool
+		taints       []core.Taint
+		expectedErr  bool
+	}{
+		{
+			name:         "registered with the Windows taint",
+			registerNode: &trueBool,
+			taints: []core.Taint{{Key: "foo", Effect: core.TaintEffectNoExecute},
+				{Key: "os", Value: "Windows", Effect: core.TaintEffectNoSchedule}},
+			expectedErr: false,
+		},
+		{
+			name:         "registered by default without the Windows taint",
+			registerNode: nil,
+			taints:       nil,
+			expectedErr:  true,
+		},
+		{
+			name:         "registered with a different taint value",
+			registerNode: &trueBool,
+			taints:       []core.Taint{{Key: "os", Value: "Linux", Effect: core.TaintEffectNoSchedule}},
+			expectedErr:  true,
+		},
+		{
+			name:         "not registered",
+			registerNode: &falseBool,
+			taints:       nil,
+			expectedErr:  false,
+		},
+		{
+			name:         "taints on register without registering",
+			registerNode: &falseBool,
+			taints:       []core.Taint{{Key: "os", Value: "Windows", Effect: core.TaintEffectNoSchedule}},
+			expectedErr:  true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateKubeletRegistration(kubeletconfig.KubeletConfiguration{RegisterNode: test.registerNode,
+				RegisterWithTaints: test.taints})
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestKubeletFlagDrift(t *testing.T) {
+	expected := servicescm.Service{
+		Command: "C:\\k\\kube-log-runner.exe -log-file=C:\\var\\log\\kubelet.log C:\\k\\kubelet.exe " +
+			"--config=C:\\k\\kubelet.conf --windows-service --hostname-override=HOSTNAME_OVERRIDE --node-ip=NODE_IP",
+		PowershellPreScripts: []servicescm.PowershellPreScript{
+			{VariableName: "HOSTNAME_OVERRIDE", Path: "C:\\k\\hostname.ps1"},
+			{VariableName: "NODE_IP", Path: "C:\\k\\ip.ps1"},
+		},
+	}
+	testCases := []struct {
+		name      string
+		effective map[string]string
+		expected  map[string]string
+	}{
+		{
+			name: "no drift",
+			effective: map[string]string{"config": "C:\\k\\kubelet.conf", "windows-service": "true",
+				"hostname-override": "win-1", "node-ip": "10.0.0.4"},
+			expected: map[string]string{},
+		},
+		{
+			name:      "changed and missing flags",
+			effective: map[string]string{"config": "C:\\k\\other.conf", "node-ip": "10.0.0.4"},
+			expected:  map[string]string{"config": "C:\\k\\kubelet.conf", "windows-service": "true"},
+		},
+		{
+			name: "flags resolved on the instance are not compared",
+			effective: map[string]string{"config": "C:\\k\\kubelet.conf", "windows-service": "true",
+				"node-ip": "10.0.0.5"},
+			expected: map[string]string{},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, kubeletFlagDrift(expected, test.effective))
+		})
+	}
+}
+
+func TestServingCertAddresses(t *testing.T) {
+	testCases := []struct {
+		name      string
+		addresses []core.NodeAddress
+		expected  string
+	}{
+		{
+			name:      "no addresses",
+			addresses: nil,
+			expected:  "",
+		},
+		{
+			name: "addresses are sorted and deduplicated",
+			addresses: []core.NodeAddress{
+				{Type: core.NodeInternalIP, Address: "10.0.0.20"},
+				{Type: core.NodeHostName, Address: "winhost"},
+				{Type: core.NodeInternalDNS, Address: "winhost"},
+			},
+			expected: "10.0.0.20,winhost",
+		},
+		{
+			name: "changed IP address",
+			addresses: []core.NodeAddress{
+				{Type: core.NodeHostName, Address: "winhost"},
+				{Type: core.NodeInternalIP, Address: "10.0.0.30"},
+			},
+			expected: "10.0.0.30,winhost",
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			node := &core.Node{Status: core.NodeStatus{Addresses: test.addresses}}
+			assert.Equal(t, test.expected, ServingCertAddresses(node))
+		})
+	}
+}
+
+func TestHasWindowsTaint(t *testing.T) {
+	testCases := []struct {
+		name     string
+		taints   []core.Taint
+		expected bool
+	}{
+		{
+			name:     "no taints",
+			expected: false,
+		},
+		{
+			name: "Windows taint among user taints",
+			taints: []core.Taint{{Key: "dedicated", Value: "gpu", Effect: core.TaintEffectNoSchedule},
+				{Key: "os", Value: "Windows", Effect: core.TaintEffectNoSchedule}},
+			expected: true,
+		},
+		{
+			name:     "only user taints",
+			taints:   []core.Taint{{Key: "dedicated", Value: "gpu", Effect: core.TaintEffectNoExecute}},
+			expected: false,
+		},
+		{
+			name:     "different value",
+			taints:   []core.Taint{{Key: "os", Value: "Linux", Effect: core.TaintEffectNoSchedule}},
+			expected: false,
+		},
+		{
+			name:     "different effect",
+			taints:   []core.Taint{{Key: "os", Value: "Windows", Effect: core.TaintEffectPreferNoSchedule}},
+			expected: false,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			node := &core.Node{Spec: core.NodeSpec{Taints: test.taints}}
+			assert.Equal(t, test.expected, HasWindowsTaint(node))
+		})
+	}
+}
+
+func TestSetNonInteractiveDesktopHeap(t *testing.T) {
+	testCases := []struct {
+		name        string
+		subsystem   string
+		sizeKB      int
+		expected    string
+		expectedErr bool
+	}{
+		{
+			name: "default heap sizes",
+			subsystem: "%SystemRoot%\\system32\\csrss.exe ObjectDirectory=\\Windows SharedSection=1024,20480,768 " +
+				"Windows=On SubSystemType=Windows",
+			sizeKB: 4096,
+			expected: "%SystemRoot%\\system32\\csrss.exe ObjectDirectory=\\Windows SharedSection=1024,20480,4096 " +
+				"Windows=On SubSystemType=Windows",
+		},
+		{
+			name:      "non-interactive heap size not given",
+			subsystem: "csrss.exe SharedSection=1024,20480 Windows=On",
+			sizeKB:    2048,
+			expected:  "csrss.exe SharedSection=1024,20480,2048 Windows=On",
+		},
+		{
+			name:      "size already set",
+			subsystem: "csrss.exe SharedSection=1024,20480,2048",
+			sizeKB:    2048,
+			expected:  "csrss.exe SharedSection=1024,20480,2048",
+		},
+		{
+			name:        "no SharedSection parameter",
+			subsystem:   "csrss.exe Windows=On",
+			sizeKB:      2048,
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := setNonInteractiveDesktopHeap(test.subsystem, test.sizeKB)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, out)
+		})
+	}
+}
+
+// fakeRegistry is a registry holding values keyed by registry key and value name
+type fakeRegistry map[string]string
+
+func (f fakeRegistry) GetRegistryValue(key, name string) (string, error) {
+	value, ok := f[key+"\\"+name]
+	if !ok {
+		return "", fmt.Errorf("registry value %s not found under %s", name, key)
+	}
+	return value, nil
+}
+
+func (f fakeRegistry) EnsureRegistryValue(key, name, value string) (bool, error) {
+	if f[key+"\\"+name] == value {
+		return false, nil
+	}
+	f[key+"\\"+name] = value
+	return true, nil
+}
+
+func (f fakeRegistry) GetPendingFileRenameOperations() ([]string, error) {
+	return nil, nil
+}
+
+func TestEnsureNonInteractiveDesktopHeap(t *testing.T) {
+	reg := fakeRegistry{subsystemsKey + "\\" + windowsSubsystemValue: "csrss.exe SharedSection=1024,20480,768"}
+	changed, err := ensureNonInteractiveDesktopHeap(reg, 4096)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "csrss.exe SharedSection=1024,20480,4096", reg[subsystemsKey+"\\"+windowsSubsystemValue])
+
+	changed, err = ensureNonInteractiveDesktopHeap(reg, 4096)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	_, err = ensureNonInteractiveDesktopHeap(fakeRegistry{}, 4096)
+	assert.Error(t, err)
+}
+
+func TestModifyCredentialProviderConfig(t *testing.T) {
+	input := config.CredentialProviderConfig{
+		Providers: []config.CredentialProvider{
+			{
+				Name:        "quay-provider",
+				MatchImages: []string{"*.quay.io"},
+			},
+			{
+				Name:        "other-provider.exe",
+				MatchImages: []string{"*.other.io"},
+			},
+		},
+	}
+	expected := config.CredentialProviderConfig{
+		Providers: []config.CredentialProvider{
+			{
+				Name:        "quay-provider.exe",
+				MatchImages: []string{"*.quay.io"},
+			},
+			{
+				Name:        "other-provider.exe",
+				MatchImages: []string{"*.other.io"},
+			},
+		},
+	}
+	inputBytes, err := yaml.Marshal(input)
+	require.NoError(t, err)
+	outputBytes, err := modifyCredentialProviderConfig(inputBytes)
+	require.NoError(t, err)
+	output := config.CredentialProviderConfig{}
+	err = yaml.Unmarshal(outputBytes, &output)
+	require.NoError(t, err)
+	assert.Equal(t, expected, output)
+}
+
+func TestCredentialProviderConfig(t *testing.T) {
+	ecr := config.CredentialProvider{
+		Name:        "ecr-credential-provider.exe",
+		MatchImages: []string{"*.dkr.ecr.*.amazonaws.com"},
+		APIVersion:  "credentialprovider.kubelet.k8s.io/v1",
+	}
+	platformConf, err := yaml.Marshal(config.CredentialProviderConfig{
+		TypeMeta:  meta.TypeMeta{Kind: "CredentialProviderConfig", APIVersion: "kubelet.config.k8s.io/v1"},
+		Providers: []config.CredentialProvider{ecr},
+	})
+	require.NoError(t, err)
+	acr := config.CredentialProvider{
+		Name:        "acr-credential-provider",
+		MatchImages: []string{"*.azurecr.io"},
+		APIVersion:  "credentialprovider.kubelet.k8s.io/v1",
+	}
+	acrWindows := acr
+	acrWindows.Name += ".exe"
+	ecrOverride := ecr
+	ecrOverride.Name = "ecr-credential-provider"
+	ecrOverride.Args = []string{"--verbose"}
+	ecrOverrideWindows := ecrOverride
+	ecrOverrideWindows.Name += ".exe"
+
+	testCases := []struct {
+		name             string
+		platformConf     string
+		providers        []config.CredentialProvider
+		expected         []config.CredentialProvider
+		expectedBinaries []string
+	}{
+		{
+			name: "no providers",
+		},
+		{
+			name:         "platform providers only",
+			platformConf: string(platformConf),
+			expected:     []config.CredentialProvider{ecr},
+		},
+		{
+			name:             "given providers only",
+			providers:        []config.CredentialProvider{acr},
+			expected:         []config.CredentialProvider{acrWindows},
+			expectedBinaries: []string{"acr-credential-provider.exe"},
+		},
+		{
+			name:             "platform and given providers",
+			platformConf:     string(platformConf),
+			providers:        []config.CredentialProvider{acr},
+			expected:         []config.CredentialProvider{ecr, acrWindows},
+			expectedBinaries: []string{"acr-credential-provider.exe"},
+		},
+		{
+			name:         "given provider replacing a platform provider",
+			platformConf: string(platformConf),
+			providers:    []config.CredentialProvider{ecrOverride},
+			expected:     []config.CredentialProvider{ecrOverrideWindows},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			out, binaries, err := credentialProviderConfig(test.platformConf, test.providers)
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedBinaries, binaries)
+			if test.expected == nil {
+				assert.Empty(t, out)
+				return
+			}
+			conf := config.CredentialProviderConfig{}
+			require.NoError(t, yaml.UnmarshalStrict([]byte(out), &conf))
+			assert.Equal(t, "CredentialProviderConfig", conf.Kind)
+			assert.Equal(t, "kubelet.config.k8s.io/v1", conf.APIVersion)
+			assert.Equal(t, test.expected, conf.Providers)
+		})
+	}
+}
+
+func TestMergeContainerdOptions(t *testing.T) {
+	// the containerd config shipped with WMCO
+	shipped, err := os.ReadFile("../internal/containerd_conf.toml")
+	require.NoError(t, err)
+	conf := "version = 2\n\n" +
+		"[plugins]\n\n" +
+		"  [plugins.\"io.containerd.grpc.v1.cri\"]\n" +
+		"    device_ownership_from_security_context = false\n" +
+		"    max_concurrent_downloads = 3\n\n" +
+		"    [plugins.\"io.containerd.grpc.v1.cri\".cni]\n" +
+		"      max_conf_num = 1\n"
+
+	testCases := []struct {
+		name        string
+		conf        string
+		topLevel    bool
+		options     map[string]string
+		expected    string
+		expectedErr bool
+	}{
+		{
+			name:     "no options",
+			conf:     conf,
+			options:  nil,
+			expected: conf,
+		},
+		{
+			name: "options merged over defaults",
+			conf: conf,
+			options: map[string]string{"device_ownership_from_security_context": "true",
+				"max_concurrent_downloads": "5"},
+			expected: "version = 2\n\n" +
+				"[plugins]\n\n" +
+				"  [plugins.\"io.containerd.grpc.v1.cri\"]\n" +
+				"    device_ownership_from_security_context = true\n" +
+				"    max_concurrent_downloads = 5\n\n" +
+				"    [plugins.\"io.containerd.grpc.v1.cri\".cni]\n" +
+				"      max_conf_num = 1\n",
+		},
+		{
+			name:     "top-level options",
+			conf:     conf,
+			topLevel: true,
+			options:  map[string]string{"version": "3"},
+			expected: strings.Replace(conf, "version = 2", "version = 3", 1),
+		},
+		{
+			name:        "table option given as top-level option",
+			conf:        conf,
+			topLevel:    true,
+			options:     map[string]string{"max_concurrent_downloads": "5"},
+			expectedErr: true,
+		},
+		{
+			name:        "option of a nested table",
+			conf:        conf,
+			options:     map[string]string{"max_conf_num": "2"},
+			expectedErr: true,
+		},
+		{
+			name:        "option missing from config",
+			conf:        conf,
+			options:     map[string]string{"stream_idle_timeout": "\"1h0m0s\""},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			table := containerdCRIPluginTable
+			if test.topLevel {
+				table = ""
+			}
+			out, err := mergeContainerdOptions(test.conf, table, test.options)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, out)
+		})
+	}
+
+	t.Run("all allowed options are in the shipped config", func(t *testing.T) {
+		s, err := settings.Parse(map[string]string{"containerdCRIOptions": "ignore_image_defined_volumes=true," +
+			"device_ownership_from_security_context=true,max_concurrent_downloads=5,stats_collect_period=5," +
+			"max_container_log_line_size=32768,image_pull_progress_timeout=1h,stream_idle_timeout=1h," +
+			"drain_exec_sync_io_timeout=1s"})
+		require.NoError(t, err)
+		out, err := mergeContainerdOptions(string(shipped), containerdCRIPluginTable, s.ContainerdCRIOptions)
+		require.NoError(t, err)
+		assert.Contains(t, out, "    device_ownership_from_security_context = true\n")
+		assert.Contains(t, out, "    image_pull_progress_timeout = \"1h0m0s\"\n")
+		assert.Equal(t, strings.Count(string(shipped), "\n"), strings.Count(out, "\n"))
+	})
+}
+
+func TestGenerateContainerdConf(t *testing.T) {
+	// the containerd config shipped with WMCO
+	shipped, err := os.ReadFile("../internal/containerd_conf.toml")
+	require.NoError(t, err)
+	discardLine := "      discard_unpacked_layers = "
+
+	testCases := []struct {
+		name            string
+		settings        *settings.Settings
+		expectedDiscard string
+	}{
+		{
+			name:            "unpacked layers kept by default",
+			settings:        &settings.Settings{},
+			expectedDiscard: "false",
+		},
+		{
+			name:            "unpacked layers discarded",
+			settings:        &settings.Settings{ContainerdDiscardUnpackedLayers: true},
+			expectedDiscard: "true",
+		},
+		{
+			name: "unpacked layers discarded alongside CRI options",
+			settings: &settings.Settings{ContainerdDiscardUnpackedLayers: true,
+				ContainerdCRIOptions: map[string]string{"max_concurrent_downloads": "5"}},
+			expectedDiscard: "true",
+		},
+		{
+			name: "custom root and state directories",
+			settings: &settings.Settings{ContainerdRootDir: "D:\\containerd\\root",
+				ContainerdStateDir: "D:\\containerd\\state"},
+			expectedDiscard: "false",
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := generateContainerdConf(string(shipped), test.settings)
+			require.NoError(t, err)
+			assert.Contains(t, out, "    [plugins.\"io.containerd.grpc.v1.cri\".containerd]\n"+
+				"      default_runtime_name = \"runhcs-wcow-process\"\n"+
+				"      disable_snapshot_annotations = false\n"+
+				discardLine+test.expectedDiscard+"\n")
+			assert.Equal(t, 1, strings.Count(out, "discard_unpacked_layers"))
+			if value, ok := test.settings.ContainerdCRIOptions["max_concurrent_downloads"]; ok {
+				assert.Contains(t, out, "    max_concurrent_downloads = "+value+"\n")
+			}
+			if test.settings.ContainerdRootDir != "" {
+				assert.Contains(t, out, "\nroot = \"D:\\\\containerd\\\\root\"\n")
+				assert.Contains(t, out, "\nstate = \"D:\\\\containerd\\\\state\"\n")
+			} else {
+				assert.Contains(t, out, "\nroot = \"C:\\\\ProgramData\\\\containerd\\\\root\"\n")
+			}
+			if !test.settings.ContainerdDiscardUnpackedLayers && len(test.settings.ContainerdCRIOptions) == 0 &&
+				test.settings.ContainerdRootDir == "" {
+				assert.Equal(t, string(shipped), out)
+			}
+		})
+	}
+}
+
+func TestAddContainerdRuntimeHandlers(t *testing.T) {
+	conf := "[plugins]\n\n" +
+		"  [plugins.\"io.containerd.grpc.v1.cri\".containerd.runtimes]\n\n" +
+		"    [plugins.\"io.containerd.grpc.v1.cri\".containerd.runtimes.runhcs-wcow-process]\n" +
+		"      runtime_type = \"io.containerd.runhcs.v1\"\n\n" +
+		"      [plugins.\"io.containerd.grpc.v1.cri\".containerd.runtimes.runhcs-wcow-process.options]\n\n" +
+		"  [plugins.\"io.containerd.grpc.v1.cri\".containerd.untrusted_workload_runtime]\n"
+
+	testCases := []struct {
+		name        string
+		conf        string
+		handlers    map[string]string
+		expected    string
+		expectedErr bool
+	}{
+		{
+			name:     "no handlers",
+			conf:     conf,
+			handlers: nil,
+			expected: conf,
+		},
+		{
+			name: "handlers added after the default handler",
+			conf: conf,
+			handlers: map[string]string{"runhcs-wcow-hypervisor": settings.ContainerdIsolationHyperV,
+				"isolated": settings.ContainerdIsolationProcess},
+			expected: "[plugins]\n\n" +
+				"  [plugins.\"io.containerd.grpc.v1.cri\".containerd.runtimes]\n\n" +
+				"    [plugins.\"io.containerd.grpc.v1.cri\".containerd.runtimes.runhcs-wcow-process]\n" +
+				"      runtime_type = \"io.containerd.runhcs.v1\"\n\n" +
+				"      [plugins.\"io.containerd.grpc.v1.cri\".containerd.runtimes.runhcs-wcow-process.options]\n\n" +
+				"    [plugins.\"io.containerd.grpc.v1.cri\".containerd.runtimes.isolated]\n" +
+				"      runtime_type = \"io.containerd.runhcs.v1\"\n\n" +
+				"      [plugins.\"io.containerd.grpc.v1.cri\".containerd.runtimes.isolated.options]\n" +
+				"        SandboxIsolation = 0\n\n" +
+				"    [plugins.\"io.containerd.grpc.v1.cri\".containerd.runtimes.runhcs-wcow-hypervisor]\n" +
+				"      runtime_type = \"io.containerd.runhcs.v1\"\n\n" +
+				"      [plugins.\"io.containerd.grpc.v1.cri\".containerd.runtimes.runhcs-wcow-hypervisor.options]\n" +
+				"        SandboxIsolation = 1\n\n" +
+				"  [plugins.\"io.containerd.grpc.v1.cri\".containerd.untrusted_workload_runtime]\n",
+		},
+		{
+			name:        "default handler missing",
+			conf:        "[plugins]\n\n  [plugins.\"io.containerd.grpc.v1.cri\".containerd.runtimes]\n",
+			handlers:    nil,
+			expectedErr: true,
+		},
+		{
+			name:        "handler already present",
+			conf:        conf + "\n  [plugins.\"io.containerd.grpc.v1.cri\".containerd.runtimes.isolated]\n",
+			handlers:    map[string]string{"isolated": settings.ContainerdIsolationProcess},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := addContainerdRuntimeHandlers(test.conf, test.handlers)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, out)
+		})
+	}
+
+	t.Run("handlers added to the shipped config", func(t *testing.T) {
+		shipped, err := os.ReadFile("../internal/containerd_conf.toml")
+		require.NoError(t, err)
+		out, err := addContainerdRuntimeHandlers(string(shipped),
+			map[string]string{"runhcs-wcow-hypervisor": settings.ContainerdIsolationHyperV})
+		require.NoError(t, err)
+		assert.Contains(t, out, "          [plugins.\"io.containerd.grpc.v1.cri\".containerd.runtimes."+
+			"runhcs-wcow-hypervisor.options]\n            SandboxIsolation = 1\n\n"+
+			"      [plugins.\"io.containerd.grpc.v1.cri\".containerd.untrusted_workload_runtime]\n")
+	})
+}
+
+func TestCreateHNSEndpointPolicies(t *testing.T) {
+	testCases := []struct {
+		name     string
+		settings *settings.Settings
+		expected string
+	}{
+		{
+			name:     "no additional policies",
+			settings: &settings.Settings{},
+			expected: "{}",
+		},
+		{
+			name:     "outbound NAT exceptions",
+			settings: &settings.Settings{HNSOutboundNATExceptions: []string{"10.0.0.0/8", "192.168.0.0/16"}},
+			expected: `{"outboundNATExceptions":["10.0.0.0/8","192.168.0.0/16"]}`,
+		},
+		{
+			name: "ACL policies",
+			settings: &settings.Settings{HNSACLPolicies: []settings.HNSACLPolicy{
+				{Action: settings.HNSACLActionBlock, Direction: settings.HNSACLDirectionOut, Protocol: "6",
+					RemoteAddress: "169.254.169.254/32", RemotePort: 80, Priority: 200},
+				{Action: settings.HNSACLActionAllow, Direction: settings.HNSACLDirectionIn, Priority: 300},
+			}},
+			expected: `{"policies":[` +
+				`{"name":"EndpointPolicy","value":{"type":"ACL","settings":{"Action":"Block","Direction":"Out",` +
+				`"Protocols":"6","RemoteAddresses":"169.254.169.254/32","RemotePorts":"80","RuleType":"Switch",` +
+				`"Priority":200}}},` +
+				`{"name":"EndpointPolicy","value":{"type":"ACL","settings":{"Action":"Allow","Direction":"In",` +
+				`"RuleType":"Switch","Priority":300}}}]}`,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := createHNSEndpointPolicies(test.settings)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, out)
+		})
+	}
+}
+
+// testCNIConfig returns a CNI config as generated by the network configuration script for the given service network
+// CIDR, with the given additional outbound NAT exceptions and policies
+func testCNIConfig(serviceCIDR string, natExceptions []string, policies string) string {
+	exceptions := `"` + strings.Join(append([]string{serviceCIDR}, natExceptions...), `","`) + `"`
+	return `{
+    "cniVersion":"0.2.0",
+    "name":"OVNKubernetesHybridOverlayNetwork",
+    "type":"win-overlay",
+    "apiVersion": 2,
+    "ipam":{"type":"host-local","subnet":"10.132.1.0/24"},
+    "policies":[
+    {"name": "EndpointPolicy", "value": {"type": "OutBoundNAT", "settings": {"exceptionList": [` + exceptions +
+		`], "destinationPrefix": "", "needEncap": false}}},
+    {"name": "EndpointPolicy", "value": {"type": "SDNRoute", "settings": {"exceptionList": [],
+        "destinationPrefix": "` + serviceCIDR + `", "needEncap": true}}},
+    {"name": "EndpointPolicy", "value": {"type": "ProviderAddress", "settings": {"providerAddress": "10.0.0.5"}}}` +
+		policies + `
+    ]
+}`
+}
+
+func TestNormalizeTOML(t *testing.T) {
+	testCases := []struct {
+		name          string
+		line          string
+		expected      string
+		expectedDepth int
+	}{
+		{
+			name:     "option",
+			line:     `    sandbox_image = "mcr.microsoft.com/pause:3.9"  # pinned`,
+			expected: `sandbox_image="mcr.microsoft.com/pause:3.9"`,
+		},
+		{
+			name:     "whitespace and comment characters in strings",
+			line:     `  args = [ "--path", 'C:\Program Files\# keys' ]`,
+			expected: `args=["--path",'C:\Program Files\# keys']`,
+		},
+		{
+			name:     "escaped quote",
+			line:     `path = "a \" # b"`,
+			expected: `path="a \" # b"`,
+		},
+		{
+			name:     "table header",
+			line:     `  [ plugins."io.containerd.grpc.v1.cri".cni ]`,
+			expected: `[plugins."io.containerd.grpc.v1.cri".cni]`,
+		},
+		{
+			name:          "start of a multiline array",
+			line:          `platforms = [ "windows/amd64", # the host`,
+			expected:      `platforms=["windows/amd64",`,
+			expectedDepth: 1,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			out, depth := normalizeTOML(test.line)
+			assert.Equal(t, test.expected, out)
+			assert.Equal(t, test.expectedDepth, depth)
+		})
+	}
+}
+
+func TestContainerdConfDrift(t *testing.T) {
+	// the containerd config shipped with WMCO
+	shipped, err := os.ReadFile("../internal/containerd_conf.toml")
+	require.NoError(t, err)
+	expected := string(shipped)
+	proxyPlugin := "\n[proxy_plugins.\"stargz\"]\n  type = \"snapshot\"\n  address = \"\\\\.\\\\pipe\\\\stargz\"\n"
+
+	testCases := []struct {
+		name          string
+		current       string
+		expectedDrift []string
+	}{
+		{
+			name:    "up to date",
+			current: expected,
+		},
+		{
+			name: "formatting and comments changed",
+			current: "# edited by hand\r\n" + strings.ReplaceAll(strings.ReplaceAll(expected, "\n", "\r\n"), " = ", "=") +
+				"\r\n\r\n",
+		},
+		{
+			name: "multiline array",
+			current: strings.Replace(expected, `platforms = ["windows/amd64", "linux/amd64"]`,
+				"platforms = [\n      \"windows/amd64\",\n      \"linux/amd64\"\n    ]", 1),
+		},
+		{
+			name:    "proxy plugin added",
+			current: expected + proxyPlugin,
+		},
+		{
+			name:    "option changed",
+			current: strings.Replace(expected, "max_concurrent_downloads = 3", "max_concurrent_downloads = 10", 1),
+			expectedDrift: []string{`table [plugins."io.containerd.grpc.v1.cri"]: ` +
+				"option max_concurrent_downloads is 10 instead of 3"},
+		},
+		{
+			name:    "option removed and added",
+			current: strings.Replace(expected, "oom_score = 0", "debug_level = 1", 1),
+			expectedDrift: []string{"top-level options: option oom_score is missing",
+				"top-level options: unexpected option debug_level"},
+		},
+		{
+			name: "table removed and unexpected table added",
+			current: strings.Replace(expected, "[plugins.\"io.containerd.grpc.v1.cri\".image_decryption]",
+				"[plugins.\"io.containerd.grpc.v1.cri\".image_encryption]", 1),
+			expectedDrift: []string{`table [plugins."io.containerd.grpc.v1.cri".image_decryption] is missing`,
+				`unexpected table [plugins."io.containerd.grpc.v1.cri".image_encryption]`},
+		},
+	}
+	expectedTables, err := parseContainerdConf(expected)
+	require.NoError(t, err)
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			current, err := parseContainerdConf(test.current)
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedDrift, containerdConfDrift(current, expectedTables))
+		})
+	}
+}
+
+func TestParseContainerdConfInvalid(t *testing.T) {
+	_, err := parseContainerdConf("version = 2\nnot an option\n")
+	assert.Error(t, err)
+}
+
+func TestRepairContainerdConf(t *testing.T) {
+	expected := "version = 2\n\n[plugins]\n  [plugins.\"cri\"]\n    sandbox_image = \"pause\"\n"
+	proxyPlugin := "[proxy_plugins.\"stargz\"]\n  # the stargz snapshotter\n  type = \"snapshot\""
+
+	testCases := []struct {
+		name     string
+		current  string
+		expected string
+	}{
+		{
+			name: "manual edits are discarded",
+			current: "version = 2\n\n[plugins]\n  [plugins.\"cri\"]\n    sandbox_image = \"other\"\n" +
+				"[debug]\n  level = \"debug\"\n",
+			expected: expected,
+		},
+		{
+			name: "tables added by hand are kept",
+			current: "version = 2\n\n[plugins]\n  [plugins.\"cri\"]\n    sandbox_image = \"other\"\n\n" + proxyPlugin +
+				"\n\n",
+			expected: expected + "\n" + proxyPlugin + "\n",
+		},
+	}
+	expectedTables, err := parseContainerdConf(expected)
+	require.NoError(t, err)
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			current, err := parseContainerdConf(test.current)
+			require.NoError(t, err)
+			repaired := repairContainerdConf(expected, current, expectedTables)
+			assert.Equal(t, test.expected, repaired)
+			repairedTables, err := parseContainerdConf(repaired)
+			require.NoError(t, err)
+			assert.Empty(t, containerdConfDrift(repairedTables, expectedTables))
+		})
+	}
+}
+
+func TestCNIConfigDrift(t *testing.T) {
+	acl := settings.HNSACLPolicy{Action: settings.HNSACLActionBlock, Direction: settings.HNSACLDirectionOut,
+		Protocol: "6", RemoteAddress: "169.254.169.254/32", RemotePort: 80, Priority: 200}
+	aclPolicy := `,{"name":"EndpointPolicy","value":{"type":"ACL","settings":{"RuleType":"Switch",` +
+		`"Direction":"Out","Action":"Block","Protocols":"6","RemoteAddresses":"169.254.169.254/32",` +
+		`"RemotePorts":"80","Priority":200}}}`
+
+	testCases := []struct {
+		name          string
+		contents      string
+		settings      *settings.Settings
+		expectedDrift int
+	}{
+		{
+			name:          "up to date",
+			contents:      testCNIConfig("172.30.0.0/16", nil, ""),
+			settings:      &settings.Settings{},
+			expectedDrift: 0,
+		},
+		{
+			name:     "up to date with additional policies in a different order",
+			contents: testCNIConfig("172.30.0.0/16", []string{"192.168.0.0/16", "10.0.0.0/8"}, aclPolicy),
+			settings: &settings.Settings{HNSOutboundNATExceptions: []string{"10.0.0.0/8", "192.168.0.0/16"},
+				HNSACLPolicies: []settings.HNSACLPolicy{acl}},
+			expectedDrift: 0,
+		},
+		{
+			name:          "service network changed",
+			contents:      testCNIConfig("172.31.0.0/16", nil, ""),
+			settings:      &settings.Settings{},
+			expectedDrift: 2,
+		},
+		{
+			name:          "outbound NAT exception missing",
+			contents:      testCNIConfig("172.30.0.0/16", nil, ""),
+			settings:      &settings.Settings{HNSOutboundNATExceptions: []string{"10.0.0.0/8"}},
+			expectedDrift: 1,
+		},
+		{
+			name:          "ACL policy missing",
+			contents:      testCNIConfig("172.30.0.0/16", nil, ""),
+			settings:      &settings.Settings{HNSACLPolicies: []settings.HNSACLPolicy{acl}},
+			expectedDrift: 1,
+		},
+		{
+			name:          "unexpected ACL policy",
+			contents:      testCNIConfig("172.30.0.0/16", nil, aclPolicy),
+			settings:      &settings.Settings{},
+			expectedDrift: 1,
+		},
+		{
+			name:          "invalid JSON",
+			contents:      "{",
+			settings:      &settings.Settings{},
+			expectedDrift: 1,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			drift := cniConfigDrift(test.contents, "172.30.0.0/16", test.settings)
+			assert.Len(t, drift, test.expectedDrift, drift)
+		})
+	}
+}
+
+func TestSplitEndpoint(t *testing.T) {
+	testCases := []struct {
+		name         string
+		endpoint     string
+		expectedHost string
+		expectedPort int
+		expectedErr  bool
+	}{
+		{
+			name:         "host and port",
+			endpoint:     "https://api-int.cluster.example.com:6443",
+			expectedHost: "api-int.cluster.example.com",
+			expectedPort: 6443,
+		},
+		{
+			name:         "IPv6 address",
+			endpoint:     "https://[fd00::10]:6443",
+			expectedHost: "fd00::10",
+			expectedPort: 6443,
+		},
+		{
+			name:         "default port",
+			endpoint:     "https://api.cluster.example.com",
+			expectedHost: "api.cluster.example.com",
+			expectedPort: 443,
+		},
+		{
+			name:        "no host",
+			endpoint:    "api-int.cluster.example.com:6443",
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			host, port, err := splitEndpoint(test.endpoint)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedHost, host)
+			assert.Equal(t, test.expectedPort, port)
+		})
+	}
+}
+
+func TestIsPartiallyJoined(t *testing.T) {
+	ready := core.NodeCondition{Type: core.NodeReady, Status: core.ConditionTrue}
+	notReady := core.NodeCondition{Type: core.NodeReady, Status: core.ConditionFalse}
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		conditions  []core.NodeCondition
+		expected    bool
+	}{
+		{
+			name:     "registered node without conditions",
+			expected: true,
+		},
+		{
+			name:       "not ready node",
+			conditions: []core.NodeCondition{notReady},
+			expected:   true,
+		},
+		{
+			name:        "not ready node annotated by WMCO",
+			annotations: map[string]string{PubKeyHashAnnotation: "hash"},
+			conditions:  []core.NodeCondition{notReady},
+			expected:    true,
+		},
+		{
+			name:       "ready node",
+			conditions: []core.NodeCondition{ready},
+			expected:   false,
+		},
+		{
+			name:        "node configured by WICD",
+			annotations: map[string]string{metadata.VersionAnnotation: "1.0.0"},
+			conditions:  []core.NodeCondition{notReady},
+			expected:    false,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			node := &core.Node{
+				ObjectMeta: meta.ObjectMeta{Name: "node", Annotations: test.annotations},
+				Status:     core.NodeStatus{Conditions: test.conditions},
+			}
+			assert.Equal(t, test.expected, isPartiallyJoined(node))
+		})
+	}
+}
+
+// newJWT returns an unsigned JWT with the given claims
+func newJWT(claims string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".signature"
+}
+
+func TestTokenExpiry(t *testing.T) {
+	testCases := []struct {
+		name        string
+		token       string
+		expected    time.Time
+		expectedErr bool
+	}{
+		{
+			name:     "token with expiry",
+			token:    newJWT(`{"sub":"node-bootstrapper","exp":1700000000}`),
+			expected: time.Unix(1700000000, 0),
+		},
+		{
+			name:  "legacy token without expiry",
+			token: newJWT(`{"sub":"node-bootstrapper"}`),
+		},
+		{
+			name:  "token which is not a JWT",
+			token: "opaque-token",
+		},
+		{
+			name:        "undecodable claims",
+			token:       "header.!!!.signature",
+			expectedErr: true,
+		},
+		{
+			name:        "claims which are not JSON",
+			token:       newJWT("exp"),
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			expiry, err := tokenExpiry([]byte(test.token))
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, test.expected.Equal(expiry), "expected %s, got %s", test.expected, expiry)
+		})
+	}
+}
+
+func TestBootstrapTokenValid(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name        string
+		expiry      time.Time
+		expected    bool
+		expectedErr bool
+	}{
+		{
+			name:     "token without expiry",
+			expected: true,
+		},
+		{
+			name:     "token valid long enough",
+			expiry:   now.Add(24 * time.Hour),
+			expected: true,
+		},
+		{
+			name:     "token close to expiry",
+			expiry:   now.Add(5 * time.Minute),
+			expected: false,
+		},
+		{
+			name:        "expired token",
+			expiry:      now.Add(-time.Minute),
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			valid, err := bootstrapTokenValid(test.expiry, now)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, valid)
+		})
+	}
+}
+
+func TestValidateDynamicPortRange(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		dynamicPorts         utilnet.PortRange
+		serviceNodePortRange string
+		expectedErr          bool
+	}{
+		{
+			name:         "Windows default range with the default NodePort range",
+			dynamicPorts: utilnet.PortRange{Base: 49152, Size: 16384},
+		},
+		{
+			name:         "range ending below the default NodePort range",
+			dynamicPorts: utilnet.PortRange{Base: 20000, Size: 10000},
+		},
+		{
+			name:         "range overlapping the start of the default NodePort range",
+			dynamicPorts: utilnet.PortRange{Base: 20000, Size: 10001},
+			expectedErr:  true,
+		},
+		{
+			name:         "range containing the default NodePort range",
+			dynamicPorts: utilnet.PortRange{Base: 10000, Size: 55536},
+			expectedErr:  true,
+		},
+		{
+			name:                 "range overlapping the end of a custom NodePort range",
+			dynamicPorts:         utilnet.PortRange{Base: 40000, Size: 10000},
+			serviceNodePortRange: "35000-40000",
+			expectedErr:          true,
+		},
+		{
+			name:                 "range starting after a custom NodePort range",
+			dynamicPorts:         utilnet.PortRange{Base: 40001, Size: 10000},
+			serviceNodePortRange: "35000-40000",
+		},
+		{
+			name:                 "invalid NodePort range",
+			dynamicPorts:         utilnet.PortRange{Base: 49152, Size: 16384},
+			serviceNodePortRange: "35000:40000",
+			expectedErr:          true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateDynamicPortRange(test.dynamicPorts, test.serviceNodePortRange)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestRetryOnTransientError(t *testing.T) {
+	unavailable := k8sapierrors.NewServiceUnavailable("etcdserver: request timed out")
+	disruptionBudget := k8sapierrors.NewTooManyRequests(
+		"Cannot evict pod as it would violate the pod's disruption budget.", 0)
+	forbidden := k8sapierrors.NewForbidden(core.Resource("pods"), "pod", nil)
+	testCases := []struct {
+		name          string
+		errs          []error
+		expectedCalls int
+		expectedErr   error
+	}{
+		{
+			name:          "success",
+			errs:          []error{nil},
+			expectedCalls: 1,
+		},
+		{
+			name:          "transient error followed by success",
+			errs:          []error{unavailable, nil},
+			expectedCalls: 2,
+		},
+		{
+			name:          "aggregated transient errors followed by success",
+			errs:          []error{utilerrors.NewAggregate([]error{unavailable, io.ErrUnexpectedEOF}), nil},
+			expectedCalls: 2,
+		},
+		{
+			name:          "disruption budget violation",
+			errs:          []error{disruptionBudget},
+			expectedCalls: 1,
+			expectedErr:   disruptionBudget,
+		},
+		{
+			name:          "error which is not transient",
+			errs:          []error{unavailable, forbidden},
+			expectedCalls: 2,
+			expectedErr:   forbidden,
+		},
+		{
+			name:          "attempts exhausted",
+			errs:          []error{unavailable, unavailable, unavailable},
+			expectedCalls: 3,
+			expectedErr:   unavailable,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			calls := 0
+			err := retryOnTransientError(wait.Backoff{Duration: time.Millisecond, Steps: 3}, logr.Discard(),
+				func() error {
+					err := test.errs[calls]
+					calls++
+					return err
+				})
+			assert.Equal(t, test.expectedCalls, calls)
+			if test.expectedErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, test.expectedErr)
+		})
+	}
+}
+
+func TestProtectedPodFilter(t *testing.T) {
+	selector, err := labels.Parse("app=critical-agent")
+	require.NoError(t, err)
+	protectedPod := core.Pod{ObjectMeta: meta.ObjectMeta{Name: "agent",
+		Labels: map[string]string{"app": "critical-agent"}}}
+	otherPod := core.Pod{ObjectMeta: meta.ObjectMeta{Name: "web", Labels: map[string]string{"app": "web"}}}
+	unlabeledPod := core.Pod{ObjectMeta: meta.ObjectMeta{Name: "job"}}
+
+	protectedFilter := protectedPodFilter(selector, true)
+	assert.True(t, protectedFilter(protectedPod).Delete)
+	assert.False(t, protectedFilter(otherPod).Delete)
+	assert.False(t, protectedFilter(unlabeledPod).Delete)
+
+	unprotectedFilter := protectedPodFilter(selector, false)
+	assert.False(t, unprotectedFilter(protectedPod).Delete)
+	assert.True(t, unprotectedFilter(otherPod).Delete)
+	assert.True(t, unprotectedFilter(unlabeledPod).Delete)
+}
+
+func TestWithPodFilter(t *testing.T) {
+	skipAll := func(core.Pod) drain.PodDeleteStatus { return drain.MakePodDeleteStatusSkip() }
+	drainer := &drain.Helper{Force: true, IgnoreAllDaemonSets: true,
+		AdditionalFilters: make([]drain.PodFilter, 1, 2)}
+	drainer.AdditionalFilters[0] = skipAll
+
+	first := withPodFilter(drainer, skipAll)
+	second := withPodFilter(drainer, skipAll)
+	assert.Len(t, drainer.AdditionalFilters, 1)
+	assert.Len(t, first.AdditionalFilters, 2)
+	assert.Len(t, second.AdditionalFilters, 2)
+	assert.True(t, first.Force)
+	assert.True(t, first.IgnoreAllDaemonSets)
+	// the filters of the copies must not share the backing array of the original filters
+	first.AdditionalFilters[1] = nil
+	assert.NotNil(t, second.AdditionalFilters[1])
+}
+
+func TestProtectedPodsError(t *testing.T) {
+	pods := []core.Pod{
+		{ObjectMeta: meta.ObjectMeta{Namespace: "monitoring", Name: "agent-b"}},
+		{ObjectMeta: meta.ObjectMeta{Namespace: "ops", Name: "agent-a"}},
+		{ObjectMeta: meta.ObjectMeta{Namespace: "monitoring", Name: "agent-a"}},
+	}
+	err := newProtectedPodsError("winnode", pods)
+	assert.Equal(t, []string{"monitoring/agent-a", "monitoring/agent-b", "ops/agent-a"}, err.Pods)
+	assert.Equal(t, "node winnode runs drain protected pods monitoring/agent-a, monitoring/agent-b, ops/agent-a, it "+
+		"is not drained until they are removed from it", err.Error())
+}
+
+func TestValidationFailedError(t *testing.T) {
+	scriptErr := errors.New("exit status 3")
+	var err error = &ValidationFailedError{Node: "winnode", Output: "BitLocker is not enabled", Err: scriptErr}
+	err = fmt.Errorf("configuration 1234 failed: %w", err)
+	var validationErr *ValidationFailedError
+	require.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, "winnode", validationErr.Node)
+	assert.ErrorIs(t, err, scriptErr)
+	assert.Equal(t, "post-configuration validation of node winnode failed, it is left cordoned: exit status 3, "+
+		"output: BitLocker is not enabled", validationErr.Error())
+}
+
+func TestDrainBackoff(t *testing.T) {
+	assert.Equal(t, retry.DrainAttempts, drainBackoff(0).Steps)
+	assert.Equal(t, 10, drainBackoff(10).Steps)
 }
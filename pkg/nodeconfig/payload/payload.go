@@ -2,12 +2,25 @@ package payload
 
 import (
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io/fs"
 	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
 	"strings"
 )
 
+// ChecksumManifestPath is the path of the optional manifest giving the expected SHA256 checksums of payload files,
+// mounted from the windows-payload-checksums ConfigMap. Each line gives a checksum and the path of a file relative to
+// the payload directory, in the format output by sha256sum.
+const ChecksumManifestPath = "/etc/windows-payload-checksums/sha256sums"
+
+// sha256Regex matches a hex encoded SHA256 checksum
+var sha256Regex = regexp.MustCompile(`^[a-fA-F0-9]{64}$`)
+
 // Payload files
 const (
 	// payloadDirectory is the directory in the operator image where are all the binaries live
@@ -24,6 +37,9 @@ const (
 	// ContainerdPath contains the path of the containerd binary. The container image should already have this binary
 	// mounted
 	ContainerdPath = payloadDirectory + "/containerd/containerd.exe"
+	// CtrPath contains the path of the containerd CLI binary. The container image should already have this binary
+	// mounted
+	CtrPath = payloadDirectory + "/containerd/ctr.exe"
 	//HcsshimPath contains the path of the hcsshim binary. The container image should already have this binary mounted
 	HcsshimPath = payloadDirectory + "/containerd/containerd-shim-runhcs-v1.exe"
 	// ContainerdConfPath contains the path of the containerd config file.
@@ -72,6 +88,9 @@ const (
 	TLSConfPath = payloadDirectory + WindowsExporterDirectory + "windows-exporter-webconfig.yaml"
 	// ECRCredentialProviderPath is the path to ecr-credential-provider.exe
 	ECRCredentialProviderPath = payloadDirectory + "ecr-credential-provider.exe"
+	// CredentialProvidersDir is the directory holding the binaries of the kubelet image credential providers given by
+	// the settings ConfigMap, each named after its provider with the .exe extension
+	CredentialProvidersDir = payloadDirectory + "credential-providers/"
 	// AzureCloudNodeManager is the name of the cloud node manager for Azure platform
 	AzureCloudNodeManager = "azure-cloud-node-manager.exe"
 	// AzureCloudNodeManagerPath contains the path of the azure cloud node manager binary. The container image should
@@ -172,6 +191,22 @@ $cni_template=$cni_template.Replace("ovn_host_subnet",$subnet)
 $provider_address=$hns_network.ManagementIP
 $cni_template=$cni_template.Replace("provider_address",$provider_address)
 
+# Add the additional HNS endpoint policies given through the WMCO settings ConfigMap, if any
+if (Test-Path -Path HNS_ENDPOINT_POLICIES_PATH) {
+    $additional_policies = Get-Content -Path HNS_ENDPOINT_POLICIES_PATH -Raw | ConvertFrom-Json
+    if ($additional_policies.outboundNATExceptions -or $additional_policies.policies) {
+        $cni_config = $cni_template | ConvertFrom-Json
+        if ($additional_policies.outboundNATExceptions) {
+            $outbound_nat = $cni_config.policies | where { $_.value.type -eq 'OutBoundNAT' }
+            $outbound_nat.value.settings.exceptionList += $additional_policies.outboundNATExceptions
+        }
+        if ($additional_policies.policies) {
+            $cni_config.policies += $additional_policies.policies
+        }
+        $cni_template = ($cni_config | ConvertTo-Json -Depth 10) -replace "\r", ""
+    }
+}
+
 Compare-And-Replace-Config -ConfigPath CNI_CONFIG_PATH -NewConfigContent $cni_template
 
 # Create HNS endpoint if it doesn't exist
@@ -269,37 +304,122 @@ type FileInfo struct {
 	SHA256 string
 }
 
-// NewFileInfo returns a pointer to a FileInfo object created from the specified file
+// NewFileInfo returns a pointer to a FileInfo object created from the specified file. An error is returned if the
+// checksum manifest gives a different checksum for the file.
 func NewFileInfo(path string) (*FileInfo, error) {
 	contents, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("could not get contents of file: %w", err)
 	}
-	return &FileInfo{
+	info := &FileInfo{
 		Path:   path,
 		SHA256: fmt.Sprintf("%x", sha256.Sum256(contents)),
-	}, nil
+	}
+	if err = verifyChecksum(info, ChecksumManifestPath); err != nil {
+		return nil, err
+	}
+	return info, nil
 }
 
-// PopulateNetworkConfScript creates the .ps1 file responsible for CNI configuration
-func PopulateNetworkConfScript(clusterCIDR, hnsNetworkName, hnsPSModulePath, cniConfigPath string) error {
+// verifyChecksum returns an error if the checksum manifest at the given path gives a different checksum for the given
+// file. Files which are not in the manifest, or an absent manifest, are not verified.
+func verifyChecksum(info *FileInfo, manifestPath string) error {
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("error reading payload checksum manifest: %w", err)
+	}
+	checksums, err := parseChecksumManifest(string(data))
+	if err != nil {
+		return fmt.Errorf("invalid payload checksum manifest %s: %w", manifestPath, err)
+	}
+	relativePath := strings.TrimPrefix(path.Clean(info.Path), path.Clean(payloadDirectory)+"/")
+	expected, ok := checksums[relativePath]
+	if !ok {
+		return nil
+	}
+	if expected != info.SHA256 {
+		return fmt.Errorf("payload file %s has SHA256 checksum %s, but %s gives %s", info.Path, info.SHA256,
+			manifestPath, expected)
+	}
+	return nil
+}
+
+// parseChecksumManifest returns the checksums given by the given checksum manifest, keyed by the path of the file
+// relative to the payload directory. Blank lines and lines starting with # are ignored.
+func parseChecksumManifest(data string) (map[string]string, error) {
+	checksums := make(map[string]string)
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d must give a checksum and a file path", i+1)
+		}
+		if !sha256Regex.MatchString(fields[0]) {
+			return nil, fmt.Errorf("line %d gives invalid SHA256 checksum %q", i+1, fields[0])
+		}
+		// sha256sum prefixes the path with * for files read in binary mode
+		filePath := path.Clean(strings.TrimPrefix(fields[1], "*"))
+		if path.IsAbs(filePath) || filePath == ".." || strings.HasPrefix(filePath, "../") {
+			return nil, fmt.Errorf("line %d gives path %q which is not relative to the payload directory", i+1,
+				fields[1])
+		}
+		checksums[filePath] = strings.ToLower(fields[0])
+	}
+	return checksums, nil
+}
+
+// PopulateNetworkConfScript creates the .ps1 file responsible for CNI configuration. hnsEndpointPoliciesPath is the
+// path of the file on the instance giving additional policies to add to the CNI config.
+func PopulateNetworkConfScript(clusterCIDR, hnsNetworkName, hnsPSModulePath, cniConfigPath,
+	hnsEndpointPoliciesPath string) error {
 	scriptContents, err := generateNetworkConfigScript(clusterCIDR, hnsNetworkName,
-		hnsPSModulePath, cniConfigPath)
+		hnsPSModulePath, cniConfigPath, hnsEndpointPoliciesPath)
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(NetworkConfigurationScript, []byte(scriptContents), fs.ModePerm)
+	return writeFileAtomically(NetworkConfigurationScript, []byte(scriptContents), fs.ModePerm)
+}
+
+// writeFileAtomically replaces the file at the given path with one holding the given data. The file is written
+// alongside and then renamed into place, as the script is regenerated while it may be read for a transfer.
+func writeFileAtomically(filePath string, data []byte, perm fs.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file for %s: %w", filePath, err)
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing %s: %w", f.Name(), err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("error closing %s: %w", f.Name(), err)
+	}
+	if err = os.Chmod(f.Name(), perm); err != nil {
+		return fmt.Errorf("error setting permissions of %s: %w", f.Name(), err)
+	}
+	if err = os.Rename(f.Name(), filePath); err != nil {
+		return fmt.Errorf("error replacing %s: %w", filePath, err)
+	}
+	return nil
 }
 
 // generateNetworkConfigScript generates the contents of the .ps1 file responsible for CNI configuration
-func generateNetworkConfigScript(clusterCIDR, hnsNetworkName, hnsPSModulePath,
-	cniConfigPath string) (string, error) {
+func generateNetworkConfigScript(clusterCIDR, hnsNetworkName, hnsPSModulePath, cniConfigPath,
+	hnsEndpointPoliciesPath string) (string, error) {
 	networkConfScript := networkConfTemplate
 	for key, val := range map[string]string{
-		"HNS_NETWORK":          hnsNetworkName,
-		"SERVICE_NETWORK_CIDR": clusterCIDR,
-		"HNS_MODULE_PATH":      hnsPSModulePath,
-		"CNI_CONFIG_PATH":      cniConfigPath,
+		"HNS_NETWORK":                hnsNetworkName,
+		"SERVICE_NETWORK_CIDR":       clusterCIDR,
+		"HNS_MODULE_PATH":            hnsPSModulePath,
+		"CNI_CONFIG_PATH":            cniConfigPath,
+		"HNS_ENDPOINT_POLICIES_PATH": hnsEndpointPoliciesPath,
 	} {
 		networkConfScript = strings.ReplaceAll(networkConfScript, key, val)
 	}
@@ -1,6 +1,9 @@
 package payload
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -100,6 +103,22 @@ $cni_template=$cni_template.Replace("ovn_host_subnet",$subnet)
 $provider_address=$hns_network.ManagementIP
 $cni_template=$cni_template.Replace("provider_address",$provider_address)
 
+# Add the additional HNS endpoint policies given through the WMCO settings ConfigMap, if any
+if (Test-Path -Path c:\k\cni\hns-endpoint-policies.json) {
+    $additional_policies = Get-Content -Path c:\k\cni\hns-endpoint-policies.json -Raw | ConvertFrom-Json
+    if ($additional_policies.outboundNATExceptions -or $additional_policies.policies) {
+        $cni_config = $cni_template | ConvertFrom-Json
+        if ($additional_policies.outboundNATExceptions) {
+            $outbound_nat = $cni_config.policies | where { $_.value.type -eq 'OutBoundNAT' }
+            $outbound_nat.value.settings.exceptionList += $additional_policies.outboundNATExceptions
+        }
+        if ($additional_policies.policies) {
+            $cni_config.policies += $additional_policies.policies
+        }
+        $cni_template = ($cni_config | ConvertTo-Json -Depth 10) -replace "\r", ""
+    }
+}
+
 Compare-And-Replace-Config -ConfigPath c:\k\cni.conf -NewConfigContent $cni_template
 
 # Create HNS endpoint if it doesn't exist
@@ -190,7 +209,131 @@ portRange: ''
 Compare-And-Replace-Config -ConfigPath $kubeProxyConfigPath -NewConfigContent $kube_proxy_config
 `
 	actual, err := generateNetworkConfigScript("10.0.0.1/32",
-		"OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1", "c:\\k\\cni.conf")
+		"OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1", "c:\\k\\cni.conf",
+		"c:\\k\\cni\\hns-endpoint-policies.json")
 	require.NoError(t, err)
 	assert.Equal(t, string(expectedOut), actual)
 }
+
+func TestParseChecksumManifest(t *testing.T) {
+	kubeletSum := strings.Repeat("a", 64)
+	containerdSum := strings.Repeat("b", 64)
+	testCases := []struct {
+		name        string
+		data        string
+		expected    map[string]string
+		expectedErr bool
+	}{
+		{
+			name:     "empty manifest",
+			data:     "",
+			expected: map[string]string{},
+		},
+		{
+			name: "sha256sum output",
+			data: "# checksums of the mirrored payload\n" + kubeletSum + "  kube-node/kubelet.exe\n\n" +
+				strings.ToUpper(containerdSum) + " *./containerd/containerd.exe\n",
+			expected: map[string]string{"kube-node/kubelet.exe": kubeletSum, "containerd/containerd.exe": containerdSum},
+		},
+		{
+			name:        "missing path",
+			data:        kubeletSum,
+			expectedErr: true,
+		},
+		{
+			name:        "invalid checksum",
+			data:        "abc123  kube-node/kubelet.exe",
+			expectedErr: true,
+		},
+		{
+			name:        "absolute path",
+			data:        kubeletSum + "  /payload/kube-node/kubelet.exe",
+			expectedErr: true,
+		},
+		{
+			name:        "path outside of the payload directory",
+			data:        kubeletSum + "  ../etc/passwd",
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := parseChecksumManifest(test.data)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, out)
+		})
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	kubelet := &FileInfo{Path: KubeletPath, SHA256: strings.Repeat("a", 64)}
+	testCases := []struct {
+		name        string
+		manifest    string
+		expectedErr bool
+	}{
+		{
+			name:        "no manifest",
+			manifest:    "",
+			expectedErr: false,
+		},
+		{
+			name:        "matching checksum",
+			manifest:    strings.Repeat("a", 64) + "  kube-node/kubelet.exe\n",
+			expectedErr: false,
+		},
+		{
+			name:        "file not in manifest",
+			manifest:    strings.Repeat("b", 64) + "  containerd/containerd.exe\n",
+			expectedErr: false,
+		},
+		{
+			name:        "mismatched checksum",
+			manifest:    strings.Repeat("b", 64) + "  kube-node/kubelet.exe\n",
+			expectedErr: true,
+		},
+		{
+			name:        "invalid manifest",
+			manifest:    "kube-node/kubelet.exe\n",
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			manifestPath := filepath.Join(t.TempDir(), "sha256sums")
+			if test.manifest != "" {
+				require.NoError(t, os.WriteFile(manifestPath, []byte(test.manifest), 0644))
+			}
+			err := verifyChecksum(kubelet, manifestPath)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestWriteFileAtomically(t *testing.T) {
+	dir := t.TempDir()
+	scriptPath := filepath.Join(dir, "network-conf.ps1")
+	require.NoError(t, os.WriteFile(scriptPath, []byte("previous contents which are longer"), 0600))
+
+	require.NoError(t, writeFileAtomically(scriptPath, []byte("new"), 0755))
+	contents, err := os.ReadFile(scriptPath)
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(contents))
+	info, err := os.Stat(scriptPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	// the temporary file is renamed into place, so no other file is left in the directory
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	assert.Error(t, writeFileAtomically(filepath.Join(dir, "missing", "network-conf.ps1"), []byte("new"), 0755))
+}
@@ -1,7 +1,48 @@
 package nodeutil
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
 	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeTypes "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// WICDDegradedCondition is the type of the Node condition reporting the result of WICD's last attempt at
+	// configuring the node's services. It is True while WICD fails to apply the desired configuration.
+	WICDDegradedCondition core.NodeConditionType = "WICDDegraded"
+	// WICDReconcileFailedReason is the reason of the WICDDegradedCondition when WICD failed to configure the node
+	WICDReconcileFailedReason = "ReconcileFailed"
+	// WICDReconcileSucceededReason is the reason of the WICDDegradedCondition when WICD configured the node
+	WICDReconcileSucceededReason = "ReconcileSucceeded"
+	// ExternallyReachableCondition is the type of the Node condition reporting whether WMCO was able to connect to
+	// the node through its external address
+	ExternallyReachableCondition core.NodeConditionType = "ExternallyReachable"
+	// ExternalAddressReachableReason is the reason of the ExternallyReachableCondition when the node was reached
+	ExternalAddressReachableReason = "AddressReachable"
+	// ExternalAddressUnreachableReason is the reason of the ExternallyReachableCondition when the node was not reached
+	ExternalAddressUnreachableReason = "AddressUnreachable"
+	// WindowsExporterScrapeableCondition is the type of the Node condition reporting whether WMCO was able to scrape
+	// the metrics served by the node's windows_exporter
+	WindowsExporterScrapeableCondition core.NodeConditionType = "WindowsExporterScrapeable"
+	// ScrapeSucceededReason is the reason of the WindowsExporterScrapeableCondition when the metrics were scraped
+	ScrapeSucceededReason = "ScrapeSucceeded"
+	// ScrapeFailedReason is the reason of the WindowsExporterScrapeableCondition when the metrics could not be scraped
+	ScrapeFailedReason = "ScrapeFailed"
+	// UpgradeStalledCondition is the type of the Node condition reporting whether WICD has failed to configure the
+	// node for its desired version within the upgrade stall timeout
+	UpgradeStalledCondition core.NodeConditionType = "UpgradeStalled"
+	// VersionMismatchReason is the reason of the UpgradeStalledCondition when the node's version has not reached its
+	// desired version within the upgrade stall timeout
+	VersionMismatchReason = "VersionMismatch"
+	// VersionReachedReason is the reason of the UpgradeStalledCondition when the node's version matches its desired
+	// version
+	VersionReachedReason = "VersionReached"
 )
 
 // FindByAddress returns a pointer to the node within the given list with an address matching the given address, or
@@ -16,3 +57,170 @@ func FindByAddress(address string, nodes *core.NodeList) *core.Node {
 	}
 	return nil
 }
+
+// GetCondition returns the condition of the given type from the status of the given node, or nil if it has none
+func GetCondition(node *core.Node, conditionType core.NodeConditionType) *core.NodeCondition {
+	for i := range node.Status.Conditions {
+		if node.Status.Conditions[i].Type == conditionType {
+			return &node.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// NewWICDDegradedCondition returns the WICDDegradedCondition describing the result of an attempt at configuring a
+// node for the given desired version, which failed if reconcileErr is not nil. The transition time of the given
+// existing condition, if any, is kept if the status is unchanged.
+func NewWICDDegradedCondition(existing *core.NodeCondition, desiredVersion string, reconcileErr error,
+	now meta.Time) core.NodeCondition {
+	condition := core.NodeCondition{
+		Type:               WICDDegradedCondition,
+		Status:             core.ConditionFalse,
+		LastHeartbeatTime:  now,
+		LastTransitionTime: now,
+		Reason:             WICDReconcileSucceededReason,
+		Message:            fmt.Sprintf("configured services for desired version %s", desiredVersion),
+	}
+	if reconcileErr != nil {
+		condition.Status = core.ConditionTrue
+		condition.Reason = WICDReconcileFailedReason
+		condition.Message = fmt.Sprintf("error configuring services for desired version %s: %s", desiredVersion,
+			reconcileErr.Error())
+	}
+	if existing != nil && existing.Status == condition.Status {
+		condition.LastTransitionTime = existing.LastTransitionTime
+	}
+	return condition
+}
+
+// SetWICDDegradedCondition updates the WICDDegradedCondition of the given node to describe the result of an attempt
+// at configuring it for the given desired version, which failed if reconcileErr is not nil. The node is not patched
+// if the condition already describes the same result.
+func SetWICDDegradedCondition(ctx context.Context, c client.Client, node *core.Node, desiredVersion string,
+	reconcileErr error) error {
+	existing := GetCondition(node, WICDDegradedCondition)
+	return setCondition(ctx, c, node, existing,
+		NewWICDDegradedCondition(existing, desiredVersion, reconcileErr, meta.Now()))
+}
+
+// NewExternallyReachableCondition returns the ExternallyReachableCondition describing the result of an attempt at
+// connecting to a node through the given external address and port, which failed if checkErr is not nil. The
+// transition time of the given existing condition, if any, is kept if the status is unchanged.
+func NewExternallyReachableCondition(existing *core.NodeCondition, target string, checkErr error,
+	now meta.Time) core.NodeCondition {
+	condition := core.NodeCondition{
+		Type:               ExternallyReachableCondition,
+		Status:             core.ConditionTrue,
+		LastHeartbeatTime:  now,
+		LastTransitionTime: now,
+		Reason:             ExternalAddressReachableReason,
+		Message:            fmt.Sprintf("connected to %s", target),
+	}
+	if checkErr != nil {
+		condition.Status = core.ConditionFalse
+		condition.Reason = ExternalAddressUnreachableReason
+		condition.Message = fmt.Sprintf("unable to connect to %s: %s", target, checkErr.Error())
+	}
+	if existing != nil && existing.Status == condition.Status {
+		condition.LastTransitionTime = existing.LastTransitionTime
+	}
+	return condition
+}
+
+// SetExternallyReachableCondition updates the ExternallyReachableCondition of the given node to describe the result
+// of an attempt at connecting to it through the given external address and port, which failed if checkErr is not nil
+func SetExternallyReachableCondition(ctx context.Context, c client.Client, node *core.Node, target string,
+	checkErr error) error {
+	existing := GetCondition(node, ExternallyReachableCondition)
+	return setCondition(ctx, c, node, existing,
+		NewExternallyReachableCondition(existing, target, checkErr, meta.Now()))
+}
+
+// NewWindowsExporterScrapeableCondition returns the WindowsExporterScrapeableCondition describing the result of an
+// attempt at scraping the windows_exporter metrics of a node at the given host:port target, which failed if scrapeErr
+// is not nil. The transition time of the given existing condition, if any, is kept if the status is unchanged.
+func NewWindowsExporterScrapeableCondition(existing *core.NodeCondition, target string, scrapeErr error,
+	now meta.Time) core.NodeCondition {
+	condition := core.NodeCondition{
+		Type:               WindowsExporterScrapeableCondition,
+		Status:             core.ConditionTrue,
+		LastHeartbeatTime:  now,
+		LastTransitionTime: now,
+		Reason:             ScrapeSucceededReason,
+		Message:            fmt.Sprintf("scraped metrics from %s", target),
+	}
+	if scrapeErr != nil {
+		condition.Status = core.ConditionFalse
+		condition.Reason = ScrapeFailedReason
+		condition.Message = fmt.Sprintf("unable to scrape metrics from %s: %s", target, scrapeErr.Error())
+	}
+	if existing != nil && existing.Status == condition.Status {
+		condition.LastTransitionTime = existing.LastTransitionTime
+	}
+	return condition
+}
+
+// SetWindowsExporterScrapeableCondition updates the WindowsExporterScrapeableCondition of the given node to describe
+// the result of an attempt at scraping its windows_exporter metrics at the given target, which failed if scrapeErr is
+// not nil
+func SetWindowsExporterScrapeableCondition(ctx context.Context, c client.Client, node *core.Node, target string,
+	scrapeErr error) error {
+	existing := GetCondition(node, WindowsExporterScrapeableCondition)
+	return setCondition(ctx, c, node, existing,
+		NewWindowsExporterScrapeableCondition(existing, target, scrapeErr, meta.Now()))
+}
+
+// NewUpgradeStalledCondition returns the UpgradeStalledCondition describing a node whose version has differed from
+// the given desired version for stalledFor, or which has reached it if stalledFor is 0. The transition time of the
+// given existing condition, if any, is kept if the status is unchanged.
+func NewUpgradeStalledCondition(existing *core.NodeCondition, desiredVersion string, stalledFor time.Duration,
+	now meta.Time) core.NodeCondition {
+	condition := core.NodeCondition{
+		Type:               UpgradeStalledCondition,
+		Status:             core.ConditionFalse,
+		LastHeartbeatTime:  now,
+		LastTransitionTime: now,
+		Reason:             VersionReachedReason,
+		Message:            fmt.Sprintf("node configured for desired version %s", desiredVersion),
+	}
+	if stalledFor > 0 {
+		condition.Status = core.ConditionTrue
+		condition.Reason = VersionMismatchReason
+		condition.Message = fmt.Sprintf("node not configured for desired version %s after %s", desiredVersion,
+			stalledFor.Round(time.Second))
+	}
+	if existing != nil && existing.Status == condition.Status {
+		condition.LastTransitionTime = existing.LastTransitionTime
+	}
+	return condition
+}
+
+// SetUpgradeStalledCondition updates the UpgradeStalledCondition of the given node to describe a node whose version
+// has differed from the given desired version for stalledFor, or which has reached it if stalledFor is 0
+func SetUpgradeStalledCondition(ctx context.Context, c client.Client, node *core.Node, desiredVersion string,
+	stalledFor time.Duration) error {
+	existing := GetCondition(node, UpgradeStalledCondition)
+	return setCondition(ctx, c, node, existing,
+		NewUpgradeStalledCondition(existing, desiredVersion, stalledFor, meta.Now()))
+}
+
+// setCondition patches the given condition into the status of the given node. The node is not patched if the given
+// existing condition of the same type already has the same status, reason and message.
+func setCondition(ctx context.Context, c client.Client, node *core.Node, existing *core.NodeCondition,
+	condition core.NodeCondition) error {
+	if existing != nil && existing.Status == condition.Status && existing.Reason == condition.Reason &&
+		existing.Message == condition.Message {
+		return nil
+	}
+	// conditions are merged by type, so the conditions maintained by kubelet are left untouched
+	patchData, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{"conditions": []core.NodeCondition{condition}},
+	})
+	if err != nil {
+		return fmt.Errorf("error creating %s condition patch: %w", condition.Type, err)
+	}
+	if err = c.Status().Patch(ctx, node, client.RawPatch(kubeTypes.StrategicMergePatchType, patchData)); err != nil {
+		return fmt.Errorf("error setting %s condition on node %s: %w", condition.Type, node.GetName(), err)
+	}
+	return nil
+}
@@ -1,11 +1,17 @@
 package nodeutil
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	core "k8s.io/api/core/v1"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 func TestFindNode(t *testing.T) {
@@ -77,3 +83,250 @@ func TestFindNode(t *testing.T) {
 	}
 
 }
+
+func TestNewWICDDegradedCondition(t *testing.T) {
+	earlier := meta.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	now := meta.NewTime(earlier.Add(time.Hour))
+	degraded := &core.NodeCondition{Type: WICDDegradedCondition, Status: core.ConditionTrue,
+		LastTransitionTime: earlier}
+
+	testCases := []struct {
+		name                   string
+		existing               *core.NodeCondition
+		reconcileErr           error
+		expectedStatus         core.ConditionStatus
+		expectedReason         string
+		expectedMessage        string
+		expectedTransitionTime meta.Time
+	}{
+		{
+			name:                   "first success",
+			expectedStatus:         core.ConditionFalse,
+			expectedReason:         WICDReconcileSucceededReason,
+			expectedMessage:        "configured services for desired version 1.0.0",
+			expectedTransitionTime: now,
+		},
+		{
+			name:                   "first failure",
+			reconcileErr:           errors.New("service kubelet failed to start"),
+			expectedStatus:         core.ConditionTrue,
+			expectedReason:         WICDReconcileFailedReason,
+			expectedMessage:        "error configuring services for desired version 1.0.0: service kubelet failed to start",
+			expectedTransitionTime: now,
+		},
+		{
+			name:                   "failure while degraded keeps the transition time",
+			existing:               degraded,
+			reconcileErr:           errors.New("timeout"),
+			expectedStatus:         core.ConditionTrue,
+			expectedReason:         WICDReconcileFailedReason,
+			expectedMessage:        "error configuring services for desired version 1.0.0: timeout",
+			expectedTransitionTime: earlier,
+		},
+		{
+			name:                   "success clears the degraded condition",
+			existing:               degraded,
+			expectedStatus:         core.ConditionFalse,
+			expectedReason:         WICDReconcileSucceededReason,
+			expectedMessage:        "configured services for desired version 1.0.0",
+			expectedTransitionTime: now,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			condition := NewWICDDegradedCondition(test.existing, "1.0.0", test.reconcileErr, now)
+			assert.Equal(t, WICDDegradedCondition, condition.Type)
+			assert.Equal(t, test.expectedStatus, condition.Status)
+			assert.Equal(t, test.expectedReason, condition.Reason)
+			assert.Equal(t, test.expectedMessage, condition.Message)
+			assert.Equal(t, now, condition.LastHeartbeatTime)
+			assert.Equal(t, test.expectedTransitionTime, condition.LastTransitionTime)
+		})
+	}
+}
+
+func TestNewExternallyReachableCondition(t *testing.T) {
+	earlier := meta.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	now := meta.NewTime(earlier.Add(time.Hour))
+	unreachable := &core.NodeCondition{Type: ExternallyReachableCondition, Status: core.ConditionFalse,
+		LastTransitionTime: earlier}
+
+	testCases := []struct {
+		name                   string
+		existing               *core.NodeCondition
+		checkErr               error
+		expectedStatus         core.ConditionStatus
+		expectedReason         string
+		expectedMessage        string
+		expectedTransitionTime meta.Time
+	}{
+		{
+			name:                   "first success",
+			expectedStatus:         core.ConditionTrue,
+			expectedReason:         ExternalAddressReachableReason,
+			expectedMessage:        "connected to 203.0.113.5:443",
+			expectedTransitionTime: now,
+		},
+		{
+			name:                   "failure while unreachable keeps the transition time",
+			existing:               unreachable,
+			checkErr:               errors.New("i/o timeout"),
+			expectedStatus:         core.ConditionFalse,
+			expectedReason:         ExternalAddressUnreachableReason,
+			expectedMessage:        "unable to connect to 203.0.113.5:443: i/o timeout",
+			expectedTransitionTime: earlier,
+		},
+		{
+			name:                   "success once unreachable",
+			existing:               unreachable,
+			expectedStatus:         core.ConditionTrue,
+			expectedReason:         ExternalAddressReachableReason,
+			expectedMessage:        "connected to 203.0.113.5:443",
+			expectedTransitionTime: now,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			condition := NewExternallyReachableCondition(test.existing, "203.0.113.5:443", test.checkErr, now)
+			assert.Equal(t, ExternallyReachableCondition, condition.Type)
+			assert.Equal(t, test.expectedStatus, condition.Status)
+			assert.Equal(t, test.expectedReason, condition.Reason)
+			assert.Equal(t, test.expectedMessage, condition.Message)
+			assert.Equal(t, now, condition.LastHeartbeatTime)
+			assert.Equal(t, test.expectedTransitionTime, condition.LastTransitionTime)
+		})
+	}
+}
+
+func TestNewWindowsExporterScrapeableCondition(t *testing.T) {
+	earlier := meta.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	now := meta.NewTime(earlier.Add(time.Hour))
+	scrapeable := &core.NodeCondition{Type: WindowsExporterScrapeableCondition, Status: core.ConditionTrue,
+		LastTransitionTime: earlier}
+
+	testCases := []struct {
+		name                   string
+		existing               *core.NodeCondition
+		scrapeErr              error
+		expectedStatus         core.ConditionStatus
+		expectedReason         string
+		expectedMessage        string
+		expectedTransitionTime meta.Time
+	}{
+		{
+			name:                   "first success",
+			expectedStatus:         core.ConditionTrue,
+			expectedReason:         ScrapeSucceededReason,
+			expectedMessage:        "scraped metrics from 10.0.0.5:9182",
+			expectedTransitionTime: now,
+		},
+		{
+			name:                   "success while scrapeable keeps the transition time",
+			existing:               scrapeable,
+			expectedStatus:         core.ConditionTrue,
+			expectedReason:         ScrapeSucceededReason,
+			expectedMessage:        "scraped metrics from 10.0.0.5:9182",
+			expectedTransitionTime: earlier,
+		},
+		{
+			name:                   "failure once scrapeable",
+			existing:               scrapeable,
+			scrapeErr:              errors.New("connection refused"),
+			expectedStatus:         core.ConditionFalse,
+			expectedReason:         ScrapeFailedReason,
+			expectedMessage:        "unable to scrape metrics from 10.0.0.5:9182: connection refused",
+			expectedTransitionTime: now,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			condition := NewWindowsExporterScrapeableCondition(test.existing, "10.0.0.5:9182", test.scrapeErr, now)
+			assert.Equal(t, WindowsExporterScrapeableCondition, condition.Type)
+			assert.Equal(t, test.expectedStatus, condition.Status)
+			assert.Equal(t, test.expectedReason, condition.Reason)
+			assert.Equal(t, test.expectedMessage, condition.Message)
+			assert.Equal(t, now, condition.LastHeartbeatTime)
+			assert.Equal(t, test.expectedTransitionTime, condition.LastTransitionTime)
+		})
+	}
+}
+
+func TestNewUpgradeStalledCondition(t *testing.T) {
+	earlier := meta.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	now := meta.NewTime(earlier.Add(time.Hour))
+	stalled := &core.NodeCondition{Type: UpgradeStalledCondition, Status: core.ConditionTrue,
+		LastTransitionTime: earlier}
+
+	testCases := []struct {
+		name                   string
+		existing               *core.NodeCondition
+		stalledFor             time.Duration
+		expectedStatus         core.ConditionStatus
+		expectedReason         string
+		expectedMessage        string
+		expectedTransitionTime meta.Time
+	}{
+		{
+			name:                   "first stall",
+			stalledFor:             31*time.Minute + 400*time.Millisecond,
+			expectedStatus:         core.ConditionTrue,
+			expectedReason:         VersionMismatchReason,
+			expectedMessage:        "node not configured for desired version 2.0.0 after 31m0s",
+			expectedTransitionTime: now,
+		},
+		{
+			name:                   "repeated stall keeps the transition time",
+			existing:               stalled,
+			stalledFor:             time.Hour,
+			expectedStatus:         core.ConditionTrue,
+			expectedReason:         VersionMismatchReason,
+			expectedMessage:        "node not configured for desired version 2.0.0 after 1h0m0s",
+			expectedTransitionTime: earlier,
+		},
+		{
+			name:                   "version reached once stalled",
+			existing:               stalled,
+			expectedStatus:         core.ConditionFalse,
+			expectedReason:         VersionReachedReason,
+			expectedMessage:        "node configured for desired version 2.0.0",
+			expectedTransitionTime: now,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			condition := NewUpgradeStalledCondition(test.existing, "2.0.0", test.stalledFor, now)
+			assert.Equal(t, UpgradeStalledCondition, condition.Type)
+			assert.Equal(t, test.expectedStatus, condition.Status)
+			assert.Equal(t, test.expectedReason, condition.Reason)
+			assert.Equal(t, test.expectedMessage, condition.Message)
+			assert.Equal(t, now, condition.LastHeartbeatTime)
+			assert.Equal(t, test.expectedTransitionTime, condition.LastTransitionTime)
+		})
+	}
+}
+
+func TestSetWICDDegradedCondition(t *testing.T) {
+	node := &core.Node{
+		ObjectMeta: meta.ObjectMeta{Name: "node"},
+		Status: core.NodeStatus{
+			Conditions: []core.NodeCondition{{Type: core.NodeReady, Status: core.ConditionTrue}},
+		},
+	}
+	c := clientfake.NewClientBuilder().WithObjects(node).WithStatusSubresource(node).Build()
+
+	require.NoError(t, SetWICDDegradedCondition(context.TODO(), c, node, "1.0.0", errors.New("timeout")))
+	updated := &core.Node{}
+	require.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: "node"}, updated))
+	// the conditions maintained by kubelet are kept
+	require.NotNil(t, GetCondition(updated, core.NodeReady))
+	wicdCondition := GetCondition(updated, WICDDegradedCondition)
+	require.NotNil(t, wicdCondition)
+	assert.Equal(t, core.ConditionTrue, wicdCondition.Status)
+
+	require.NoError(t, SetWICDDegradedCondition(context.TODO(), c, updated, "1.0.0", nil))
+	require.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: "node"}, updated))
+	wicdCondition = GetCondition(updated, WICDDegradedCondition)
+	require.NotNil(t, wicdCondition)
+	assert.Equal(t, core.ConditionFalse, wicdCondition.Status)
+	assert.Len(t, updated.Status.Conditions, 2)
+}
@@ -245,6 +245,50 @@ func (ms *mirrorSet) generateConfig(secretsConfig credentialprovider.DockerConfi
 	return result
 }
 
+// GetRegistryEndpoints returns the hosts images from the given source registry host can be pulled from, as configured
+// by the cluster's ImageDigestMirrorSets and ImageTagMirrorSets. The source itself is included unless it is mirrored
+// with a policy prohibiting contacting it.
+func GetRegistryEndpoints(ctx context.Context, c client.Client, source string) ([]string, error) {
+	imageDigestMirrorSetList := &config.ImageDigestMirrorSetList{}
+	if err := c.List(ctx, imageDigestMirrorSetList); err != nil {
+		return nil, fmt.Errorf("error getting IDMS list: %w", err)
+	}
+	imageTagMirrorSetList := &config.ImageTagMirrorSetList{}
+	if err := c.List(ctx, imageTagMirrorSetList); err != nil {
+		return nil, fmt.Errorf("error getting ITMS list: %w", err)
+	}
+	return registryEndpoints(getMergedMirrorSets(imageDigestMirrorSetList.Items, imageTagMirrorSetList.Items),
+		source), nil
+}
+
+// registryEndpoints returns the hosts images from the given source registry host can be pulled from, based on the
+// given mirror sets
+func registryEndpoints(mirrorSets []mirrorSet, source string) []string {
+	var endpoints []string
+	contactSource := true
+	seen := make(map[string]struct{})
+	for _, ms := range mirrorSets {
+		if ms.source != source {
+			continue
+		}
+		if ms.mirrorSourcePolicy == config.NeverContactSource {
+			contactSource = false
+		}
+		for _, m := range ms.mirrors {
+			host := extractHostname(m.host)
+			if _, ok := seen[host]; ok {
+				continue
+			}
+			seen[host] = struct{}{}
+			endpoints = append(endpoints, host)
+		}
+	}
+	if _, ok := seen[source]; contactSource && !ok {
+		endpoints = append(endpoints, source)
+	}
+	return endpoints
+}
+
 // GenerateConfigFiles uses cluster resources to generate the containerd mirror registry configuration files
 func GenerateConfigFiles(ctx context.Context, c client.Client) (map[string][]byte, error) {
 	// List IDMS/ITMS resources
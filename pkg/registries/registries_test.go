@@ -559,6 +559,64 @@ func TestMergeMirrors(t *testing.T) {
 	}
 }
 
+func TestRegistryEndpoints(t *testing.T) {
+	testCases := []struct {
+		name       string
+		mirrorSets []mirrorSet
+		source     string
+		expected   []string
+	}{
+		{
+			name:       "no mirrors",
+			mirrorSets: []mirrorSet{},
+			source:     "mcr.microsoft.com",
+			expected:   []string{"mcr.microsoft.com"},
+		},
+		{
+			name: "mirrors of a different source",
+			mirrorSets: []mirrorSet{
+				{
+					source:             "quay.io",
+					mirrors:            []mirror{{host: "mirror.example.com/quay"}},
+					mirrorSourcePolicy: config.NeverContactSource,
+				},
+			},
+			source:   "mcr.microsoft.com",
+			expected: []string{"mcr.microsoft.com"},
+		},
+		{
+			name: "mirrors with namespaces and ports",
+			mirrorSets: []mirrorSet{
+				{
+					source: "mcr.microsoft.com",
+					mirrors: []mirror{{host: "mirror.example.com:5000/mcr"}, {host: "mirror.example.com:5000/oss"},
+						{host: "mirror.example.net"}},
+					mirrorSourcePolicy: config.AllowContactingSource,
+				},
+			},
+			source:   "mcr.microsoft.com",
+			expected: []string{"mirror.example.com:5000", "mirror.example.net", "mcr.microsoft.com"},
+		},
+		{
+			name: "source never contacted",
+			mirrorSets: []mirrorSet{
+				{
+					source:             "mcr.microsoft.com",
+					mirrors:            []mirror{{host: "mirror.example.com"}},
+					mirrorSourcePolicy: config.NeverContactSource,
+				},
+			},
+			source:   "mcr.microsoft.com",
+			expected: []string{"mirror.example.com"},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, registryEndpoints(test.mirrorSets, test.source))
+		})
+	}
+}
+
 func TestExtractMirrorURL(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -13,4 +13,7 @@ const (
 	Timeout = time.Minute * 10
 	// ResourceChangeTimeout is the total time waited for a change (create/update/delete) to take place
 	ResourceChangeTimeout = time.Minute * 2
+	// DrainAttempts is the number of times cordoning or draining a node is attempted when it fails due to a
+	// transient API error
+	DrainAttempts = 5
 )
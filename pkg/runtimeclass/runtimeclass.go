@@ -0,0 +1,56 @@
+package runtimeclass
+
+import (
+	core "k8s.io/api/core/v1"
+	nodev1 "k8s.io/api/node/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// namePrefix is the prefix of the name of each RuntimeClass managed by WMCO, followed by the Windows build it
+	// schedules pods onto
+	namePrefix = "windows-"
+	// containerdHandler is the containerd runtime handler for process isolated Windows containers
+	containerdHandler = "runhcs-wcow-process"
+	// BuildLabel is a RuntimeClass label identifying the RuntimeClasses managed by WMCO. Its value is the Windows build
+	// the RuntimeClass schedules pods onto.
+	BuildLabel = "windowsmachineconfig.openshift.io/windows-build"
+)
+
+// Name returns the name of the RuntimeClass scheduling pods onto nodes of the given Windows build, such as
+// windows-10.0.20348
+func Name(build string) string {
+	return namePrefix + build
+}
+
+// New returns a RuntimeClass which schedules process isolated pods onto the Windows nodes of the given build, as
+// given by the node.kubernetes.io/windows-build label, tolerating the taint WMCO registers Windows nodes with
+func New(build string) *nodev1.RuntimeClass {
+	return &nodev1.RuntimeClass{
+		ObjectMeta: meta.ObjectMeta{
+			Name:   Name(build),
+			Labels: map[string]string{BuildLabel: build},
+		},
+		Handler: containerdHandler,
+		Scheduling: &nodev1.Scheduling{
+			NodeSelector: map[string]string{
+				core.LabelOSStable:     string(core.Windows),
+				core.LabelWindowsBuild: build,
+			},
+			Tolerations: []core.Toleration{
+				{
+					Key:      "os",
+					Operator: core.TolerationOpEqual,
+					Value:    "Windows",
+					Effect:   core.TaintEffectNoSchedule,
+				},
+			},
+		},
+	}
+}
+
+// IsManaged returns true if the given RuntimeClass was created by WMCO, as opposed to by a user
+func IsManaged(rc *nodev1.RuntimeClass) bool {
+	_, ok := rc.GetLabels()[BuildLabel]
+	return ok
+}
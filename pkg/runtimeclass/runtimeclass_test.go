@@ -0,0 +1,75 @@
+package runtimeclass
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	core "k8s.io/api/core/v1"
+	nodev1 "k8s.io/api/node/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNew(t *testing.T) {
+	testCases := []struct {
+		name         string
+		build        string
+		expectedName string
+	}{
+		{
+			name:         "Windows Server 2019",
+			build:        "10.0.17763",
+			expectedName: "windows-10.0.17763",
+		},
+		{
+			name:         "Windows Server 2022",
+			build:        "10.0.20348",
+			expectedName: "windows-10.0.20348",
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			rc := New(test.build)
+			assert.Equal(t, test.expectedName, rc.GetName())
+			assert.Equal(t, "runhcs-wcow-process", rc.Handler)
+			assert.True(t, IsManaged(rc))
+			require.NotNil(t, rc.Scheduling)
+			assert.Equal(t, map[string]string{core.LabelOSStable: "windows", core.LabelWindowsBuild: test.build},
+				rc.Scheduling.NodeSelector)
+			// the toleration must match the taint WMCO registers kubelet with
+			taint := &core.Taint{Key: "os", Value: "Windows", Effect: core.TaintEffectNoSchedule}
+			require.Len(t, rc.Scheduling.Tolerations, 1)
+			assert.True(t, rc.Scheduling.Tolerations[0].ToleratesTaint(taint))
+		})
+	}
+}
+
+func TestIsManaged(t *testing.T) {
+	testCases := []struct {
+		name     string
+		labels   map[string]string
+		expected bool
+	}{
+		{
+			name:     "no labels",
+			labels:   nil,
+			expected: false,
+		},
+		{
+			name:     "other labels",
+			labels:   map[string]string{"app": "windows"},
+			expected: false,
+		},
+		{
+			name:     "build label",
+			labels:   map[string]string{BuildLabel: "10.0.20348"},
+			expected: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			rc := &nodev1.RuntimeClass{ObjectMeta: meta.ObjectMeta{Name: "windows-10.0.20348", Labels: test.labels}}
+			assert.Equal(t, test.expected, IsManaged(rc))
+		})
+	}
+}
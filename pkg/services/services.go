@@ -9,6 +9,7 @@ import (
 
 	"github.com/openshift/windows-machine-config-operator/pkg/cluster"
 	"github.com/openshift/windows-machine-config-operator/pkg/ignition"
+	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
 	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig"
 	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
 	"github.com/openshift/windows-machine-config-operator/pkg/windows"
@@ -22,16 +23,34 @@ const (
 	// hostnameOverrideVar is the variable that should be replaced with the value of the desired instance hostname
 	hostnameOverrideVar = "HOSTNAME_OVERRIDE"
 	NodeIPVar           = "NODE_IP"
+	// kubeletLogLevelVar, kubeProxyLogLevelVar and containerdLogLevelVar are replaced with the log level of the
+	// respective service, which can be overridden on a node through an annotation
+	kubeletLogLevelVar    = "KUBELET_LOG_LEVEL"
+	kubeProxyLogLevelVar  = "VERBOSITY"
+	containerdLogLevelVar = "CONTAINERD_LOG_LEVEL"
+)
+
+var (
+	// klogLevels are the log verbosities accepted by services that use klog to log
+	klogLevels = []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "10"}
+	// containerdLogLevels are the log levels accepted by containerd
+	containerdLogLevels = []string{"trace", "debug", "info", "warn", "error", "fatal", "panic"}
 )
 
 // GenerateManifest returns the expected state of the Windows service configmap. If debug is true, debug logging
-// will be enabled for services that support it.
+// will be enabled for services that support it. kubelet stores its certificates in the given directory, or in
+// windows.KubeletCertDir if it is empty. kubelet is given the credential provider config if the platform provides
+// credential providers, or if credentialProviders is true. The services log to the given log directory, or to the
+// default log directory if it is empty.
 func GenerateManifest(kubeletArgsFromIgnition map[string]string, vxlanPort string, platform config.PlatformType,
-	debug bool) (*servicescm.Data, error) {
+	debug bool, kubeletCertDir string, credentialProviders bool, logDir string) (*servicescm.Data, error) {
+	logs := windows.NewLogPaths(logDir)
 	windowsExporterServiceCommand := fmt.Sprintf("%s --collectors.enabled "+
-		"cpu,cs,logical_disk,net,os,service,system,textfile,container,memory,cpu_info --web.config.file %s",
-		windows.WindowsExporterPath, windows.TLSConfPath)
-	kubeletConfiguration, err := getKubeletServiceConfiguration(kubeletArgsFromIgnition, debug, platform)
+		"cpu,cs,logical_disk,net,os,service,system,textfile,container,memory,cpu_info --web.config.file %s "+
+		"--collector.textfile.directories %s", windows.WindowsExporterPath, windows.TLSConfPath,
+		windows.WindowsExporterTextfileDir)
+	kubeletConfiguration, err := getKubeletServiceConfiguration(kubeletArgsFromIgnition, debug, platform,
+		kubeletCertDir, credentialProviders, logs.KubeletLog)
 	if err != nil {
 		return nil, fmt.Errorf("could not determine kubelet service configuration spec: %w", err)
 	}
@@ -44,11 +63,11 @@ func GenerateManifest(kubeletArgsFromIgnition map[string]string, vxlanPort strin
 		Bootstrap:              false,
 		Priority:               2,
 	},
-		containerdConfiguration(debug),
+		containerdConfiguration(debug, logs.ContainerdLog),
 		kubeletConfiguration,
-		hybridOverlayConfiguration(vxlanPort, debug),
-		kubeProxyConfiguration(debug),
-		csiProxyConfiguration(debug),
+		hybridOverlayConfiguration(vxlanPort, debug, logs.HybridOverlayLog),
+		kubeProxyConfiguration(debug, logs.KubeProxyLog),
+		csiProxyConfiguration(debug, logs.CSIProxyLog),
 	}
 	if platform == config.AzurePlatformType {
 		*services = append(*services, azureCloudNodeManagerConfiguration())
@@ -62,19 +81,21 @@ func GenerateManifest(kubeletArgsFromIgnition map[string]string, vxlanPort strin
 	return servicescm.NewData(services, files, cluster.GetProxyVars(), watchedEnvVars)
 }
 
-// containerdConfiguration returns the service specification for the Windows containerd service
-func containerdConfiguration(debug bool) servicescm.Service {
-	containerdServiceCmd := fmt.Sprintf("%s --config %s --log-file %s --run-service",
-		windows.ContainerdPath, windows.ContainerdConfPath, windows.ContainerdLogPath)
+// containerdConfiguration returns the service specification for the Windows containerd service, logging to the given
+// file
+func containerdConfiguration(debug bool, logFile string) servicescm.Service {
+	containerdServiceCmd := fmt.Sprintf("%s --config %s --log-file %s --run-service --log-level %s",
+		windows.ContainerdPath, windows.ContainerdConfPath, logFile, containerdLogLevelVar)
+	logLevel := "info"
 	if debug {
-		containerdServiceCmd = containerdServiceCmd + " --log-level debug"
-	} else {
-		containerdServiceCmd = containerdServiceCmd + " --log-level info"
+		logLevel = "debug"
 	}
 	return servicescm.Service{
-		Name:                   windows.ContainerdServiceName,
-		Command:                containerdServiceCmd,
-		NodeVariablesInCommand: nil,
+		Name:    windows.ContainerdServiceName,
+		Command: containerdServiceCmd,
+		NodeVariablesInCommand: []servicescm.NodeCmdArg{
+			logLevelVariable(containerdLogLevelVar, metadata.ContainerdLogLevelAnnotation, logLevel, containerdLogLevels),
+		},
 		PowershellPreScripts: []servicescm.PowershellPreScript{{
 			Path: fmt.Sprintf("%s -BinPath %s", windows.WinDefenderExclusionScriptRemotePath, windows.ContainerdPath),
 		}},
@@ -103,11 +124,10 @@ func azureCloudNodeManagerConfiguration() servicescm.Service {
 	}
 }
 
-// hybridOverlayConfiguration returns the Service definition for hybrid-overlay
-func hybridOverlayConfiguration(vxlanPort string, debug bool) servicescm.Service {
+// hybridOverlayConfiguration returns the Service definition for hybrid-overlay, logging to the given file
+func hybridOverlayConfiguration(vxlanPort string, debug bool, logFile string) servicescm.Service {
 	hybridOverlayServiceCmd := fmt.Sprintf("%s --node NODE_NAME --bootstrap-kubeconfig=%s --cert-dir=%s --cert-duration=24h "+
-		"--windows-service --logfile "+"%s\\hybrid-overlay.log", windows.HybridOverlayPath, windows.KubeconfigPath, windows.CniConfDir,
-		windows.HybridOverlayLogDir)
+		"--windows-service --logfile %s", windows.HybridOverlayPath, windows.KubeconfigPath, windows.CniConfDir, logFile)
 	if len(vxlanPort) > 0 {
 		hybridOverlayServiceCmd = fmt.Sprintf("%s --hybrid-overlay-vxlan-port %s", hybridOverlayServiceCmd, vxlanPort)
 	}
@@ -134,20 +154,23 @@ func hybridOverlayConfiguration(vxlanPort string, debug bool) servicescm.Service
 	}
 }
 
-// kubeProxyConfiguration returns the Service definition for kube-proxy
-func kubeProxyConfiguration(debug bool) servicescm.Service {
-	cmd := fmt.Sprintf("%s -log-file=%s %s --config %s --windows-service", windows.KubeLogRunnerPath, windows.KubeProxyLog,
-		windows.KubeProxyPath, windows.KubeProxyConfigPath)
+// kubeProxyConfiguration returns the Service definition for kube-proxy, logging to the given file
+func kubeProxyConfiguration(debug bool, logFile string) servicescm.Service {
+	// The verbosity is given in the kube-proxy config generated by the pre-script. It is also given on the command
+	// line, where it has no effect, so that kube-proxy is restarted with the new config when the verbosity changes.
+	cmd := fmt.Sprintf("%s -log-file=%s %s --config %s --windows-service --v=%s", windows.KubeLogRunnerPath,
+		logFile, windows.KubeProxyPath, windows.KubeProxyConfigPath, kubeProxyLogLevelVar)
 
 	verbosity := "0"
 	if debug {
 		verbosity = "4"
 	}
-	sanitizedSubnetAnnotation := strings.ReplaceAll(nodeconfig.HybridOverlaySubnet, ".", "\\.")
+	verbosityVar := logLevelVariable(kubeProxyLogLevelVar, metadata.KubeProxyLogLevelAnnotation, verbosity, klogLevels)
 	return servicescm.Service{
-		Name:         windows.KubeProxyServiceName,
-		Command:      cmd,
-		Dependencies: []string{windows.HybridOverlayServiceName},
+		Name:                   windows.KubeProxyServiceName,
+		Command:                cmd,
+		NodeVariablesInCommand: []servicescm.NodeCmdArg{verbosityVar},
+		Dependencies:           []string{windows.HybridOverlayServiceName},
 		PowershellPreScripts: []servicescm.PowershellPreScript{{
 			Path: windows.NetworkConfScriptPath + " -hostnameOverride NODE_NAME -clusterCIDR NODE_SUBNET -kubeConfigPath KUBE_CONFIG_PATH -kubeProxyConfigPath KUBE_PROXY_CONFIG_PATH -verbosity VERBOSITY",
 			NodeArgs: []servicescm.NodeCmdArg{
@@ -157,7 +180,7 @@ func kubeProxyConfiguration(debug bool) servicescm.Service {
 				},
 				{
 					Name:               "NODE_SUBNET",
-					NodeObjectJsonPath: fmt.Sprintf("{.metadata.annotations.%s}", sanitizedSubnetAnnotation),
+					NodeObjectJsonPath: annotationJSONPath(nodeconfig.HybridOverlaySubnet),
 				},
 				{
 					Name:               "KUBE_CONFIG_PATH",
@@ -167,10 +190,7 @@ func kubeProxyConfiguration(debug bool) servicescm.Service {
 					Name:               "KUBE_PROXY_CONFIG_PATH",
 					NodeObjectJsonPath: windows.KubeProxyConfigPath,
 				},
-				{
-					Name:               "VERBOSITY",
-					NodeObjectJsonPath: verbosity,
-				},
+				verbosityVar,
 			},
 		}},
 		Bootstrap: false,
@@ -178,10 +198,9 @@ func kubeProxyConfiguration(debug bool) servicescm.Service {
 	}
 }
 
-// csiProxyConfiguration returns the Service definition for csi-proxy
-func csiProxyConfiguration(debug bool) servicescm.Service {
-	serviceCmd := fmt.Sprintf("%s -log_file=%s -logtostderr=false -windows-service", windows.CSIProxyPath,
-		windows.CSIProxyLog)
+// csiProxyConfiguration returns the Service definition for csi-proxy, logging to the given file
+func csiProxyConfiguration(debug bool, logFile string) servicescm.Service {
+	serviceCmd := fmt.Sprintf("%s -log_file=%s -logtostderr=false -windows-service", windows.CSIProxyPath, logFile)
 	// Set log level
 	serviceCmd = fmt.Sprintf("%s %s", serviceCmd, klogVerbosityArg(debug))
 	return servicescm.Service{
@@ -195,10 +214,10 @@ func csiProxyConfiguration(debug bool) servicescm.Service {
 	}
 }
 
-// getKubeletServiceConfiguration returns the Service definition for the kubelet
+// getKubeletServiceConfiguration returns the Service definition for the kubelet, logging to the given file
 func getKubeletServiceConfiguration(argsFromIginition map[string]string, debug bool,
-	platform config.PlatformType) (servicescm.Service, error) {
-	kubeletArgs, err := generateKubeletArgs(argsFromIginition, debug)
+	platform config.PlatformType, certDir string, credentialProviders bool, logFile string) (servicescm.Service, error) {
+	kubeletArgs, err := generateKubeletArgs(argsFromIginition, certDir)
 	if err != nil {
 		return servicescm.Service{}, err
 	}
@@ -216,37 +235,47 @@ func getKubeletServiceConfiguration(argsFromIginition map[string]string, debug b
 	}
 
 	kubeletServiceCmd := fmt.Sprintf("%s -log-file=%s %s",
-		windows.KubeLogRunnerPath, windows.KubeletLog, windows.KubeletPath)
+		windows.KubeLogRunnerPath, logFile, windows.KubeletPath)
 
 	for _, arg := range kubeletArgs {
 		kubeletServiceCmd += fmt.Sprintf(" %s", arg)
 	}
 
-	// explicitly set node ip and resolves to the first IPv4 address of the default gateway
+	// explicitly set node ip, resolving it in a platform specific way
 	kubeletServiceCmd = fmt.Sprintf("%s --node-ip=%s", kubeletServiceCmd, NodeIPVar)
-	if platform == config.AWSPlatformType {
+	// the ECR credential provider is used on AWS
+	if platform == config.AWSPlatformType || credentialProviders {
 		kubeletServiceCmd = fmt.Sprintf("%s --image-credential-provider-bin-dir=%s --image-credential-provider-config=%s",
 			kubeletServiceCmd, windows.K8sDir, windows.CredentialProviderConfig)
 	}
 	preScripts = append(preScripts, servicescm.PowershellPreScript{
 		VariableName: NodeIPVar,
-		Path: "(Get-NetRoute -DestinationPrefix '0.0.0.0/0' | " +
-			"Get-NetIpAddress -AddressFamily IPv4 -ifIndex {$_.ifIndex}[0]).IPAddress",
+		Path:         getNodeIPCmd(platform),
 	})
+	logLevel := standardLogLevel
+	if debug {
+		logLevel = debugLogLevel
+	}
 	return servicescm.Service{
-		Name:                   windows.KubeletServiceName,
-		Command:                kubeletServiceCmd,
-		Priority:               1,
-		Bootstrap:              true,
-		Dependencies:           []string{windows.ContainerdServiceName},
-		PowershellPreScripts:   preScripts,
-		NodeVariablesInCommand: nil,
+		Name:                 windows.KubeletServiceName,
+		Command:              kubeletServiceCmd,
+		Priority:             1,
+		Bootstrap:            true,
+		Dependencies:         []string{windows.ContainerdServiceName},
+		PowershellPreScripts: preScripts,
+		NodeVariablesInCommand: []servicescm.NodeCmdArg{
+			logLevelVariable(kubeletLogLevelVar, metadata.KubeletLogLevelAnnotation, logLevel, klogLevels),
+		},
 	}, nil
 }
 
-// generateKubeletArgs returns the kubelet args required during initial kubelet start up
-func generateKubeletArgs(argsFromIgnition map[string]string, debug bool) ([]string, error) {
-	certDirectory := "c:\\var\\lib\\kubelet\\pki\\"
+// generateKubeletArgs returns the kubelet args required during initial kubelet start up, with kubelet storing its
+// certificates in the given directory or in windows.KubeletCertDir if it is empty
+func generateKubeletArgs(argsFromIgnition map[string]string, certDir string) ([]string, error) {
+	if certDir == "" {
+		certDir = windows.KubeletCertDir
+	}
+	certDirectory := certDir + "\\"
 	windowsPriorityClass := "ABOVE_NORMAL_PRIORITY_CLASS"
 	// TODO: Removal of deprecated flags to be done in https://issues.redhat.com/browse/WINC-924
 	kubeletArgs := []string{
@@ -262,7 +291,7 @@ func generateKubeletArgs(argsFromIgnition map[string]string, debug bool) ([]stri
 		"--windows-priorityclass=" + windowsPriorityClass,
 	}
 
-	kubeletArgs = append(kubeletArgs, klogVerbosityArg(debug))
+	kubeletArgs = append(kubeletArgs, "--v="+kubeletLogLevelVar)
 	if cloudProvider, ok := argsFromIgnition[ignition.CloudProviderOption]; ok {
 		kubeletArgs = append(kubeletArgs, fmt.Sprintf("--%s=%s", ignition.CloudProviderOption, cloudProvider))
 	}
@@ -284,6 +313,22 @@ func klogVerbosityArg(debug bool) string {
 	}
 }
 
+// logLevelVariable returns a variable which is replaced with the log level given by the Node annotation, or by the
+// given default if the annotation is not set or is not one of the allowed levels
+func logLevelVariable(name, annotation, defaultLevel string, allowedLevels []string) servicescm.NodeCmdArg {
+	return servicescm.NodeCmdArg{
+		Name:               name,
+		NodeObjectJsonPath: annotationJSONPath(annotation),
+		Default:            defaultLevel,
+		AllowedValues:      allowedLevels,
+	}
+}
+
+// annotationJSONPath returns the JSON path of the given annotation within a Node object
+func annotationJSONPath(annotation string) string {
+	return fmt.Sprintf("{.metadata.annotations.%s}", strings.ReplaceAll(annotation, ".", "\\."))
+}
+
 // getHostnameCmd returns the hostname override command for the given platform as needed
 func getHostnameCmd(platformType config.PlatformType) string {
 	switch platformType {
@@ -300,3 +345,14 @@ func getHostnameCmd(platformType config.PlatformType) string {
 		return ""
 	}
 }
+
+// getNodeIPCmd returns the PowerShell command that resolves the IP address kubelet should register the node with
+func getNodeIPCmd(platformType config.PlatformType) string {
+	switch platformType {
+	case config.VSpherePlatformType:
+		return windows.VSphereNodeIPCommand
+	default:
+		// resolves to the first IPv4 address of the default gateway
+		return windows.DefaultRouteIPv4Command
+	}
+}
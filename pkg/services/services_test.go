@@ -1,10 +1,15 @@
 package services
 
 import (
+	"slices"
+	"strings"
 	"testing"
 
 	config "github.com/openshift/api/config/v1"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/windows"
 )
 
 func TestGetHostnameCmd(t *testing.T) {
@@ -41,3 +46,129 @@ func TestGetHostnameCmd(t *testing.T) {
 		})
 	}
 }
+
+func TestGetNodeIPCmd(t *testing.T) {
+	tests := []struct {
+		name         string
+		platformType config.PlatformType
+		expected     string
+	}{
+		{
+			name:         "any platform",
+			platformType: "",
+			expected:     "(Get-NetRoute -DestinationPrefix '0.0.0.0/0' | Get-NetIpAddress -AddressFamily IPv4 -ifIndex {$_.ifIndex}[0]).IPAddress",
+		},
+		{
+			name:         "Nutanix platform",
+			platformType: config.NutanixPlatformType,
+			expected:     "(Get-NetRoute -DestinationPrefix '0.0.0.0/0' | Get-NetIpAddress -AddressFamily IPv4 -ifIndex {$_.ifIndex}[0]).IPAddress",
+		},
+		{
+			name:         "VSphere platform",
+			platformType: config.VSpherePlatformType,
+			expected:     "$ip = & 'C:\\Program Files\\VMware\\VMware Tools\\vmtoolsd.exe' --cmd 'info-get guestinfo.ip' 2>$null; if ($LASTEXITCODE -eq 0 -and $ip) { return $ip.Trim() }; (Get-NetRoute -DestinationPrefix '0.0.0.0/0' | Get-NetIpAddress -AddressFamily IPv4 -ifIndex {$_.ifIndex}[0]).IPAddress",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := getNodeIPCmd(test.platformType)
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+}
+
+func TestGenerateKubeletArgsCertDir(t *testing.T) {
+	tests := []struct {
+		name     string
+		certDir  string
+		expected string
+	}{
+		{
+			name:     "default directory",
+			certDir:  "",
+			expected: windows.KubeletCertDir,
+		},
+		{
+			name:     "custom directory",
+			certDir:  "C:\\var\\audit\\kubelet-pki",
+			expected: "C:\\var\\audit\\kubelet-pki",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args, err := generateKubeletArgs(map[string]string{}, test.certDir)
+			require.NoError(t, err)
+			var certDirArgs []string
+			for _, arg := range args {
+				if strings.HasPrefix(arg, "--cert-dir=") {
+					certDirArgs = append(certDirArgs, strings.TrimPrefix(arg, "--cert-dir="))
+				}
+			}
+			require.Len(t, certDirArgs, 1)
+			assert.Equal(t, test.expected+"\\", certDirArgs[0])
+		})
+	}
+	// kubelet's default certificate directory is removed along with the other WMCO managed directories
+	assert.True(t, slices.Contains(windows.RequiredDirectories, windows.KubeletCertDir))
+}
+
+func TestGetKubeletServiceConfigurationCredentialProviders(t *testing.T) {
+	tests := []struct {
+		name                string
+		platform            config.PlatformType
+		credentialProviders bool
+		expected            bool
+	}{
+		{
+			name:     "AWS",
+			platform: config.AWSPlatformType,
+			expected: true,
+		},
+		{
+			name:     "no credential providers",
+			platform: config.AzurePlatformType,
+			expected: false,
+		},
+		{
+			name:                "credential providers given by the settings",
+			platform:            config.AzurePlatformType,
+			credentialProviders: true,
+			expected:            true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			svc, err := getKubeletServiceConfiguration(map[string]string{}, false, test.platform, "",
+				test.credentialProviders, windows.NewLogPaths("").KubeletLog)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, strings.Contains(svc.Command,
+				"--image-credential-provider-config="+windows.CredentialProviderConfig))
+			assert.Equal(t, test.expected, strings.Contains(svc.Command,
+				"--image-credential-provider-bin-dir="+windows.K8sDir))
+		})
+	}
+}
+
+func TestGenerateManifestLogDir(t *testing.T) {
+	for _, logDir := range []string{"", "D:\\logs"} {
+		t.Run(logDir, func(t *testing.T) {
+			logs := windows.NewLogPaths(logDir)
+			data, err := GenerateManifest(map[string]string{}, "", config.NonePlatformType, false, "", false, logDir)
+			require.NoError(t, err)
+			expected := map[string]string{
+				windows.KubeletServiceName:       "-log-file=" + logs.KubeletLog + " ",
+				windows.KubeProxyServiceName:     "-log-file=" + logs.KubeProxyLog + " ",
+				windows.HybridOverlayServiceName: "--logfile " + logs.HybridOverlayLog,
+				windows.ContainerdServiceName:    "--log-file " + logs.ContainerdLog + " ",
+				"csi-proxy":                      "-log_file=" + logs.CSIProxyLog + " ",
+			}
+			for _, svc := range data.Services {
+				if arg, ok := expected[svc.Name]; ok {
+					assert.Contains(t, svc.Command, arg, svc.Name)
+					delete(expected, svc.Name)
+				}
+			}
+			assert.Empty(t, expected, "services missing from the manifest")
+		})
+	}
+}
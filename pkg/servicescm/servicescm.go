@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"slices"
 	"sort"
 	"strings"
 
@@ -21,6 +22,10 @@ const (
 	// CMDataAnnotation is a Node annotation whose value is the base64 encoded data of current version's service CM
 	// TODO: Remove this when the WICD controller has permissions to watch ConfigMaps
 	CMDataAnnotation = "windowsmachineconfig.openshift.io/cmdata"
+	// ReconcileRequestedAnnotation is a services ConfigMap annotation which WMCO sets to the current time to have WICD
+	// reconcile the services of the instances configured with the ConfigMap, such as when a service was found to have
+	// drifted from its expected configuration
+	ReconcileRequestedAnnotation = "windowsmachineconfig.openshift.io/reconcile-requested"
 	// servicesKey is a required key in the services ConfigMap. The value for this key is a Service object JSON array.
 	servicesKey = "services"
 	// filesKey is a required key in the services ConfigMap. The value for this key is a FileInfo object JSON array.
@@ -49,6 +54,12 @@ type NodeCmdArg struct {
 	// NodeObjectJsonPath is the JSON path of a field within an instance's Node object.
 	// The value of this field is the value of the variable
 	NodeObjectJsonPath string `json:"nodeObjectJsonPath"`
+	// Default is the value of the variable when the field is not present in the Node object, or when the Node does
+	// not exist yet. If this is empty, the field is required.
+	Default string `json:"default,omitempty"`
+	// AllowedValues are the values the field can take. The variable takes the Default value when the field is set to
+	// any other value. Any value is allowed if this is empty.
+	AllowedValues []string `json:"allowedValues,omitempty"`
 }
 
 // PowershellPreScript describes a PowerShell script to be ran and an optional variable to be populated
@@ -256,9 +267,29 @@ func (cmData *Data) validate() error {
 	if err := validateDependencies(cmData.Services); err != nil {
 		return err
 	}
+	if err := validateNodeVariables(cmData.Services); err != nil {
+		return err
+	}
 	return validatePriorities(cmData.Services)
 }
 
+// validateNodeVariables ensures the default value of each node variable with restricted values is an allowed value
+func validateNodeVariables(services []Service) error {
+	for _, svc := range services {
+		nodeVars := slices.Clone(svc.NodeVariablesInCommand)
+		for _, script := range svc.PowershellPreScripts {
+			nodeVars = append(nodeVars, script.NodeArgs...)
+		}
+		for _, nodeVar := range nodeVars {
+			if len(nodeVar.AllowedValues) > 0 && !slices.Contains(nodeVar.AllowedValues, nodeVar.Default) {
+				return fmt.Errorf("service %s variable %s default value %q is not an allowed value", svc.Name,
+					nodeVar.Name, nodeVar.Default)
+			}
+		}
+	}
+	return nil
+}
+
 // ValidateExpectedContent ensures that the given slices are all comprised of only the expected services, files, and
 // environment variables
 func (cmData *Data) ValidateExpectedContent(expected *Data) error {
@@ -323,8 +354,11 @@ func validateDependencies(services []Service) error {
 	}
 
 	for _, bootstrapSvc := range bootstrapServices {
-		if len(bootstrapSvc.NodeVariablesInCommand) > 0 {
-			return fmt.Errorf("bootstrap service %s cannot require node variables in command", bootstrapSvc.Name)
+		// the Node does not exist yet when bootstrap services are started, so their variables must have a default
+		for _, nodeVar := range bootstrapSvc.NodeVariablesInCommand {
+			if nodeVar.Default == "" {
+				return fmt.Errorf("bootstrap service %s cannot require node variables in command", bootstrapSvc.Name)
+			}
 		}
 		if bootstrapSvc.hasDependency(nonBootstrapServices) {
 			return fmt.Errorf("bootstrap service %s cannot depend on non-bootstrap service", bootstrapSvc.Name)
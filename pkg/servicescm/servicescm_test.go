@@ -376,6 +376,25 @@ func TestValidateDependencies(t *testing.T) {
 			},
 			expectedErr: false,
 		},
+		{
+			name: "bootstrap service node variable with default",
+			input: []Service{
+				{
+					Name:    "new-bootstrap-service",
+					Command: "C:\\new-service --v=LOG_LEVEL",
+					NodeVariablesInCommand: []NodeCmdArg{
+						{
+							Name:               "LOG_LEVEL",
+							NodeObjectJsonPath: "{.metadata.annotations.log-level}",
+							Default:            "2",
+						},
+					},
+					Bootstrap: true,
+					Priority:  0,
+				},
+			},
+			expectedErr: false,
+		},
 		{
 			name: "bootstrap service requires node variable in command",
 			input: []Service{
@@ -751,3 +770,60 @@ func TestValidatePriorities(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateNodeVariables(t *testing.T) {
+	testCases := []struct {
+		name        string
+		nodeVar     NodeCmdArg
+		inPreScript bool
+		expectedErr bool
+	}{
+		{
+			name:        "unrestricted variable",
+			nodeVar:     NodeCmdArg{Name: "NODE_NAME", NodeObjectJsonPath: "{.metadata.name}"},
+			expectedErr: false,
+		},
+		{
+			name: "allowed default",
+			nodeVar: NodeCmdArg{Name: "LOG_LEVEL", NodeObjectJsonPath: "{.metadata.annotations.log-level}",
+				Default: "info", AllowedValues: []string{"debug", "info"}},
+			expectedErr: false,
+		},
+		{
+			name: "default not allowed",
+			nodeVar: NodeCmdArg{Name: "LOG_LEVEL", NodeObjectJsonPath: "{.metadata.annotations.log-level}",
+				Default: "verbose", AllowedValues: []string{"debug", "info"}},
+			expectedErr: true,
+		},
+		{
+			name: "restricted variable without default",
+			nodeVar: NodeCmdArg{Name: "LOG_LEVEL", NodeObjectJsonPath: "{.metadata.annotations.log-level}",
+				AllowedValues: []string{"debug", "info"}},
+			expectedErr: true,
+		},
+		{
+			name: "pre-script default not allowed",
+			nodeVar: NodeCmdArg{Name: "VERBOSITY", NodeObjectJsonPath: "{.metadata.annotations.verbosity}",
+				Default: "11", AllowedValues: []string{"0", "2", "4"}},
+			inPreScript: true,
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			svc := Service{Name: "service", Command: "C:\\service"}
+			if test.inPreScript {
+				svc.PowershellPreScripts = []PowershellPreScript{{Path: "C:\\script.ps1", NodeArgs: []NodeCmdArg{test.nodeVar}}}
+			} else {
+				svc.NodeVariablesInCommand = []NodeCmdArg{test.nodeVar}
+			}
+			err := validateNodeVariables([]Service{svc})
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,2009 @@
+package settings
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	// embed the time zone database, so that reboot windows can be given in any time zone
+	_ "time/tzdata"
+
+	"golang.org/x/crypto/ssh"
+	core "k8s.io/api/core/v1"
+	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	kubeTypes "k8s.io/apimachinery/pkg/types"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/validation"
+	cliflag "k8s.io/component-base/cli/flag"
+	kubeletconfigv1 "k8s.io/kubelet/config/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// ConfigMap is the name of the optional ConfigMap, created by the user in the WMCO namespace, which holds
+	// cluster-wide settings applied to all Windows instances configured by WMCO
+	ConfigMap = "wmco-settings"
+	// timezoneKey is an optional key whose value is the ID of the timezone that Windows instances should be set to,
+	// as given by `Get-TimeZone -ListAvailable`. For example: UTC
+	timezoneKey = "timezone"
+	// kubeletTLSMinVersionKey is an optional key whose value is the minimum TLS version kubelet's server accepts, as
+	// named by kubelet's --tls-min-version flag. For example: VersionTLS12
+	kubeletTLSMinVersionKey = "kubeletTLSMinVersion"
+	// kubeletTLSCipherSuitesKey is an optional key whose value is a comma separated list of the cipher suites
+	// kubelet's server accepts, using the IANA names accepted by kubelet's --tls-cipher-suites flag
+	kubeletTLSCipherSuitesKey = "kubeletTLSCipherSuites"
+	// kubeletPodPidsLimitKey is an optional key whose value is the maximum number of PIDs allowed in any pod, as a
+	// positive integer
+	kubeletPodPidsLimitKey = "kubeletPodPidsLimit"
+	// kubeletMaxParallelImagePullsKey is an optional key whose value is the maximum number of images kubelet pulls in
+	// parallel, as a positive integer
+	kubeletMaxParallelImagePullsKey = "kubeletMaxParallelImagePulls"
+	// kubeletEventRecordQPSKey is an optional key whose value is the maximum number of events kubelet creates per
+	// second, as a non-negative integer. kubelet does not limit the rate of events if it is 0.
+	kubeletEventRecordQPSKey = "kubeletEventRecordQPS"
+	// kubeletEventBurstKey is an optional key whose value is the number of events kubelet may create in a burst above
+	// its event rate limit, as a positive integer
+	kubeletEventBurstKey = "kubeletEventBurst"
+	// kubeletSerializeImagePullsKey is an optional key whose value, if true, causes kubelet to pull images one at a
+	// time instead of in parallel
+	kubeletSerializeImagePullsKey = "kubeletSerializeImagePulls"
+	// kubeletMaxPodsKey is an optional key whose value is the maximum number of pods kubelet runs, as a positive
+	// integer. If not given, the maximum depends on the platform of the cluster.
+	kubeletMaxPodsKey = "kubeletMaxPods"
+	// kubeletEvictionMinimumReclaimKey is an optional key whose value is a comma separated list of eviction signals
+	// and the minimum amount of the resource kubelet reclaims once it starts evicting pods, in the format accepted by
+	// kubelet's --eviction-minimum-reclaim flag. For example: memory.available=200Mi,nodefs.available=5%
+	kubeletEvictionMinimumReclaimKey = "kubeletEvictionMinimumReclaim"
+	// kubeletKubeReservedKey is an optional key whose value is a comma separated list of resources and the amount of
+	// each reserved for kubelet and containerd, in the format accepted by kubelet's --kube-reserved flag. For
+	// example: cpu=200m,memory=500Mi
+	kubeletKubeReservedKey = "kubeletKubeReserved"
+	// kubeletCertDirKey is an optional key whose value is the directory in which kubelet stores its client and
+	// serving certificates, as given by kubelet's --cert-dir flag. It must be under kubeletCertDirPrefix.
+	kubeletCertDirKey = "kubeletCertDir"
+	// kubeletStaticPodPathKey is an optional key whose value is the absolute path of the directory kubelet reads static
+	// pod manifests from, as given by kubelet's staticPodPath option
+	kubeletStaticPodPathKey = "kubeletStaticPodPath"
+	// kubeletCgroupsPerQOSKey is an optional key whose value is true if kubelet should create a cgroup hierarchy for
+	// each QoS class. This is experimental on Windows, and is only applied to instances whose Windows build is known
+	// to support it.
+	kubeletCgroupsPerQOSKey = "kubeletCgroupsPerQOS"
+	// kubeletNodeStatusUpdateFrequencyKey is an optional key whose value is how often kubelet posts the status of its
+	// node, as a duration such as 10s
+	kubeletNodeStatusUpdateFrequencyKey = "kubeletNodeStatusUpdateFrequency"
+	// kubeletSyncFrequencyKey is an optional key whose value is how often kubelet syncs the running containers of its
+	// node with their desired state, as a duration such as 1m
+	kubeletSyncFrequencyKey = "kubeletSyncFrequency"
+	// kubeletClusterDNSKey is an optional key whose value is the IP address of the cluster DNS service, for clusters
+	// whose DNS service is not at the 10th address of the service network. It must be within the primary service
+	// network.
+	kubeletClusterDNSKey = "kubeletClusterDNS"
+	// kubeletHardeningKey is an optional key whose value, if true, applies the kubelet hardening profile, setting the
+	// security relevant kubelet options checked by CIS benchmarks to their recommended values
+	kubeletHardeningKey = "kubeletHardening"
+	// kubeletShutdownGracePeriodKey is an optional key whose value is how long kubelet delays the shutdown of its
+	// instance to terminate the pods of the node, as a duration such as 60s
+	kubeletShutdownGracePeriodKey = "kubeletShutdownGracePeriod"
+	// kubeletShutdownGracePeriodCriticalPodsKey is an optional key whose value is the part of the shutdown grace
+	// period reserved for terminating critical pods, as a duration such as 20s. It must not exceed the shutdown grace
+	// period.
+	kubeletShutdownGracePeriodCriticalPodsKey = "kubeletShutdownGracePeriodCriticalPods"
+	// kubeletCredentialProvidersKey is an optional key whose value is a YAML list of the image credential providers
+	// kubelet should use, in the format of the providers field of kubelet's CredentialProviderConfig. The binary of
+	// each provider is transferred from the credential-providers directory of the operator's payload.
+	kubeletCredentialProvidersKey = "kubeletCredentialProviders"
+	// kubeletFeatureGatesKey is an optional key whose value is a comma separated list of gate=state pairs giving the
+	// kubelet feature gates to set in addition to those set by WMCO, such as KubeletTracing=true,SidecarContainers=false
+	kubeletFeatureGatesKey = "kubeletFeatureGates"
+	// kubeletCertDirPrefix is the directory kubelet's certificate directory must be under, so that the certificates
+	// cannot be stored alongside WMCO managed files which are replaced during configuration
+	kubeletCertDirPrefix = "C:\\var\\"
+	// containerdCRIOptionsKey is an optional key whose value is a comma separated list of option=value pairs,
+	// setting options of containerd's CRI plugin. Only the options in containerdCRIOptions can be given. For example:
+	// device_ownership_from_security_context=true,max_concurrent_downloads=5
+	containerdCRIOptionsKey = "containerdCRIOptions"
+	// containerdRuntimeHandlersKey is an optional key whose value is a comma separated list of name=isolation pairs,
+	// registering additional containerd runtime handlers using one of the ContainerdIsolation modes. For example:
+	// runhcs-wcow-hypervisor=hyperv
+	containerdRuntimeHandlersKey = "containerdRuntimeHandlers"
+	// containerdDiscardUnpackedLayersKey is an optional key whose value, if true, makes containerd discard the
+	// compressed layers of images once they are unpacked
+	containerdDiscardUnpackedLayersKey = "containerdDiscardUnpackedLayers"
+	// containerdRootDirKey is an optional key whose value is the absolute path of the directory containerd keeps its
+	// persistent data, such as image content and snapshots, in
+	containerdRootDirKey = "containerdRootDir"
+	// containerdStateDirKey is an optional key whose value is the absolute path of the directory containerd keeps its
+	// transient data, such as the sockets and state of running containers, in
+	containerdStateDirKey = "containerdStateDir"
+	// minFreeMemoryMBKey is an optional key whose value is the free memory, in MB, an instance must have for the
+	// Windows Containers feature to be installed on it
+	minFreeMemoryMBKey = "minFreeMemoryMB"
+	// pagefileMinSizeMBKey is an optional key whose value is the minimum size, in MB, of the pagefile on instances
+	pagefileMinSizeMBKey = "pagefileMinSizeMB"
+	// crashDumpTypeKey is an optional key whose value is the type of memory dump instances write when they crash, one
+	// of crashDumpTypes
+	crashDumpTypeKey = "crashDumpType"
+	// schannelDisabledProtocolsKey is an optional key whose value is a comma separated list of the
+	// deprecatedSChannelProtocols to disable on instances
+	schannelDisabledProtocolsKey = "schannelDisabledProtocols"
+	// addK8sDirsToPathKey is an optional key whose value, when "true", causes the directories holding the Kubernetes
+	// and containerd binaries to be added to the system PATH of instances
+	addK8sDirsToPathKey = "addK8sDirsToPath"
+	// logDirKey is an optional key whose value is the absolute path of the directory holding the log directories of
+	// the services on instances
+	logDirKey = "logDir"
+	// wicdConfigurationTimeoutKey is an optional key whose value is how long WMCO waits for WICD to configure the
+	// services of a node, as a duration such as 10m
+	wicdConfigurationTimeoutKey = "wicdConfigurationTimeout"
+	// configurationTimeoutKey is an optional key whose value is how long WMCO lets a configuration of an instance run
+	// before aborting it, as a duration such as 1h
+	configurationTimeoutKey = "configurationTimeout"
+	// upgradeStallTimeoutKey is an optional key whose value is how long the version of a node can differ from its
+	// desired version before WMCO restarts WICD on it, as a duration such as 30m
+	upgradeStallTimeoutKey = "upgradeStallTimeout"
+	// rebootDetectionDelayKey is an optional key whose value is how long WMCO waits after requesting an instance reboot
+	// before checking whether the instance has gone down, as a duration such as 10s
+	rebootDetectionDelayKey = "rebootDetectionDelay"
+	// wicdRecoveryDelaysKey is an optional key whose value is a comma separated list of how long the Windows service
+	// manager waits before each successive restart of WICD after it crashes, as durations such as 10s
+	wicdRecoveryDelaysKey = "wicdRecoveryDelays"
+	// wicdRecoveryResetPeriodKey is an optional key whose value is how long WICD must run without crashing for the
+	// next restart to use the first of the WICD recovery delays again, as a duration such as 5m
+	wicdRecoveryResetPeriodKey = "wicdRecoveryResetPeriod"
+	// wicdServiceAccountKey is an optional key whose value is the account the WICD service logs on as, either the
+	// virtual account of the service or a group managed service account. WICD runs as LocalSystem if this is not given.
+	wicdServiceAccountKey = "wicdServiceAccount"
+	// maxWICDRecoveryDelays is the maximum number of WICD recovery delays
+	maxWICDRecoveryDelays = 10
+	// maxWICDRecoveryDelay is the maximum WICD recovery delay, so that a crashed WICD is not left stopped for long
+	maxWICDRecoveryDelay = time.Hour
+	// minWICDRecoveryResetPeriod is the minimum WICD recovery reset period, below which a crash looping WICD would be
+	// restarted with the first delay over and over
+	minWICDRecoveryResetPeriod = time.Minute
+	// maxWICDRecoveryResetPeriod is the maximum WICD recovery reset period
+	maxWICDRecoveryResetPeriod = 24 * time.Hour
+	// rebootDetectionIntervalKey is an optional key whose value is how often WMCO checks whether a rebooting instance
+	// has gone down, as a duration such as 5s
+	rebootDetectionIntervalKey = "rebootDetectionInterval"
+	// ntpServersKey is an optional key whose value is a comma separated list of the hostnames or IP addresses of the
+	// NTP servers instances should synchronize their time with
+	ntpServersKey = "ntpServers"
+	// leaveNodesCordonedKey is an optional key whose value, when "true", causes configured nodes to be left cordoned
+	// until they are manually uncordoned
+	leaveNodesCordonedKey = "leaveNodesCordoned"
+	// postConfigurationValidationConfigMapKey is an optional key whose value is the name of a ConfigMap, in the WMCO
+	// namespace, holding the PowerShell script run on instances once they are configured, under the
+	// PostConfigurationValidationScriptKey key. Nodes are only uncordoned if the script succeeds.
+	postConfigurationValidationConfigMapKey = "postConfigurationValidationConfigMap"
+	// postConfigurationValidationTimeoutKey is an optional key whose value is how long the post-configuration
+	// validation script can run before it is stopped and the validation fails, as a duration such as 5m
+	postConfigurationValidationTimeoutKey = "postConfigurationValidationTimeout"
+	// hostProcessHelperImageKey is an optional key whose value is the container image run as a host-process pod on
+	// every Windows node. No helper pods are deployed if this is not given.
+	hostProcessHelperImageKey = "hostProcessHelperImage"
+	// manageRuntimeClassesKey is an optional key whose value, when "true", causes WMCO to manage a RuntimeClass for
+	// each Windows build of the Windows nodes in the cluster
+	manageRuntimeClassesKey = "manageRuntimeClasses"
+	// powerPlanKey is an optional key whose value is the power plan instances should use, either as one of the names
+	// in powerPlans or as the GUID of a power plan, as listed by `powercfg /list`
+	powerPlanKey = "powerPlan"
+	// dynamicPortRangeKey is an optional key whose value is the range of ports instances allocate the local ports of
+	// outgoing TCP connections and UDP sockets from, such as 10000-19999. The range of instances is left unchanged if
+	// this is not given.
+	dynamicPortRangeKey = "dynamicPortRange"
+	// firewallProfilesKey is an optional key whose value is a comma separated list of profile=state pairs giving
+	// whether each of the Windows firewall profiles named by the FirewallProfile constants is enabled, such as
+	// Domain=true,Public=false. The state of profiles which are not given is left unchanged.
+	firewallProfilesKey = "firewallProfiles"
+	// nonInteractiveDesktopHeapKBKey is an optional key whose value is the size, in KB, of the desktop heap of each
+	// non-interactive desktop on instances, which bounds the number of processes services such as containerd can run
+	nonInteractiveDesktopHeapKBKey = "nonInteractiveDesktopHeapKB"
+	// interactiveSessionsOnRebootKey is an optional key whose value is what WMCO does when a node which must be
+	// rebooted has users logged on interactively, as one of the InteractiveSessions constants
+	interactiveSessionsOnRebootKey = "interactiveSessionsOnReboot"
+	// rebootWindowsKey is an optional key whose value is a comma separated list of the time of day ranges during which
+	// nodes may be rebooted, such as 22:00-04:00. Nodes may be rebooted at any time if this is not given.
+	rebootWindowsKey = "rebootWindows"
+	// rebootWindowsTimezoneKey is an optional key whose value is the IANA name of the time zone the reboot windows are
+	// given in, such as America/New_York. UTC is used if this is not given.
+	rebootWindowsTimezoneKey = "rebootWindowsTimezone"
+	// tempFileCleanupPolicyKey is an optional key whose value is when WMCO removes stale files from its temporary
+	// directory on instances, as one of the TempFileCleanup constants
+	tempFileCleanupPolicyKey = "tempFileCleanupPolicy"
+	// externalConnectivityCheckPortKey is an optional key whose value is the TCP port WMCO connects to on the external
+	// address of each configured node, to verify the node is reachable from outside of the cluster network. No check
+	// is done if this is not given.
+	externalConnectivityCheckPortKey = "externalConnectivityCheckPort"
+	// externalConnectivityCheckRetriesKey is an optional key whose value is the number of times the external
+	// connectivity of a node is checked before it is reported unreachable
+	externalConnectivityCheckRetriesKey = "externalConnectivityCheckRetries"
+	// externalConnectivityCheckTimeoutKey is an optional key whose value is how long each external connectivity check
+	// waits for the connection to be established, as a duration such as 10s
+	externalConnectivityCheckTimeoutKey = "externalConnectivityCheckTimeout"
+	// maxExternalConnectivityCheckRetries is the maximum number of external connectivity checks of a node before it is
+	// reported unreachable
+	maxExternalConnectivityCheckRetries = 10
+	// maxExternalConnectivityCheckTimeout is the maximum time each external connectivity check waits, as the check
+	// holds up the reconciliation of the node
+	maxExternalConnectivityCheckTimeout = 30 * time.Second
+	// hnsOutboundNATExceptionsKey is an optional key whose value is a comma separated list of IPv4 CIDRs which pod
+	// traffic is sent to without being NATed to the node's address, in addition to the cluster's service network
+	hnsOutboundNATExceptionsKey = "hnsOutboundNATExceptions"
+	// hnsACLPoliciesKey is an optional key whose value is a semicolon separated list of ACL rules applied to the HNS
+	// endpoint of each pod. Each rule is a comma separated list of field=value pairs, for example:
+	// action=Block,direction=Out,protocol=TCP,remoteAddress=169.254.169.254/32,remotePort=80,priority=200
+	hnsACLPoliciesKey = "hnsACLPolicies"
+	// sshCiphersKey is an optional key whose value is a comma separated list of the ciphers WMCO offers when
+	// connecting to instances over SSH, in order of preference
+	sshCiphersKey = "sshCiphers"
+	// sshKeyExchangesKey is an optional key whose value is a comma separated list of the key exchange algorithms WMCO
+	// offers when connecting to instances over SSH, in order of preference
+	sshKeyExchangesKey = "sshKeyExchanges"
+	// sshMACsKey is an optional key whose value is a comma separated list of the MAC algorithms WMCO offers when
+	// connecting to instances over SSH, in order of preference
+	sshMACsKey = "sshMACs"
+	// sshHostKeyAlgorithmsKey is an optional key whose value is a comma separated list of the host key algorithms WMCO
+	// accepts from instances over SSH, in order of preference
+	sshHostKeyAlgorithmsKey = "sshHostKeyAlgorithms"
+	// sshKnownHostsKey is an optional key whose value gives the host keys expected from instances over SSH, one per
+	// line in the OpenSSH known_hosts format. Hashed host names and host patterns are not supported.
+	sshKnownHostsKey = "sshKnownHosts"
+	// sshHostKeyPolicyKey is an optional key whose value is how the host keys of instances are verified when
+	// connecting to them over SSH, as one of the SSHHostKeyPolicy constants
+	sshHostKeyPolicyKey = "sshHostKeyPolicy"
+	// sftpMaxPacketSizeKey is an optional key whose value is the maximum number of bytes of file data in each SFTP
+	// read or write request made to instances, from MinSFTPMaxPacketSize to MaxSFTPMaxPacketSize
+	sftpMaxPacketSizeKey = "sftpMaxPacketSize"
+	// sftpMaxConcurrentRequestsKey is an optional key whose value is the maximum number of SFTP write requests in
+	// flight for each file transferred to instances, from 1 to MaxSFTPMaxConcurrentRequests
+	sftpMaxConcurrentRequestsKey = "sftpMaxConcurrentRequests"
+	// drainMaxAttemptsKey is an optional key whose value is the number of times cordoning or draining a node is
+	// attempted when it fails due to a transient API error
+	drainMaxAttemptsKey = "drainMaxAttempts"
+	// trustedCABundleSyncConcurrencyKey is an optional key whose value is the maximum number of nodes the trusted CA
+	// bundle is synced to at the same time
+	trustedCABundleSyncConcurrencyKey = "trustedCABundleSyncConcurrency"
+	// drainProtectedPodSelectorKey is an optional key whose value is a label selector matching the pods which are
+	// protected when a node is drained, as given by drainProtectedPodPolicyKey
+	drainProtectedPodSelectorKey = "drainProtectedPodSelector"
+	// drainProtectedPodPolicyKey is an optional key whose value is one of the DrainProtectedPodPolicy constants
+	drainProtectedPodPolicyKey = "drainProtectedPodPolicy"
+	// drainProtectedPodGracePeriodKey is an optional key whose value is the grace period given to protected pods when
+	// they are evicted, as a positive duration
+	drainProtectedPodGracePeriodKey = "drainProtectedPodGracePeriod"
+)
+
+const (
+	// MinDynamicPort is the lowest port of a dynamic port range accepted by Windows
+	MinDynamicPort = 1025
+	// MinDynamicPortRangeSize is the smallest number of ports of a dynamic port range accepted by Windows
+	MinDynamicPortRangeSize = 255
+	// MinSFTPMaxPacketSize is the smallest value of the sftpMaxPacketSize setting
+	MinSFTPMaxPacketSize = 1024
+	// MaxSFTPMaxPacketSize is the largest value of the sftpMaxPacketSize setting. The SFTP server of the oldest
+	// OpenSSH release shipped with Windows Server returns at most 64KiB per read, and the SFTP client does not handle
+	// shorter reads than it requested, so larger packets would corrupt files read from instances.
+	MaxSFTPMaxPacketSize = 65536
+	// MaxSFTPMaxConcurrentRequests is the largest value of the sftpMaxConcurrentRequests setting
+	MaxSFTPMaxConcurrentRequests = 1024
+)
+
+const (
+	// SSHHostKeyPolicyVerifyKnown causes the host key of an instance given in the known hosts to be verified, while
+	// other instances are connected to without verifying their host key. This is the default.
+	SSHHostKeyPolicyVerifyKnown = "VerifyKnown"
+	// SSHHostKeyPolicyStrict causes the host key of every instance to be verified, refusing to connect to instances
+	// which are not in the known hosts
+	SSHHostKeyPolicyStrict = "Strict"
+	// SSHHostKeyPolicyIgnore causes instances to be connected to without verifying their host key
+	SSHHostKeyPolicyIgnore = "Ignore"
+)
+
+const (
+	// FirewallProfileDomain is the Windows firewall profile applied to networks the instance's domain is reachable on
+	FirewallProfileDomain = "Domain"
+	// FirewallProfilePrivate is the Windows firewall profile applied to networks marked as private
+	FirewallProfilePrivate = "Private"
+	// FirewallProfilePublic is the Windows firewall profile applied to all other networks
+	FirewallProfilePublic = "Public"
+)
+
+const (
+	// InteractiveSessionsIgnore causes nodes to be rebooted without checking for interactive sessions
+	InteractiveSessionsIgnore = "Ignore"
+	// InteractiveSessionsWarn causes the users logged on to a node to be logged before it is rebooted. This is the
+	// default.
+	InteractiveSessionsWarn = "Warn"
+	// InteractiveSessionsRefuse causes the reboot of a node to be retried until no users are logged on to it
+	InteractiveSessionsRefuse = "Refuse"
+)
+
+const (
+	// DrainProtectedPodPolicyEvictLast causes the pods matching the drain protected pod selector to be evicted once
+	// all other pods of a node have been evicted. This is the default.
+	DrainProtectedPodPolicyEvictLast = "EvictLast"
+	// DrainProtectedPodPolicyRefuse causes a node running pods matching the drain protected pod selector to not be
+	// drained, with the drain retried until the pods are removed from the node
+	DrainProtectedPodPolicyRefuse = "Refuse"
+)
+
+const (
+	// TempFileCleanupAlways causes stale temporary files to be removed when a node is configured, and periodically
+	// while it is running
+	TempFileCleanupAlways = "Always"
+	// TempFileCleanupNever causes temporary files to only be removed when a node is deconfigured
+	TempFileCleanupNever = "Never"
+	// TempFileCleanupOnVersionChange causes stale temporary files to be removed when a node is configured, which
+	// includes being upgraded to a new WMCO version. This is the default.
+	TempFileCleanupOnVersionChange = "OnVersionChange"
+)
+
+const (
+	// HNSACLActionAllow allows the traffic matched by an HNS ACL rule
+	HNSACLActionAllow = "Allow"
+	// HNSACLActionBlock drops the traffic matched by an HNS ACL rule
+	HNSACLActionBlock = "Block"
+	// HNSACLDirectionIn matches traffic received by pods
+	HNSACLDirectionIn = "In"
+	// HNSACLDirectionOut matches traffic sent by pods
+	HNSACLDirectionOut = "Out"
+	// maxHNSACLPriority is the highest priority an HNS ACL rule can be given
+	maxHNSACLPriority = 65500
+)
+
+// hnsACLProtocols maps the protocols HNS ACL rules can be restricted to, to their IANA protocol number
+var hnsACLProtocols = map[string]string{"TCP": "6", "UDP": "17", "ICMP": "1"}
+
+// HNSACLPolicy is an access control rule applied to the HNS endpoint of each pod
+type HNSACLPolicy struct {
+	// Action is either HNSACLActionAllow or HNSACLActionBlock
+	Action string
+	// Direction is either HNSACLDirectionIn or HNSACLDirectionOut
+	Direction string
+	// Protocol is the IANA number of the protocol the rule applies to. The rule applies to all protocols if this is
+	// empty.
+	Protocol string
+	// RemoteAddress is the CIDR of the remote addresses the rule applies to. The rule applies to all addresses if
+	// this is empty.
+	RemoteAddress string
+	// LocalPort is the pod port the rule applies to. The rule applies to all local ports if this is 0.
+	LocalPort int
+	// RemotePort is the remote port the rule applies to. The rule applies to all remote ports if this is 0.
+	RemotePort int
+	// Priority orders the rule against the other rules of the endpoint, rules with a lower value taking precedence
+	Priority int
+}
+
+// DefaultKubeletTLSMinVersion is the minimum TLS version used by kubelet if none is given. This matches the minimum
+// version of the OpenShift Intermediate TLS profile used by Linux workers.
+const DefaultKubeletTLSMinVersion = "VersionTLS12"
+
+// DefaultKubeletMaxParallelImagePulls is the maximum number of images kubelet pulls in parallel if no maximum is given.
+// This bounds the disk and network load of parallel image pulls, which are large for Windows images.
+const DefaultKubeletMaxParallelImagePulls = int32(5)
+
+// DefaultConfigurationTimeout is how long a configuration of an instance can run before it is aborted if no timeout is
+// given. It is well above the time configuring an instance takes, including the instance restarts some settings
+// require, so that it only stops configurations which are stuck.
+const DefaultConfigurationTimeout = time.Hour
+
+// PostConfigurationValidationScriptKey is the key of the post-configuration validation ConfigMap holding the
+// PowerShell script to run
+const PostConfigurationValidationScriptKey = "validate.ps1"
+
+// DefaultPostConfigurationValidationTimeout is how long the post-configuration validation script can run if no timeout
+// is given
+const DefaultPostConfigurationValidationTimeout = 10 * time.Minute
+
+// DefaultTrustedCABundleSyncConcurrency is the maximum number of nodes the trusted CA bundle is synced to at the same
+// time if no maximum is given. Each sync holds an SSH connection to its node, so this bounds the number of connections
+// opened when the bundle changes.
+const DefaultTrustedCABundleSyncConcurrency = 5
+
+// KubeletEvictionSignals are the eviction signals supported by kubelet on Windows. Signals such as nodefs.inodesFree
+// and pid.available are only implemented on Linux.
+var KubeletEvictionSignals = []string{"memory.available", "nodefs.available", "imagefs.available"}
+
+// DefaultKubeletEvictionMinimumReclaim is the minimum amount of each resource kubelet reclaims when evicting pods, if
+// none is given for that eviction signal. Without a minimum, kubelet evicts just enough pods to cross back over the
+// eviction threshold and so repeatedly hits it again on a busy node.
+var DefaultKubeletEvictionMinimumReclaim = map[string]string{
+	"memory.available":  "100Mi",
+	"nodefs.available":  "500Mi",
+	"imagefs.available": "2Gi",
+}
+
+// KubeletReservedResources are the resources which can be reserved for system and Kubernetes daemons on Windows nodes
+var KubeletReservedResources = []string{"cpu", "memory", "ephemeral-storage"}
+
+// RotateKubeletServerCertificateGate is the kubelet feature gate WMCO always enables, as the serving certificates of
+// Windows nodes are requested and rotated by kubelet
+const RotateKubeletServerCertificateGate = "RotateKubeletServerCertificate"
+
+// KubeletFeatureGates are the kubelet feature gates which can be set on Windows nodes. kubelet fails to start if given
+// a gate it does not know, or a value for a gate locked to its default, so only the alpha and beta gates of the
+// shipped kubelet which are relevant to Windows are accepted. WindowsGracefulNodeShutdown is not included, as it is
+// enabled by WMCO along with kubeletShutdownGracePeriod.
+var KubeletFeatureGates = []string{
+	RotateKubeletServerCertificateGate,
+	"ContainerCheckpoint",
+	"DisableKubeletCloudCredentialProviders",
+	"EventedPLEG",
+	"ImageMaximumGCAge",
+	"InPlacePodVerticalScaling",
+	"KubeletCgroupDriverFromCRI",
+	"KubeletPodResourcesDynamicResources",
+	"KubeletPodResourcesGet",
+	"KubeletSeparateDiskGC",
+	"KubeletTracing",
+	"PodAndContainerStatsFromCRI",
+	"PodLifecycleSleepAction",
+	"PodReadyToStartContainersCondition",
+	"RecursiveReadOnlyMounts",
+	"SidecarContainers",
+	"WindowsHostNetwork",
+}
+
+// DefaultKubeletSystemReserved is the amount of each resource reserved for the operating system's daemons
+var DefaultKubeletSystemReserved = map[string]string{
+	"cpu":               "300m",
+	"memory":            "600Mi",
+	"ephemeral-storage": "500Mi",
+}
+
+// DefaultKubeletKubeReserved is the amount of each resource reserved for kubelet and containerd, if none is given for
+// that resource. Together with DefaultKubeletSystemReserved, this reserves the amount previously reserved for the
+// system alone, so that the allocatable resources of existing nodes are unchanged.
+var DefaultKubeletKubeReserved = map[string]string{
+	"cpu":               "200m",
+	"memory":            "400Mi",
+	"ephemeral-storage": "500Mi",
+}
+
+// DefaultKubeletTLSCipherSuites are the cipher suites used by kubelet if none are given. These are the TLS 1.2 cipher
+// suites of the OpenShift Intermediate TLS profile which are supported by kubelet, using their IANA names.
+var DefaultKubeletTLSCipherSuites = []string{
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+}
+
+// DefaultContainerdRuntimeHandler is the containerd runtime handler, running process isolated containers, which is
+// always present in containerd's config
+const DefaultContainerdRuntimeHandler = "runhcs-wcow-process"
+
+const (
+	// ContainerdIsolationProcess runs the containers of a runtime handler as processes sharing the host's kernel
+	ContainerdIsolationProcess = "process"
+	// ContainerdIsolationHyperV runs the containers of a runtime handler in a Hyper-V utility VM. The instance must
+	// have the Hyper-V feature installed.
+	ContainerdIsolationHyperV = "hyperv"
+)
+
+// criOptionType is the type of the value of a containerd CRI plugin option
+type criOptionType int
+
+const (
+	criOptionBool criOptionType = iota
+	criOptionPositiveInt
+	criOptionDuration
+)
+
+// containerdCRIOptions maps the containerd CRI plugin options which can be set through the settings ConfigMap to the
+// type of their value. These are options known to be safe to change on Windows nodes, options which could prevent
+// containerd from running pods, such as the sandbox image or CNI configuration, are not included.
+var containerdCRIOptions = map[string]criOptionType{
+	"device_ownership_from_security_context": criOptionBool,
+	"ignore_image_defined_volumes":           criOptionBool,
+	"max_concurrent_downloads":               criOptionPositiveInt,
+	"max_container_log_line_size":            criOptionPositiveInt,
+	"stats_collect_period":                   criOptionPositiveInt,
+	"image_pull_progress_timeout":            criOptionDuration,
+	"stream_idle_timeout":                    criOptionDuration,
+	"drain_exec_sync_io_timeout":             criOptionDuration,
+}
+
+// ErrInvalid is wrapped by the error returned by Get if the settings ConfigMap exists but is not valid
+var ErrInvalid = errors.New("invalid settings")
+
+// timezoneIDRegex matches the characters allowed in a Windows timezone ID such as "Central Europe Standard Time" or
+// "UTC-11". This is a sanity check only, the timezone ID is validated against the instance's list of timezones.
+var timezoneIDRegex = regexp.MustCompile(`^[a-zA-Z0-9 ()+.\-_]+$`)
+
+// powerPlans maps the names of the power plans available on all Windows Server instances to their GUIDs
+var powerPlans = map[string]string{
+	"Balanced":        "381b4222-f694-41f0-9685-ff5bb260df2e",
+	"HighPerformance": "8c5e7fda-e8bf-4a96-9a85-a6e23a8c635c",
+	"PowerSaver":      "a1841308-3541-4fab-bc81-f71556f20b4a",
+}
+
+// CrashDumpTypeNone is the crash dump type which disables crash dumps
+const CrashDumpTypeNone = "None"
+
+// crashDumpTypes are the accepted crash dump types, named as in the Startup and Recovery settings of Windows
+var crashDumpTypes = []string{CrashDumpTypeNone, "Small", "Kernel", "Automatic", "Complete"}
+
+// deprecatedSChannelProtocols are the SChannel protocols which can be disabled. TLS 1.2 and later cannot be disabled,
+// as instances would be left without a protocol accepted by current clients and servers.
+var deprecatedSChannelProtocols = []string{"SSL2.0", "SSL3.0", "TLS1.0", "TLS1.1"}
+
+// localSystemAccount is the account services run as by default
+const localSystemAccount = "LocalSystem"
+
+// virtualAccountRegex matches the virtual account of a service, such as NT SERVICE\windows-instance-config-daemon
+var virtualAccountRegex = regexp.MustCompile(`(?i)^NT SERVICE\\[a-z0-9._-]+$`)
+
+// managedServiceAccountRegex matches a group managed service account, whose name ends with a $, such as CONTOSO\wicd$.
+// The account names of group managed service accounts are at most 15 characters long, excluding the $.
+var managedServiceAccountRegex = regexp.MustCompile(`(?i)^[a-z0-9][a-z0-9.-]*\\[a-z0-9._-]{1,15}\$$`)
+
+// powerPlanGUIDRegex matches a power plan GUID such as "8c5e7fda-e8bf-4a96-9a85-a6e23a8c635c"
+var powerPlanGUIDRegex = regexp.MustCompile(`(?i)^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// windowsPathRegex matches an absolute Windows directory path such as "C:\var\lib\kubelet\pki", without relative
+// components
+var windowsPathRegex = regexp.MustCompile(`^[a-zA-Z]:(\\[a-zA-Z0-9 _\-]+(\.[a-zA-Z0-9 _\-]+)*)+$`)
+
+// imageRegex matches the characters allowed in a container image reference such as "quay.io/org/image:tag" or
+// "quay.io/org/image@sha256:<digest>". This is a sanity check only, the reference is resolved when the image is pulled.
+var imageRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._\-/:@]*$`)
+
+// credentialProviderNameRegex matches the file name of a credential provider binary such as "acr-credential-provider",
+// which kubelet looks up in its credential provider binary directory
+var credentialProviderNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._\-]*$`)
+
+// credentialProviderAPIVersions are the credential provider API versions supported by kubelet
+var credentialProviderAPIVersions = []string{"credentialprovider.kubelet.k8s.io/v1",
+	"credentialprovider.kubelet.k8s.io/v1beta1"}
+
+// The SSH algorithms supported by golang.org/x/crypto/ssh, which WMCO connects to instances with. The library does not
+// export these, so they must be kept in sync with it when it is updated.
+var (
+	// supportedSSHCiphers are the ciphers supported by the SSH client
+	supportedSSHCiphers = []string{"aes128-ctr", "aes192-ctr", "aes256-ctr", "aes128-gcm@openssh.com",
+		"aes256-gcm@openssh.com", "chacha20-poly1305@openssh.com", "arcfour256", "arcfour128", "arcfour", "aes128-cbc",
+		"3des-cbc"}
+	// supportedSSHKeyExchanges are the key exchange algorithms supported by the SSH client
+	supportedSSHKeyExchanges = []string{"curve25519-sha256", "curve25519-sha256@libssh.org", "ecdh-sha2-nistp256",
+		"ecdh-sha2-nistp384", "ecdh-sha2-nistp521", "diffie-hellman-group14-sha256", "diffie-hellman-group16-sha512",
+		"diffie-hellman-group14-sha1", "diffie-hellman-group1-sha1", "diffie-hellman-group-exchange-sha256",
+		"diffie-hellman-group-exchange-sha1"}
+	// supportedSSHMACs are the MAC algorithms supported by the SSH client
+	supportedSSHMACs = []string{"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com", "hmac-sha2-256",
+		"hmac-sha2-512", "hmac-sha1", "hmac-sha1-96"}
+	// supportedSSHHostKeyAlgorithms are the host key algorithms supported by the SSH client
+	supportedSSHHostKeyAlgorithms = []string{ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSAv01,
+		ssh.CertAlgoDSAv01, ssh.CertAlgoECDSA256v01, ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01,
+		ssh.CertAlgoED25519v01, ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521, ssh.KeyAlgoRSASHA256,
+		ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSA, ssh.KeyAlgoDSA, ssh.KeyAlgoED25519}
+)
+
+// TimeRange is a range of the time of day, as offsets from midnight. End is before Start for a range which spans
+// midnight.
+type TimeRange struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// contains returns true if the given offset from midnight is within the range
+func (r TimeRange) contains(offset time.Duration) bool {
+	if r.Start < r.End {
+		return offset >= r.Start && offset < r.End
+	}
+	return offset >= r.Start || offset < r.End
+}
+
+// Settings holds the user provided configuration options for Windows instances. A zero value for any field means that
+// WMCO should leave the associated setting unchanged on the instance.
+type Settings struct {
+	// Timezone is the ID of the timezone that should be set on the instance
+	Timezone string
+	// KubeletTLSMinVersion is the minimum TLS version kubelet should accept. DefaultKubeletTLSMinVersion is used if
+	// this is empty.
+	KubeletTLSMinVersion string
+	// KubeletTLSCipherSuites are the cipher suites kubelet should accept. DefaultKubeletTLSCipherSuites are used if
+	// this is empty.
+	KubeletTLSCipherSuites []string
+	// KubeletPodPidsLimit is the maximum number of PIDs allowed in any pod. No limit is set if this is 0.
+	KubeletPodPidsLimit int64
+	// KubeletMaxParallelImagePulls is the maximum number of images kubelet pulls in parallel.
+	// DefaultKubeletMaxParallelImagePulls is used if this is 0.
+	KubeletMaxParallelImagePulls int32
+	// KubeletSerializeImagePulls causes kubelet to pull images one at a time
+	KubeletSerializeImagePulls bool
+	// KubeletEventRecordQPS is the maximum number of events kubelet creates per second, with 0 meaning no limit.
+	// kubelet's default is used if this is nil.
+	KubeletEventRecordQPS *int32
+	// KubeletEventBurst is the number of events kubelet may create in a burst above KubeletEventRecordQPS. kubelet's
+	// default is used if this is 0.
+	KubeletEventBurst int32
+	// KubeletMaxPods is the maximum number of pods kubelet runs. A default for the cluster's platform is used if this
+	// is 0.
+	KubeletMaxPods int32
+	// KubeletEvictionMinimumReclaim maps eviction signals to the minimum amount of the resource kubelet reclaims when
+	// evicting pods. DefaultKubeletEvictionMinimumReclaim is used for any signal not given.
+	KubeletEvictionMinimumReclaim map[string]string
+	// KubeletKubeReserved maps resources to the amount of the resource reserved for kubelet and containerd.
+	// DefaultKubeletKubeReserved is used for any resource not given.
+	KubeletKubeReserved map[string]string
+	// KubeletCertDir is the directory in which kubelet stores its client and serving certificates. kubelet's default
+	// certificate directory is used if this is empty.
+	KubeletCertDir string
+	// KubeletStaticPodPath is the directory kubelet reads static pod manifests from. kubelet does not run static pods
+	// if this is empty.
+	KubeletStaticPodPath string
+	// KubeletCgroupsPerQOS enables kubelet's cgroupsPerQOS option on instances whose Windows build supports it
+	KubeletCgroupsPerQOS bool
+	// KubeletNodeStatusUpdateFrequency is how often kubelet posts the status of its node. kubelet's default frequency
+	// is used if this is 0.
+	KubeletNodeStatusUpdateFrequency time.Duration
+	// KubeletSyncFrequency is how often kubelet syncs the running containers of its node with their desired state.
+	// kubelet's default frequency is used if this is 0.
+	KubeletSyncFrequency time.Duration
+	// KubeletClusterDNS is the IP address of the cluster DNS service. If empty, the address is derived from the
+	// service network.
+	KubeletClusterDNS string
+	// KubeletHardening enables the kubelet hardening profile
+	KubeletHardening bool
+	// KubeletShutdownGracePeriod is how long kubelet delays the shutdown of its instance to terminate pods. Graceful
+	// node shutdown is disabled if this is 0.
+	KubeletShutdownGracePeriod time.Duration
+	// KubeletShutdownGracePeriodCriticalPods is the part of KubeletShutdownGracePeriod used to terminate critical pods
+	KubeletShutdownGracePeriodCriticalPods time.Duration
+	// KubeletCredentialProviders are the image credential providers kubelet should use, in addition to any provided
+	// by the cluster's platform. A provider with the same name as a platform provider replaces it.
+	KubeletCredentialProviders []kubeletconfigv1.CredentialProvider
+	// KubeletFeatureGates maps the names of kubelet feature gates, each one of KubeletFeatureGates, to whether they
+	// are enabled
+	KubeletFeatureGates map[string]bool
+	// ContainerdCRIOptions maps containerd CRI plugin options to their value, as a TOML literal. The default value of
+	// any option not given is used.
+	ContainerdCRIOptions map[string]string
+	// ContainerdRuntimeHandlers maps the names of additional containerd runtime handlers to their ContainerdIsolation
+	// mode. DefaultContainerdRuntimeHandler is present regardless.
+	ContainerdRuntimeHandlers map[string]string
+	// ContainerdDiscardUnpackedLayers enables containerd's discard_unpacked_layers option
+	ContainerdDiscardUnpackedLayers bool
+	// ContainerdRootDir is containerd's root directory. The directory given in the shipped config is used if this is
+	// empty.
+	ContainerdRootDir string
+	// ContainerdStateDir is containerd's state directory. The directory given in the shipped config is used if this is
+	// empty.
+	ContainerdStateDir string
+	// MinFreeMemory is the free memory, in bytes, required on an instance before the Windows Containers feature is
+	// installed. If this is 0, a warning is logged on instances with low free memory instead of failing.
+	MinFreeMemory uint64
+	// PagefileMinSizeMB is the minimum size of the instance's pagefile, in MB
+	PagefileMinSizeMB int
+	// CrashDumpType is the type of memory dump the instance writes when it crashes, with CrashDumpTypeNone disabling
+	// crash dumps. The crash dump configuration of the instance is left unchanged if this is empty.
+	CrashDumpType string
+	// SChannelDisabledProtocols are the SChannel protocols to disable on the instance, for both its clients and
+	// servers. Protocols which are not given are left unchanged.
+	SChannelDisabledProtocols []string
+	// AddK8sDirsToPath indicates the Kubernetes and containerd directories should be entries of the instance's
+	// system PATH
+	AddK8sDirsToPath bool
+	// LogDir is the directory holding the log directories of the services on the instance. The default log directory
+	// is used if this is empty.
+	LogDir string
+	// WICDConfigurationTimeout is how long WMCO waits for WICD to configure the services of a node. The default
+	// timeout is used if this is 0.
+	WICDConfigurationTimeout time.Duration
+	// ConfigurationTimeout is how long a configuration of the instance can run before it is aborted.
+	// DefaultConfigurationTimeout is used if this is 0.
+	ConfigurationTimeout time.Duration
+	// UpgradeStallTimeout is how long the version of a node can differ from its desired version before WICD is
+	// restarted on it. The default timeout is used if this is 0.
+	UpgradeStallTimeout time.Duration
+	// RebootDetectionDelay is how long to wait after requesting an instance reboot before checking if it has gone
+	// down. The default delay is used if this is 0.
+	RebootDetectionDelay time.Duration
+	// WICDRecoveryDelays are how long to wait before each successive restart of WICD after it crashes. The default
+	// delays are used if this is empty.
+	WICDRecoveryDelays []time.Duration
+	// WICDRecoveryResetPeriod is how long WICD must run without crashing for its crash counter to be reset. The
+	// default period is used if this is 0.
+	WICDRecoveryResetPeriod time.Duration
+	// WICDServiceAccount is the account the WICD service logs on as. WICD runs as LocalSystem if this is empty.
+	WICDServiceAccount string
+	// RebootDetectionInterval is how often a rebooting instance is checked until it has gone down. The default
+	// interval is used if this is 0.
+	RebootDetectionInterval time.Duration
+	// NTPServers are the NTP servers the instance should synchronize its time with
+	NTPServers []string
+	// LeaveNodesCordoned indicates nodes should be left cordoned once they are configured, instead of being uncordoned
+	LeaveNodesCordoned bool
+	// PostConfigurationValidationConfigMap is the name of the ConfigMap holding the script validating the instance
+	// once it is configured. No validation is run if this is empty.
+	PostConfigurationValidationConfigMap string
+	// PostConfigurationValidationTimeout is how long the post-configuration validation script can run.
+	// DefaultPostConfigurationValidationTimeout is used if this is 0.
+	PostConfigurationValidationTimeout time.Duration
+	// HostProcessHelperImage is the image of the helper workload run as a host-process pod on every Windows node. The
+	// helper workload is not deployed if this is empty.
+	HostProcessHelperImage string
+	// ManageRuntimeClasses indicates a RuntimeClass should be managed for each Windows build of the Windows nodes
+	ManageRuntimeClasses bool
+	// PowerPlan is the GUID of the power plan that should be active on the instance
+	PowerPlan string
+	// DynamicPortRange is the dynamic port range of the instance. The range is left unchanged if this is nil.
+	DynamicPortRange *utilnet.PortRange
+	// FirewallProfiles maps the FirewallProfile constants naming the Windows firewall profiles whose state is
+	// asserted on the instance to whether they are enabled
+	FirewallProfiles map[string]bool
+	// NonInteractiveDesktopHeapKB is the size, in KB, of the desktop heap of each non-interactive desktop on the
+	// instance
+	NonInteractiveDesktopHeapKB int
+	// InteractiveSessionsOnReboot is one of the InteractiveSessions constants, describing what is done when users are
+	// logged on to an instance that must be rebooted. InteractiveSessionsWarn is used if this is empty.
+	InteractiveSessionsOnReboot string
+	// RebootWindows are the ranges of the time of day during which nodes may be rebooted. Nodes may be rebooted at
+	// any time if this is empty.
+	RebootWindows []TimeRange
+	// RebootWindowsLocation is the time zone RebootWindows are given in. UTC is used if this is nil.
+	RebootWindowsLocation *time.Location
+	// TempFileCleanupPolicy is one of the TempFileCleanup constants, describing when stale temporary files are removed
+	// from instances. TempFileCleanupOnVersionChange is used if this is empty.
+	TempFileCleanupPolicy string
+	// ExternalConnectivityCheckPort is the TCP port connected to on the external address of configured nodes. The
+	// external connectivity of nodes is not checked if this is 0.
+	ExternalConnectivityCheckPort int
+	// ExternalConnectivityCheckRetries is the number of connection attempts made before a node is reported
+	// unreachable. The default number of attempts is made if this is 0.
+	ExternalConnectivityCheckRetries int
+	// ExternalConnectivityCheckTimeout is how long each connection attempt waits for the connection to be
+	// established. The default timeout is used if this is 0.
+	ExternalConnectivityCheckTimeout time.Duration
+	// HNSOutboundNATExceptions are the CIDRs pod traffic is sent to without being NATed, in addition to the cluster's
+	// service network
+	HNSOutboundNATExceptions []string
+	// HNSACLPolicies are the ACL rules applied to the HNS endpoint of each pod, in addition to those set by HNS
+	HNSACLPolicies []HNSACLPolicy
+	// SSHCiphers are the ciphers offered when connecting to instances over SSH, in order of preference. The SSH
+	// library's defaults are used if this is empty.
+	SSHCiphers []string
+	// SSHKeyExchanges are the key exchange algorithms offered when connecting to instances over SSH, in order of
+	// preference. The SSH library's defaults are used if this is empty.
+	SSHKeyExchanges []string
+	// SSHMACs are the MAC algorithms offered when connecting to instances over SSH, in order of preference. The SSH
+	// library's defaults are used if this is empty.
+	SSHMACs []string
+	// SSHHostKeyAlgorithms are the host key algorithms accepted from instances over SSH, in order of preference. The
+	// SSH library's defaults are used if this is empty.
+	SSHHostKeyAlgorithms []string
+	// SSHKnownHosts maps the addresses of instances to the host keys expected from them over SSH. Addresses are in the
+	// known_hosts format: the host, or [host]:port if its SSH server does not listen on port 22.
+	SSHKnownHosts map[string][]ssh.PublicKey
+	// SSHHostKeyPolicy is one of the SSHHostKeyPolicy constants, giving how the host keys of instances are verified.
+	// SSHHostKeyPolicyVerifyKnown is used if this is empty.
+	SSHHostKeyPolicy string
+	// SFTPMaxPacketSize is the maximum number of bytes of file data in each SFTP read or write request. The SFTP
+	// library's default is used if this is 0.
+	SFTPMaxPacketSize int
+	// SFTPMaxConcurrentRequests is the maximum number of SFTP write requests in flight for each file transferred. The
+	// SFTP library's default is used if this is 0.
+	SFTPMaxConcurrentRequests int
+	// DrainMaxAttempts is the number of times cordoning or draining a node is attempted when it fails due to a
+	// transient API error. The default number of attempts is made if this is 0.
+	DrainMaxAttempts int
+	// TrustedCABundleSyncConcurrency is the maximum number of nodes the trusted CA bundle is synced to at the same
+	// time. DefaultTrustedCABundleSyncConcurrency is used if this is 0.
+	TrustedCABundleSyncConcurrency int
+	// DrainProtectedPodSelector is the label selector of the pods protected when a node is drained. No pods are
+	// protected if this is empty.
+	DrainProtectedPodSelector string
+	// DrainProtectedPodPolicy is one of the DrainProtectedPodPolicy constants, describing how protected pods are
+	// drained. DrainProtectedPodPolicyEvictLast is used if this is empty.
+	DrainProtectedPodPolicy string
+	// DrainProtectedPodGracePeriod is the grace period protected pods are given when they are evicted. The
+	// terminationGracePeriodSeconds of the pods is used if this is 0.
+	DrainProtectedPodGracePeriod time.Duration
+}
+
+// UntilRebootWindow returns how long it is from the given time until nodes may be rebooted, which is 0 if they may be
+// rebooted right away
+func (s *Settings) UntilRebootWindow(now time.Time) time.Duration {
+	if len(s.RebootWindows) == 0 {
+		return 0
+	}
+	location := s.RebootWindowsLocation
+	if location == nil {
+		location = time.UTC
+	}
+	now = now.In(location)
+	offset := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute +
+		time.Duration(now.Second())*time.Second
+	var until time.Duration
+	for i, window := range s.RebootWindows {
+		if window.contains(offset) {
+			return 0
+		}
+		wait := (window.Start - offset + 24*time.Hour) % (24 * time.Hour)
+		if i == 0 || wait < until {
+			until = wait
+		}
+	}
+	return until
+}
+
+// EffectiveConfigurationTimeout returns how long a configuration of an instance can run before it is aborted, which is
+// DefaultConfigurationTimeout if no timeout is given
+func (s *Settings) EffectiveConfigurationTimeout() time.Duration {
+	if s.ConfigurationTimeout > 0 {
+		return s.ConfigurationTimeout
+	}
+	return DefaultConfigurationTimeout
+}
+
+// Get returns the settings described by the settings ConfigMap in the given namespace. Default settings are returned
+// if the ConfigMap does not exist. The error returned if the ConfigMap is not valid wraps ErrInvalid.
+func Get(ctx context.Context, c client.Client, namespace string) (*Settings, error) {
+	cm := &core.ConfigMap{}
+	err := c.Get(ctx, kubeTypes.NamespacedName{Namespace: namespace, Name: ConfigMap}, cm)
+	if err != nil {
+		if k8sapierrors.IsNotFound(err) {
+			return &Settings{}, nil
+		}
+		return nil, fmt.Errorf("unable to get ConfigMap %s/%s: %w", namespace, ConfigMap, err)
+	}
+	s, err := Parse(cm.Data)
+	if err != nil {
+		return nil, fmt.Errorf("%w in ConfigMap %s/%s: %w", ErrInvalid, namespace, ConfigMap, err)
+	}
+	return s, nil
+}
+
+// Parse converts the given ConfigMap data into Settings, returning an error if any of the values are invalid or if an
+// unknown key is present
+func Parse(data map[string]string) (*Settings, error) {
+	s := &Settings{}
+	for key, value := range data {
+		switch key {
+		case timezoneKey:
+			if !timezoneIDRegex.MatchString(value) {
+				return nil, fmt.Errorf("invalid %s value %q", key, value)
+			}
+			s.Timezone = value
+		case kubeletTLSMinVersionKey:
+			if _, err := cliflag.TLSVersion(value); err != nil || value == "" {
+				return nil, fmt.Errorf("invalid %s value %q", key, value)
+			}
+			s.KubeletTLSMinVersion = value
+		case kubeletTLSCipherSuitesKey:
+			suites, err := parseCipherSuites(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: %w", key, value, err)
+			}
+			s.KubeletTLSCipherSuites = suites
+		case kubeletPodPidsLimitKey:
+			limit, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || limit <= 0 {
+				return nil, fmt.Errorf("invalid %s value %q: must be a positive integer", key, value)
+			}
+			s.KubeletPodPidsLimit = limit
+		case kubeletMaxParallelImagePullsKey:
+			pulls, err := strconv.ParseInt(value, 10, 32)
+			if err != nil || pulls <= 0 {
+				return nil, fmt.Errorf("invalid %s value %q: must be a positive integer", key, value)
+			}
+			s.KubeletMaxParallelImagePulls = int32(pulls)
+		case kubeletEventRecordQPSKey:
+			qps, err := strconv.ParseInt(value, 10, 32)
+			if err != nil || qps < 0 {
+				return nil, fmt.Errorf("invalid %s value %q: must be a non-negative integer", key, value)
+			}
+			eventRecordQPS := int32(qps)
+			s.KubeletEventRecordQPS = &eventRecordQPS
+		case kubeletEventBurstKey:
+			burst, err := strconv.ParseInt(value, 10, 32)
+			if err != nil || burst <= 0 {
+				return nil, fmt.Errorf("invalid %s value %q: must be a positive integer", key, value)
+			}
+			s.KubeletEventBurst = int32(burst)
+		case kubeletSerializeImagePullsKey:
+			serialize, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: must be true or false", key, value)
+			}
+			s.KubeletSerializeImagePulls = serialize
+		case kubeletMaxPodsKey:
+			pods, err := strconv.ParseInt(value, 10, 32)
+			if err != nil || pods <= 0 {
+				return nil, fmt.Errorf("invalid %s value %q: must be a positive integer", key, value)
+			}
+			s.KubeletMaxPods = int32(pods)
+		case kubeletEvictionMinimumReclaimKey:
+			reclaim, err := parseEvictionMinimumReclaim(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: %w", key, value, err)
+			}
+			s.KubeletEvictionMinimumReclaim = reclaim
+		case kubeletKubeReservedKey:
+			reserved, err := parseKubeReserved(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: %w", key, value, err)
+			}
+			s.KubeletKubeReserved = reserved
+		case kubeletCertDirKey:
+			dir := strings.TrimSuffix(value, "\\")
+			if !windowsPathRegex.MatchString(dir) ||
+				!strings.HasPrefix(strings.ToLower(dir), strings.ToLower(kubeletCertDirPrefix)) {
+				return nil, fmt.Errorf("invalid %s value %q: must be an absolute directory path under %s", key, value,
+					kubeletCertDirPrefix)
+			}
+			s.KubeletCertDir = dir
+		case kubeletStaticPodPathKey:
+			dir := strings.TrimSuffix(value, "\\")
+			if !windowsPathRegex.MatchString(dir) {
+				return nil, fmt.Errorf("invalid %s value %q: must be an absolute directory path", key, value)
+			}
+			s.KubeletStaticPodPath = dir
+		case kubeletCgroupsPerQOSKey:
+			cgroupsPerQOS, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: must be true or false", key, value)
+			}
+			s.KubeletCgroupsPerQOS = cgroupsPerQOS
+		case kubeletNodeStatusUpdateFrequencyKey:
+			frequency, err := time.ParseDuration(value)
+			if err != nil || frequency <= 0 {
+				return nil, fmt.Errorf("invalid %s value %q: must be a positive duration", key, value)
+			}
+			s.KubeletNodeStatusUpdateFrequency = frequency
+		case kubeletSyncFrequencyKey:
+			frequency, err := time.ParseDuration(value)
+			if err != nil || frequency <= 0 {
+				return nil, fmt.Errorf("invalid %s value %q: must be a positive duration", key, value)
+			}
+			s.KubeletSyncFrequency = frequency
+		case kubeletClusterDNSKey:
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid %s value %q: must be an IP address", key, value)
+			}
+			s.KubeletClusterDNS = ip.String()
+		case kubeletHardeningKey:
+			hardening, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: must be true or false", key, value)
+			}
+			s.KubeletHardening = hardening
+		case kubeletShutdownGracePeriodKey:
+			period, err := time.ParseDuration(value)
+			if err != nil || period <= 0 {
+				return nil, fmt.Errorf("invalid %s value %q: must be a positive duration", key, value)
+			}
+			s.KubeletShutdownGracePeriod = period
+		case kubeletShutdownGracePeriodCriticalPodsKey:
+			period, err := time.ParseDuration(value)
+			if err != nil || period <= 0 {
+				return nil, fmt.Errorf("invalid %s value %q: must be a positive duration", key, value)
+			}
+			s.KubeletShutdownGracePeriodCriticalPods = period
+		case kubeletCredentialProvidersKey:
+			providers, err := parseCredentialProviders(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value: %w", key, err)
+			}
+			s.KubeletCredentialProviders = providers
+		case kubeletFeatureGatesKey:
+			gates, err := parseKubeletFeatureGates(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: %w", key, value, err)
+			}
+			s.KubeletFeatureGates = gates
+		case containerdCRIOptionsKey:
+			options, err := parseContainerdCRIOptions(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: %w", key, value, err)
+			}
+			s.ContainerdCRIOptions = options
+		case containerdRuntimeHandlersKey:
+			handlers, err := parseContainerdRuntimeHandlers(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: %w", key, value, err)
+			}
+			s.ContainerdRuntimeHandlers = handlers
+		case containerdDiscardUnpackedLayersKey:
+			discard, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: must be true or false", key, value)
+			}
+			s.ContainerdDiscardUnpackedLayers = discard
+		case logDirKey:
+			dir := strings.TrimSuffix(value, "\\")
+			if !windowsPathRegex.MatchString(dir) {
+				return nil, fmt.Errorf("invalid %s value %q: must be an absolute directory path", key, value)
+			}
+			s.LogDir = dir
+		case containerdRootDirKey, containerdStateDirKey:
+			dir := strings.TrimSuffix(value, "\\")
+			if !windowsPathRegex.MatchString(dir) {
+				return nil, fmt.Errorf("invalid %s value %q: must be an absolute directory path", key, value)
+			}
+			if key == containerdRootDirKey {
+				s.ContainerdRootDir = dir
+			} else {
+				s.ContainerdStateDir = dir
+			}
+		case minFreeMemoryMBKey:
+			mb, err := strconv.ParseUint(value, 10, 32)
+			if err != nil || mb == 0 {
+				return nil, fmt.Errorf("invalid %s value %q: must be a positive integer", key, value)
+			}
+			s.MinFreeMemory = mb * 1024 * 1024
+		case pagefileMinSizeMBKey:
+			size, err := strconv.ParseUint(value, 10, 32)
+			if err != nil || size == 0 {
+				return nil, fmt.Errorf("invalid %s value %q: must be a positive integer", key, value)
+			}
+			s.PagefileMinSizeMB = int(size)
+		case crashDumpTypeKey:
+			if !slices.Contains(crashDumpTypes, value) {
+				return nil, fmt.Errorf("invalid %s value %q: must be one of %s", key, value,
+					strings.Join(crashDumpTypes, ", "))
+			}
+			s.CrashDumpType = value
+		case schannelDisabledProtocolsKey:
+			protocols, err := parseSChannelProtocols(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: %w", key, value, err)
+			}
+			s.SChannelDisabledProtocols = protocols
+		case addK8sDirsToPathKey:
+			add, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: must be true or false", key, value)
+			}
+			s.AddK8sDirsToPath = add
+		case wicdConfigurationTimeoutKey:
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("invalid %s value %q: must be a positive duration", key, value)
+			}
+			s.WICDConfigurationTimeout = timeout
+		case configurationTimeoutKey:
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("invalid %s value %q: must be a positive duration", key, value)
+			}
+			s.ConfigurationTimeout = timeout
+		case upgradeStallTimeoutKey:
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("invalid %s value %q: must be a positive duration", key, value)
+			}
+			s.UpgradeStallTimeout = timeout
+		case rebootDetectionDelayKey:
+			delay, err := time.ParseDuration(value)
+			if err != nil || delay <= 0 {
+				return nil, fmt.Errorf("invalid %s value %q: must be a positive duration", key, value)
+			}
+			s.RebootDetectionDelay = delay
+		case wicdRecoveryDelaysKey:
+			delays, err := parseWICDRecoveryDelays(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: %w", key, value, err)
+			}
+			s.WICDRecoveryDelays = delays
+		case wicdRecoveryResetPeriodKey:
+			period, err := time.ParseDuration(value)
+			if err != nil || period < minWICDRecoveryResetPeriod || period > maxWICDRecoveryResetPeriod ||
+				period%time.Second != 0 {
+				return nil, fmt.Errorf("invalid %s value %q: must be a whole number of seconds between %s and %s",
+					key, value, minWICDRecoveryResetPeriod, maxWICDRecoveryResetPeriod)
+			}
+			s.WICDRecoveryResetPeriod = period
+		case wicdServiceAccountKey:
+			account, err := parseServiceAccount(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: %w", key, value, err)
+			}
+			s.WICDServiceAccount = account
+		case rebootDetectionIntervalKey:
+			interval, err := time.ParseDuration(value)
+			if err != nil || interval <= 0 {
+				return nil, fmt.Errorf("invalid %s value %q: must be a positive duration", key, value)
+			}
+			s.RebootDetectionInterval = interval
+		case ntpServersKey:
+			servers, err := parseNTPServers(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: %w", key, value, err)
+			}
+			s.NTPServers = servers
+		case leaveNodesCordonedKey:
+			leaveCordoned, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: must be true or false", key, value)
+			}
+			s.LeaveNodesCordoned = leaveCordoned
+		case postConfigurationValidationConfigMapKey:
+			if errs := validation.IsDNS1123Subdomain(value); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid %s value %q: %s", key, value, strings.Join(errs, ", "))
+			}
+			s.PostConfigurationValidationConfigMap = value
+		case postConfigurationValidationTimeoutKey:
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("invalid %s value %q: must be a positive duration", key, value)
+			}
+			s.PostConfigurationValidationTimeout = timeout
+		case hostProcessHelperImageKey:
+			if !imageRegex.MatchString(value) {
+				return nil, fmt.Errorf("invalid %s value %q", key, value)
+			}
+			s.HostProcessHelperImage = value
+		case manageRuntimeClassesKey:
+			manage, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: must be true or false", key, value)
+			}
+			s.ManageRuntimeClasses = manage
+		case powerPlanKey:
+			plan, err := parsePowerPlan(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: %w", key, value, err)
+			}
+			s.PowerPlan = plan
+		case dynamicPortRangeKey:
+			portRange, err := parseDynamicPortRange(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: %w", key, value, err)
+			}
+			s.DynamicPortRange = portRange
+		case firewallProfilesKey:
+			profiles, err := parseFirewallProfiles(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: %w", key, value, err)
+			}
+			s.FirewallProfiles = profiles
+		case nonInteractiveDesktopHeapKBKey:
+			size, err := strconv.ParseUint(value, 10, 32)
+			if err != nil || size == 0 {
+				return nil, fmt.Errorf("invalid %s value %q: must be a positive integer", key, value)
+			}
+			s.NonInteractiveDesktopHeapKB = int(size)
+		case interactiveSessionsOnRebootKey:
+			switch value {
+			case InteractiveSessionsIgnore, InteractiveSessionsWarn, InteractiveSessionsRefuse:
+				s.InteractiveSessionsOnReboot = value
+			default:
+				return nil, fmt.Errorf("invalid %s value %q: must be one of %s, %s or %s", key, value,
+					InteractiveSessionsIgnore, InteractiveSessionsWarn, InteractiveSessionsRefuse)
+			}
+		case rebootWindowsKey:
+			ranges, err := parseTimeRanges(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: %w", key, value, err)
+			}
+			s.RebootWindows = ranges
+		case rebootWindowsTimezoneKey:
+			location, err := time.LoadLocation(value)
+			if err != nil || value == "" {
+				return nil, fmt.Errorf("invalid %s value %q: must be an IANA time zone name", key, value)
+			}
+			s.RebootWindowsLocation = location
+		case tempFileCleanupPolicyKey:
+			switch value {
+			case TempFileCleanupAlways, TempFileCleanupNever, TempFileCleanupOnVersionChange:
+				s.TempFileCleanupPolicy = value
+			default:
+				return nil, fmt.Errorf("invalid %s value %q: must be one of %s, %s or %s", key, value,
+					TempFileCleanupAlways, TempFileCleanupNever, TempFileCleanupOnVersionChange)
+			}
+		case externalConnectivityCheckPortKey:
+			port, err := strconv.ParseUint(value, 10, 16)
+			if err != nil || port == 0 {
+				return nil, fmt.Errorf("invalid %s value %q: must be a port number from 1 to 65535", key, value)
+			}
+			s.ExternalConnectivityCheckPort = int(port)
+		case externalConnectivityCheckRetriesKey:
+			retries, err := strconv.ParseUint(value, 10, 8)
+			if err != nil || retries == 0 || retries > maxExternalConnectivityCheckRetries {
+				return nil, fmt.Errorf("invalid %s value %q: must be a positive integer no greater than %d", key,
+					value, maxExternalConnectivityCheckRetries)
+			}
+			s.ExternalConnectivityCheckRetries = int(retries)
+		case externalConnectivityCheckTimeoutKey:
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 || timeout > maxExternalConnectivityCheckTimeout {
+				return nil, fmt.Errorf("invalid %s value %q: must be a positive duration of up to %s", key, value,
+					maxExternalConnectivityCheckTimeout)
+			}
+			s.ExternalConnectivityCheckTimeout = timeout
+		case hnsOutboundNATExceptionsKey:
+			exceptions, err := parseHNSOutboundNATExceptions(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: %w", key, value, err)
+			}
+			s.HNSOutboundNATExceptions = exceptions
+		case hnsACLPoliciesKey:
+			policies, err := parseHNSACLPolicies(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: %w", key, value, err)
+			}
+			s.HNSACLPolicies = policies
+		case sftpMaxPacketSizeKey:
+			size, err := strconv.Atoi(value)
+			if err != nil || size < MinSFTPMaxPacketSize || size > MaxSFTPMaxPacketSize {
+				return nil, fmt.Errorf("invalid %s value %q: must be an integer from %d to %d", key, value,
+					MinSFTPMaxPacketSize, MaxSFTPMaxPacketSize)
+			}
+			s.SFTPMaxPacketSize = size
+		case sftpMaxConcurrentRequestsKey:
+			requests, err := strconv.Atoi(value)
+			if err != nil || requests < 1 || requests > MaxSFTPMaxConcurrentRequests {
+				return nil, fmt.Errorf("invalid %s value %q: must be an integer from 1 to %d", key, value,
+					MaxSFTPMaxConcurrentRequests)
+			}
+			s.SFTPMaxConcurrentRequests = requests
+		case drainMaxAttemptsKey:
+			attempts, err := strconv.ParseUint(value, 10, 8)
+			if err != nil || attempts == 0 {
+				return nil, fmt.Errorf("invalid %s value %q: must be a positive integer no greater than 255", key,
+					value)
+			}
+			s.DrainMaxAttempts = int(attempts)
+		case trustedCABundleSyncConcurrencyKey:
+			concurrency, err := strconv.ParseUint(value, 10, 8)
+			if err != nil || concurrency == 0 {
+				return nil, fmt.Errorf("invalid %s value %q: must be a positive integer no greater than 255", key,
+					value)
+			}
+			s.TrustedCABundleSyncConcurrency = int(concurrency)
+		case drainProtectedPodSelectorKey:
+			selector, err := labels.Parse(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: %w", key, value, err)
+			}
+			if selector.Empty() {
+				return nil, fmt.Errorf("invalid %s value %q: must not match all pods", key, value)
+			}
+			s.DrainProtectedPodSelector = value
+		case drainProtectedPodPolicyKey:
+			switch value {
+			case DrainProtectedPodPolicyEvictLast, DrainProtectedPodPolicyRefuse:
+				s.DrainProtectedPodPolicy = value
+			default:
+				return nil, fmt.Errorf("invalid %s value %q: must be one of %s or %s", key, value,
+					DrainProtectedPodPolicyEvictLast, DrainProtectedPodPolicyRefuse)
+			}
+		case drainProtectedPodGracePeriodKey:
+			gracePeriod, err := time.ParseDuration(value)
+			if err != nil || gracePeriod <= 0 {
+				return nil, fmt.Errorf("invalid %s value %q: must be a positive duration", key, value)
+			}
+			s.DrainProtectedPodGracePeriod = gracePeriod
+		case sshCiphersKey:
+			algorithms, err := parseSSHAlgorithms(value, supportedSSHCiphers)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: %w", key, value, err)
+			}
+			s.SSHCiphers = algorithms
+		case sshKeyExchangesKey:
+			algorithms, err := parseSSHAlgorithms(value, supportedSSHKeyExchanges)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: %w", key, value, err)
+			}
+			s.SSHKeyExchanges = algorithms
+		case sshMACsKey:
+			algorithms, err := parseSSHAlgorithms(value, supportedSSHMACs)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: %w", key, value, err)
+			}
+			s.SSHMACs = algorithms
+		case sshHostKeyAlgorithmsKey:
+			algorithms, err := parseSSHAlgorithms(value, supportedSSHHostKeyAlgorithms)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: %w", key, value, err)
+			}
+			s.SSHHostKeyAlgorithms = algorithms
+		case sshKnownHostsKey:
+			knownHosts, err := parseKnownHosts(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value: %w", key, err)
+			}
+			s.SSHKnownHosts = knownHosts
+		case sshHostKeyPolicyKey:
+			switch value {
+			case SSHHostKeyPolicyVerifyKnown, SSHHostKeyPolicyStrict, SSHHostKeyPolicyIgnore:
+				s.SSHHostKeyPolicy = value
+			default:
+				return nil, fmt.Errorf("invalid %s value %q: must be one of %s, %s or %s", key, value,
+					SSHHostKeyPolicyVerifyKnown, SSHHostKeyPolicyStrict, SSHHostKeyPolicyIgnore)
+			}
+		default:
+			return nil, fmt.Errorf("unknown key %s", key)
+		}
+	}
+	// kubelet rejects a critical pods grace period longer than the overall grace period
+	if s.KubeletShutdownGracePeriodCriticalPods > s.KubeletShutdownGracePeriod {
+		return nil, fmt.Errorf("%s must not exceed %s", kubeletShutdownGracePeriodCriticalPodsKey,
+			kubeletShutdownGracePeriodKey)
+	}
+	// kubelet refuses to start with a maximum number of parallel pulls when pulls are serialized
+	if s.KubeletSerializeImagePulls && s.KubeletMaxParallelImagePulls > 0 {
+		return nil, fmt.Errorf("%s cannot be given when %s is true", kubeletMaxParallelImagePullsKey,
+			kubeletSerializeImagePullsKey)
+	}
+	// containerd refuses to start if its root and state directories are the same
+	if s.ContainerdRootDir != "" && strings.EqualFold(s.ContainerdRootDir, s.ContainerdStateDir) {
+		return nil, fmt.Errorf("%s and %s must be different directories", containerdRootDirKey,
+			containerdStateDirKey)
+	}
+	if (s.DrainProtectedPodPolicy != "" || s.DrainProtectedPodGracePeriod > 0) && s.DrainProtectedPodSelector == "" {
+		return nil, fmt.Errorf("%s and %s require %s to be given", drainProtectedPodPolicyKey,
+			drainProtectedPodGracePeriodKey, drainProtectedPodSelectorKey)
+	}
+	// protected pods are never evicted when the drain is refused
+	if s.DrainProtectedPodPolicy == DrainProtectedPodPolicyRefuse && s.DrainProtectedPodGracePeriod > 0 {
+		return nil, fmt.Errorf("%s cannot be given when %s is %s", drainProtectedPodGracePeriodKey,
+			drainProtectedPodPolicyKey, DrainProtectedPodPolicyRefuse)
+	}
+	// every configuration would be aborted while waiting for WICD
+	if s.EffectiveConfigurationTimeout() <= s.WICDConfigurationTimeout {
+		return nil, fmt.Errorf("%s must be longer than %s", configurationTimeoutKey, wicdConfigurationTimeoutKey)
+	}
+	if s.PostConfigurationValidationTimeout > 0 && s.PostConfigurationValidationConfigMap == "" {
+		return nil, fmt.Errorf("%s requires %s to be given", postConfigurationValidationTimeoutKey,
+			postConfigurationValidationConfigMapKey)
+	}
+	// every configuration would be aborted while running the validation
+	if s.EffectiveConfigurationTimeout() <= s.PostConfigurationValidationTimeout {
+		return nil, fmt.Errorf("%s must be longer than %s", configurationTimeoutKey,
+			postConfigurationValidationTimeoutKey)
+	}
+	// no instance could be connected to
+	if s.SSHHostKeyPolicy == SSHHostKeyPolicyStrict && len(s.SSHKnownHosts) == 0 {
+		return nil, fmt.Errorf("%s %s requires %s to be given", sshHostKeyPolicyKey, SSHHostKeyPolicyStrict,
+			sshKnownHostsKey)
+	}
+	return s, nil
+}
+
+// parseCipherSuites splits the given comma separated list of cipher suites, ensuring each one is supported by kubelet
+func parseCipherSuites(value string) ([]string, error) {
+	var suites []string
+	for _, suite := range strings.Split(value, ",") {
+		suite = strings.TrimSpace(suite)
+		if suite == "" {
+			continue
+		}
+		suites = append(suites, suite)
+	}
+	if len(suites) == 0 {
+		return nil, fmt.Errorf("at least one cipher suite must be given")
+	}
+	if _, err := cliflag.TLSCipherSuites(suites); err != nil {
+		return nil, err
+	}
+	return suites, nil
+}
+
+// parseEvictionMinimumReclaim parses the given comma separated list of signal=amount pairs, ensuring each signal is
+// supported on Windows and each amount is either a non-negative quantity or a percentage
+func parseEvictionMinimumReclaim(value string) (map[string]string, error) {
+	reclaim := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		signal, amount, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, fmt.Errorf("%s must be in the format signal=amount", pair)
+		}
+		signal = strings.TrimSpace(signal)
+		amount = strings.TrimSpace(amount)
+		if !slices.Contains(KubeletEvictionSignals, signal) {
+			return nil, fmt.Errorf("unsupported eviction signal %s, must be one of %s", signal,
+				strings.Join(KubeletEvictionSignals, ", "))
+		}
+		if _, present := reclaim[signal]; present {
+			return nil, fmt.Errorf("eviction signal %s given more than once", signal)
+		}
+		if err := validateReclaimAmount(amount); err != nil {
+			return nil, fmt.Errorf("invalid amount for %s: %w", signal, err)
+		}
+		reclaim[signal] = amount
+	}
+	if len(reclaim) == 0 {
+		return nil, fmt.Errorf("at least one eviction signal must be given")
+	}
+	return reclaim, nil
+}
+
+// parseKubeReserved parses the given comma separated list of resource=quantity pairs, ensuring each resource is one of
+// KubeletReservedResources and each quantity is non-negative
+func parseKubeReserved(value string) (map[string]string, error) {
+	reserved := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, amount, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, fmt.Errorf("%s must be in the format resource=quantity", pair)
+		}
+		name = strings.TrimSpace(name)
+		amount = strings.TrimSpace(amount)
+		if !slices.Contains(KubeletReservedResources, name) {
+			return nil, fmt.Errorf("unsupported resource %s, must be one of %s", name,
+				strings.Join(KubeletReservedResources, ", "))
+		}
+		if _, present := reserved[name]; present {
+			return nil, fmt.Errorf("resource %s given more than once", name)
+		}
+		quantity, err := resource.ParseQuantity(amount)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quantity for %s: %w", name, err)
+		}
+		if quantity.Sign() < 0 {
+			return nil, fmt.Errorf("quantity for %s must not be negative", name)
+		}
+		reserved[name] = amount
+	}
+	if len(reserved) == 0 {
+		return nil, fmt.Errorf("at least one resource must be given")
+	}
+	return reserved, nil
+}
+
+// parseCredentialProviders parses the given YAML list of kubelet image credential providers, ensuring each provider
+// names a binary, matches at least one image and has a positive cache duration
+func parseCredentialProviders(value string) ([]kubeletconfigv1.CredentialProvider, error) {
+	var providers []kubeletconfigv1.CredentialProvider
+	if err := yaml.UnmarshalStrict([]byte(value), &providers); err != nil {
+		return nil, fmt.Errorf("must be a YAML list of credential providers: %w", err)
+	}
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("at least one credential provider must be given")
+	}
+	names := make(map[string]struct{})
+	for _, provider := range providers {
+		if !credentialProviderNameRegex.MatchString(provider.Name) {
+			return nil, fmt.Errorf("invalid provider name %q: must be the file name of the provider binary",
+				provider.Name)
+		}
+		name := strings.ToLower(strings.TrimSuffix(provider.Name, ".exe"))
+		if _, present := names[name]; present {
+			return nil, fmt.Errorf("provider %s given more than once", provider.Name)
+		}
+		names[name] = struct{}{}
+		if len(provider.MatchImages) == 0 {
+			return nil, fmt.Errorf("provider %s must match at least one image", provider.Name)
+		}
+		if provider.DefaultCacheDuration == nil || provider.DefaultCacheDuration.Duration <= 0 {
+			return nil, fmt.Errorf("provider %s must have a positive defaultCacheDuration", provider.Name)
+		}
+		if !slices.Contains(credentialProviderAPIVersions, provider.APIVersion) {
+			return nil, fmt.Errorf("unsupported apiVersion %q for provider %s, must be one of %s",
+				provider.APIVersion, provider.Name, strings.Join(credentialProviderAPIVersions, ", "))
+		}
+	}
+	return providers, nil
+}
+
+// parseContainerdCRIOptions parses the given comma separated list of option=value pairs, ensuring each option is in
+// containerdCRIOptions and has a value of the expected type. The values are returned as TOML literals.
+func parseContainerdCRIOptions(value string) (map[string]string, error) {
+	options := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		option, optionValue, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, fmt.Errorf("%s must be in the format option=value", pair)
+		}
+		option = strings.TrimSpace(option)
+		optionValue = strings.TrimSpace(optionValue)
+		optionType, ok := containerdCRIOptions[option]
+		if !ok {
+			return nil, fmt.Errorf("unsupported containerd CRI option %s", option)
+		}
+		if _, present := options[option]; present {
+			return nil, fmt.Errorf("containerd CRI option %s given more than once", option)
+		}
+		literal, err := criOptionLiteral(optionType, optionValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %w", option, err)
+		}
+		options[option] = literal
+	}
+	if len(options) == 0 {
+		return nil, fmt.Errorf("at least one containerd CRI option must be given")
+	}
+	return options, nil
+}
+
+// parseContainerdRuntimeHandlers parses the given comma separated list of name=isolation pairs, ensuring each name is
+// a valid RuntimeClass handler other than DefaultContainerdRuntimeHandler, and each isolation is a ContainerdIsolation
+// mode
+func parseContainerdRuntimeHandlers(value string) (map[string]string, error) {
+	handlers := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, isolation, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, fmt.Errorf("%s must be in the format name=isolation", pair)
+		}
+		name = strings.TrimSpace(name)
+		isolation = strings.TrimSpace(isolation)
+		// RuntimeClass handlers must be DNS labels
+		if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid runtime handler name %s: %s", name, strings.Join(errs, ", "))
+		}
+		if name == DefaultContainerdRuntimeHandler {
+			return nil, fmt.Errorf("%s is the default runtime handler and cannot be redefined", name)
+		}
+		if _, present := handlers[name]; present {
+			return nil, fmt.Errorf("runtime handler %s given more than once", name)
+		}
+		if isolation != ContainerdIsolationProcess && isolation != ContainerdIsolationHyperV {
+			return nil, fmt.Errorf("unsupported isolation %s for runtime handler %s, must be %s or %s", isolation,
+				name, ContainerdIsolationProcess, ContainerdIsolationHyperV)
+		}
+		handlers[name] = isolation
+	}
+	if len(handlers) == 0 {
+		return nil, fmt.Errorf("at least one runtime handler must be given")
+	}
+	return handlers, nil
+}
+
+// criOptionLiteral returns the TOML literal of the given containerd CRI option value, returning an error if the value
+// is not of the given type
+func criOptionLiteral(optionType criOptionType, value string) (string, error) {
+	switch optionType {
+	case criOptionBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("%s must be true or false", value)
+		}
+		return strconv.FormatBool(b), nil
+	case criOptionPositiveInt:
+		i, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || i <= 0 {
+			return "", fmt.Errorf("%s must be a positive integer", value)
+		}
+		return strconv.FormatInt(i, 10), nil
+	case criOptionDuration:
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return "", fmt.Errorf("%s must be a non-negative duration", value)
+		}
+		return strconv.Quote(d.String()), nil
+	default:
+		return "", fmt.Errorf("unknown option type %d", optionType)
+	}
+}
+
+// validateReclaimAmount returns an error if the given amount is not a percentage between 0 and 100, or a non-negative
+// resource quantity such as 500Mi
+func validateReclaimAmount(amount string) error {
+	if percentage, found := strings.CutSuffix(amount, "%"); found {
+		p, err := strconv.ParseFloat(percentage, 64)
+		if err != nil || p < 0 || p > 100 {
+			return fmt.Errorf("%s is not a percentage between 0%% and 100%%", amount)
+		}
+		return nil
+	}
+	quantity, err := resource.ParseQuantity(amount)
+	if err != nil {
+		return fmt.Errorf("%s is not a valid quantity: %w", amount, err)
+	}
+	if quantity.Sign() < 0 {
+		return fmt.Errorf("%s must not be negative", amount)
+	}
+	return nil
+}
+
+// parseTimeRanges splits the given comma separated list of time of day ranges, each in the format HH:MM-HH:MM
+func parseTimeRanges(value string) ([]TimeRange, error) {
+	var ranges []TimeRange
+	for _, r := range strings.Split(value, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		start, end, found := strings.Cut(r, "-")
+		if !found {
+			return nil, fmt.Errorf("%s must be in the format HH:MM-HH:MM", r)
+		}
+		startOffset, err := parseTimeOfDay(strings.TrimSpace(start))
+		if err != nil {
+			return nil, err
+		}
+		endOffset, err := parseTimeOfDay(strings.TrimSpace(end))
+		if err != nil {
+			return nil, err
+		}
+		if startOffset == endOffset {
+			return nil, fmt.Errorf("%s is an empty range", r)
+		}
+		ranges = append(ranges, TimeRange{Start: startOffset, End: endOffset})
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("at least one time range must be given")
+	}
+	return ranges, nil
+}
+
+// parseTimeOfDay returns the offset from midnight of the given time of day, in the 24-hour format HH:MM
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a time of day in the format HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// parseNTPServers splits the given comma separated list of NTP servers, ensuring each one is a valid IP address or
+// hostname
+func parseNTPServers(value string) ([]string, error) {
+	var servers []string
+	for _, server := range strings.Split(value, ",") {
+		server = strings.TrimSpace(server)
+		if server == "" {
+			continue
+		}
+		if net.ParseIP(server) == nil && len(validation.IsDNS1123Subdomain(strings.ToLower(server))) > 0 {
+			return nil, fmt.Errorf("%s is not a valid IP address or hostname", server)
+		}
+		servers = append(servers, server)
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("at least one NTP server must be given")
+	}
+	return servers, nil
+}
+
+// parseSChannelProtocols splits the given comma separated list of SChannel protocols, ensuring each one is one of the
+// deprecatedSChannelProtocols. Protocols given more than once are returned once.
+func parseSChannelProtocols(value string) ([]string, error) {
+	var protocols []string
+	for _, protocol := range strings.Split(value, ",") {
+		protocol = strings.TrimSpace(protocol)
+		if protocol == "" {
+			continue
+		}
+		if !slices.Contains(deprecatedSChannelProtocols, protocol) {
+			return nil, fmt.Errorf("protocol %s cannot be disabled, must be one of %s", protocol,
+				strings.Join(deprecatedSChannelProtocols, ", "))
+		}
+		if !slices.Contains(protocols, protocol) {
+			protocols = append(protocols, protocol)
+		}
+	}
+	if len(protocols) == 0 {
+		return nil, fmt.Errorf("at least one protocol must be given")
+	}
+	return protocols, nil
+}
+
+// parseHNSOutboundNATExceptions splits the given comma separated list of CIDRs, ensuring each one is an IPv4 CIDR.
+// The CIDRs are returned in their canonical form.
+func parseHNSOutboundNATExceptions(value string) ([]string, error) {
+	var exceptions []string
+	for _, cidr := range strings.Split(value, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		exception, err := parseIPv4CIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		if slices.Contains(exceptions, exception) {
+			return nil, fmt.Errorf("%s given more than once", cidr)
+		}
+		exceptions = append(exceptions, exception)
+	}
+	if len(exceptions) == 0 {
+		return nil, fmt.Errorf("at least one CIDR must be given")
+	}
+	return exceptions, nil
+}
+
+// parseHNSACLPolicies parses the given semicolon separated list of HNS ACL rules. Each rule is a comma separated list
+// of field=value pairs, which must give the action, direction and priority of the rule.
+func parseHNSACLPolicies(value string) ([]HNSACLPolicy, error) {
+	var policies []HNSACLPolicy
+	for _, rule := range strings.Split(value, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		policy, err := parseHNSACLPolicy(rule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %s: %w", rule, err)
+		}
+		policies = append(policies, *policy)
+	}
+	if len(policies) == 0 {
+		return nil, fmt.Errorf("at least one rule must be given")
+	}
+	return policies, nil
+}
+
+// parseHNSACLPolicy parses the given comma separated list of field=value pairs describing an HNS ACL rule
+func parseHNSACLPolicy(rule string) (*HNSACLPolicy, error) {
+	policy := &HNSACLPolicy{}
+	given := make(map[string]bool)
+	for _, pair := range strings.Split(rule, ",") {
+		field, fieldValue, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found {
+			return nil, fmt.Errorf("%s must be in the format field=value", pair)
+		}
+		field = strings.TrimSpace(field)
+		fieldValue = strings.TrimSpace(fieldValue)
+		if given[field] {
+			return nil, fmt.Errorf("%s given more than once", field)
+		}
+		given[field] = true
+		var err error
+		switch field {
+		case "action":
+			if fieldValue != HNSACLActionAllow && fieldValue != HNSACLActionBlock {
+				return nil, fmt.Errorf("action must be %s or %s", HNSACLActionAllow, HNSACLActionBlock)
+			}
+			policy.Action = fieldValue
+		case "direction":
+			if fieldValue != HNSACLDirectionIn && fieldValue != HNSACLDirectionOut {
+				return nil, fmt.Errorf("direction must be %s or %s", HNSACLDirectionIn, HNSACLDirectionOut)
+			}
+			policy.Direction = fieldValue
+		case "protocol":
+			protocol, ok := hnsACLProtocols[fieldValue]
+			if !ok {
+				return nil, fmt.Errorf("protocol must be one of TCP, UDP or ICMP")
+			}
+			policy.Protocol = protocol
+		case "remoteAddress":
+			if policy.RemoteAddress, err = parseIPv4CIDR(fieldValue); err != nil {
+				return nil, err
+			}
+		case "localPort":
+			if policy.LocalPort, err = parsePort(fieldValue); err != nil {
+				return nil, fmt.Errorf("invalid localPort: %w", err)
+			}
+		case "remotePort":
+			if policy.RemotePort, err = parsePort(fieldValue); err != nil {
+				return nil, fmt.Errorf("invalid remotePort: %w", err)
+			}
+		case "priority":
+			priority, err := strconv.Atoi(fieldValue)
+			if err != nil || priority < 1 || priority > maxHNSACLPriority {
+				return nil, fmt.Errorf("priority must be an integer from 1 to %d", maxHNSACLPriority)
+			}
+			policy.Priority = priority
+		default:
+			return nil, fmt.Errorf("unknown field %s", field)
+		}
+	}
+	for _, required := range []string{"action", "direction", "priority"} {
+		if !given[required] {
+			return nil, fmt.Errorf("%s must be given", required)
+		}
+	}
+	if (policy.LocalPort != 0 || policy.RemotePort != 0) && policy.Protocol != hnsACLProtocols["TCP"] &&
+		policy.Protocol != hnsACLProtocols["UDP"] {
+		return nil, fmt.Errorf("ports can only be given for the TCP and UDP protocols")
+	}
+	return policy, nil
+}
+
+// parseIPv4CIDR returns the canonical form of the given IPv4 CIDR
+func parseIPv4CIDR(value string) (string, error) {
+	ip, ipNet, err := net.ParseCIDR(value)
+	if err != nil || ip.To4() == nil {
+		return "", fmt.Errorf("%s is not a valid IPv4 CIDR", value)
+	}
+	return ipNet.String(), nil
+}
+
+// parsePort returns the given TCP or UDP port number
+func parsePort(value string) (int, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("port %q must be an integer between 1 and 65535", value)
+	}
+	return port, nil
+}
+
+// parseSSHAlgorithms parses the given comma separated list of SSH algorithms, ensuring each is one of the given
+// supported algorithms and is given only once
+func parseSSHAlgorithms(value string, supported []string) ([]string, error) {
+	var algorithms []string
+	for _, algorithm := range strings.Split(value, ",") {
+		algorithm = strings.TrimSpace(algorithm)
+		if algorithm == "" {
+			continue
+		}
+		if !slices.Contains(supported, algorithm) {
+			return nil, fmt.Errorf("unsupported algorithm %q, must be one of %s", algorithm,
+				strings.Join(supported, ", "))
+		}
+		if slices.Contains(algorithms, algorithm) {
+			return nil, fmt.Errorf("algorithm %q given more than once", algorithm)
+		}
+		algorithms = append(algorithms, algorithm)
+	}
+	if len(algorithms) == 0 {
+		return nil, fmt.Errorf("at least one algorithm must be given")
+	}
+	return algorithms, nil
+}
+
+// parseKnownHosts parses the given host keys in the OpenSSH known_hosts format, returning the keys of each host by
+// address. Hosts must be given as plain addresses, markers such as @cert-authority are not supported.
+func parseKnownHosts(value string) (map[string][]ssh.PublicKey, error) {
+	knownHosts := make(map[string][]ssh.PublicKey)
+	rest := []byte(value)
+	for {
+		marker, hosts, key, _, next, err := ssh.ParseKnownHosts(rest)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		rest = next
+		if marker != "" {
+			return nil, fmt.Errorf("unsupported marker @%s", marker)
+		}
+		for _, host := range hosts {
+			if strings.HasPrefix(host, "|") || strings.ContainsAny(host, "*?!") {
+				return nil, fmt.Errorf("unsupported host %q, hashed host names and patterns cannot be given", host)
+			}
+			address := strings.ToLower(host)
+			knownHosts[address] = append(knownHosts[address], key)
+		}
+	}
+	if len(knownHosts) == 0 {
+		return nil, fmt.Errorf("at least one host key must be given")
+	}
+	return knownHosts, nil
+}
+
+// parseWICDRecoveryDelays parses the given comma separated list of WICD recovery delays. Each delay must be positive,
+// a whole number of milliseconds, and at most maxWICDRecoveryDelay.
+func parseWICDRecoveryDelays(value string) ([]time.Duration, error) {
+	entries := strings.Split(value, ",")
+	if len(entries) > maxWICDRecoveryDelays {
+		return nil, fmt.Errorf("at most %d delays can be given", maxWICDRecoveryDelays)
+	}
+	delays := make([]time.Duration, 0, len(entries))
+	for _, entry := range entries {
+		delay, err := time.ParseDuration(strings.TrimSpace(entry))
+		if err != nil || delay <= 0 || delay > maxWICDRecoveryDelay || delay%time.Millisecond != 0 {
+			return nil, fmt.Errorf("delay %q must be a positive whole number of milliseconds of up to %s", entry,
+				maxWICDRecoveryDelay)
+		}
+		delays = append(delays, delay)
+	}
+	return delays, nil
+}
+
+// parseFirewallProfiles parses the given comma separated list of profile=state pairs, where each profile is one of the
+// FirewallProfile constants, matched case-insensitively, and each state is true or false
+func parseFirewallProfiles(value string) (map[string]bool, error) {
+	profiles := make(map[string]bool)
+	for _, pair := range strings.Split(value, ",") {
+		name, state, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found {
+			return nil, fmt.Errorf("%q must be given as profile=state", pair)
+		}
+		var profile string
+		for _, known := range []string{FirewallProfileDomain, FirewallProfilePrivate, FirewallProfilePublic} {
+			if strings.EqualFold(strings.TrimSpace(name), known) {
+				profile = known
+			}
+		}
+		if profile == "" {
+			return nil, fmt.Errorf("unknown firewall profile %q, must be one of %s, %s or %s", name,
+				FirewallProfileDomain, FirewallProfilePrivate, FirewallProfilePublic)
+		}
+		if _, present := profiles[profile]; present {
+			return nil, fmt.Errorf("firewall profile %s given more than once", profile)
+		}
+		enabled, err := strconv.ParseBool(strings.TrimSpace(state))
+		if err != nil {
+			return nil, fmt.Errorf("state of firewall profile %s must be true or false", profile)
+		}
+		profiles[profile] = enabled
+	}
+	return profiles, nil
+}
+
+// parseKubeletFeatureGates parses the given comma separated list of gate=state pairs, where each gate is one of
+// KubeletFeatureGates and each state is true or false. RotateKubeletServerCertificateGate cannot be disabled.
+func parseKubeletFeatureGates(value string) (map[string]bool, error) {
+	gates := make(map[string]bool)
+	for _, pair := range strings.Split(value, ",") {
+		name, state, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found {
+			return nil, fmt.Errorf("%q must be given as gate=state", pair)
+		}
+		name = strings.TrimSpace(name)
+		if !slices.Contains(KubeletFeatureGates, name) {
+			return nil, fmt.Errorf("unknown kubelet feature gate %q, must be one of %s", name,
+				strings.Join(KubeletFeatureGates, ", "))
+		}
+		if _, present := gates[name]; present {
+			return nil, fmt.Errorf("kubelet feature gate %s given more than once", name)
+		}
+		enabled, err := strconv.ParseBool(strings.TrimSpace(state))
+		if err != nil {
+			return nil, fmt.Errorf("state of kubelet feature gate %s must be true or false", name)
+		}
+		if name == RotateKubeletServerCertificateGate && !enabled {
+			return nil, fmt.Errorf("kubelet feature gate %s is required by WMCO and cannot be disabled", name)
+		}
+		gates[name] = enabled
+	}
+	return gates, nil
+}
+
+// parseServiceAccount returns the account a service should log on as, which is empty for LocalSystem. Only accounts
+// without a password are accepted, as there is nowhere to store one.
+func parseServiceAccount(value string) (string, error) {
+	if strings.EqualFold(value, localSystemAccount) {
+		return "", nil
+	}
+	if !virtualAccountRegex.MatchString(value) && !managedServiceAccountRegex.MatchString(value) {
+		return "", fmt.Errorf("must be LocalSystem, a virtual account such as NT SERVICE\\windows-instance-config-daemon " +
+			"or a group managed service account such as CONTOSO\\wicd$")
+	}
+	return value, nil
+}
+
+// parseDynamicPortRange returns the given dynamic port range, given as START-END, returning an error if it is not one
+// Windows accepts
+func parseDynamicPortRange(value string) (*utilnet.PortRange, error) {
+	if !strings.Contains(value, "-") {
+		return nil, fmt.Errorf("must be a range of ports such as 10000-19999")
+	}
+	portRange, err := utilnet.ParsePortRange(value)
+	if err != nil {
+		return nil, err
+	}
+	if portRange.Base < MinDynamicPort {
+		return nil, fmt.Errorf("must not start below port %d", MinDynamicPort)
+	}
+	if portRange.Size < MinDynamicPortRangeSize {
+		return nil, fmt.Errorf("must contain at least %d ports", MinDynamicPortRangeSize)
+	}
+	return portRange, nil
+}
+
+// parsePowerPlan returns the GUID of the given power plan, which is either one of the names in powerPlans or a GUID
+func parsePowerPlan(value string) (string, error) {
+	if guid, ok := powerPlans[value]; ok {
+		return guid, nil
+	}
+	if !powerPlanGUIDRegex.MatchString(value) {
+		return "", fmt.Errorf("must be one of Balanced, HighPerformance, PowerSaver or a power plan GUID")
+	}
+	return strings.ToLower(value), nil
+}
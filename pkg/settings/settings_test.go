@@ -0,0 +1,1374 @@
+package settings
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	kubeletconfigv1 "k8s.io/kubelet/config/v1"
+	"k8s.io/utils/ptr"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestParse(t *testing.T) {
+	hostKey := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAINRne0bir0SIYSIG1jV/8LWS5WSis0lugkGPwbKCxojO"
+	parsedHostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKey))
+	require.NoError(t, err)
+	otherHostKey := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOeAhw2xxbvPUJoSd1cBw5jvpJibmZ5xNBBZySx2Gc+W"
+	parsedOtherHostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(otherHostKey))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name        string
+		input       map[string]string
+		expected    *Settings
+		expectedErr bool
+	}{
+		{
+			name:     "empty data",
+			input:    map[string]string{},
+			expected: &Settings{},
+		},
+		{
+			name:        "unknown key",
+			input:       map[string]string{"unknown": "value"},
+			expectedErr: true,
+		},
+		{
+			name:     "valid timezone",
+			input:    map[string]string{timezoneKey: "UTC"},
+			expected: &Settings{Timezone: "UTC"},
+		},
+		{
+			name:     "valid timezone with spaces",
+			input:    map[string]string{timezoneKey: "Central Europe Standard Time"},
+			expected: &Settings{Timezone: "Central Europe Standard Time"},
+		},
+		{
+			name:        "timezone with invalid characters",
+			input:       map[string]string{timezoneKey: "UTC; Restart-Computer"},
+			expectedErr: true,
+		},
+		{
+			name:        "empty timezone",
+			input:       map[string]string{timezoneKey: ""},
+			expectedErr: true,
+		},
+		{
+			name:     "valid kubelet TLS min version",
+			input:    map[string]string{kubeletTLSMinVersionKey: "VersionTLS13"},
+			expected: &Settings{KubeletTLSMinVersion: "VersionTLS13"},
+		},
+		{
+			name:        "invalid kubelet TLS min version",
+			input:       map[string]string{kubeletTLSMinVersionKey: "TLS1.2"},
+			expectedErr: true,
+		},
+		{
+			name: "valid kubelet TLS cipher suites",
+			input: map[string]string{kubeletTLSCipherSuitesKey: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, " +
+				"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"},
+			expected: &Settings{KubeletTLSCipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+				"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}},
+		},
+		{
+			name: "unknown kubelet TLS cipher suite",
+			input: map[string]string{kubeletTLSCipherSuitesKey: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256," +
+				"ECDHE-RSA-AES128-GCM-SHA256"},
+			expectedErr: true,
+		},
+		{
+			name:        "empty kubelet TLS cipher suites",
+			input:       map[string]string{kubeletTLSCipherSuitesKey: " , "},
+			expectedErr: true,
+		},
+		{
+			name:     "valid kubelet pod PID limit",
+			input:    map[string]string{kubeletPodPidsLimitKey: "4096"},
+			expected: &Settings{KubeletPodPidsLimit: 4096},
+		},
+		{
+			name:        "zero kubelet pod PID limit",
+			input:       map[string]string{kubeletPodPidsLimitKey: "0"},
+			expectedErr: true,
+		},
+		{
+			name:        "negative kubelet pod PID limit",
+			input:       map[string]string{kubeletPodPidsLimitKey: "-1"},
+			expectedErr: true,
+		},
+		{
+			name:        "non-numeric kubelet pod PID limit",
+			input:       map[string]string{kubeletPodPidsLimitKey: "unlimited"},
+			expectedErr: true,
+		},
+		{
+			name:     "valid kubelet max parallel image pulls",
+			input:    map[string]string{kubeletMaxParallelImagePullsKey: "3"},
+			expected: &Settings{KubeletMaxParallelImagePulls: 3},
+		},
+		{
+			name:        "zero kubelet max parallel image pulls",
+			input:       map[string]string{kubeletMaxParallelImagePullsKey: "0"},
+			expectedErr: true,
+		},
+		{
+			name:     "valid kubelet event rate limits",
+			input:    map[string]string{kubeletEventRecordQPSKey: "20", kubeletEventBurstKey: "40"},
+			expected: &Settings{KubeletEventRecordQPS: ptr.To(int32(20)), KubeletEventBurst: 40},
+		},
+		{
+			name:     "unlimited kubelet event rate",
+			input:    map[string]string{kubeletEventRecordQPSKey: "0"},
+			expected: &Settings{KubeletEventRecordQPS: ptr.To(int32(0))},
+		},
+		{
+			name:        "negative kubelet event rate",
+			input:       map[string]string{kubeletEventRecordQPSKey: "-1"},
+			expectedErr: true,
+		},
+		{
+			name:        "zero kubelet event burst",
+			input:       map[string]string{kubeletEventBurstKey: "0"},
+			expectedErr: true,
+		},
+		{
+			name:        "non-numeric kubelet event burst",
+			input:       map[string]string{kubeletEventBurstKey: "many"},
+			expectedErr: true,
+		},
+		{
+			name:     "serialized kubelet image pulls",
+			input:    map[string]string{kubeletSerializeImagePullsKey: "true"},
+			expected: &Settings{KubeletSerializeImagePulls: true},
+		},
+		{
+			name:     "parallel kubelet image pulls",
+			input:    map[string]string{kubeletSerializeImagePullsKey: "false", kubeletMaxParallelImagePullsKey: "3"},
+			expected: &Settings{KubeletMaxParallelImagePulls: 3},
+		},
+		{
+			name:        "invalid kubelet serialize image pulls",
+			input:       map[string]string{kubeletSerializeImagePullsKey: "1.0"},
+			expectedErr: true,
+		},
+		{
+			name:        "kubelet max parallel image pulls with serialized pulls",
+			input:       map[string]string{kubeletSerializeImagePullsKey: "true", kubeletMaxParallelImagePullsKey: "3"},
+			expectedErr: true,
+		},
+		{
+			name:     "valid kubelet max pods",
+			input:    map[string]string{kubeletMaxPodsKey: "150"},
+			expected: &Settings{KubeletMaxPods: 150},
+		},
+		{
+			name:        "negative kubelet max pods",
+			input:       map[string]string{kubeletMaxPodsKey: "-10"},
+			expectedErr: true,
+		},
+		{
+			name:        "kubelet max pods out of range",
+			input:       map[string]string{kubeletMaxPodsKey: "4294967296"},
+			expectedErr: true,
+		},
+		{
+			name: "valid kubelet eviction minimum reclaim",
+			input: map[string]string{
+				kubeletEvictionMinimumReclaimKey: "memory.available=200Mi, nodefs.available=5%,imagefs.available=0"},
+			expected: &Settings{KubeletEvictionMinimumReclaim: map[string]string{"memory.available": "200Mi",
+				"nodefs.available": "5%", "imagefs.available": "0"}},
+		},
+		{
+			name:        "kubelet eviction minimum reclaim with Linux only signal",
+			input:       map[string]string{kubeletEvictionMinimumReclaimKey: "nodefs.inodesFree=5%"},
+			expectedErr: true,
+		},
+		{
+			name:        "kubelet eviction minimum reclaim with invalid quantity",
+			input:       map[string]string{kubeletEvictionMinimumReclaimKey: "memory.available=lots"},
+			expectedErr: true,
+		},
+		{
+			name:        "kubelet eviction minimum reclaim with invalid percentage",
+			input:       map[string]string{kubeletEvictionMinimumReclaimKey: "nodefs.available=150%"},
+			expectedErr: true,
+		},
+		{
+			name:        "kubelet eviction minimum reclaim with negative quantity",
+			input:       map[string]string{kubeletEvictionMinimumReclaimKey: "memory.available=-1Gi"},
+			expectedErr: true,
+		},
+		{
+			name:        "kubelet eviction minimum reclaim with repeated signal",
+			input:       map[string]string{kubeletEvictionMinimumReclaimKey: "memory.available=1Gi,memory.available=2Gi"},
+			expectedErr: true,
+		},
+		{
+			name:        "kubelet eviction minimum reclaim missing amount",
+			input:       map[string]string{kubeletEvictionMinimumReclaimKey: "memory.available"},
+			expectedErr: true,
+		},
+		{
+			name:  "valid kubelet kube reserved",
+			input: map[string]string{kubeletKubeReservedKey: "cpu=250m, memory=1Gi,ephemeral-storage=0"},
+			expected: &Settings{KubeletKubeReserved: map[string]string{"cpu": "250m", "memory": "1Gi",
+				"ephemeral-storage": "0"}},
+		},
+		{
+			name:        "kubelet kube reserved with unsupported resource",
+			input:       map[string]string{kubeletKubeReservedKey: "pid=1000"},
+			expectedErr: true,
+		},
+		{
+			name:        "kubelet kube reserved with invalid quantity",
+			input:       map[string]string{kubeletKubeReservedKey: "memory=lots"},
+			expectedErr: true,
+		},
+		{
+			name:        "kubelet kube reserved with negative quantity",
+			input:       map[string]string{kubeletKubeReservedKey: "cpu=-100m"},
+			expectedErr: true,
+		},
+		{
+			name:        "kubelet kube reserved with repeated resource",
+			input:       map[string]string{kubeletKubeReservedKey: "cpu=100m,cpu=200m"},
+			expectedErr: true,
+		},
+		{
+			name:        "kubelet kube reserved missing quantity",
+			input:       map[string]string{kubeletKubeReservedKey: "memory"},
+			expectedErr: true,
+		},
+		{
+			name:  "valid containerd runtime handlers",
+			input: map[string]string{containerdRuntimeHandlersKey: "runhcs-wcow-hypervisor=hyperv, isolated=process"},
+			expected: &Settings{ContainerdRuntimeHandlers: map[string]string{
+				"runhcs-wcow-hypervisor": ContainerdIsolationHyperV, "isolated": ContainerdIsolationProcess}},
+		},
+		{
+			name:        "containerd runtime handler redefining the default handler",
+			input:       map[string]string{containerdRuntimeHandlersKey: DefaultContainerdRuntimeHandler + "=hyperv"},
+			expectedErr: true,
+		},
+		{
+			name:        "containerd runtime handler with invalid name",
+			input:       map[string]string{containerdRuntimeHandlersKey: "Hyper_V=hyperv"},
+			expectedErr: true,
+		},
+		{
+			name:        "containerd runtime handler with unsupported isolation",
+			input:       map[string]string{containerdRuntimeHandlersKey: "sandboxed=gvisor"},
+			expectedErr: true,
+		},
+		{
+			name:     "containerd discard unpacked layers",
+			input:    map[string]string{containerdDiscardUnpackedLayersKey: "true"},
+			expected: &Settings{ContainerdDiscardUnpackedLayers: true},
+		},
+		{
+			name:        "invalid containerd discard unpacked layers",
+			input:       map[string]string{containerdDiscardUnpackedLayersKey: "yes please"},
+			expectedErr: true,
+		},
+		{
+			name: "containerd root and state directories",
+			input: map[string]string{containerdRootDirKey: "D:\\containerd\\root\\",
+				containerdStateDirKey: "D:\\containerd\\state"},
+			expected: &Settings{ContainerdRootDir: "D:\\containerd\\root", ContainerdStateDir: "D:\\containerd\\state"},
+		},
+		{
+			name:        "relative containerd root directory",
+			input:       map[string]string{containerdRootDirKey: "containerd\\root"},
+			expectedErr: true,
+		},
+		{
+			name:        "containerd state directory with relative components",
+			input:       map[string]string{containerdStateDirKey: "D:\\containerd\\..\\state"},
+			expectedErr: true,
+		},
+		{
+			name: "same containerd root and state directories",
+			input: map[string]string{containerdRootDirKey: "D:\\containerd",
+				containerdStateDirKey: "d:\\Containerd\\"},
+			expectedErr: true,
+		},
+		{
+			name:        "containerd runtime handler given twice",
+			input:       map[string]string{containerdRuntimeHandlersKey: "isolated=hyperv,isolated=process"},
+			expectedErr: true,
+		},
+		{
+			name:        "containerd runtime handler missing isolation",
+			input:       map[string]string{containerdRuntimeHandlersKey: "isolated"},
+			expectedErr: true,
+		},
+		{
+			name: "valid containerd CRI options",
+			input: map[string]string{containerdCRIOptionsKey: "device_ownership_from_security_context=True, " +
+				"max_concurrent_downloads=5,stream_idle_timeout=30m"},
+			expected: &Settings{ContainerdCRIOptions: map[string]string{
+				"device_ownership_from_security_context": "true", "max_concurrent_downloads": "5",
+				"stream_idle_timeout": "\"30m0s\""}},
+		},
+		{
+			name:        "containerd CRI option not in allowlist",
+			input:       map[string]string{containerdCRIOptionsKey: "sandbox_image=example.com/pause:latest"},
+			expectedErr: true,
+		},
+		{
+			name:        "containerd CRI option with invalid boolean",
+			input:       map[string]string{containerdCRIOptionsKey: "device_ownership_from_security_context=yes"},
+			expectedErr: true,
+		},
+		{
+			name:        "containerd CRI option with non-positive integer",
+			input:       map[string]string{containerdCRIOptionsKey: "max_concurrent_downloads=0"},
+			expectedErr: true,
+		},
+		{
+			name:        "containerd CRI option with invalid duration",
+			input:       map[string]string{containerdCRIOptionsKey: "image_pull_progress_timeout=\"1h\""},
+			expectedErr: true,
+		},
+		{
+			name: "repeated containerd CRI option",
+			input: map[string]string{containerdCRIOptionsKey: "ignore_image_defined_volumes=true," +
+				"ignore_image_defined_volumes=false"},
+			expectedErr: true,
+		},
+		{
+			name:        "containerd CRI option missing value",
+			input:       map[string]string{containerdCRIOptionsKey: "ignore_image_defined_volumes"},
+			expectedErr: true,
+		},
+		{
+			name:        "empty containerd CRI options",
+			input:       map[string]string{containerdCRIOptionsKey: " , "},
+			expectedErr: true,
+		},
+		{
+			name:     "valid kubelet certificate directory",
+			input:    map[string]string{kubeletCertDirKey: "c:\\var\\audit\\kubelet.pki\\"},
+			expected: &Settings{KubeletCertDir: "c:\\var\\audit\\kubelet.pki"},
+		},
+		{
+			name:        "kubelet certificate directory outside of allowed prefix",
+			input:       map[string]string{kubeletCertDirKey: "C:\\k\\pki"},
+			expectedErr: true,
+		},
+		{
+			name:        "kubelet certificate directory escaping allowed prefix",
+			input:       map[string]string{kubeletCertDirKey: "C:\\var\\..\\k\\pki"},
+			expectedErr: true,
+		},
+		{
+			name:     "valid log directory",
+			input:    map[string]string{logDirKey: "D:\\logs\\"},
+			expected: &Settings{LogDir: "D:\\logs"},
+		},
+		{
+			name:        "relative log directory",
+			input:       map[string]string{logDirKey: "logs"},
+			expectedErr: true,
+		},
+		{
+			name:     "valid kubelet static pod path",
+			input:    map[string]string{kubeletStaticPodPathKey: "D:\\monitoring\\manifests\\"},
+			expected: &Settings{KubeletStaticPodPath: "D:\\monitoring\\manifests"},
+		},
+		{
+			name:        "relative kubelet static pod path",
+			input:       map[string]string{kubeletStaticPodPathKey: "manifests"},
+			expectedErr: true,
+		},
+		{
+			name:        "kubelet static pod path with parent directory",
+			input:       map[string]string{kubeletStaticPodPathKey: "D:\\monitoring\\..\\manifests"},
+			expectedErr: true,
+		},
+		{
+			name:        "relative kubelet certificate directory",
+			input:       map[string]string{kubeletCertDirKey: "var\\pki"},
+			expectedErr: true,
+		},
+		{
+			name:     "valid minimum free memory",
+			input:    map[string]string{minFreeMemoryMBKey: "2048"},
+			expected: &Settings{MinFreeMemory: 2048 * 1024 * 1024},
+		},
+		{
+			name:        "zero minimum free memory",
+			input:       map[string]string{minFreeMemoryMBKey: "0"},
+			expectedErr: true,
+		},
+		{
+			name:        "minimum free memory with unit",
+			input:       map[string]string{minFreeMemoryMBKey: "2Gi"},
+			expectedErr: true,
+		},
+		{
+			name:     "small crash dumps",
+			input:    map[string]string{crashDumpTypeKey: "Small"},
+			expected: &Settings{CrashDumpType: "Small"},
+		},
+		{
+			name:     "crash dumps disabled",
+			input:    map[string]string{crashDumpTypeKey: "None"},
+			expected: &Settings{CrashDumpType: CrashDumpTypeNone},
+		},
+		{
+			name:        "invalid crash dump type",
+			input:       map[string]string{crashDumpTypeKey: "mini"},
+			expectedErr: true,
+		},
+		{
+			name:     "SChannel protocols disabled",
+			input:    map[string]string{schannelDisabledProtocolsKey: "TLS1.0, TLS1.1,TLS1.0,"},
+			expected: &Settings{SChannelDisabledProtocols: []string{"TLS1.0", "TLS1.1"}},
+		},
+		{
+			name:        "current SChannel protocol disabled",
+			input:       map[string]string{schannelDisabledProtocolsKey: "TLS1.1,TLS1.2"},
+			expectedErr: true,
+		},
+		{
+			name:        "no SChannel protocols",
+			input:       map[string]string{schannelDisabledProtocolsKey: " , "},
+			expectedErr: true,
+		},
+		{
+			name:     "valid pagefile size",
+			input:    map[string]string{pagefileMinSizeMBKey: "8192"},
+			expected: &Settings{PagefileMinSizeMB: 8192},
+		},
+		{
+			name:        "pagefile size too large",
+			input:       map[string]string{pagefileMinSizeMBKey: "99999999999"},
+			expectedErr: true,
+		},
+		{
+			name:     "valid WICD configuration timeout",
+			input:    map[string]string{wicdConfigurationTimeoutKey: "5m"},
+			expected: &Settings{WICDConfigurationTimeout: 5 * time.Minute},
+		},
+		{
+			name:     "configuration timeout",
+			input:    map[string]string{configurationTimeoutKey: "90m", wicdConfigurationTimeoutKey: "20m"},
+			expected: &Settings{ConfigurationTimeout: 90 * time.Minute, WICDConfigurationTimeout: 20 * time.Minute},
+		},
+		{
+			name:        "invalid configuration timeout",
+			input:       map[string]string{configurationTimeoutKey: "0s"},
+			expectedErr: true,
+		},
+		{
+			name:        "configuration timeout shorter than WICD configuration timeout",
+			input:       map[string]string{configurationTimeoutKey: "10m", wicdConfigurationTimeoutKey: "15m"},
+			expectedErr: true,
+		},
+		{
+			name:        "WICD configuration timeout not shorter than default configuration timeout",
+			input:       map[string]string{wicdConfigurationTimeoutKey: "1h"},
+			expectedErr: true,
+		},
+		{
+			name:        "WICD configuration timeout without unit",
+			input:       map[string]string{wicdConfigurationTimeoutKey: "300"},
+			expectedErr: true,
+		},
+		{
+			name:        "negative WICD configuration timeout",
+			input:       map[string]string{wicdConfigurationTimeoutKey: "-5m"},
+			expectedErr: true,
+		},
+		{
+			name:     "valid upgrade stall timeout",
+			input:    map[string]string{upgradeStallTimeoutKey: "1h"},
+			expected: &Settings{UpgradeStallTimeout: time.Hour},
+		},
+		{
+			name:        "zero upgrade stall timeout",
+			input:       map[string]string{upgradeStallTimeoutKey: "0s"},
+			expectedErr: true,
+		},
+		{
+			name: "valid external connectivity check",
+			input: map[string]string{externalConnectivityCheckPortKey: "443", externalConnectivityCheckRetriesKey: "5",
+				externalConnectivityCheckTimeoutKey: "3s"},
+			expected: &Settings{ExternalConnectivityCheckPort: 443, ExternalConnectivityCheckRetries: 5,
+				ExternalConnectivityCheckTimeout: 3 * time.Second},
+		},
+		{
+			name:        "external connectivity check port out of range",
+			input:       map[string]string{externalConnectivityCheckPortKey: "65536"},
+			expectedErr: true,
+		},
+		{
+			name:        "zero external connectivity check port",
+			input:       map[string]string{externalConnectivityCheckPortKey: "0"},
+			expectedErr: true,
+		},
+		{
+			name:        "zero external connectivity check retries",
+			input:       map[string]string{externalConnectivityCheckRetriesKey: "0"},
+			expectedErr: true,
+		},
+		{
+			name:        "too many external connectivity check retries",
+			input:       map[string]string{externalConnectivityCheckRetriesKey: "11"},
+			expectedErr: true,
+		},
+		{
+			name:        "negative external connectivity check timeout",
+			input:       map[string]string{externalConnectivityCheckTimeoutKey: "-1s"},
+			expectedErr: true,
+		},
+		{
+			name:        "too long external connectivity check timeout",
+			input:       map[string]string{externalConnectivityCheckTimeoutKey: "31s"},
+			expectedErr: true,
+		},
+		{
+			name:     "valid HNS outbound NAT exceptions",
+			input:    map[string]string{hnsOutboundNATExceptionsKey: "10.0.0.0/8, 192.168.1.5/24"},
+			expected: &Settings{HNSOutboundNATExceptions: []string{"10.0.0.0/8", "192.168.1.0/24"}},
+		},
+		{
+			name:        "IPv6 HNS outbound NAT exception",
+			input:       map[string]string{hnsOutboundNATExceptionsKey: "fd00::/64"},
+			expectedErr: true,
+		},
+		{
+			name:        "duplicate HNS outbound NAT exception",
+			input:       map[string]string{hnsOutboundNATExceptionsKey: "10.0.0.0/8,10.1.0.0/8"},
+			expectedErr: true,
+		},
+		{
+			name:        "empty HNS outbound NAT exceptions",
+			input:       map[string]string{hnsOutboundNATExceptionsKey: ","},
+			expectedErr: true,
+		},
+		{
+			name: "valid HNS ACL policies",
+			input: map[string]string{hnsACLPoliciesKey: "action=Block,direction=Out,protocol=TCP," +
+				"remoteAddress=169.254.169.254/32,remotePort=80,priority=200; action=Allow,direction=In,priority=300"},
+			expected: &Settings{HNSACLPolicies: []HNSACLPolicy{
+				{Action: HNSACLActionBlock, Direction: HNSACLDirectionOut, Protocol: "6",
+					RemoteAddress: "169.254.169.254/32", RemotePort: 80, Priority: 200},
+				{Action: HNSACLActionAllow, Direction: HNSACLDirectionIn, Priority: 300},
+			}},
+		},
+		{
+			name:        "HNS ACL policy without priority",
+			input:       map[string]string{hnsACLPoliciesKey: "action=Block,direction=Out"},
+			expectedErr: true,
+		},
+		{
+			name:        "HNS ACL policy with unknown action",
+			input:       map[string]string{hnsACLPoliciesKey: "action=Deny,direction=Out,priority=200"},
+			expectedErr: true,
+		},
+		{
+			name:        "HNS ACL policy with unknown field",
+			input:       map[string]string{hnsACLPoliciesKey: "action=Block,direction=Out,priority=200,ruleType=Host"},
+			expectedErr: true,
+		},
+		{
+			name: "HNS ACL policy with port for ICMP",
+			input: map[string]string{hnsACLPoliciesKey: "action=Block,direction=Out,protocol=ICMP,localPort=80," +
+				"priority=200"},
+			expectedErr: true,
+		},
+		{
+			name:        "HNS ACL policy priority out of range",
+			input:       map[string]string{hnsACLPoliciesKey: "action=Block,direction=Out,priority=65501"},
+			expectedErr: true,
+		},
+		{
+			name:     "kubelet cgroups per QoS enabled",
+			input:    map[string]string{kubeletCgroupsPerQOSKey: "true"},
+			expected: &Settings{KubeletCgroupsPerQOS: true},
+		},
+		{
+			name:        "invalid kubelet cgroups per QoS",
+			input:       map[string]string{kubeletCgroupsPerQOSKey: "enabled"},
+			expectedErr: true,
+		},
+		{
+			name:     "kubelet cluster DNS",
+			input:    map[string]string{kubeletClusterDNSKey: "172.30.0.53"},
+			expected: &Settings{KubeletClusterDNS: "172.30.0.53"},
+		},
+		{
+			name:     "IPv6 kubelet cluster DNS",
+			input:    map[string]string{kubeletClusterDNSKey: "fd02:0::35"},
+			expected: &Settings{KubeletClusterDNS: "fd02::35"},
+		},
+		{
+			name:        "invalid kubelet cluster DNS",
+			input:       map[string]string{kubeletClusterDNSKey: "172.30.0.0/16"},
+			expectedErr: true,
+		},
+		{
+			name:     "valid kubelet node status update frequency",
+			input:    map[string]string{kubeletNodeStatusUpdateFrequencyKey: "20s"},
+			expected: &Settings{KubeletNodeStatusUpdateFrequency: 20 * time.Second},
+		},
+		{
+			name:     "kubelet sync frequency",
+			input:    map[string]string{kubeletSyncFrequencyKey: "2m"},
+			expected: &Settings{KubeletSyncFrequency: 2 * time.Minute},
+		},
+		{
+			name:        "invalid kubelet sync frequency",
+			input:       map[string]string{kubeletSyncFrequencyKey: "0s"},
+			expectedErr: true,
+		},
+		{
+			name:        "zero kubelet node status update frequency",
+			input:       map[string]string{kubeletNodeStatusUpdateFrequencyKey: "0s"},
+			expectedErr: true,
+		},
+		{
+			name:        "negative kubelet node status update frequency",
+			input:       map[string]string{kubeletNodeStatusUpdateFrequencyKey: "-10s"},
+			expectedErr: true,
+		},
+		{
+			name:     "kubelet hardening enabled",
+			input:    map[string]string{kubeletHardeningKey: "true"},
+			expected: &Settings{KubeletHardening: true},
+		},
+		{
+			name:        "invalid kubelet hardening",
+			input:       map[string]string{kubeletHardeningKey: "cis"},
+			expectedErr: true,
+		},
+		{
+			name:     "kubelet feature gates",
+			input:    map[string]string{kubeletFeatureGatesKey: "KubeletTracing=true, SidecarContainers=false"},
+			expected: &Settings{KubeletFeatureGates: map[string]bool{"KubeletTracing": true, "SidecarContainers": false}},
+		},
+		{
+			name:     "mandatory kubelet feature gate enabled",
+			input:    map[string]string{kubeletFeatureGatesKey: "RotateKubeletServerCertificate=true"},
+			expected: &Settings{KubeletFeatureGates: map[string]bool{RotateKubeletServerCertificateGate: true}},
+		},
+		{
+			name:        "mandatory kubelet feature gate disabled",
+			input:       map[string]string{kubeletFeatureGatesKey: "KubeletTracing=true,RotateKubeletServerCertificate=false"},
+			expectedErr: true,
+		},
+		{
+			name:        "unknown kubelet feature gate",
+			input:       map[string]string{kubeletFeatureGatesKey: "WindowsGracefulNodeShutdown=true"},
+			expectedErr: true,
+		},
+		{
+			name:        "duplicate kubelet feature gate",
+			input:       map[string]string{kubeletFeatureGatesKey: "KubeletTracing=true,KubeletTracing=false"},
+			expectedErr: true,
+		},
+		{
+			name:        "invalid kubelet feature gate state",
+			input:       map[string]string{kubeletFeatureGatesKey: "KubeletTracing=on"},
+			expectedErr: true,
+		},
+		{
+			name: "valid kubelet shutdown grace periods",
+			input: map[string]string{kubeletShutdownGracePeriodKey: "60s",
+				kubeletShutdownGracePeriodCriticalPodsKey: "20s"},
+			expected: &Settings{KubeletShutdownGracePeriod: time.Minute,
+				KubeletShutdownGracePeriodCriticalPods: 20 * time.Second},
+		},
+		{
+			name:     "kubelet shutdown grace period without critical pods period",
+			input:    map[string]string{kubeletShutdownGracePeriodKey: "30s"},
+			expected: &Settings{KubeletShutdownGracePeriod: 30 * time.Second},
+		},
+		{
+			name:        "zero kubelet shutdown grace period",
+			input:       map[string]string{kubeletShutdownGracePeriodKey: "0s"},
+			expectedErr: true,
+		},
+		{
+			name: "kubelet critical pods shutdown grace period exceeding the grace period",
+			input: map[string]string{kubeletShutdownGracePeriodKey: "30s",
+				kubeletShutdownGracePeriodCriticalPodsKey: "1m"},
+			expectedErr: true,
+		},
+		{
+			name:        "kubelet critical pods shutdown grace period without grace period",
+			input:       map[string]string{kubeletShutdownGracePeriodCriticalPodsKey: "10s"},
+			expectedErr: true,
+		},
+		{
+			name: "valid kubelet credential providers",
+			input: map[string]string{kubeletCredentialProvidersKey: "- name: acr-credential-provider\n" +
+				"  matchImages: [\"*.azurecr.io\"]\n" +
+				"  defaultCacheDuration: 10m\n" +
+				"  apiVersion: credentialprovider.kubelet.k8s.io/v1\n" +
+				"  args: [\"--config\", \"C:\\\\k\\\\acr.json\"]\n"},
+			expected: &Settings{KubeletCredentialProviders: []kubeletconfigv1.CredentialProvider{{
+				Name:                 "acr-credential-provider",
+				MatchImages:          []string{"*.azurecr.io"},
+				DefaultCacheDuration: &meta.Duration{Duration: 10 * time.Minute},
+				APIVersion:           "credentialprovider.kubelet.k8s.io/v1",
+				Args:                 []string{"--config", "C:\\k\\acr.json"},
+			}}},
+		},
+		{
+			name: "kubelet credential providers with duplicate names",
+			input: map[string]string{kubeletCredentialProvidersKey: "- {name: provider, matchImages: [a.io], " +
+				"defaultCacheDuration: 1m, apiVersion: credentialprovider.kubelet.k8s.io/v1}\n" +
+				"- {name: provider.exe, matchImages: [b.io], defaultCacheDuration: 1m, " +
+				"apiVersion: credentialprovider.kubelet.k8s.io/v1}\n"},
+			expectedErr: true,
+		},
+		{
+			name: "kubelet credential provider with a path as name",
+			input: map[string]string{kubeletCredentialProvidersKey: "- {name: ..\\\\provider, matchImages: [a.io], " +
+				"defaultCacheDuration: 1m, apiVersion: credentialprovider.kubelet.k8s.io/v1}\n"},
+			expectedErr: true,
+		},
+		{
+			name: "kubelet credential provider without images",
+			input: map[string]string{kubeletCredentialProvidersKey: "- {name: provider, matchImages: [], " +
+				"defaultCacheDuration: 1m, apiVersion: credentialprovider.kubelet.k8s.io/v1}\n"},
+			expectedErr: true,
+		},
+		{
+			name: "kubelet credential provider without cache duration",
+			input: map[string]string{kubeletCredentialProvidersKey: "- {name: provider, matchImages: [a.io], " +
+				"apiVersion: credentialprovider.kubelet.k8s.io/v1}\n"},
+			expectedErr: true,
+		},
+		{
+			name: "kubelet credential provider with unsupported API version",
+			input: map[string]string{kubeletCredentialProvidersKey: "- {name: provider, matchImages: [a.io], " +
+				"defaultCacheDuration: 1m, apiVersion: credentialprovider.kubelet.k8s.io/v2}\n"},
+			expectedErr: true,
+		},
+		{
+			name: "kubelet credential provider with unknown field",
+			input: map[string]string{kubeletCredentialProvidersKey: "- {name: provider, matchImages: [a.io], " +
+				"defaultCacheDuration: 1m, apiVersion: credentialprovider.kubelet.k8s.io/v1, unknown: true}\n"},
+			expectedErr: true,
+		},
+		{
+			name:        "kubelet credential providers not given as a list",
+			input:       map[string]string{kubeletCredentialProvidersKey: "name: provider"},
+			expectedErr: true,
+		},
+		{
+			name:        "kubelet node status update frequency without unit",
+			input:       map[string]string{kubeletNodeStatusUpdateFrequencyKey: "10"},
+			expectedErr: true,
+		},
+		{
+			name: "valid SSH algorithms",
+			input: map[string]string{sshCiphersKey: "aes256-gcm@openssh.com, aes256-ctr",
+				sshKeyExchangesKey: "ecdh-sha2-nistp384,diffie-hellman-group16-sha512", sshMACsKey: "hmac-sha2-512",
+				sshHostKeyAlgorithmsKey: "rsa-sha2-512,ecdsa-sha2-nistp384"},
+			expected: &Settings{SSHCiphers: []string{"aes256-gcm@openssh.com", "aes256-ctr"},
+				SSHKeyExchanges: []string{"ecdh-sha2-nistp384", "diffie-hellman-group16-sha512"},
+				SSHMACs:         []string{"hmac-sha2-512"}, SSHHostKeyAlgorithms: []string{"rsa-sha2-512", "ecdsa-sha2-nistp384"}},
+		},
+		{
+			name:        "unsupported SSH cipher",
+			input:       map[string]string{sshCiphersKey: "aes128-ctr,blowfish-cbc"},
+			expectedErr: true,
+		},
+		{
+			name:        "SSH key exchange given as a cipher",
+			input:       map[string]string{sshKeyExchangesKey: "aes128-ctr"},
+			expectedErr: true,
+		},
+		{
+			name:        "duplicate SSH MAC",
+			input:       map[string]string{sshMACsKey: "hmac-sha2-256,hmac-sha2-256"},
+			expectedErr: true,
+		},
+		{
+			name:        "empty SSH host key algorithms",
+			input:       map[string]string{sshHostKeyAlgorithmsKey: " , "},
+			expectedErr: true,
+		},
+		{
+			name: "valid SSH known hosts",
+			input: map[string]string{sshKnownHostsKey: "# BYOH instances\n10.0.0.5,Win-1.example.com " + hostKey +
+				"\n[10.0.0.6]:2222 " + hostKey + "\n10.0.0.5 " + otherHostKey + " rotated\n",
+				sshHostKeyPolicyKey: SSHHostKeyPolicyStrict},
+			expected: &Settings{SSHKnownHosts: map[string][]ssh.PublicKey{
+				"10.0.0.5":          {parsedHostKey, parsedOtherHostKey},
+				"win-1.example.com": {parsedHostKey},
+				"[10.0.0.6]:2222":   {parsedHostKey},
+			}, SSHHostKeyPolicy: SSHHostKeyPolicyStrict},
+		},
+		{
+			name:        "SSH known hosts with a hashed host name",
+			input:       map[string]string{sshKnownHostsKey: "|1|c2FsdA==|aGFzaA== " + hostKey},
+			expectedErr: true,
+		},
+		{
+			name:        "SSH known hosts with a host pattern",
+			input:       map[string]string{sshKnownHostsKey: "10.0.0.* " + hostKey},
+			expectedErr: true,
+		},
+		{
+			name:        "SSH known hosts with a marker",
+			input:       map[string]string{sshKnownHostsKey: "@cert-authority *.example.com " + hostKey},
+			expectedErr: true,
+		},
+		{
+			name:        "SSH known hosts with an invalid key",
+			input:       map[string]string{sshKnownHostsKey: "10.0.0.5 ssh-ed25519 invalid"},
+			expectedErr: true,
+		},
+		{
+			name:        "empty SSH known hosts",
+			input:       map[string]string{sshKnownHostsKey: "# no hosts\n"},
+			expectedErr: true,
+		},
+		{
+			name:     "SSH host key verification disabled",
+			input:    map[string]string{sshHostKeyPolicyKey: SSHHostKeyPolicyIgnore},
+			expected: &Settings{SSHHostKeyPolicy: SSHHostKeyPolicyIgnore},
+		},
+		{
+			name:        "strict SSH host key policy without known hosts",
+			input:       map[string]string{sshHostKeyPolicyKey: SSHHostKeyPolicyStrict},
+			expectedErr: true,
+		},
+		{
+			name:        "invalid SSH host key policy",
+			input:       map[string]string{sshHostKeyPolicyKey: "verify"},
+			expectedErr: true,
+		},
+		{
+			name:     "valid SFTP tuning",
+			input:    map[string]string{sftpMaxPacketSizeKey: "65536", sftpMaxConcurrentRequestsKey: "128"},
+			expected: &Settings{SFTPMaxPacketSize: 65536, SFTPMaxConcurrentRequests: 128},
+		},
+		{
+			name:        "SFTP packet size too small",
+			input:       map[string]string{sftpMaxPacketSizeKey: "512"},
+			expectedErr: true,
+		},
+		{
+			name:        "SFTP packet size too large",
+			input:       map[string]string{sftpMaxPacketSizeKey: "131072"},
+			expectedErr: true,
+		},
+		{
+			name:        "zero SFTP concurrent requests",
+			input:       map[string]string{sftpMaxConcurrentRequestsKey: "0"},
+			expectedErr: true,
+		},
+		{
+			name:        "invalid SFTP concurrent requests",
+			input:       map[string]string{sftpMaxConcurrentRequestsKey: "many"},
+			expectedErr: true,
+		},
+		{
+			name:     "valid drain attempts",
+			input:    map[string]string{drainMaxAttemptsKey: "10"},
+			expected: &Settings{DrainMaxAttempts: 10},
+		},
+		{
+			name:        "zero drain attempts",
+			input:       map[string]string{drainMaxAttemptsKey: "0"},
+			expectedErr: true,
+		},
+		{
+			name:        "too many drain attempts",
+			input:       map[string]string{drainMaxAttemptsKey: "256"},
+			expectedErr: true,
+		},
+		{
+			name:     "valid trusted CA bundle sync concurrency",
+			input:    map[string]string{trustedCABundleSyncConcurrencyKey: "20"},
+			expected: &Settings{TrustedCABundleSyncConcurrency: 20},
+		},
+		{
+			name:        "zero trusted CA bundle sync concurrency",
+			input:       map[string]string{trustedCABundleSyncConcurrencyKey: "0"},
+			expectedErr: true,
+		},
+		{
+			name:        "invalid trusted CA bundle sync concurrency",
+			input:       map[string]string{trustedCABundleSyncConcurrencyKey: "all"},
+			expectedErr: true,
+		},
+		{
+			name: "drain protected pods evicted last",
+			input: map[string]string{drainProtectedPodSelectorKey: "app=critical-agent",
+				drainProtectedPodGracePeriodKey: "10m"},
+			expected: &Settings{DrainProtectedPodSelector: "app=critical-agent",
+				DrainProtectedPodGracePeriod: 10 * time.Minute},
+		},
+		{
+			name: "drain refused for protected pods",
+			input: map[string]string{drainProtectedPodSelectorKey: "tier in (critical)",
+				drainProtectedPodPolicyKey: "Refuse"},
+			expected: &Settings{DrainProtectedPodSelector: "tier in (critical)",
+				DrainProtectedPodPolicy: DrainProtectedPodPolicyRefuse},
+		},
+		{
+			name:        "invalid drain protected pod selector",
+			input:       map[string]string{drainProtectedPodSelectorKey: "app in critical"},
+			expectedErr: true,
+		},
+		{
+			name:        "drain protected pod selector matching all pods",
+			input:       map[string]string{drainProtectedPodSelectorKey: " "},
+			expectedErr: true,
+		},
+		{
+			name:        "invalid drain protected pod policy",
+			input:       map[string]string{drainProtectedPodSelectorKey: "app=agent", drainProtectedPodPolicyKey: "Wait"},
+			expectedErr: true,
+		},
+		{
+			name:        "drain protected pod policy without selector",
+			input:       map[string]string{drainProtectedPodPolicyKey: "Refuse"},
+			expectedErr: true,
+		},
+		{
+			name: "drain protected pod grace period with refused drains",
+			input: map[string]string{drainProtectedPodSelectorKey: "app=agent", drainProtectedPodPolicyKey: "Refuse",
+				drainProtectedPodGracePeriodKey: "1m"},
+			expectedErr: true,
+		},
+		{
+			name:        "negative drain protected pod grace period",
+			input:       map[string]string{drainProtectedPodSelectorKey: "app=agent", drainProtectedPodGracePeriodKey: "-1m"},
+			expectedErr: true,
+		},
+		{
+			name:     "valid reboot detection settings",
+			input:    map[string]string{rebootDetectionDelayKey: "30s", rebootDetectionIntervalKey: "2s"},
+			expected: &Settings{RebootDetectionDelay: 30 * time.Second, RebootDetectionInterval: 2 * time.Second},
+		},
+		{
+			name:  "WICD recovery actions",
+			input: map[string]string{wicdRecoveryDelaysKey: "5s, 20s,1m30s", wicdRecoveryResetPeriodKey: "10m"},
+			expected: &Settings{WICDRecoveryDelays: []time.Duration{5 * time.Second, 20 * time.Second,
+				90 * time.Second}, WICDRecoveryResetPeriod: 10 * time.Minute},
+		},
+		{
+			name:        "zero WICD recovery delay",
+			input:       map[string]string{wicdRecoveryDelaysKey: "10s,0s"},
+			expectedErr: true,
+		},
+		{
+			name:        "WICD recovery delay too long",
+			input:       map[string]string{wicdRecoveryDelaysKey: "2h"},
+			expectedErr: true,
+		},
+		{
+			name:        "too many WICD recovery delays",
+			input:       map[string]string{wicdRecoveryDelaysKey: "1s,1s,1s,1s,1s,1s,1s,1s,1s,1s,1s"},
+			expectedErr: true,
+		},
+		{
+			name:        "empty WICD recovery delay",
+			input:       map[string]string{wicdRecoveryDelaysKey: "10s,"},
+			expectedErr: true,
+		},
+		{
+			name:        "WICD recovery reset period too short",
+			input:       map[string]string{wicdRecoveryResetPeriodKey: "30s"},
+			expectedErr: true,
+		},
+		{
+			name:        "WICD recovery reset period with fractional seconds",
+			input:       map[string]string{wicdRecoveryResetPeriodKey: "5m0.5s"},
+			expectedErr: true,
+		},
+		{
+			name:     "WICD virtual service account",
+			input:    map[string]string{wicdServiceAccountKey: "NT SERVICE\\windows-instance-config-daemon"},
+			expected: &Settings{WICDServiceAccount: "NT SERVICE\\windows-instance-config-daemon"},
+		},
+		{
+			name:     "WICD group managed service account",
+			input:    map[string]string{wicdServiceAccountKey: "CONTOSO\\wicd-gmsa$"},
+			expected: &Settings{WICDServiceAccount: "CONTOSO\\wicd-gmsa$"},
+		},
+		{
+			name:     "WICD running as LocalSystem",
+			input:    map[string]string{wicdServiceAccountKey: "localsystem"},
+			expected: &Settings{},
+		},
+		{
+			name:        "WICD service account requiring a password",
+			input:       map[string]string{wicdServiceAccountKey: "CONTOSO\\wicd"},
+			expectedErr: true,
+		},
+		{
+			name:        "WICD service account with a name too long for a managed service account",
+			input:       map[string]string{wicdServiceAccountKey: "CONTOSO\\windows-instance-config$"},
+			expectedErr: true,
+		},
+		{
+			name:        "WICD service account with a quote",
+			input:       map[string]string{wicdServiceAccountKey: "NT SERVICE\\wicd'"},
+			expectedErr: true,
+		},
+		{
+			name:        "WICD running as NetworkService",
+			input:       map[string]string{wicdServiceAccountKey: "NT AUTHORITY\\NetworkService"},
+			expectedErr: true,
+		},
+		{
+			name:        "zero reboot detection delay",
+			input:       map[string]string{rebootDetectionDelayKey: "0s"},
+			expectedErr: true,
+		},
+		{
+			name:        "reboot detection interval without unit",
+			input:       map[string]string{rebootDetectionIntervalKey: "5"},
+			expectedErr: true,
+		},
+		{
+			name:     "valid NTP servers",
+			input:    map[string]string{ntpServersKey: "time.example.com, 10.0.0.1,fd00::1"},
+			expected: &Settings{NTPServers: []string{"time.example.com", "10.0.0.1", "fd00::1"}},
+		},
+		{
+			name:        "NTP server with invalid characters",
+			input:       map[string]string{ntpServersKey: "time.example.com' ; Restart-Computer"},
+			expectedErr: true,
+		},
+		{
+			name:        "empty NTP servers",
+			input:       map[string]string{ntpServersKey: ","},
+			expectedErr: true,
+		},
+		{
+			name:     "add Kubernetes directories to PATH",
+			input:    map[string]string{addK8sDirsToPathKey: "true"},
+			expected: &Settings{AddK8sDirsToPath: true},
+		},
+		{
+			name:        "invalid add Kubernetes directories to PATH",
+			input:       map[string]string{addK8sDirsToPathKey: "1.0"},
+			expectedErr: true,
+		},
+		{
+			name:     "leave nodes cordoned",
+			input:    map[string]string{leaveNodesCordonedKey: "true"},
+			expected: &Settings{LeaveNodesCordoned: true},
+		},
+		{
+			name:        "invalid leave nodes cordoned",
+			input:       map[string]string{leaveNodesCordonedKey: "yes"},
+			expectedErr: true,
+		},
+		{
+			name: "post-configuration validation",
+			input: map[string]string{postConfigurationValidationConfigMapKey: "compliance-check",
+				postConfigurationValidationTimeoutKey: "5m"},
+			expected: &Settings{PostConfigurationValidationConfigMap: "compliance-check",
+				PostConfigurationValidationTimeout: 5 * time.Minute},
+		},
+		{
+			name:        "invalid post-configuration validation ConfigMap",
+			input:       map[string]string{postConfigurationValidationConfigMapKey: "Compliance_Check"},
+			expectedErr: true,
+		},
+		{
+			name:        "post-configuration validation timeout without ConfigMap",
+			input:       map[string]string{postConfigurationValidationTimeoutKey: "5m"},
+			expectedErr: true,
+		},
+		{
+			name: "post-configuration validation timeout not shorter than default configuration timeout",
+			input: map[string]string{postConfigurationValidationConfigMapKey: "compliance-check",
+				postConfigurationValidationTimeoutKey: "2h"},
+			expectedErr: true,
+		},
+		{
+			name: "configuration timeout shorter than post-configuration validation timeout",
+			input: map[string]string{configurationTimeoutKey: "10m",
+				postConfigurationValidationConfigMapKey: "compliance-check",
+				postConfigurationValidationTimeoutKey:   "15m"},
+			expectedErr: true,
+		},
+		{
+			name:     "manage RuntimeClasses",
+			input:    map[string]string{manageRuntimeClassesKey: "true"},
+			expected: &Settings{ManageRuntimeClasses: true},
+		},
+		{
+			name:        "invalid manage RuntimeClasses",
+			input:       map[string]string{manageRuntimeClassesKey: "enabled"},
+			expectedErr: true,
+		},
+		{
+			name:     "valid host-process helper image",
+			input:    map[string]string{hostProcessHelperImageKey: "quay.io/example/helper@sha256:0123456789abcdef"},
+			expected: &Settings{HostProcessHelperImage: "quay.io/example/helper@sha256:0123456789abcdef"},
+		},
+		{
+			name:        "host-process helper image with whitespace",
+			input:       map[string]string{hostProcessHelperImageKey: "quay.io/example/helper:v1 --privileged"},
+			expectedErr: true,
+		},
+		{
+			name:        "empty host-process helper image",
+			input:       map[string]string{hostProcessHelperImageKey: ""},
+			expectedErr: true,
+		},
+		{
+			name:     "power plan name",
+			input:    map[string]string{powerPlanKey: "HighPerformance"},
+			expected: &Settings{PowerPlan: "8c5e7fda-e8bf-4a96-9a85-a6e23a8c635c"},
+		},
+		{
+			name:     "power plan GUID",
+			input:    map[string]string{powerPlanKey: "E9A42B02-D5DF-448D-AA00-03F14749EB61"},
+			expected: &Settings{PowerPlan: "e9a42b02-d5df-448d-aa00-03f14749eb61"},
+		},
+		{
+			name:        "unknown power plan name",
+			input:       map[string]string{powerPlanKey: "High performance"},
+			expectedErr: true,
+		},
+		{
+			name:        "malformed power plan GUID",
+			input:       map[string]string{powerPlanKey: "8c5e7fda-e8bf-4a96-9a85"},
+			expectedErr: true,
+		},
+		{
+			name:     "dynamic port range",
+			input:    map[string]string{dynamicPortRangeKey: "10000-19999"},
+			expected: &Settings{DynamicPortRange: &utilnet.PortRange{Base: 10000, Size: 10000}},
+		},
+		{
+			name:        "dynamic port range given as a single port",
+			input:       map[string]string{dynamicPortRangeKey: "10000"},
+			expectedErr: true,
+		},
+		{
+			name:        "dynamic port range below the lowest dynamic port",
+			input:       map[string]string{dynamicPortRangeKey: "1000-2000"},
+			expectedErr: true,
+		},
+		{
+			name:        "dynamic port range too small",
+			input:       map[string]string{dynamicPortRangeKey: "10000-10100"},
+			expectedErr: true,
+		},
+		{
+			name:        "dynamic port range beyond the highest port",
+			input:       map[string]string{dynamicPortRangeKey: "60000-70000"},
+			expectedErr: true,
+		},
+		{
+			name:        "reversed dynamic port range",
+			input:       map[string]string{dynamicPortRangeKey: "19999-10000"},
+			expectedErr: true,
+		},
+		{
+			name:  "firewall profiles",
+			input: map[string]string{firewallProfilesKey: "Domain=true, private=false,PUBLIC=true"},
+			expected: &Settings{FirewallProfiles: map[string]bool{FirewallProfileDomain: true,
+				FirewallProfilePrivate: false, FirewallProfilePublic: true}},
+		},
+		{
+			name:        "unknown firewall profile",
+			input:       map[string]string{firewallProfilesKey: "Domain=true,Work=false"},
+			expectedErr: true,
+		},
+		{
+			name:        "duplicate firewall profile",
+			input:       map[string]string{firewallProfilesKey: "Public=true,public=false"},
+			expectedErr: true,
+		},
+		{
+			name:        "invalid firewall profile state",
+			input:       map[string]string{firewallProfilesKey: "Public=enabled"},
+			expectedErr: true,
+		},
+		{
+			name:        "firewall profile without state",
+			input:       map[string]string{firewallProfilesKey: "Public"},
+			expectedErr: true,
+		},
+		{
+			name:     "valid non-interactive desktop heap size",
+			input:    map[string]string{nonInteractiveDesktopHeapKBKey: "4096"},
+			expected: &Settings{NonInteractiveDesktopHeapKB: 4096},
+		},
+		{
+			name:        "negative non-interactive desktop heap size",
+			input:       map[string]string{nonInteractiveDesktopHeapKBKey: "-768"},
+			expectedErr: true,
+		},
+		{
+			name:     "valid interactive sessions on reboot",
+			input:    map[string]string{interactiveSessionsOnRebootKey: "Refuse"},
+			expected: &Settings{InteractiveSessionsOnReboot: InteractiveSessionsRefuse},
+		},
+		{
+			name:        "invalid interactive sessions on reboot",
+			input:       map[string]string{interactiveSessionsOnRebootKey: "refuse"},
+			expectedErr: true,
+		},
+		{
+			name:     "valid temp file cleanup policy",
+			input:    map[string]string{tempFileCleanupPolicyKey: "Always"},
+			expected: &Settings{TempFileCleanupPolicy: TempFileCleanupAlways},
+		},
+		{
+			name:        "invalid temp file cleanup policy",
+			input:       map[string]string{tempFileCleanupPolicyKey: "on-version-change"},
+			expectedErr: true,
+		},
+		{
+			name:  "valid reboot windows",
+			input: map[string]string{rebootWindowsKey: "22:00-04:00, 12:30-13:00"},
+			expected: &Settings{RebootWindows: []TimeRange{{Start: 22 * time.Hour, End: 4 * time.Hour},
+				{Start: 12*time.Hour + 30*time.Minute, End: 13 * time.Hour}}},
+		},
+		{
+			name:        "reboot window with invalid time",
+			input:       map[string]string{rebootWindowsKey: "22:00-25:00"},
+			expectedErr: true,
+		},
+		{
+			name:        "empty reboot window",
+			input:       map[string]string{rebootWindowsKey: "02:00-02:00"},
+			expectedErr: true,
+		},
+		{
+			name:        "reboot window without end",
+			input:       map[string]string{rebootWindowsKey: "02:00"},
+			expectedErr: true,
+		},
+		{
+			name:        "invalid reboot windows time zone",
+			input:       map[string]string{rebootWindowsTimezoneKey: "Eastern Standard Time"},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := Parse(test.input)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, out)
+		})
+	}
+}
+
+func TestGet(t *testing.T) {
+	namespace := "openshift-windows-machine-config-operator"
+	testCases := []struct {
+		name            string
+		data            map[string]string
+		absent          bool
+		expectedInvalid bool
+		expected        *Settings
+	}{
+		{
+			name:     "ConfigMap absent",
+			absent:   true,
+			expected: &Settings{},
+		},
+		{
+			name:     "valid ConfigMap",
+			data:     map[string]string{timezoneKey: "UTC"},
+			expected: &Settings{Timezone: "UTC"},
+		},
+		{
+			name:            "invalid ConfigMap",
+			data:            map[string]string{"unknown": "value"},
+			expectedInvalid: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			builder := clientfake.NewClientBuilder()
+			if !test.absent {
+				builder = builder.WithObjects(&core.ConfigMap{
+					ObjectMeta: meta.ObjectMeta{Name: ConfigMap, Namespace: namespace}, Data: test.data})
+			}
+			s, err := Get(context.Background(), builder.Build(), namespace)
+			if test.expectedInvalid {
+				assert.ErrorIs(t, err, ErrInvalid)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, s)
+		})
+	}
+}
+
+func TestUntilRebootWindow(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	overnight := []TimeRange{{Start: 22 * time.Hour, End: 4 * time.Hour}}
+	testCases := []struct {
+		name     string
+		settings *Settings
+		now      time.Time
+		expected time.Duration
+	}{
+		{
+			name:     "no reboot windows",
+			settings: &Settings{},
+			now:      time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+			expected: 0,
+		},
+		{
+			name:     "within window before midnight",
+			settings: &Settings{RebootWindows: overnight},
+			now:      time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC),
+			expected: 0,
+		},
+		{
+			name:     "within window after midnight",
+			settings: &Settings{RebootWindows: overnight},
+			now:      time.Date(2024, 1, 1, 3, 59, 0, 0, time.UTC),
+			expected: 0,
+		},
+		{
+			name:     "window end is exclusive",
+			settings: &Settings{RebootWindows: overnight},
+			now:      time.Date(2024, 1, 1, 4, 0, 0, 0, time.UTC),
+			expected: 18 * time.Hour,
+		},
+		{
+			name: "nearest of multiple windows",
+			settings: &Settings{RebootWindows: []TimeRange{{Start: 22 * time.Hour, End: 23 * time.Hour},
+				{Start: 13 * time.Hour, End: 14 * time.Hour}}},
+			now:      time.Date(2024, 1, 1, 12, 30, 0, 0, time.UTC),
+			expected: 30 * time.Minute,
+		},
+		{
+			name:     "window in another time zone",
+			settings: &Settings{RebootWindows: overnight, RebootWindowsLocation: newYork},
+			// 20:00 in New York
+			now:      time.Date(2024, 1, 2, 1, 0, 0, 0, time.UTC),
+			expected: 2 * time.Hour,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.settings.UntilRebootWindow(test.now))
+		})
+	}
+}
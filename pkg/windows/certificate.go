@@ -0,0 +1,55 @@
+package windows
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotCertificate is returned when the expiry of a file which holds no certificate is requested, such as a
+// kubeconfig authenticating with a ServiceAccount token
+var ErrNotCertificate = errors.New("file does not contain a certificate")
+
+func (vm *windows) GetCertificateExpiry(certPath string) (time.Time, error) {
+	out, err := vm.Run(certificateBlocksCmd(certPath), true)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error reading certificate %s with output %s: %w", certPath, out, err)
+	}
+	expiry, err := parseCertificateExpiry(out)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error parsing certificate %s: %w", certPath, err)
+	}
+	return expiry, nil
+}
+
+// certificateBlocksCmd returns the PowerShell command printing the PEM certificate blocks of the file at the given
+// path. Only the certificate blocks are printed, so that private keys stored in the same file, as kubelet does, are
+// not sent back from the instance.
+func certificateBlocksCmd(path string) string {
+	return "$content = Get-Content -Raw -LiteralPath '" + path + "' -ErrorAction Stop; " +
+		"[regex]::Matches($content, '-----BEGIN CERTIFICATE-----[^-]+-----END CERTIFICATE-----') | " +
+		"ForEach-Object { $_.Value }"
+}
+
+// parseCertificateExpiry returns the expiry of the first certificate in the given PEM data, which is the leaf
+// certificate when the data holds a chain. ErrNotCertificate is returned if the data holds no certificate.
+func parseCertificateExpiry(data string) (time.Time, error) {
+	rest := []byte(data)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return time.Time{}, ErrNotCertificate
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return cert.NotAfter, nil
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,9 +19,6 @@ import (
 	"github.com/openshift/windows-machine-config-operator/pkg/retry"
 )
 
-// sshPort is the default SSH port
-const sshPort = "22"
-
 // AuthErr occurs when our authentication into the VM is rejected
 type AuthErr struct {
 	err string
@@ -33,17 +33,83 @@ func newAuthErr(err error) *AuthErr {
 	return &AuthErr{err: err.Error()}
 }
 
+// HostKeyErr occurs when the host key presented by an instance cannot be verified
+type HostKeyErr struct {
+	// address is the known_hosts address of the instance
+	address string
+	// reason describes why the host key was rejected
+	reason string
+}
+
+func (e *HostKeyErr) Error() string {
+	return fmt.Sprintf("SSH host key verification failed for %s: %s", e.address, e.reason)
+}
+
 type connectivity interface {
 	// init initialises the connectivity medium
 	init() error
 	// run executes the given command on the remote system
 	run(cmd string) (string, error)
+	// runWithInput executes the given command on the remote system like run, giving it the given input through stdin
+	runWithInput(cmd, input string) (string, error)
 	// createSFTPClient initializes an SFTP client from the existing SSH client. Caller should close the connection.
 	createSFTPClient() (*sftp.Client, error)
 	// transfer reads from reader and creates a file in the remote VM directory, creating the remote directory if needed
 	transfer(*sftp.Client, io.Reader, string, string) error
 	// transferFiles transfers the given files to a given remote directory
 	transferFiles(*sftp.Client, map[string][]byte, string) error
+	// download returns the contents of the file at the given path on the remote system
+	download(*sftp.Client, string) ([]byte, error)
+	// addLogValues adds the given key/value pairs to all further log entries
+	addLogValues(...interface{})
+	// close closes the connection to the remote system
+	close() error
+}
+
+// SSHAlgorithms are the algorithms negotiated when connecting to an instance over SSH, in order of preference. The SSH
+// library's defaults are used for any which are empty.
+type SSHAlgorithms struct {
+	// Ciphers are the ciphers offered to the instance
+	Ciphers []string
+	// KeyExchanges are the key exchange algorithms offered to the instance
+	KeyExchanges []string
+	// MACs are the MAC algorithms offered to the instance
+	MACs []string
+	// HostKeyAlgorithms are the host key algorithms accepted from the instance
+	HostKeyAlgorithms []string
+}
+
+// SFTPOptions tune the SFTP client used to transfer files to an instance. The SFTP library's defaults are used for any
+// which are 0.
+type SFTPOptions struct {
+	// MaxPacket is the maximum number of bytes of file data in each read or write request
+	MaxPacket int
+	// MaxConcurrentRequests is the maximum number of write requests in flight for each file transferred
+	MaxConcurrentRequests int
+}
+
+// clientOptions returns the options of the SFTP client. Writes are always concurrent, as otherwise each write waits
+// for the instance to acknowledge the previous one, bounding the throughput of transfers by the link's latency.
+func (o SFTPOptions) clientOptions() []sftp.ClientOption {
+	options := []sftp.ClientOption{sftp.UseConcurrentWrites(true)}
+	if o.MaxPacket > 0 {
+		// sizes above 32KiB are not accepted by every SFTP server, and are validated by the settings instead
+		options = append(options, sftp.MaxPacketUnchecked(o.MaxPacket))
+	}
+	if o.MaxConcurrentRequests > 0 {
+		options = append(options, sftp.MaxConcurrentRequestsPerFile(o.MaxConcurrentRequests))
+	}
+	return options
+}
+
+// HostKeyVerification gives the host keys expected from instances when connecting to them over SSH
+type HostKeyVerification struct {
+	// KnownHosts maps the addresses of instances to the host keys expected from them. Addresses are in the
+	// known_hosts format: the host, or [host]:port if its SSH server does not listen on port 22.
+	KnownHosts map[string][]ssh.PublicKey
+	// RequireKnownHost causes connections to instances which are not in KnownHosts to be refused. Otherwise, these
+	// instances are connected to without verifying their host key.
+	RequireKnownHost bool
 }
 
 // sshConnectivity encapsulates the information needed to connect to the Windows VM over ssh
@@ -52,21 +118,40 @@ type sshConnectivity struct {
 	username string
 	// ipAddress is the VM's IP address
 	ipAddress string
+	// port is the port the VM's SSH server listens on
+	port int
 	// signer is used for authenticating against the VM
 	signer ssh.Signer
+	// algorithms are the algorithms negotiated with the VM's SSH server
+	algorithms SSHAlgorithms
+	// sftpOptions tune the SFTP client used to transfer files to the VM
+	sftpOptions SFTPOptions
+	// hostKeys gives the host keys expected from the VM. The VM's host key is not verified if this is nil.
+	hostKeys *HostKeyVerification
 	// sshClient is the client used to access the Windows VM via ssh
 	sshClient *ssh.Client
 	log       logr.Logger
 }
 
-// newSshConnectivity returns an instance of sshConnectivity
-func newSshConnectivity(username, ipAddress string, signer ssh.Signer, logger logr.Logger) (connectivity, error) {
+// newSshConnectivity returns an instance of sshConnectivity. algorithms can be nil, in which case the SSH library's
+// default algorithms are used. hostKeys can be nil, in which case the host key of the VM is not verified. sftpOptions
+// can be nil, in which case the SFTP library's defaults are used, aside from writes being concurrent.
+func newSshConnectivity(username, ipAddress string, port int, signer ssh.Signer, algorithms *SSHAlgorithms,
+	hostKeys *HostKeyVerification, sftpOptions *SFTPOptions, logger logr.Logger) (connectivity, error) {
 	c := &sshConnectivity{
 		username:  username,
 		ipAddress: ipAddress,
+		port:      port,
 		signer:    signer,
+		hostKeys:  hostKeys,
 		log:       logger,
 	}
+	if algorithms != nil {
+		c.algorithms = *algorithms
+	}
+	if sftpOptions != nil {
+		c.sftpOptions = *sftpOptions
+	}
 	if err := c.init(); err != nil {
 		return nil, fmt.Errorf("error instantiating SSH client: %w", err)
 	}
@@ -78,38 +163,165 @@ func (c *sshConnectivity) init() error {
 	if c.username == "" || c.ipAddress == "" || c.signer == nil {
 		return fmt.Errorf("incomplete sshConnectivity information: %v", c)
 	}
-
-	config := &ssh.ClientConfig{
-		User: c.username,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(c.signer),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	if c.port < 1 || c.port > 65535 {
+		return fmt.Errorf("invalid SSH port %d", c.port)
 	}
+
+	config := c.clientConfig()
 	var err error
 	var sshClient *ssh.Client
 	// Retry if we are unable to create a client as the VM could still be executing the steps in its user data
 	err = wait.PollImmediate(time.Minute, retry.Timeout, func() (bool, error) {
-		sshClient, err = ssh.Dial("tcp", c.ipAddress+":"+sshPort, config)
+		sshClient, err = ssh.Dial("tcp", net.JoinHostPort(c.ipAddress, strconv.Itoa(c.port)), config)
 		if err == nil {
 			return true, nil
 		}
-		c.log.V(1).Info("SSH dial", "IP Address", c.ipAddress, "error", err)
+		c.log.V(1).Info("SSH dial", "IP Address", c.ipAddress, "port", c.port, "error", err)
 		if strings.Contains(err.Error(), "unable to authenticate") {
 			// Authentication failure is a special case that must be handled differently
 			return false, newAuthErr(err)
 		}
+		// retrying cannot change the host key presented by the VM
+		var hostKeyErr *HostKeyErr
+		if errors.As(err, &hostKeyErr) {
+			return false, hostKeyErr
+		}
 		return false, nil
 	})
 	if err != nil {
 		return fmt.Errorf("unable to connect to Windows VM %s: %w", c.ipAddress, err)
 	}
+	// the connection being replaced, such as one broken by a reboot, is no longer used
+	if err := c.close(); err != nil {
+		c.log.V(1).Info("error closing previous SSH connection", "error", err)
+	}
 	c.sshClient = sshClient
 	return nil
 }
 
+// close closes the SSH client, if any. Closing a client that is already closed is not an error.
+func (c *sshConnectivity) close() error {
+	if c.sshClient == nil {
+		return nil
+	}
+	err := c.sshClient.Close()
+	c.sshClient = nil
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// clientConfig returns the configuration of the SSH client used to connect to the VM
+func (c *sshConnectivity) clientConfig() *ssh.ClientConfig {
+	return &ssh.ClientConfig{
+		Config: ssh.Config{
+			Ciphers:      c.algorithms.Ciphers,
+			KeyExchanges: c.algorithms.KeyExchanges,
+			MACs:         c.algorithms.MACs,
+		},
+		User: c.username,
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeys(c.signer),
+		},
+		HostKeyCallback:   c.hostKeyCallback(),
+		HostKeyAlgorithms: c.hostKeyAlgorithms(),
+	}
+}
+
+// hostKeyAlgorithms returns the host key algorithms accepted from the VM. If host keys are known for the VM, only the
+// algorithms of these keys are accepted, as the VM could otherwise present a key of another type, which could not be
+// verified. The order of the configured algorithms is kept, and only these are accepted if none match the known keys.
+func (c *sshConnectivity) hostKeyAlgorithms() []string {
+	expected := c.expectedHostKeys()
+	if len(expected) == 0 {
+		return c.algorithms.HostKeyAlgorithms
+	}
+	var known []string
+	for _, key := range expected {
+		for _, algorithm := range hostKeyAlgorithmsOf(key.Type()) {
+			if !slices.Contains(known, algorithm) {
+				known = append(known, algorithm)
+			}
+		}
+	}
+	if len(c.algorithms.HostKeyAlgorithms) == 0 {
+		return known
+	}
+	var algorithms []string
+	for _, algorithm := range c.algorithms.HostKeyAlgorithms {
+		if slices.Contains(known, algorithm) {
+			algorithms = append(algorithms, algorithm)
+		}
+	}
+	if len(algorithms) == 0 {
+		return c.algorithms.HostKeyAlgorithms
+	}
+	return algorithms
+}
+
+// hostKeyAlgorithmsOf returns the host key algorithms which can be used with a key of the given type, in order of
+// preference. RSA keys can be used with SHA-2 signatures as well as the SHA-1 signatures of their key type.
+func hostKeyAlgorithmsOf(keyType string) []string {
+	if keyType == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return []string{keyType}
+}
+
+// expectedHostKeys returns the host keys expected from the VM, which is nil if the VM's host key is not verified or
+// no host key is known for the VM
+func (c *sshConnectivity) expectedHostKeys() []ssh.PublicKey {
+	if c.hostKeys == nil {
+		return nil
+	}
+	return c.hostKeys.KnownHosts[knownHostsAddress(c.ipAddress, c.port)]
+}
+
+// hostKeyCallback returns the callback verifying the host key presented by the VM against the expected host keys
+func (c *sshConnectivity) hostKeyCallback() ssh.HostKeyCallback {
+	if c.hostKeys == nil {
+		return ssh.InsecureIgnoreHostKey()
+	}
+	address := knownHostsAddress(c.ipAddress, c.port)
+	expected := c.expectedHostKeys()
+	if len(expected) == 0 {
+		if !c.hostKeys.RequireKnownHost {
+			return ssh.InsecureIgnoreHostKey()
+		}
+		return func(string, net.Addr, ssh.PublicKey) error {
+			return &HostKeyErr{address: address, reason: "no host key is known for the instance"}
+		}
+	}
+	return func(_ string, _ net.Addr, key ssh.PublicKey) error {
+		for _, expectedKey := range expected {
+			if expectedKey.Type() == key.Type() && bytes.Equal(expectedKey.Marshal(), key.Marshal()) {
+				return nil
+			}
+		}
+		return &HostKeyErr{address: address, reason: fmt.Sprintf("%s host key %s does not match any known host key",
+			key.Type(), ssh.FingerprintSHA256(key))}
+	}
+}
+
+// knownHostsAddress returns the address of the given SSH server in the known_hosts format, in which the port is only
+// given if it is not the standard SSH port
+func knownHostsAddress(host string, port int) string {
+	host = strings.ToLower(host)
+	if port == 22 {
+		return host
+	}
+	return "[" + host + "]:" + strconv.Itoa(port)
+}
+
 // run instantiates a new SSH session and runs the command on the VM and returns the combined stdout and stderr output
 func (c *sshConnectivity) run(cmd string) (string, error) {
+	return c.runWithInput(cmd, "")
+}
+
+// runWithInput runs the command on the VM as run does, writing the given input to its stdin. Unlike arguments of the
+// command, the input is not visible in the process list of the VM.
+func (c *sshConnectivity) runWithInput(cmd, input string) (string, error) {
 	if c.sshClient == nil {
 		return "", fmt.Errorf("run cannot be called with nil SSH client")
 	}
@@ -118,6 +330,9 @@ func (c *sshConnectivity) run(cmd string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if input != "" {
+		session.Stdin = strings.NewReader(input)
+	}
 	defer func() {
 		// io.EOF is returned if you attempt to close a session that is already closed which typically happens given
 		// that Run(), which is called by CombinedOutput(), internally closes the session.
@@ -135,7 +350,7 @@ func (c *sshConnectivity) createSFTPClient() (*sftp.Client, error) {
 		return nil, fmt.Errorf("cannot be called with nil SSH client")
 	}
 
-	sftpClient, err := sftp.NewClient(c.sshClient)
+	sftpClient, err := sftp.NewClient(c.sshClient, c.sftpOptions.clientOptions()...)
 	if err != nil {
 		return nil, err
 	}
@@ -171,6 +386,30 @@ func (c *sshConnectivity) transfer(sftpClient *sftp.Client, reader io.Reader, fi
 	return nil
 }
 
+func (c *sshConnectivity) addLogValues(keysAndValues ...interface{}) {
+	c.log = c.log.WithValues(keysAndValues...)
+}
+
+func (c *sshConnectivity) download(sftpClient *sftp.Client, remotePath string) ([]byte, error) {
+	if sftpClient == nil {
+		return nil, fmt.Errorf("download cannot be called with nil SFTP client")
+	}
+	srcFile, err := sftpClient.Open(remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s on Windows VM: %w", remotePath, err)
+	}
+	defer func() {
+		if err := srcFile.Close(); err != nil {
+			c.log.Error(err, "error closing remote file", "file", remotePath)
+		}
+	}()
+	contents, err := io.ReadAll(srcFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s from the Windows VM: %w", remotePath, err)
+	}
+	return contents, nil
+}
+
 func (c *sshConnectivity) transferFiles(sftpClient *sftp.Client, files map[string][]byte, remoteDir string) error {
 	for workingPath, content := range files {
 		reader := bytes.NewReader(content)
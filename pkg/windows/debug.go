@@ -0,0 +1,341 @@
+package windows
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	// debugLogTailLines is the number of lines collected from the end of each log file in a debug bundle
+	debugLogTailLines = 500
+	// debugServicesFile is the name of the debug bundle file holding the configuration of the WMCO-managed services
+	debugServicesFile = "services.txt"
+	// debugHNSNetworksFile is the name of the debug bundle file holding the state of the HNS networks
+	debugHNSNetworksFile = "hns-networks.json"
+	// debugHNSEndpointsFile is the name of the debug bundle file holding the state of the HNS endpoints
+	debugHNSEndpointsFile = "hns-endpoints.json"
+	// debugChecksumsFile is the name of the debug bundle file holding the checksums of the WMCO-managed files
+	debugChecksumsFile = "checksums.txt"
+	// debugLogsDir is the directory within the debug bundle that log tails are placed in
+	debugLogsDir = "logs"
+	// wicdDiagnosticsLogLines is the number of WICD log lines included in the WICD diagnostics
+	wicdDiagnosticsLogLines = 50
+	// processDumpDir is the directory process dumps are written to on the instance until they are collected
+	processDumpDir = K8sDir + "\\process-dumps"
+	// procdumpPath is where procdump is looked for on the instance when it is not on the PATH. procdump is not
+	// shipped with WMCO.
+	procdumpPath = K8sDir + "\\procdump.exe"
+	// werCrashDumpDir is the directory Windows Error Reporting writes the crash dumps of processes run as SYSTEM to,
+	// when local crash dumps are enabled on the instance
+	werCrashDumpDir = "C:\\Windows\\System32\\config\\systemprofile\\AppData\\Local\\CrashDumps"
+	// etwTraceSession is the name of the ETW trace session started by StartETWTrace
+	etwTraceSession = "wmco-etw"
+	// etwTraceDir is the directory ETW traces are written to on the instance until they are collected
+	etwTraceDir = K8sDir + "\\etw-traces"
+	// etwTracePath is the file the ETW trace session writes events to
+	etwTracePath = etwTraceDir + "\\" + etwTraceSession + ".etl"
+	// etwTraceMaxSizeMB is the size the trace file is kept under. The oldest events are overwritten once it is
+	// reached, so that a trace left running cannot fill the disk of the instance.
+	etwTraceMaxSizeMB = 256
+)
+
+// ProcessDumpServices maps the services whose process can be dumped to the name of their process. kubelet and
+// kube-proxy are run through kube-log-runner, so the process of the service itself is not the one of interest.
+var ProcessDumpServices = map[string]string{
+	KubeletServiceName:    "kubelet",
+	KubeProxyServiceName:  "kube-proxy",
+	ContainerdServiceName: "containerd",
+}
+
+// ETWTraceProviders maps the names of the ETW providers which can be traced to their GUIDs. Only providers of the
+// container networking stack are allowed, as the events of others may contain sensitive information.
+var ETWTraceProviders = map[string]string{
+	"Microsoft-Windows-Host-Network-Service": "{0C885E0D-6EB6-476C-A048-2457EED3A5C1}",
+	"Microsoft-Windows-Hyper-V-VfpExt":       "{9F2660EA-CFE7-428F-9850-AECA612619B0}",
+	"Microsoft-Windows-Hyper-V-VmSwitch":     "{1F387CBC-6818-4530-9DB6-5F1058CD7E86}",
+	"Microsoft-Windows-Hyper-V-Compute":      "{17103E3F-3C6E-4677-BB17-3B267EB5BE57}",
+	"Microsoft-Windows-TCPIP":                "{2F07E2EE-15DB-40F1-90EF-9D7BA282188A}",
+	"Microsoft-Windows-WinNat":               "{66C07ECD-6667-43FC-93F8-05CF07F446EC}",
+}
+
+// ErrETWTraceRunning is returned when an ETW trace cannot be started as one is already running
+var ErrETWTraceRunning = errors.New("an ETW trace is already running on the instance, it must be stopped first")
+
+// ErrNoETWTrace is returned when an ETW trace cannot be stopped as none is running
+var ErrNoETWTrace = errors.New("no ETW trace is running on the instance")
+
+// ErrNoProcessDump is returned when a process cannot be dumped and no crash dump of it exists
+var ErrNoProcessDump = errors.New("procdump is not installed or the process is not running, and " +
+	"Windows Error Reporting has no crash dump of the process")
+
+func (vm *windows) GatherDebugBundle() (map[string][]byte, error) {
+	bundle := make(map[string][]byte)
+	var errs []error
+	// each collector is run even if a previous one failed, so that as much information as possible is gathered
+	collectors := []func(map[string][]byte) error{
+		vm.collectServiceConfigs,
+		vm.collectHNSState,
+		vm.collectChecksums,
+		vm.collectLogTails,
+	}
+	for _, collect := range collectors {
+		if err := collect(bundle); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return bundle, fmt.Errorf("debug bundle is incomplete: %w", errors.Join(errs...))
+	}
+	return bundle, nil
+}
+
+// collectServiceConfigs adds the configuration and state of each WMCO-managed Windows service to the bundle
+func (vm *windows) collectServiceConfigs(bundle map[string][]byte) error {
+	var out strings.Builder
+	var errs []error
+	for _, svcName := range RequiredServices {
+		config, err := vm.Run(serviceQueryCmd+svcName, false)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error querying %s service config: %w", svcName, err))
+		}
+		state, err := vm.Run(serviceStateQueryCmd+svcName, false)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error querying %s service state: %w", svcName, err))
+		}
+		fmt.Fprintf(&out, "%s\n%s\n", config, state)
+	}
+	bundle[debugServicesFile] = []byte(out.String())
+	return errors.Join(errs...)
+}
+
+// collectHNSState adds the HNS networks and endpoints present on the instance to the bundle
+func (vm *windows) collectHNSState(bundle map[string][]byte) error {
+	var errs []error
+	networks, err := vm.Run("Get-HnsNetwork | ConvertTo-Json -Depth 10", true)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("error getting HNS networks: %w", err))
+	} else {
+		bundle[debugHNSNetworksFile] = []byte(networks)
+	}
+	endpoints, err := vm.Run("Get-HnsEndpoint | ConvertTo-Json -Depth 10", true)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("error getting HNS endpoints: %w", err))
+	} else {
+		bundle[debugHNSEndpointsFile] = []byte(endpoints)
+	}
+	return errors.Join(errs...)
+}
+
+// collectChecksums adds the expected and actual checksums of all files transferred by WMCO to the bundle
+func (vm *windows) collectChecksums(bundle map[string][]byte) error {
+	var lines []string
+	var errs []error
+	for file, dir := range vm.filesToTransfer {
+		remotePath := dir + "\\" + filepath.Base(file.Path)
+		actual := "missing"
+		exists, err := vm.FileExists(remotePath, "")
+		if err != nil {
+			errs = append(errs, err)
+			actual = "unknown"
+		} else if exists {
+			remoteFile, err := vm.newFileInfo(remotePath)
+			if err != nil {
+				errs = append(errs, err)
+				actual = "unknown"
+			} else {
+				actual = remoteFile.SHA256
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%s expected=%s actual=%s", remotePath, file.SHA256, actual))
+	}
+	// keep the output stable between bundles, so that they can be compared
+	sort.Strings(lines)
+	bundle[debugChecksumsFile] = []byte(strings.Join(lines, "\n"))
+	return errors.Join(errs...)
+}
+
+// collectLogTails adds the last lines of the most recently written log file of each service to the bundle
+func (vm *windows) collectLogTails(bundle map[string][]byte) error {
+	var errs []error
+	for _, dir := range vm.logPaths.serviceDirs() {
+		out, err := vm.Run(newestLogTailCmd(dir, debugLogTailLines), true)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error collecting logs from %s: %w", dir, err))
+			continue
+		}
+		bundle[debugLogsDir+"/"+filepath.Base(strings.ReplaceAll(dir, "\\", "/"))+".log"] = []byte(out)
+	}
+	return errors.Join(errs...)
+}
+
+func (vm *windows) CaptureProcessDump(serviceName string) ([]byte, error) {
+	processName, ok := ProcessDumpServices[serviceName]
+	if !ok {
+		return nil, fmt.Errorf("capturing a dump of the %s service is not supported", serviceName)
+	}
+	out, err := vm.Run(processDumpCmd(processName), true)
+	if err != nil {
+		return nil, fmt.Errorf("error capturing dump of %s with output %s: %w", processName, out, err)
+	}
+	dumpPath := strings.TrimSpace(out)
+	if dumpPath == "" {
+		return nil, ErrNoProcessDump
+	}
+	if strings.HasPrefix(dumpPath, processDumpDir) {
+		// dumps can hold credentials, so the ones taken by WMCO are not left on the instance once collected
+		defer func() {
+			if out, err := vm.Run("Remove-Item -Path '"+dumpPath+"' -Force", true); err != nil {
+				vm.log.Error(err, "unable to remove process dump", "path", dumpPath, "output", out)
+			}
+		}()
+	}
+	dump, err := vm.downloadFile(dumpPath)
+	if err != nil {
+		return nil, fmt.Errorf("error collecting dump of %s: %w", processName, err)
+	}
+	vm.log.Info("collected process dump", "service", serviceName, "path", dumpPath, "bytes", len(dump))
+	return dump, nil
+}
+
+// downloadFile returns the contents of the file at the given path on the instance
+func (vm *windows) downloadFile(path string) ([]byte, error) {
+	sftpClient, err := vm.interact.createSFTPClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SFTP client: %w", err)
+	}
+	defer func() {
+		if err := sftpClient.Close(); err != nil {
+			vm.log.Error(err, "error closing SFTP connection")
+		}
+	}()
+	return vm.interact.download(sftpClient, path)
+}
+
+func (vm *windows) StartETWTrace(providers []string) error {
+	guids, err := etwTraceProviderGUIDs(providers)
+	if err != nil {
+		return err
+	}
+	if err = vm.EnsureDirectory(etwTraceDir); err != nil {
+		return err
+	}
+	out, err := vm.Run(etwTraceStartCmd(guids[0]), false)
+	if err != nil {
+		if strings.Contains(out, "already exists") {
+			return ErrETWTraceRunning
+		}
+		return fmt.Errorf("error starting ETW trace with output %s: %w", out, err)
+	}
+	// a session is started with a single provider, the others are enabled in the running session
+	for _, guid := range guids[1:] {
+		if out, err = vm.Run(etwTraceEnableCmd(guid), false); err != nil {
+			if out, err := vm.Run(etwTraceStopCmd, false); err != nil {
+				vm.log.Error(err, "unable to stop partially started ETW trace", "output", out)
+			}
+			return fmt.Errorf("error enabling ETW provider %s with output %s: %w", guid, out, err)
+		}
+	}
+	vm.log.Info("started ETW trace", "providers", providers, "path", etwTracePath)
+	return nil
+}
+
+func (vm *windows) StopETWTrace() ([]byte, error) {
+	out, err := vm.Run(etwTraceStopCmd, false)
+	if err != nil {
+		if strings.Contains(out, "not found") {
+			return nil, ErrNoETWTrace
+		}
+		return nil, fmt.Errorf("error stopping ETW trace with output %s: %w", out, err)
+	}
+	// the trace is removed once collected, so that the next one does not start from a stale file
+	defer func() {
+		if out, err := vm.Run("Remove-Item -Path '"+etwTracePath+"' -Force", true); err != nil {
+			vm.log.Error(err, "unable to remove ETW trace", "path", etwTracePath, "output", out)
+		}
+	}()
+	trace, err := vm.downloadFile(etwTracePath)
+	if err != nil {
+		return nil, fmt.Errorf("error collecting ETW trace: %w", err)
+	}
+	vm.log.Info("collected ETW trace", "path", etwTracePath, "bytes", len(trace))
+	return trace, nil
+}
+
+// etwTraceProviderGUIDs returns the GUIDs of the given ETW providers, in the order given, returning an error if no
+// provider is given or a provider is not one of ETWTraceProviders
+func etwTraceProviderGUIDs(providers []string) ([]string, error) {
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("at least one ETW provider must be given")
+	}
+	var guids []string
+	for _, provider := range providers {
+		guid, ok := ETWTraceProviders[provider]
+		if !ok {
+			allowed := make([]string, 0, len(ETWTraceProviders))
+			for name := range ETWTraceProviders {
+				allowed = append(allowed, name)
+			}
+			sort.Strings(allowed)
+			return nil, fmt.Errorf("tracing ETW provider %q is not supported, must be one of %s", provider,
+				strings.Join(allowed, ", "))
+		}
+		if !slices.Contains(guids, guid) {
+			guids = append(guids, guid)
+		}
+	}
+	return guids, nil
+}
+
+// etwTraceStartCmd returns the command which starts the ETW trace session with the provider of the given GUID,
+// collecting all of its events
+func etwTraceStartCmd(guid string) string {
+	return fmt.Sprintf("logman start %s -ets -o %s -mode Circular -max %d -p %s 0xffffffffffffffff 0xff",
+		etwTraceSession, etwTracePath, etwTraceMaxSizeMB, guid)
+}
+
+// etwTraceEnableCmd returns the command which adds the provider of the given GUID to the running ETW trace session,
+// collecting all of its events
+func etwTraceEnableCmd(guid string) string {
+	return fmt.Sprintf("logman update trace %s -ets -p %s 0xffffffffffffffff 0xff", etwTraceSession, guid)
+}
+
+// etwTraceStopCmd is the command which stops the ETW trace session, flushing its events to the trace file
+const etwTraceStopCmd = "logman stop " + etwTraceSession + " -ets"
+
+// processDumpCmd returns the PowerShell command which dumps the process with the given name using procdump, if both
+// are present, or otherwise finds the newest Windows Error Reporting crash dump of the process. The command outputs
+// the path of the dump, and nothing if there is none.
+func processDumpCmd(processName string) string {
+	dumpPath := processDumpDir + "\\" + processName + ".dmp"
+	return fmt.Sprintf("Remove-Item -Path '%[1]s' -Force -ErrorAction SilentlyContinue; "+
+		"$p = Get-Process -Name '%[2]s' -ErrorAction SilentlyContinue | Select-Object -First 1; "+
+		"$tool = Get-Command -Name procdump.exe,'%[3]s' -ErrorAction SilentlyContinue | Select-Object -First 1; "+
+		"if ($p -and $tool) { New-Item -ItemType Directory -Force -Path '%[4]s' | Out-Null; "+
+		"& $tool.Source -accepteula -o -mp $p.Id '%[1]s' | Out-Null }; "+
+		"if (Test-Path -Path '%[1]s') { '%[1]s' } else { Get-ChildItem -Path '%[5]s' -Filter '%[2]s.exe.*.dmp' "+
+		"-File -ErrorAction SilentlyContinue | Sort-Object LastWriteTime -Descending | Select-Object -First 1 "+
+		"-ExpandProperty FullName }", dumpPath, processName, procdumpPath, processDumpDir, werCrashDumpDir)
+}
+
+func (vm *windows) GetWICDDiagnostics() (string, error) {
+	state, err := vm.Run(serviceStateQueryCmd+WicdServiceName, false)
+	if err != nil {
+		return "", fmt.Errorf("error querying %s service state: %w", WicdServiceName, err)
+	}
+	logs, err := vm.Run(newestLogTailCmd(vm.logPaths.WICDDir, wicdDiagnosticsLogLines), true)
+	if err != nil {
+		return state, fmt.Errorf("error collecting logs from %s: %w", vm.logPaths.WICDDir, err)
+	}
+	return state + "\n" + logs, nil
+}
+
+// newestLogTailCmd returns the PowerShell command to get the given number of lines from the end of the most recently
+// written file in the given directory
+func newestLogTailCmd(dir string, lines int) string {
+	return fmt.Sprintf("if(Test-Path %s) {Get-ChildItem %s -File | Sort-Object LastWriteTime -Descending | "+
+		"Select-Object -First 1 | Get-Content -Tail %d}", dir, dir, lines)
+}
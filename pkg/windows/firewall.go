@@ -0,0 +1,186 @@
+package windows
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// WindowsExporterPort is the port windows_exporter serves metrics on
+	WindowsExporterPort = 9182
+	// WindowsExporterFirewallRule is the name of the firewall rule allowing windows_exporter to be scraped
+	WindowsExporterFirewallRule = "OpenShift-windows-exporter"
+	// firewallRuleUnchanged is output by the firewall rule and profile commands when no change is needed
+	firewallRuleUnchanged = "UNCHANGED"
+	// kubeletPort is the port kubelet serves its API on
+	kubeletPort = 10250
+	// listeningPortsCmd outputs the local port, owning process ID and owning process name of each listening TCP
+	// socket, one socket per line. Processes are looked up once, as they may own many sockets.
+	listeningPortsCmd = "$p = @{}; Get-Process | ForEach-Object { $p[$_.Id] = $_.Name }; " +
+		"Get-NetTCPConnection -State Listen -ErrorAction SilentlyContinue | " +
+		"ForEach-Object { '{0} {1} {2}' -f $_.LocalPort, $_.OwningProcess, $p[[int]$_.OwningProcess] }"
+)
+
+// firewallProfiles are the names of the Windows firewall profiles
+var firewallProfiles = []string{"Domain", "Private", "Public"}
+
+// requiredPorts maps the ports the services WMCO runs on instances listen on, to the name of the process of the
+// service listening on it
+var requiredPorts = map[int]string{
+	kubeletPort:         "kubelet",
+	WindowsExporterPort: "windows_exporter",
+}
+
+func (vm *windows) EnsureFirewallRule(name string, port int) error {
+	if port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid port %d for firewall rule %s", port, name)
+	}
+	out, err := vm.Run(firewallRuleCmd(name, port), true)
+	if err != nil {
+		return fmt.Errorf("error ensuring firewall rule %s with output %s: %w", name, out, err)
+	}
+	if strings.TrimSpace(out) != firewallRuleUnchanged {
+		vm.log.Info("created", "firewall rule", name, "port", port)
+	}
+	return nil
+}
+
+func (vm *windows) SetFirewallProfileState(profile string, enabled bool) error {
+	if !slices.Contains(firewallProfiles, profile) {
+		return fmt.Errorf("invalid firewall profile %q, must be one of %s", profile, strings.Join(firewallProfiles, ", "))
+	}
+	out, err := vm.Run(firewallProfileCmd(profile, enabled), true)
+	if err != nil {
+		if isPermissionError(out) {
+			return fmt.Errorf("user %s lacks the privileges required to set the state of firewall profile %s: %w",
+				vm.instance.Username, profile, err)
+		}
+		return fmt.Errorf("error setting state of firewall profile %s with output %s: %w", profile, out, err)
+	}
+	if strings.TrimSpace(out) != firewallRuleUnchanged {
+		vm.log.Info("set state of", "firewall profile", profile, "enabled", enabled)
+	}
+	return nil
+}
+
+func (vm *windows) IsPortListening(port int) (bool, error) {
+	out, err := vm.Run("[bool](Get-NetTCPConnection -State Listen -LocalPort "+strconv.Itoa(port)+
+		" -ErrorAction SilentlyContinue)", true)
+	if err != nil {
+		return false, fmt.Errorf("error checking if port %d is listening with output %s: %w", port, out, err)
+	}
+	listening, err := strconv.ParseBool(strings.TrimSpace(out))
+	if err != nil {
+		return false, fmt.Errorf("unable to parse listening state of port %d %q: %w", port, out, err)
+	}
+	return listening, nil
+}
+
+func (vm *windows) GetListeningPorts() (map[int]string, error) {
+	out, err := vm.Run(listeningPortsCmd, true)
+	if err != nil {
+		return nil, fmt.Errorf("error getting listening ports with output %s: %w", out, err)
+	}
+	return parseListeningPorts(out)
+}
+
+// checkRequiredPorts returns an error if a port one of WMCO's services must listen on is already in use by another
+// process, as the service would otherwise fail to start without a clear cause
+func (vm *windows) checkRequiredPorts() error {
+	listening, err := vm.GetListeningPorts()
+	if err != nil {
+		return err
+	}
+	if conflicts := portConflicts(listening); len(conflicts) > 0 {
+		return fmt.Errorf("ports required by WMCO services are in use, the processes using them must be stopped: %s",
+			strings.Join(conflicts, ", "))
+	}
+	return nil
+}
+
+// parseListeningPorts parses the output of listeningPortsCmd into a map of each listening port to the name of the
+// process owning it, or to the ID of the process if its name is unknown
+func parseListeningPorts(out string) (map[int]string, error) {
+	ports := make(map[int]string)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("unable to parse listening port %q", line)
+		}
+		port, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse listening port %q: %w", line, err)
+		}
+		// The same port is listed once for each address it is bound to
+		if _, present := ports[port]; present {
+			continue
+		}
+		if len(fields) > 2 {
+			ports[port] = strings.Join(fields[2:], " ")
+		} else {
+			ports[port] = "PID " + fields[1]
+		}
+	}
+	return ports, nil
+}
+
+// portConflicts returns a description of each port in requiredPorts which is listened on by a process other than the
+// service expected to use it, sorted by port
+func portConflicts(listening map[int]string) []string {
+	var ports []int
+	for port, process := range listening {
+		if expected, required := requiredPorts[port]; required && !strings.EqualFold(process, expected) {
+			ports = append(ports, port)
+		}
+	}
+	sort.Ints(ports)
+	conflicts := make([]string, 0, len(ports))
+	for _, port := range ports {
+		conflicts = append(conflicts, fmt.Sprintf("port %d used by %s", port, listening[port]))
+	}
+	return conflicts
+}
+
+// removeFirewallRules removes all firewall rules created by WMCO
+func (vm *windows) removeFirewallRules() error {
+	out, err := vm.Run("Get-NetFirewallRule -Description '"+ManagedTag+"' -ErrorAction SilentlyContinue | "+
+		"Remove-NetFirewallRule", true)
+	if err != nil {
+		return fmt.Errorf("error removing firewall rules with output %s: %w", out, err)
+	}
+	return nil
+}
+
+// firewallProfileCmd returns the PowerShell command which enables or disables the firewall profile with the given
+// name, if it is not already in that state. Profiles whose state is not configured are treated as not matching.
+func firewallProfileCmd(profile string, enabled bool) string {
+	state := "False"
+	if enabled {
+		state = "True"
+	}
+	return "$p = Get-NetFirewallProfile -Name " + profile + " -ErrorAction Stop; " +
+		"if ([string]$p.Enabled -eq '" + state + "') { '" + firewallRuleUnchanged + "' } else { " +
+		"Set-NetFirewallProfile -Name " + profile + " -Enabled " + state + " -ErrorAction Stop }"
+}
+
+// firewallRuleCmd returns the PowerShell command which ensures an enabled inbound firewall rule with the given name
+// allows TCP traffic to the given local port. The rule is created again if it differs, and is identified as
+// WMCO-managed through its description.
+func firewallRuleCmd(name string, port int) string {
+	localPort := strconv.Itoa(port)
+	return "$r = Get-NetFirewallRule -Name '" + name + "' -ErrorAction SilentlyContinue; " +
+		"if ($r -and $r.Enabled -eq 'True' -and $r.Action -eq 'Allow' -and $r.Direction -eq 'Inbound' -and " +
+		"$r.Description -eq '" + ManagedTag + "' -and " +
+		"(($r | Get-NetFirewallPortFilter).LocalPort -join ',') -eq '" + localPort + "') { '" +
+		firewallRuleUnchanged + "' } else { " +
+		"if ($r) { Remove-NetFirewallRule -Name '" + name + "' }; " +
+		"New-NetFirewallRule -Name '" + name + "' -DisplayName '" + name + "' -Description '" + ManagedTag +
+		"' -Direction Inbound -Action Allow -Protocol TCP -LocalPort " + localPort +
+		" -EdgeTraversalPolicy Allow | Out-Null }"
+}
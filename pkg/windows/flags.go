@@ -0,0 +1,115 @@
+package windows
+
+import (
+	"fmt"
+	"strings"
+)
+
+// binaryPathNameField is the field of `sc.exe qc` output holding the command a service runs
+const binaryPathNameField = "BINARY_PATH_NAME"
+
+func (vm *windows) GetEffectiveKubeletFlags() (map[string]string, error) {
+	binPath, err := vm.getServiceBinaryPath(KubeletServiceName)
+	if err != nil {
+		return nil, err
+	}
+	return ParseServiceFlags(binPath), nil
+}
+
+// getServiceBinaryPath returns the command run by the service with the given name
+func (vm *windows) getServiceBinaryPath(serviceName string) (string, error) {
+	out, err := vm.Run(serviceQueryCmd+serviceName, false)
+	if err != nil {
+		return "", fmt.Errorf("error querying %s service config: %w", serviceName, err)
+	}
+	binPath, err := parseBinaryPathName(out)
+	if err != nil {
+		return "", fmt.Errorf("error parsing %s service config: %w", serviceName, err)
+	}
+	return binPath, nil
+}
+
+// parseBinaryPathName returns the value of the BINARY_PATH_NAME field from the given `sc.exe qc` output
+func parseBinaryPathName(scOutput string) (string, error) {
+	for _, line := range strings.Split(scOutput, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, binaryPathNameField) {
+			continue
+		}
+		// the value is separated by the first ": ", paths such as C:\ do not contain a space after the colon
+		parts := strings.SplitN(line, ": ", 2)
+		if len(parts) != 2 {
+			return "", fmt.Errorf("malformed %s line %q", binaryPathNameField, line)
+		}
+		return strings.TrimSpace(parts[1]), nil
+	}
+	return "", fmt.Errorf("%s not found", binaryPathNameField)
+}
+
+// ParseServiceFlags returns the flags given to the binary invoked by the given service command, keyed by flag name
+// without leading dashes. Only the flags following the last positional argument are returned, so that for commands
+// wrapped by a runner such as kube-log-runner the flags of the wrapped binary are returned. Flags must be given in the
+// --flag=value form, flags without a value are boolean flags and are given the value "true".
+func ParseServiceFlags(cmd string) map[string]string {
+	flags := make(map[string]string)
+	for _, arg := range splitCommand(cmd) {
+		name, value, isFlag := parseFlag(arg)
+		if !isFlag {
+			// a positional argument, any preceding flags belong to a previous binary
+			flags = make(map[string]string)
+			continue
+		}
+		flags[name] = value
+	}
+	return flags
+}
+
+// parseFlag returns the name and value of the given flag argument, and false if the argument is not a flag
+func parseFlag(arg string) (string, string, bool) {
+	if !strings.HasPrefix(arg, "-") {
+		return "", "", false
+	}
+	name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+	if name == "" {
+		return "", "", false
+	}
+	if !hasValue {
+		value = "true"
+	}
+	return name, value, true
+}
+
+// splitCommand splits the given Windows command line into its arguments. Arguments are separated by whitespace, unless
+// the whitespace is within double quotes. Quotes are removed from the returned arguments, and \" is treated as a
+// literal quote.
+func splitCommand(cmd string) []string {
+	var args []string
+	var current strings.Builder
+	inQuotes := false
+	inArg := false
+	for i := 0; i < len(cmd); i++ {
+		c := cmd[i]
+		switch {
+		case c == '\\' && i+1 < len(cmd) && cmd[i+1] == '"':
+			current.WriteByte('"')
+			inArg = true
+			i++
+		case c == '"':
+			inQuotes = !inQuotes
+			inArg = true
+		case (c == ' ' || c == '\t') && !inQuotes:
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args
+}
@@ -0,0 +1,232 @@
+package windows
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+)
+
+// HNSConfig is the configuration of the WMCO-managed HNS networks of an instance, as exported by ExportHNSConfig
+type HNSConfig struct {
+	// Networks are the WMCO-managed HNS networks, in the order they must be created in
+	Networks []HNSNetwork `json:"networks"`
+}
+
+// HNSNetwork is an HNS network, using the field names of the HNS API so that it can be given as is to create the
+// network
+type HNSNetwork struct {
+	Name               string            `json:"Name"`
+	Type               string            `json:"Type"`
+	NetworkAdapterName string            `json:"NetworkAdapterName,omitempty"`
+	Subnets            []HNSSubnet       `json:"Subnets,omitempty"`
+	Policies           []json.RawMessage `json:"Policies,omitempty"`
+}
+
+// HNSSubnet is a subnet of an HNS network
+type HNSSubnet struct {
+	AddressPrefix  string            `json:"AddressPrefix"`
+	GatewayAddress string            `json:"GatewayAddress,omitempty"`
+	Policies       []json.RawMessage `json:"Policies,omitempty"`
+}
+
+// hnsHostState is the part of the networking state of an instance an HNSConfig is validated against
+type hnsHostState struct {
+	Networks []HNSNetwork `json:"networks"`
+	Adapters []string     `json:"adapters"`
+}
+
+// managedHNSNetworks are the HNS networks created by the hybrid-overlay configuration process, in the order they are
+// created in. The overlay network is created on top of the base network.
+var managedHNSNetworks = []string{BaseOVNKubeOverlayNetwork, OVNKubeOverlayNetwork}
+
+func (vm *windows) ExportHNSConfig() ([]byte, error) {
+	state, err := vm.getHNSHostState()
+	if err != nil {
+		return nil, err
+	}
+	config := managedHNSConfig(state.Networks)
+	if len(config.Networks) == 0 {
+		return nil, fmt.Errorf("instance has none of the WMCO-managed HNS networks %s",
+			strings.Join(managedHNSNetworks, ", "))
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal HNS config: %w", err)
+	}
+	return data, nil
+}
+
+func (vm *windows) ImportHNSConfig(data []byte) error {
+	config, err := parseHNSConfig(data)
+	if err != nil {
+		return err
+	}
+	state, err := vm.getHNSHostState()
+	if err != nil {
+		return err
+	}
+	missing, err := validateHNSConfig(config, state)
+	if err != nil {
+		return fmt.Errorf("HNS config is not compatible with the instance: %w", err)
+	}
+	for _, network := range missing {
+		cmd, err := createHNSNetworkCmd(network)
+		if err != nil {
+			return err
+		}
+		if out, err := vm.Run(cmd, true); err != nil {
+			return fmt.Errorf("error creating %s HNS network with output %s: %w", network.Name, out, err)
+		}
+		vm.log.Info("created HNS network", "network", network.Name)
+	}
+	return nil
+}
+
+// getHNSHostState returns the HNS networks and network adapters of the instance
+func (vm *windows) getHNSHostState() (*hnsHostState, error) {
+	out, err := vm.Run(hnsHostStateCmd(), true)
+	if err != nil {
+		return nil, fmt.Errorf("error getting HNS networks with output %s: %w", out, err)
+	}
+	state := &hnsHostState{}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out)), state); err != nil {
+		return nil, fmt.Errorf("unable to parse HNS networks %q: %w", out, err)
+	}
+	return state, nil
+}
+
+// hnsHostStateCmd returns the PowerShell command which outputs the HNS networks and the names of the network adapters
+// of the instance as JSON
+func hnsHostStateCmd() string {
+	return "@{networks = @(Get-HnsNetwork | Select-Object Name, Type, NetworkAdapterName, Subnets, Policies); " +
+		"adapters = @(Get-NetAdapter | ForEach-Object { $_.Name })} | ConvertTo-Json -Depth 10 -Compress"
+}
+
+// createHNSNetworkCmd returns the PowerShell command which creates the given HNS network. The network is passed
+// encoded, as its JSON cannot be quoted within the command.
+func createHNSNetworkCmd(network HNSNetwork) (string, error) {
+	data, err := json.Marshal(network)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal %s HNS network: %w", network.Name, err)
+	}
+	return "$ErrorActionPreference = 'Stop'; Import-Module -DisableNameChecking " + HNSPSModule + "; " +
+		"$j = [Text.Encoding]::UTF8.GetString([Convert]::FromBase64String('" +
+		base64.StdEncoding.EncodeToString(data) + "')); " +
+		"$n = Invoke-HNSRequest -Method POST -Type networks -Data $j; " +
+		"if (-not $n) { throw 'HNS network " + network.Name + " was not created' }", nil
+}
+
+// managedHNSConfig returns the config of the WMCO-managed networks among the given HNS networks
+func managedHNSConfig(networks []HNSNetwork) *HNSConfig {
+	config := &HNSConfig{}
+	for _, name := range managedHNSNetworks {
+		for _, network := range networks {
+			if network.Name == name {
+				config.Networks = append(config.Networks, network)
+				break
+			}
+		}
+	}
+	return config
+}
+
+// parseHNSConfig parses the given HNS config, returning an error if it gives a network which is not managed by WMCO,
+// gives a network more than once, or gives a network without a type or with an invalid subnet. The networks are
+// ordered as they must be created.
+func parseHNSConfig(data []byte) (*HNSConfig, error) {
+	config := &HNSConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("unable to parse HNS config: %w", err)
+	}
+	if len(config.Networks) == 0 {
+		return nil, fmt.Errorf("HNS config gives no networks")
+	}
+	seen := make(map[string]struct{})
+	for _, network := range config.Networks {
+		if !slices.Contains(managedHNSNetworks, network.Name) {
+			return nil, fmt.Errorf("HNS network %q is not managed by WMCO, must be one of %s", network.Name,
+				strings.Join(managedHNSNetworks, ", "))
+		}
+		if _, present := seen[network.Name]; present {
+			return nil, fmt.Errorf("HNS network %s given more than once", network.Name)
+		}
+		seen[network.Name] = struct{}{}
+		if network.Type == "" {
+			return nil, fmt.Errorf("HNS network %s has no type", network.Name)
+		}
+		for _, subnet := range network.Subnets {
+			if _, _, err := net.ParseCIDR(subnet.AddressPrefix); err != nil {
+				return nil, fmt.Errorf("HNS network %s has invalid subnet %q: %w", network.Name,
+					subnet.AddressPrefix, err)
+			}
+		}
+	}
+	return managedHNSConfig(config.Networks), nil
+}
+
+// validateHNSConfig returns the networks of the given config which must be created on an instance with the given
+// networking state. An error is returned if a network of the config already exists with a different type or
+// subnets, if the network adapter of a network is not present on the instance, or if a subnet of a network overlaps
+// a subnet of another network of the instance.
+func validateHNSConfig(config *HNSConfig, state *hnsHostState) ([]HNSNetwork, error) {
+	var missing []HNSNetwork
+	for _, network := range config.Networks {
+		if network.NetworkAdapterName != "" && !slices.Contains(state.Adapters, network.NetworkAdapterName) {
+			return nil, fmt.Errorf("network adapter %q of HNS network %s not found", network.NetworkAdapterName,
+				network.Name)
+		}
+		exists := false
+		for _, current := range state.Networks {
+			if current.Name == network.Name {
+				if !strings.EqualFold(current.Type, network.Type) ||
+					!slices.Equal(subnetPrefixes(current), subnetPrefixes(network)) {
+					return nil, fmt.Errorf("HNS network %s already exists with a different configuration",
+						network.Name)
+				}
+				exists = true
+				continue
+			}
+			if overlap := overlappingSubnet(network, current); overlap != "" {
+				return nil, fmt.Errorf("subnet %s of HNS network %s overlaps HNS network %s", overlap,
+					network.Name, current.Name)
+			}
+		}
+		if !exists {
+			missing = append(missing, network)
+		}
+	}
+	return missing, nil
+}
+
+// subnetPrefixes returns the address prefixes of the subnets of the given network
+func subnetPrefixes(network HNSNetwork) []string {
+	var prefixes []string
+	for _, subnet := range network.Subnets {
+		prefixes = append(prefixes, subnet.AddressPrefix)
+	}
+	return prefixes
+}
+
+// overlappingSubnet returns the first address prefix of the given network which overlaps a subnet of the other
+// network, or an empty string if there is none
+func overlappingSubnet(network, other HNSNetwork) string {
+	for _, subnet := range network.Subnets {
+		_, a, err := net.ParseCIDR(subnet.AddressPrefix)
+		if err != nil {
+			continue
+		}
+		for _, otherSubnet := range other.Subnets {
+			_, b, err := net.ParseCIDR(otherSubnet.AddressPrefix)
+			if err != nil {
+				continue
+			}
+			if a.Contains(b.IP) || b.Contains(a.IP) {
+				return subnet.AddressPrefix
+			}
+		}
+	}
+	return ""
+}
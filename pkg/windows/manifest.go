@@ -0,0 +1,155 @@
+package windows
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
+)
+
+// StateManifest is the state of the WMCO-managed files, services and environment variables of an instance, as
+// returned by ExportStateManifest. Entries are sorted so that manifests of the same state are identical.
+type StateManifest struct {
+	// Digest is the SHA256 checksum of the JSON encoding of the manifest with an empty digest
+	Digest string `json:"digest"`
+	// Files are the files the services ConfigMap gives, sorted by path
+	Files []ManifestFile `json:"files"`
+	// Services are the services the services ConfigMap gives, sorted by name
+	Services []ManifestService `json:"services"`
+	// EnvironmentVars are the environment variables the services ConfigMap gives, sorted by name
+	EnvironmentVars []ManifestEnvironmentVar `json:"environmentVars"`
+}
+
+// ManifestFile is the state of a WMCO-managed file
+type ManifestFile struct {
+	Path string `json:"path"`
+	// ExpectedChecksum is the SHA256 checksum given by the services ConfigMap
+	ExpectedChecksum string `json:"expectedChecksum"`
+	// Checksum is the SHA256 checksum of the file on the instance, empty if the file is missing
+	Checksum string `json:"checksum"`
+	Drifted  bool   `json:"drifted"`
+}
+
+// ManifestService is the state of a WMCO-managed service
+type ManifestService struct {
+	Name string `json:"name"`
+	// ExpectedCommand is the command given by the services ConfigMap, before node specific values are substituted
+	ExpectedCommand string `json:"expectedCommand"`
+	// Command is the command the service runs on the instance, empty if the service is missing
+	Command      string   `json:"command"`
+	Dependencies []string `json:"dependencies,omitempty"`
+	Missing      bool     `json:"missing"`
+}
+
+// ManifestEnvironmentVar is the state of a WMCO-managed system environment variable
+type ManifestEnvironmentVar struct {
+	Name          string `json:"name"`
+	ExpectedValue string `json:"expectedValue"`
+	// Value is the value of the variable on the instance, nil if the variable is not set
+	Value   *string `json:"value"`
+	Drifted bool    `json:"drifted"`
+}
+
+func (vm *windows) ExportStateManifest(data *servicescm.Data) ([]byte, error) {
+	checksums := make(map[string]string, len(data.Files))
+	for _, file := range data.Files {
+		checksum, err := vm.fileChecksum(file.Path)
+		if err != nil {
+			return nil, err
+		}
+		checksums[file.Path] = checksum
+	}
+	commands := make(map[string]string, len(data.Services))
+	for _, svc := range data.Services {
+		out, err := vm.Run(serviceQueryCmd+svc.Name, false)
+		if err != nil {
+			if strings.Contains(out, serviceNotFound) {
+				continue
+			}
+			return nil, fmt.Errorf("error querying %s service config with output %s: %w", svc.Name, out, err)
+		}
+		if commands[svc.Name], err = parseBinaryPathName(out); err != nil {
+			return nil, fmt.Errorf("error parsing %s service config: %w", svc.Name, err)
+		}
+	}
+	envVars := make(map[string]*string)
+	if len(data.EnvironmentVars) > 0 {
+		out, err := vm.Run(machineEnvVarsCmd(data.EnvironmentVars), true)
+		if err != nil {
+			return nil, fmt.Errorf("error getting environment variables with output %s: %w", out, err)
+		}
+		if err = json.Unmarshal([]byte(strings.TrimSpace(out)), &envVars); err != nil {
+			return nil, fmt.Errorf("unable to parse environment variables %q: %w", out, err)
+		}
+	}
+	return json.MarshalIndent(newStateManifest(data, checksums, commands, envVars), "", "  ")
+}
+
+// machineEnvVarsCmd returns the PowerShell command which outputs the system values of the given environment variables
+// as a JSON object keyed by name, with null values for variables which are not set
+func machineEnvVarsCmd(vars map[string]string) string {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	entries := make([]string, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, fmt.Sprintf("'%s' = [Environment]::GetEnvironmentVariable('%s', 'Machine')", name,
+			name))
+	}
+	return "@{" + strings.Join(entries, "; ") + "} | ConvertTo-Json -Compress"
+}
+
+// newStateManifest returns the manifest of the state given by the services ConfigMap data and the checksums, service
+// commands and environment variables found on the instance. Files and services missing from the instance have no
+// checksum or command.
+func newStateManifest(data *servicescm.Data, checksums, commands map[string]string,
+	envVars map[string]*string) *StateManifest {
+	manifest := &StateManifest{
+		Files:           make([]ManifestFile, 0, len(data.Files)),
+		Services:        make([]ManifestService, 0, len(data.Services)),
+		EnvironmentVars: make([]ManifestEnvironmentVar, 0, len(data.EnvironmentVars)),
+	}
+	for _, file := range data.Files {
+		expected := strings.ToLower(file.Checksum)
+		checksum := checksums[file.Path]
+		manifest.Files = append(manifest.Files, ManifestFile{Path: file.Path, ExpectedChecksum: expected,
+			Checksum: checksum, Drifted: checksum != expected})
+	}
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return strings.ToLower(manifest.Files[i].Path) < strings.ToLower(manifest.Files[j].Path)
+	})
+	for _, svc := range data.Services {
+		command, found := commands[svc.Name]
+		dependencies := append([]string(nil), svc.Dependencies...)
+		sort.Strings(dependencies)
+		manifest.Services = append(manifest.Services, ManifestService{Name: svc.Name, ExpectedCommand: svc.Command,
+			Command: command, Dependencies: dependencies, Missing: !found})
+	}
+	sort.Slice(manifest.Services, func(i, j int) bool { return manifest.Services[i].Name < manifest.Services[j].Name })
+	for name, expected := range data.EnvironmentVars {
+		value := envVars[name]
+		manifest.EnvironmentVars = append(manifest.EnvironmentVars, ManifestEnvironmentVar{Name: name,
+			ExpectedValue: expected, Value: value, Drifted: value == nil || *value != expected})
+	}
+	sort.Slice(manifest.EnvironmentVars, func(i, j int) bool {
+		return manifest.EnvironmentVars[i].Name < manifest.EnvironmentVars[j].Name
+	})
+	manifest.Digest = manifestDigest(manifest)
+	return manifest
+}
+
+// manifestDigest returns the SHA256 checksum of the JSON encoding of the given manifest with an empty digest
+func manifestDigest(manifest *StateManifest) string {
+	unsigned := *manifest
+	unsigned.Digest = ""
+	// a manifest only holds strings, slices and bools, so it always encodes
+	encoded, _ := json.Marshal(unsigned)
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
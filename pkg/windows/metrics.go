@@ -0,0 +1,60 @@
+package windows
+
+import (
+	"strings"
+)
+
+const (
+	// ServiceTerminationsMetric is the metric served by windows_exporter giving the number of unexpected terminations
+	// of each WMCO-managed service of the instance, as recorded by the events retained in its System event log
+	ServiceTerminationsMetric = "wmco_node_service_terminations"
+	// HNSSubnetSizeMetric is the metric served by windows_exporter giving the number of addresses of the subnet of the
+	// hybrid overlay HNS network of the instance which can be assigned to endpoints, labelled with the subnet
+	HNSSubnetSizeMetric = "wmco_node_hns_subnet_size"
+	// HNSSubnetAddressesUsedMetric is the metric served by windows_exporter giving the number of addresses of the
+	// subnet of the hybrid overlay HNS network of the instance which are assigned to endpoints, labelled with the subnet
+	HNSSubnetAddressesUsedMetric = "wmco_node_hns_subnet_addresses_used"
+	// nodeMetricsTask is the name of the scheduled task writing the WMCO metrics of the instance
+	nodeMetricsTask = "node-metrics"
+	// nodeMetricsTrigger is how often the WMCO metrics of the instance are written
+	nodeMetricsTrigger = scheduledTaskEveryPrefix + "5m"
+	// nodeMetricsFile is the file the WMCO metrics of the instance are written to, which is served by the
+	// windows_exporter textfile collector
+	nodeMetricsFile = WindowsExporterTextfileDir + "\\wmco.prom"
+)
+
+// ensureNodeMetricsTask ensures the scheduled task writing the WMCO metrics of the instance is registered, so that the
+// metrics are served by windows_exporter rather than read from the instance by the operator
+func (vm *windows) ensureNodeMetricsTask() error {
+	return vm.EnsureScheduledTask(nodeMetricsTask, nodeMetricsCmd(), nodeMetricsTrigger)
+}
+
+// nodeMetricsCmd returns the PowerShell command which writes the WMCO metrics of the instance, in the Prometheus text
+// format, to the file served by the windows_exporter textfile collector. The metrics are written to a temporary file
+// which then replaces the served one, so that windows_exporter never serves a partially written file.
+func nodeMetricsCmd() string {
+	services := make([]string, 0, len(RequiredServices))
+	for _, service := range RequiredServices {
+		services = append(services, "'"+service+"'")
+	}
+	// the events identify the service by its display name. The network, network ID and broadcast addresses of the HNS
+	// subnet cannot be assigned to endpoints, and the HNS network metrics are left out until the network exists.
+	return "$m = @('# TYPE " + ServiceTerminationsMetric + " gauge'); " +
+		"$e = @(Get-WinEvent -FilterHashtable @{LogName='System'; ProviderName='Service Control Manager'; " +
+		"Id=" + serviceTerminatedEventIDs + "} -ErrorAction SilentlyContinue); " +
+		"foreach ($s in @(" + strings.Join(services, ",") + ")) { " +
+		"$svc = Get-Service -Name $s -ErrorAction SilentlyContinue; if (-not $svc) { continue }; " +
+		"$c = @($e | Where-Object { $_.Properties[0].Value -eq $svc.DisplayName }).Count; " +
+		"$m += '" + ServiceTerminationsMetric + "{service=\"' + $s + '\"} ' + $c }; " +
+		"$n = @(" + getHNSNetworkCmd(OVNKubeOverlayNetwork) + ")[0]; " +
+		"if ($n) { $p = @($n.Subnets)[0].AddressPrefix; " +
+		"$size = [math]::Max([math]::Pow(2, 32 - [int]$p.Split('/')[1]) - 3, 0); " +
+		"$used = @(Get-HnsEndpoint | Where-Object { $_.VirtualNetwork -eq $n.Id -and $_.IPAddress } | " +
+		"ForEach-Object { $_.IPAddress } | Sort-Object -Unique).Count; " +
+		"$m += '# TYPE " + HNSSubnetSizeMetric + " gauge'; " +
+		"$m += '" + HNSSubnetSizeMetric + "{subnet=\"' + $p + '\"} ' + $size; " +
+		"$m += '# TYPE " + HNSSubnetAddressesUsedMetric + " gauge'; " +
+		"$m += '" + HNSSubnetAddressesUsedMetric + "{subnet=\"' + $p + '\"} ' + $used }; " +
+		"Set-Content -Path '" + nodeMetricsFile + ".tmp' -Value $m -Encoding ascii; " +
+		"Move-Item -Path '" + nodeMetricsFile + ".tmp' -Destination '" + nodeMetricsFile + "' -Force"
+}
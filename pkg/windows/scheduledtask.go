@@ -0,0 +1,133 @@
+package windows
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	// scheduledTaskPath is the folder of the Task Scheduler library that WMCO-managed scheduled tasks are created in
+	scheduledTaskPath = "\\OpenShift\\"
+	// scheduledTaskAtStartup is the trigger of a scheduled task run each time the instance starts
+	scheduledTaskAtStartup = "atstartup"
+	// scheduledTaskDailyPrefix prefixes the time of day, in the 24-hour format HH:MM, of a scheduled task run daily
+	scheduledTaskDailyPrefix = "daily@"
+	// scheduledTaskEveryPrefix prefixes the interval, as a duration such as 30m, of a scheduled task run repeatedly
+	scheduledTaskEveryPrefix = "every:"
+	// scheduledTaskUnchanged is output by the scheduled task registration command when the task is up to date
+	scheduledTaskUnchanged = "UNCHANGED"
+)
+
+// scheduledTaskNameRegex matches the names which can be given to WMCO-managed scheduled tasks
+var scheduledTaskNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_\-]+$`)
+
+// scheduledTaskTimeRegex matches a time of day in the 24-hour format HH:MM
+var scheduledTaskTimeRegex = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]$`)
+
+func (vm *windows) EnsureScheduledTask(name, command, trigger string) error {
+	cmd, err := registerScheduledTaskCmd(name, command, trigger)
+	if err != nil {
+		return fmt.Errorf("invalid scheduled task %s: %w", name, err)
+	}
+	out, err := vm.Run(cmd, true)
+	if err != nil {
+		return fmt.Errorf("error registering scheduled task %s with output %s: %w", name, out, err)
+	}
+	if strings.TrimSpace(out) != scheduledTaskUnchanged {
+		vm.log.Info("registered", "scheduled task", name, "trigger", trigger)
+	}
+	return nil
+}
+
+func (vm *windows) RemoveScheduledTask(name string) error {
+	if !scheduledTaskNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid scheduled task name %q", name)
+	}
+	out, err := vm.Run("$t = Get-ScheduledTask -TaskPath '"+scheduledTaskPath+"' -TaskName '"+name+
+		"' -ErrorAction SilentlyContinue; if ($t) { Unregister-ScheduledTask -InputObject $t -Confirm:$false }", true)
+	if err != nil {
+		return fmt.Errorf("error removing scheduled task %s with output %s: %w", name, out, err)
+	}
+	return nil
+}
+
+// removeScheduledTasks removes all scheduled tasks created by WMCO
+func (vm *windows) removeScheduledTasks() error {
+	out, err := vm.Run("Get-ScheduledTask -TaskPath '"+scheduledTaskPath+"' -ErrorAction SilentlyContinue | "+
+		"Where-Object { $_.Description -like '"+ManagedTag+"*' } | Unregister-ScheduledTask -Confirm:$false", true)
+	if err != nil {
+		return fmt.Errorf("error removing scheduled tasks with output %s: %w", out, err)
+	}
+	return nil
+}
+
+// registerScheduledTaskCmd returns the PowerShell command which registers a scheduled task with the given name,
+// running the given PowerShell command as SYSTEM on the given trigger. The task is identified as WMCO-managed, and
+// carries a hash of its definition in its description, so that it is only registered again if its definition changed.
+func registerScheduledTaskCmd(name, command, trigger string) (string, error) {
+	if !scheduledTaskNameRegex.MatchString(name) {
+		return "", fmt.Errorf("name must only contain alphanumeric characters, dashes and underscores")
+	}
+	if command == "" {
+		return "", fmt.Errorf("command must be given")
+	}
+	triggerCmd, err := scheduledTaskTriggerCmd(trigger)
+	if err != nil {
+		return "", err
+	}
+	description := fmt.Sprintf("%s %s %x", ManagedTag, name,
+		sha256.Sum256([]byte(name+"\x00"+command+"\x00"+trigger)))
+	return "$t = Get-ScheduledTask -TaskPath '" + scheduledTaskPath + "' -TaskName '" + name +
+		"' -ErrorAction SilentlyContinue; " +
+		"if ($t -and $t.Description -eq '" + description + "') { '" + scheduledTaskUnchanged + "' } else { " +
+		"$a = New-ScheduledTaskAction -Execute 'powershell.exe' -Argument '-NoProfile -NonInteractive " +
+		"-EncodedCommand " + encodePowerShellCommand(command) + "'; " +
+		"$tr = " + triggerCmd + "; " +
+		"$p = New-ScheduledTaskPrincipal -UserId 'SYSTEM' -LogonType ServiceAccount -RunLevel Highest; " +
+		"Register-ScheduledTask -TaskPath '" + scheduledTaskPath + "' -TaskName '" + name + "' -Description '" +
+		description + "' -Action $a -Trigger $tr -Principal $p -Force | Out-Null }", nil
+}
+
+// scheduledTaskTriggerCmd returns the PowerShell expression creating the scheduled task trigger described by the
+// given trigger, which is one of:
+//   - atstartup, running the task each time the instance starts
+//   - daily@HH:MM, running the task daily at the given time of day, in the 24-hour format
+//   - every:<duration>, running the task repeatedly at the given interval, which is a whole number of minutes
+func scheduledTaskTriggerCmd(trigger string) (string, error) {
+	switch {
+	case trigger == scheduledTaskAtStartup:
+		return "New-ScheduledTaskTrigger -AtStartup", nil
+	case strings.HasPrefix(trigger, scheduledTaskDailyPrefix):
+		at := strings.TrimPrefix(trigger, scheduledTaskDailyPrefix)
+		if !scheduledTaskTimeRegex.MatchString(at) {
+			return "", fmt.Errorf("invalid time of day %q, must be in the format HH:MM", at)
+		}
+		return "New-ScheduledTaskTrigger -Daily -At '" + at + "'", nil
+	case strings.HasPrefix(trigger, scheduledTaskEveryPrefix):
+		interval, err := time.ParseDuration(strings.TrimPrefix(trigger, scheduledTaskEveryPrefix))
+		if err != nil || interval < time.Minute || interval%time.Minute != 0 {
+			return "", fmt.Errorf("invalid interval in trigger %q, must be a whole number of minutes", trigger)
+		}
+		return "New-ScheduledTaskTrigger -Once -At (Get-Date) -RepetitionInterval (New-TimeSpan -Minutes " +
+			strconv.Itoa(int(interval/time.Minute)) + ")", nil
+	default:
+		return "", fmt.Errorf("invalid trigger %q, must be %s, %sHH:MM or %s<duration>", trigger,
+			scheduledTaskAtStartup, scheduledTaskDailyPrefix, scheduledTaskEveryPrefix)
+	}
+}
+
+// encodePowerShellCommand returns the given PowerShell command encoded as expected by PowerShell's -EncodedCommand
+// parameter, so that it can be passed to PowerShell without being quoted
+func encodePowerShellCommand(command string) string {
+	encoded := make([]byte, 0, 2*len(command))
+	for _, unit := range utf16.Encode([]rune(command)) {
+		encoded = append(encoded, byte(unit), byte(unit>>8))
+	}
+	return base64.StdEncoding.EncodeToString(encoded)
+}
@@ -1,6 +1,9 @@
 package windows
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 type recoveryActionType string
 
@@ -9,8 +12,8 @@ const serviceRestart = recoveryActionType("restart")
 type recoveryAction struct {
 	// actionType is the action that will be performed by the Windows service manager after a program crash
 	actionType recoveryActionType
-	// delay is the amount of time in seconds to wait before performing the specified action
-	delay int
+	// delay is the amount of time to wait before performing the specified action
+	delay time.Duration
 }
 
 // service struct contains the service information
@@ -26,15 +29,14 @@ type service struct {
 	// recoveryActions is a list of recovery actions that the service manager will apply in case of program crash
 	// these actions will be run in order, until the crash counter is reset
 	recoveryActions []recoveryAction
-	// recoveryPeriod is the amount of time in seconds with no failures after which the recoveryAction crash counter
-	// resets
-	recoveryPeriod int
+	// recoveryPeriod is the amount of time with no failures after which the recoveryAction crash counter resets
+	recoveryPeriod time.Duration
 }
 
 // newService initializes and returns a pointer to the service struct. The dependencies, recoveryActions, and
 // recoveryPeriod arguments are optional
 func newService(binaryPath, name, args string, dependencies []string, recoveryActions []recoveryAction,
-	recoveryPeriod int) (*service, error) {
+	recoveryPeriod time.Duration) (*service, error) {
 	if binaryPath == "" || name == "" {
 		return nil, fmt.Errorf("can't instantiate a service with incomplete service parameters")
 	}
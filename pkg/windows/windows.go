@@ -4,25 +4,53 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	config "github.com/openshift/api/config/v1"
 	"golang.org/x/crypto/ssh"
+	"k8s.io/apimachinery/pkg/util/rand"
 	"k8s.io/apimachinery/pkg/util/wait"
 	ctrl "sigs.k8s.io/controller-runtime"
 
+	"github.com/openshift/windows-machine-config-operator/pkg/bootdiagnostics"
 	"github.com/openshift/windows-machine-config-operator/pkg/instance"
 	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig/payload"
 	"github.com/openshift/windows-machine-config-operator/pkg/retry"
+	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
 )
 
 const (
+	// defaultRebootDelay is how long to wait after requesting a reboot before checking if the instance has gone down
+	defaultRebootDelay = 10 * time.Second
 	// remoteDir is the remote temporary directory created on the Windows VM
 	remoteDir = "C:\\Temp"
+	// scriptFilePrefix is the prefix of the name of the temporary files scripts are run from by RunScript and
+	// RunValidationScript
+	scriptFilePrefix = "wmco-script-"
+	// staleScriptAge is how old a temporary script file must be before it is considered stale. Younger script files
+	// may belong to a script which is still running.
+	staleScriptAge = time.Hour
+	// validationLogFile is the name of the file in the WICD log directory holding the output of the most recent run of
+	// the post-configuration validation script
+	validationLogFile = "post-configuration-validation.log"
+	// validationOutputLines is the number of lines of the output of the post-configuration validation script returned
+	// by RunValidationScript, the full output being kept in the validation log
+	validationOutputLines = 20
 	// GcpGetHostnameScriptRemotePath is the remote location of the PowerShell script that resolves the hostname
 	// for GCP instances
 	GcpGetHostnameScriptRemotePath = remoteDir + "\\" + payload.GcpGetHostnameScriptName
@@ -37,16 +65,16 @@ const (
 	CredentialProviderConfig = K8sDir + "\\credential-provider-config.yaml"
 	// KubeconfigPath is the remote location of the kubelet's kubeconfig
 	KubeconfigPath = K8sDir + "\\kubeconfig"
-	// logDir is the remote kubernetes log directory
+	// KubeletCertDir is the default remote directory in which kubelet stores its client and serving certificates
+	KubeletCertDir = "c:\\var\\lib\\kubelet\\pki"
+	// KubeletClientCertPath is the remote location of the file holding the current client certificate and key of
+	// kubelet. It is a symbolic link to the file holding them, which is replaced whenever the certificate is rotated.
+	KubeletClientCertPath = KubeletCertDir + "\\kubelet-client-current.pem"
+	// kubeletServingCertFiles matches the files in kubelet's certificate directory holding its current and previous
+	// serving certificates
+	kubeletServingCertFiles = "kubelet-server-*.pem"
+	// logDir is the default remote kubernetes log directory
 	logDir = "C:\\var\\log"
-	// KubeletLogDir is the remote kubelet log directory
-	KubeletLogDir = logDir + "\\kubelet"
-	// KubeProxyLogDir is the remote kube-proxy log directory
-	KubeProxyLogDir = logDir + "\\kube-proxy"
-	// HybridOverlayLogDir is the remote hybrid-overlay log directory
-	HybridOverlayLogDir = logDir + "\\hybrid-overlay"
-	// wicdLogDir is the remote wicd log directory
-	wicdLogDir = logDir + "\\wicd"
 	// cniDir is the directory for storing CNI binaries
 	cniDir = K8sDir + "\\cni"
 	// CniConfDir is the directory for storing CNI configuration
@@ -58,16 +86,18 @@ const (
 	// TLSConfPath is the location of TLS config files
 	TLSConfPath = TLSDir + "\\windows-exporter-webconfig.yaml"
 	// TLSCertsPath is the location of TLS cert files
-	TLSCertsPath   = TLSDir + "\\certs"
+	TLSCertsPath = TLSDir + "\\certs"
+	// TLSKeyPath is the location of the private key of the TLS cert served by windows_exporter
+	TLSKeyPath     = TLSCertsPath + "\\tls.key"
 	ContainerdPath = ContainerdDir + "\\containerd.exe"
+	// CtrPath is the location of the containerd CLI, used to manage containers directly through containerd
+	CtrPath = ContainerdDir + "\\ctr.exe"
+	// containerdK8sNamespace is the containerd namespace holding the containers created by kubelet
+	containerdK8sNamespace = "k8s.io"
 	// ContainerdConfPath is the location of containerd config file
 	ContainerdConfPath = ContainerdDir + "\\containerd_conf.toml"
 	// ContainerdConfigDir is the remote directory for containerd registry config
 	ContainerdConfigDir = ContainerdDir + "\\registries"
-	// containerdLogDir is the remote containerd log directory
-	containerdLogDir = logDir + "\\containerd"
-	// ContainerdLogPath is the location of the containerd log file
-	ContainerdLogPath = containerdLogDir + "\\containerd.log"
 	// ContainerdServiceName is containerd Windows service name
 	ContainerdServiceName = "containerd"
 	// WicdServiceName is the Windows service name for WICD
@@ -76,8 +106,15 @@ const (
 	wicdPath = K8sDir + "\\windows-instance-config-daemon.exe"
 	// WindowsExporterPath is the location of the windows_exporter.exe
 	WindowsExporterPath = K8sDir + "\\windows_exporter.exe"
+	// WindowsExporterTextfileDir is the directory the windows_exporter textfile collector reads *.prom files from
+	WindowsExporterTextfileDir = K8sDir + "\\windows-exporter\\textfile_inputs"
 	// NetworkConfScriptPath is the location of the network configuration script
 	NetworkConfScriptPath = remoteDir + "\\network-conf.ps1"
+	// HNSEndpointPoliciesPath is the location of the file giving the additional HNS endpoint policies the network
+	// configuration script adds to the CNI config
+	HNSEndpointPoliciesPath = CniConfDir + "\\hns-endpoint-policies.json"
+	// CNIConfigPath is the location of the CNI config file generated by the network configuration script
+	CNIConfigPath = CniConfDir + "\\cni.conf"
 	// AzureCloudNodeManagerPath is the location of the azure-cloud-node-manager.exe
 	AzureCloudNodeManagerPath = K8sDir + "\\" + payload.AzureCloudNodeManager
 	// ECRCredentialProviderPath is the location of ecr credential provider exe
@@ -93,20 +130,12 @@ const (
 	KubeLogRunnerPath = K8sDir + "\\kube-log-runner.exe"
 	// KubeletConfigPath is the location of the kubelet configuration file
 	KubeletConfigPath = K8sDir + "\\kubelet.conf"
-	// KubeletLog is the location of the kubelet log file
-	KubeletLog = KubeletLogDir + "\\kubelet.log"
 	// KubeProxyConfigPath is the location of the kube proxy configuration file
 	KubeProxyConfigPath = K8sDir + "\\kube-proxy.conf"
-	// KubeProxyLog is the location of the kube-proxy log file
-	KubeProxyLog = KubeProxyLogDir + "\\kube-proxy.log"
 	// KubeProxyPath is the location of the kube-proxy exe
 	KubeProxyPath = K8sDir + "\\kube-proxy.exe"
 	// CSIProxyPath is the location of the csi-proxy exe
 	CSIProxyPath = K8sDir + "\\csi-proxy.exe"
-	// csiProxyLogDir is the location of the csi-proxy log file
-	csiProxyLogDir = logDir + "\\csi-proxy"
-	// CSIProxyLog is the location of the csi-proxy log file
-	CSIProxyLog = csiProxyLogDir + "\\csi-proxy.log"
 	// HybridOverlayPath is the location of the hybrid-overlay-node exe
 	HybridOverlayPath = K8sDir + "\\hybrid-overlay-node.exe"
 	// HybridOverlayServiceName is the name of the hybrid-overlay-node Windows service
@@ -125,6 +154,61 @@ const (
 	AzureCloudNodeManagerServiceName = "cloud-node-manager"
 	// serviceQueryCmd is the Windows command used to query a service
 	serviceQueryCmd = "sc.exe qc "
+	// serviceStartNameField is the field of `sc.exe qc` output holding the account a service logs on as
+	serviceStartNameField = "SERVICE_START_NAME"
+	// localSystemAccount is the account services log on as by default
+	localSystemAccount = "LocalSystem"
+	// serviceStateQueryCmd is the Windows command used to query the state of a service
+	serviceStateQueryCmd = "sc.exe queryex "
+	// win32ExitCodeField is the field of `sc.exe queryex` output holding the Win32 error code a service last exited with
+	win32ExitCodeField = "WIN32_EXIT_CODE"
+	// serviceExitCodeField is the field of `sc.exe queryex` output holding the service specific error code a service
+	// last exited with
+	serviceExitCodeField = "SERVICE_EXIT_CODE"
+	// errorServiceSpecificError is the Win32 exit code of a service which reported a service specific error code
+	errorServiceSpecificError = 1066
+	// serviceTerminatedEventIDs are the IDs of the Service Control Manager events logged when a service terminates
+	// unexpectedly, with and without a recovery action being taken
+	serviceTerminatedEventIDs = "7031,7034"
+	// containerdPipeName is the name of the named pipe containerd serves its CRI endpoint on
+	containerdPipeName = "containerd-containerd"
+	// containerdStateTimeoutSeconds is how long containerd's CRI endpoint is given to respond before it is considered
+	// unresponsive
+	containerdStateTimeoutSeconds = 10
+	// Outputs of containerdStateCmd
+	criResponsive    = "CRI_RESPONSIVE"
+	criUnresponsive  = "CRI_UNRESPONSIVE"
+	pipeResponsive   = "PIPE_RESPONSIVE"
+	pipeUnresponsive = "PIPE_UNRESPONSIVE"
+	// bootDiagnosticsTimeout is how long to wait for the cloud provider to return the console output of an instance
+	bootDiagnosticsTimeout = time.Minute
+	// defaultWICDRecoveryResetPeriod is how long WICD must run without crashing for its crash counter to be reset, if
+	// not configured
+	defaultWICDRecoveryResetPeriod = 5 * time.Minute
+	// computerInfoCmd outputs the description of the instance's operating system and hardware as JSON. Get-ComputerInfo
+	// is not used as it gathers many more properties and takes several seconds, the CIM queries are limited to the
+	// properties needed.
+	computerInfoCmd = "$os = Get-CimInstance Win32_OperatingSystem -Property Caption,Version,BuildNumber; " +
+		"$cs = Get-CimInstance Win32_ComputerSystem " +
+		"-Property TotalPhysicalMemory,NumberOfLogicalProcessors,Manufacturer,Model; " +
+		"$edition = (Get-ItemProperty 'HKLM:\\SOFTWARE\\Microsoft\\Windows NT\\CurrentVersion').EditionID; " +
+		"ConvertTo-Json -Compress -InputObject @{osName = [string]$os.Caption; osVersion = [string]$os.Version; " +
+		"build = [int]$os.BuildNumber; edition = [string]$edition; " +
+		"totalMemoryBytes = [uint64]$cs.TotalPhysicalMemory; logicalProcessors = [int]$cs.NumberOfLogicalProcessors; " +
+		"manufacturer = [string]$cs.Manufacturer; model = [string]$cs.Model}"
+	// interactiveLogonTypesFilter is the WQL filter matching the logon sessions of users logged on through the console
+	// (2), RDP (10) or with cached credentials (11). SSH sessions, including WMCO's own, are network logons.
+	interactiveLogonTypesFilter = "LogonType = 2 OR LogonType = 10 OR LogonType = 11"
+	// systemEnvironmentKey is the registry key holding the system environment variables
+	systemEnvironmentKey = "HKLM:\\SYSTEM\\CurrentControlSet\\Control\\Session Manager\\Environment"
+	// pathValue is the registry value holding the system PATH
+	pathValue = "Path"
+	// sessionManagerKey is the registry key holding the PendingFileRenameOperations value
+	sessionManagerKey = "HKLM:\\SYSTEM\\CurrentControlSet\\Control\\Session Manager"
+	// activeComputerNameKey is the registry key holding the name the computer was started with
+	activeComputerNameKey = "HKLM:\\SYSTEM\\CurrentControlSet\\Control\\ComputerName\\ActiveComputerName"
+	// computerNameKey is the registry key holding the name the computer will have once restarted
+	computerNameKey = "HKLM:\\SYSTEM\\CurrentControlSet\\Control\\ComputerName\\ComputerName"
 	// serviceNotFound is part of the error output returned when a service does not exist. 1060 is an error code
 	// representing ERROR_SERVICE_DOES_NOT_EXIST
 	// referenced: https://docs.microsoft.com/en-us/windows/win32/debug/system-error-codes--1000-1299-
@@ -137,10 +221,66 @@ const (
 	ManagedTag = "OpenShift managed"
 	// containersFeatureName is the name of the Windows feature that is required to be enabled on the Windows instance.
 	containersFeatureName = "Containers"
+	// pagefileUnchanged is output by the pagefile configuration command when no change is needed
+	pagefileUnchanged = "unchanged"
+	// crashControlKey is the registry key holding the crash dump configuration of Windows
+	crashControlKey = "HKLM:\\SYSTEM\\CurrentControlSet\\Control\\CrashControl"
+	// crashDumpPolicyUnchanged is output by the crash dump configuration command when no change is needed
+	crashDumpPolicyUnchanged = "unchanged"
+	// schannelProtocolsKey is the registry key holding a subkey per SChannel protocol
+	schannelProtocolsKey = "HKLM:\\SYSTEM\\CurrentControlSet\\Control\\SecurityProviders\\SCHANNEL\\Protocols"
+	// schannelProtocolsUnchanged is output by the SChannel protocol configuration command when no change is needed
+	schannelProtocolsUnchanged = "unchanged"
+	// ntpServersUnchanged is output by the NTP server query command when the servers are already configured
+	ntpServersUnchanged = "unchanged"
+	// w32timeServiceName is the name of the Windows Time service
+	w32timeServiceName = "W32Time"
+	// w32timeParametersKey is the registry key holding the configuration of the Windows Time service
+	w32timeParametersKey = "HKLM:\\SYSTEM\\CurrentControlSet\\Services\\W32Time\\Parameters"
+	// minKubeProxyKernelspaceBuild is the earliest Windows build whose HNS supports kube-proxy's kernelspace proxier,
+	// which corresponds to Windows Server 2019
+	minKubeProxyKernelspaceBuild = 17763
+	// hnsServiceName is the name of the Host Networking Service, which the kernelspace proxier programs
+	hnsServiceName = "hns"
+	// registryReachable is output by the registry probe command when the registry responded
+	registryReachable = "reachable"
+	// networkAdaptersCmd outputs the visible network adapters of the instance as JSON. Properties are read directly
+	// rather than from formatted output, which is localized, and the connection state is compared with the numeric
+	// MediaConnectState value, where 1 means connected. The default route is the one with the lowest metric.
+	networkAdaptersCmd = "$route = Get-NetRoute -DestinationPrefix '0.0.0.0/0' -ErrorAction SilentlyContinue | " +
+		"Sort-Object -Property { $_.RouteMetric + " +
+		"(Get-NetIPInterface -InterfaceIndex $_.ifIndex -AddressFamily IPv4).InterfaceMetric } | " +
+		"Select-Object -First 1; " +
+		"$adapters = @(Get-NetAdapter | ForEach-Object { $a = $_; [PSCustomObject]@{ " +
+		"name = $a.Name; description = $a.InterfaceDescription; index = [int]$a.ifIndex; " +
+		"macAddress = $a.MacAddress; connected = ($a.MediaConnectState -eq 1); " +
+		"ipv4Addresses = @(Get-NetIPAddress -InterfaceIndex $a.ifIndex -AddressFamily IPv4 " +
+		"-ErrorAction SilentlyContinue | ForEach-Object { $_.IPAddress }); " +
+		"defaultRoute = [bool]($route -and $route.ifIndex -eq $a.ifIndex) } }); " +
+		"ConvertTo-Json -InputObject $adapters -Depth 3 -Compress"
+	// registryProbeTimeoutSeconds is how long the registry probe command waits for a registry to respond
+	registryProbeTimeoutSeconds = 10
+	// defaultMinFreeMemory is the free memory, in bytes, below which a warning is logged before installing the
+	// Containers feature, when no minimum has been configured
+	defaultMinFreeMemory = 1024 * 1024 * 1024
 	// wicdKubeconfigPath is the path of the kubeconfig used by WICD
 	wicdKubeconfigPath = K8sDir + "\\wicd-kubeconfig"
 	// TrustedCABundlePath is the location of the trusted CA bundle file
 	TrustedCABundlePath = K8sDir + "\\ca-bundle.crt"
+	// SystemSID is the SID of the LocalSystem account, which the WMCO managed services run as
+	SystemSID = "S-1-5-18"
+	// AdministratorsSID is the SID of the built-in Administrators group
+	AdministratorsSID = "S-1-5-32-544"
+	// fullControlRights is the name of the file system rights granting full control over a file
+	fullControlRights = "FullControl"
+	// accessDenied is part of the error output returned by PowerShell when a command requires privileges the user lacks
+	accessDenied = "Access is denied"
+	// privilegeNotHeld is part of the error output returned when a privilege required by a command is not held
+	privilegeNotHeld = "A required privilege is not held by the client"
+	// adminPrivilegesCmd is the PowerShell command which outputs True if the current user's token belongs to the
+	// Administrators role, which is only the case for elevated tokens of members of the Administrators group
+	adminPrivilegesCmd = "([Security.Principal.WindowsPrincipal][Security.Principal.WindowsIdentity]::GetCurrent())" +
+		".IsInRole([Security.Principal.WindowsBuiltInRole]::Administrator)"
 	// GetHostnameFQDNCommand is the PowerShell command to get the FQDN hostname of the Windows instance
 	GetHostnameFQDNCommand = "$output = Invoke-Expression 'ipconfig /all'; " +
 		"$hostNameLine = ($output -split '`n') | Where-Object { $_ -match 'Host Name' }; " +
@@ -150,9 +290,47 @@ const (
 		"if (-not $dnsSuffix) { return $hostName }; " +
 		"$fqdn = $hostName + '.' + $dnsSuffix; " +
 		"return $fqdn"
+	// DefaultRouteIPv4Command is the PowerShell command to get the first IPv4 address of the interface used by the
+	// default route of the Windows instance
+	DefaultRouteIPv4Command = "(Get-NetRoute -DestinationPrefix '0.0.0.0/0' | " +
+		"Get-NetIpAddress -AddressFamily IPv4 -ifIndex {$_.ifIndex}[0]).IPAddress"
+	// vSphereGuestIPCommand is the PowerShell command to get the primary IP address of the Windows instance, as
+	// reported to vSphere by VMware Tools
+	vSphereGuestIPCommand = "& 'C:\\Program Files\\VMware\\VMware Tools\\vmtoolsd.exe' " +
+		"--cmd 'info-get guestinfo.ip'"
+	// VSphereNodeIPCommand is the PowerShell command to get the IP address a vSphere Windows instance should be
+	// registered with. The address reported by VMware Tools is preferred, as the default route may use a
+	// non-routable interface on instances with multiple NICs.
+	VSphereNodeIPCommand = "$ip = " + vSphereGuestIPCommand + " 2>$null; " +
+		"if ($LASTEXITCODE -eq 0 -and $ip) { return $ip.Trim() }; " + DefaultRouteIPv4Command
 )
 
 var (
+	// sidRegex matches security identifiers given in their string form, such as S-1-5-18
+	sidRegex = regexp.MustCompile(`^S-1-[0-9]+(-[0-9]+)+$`)
+	// CredentialFileSIDs are the SIDs granted access to the credential files on an instance
+	CredentialFileSIDs = []string{SystemSID, AdministratorsSID}
+	// credentialFiles are the files on an instance which hold credentials, such as the kubeconfigs used by kubelet and
+	// WICD, and must only be accessible to CredentialFileSIDs
+	credentialFiles = []string{wicdKubeconfigPath, BootstrapKubeconfigPath, KubeconfigPath, KubeletClientCertPath,
+		TLSKeyPath}
+	// scriptParameterRegex matches the names which can be given to the parameters of scripts run through RunScript
+	scriptParameterRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// connectivityHostRegex matches the hosts which can be given to TestConnectivity: DNS names, IPv4 addresses and
+	// IPv6 addresses without brackets
+	connectivityHostRegex = regexp.MustCompile(`^[a-zA-Z0-9.\-:]+$`)
+	// rebootPendingKeys are the registry keys which exist while a reboot is pending to complete the installation of
+	// updates or Windows features. PendingFileRenameOperations is not considered, as it is commonly left set by
+	// software installers and does not affect feature installation.
+	rebootPendingKeys = []string{
+		"HKLM:\\SOFTWARE\\Microsoft\\Windows\\CurrentVersion\\Component Based Servicing\\RebootPending",
+		"HKLM:\\SOFTWARE\\Microsoft\\Windows\\CurrentVersion\\WindowsUpdate\\Auto Update\\RebootRequired",
+	}
+	// defaultWICDRecoveryDelays are how long the Windows service manager waits before each successive restart of WICD
+	// after it crashes, if not configured
+	defaultWICDRecoveryDelays = []time.Duration{10 * time.Second, 30 * time.Second, time.Minute, 2 * time.Minute}
+	// defaultLogPaths are the locations of the logs of the services under the default log directory
+	defaultLogPaths = NewLogPaths("")
 	// RequiredServices is a list of Windows services installed by WMCO. WICD owns all services aside from itself.
 	// The order of this slice matters due to service dependencies. If a service depends on another service, the
 	// dependent service should be placed before the service it depends on.
@@ -169,17 +347,19 @@ var (
 		cniDir,
 		CniConfDir,
 		logDir,
-		KubeletLogDir,
-		csiProxyLogDir,
-		KubeProxyLogDir,
-		wicdLogDir,
-		HybridOverlayLogDir,
+		defaultLogPaths.KubeletDir,
+		defaultLogPaths.CSIProxyDir,
+		defaultLogPaths.KubeProxyDir,
+		defaultLogPaths.WICDDir,
+		defaultLogPaths.HybridOverlayDir,
 		ContainerdDir,
-		containerdLogDir,
+		defaultLogPaths.ContainerdDir,
 		ContainerdConfigDir,
 		podManifestDirectory,
 		K8sDir,
 		TLSDir,
+		WindowsExporterTextfileDir,
+		KubeletCertDir,
 	}
 )
 
@@ -197,7 +377,8 @@ func createPayload(platform *config.PlatformType) (map[*payload.FileInfo]string,
 	return files, nil
 }
 
-// getFilesToTransfer returns the properly populated filesToTransfer map. Note this does not include the WICD binary.
+// getFilesToTransfer returns the properly populated filesToTransfer map. Note this does not include the WICD binary,
+// nor the containerd config which is generated from the settings ConfigMap.
 func getFilesToTransfer(platform *config.PlatformType) map[string]string {
 	srcDestPairs := map[string]string{
 		payload.GcpGetValidHostnameScriptPath:  remoteDir,
@@ -213,8 +394,8 @@ func getFilesToTransfer(platform *config.PlatformType) map[string]string {
 		payload.KubeLogRunnerPath:              K8sDir,
 		payload.CSIProxyPath:                   K8sDir,
 		payload.ContainerdPath:                 ContainerdDir,
+		payload.CtrPath:                        ContainerdDir,
 		payload.HcsshimPath:                    ContainerdDir,
-		payload.ContainerdConfPath:             ContainerdDir,
 		payload.TLSConfPath:                    TLSDir,
 		payload.NetworkConfigurationScript:     remoteDir,
 	}
@@ -236,12 +417,129 @@ func GetK8sDir() string {
 	return K8sDir
 }
 
-// Windows contains all the methods needed to configure a Windows VM to become a worker node
-type Windows interface {
+// ChecksumMismatchErr occurs when a payload file already present on the instance has a different checksum than the
+// one shipped with WMCO, and could not be replaced. This indicates the file was modified on the instance, or was left
+// partially written by a prior transfer.
+type ChecksumMismatchErr struct {
+	// Path is the location of the file on the instance
+	Path string
+	// Expected is the SHA256 checksum of the payload file
+	Expected string
+	// Found is the SHA256 checksum of the file present on the instance
+	Found string
+	// err is the error which prevented the file from being replaced
+	err error
+}
+
+func (e *ChecksumMismatchErr) Error() string {
+	return fmt.Sprintf("file %s on the instance has SHA256 checksum %s instead of the expected %s, and could not be "+
+		"replaced: %s", e.Path, e.Found, e.Expected, e.err)
+}
+
+func (e *ChecksumMismatchErr) Unwrap() error {
+	return e.err
+}
+
+// NetworkAdapter describes a network adapter of an instance
+type NetworkAdapter struct {
+	// Name is the alias of the adapter, such as Ethernet0
+	Name string `json:"name"`
+	// Description is the description of the adapter, typically naming its hardware or driver
+	Description string `json:"description"`
+	// Index is the interface index of the adapter
+	Index int `json:"index"`
+	// MACAddress is the MAC address of the adapter
+	MACAddress string `json:"macAddress"`
+	// Connected indicates the adapter has a network connection. Disconnected adapters have no IPv4 addresses.
+	Connected bool `json:"connected"`
+	// IPv4Addresses are the IPv4 addresses assigned to the adapter
+	IPv4Addresses []string `json:"ipv4Addresses"`
+	// DefaultRoute indicates the adapter is the interface used by the instance's preferred default IPv4 route
+	DefaultRoute bool `json:"defaultRoute"`
+}
+
+// ConnectivityResult is the result of a check of whether an instance can open a TCP connection to an endpoint
+type ConnectivityResult struct {
+	// TCPTestSucceeded indicates a TCP connection to the endpoint was established
+	TCPTestSucceeded bool `json:"tcpTestSucceeded"`
+	// RemoteAddress is the address the host of the endpoint resolved to. It is empty if the host could not be resolved.
+	RemoteAddress string `json:"remoteAddress"`
+	// PingSucceeded indicates the host of the endpoint replied to an ICMP echo request
+	PingSucceeded bool `json:"pingSucceeded"`
+	// RoundTripMs is the round trip time, in milliseconds, of the ICMP echo request to the host of the endpoint. It is
+	// 0 if the host did not reply, as is the case when ICMP is blocked.
+	RoundTripMs int64 `json:"roundTripMs"`
+}
+
+// FileACL describes the owner and access rules of a file
+type FileACL struct {
+	// Owner is the SID of the owner of the file
+	Owner string `json:"owner"`
+	// Protected indicates the file does not inherit access rules from its parent directory
+	Protected bool `json:"protected"`
+	// Rules are the access rules of the file, including inherited rules
+	Rules []FileAccessRule `json:"rules"`
+}
+
+// FileAccessRule describes an access rule of a file
+type FileAccessRule struct {
+	// SID is the SID of the account or group the rule applies to
+	SID string `json:"sid"`
+	// Rights are the file system rights given by the rule, such as FullControl, as named by PowerShell
+	Rights string `json:"rights"`
+	// Allow indicates the rule grants the rights, rather than denying them
+	Allow bool `json:"allow"`
+}
+
+// ComputerInfo describes the operating system and hardware of an instance
+type ComputerInfo struct {
+	// OSName is the name of the operating system, such as Microsoft Windows Server 2022 Datacenter
+	OSName string `json:"osName"`
+	// OSVersion is the version of the operating system, such as 10.0.20348
+	OSVersion string `json:"osVersion"`
+	// Build is the build number of the operating system, such as 20348
+	Build int `json:"build"`
+	// Edition is the ID of the edition of the operating system, such as ServerDatacenter
+	Edition string `json:"edition"`
+	// TotalMemoryBytes is the total physical memory of the instance, in bytes
+	TotalMemoryBytes uint64 `json:"totalMemoryBytes"`
+	// LogicalProcessors is the number of logical processors of the instance
+	LogicalProcessors int `json:"logicalProcessors"`
+	// Manufacturer is the manufacturer of the instance, such as the name of the hypervisor vendor for VMs
+	Manufacturer string `json:"manufacturer"`
+	// Model is the model of the instance
+	Model string `json:"model"`
+}
+
+// HNSNetworkIPUsage describes how many of the addresses of an HNS network's subnet are assigned to endpoints
+type HNSNetworkIPUsage struct {
+	// Subnet is the address prefix of the network's subnet, such as 10.132.1.0/24
+	Subnet string
+	// Size is the number of addresses of the subnet which can be assigned to endpoints. The network address, the
+	// gateway address and the broadcast address are reserved.
+	Size int
+	// Used is the number of addresses of the subnet assigned to the endpoints of the network
+	Used int
+}
+
+// Connection is the SSH connection to a Windows instance, over which commands are run and files are transferred
+type Connection interface {
 	// GetIPv4Address returns the IPv4 address of the associated instance.
 	GetIPv4Address() string
 	// GetHostname returns the FQDN of the associated instance including the domain name, if any
 	GetHostname() (string, error)
+	// Run executes the given command remotely on the Windows VM over a ssh connection and returns the combined output
+	// of stdout and stderr. If the bool is set, it implies that the cmd is to be execute in PowerShell. This function
+	// should be used in scenarios where you want to execute a command that runs in the background. In these cases we
+	// have observed that Run() returns before the command completes and as a result killing the process.
+	Run(string, bool) (string, error)
+	// RunScript uploads the given PowerShell script to a temporary file on the instance and runs it with the given
+	// arguments, keyed by parameter name, returning the combined output of stdout and stderr. The temporary file is
+	// removed once the script exits, whether it succeeded or not. Argument values must not contain double quotes.
+	RunScript(string, map[string]string) (string, error)
+	// FileExists returns true if a specific file exists at the given path and checksum on the Windows VM. Set an
+	// empty checksum (checksum == "") to disable checksum check.
+	FileExists(string, string) (bool, error)
 	// EnsureFile ensures the given file exists within the specified directory on the Windows VM. The file will be copied
 	// to the Windows VM if it is not present or if it has the incorrect contents. The remote directory is created if it
 	// does not exist.
@@ -250,31 +548,246 @@ type Windows interface {
 	// The content will be copied to the Windows VM if the file is not present or has incorrect contents. The remote
 	// directory is created if it does not exist.
 	EnsureFileContent([]byte, string, string) error
-	// EnsureHNSNetworksAreRemoved ensures the HNS networks created by the hybrid-overlay configuration process are removed
-	// by repeatedly checking and retrying the removal of each network.
-	EnsureHNSNetworksAreRemoved() error
-	// FileExists returns true if a specific file exists at the given path and checksum on the Windows VM. Set an
-	// empty checksum (checksum == "") to disable checksum check.
-	FileExists(string, string) (bool, error)
+	// EnsureDirectory creates the directory at the given path on the instance, along with its parents, if it does
+	// not exist
+	EnsureDirectory(string) error
 	// ReplaceDir transfers the given files to their given paths within the remote directory the Windows instance.
 	// The destination dir will only contain the given files after this function is called, clearing existing content.
 	ReplaceDir(map[string][]byte, string) error
-	// Run executes the given command remotely on the Windows VM over a ssh connection and returns the combined output
-	// of stdout and stderr. If the bool is set, it implies that the cmd is to be execute in PowerShell. This function
-	// should be used in scenarios where you want to execute a command that runs in the background. In these cases we
-	// have observed that Run() returns before the command completes and as a result killing the process.
-	Run(string, bool) (string, error)
+	// AddLogValues adds the given key/value pairs to all further log entries about the instance
+	AddLogValues(...interface{})
+	// Close closes the SSH connection to the instance. It must be called once the instance is no longer interacted
+	// with, and no other method may be called afterwards.
+	Close() error
+}
+
+// Registry reads and writes the registry of a Windows instance
+type Registry interface {
+	// GetRegistryValue returns the registry value with the given name under the registry key with the given
+	// PowerShell path. Environment variables within the value are not expanded.
+	GetRegistryValue(string, string) (string, error)
+	// EnsureRegistryValue ensures the registry value with the given name under the registry key with the given
+	// PowerShell path is the given string, returning true if it had to be changed. The type of an existing value is
+	// kept, new values are created as REG_SZ values.
+	EnsureRegistryValue(string, string, string) (bool, error)
+	// GetPendingFileRenameOperations returns the paths of the files Windows is to rename, replace or delete when the
+	// instance next restarts, as given by the PendingFileRenameOperations registry value. Such files are typically
+	// locked by a running process, such as when an update replaced a file in use. An empty slice is returned if no
+	// operation is pending.
+	GetPendingFileRenameOperations() ([]string, error)
+}
+
+// Diagnostics collects the information needed to debug a Windows instance and the services WMCO manages on it
+type Diagnostics interface {
+	// GatherDebugBundle collects the state of the instance, such as the config of WMCO-managed services, log tails, HNS
+	// state and file checksums. The keys of the returned map are file names, and the values are the file contents.
+	// If any information cannot be collected, the partial results are returned alongside an error.
+	GatherDebugBundle() (map[string][]byte, error)
+	// CaptureProcessDump returns a memory dump of the process of the given service, which must be one of
+	// ProcessDumpServices. The dump is taken with procdump if the tool is installed on the instance and the process is
+	// running, otherwise the newest crash dump written by Windows Error Reporting is returned. ErrNoProcessDump is
+	// returned if neither is available.
+	CaptureProcessDump(string) ([]byte, error)
+	// StartETWTrace starts tracing the events of the given ETW providers on the instance, which must be among
+	// ETWTraceProviders. ErrETWTraceRunning is returned if a trace is already running.
+	StartETWTrace([]string) error
+	// StopETWTrace stops the running ETW trace and returns the collected events, in the ETL format. The trace is
+	// removed from the instance once collected. ErrNoETWTrace is returned if no trace is running.
+	StopETWTrace() ([]byte, error)
+	// GetWICDDiagnostics returns the state of the WICD service and the most recent lines of its log. The state is
+	// returned alongside an error if the logs cannot be collected.
+	GetWICDDiagnostics() (string, error)
+	// GetBootDiagnostics returns the console output of the instance, as retrieved from the cloud provider hosting it.
+	// This is useful when the instance cannot be reached over SSH, such as after a reboot which did not complete.
+	// bootdiagnostics.ErrUnavailable is returned on platforms which do not support this.
+	GetBootDiagnostics() (string, error)
+	// ExportStateManifest returns a StateManifest, as indented JSON, of the files, services and environment
+	// variables given by the services ConfigMap data, recording for each of them both what the ConfigMap expects
+	// and what is found on the instance. The output only changes if the state changes, so that manifests taken over
+	// time can be diffed to detect drift.
+	ExportStateManifest(*servicescm.Data) ([]byte, error)
+}
+
+// Windows contains all the methods needed to configure a Windows VM to become a worker node
+type Windows interface {
+	Connection
+	Registry
+	Diagnostics
+	// GetBuildNumber returns the Windows build number of the associated instance, such as 20348 for Windows Server
+	// 2022
+	GetBuildNumber() (int, error)
+	// GetComputerInfo returns a description of the operating system and hardware of the instance
+	GetComputerInfo() (*ComputerInfo, error)
+	// EnsureHNSNetworksAreRemoved ensures the HNS networks created by the hybrid-overlay configuration process are removed
+	// by repeatedly checking and retrying the removal of each network.
+	EnsureHNSNetworksAreRemoved() error
+	// EnsureLogDirectories creates the log directories of the services on the instance, if they do not exist, when
+	// the services log to a directory other than the default log directory
+	EnsureLogDirectories() error
 	// RebootAndReinitialize reboots the instance and re-initializes the Windows SSH client
 	RebootAndReinitialize() error
-	// Bootstrap prepares the Windows instance and runs the WICD bootstrap command
-	Bootstrap(string, string, string) error
-	// ConfigureWICD ensures that the Windows Instance Config Daemon is running on the node
-	ConfigureWICD(string, string) error
-	// RemoveFilesAndNetworks removes all files and networks created by WMCO
+	// Bootstrap prepares the Windows instance and runs the WICD bootstrap command. If the Windows Containers feature
+	// must be installed and the instance has less free memory than the given number of bytes, Bootstrap fails. If the
+	// given number is 0, only a warning is logged when free memory is below defaultMinFreeMemory.
+	Bootstrap(string, string, string, uint64) error
+	// ConfigureWICD ensures that the Windows Instance Config Daemon is running on the node as the given account,
+	// restarted after crashes as given by the ServiceRecovery. WICD runs as LocalSystem if the account is empty, and
+	// the default recovery actions are used if the ServiceRecovery is nil.
+	ConfigureWICD(string, string, *ServiceRecovery, string) error
+	// SetWICDRecoveryActions sets how the Windows service manager restarts the existing WICD service after it crashes.
+	// The default recovery actions are used if the ServiceRecovery is nil.
+	SetWICDRecoveryActions(*ServiceRecovery) error
+	// SetWICDServiceAccount ensures the existing WICD service logs on as the given account, restarting WICD if its
+	// account had to be changed. The account must be a member of the local Administrators group, as WICD manages the
+	// services and networks of the instance. WICD runs as LocalSystem if the account is empty.
+	SetWICDServiceAccount(string) error
+	// RemoveFilesAndNetworks removes all files, networks, scheduled tasks and firewall rules created by WMCO
 	RemoveFilesAndNetworks() error
+	// RemoveFiles removes all files, scheduled tasks and firewall rules created by WMCO, leaving the HNS networks in
+	// place, as removing them briefly disrupts the networking of the whole instance
+	RemoveFiles() error
 	// RunWICDCleanup ensures the WICD service is stopped and runs the cleanup command that ensures all WICD-managed
 	// services are also stopped
 	RunWICDCleanup(string, string) error
+	// SetTimezone sets the timezone of the instance to the timezone with the given ID, if not already set. The ID must
+	// be one of the timezones returned by `Get-TimeZone -ListAvailable` on the instance.
+	SetTimezone(string) error
+	// SetPagefile ensures the instance's pagefile is manually managed, with an initial size of at least the given
+	// number of MB. Returns true if the pagefile configuration was changed, which only takes effect after a reboot.
+	SetPagefile(int) (bool, error)
+	// SetCrashDumpPolicy ensures the instance writes a memory dump of the given type, one of Small, Kernel, Automatic
+	// or Complete, when it crashes, and keeps the dump even when disk space is low. Crash dumps are disabled if
+	// enabled is false, in which case the type is ignored. Returns true if the configuration was changed, which only
+	// takes effect after a reboot.
+	SetCrashDumpPolicy(bool, string) (bool, error)
+	// EnsureSChannelProtocols ensures the given SChannel protocols, each one of SSL2.0, SSL3.0, TLS1.0 or TLS1.1, are
+	// disabled for both the clients and servers of the instance. Other protocols are left unchanged. Returns true if
+	// any protocol had to be disabled, which only takes effect after a reboot.
+	EnsureSChannelProtocols([]string) (bool, error)
+	// TestRegistryConnectivity checks if the instance can reach each of the given container registry hosts, returning
+	// the result of each check keyed by registry. A nil result means the registry was reached. Registries are contacted
+	// through the cluster-wide proxy, unless excluded from it. An error is returned if the checks could not be run.
+	TestRegistryConnectivity([]string) (map[string]error, error)
+	// TestConnectivity checks if the instance can open a TCP connection to the given host and port, returning the
+	// result of the check. An error is returned if the check could not be run, not if the endpoint is unreachable.
+	TestConnectivity(string, int) (*ConnectivityResult, error)
+	// SetNTPServers ensures the instance synchronizes its time with the given NTP servers, enabling and starting the
+	// Windows Time service if needed
+	SetNTPServers([]string) error
+	// GetVSphereGuestIP returns the primary IP address of the instance as reported to vSphere by VMware Tools
+	GetVSphereGuestIP() (string, error)
+	// GetFreeMemory returns the amount of free physical memory on the instance, in bytes
+	GetFreeMemory() (uint64, error)
+	// GetInterfaceMTU returns the IPv4 MTU of the network interface with the given alias
+	GetInterfaceMTU(string) (int, error)
+	// GetHNSNetworkInterfaceAlias returns the alias of the host vEthernet adapter of the HNS network with the given
+	// name, which is the interface traffic of the network is sent over
+	GetHNSNetworkInterfaceAlias(string) (string, error)
+	// GetNetworkAdapters returns the visible network adapters of the instance, including disconnected ones, ordered
+	// by interface index
+	GetNetworkAdapters() ([]NetworkAdapter, error)
+	// GetFileACL returns the owner and access rules of the file at the given path
+	GetFileACL(string) (*FileACL, error)
+	// EnsureFileACL ensures the file at the given path is owned by the given SID, does not inherit access rules from
+	// its parent directory and only grants full control to the given SIDs. The ACL is only changed if it differs.
+	EnsureFileACL(string, string, []string) error
+	// EnsureCredentialFileACLs ensures the files on the instance which hold credentials are only accessible to
+	// CredentialFileSIDs. Files which do not exist yet, such as those written by kubelet once it has joined the cluster,
+	// are skipped.
+	EnsureCredentialFileACLs() error
+	// UpdateWICDKubeconfig ensures the kubeconfig used by WICD has the given contents, restarting WICD if the file
+	// had to be changed so that the new credentials are used
+	UpdateWICDKubeconfig(string) error
+	// EnsureHNSEndpointPolicies ensures the file giving the additional HNS endpoint policies has the given contents.
+	// The policies are not validated by HNS until they are applied to the endpoints of new pods.
+	EnsureHNSEndpointPolicies(string) error
+	// GetCNIConfig returns the contents of the CNI config file generated on the instance
+	GetCNIConfig() (string, error)
+	// GetContainerdConfig returns the contents of the containerd config file on the instance, or an empty string if
+	// the file does not exist
+	GetContainerdConfig() (string, error)
+	// RepairCNIConfig ensures the network configuration script on the instance is the one generated for the current
+	// cluster network, and restarts WICD so that the CNI config is generated again by the script
+	RepairCNIConfig() error
+	// ValidateCNIConsistency returns an error naming each CNI plugin referenced by the CNI config on the instance
+	// which is missing from the CNI directory, or whose binary differs from the one in the WMCO payload
+	ValidateCNIConsistency() error
+	// ValidateContainerdCNIConfig returns an error if the effective config of the containerd service does not give
+	// the CNI config and plugin directories WMCO populates
+	ValidateContainerdCNIConfig() error
+	// GetWICDKubeconfigServer returns the API server URL given by the kubeconfig used by WICD
+	GetWICDKubeconfigServer() (string, error)
+	// RestartService restarts the Windows service with the given name, starting it if it is not running. Running
+	// services which depend on it are stopped before it and started again after it, in dependency order.
+	RestartService(string) error
+	// GetEffectiveKubeletFlags returns the flags the kubelet service is configured to run with, keyed by flag name
+	GetEffectiveKubeletFlags() (map[string]string, error)
+	// RenewKubeletServingCert removes kubelet's serving certificate from the given certificate directory and restarts
+	// kubelet, so that a new serving certificate is requested for the instance's current addresses
+	RenewKubeletServingCert(string) error
+	// GetCertificateExpiry returns the expiry of the PEM encoded certificate in the file at the given path, or of the
+	// leaf certificate if the file holds a chain. ErrNotCertificate is returned if the file holds no certificate.
+	GetCertificateExpiry(string) (time.Time, error)
+	// GetServiceLastExitCode returns the exit code the WMCO-managed service with the given name last stopped with, as
+	// reported by the service control manager. This is the service specific error code if the service reported one,
+	// and 0 if the service has not stopped or last stopped cleanly.
+	GetServiceLastExitCode(string) (int, error)
+	// ExportHNSConfig returns the configuration of the WMCO-managed HNS networks of the instance, including their
+	// subnets and policies, as JSON. Other HNS networks of the instance are not exported.
+	ExportHNSConfig() ([]byte, error)
+	// ImportHNSConfig creates the HNS networks given by a configuration returned by ExportHNSConfig, such as one
+	// exported from the host the instance replaces. An error is returned without creating any network if the
+	// configuration gives a network which is not WMCO-managed, or is not compatible with the networking of the
+	// instance. Networks which already exist with the given configuration are left unchanged.
+	ImportHNSConfig([]byte) error
+	// GetContainerdState returns an error if the containerd service is not running, or if its CRI endpoint does not
+	// respond even though the service is running. The endpoint is queried with crictl if it is on the PATH, otherwise
+	// only connectivity to the endpoint's named pipe is checked.
+	GetContainerdState() error
+	// EnsureScheduledTask ensures a WMCO-managed scheduled task with the given name runs the given PowerShell command
+	// as SYSTEM on the given trigger, registering it again if its definition changed. The trigger is one of atstartup,
+	// daily@HH:MM, or every:<duration> for a whole number of minutes such as every:30m.
+	EnsureScheduledTask(string, string, string) error
+	// RemoveScheduledTask removes the WMCO-managed scheduled task with the given name, if it exists
+	RemoveScheduledTask(string) error
+	// EnsureFirewallRule ensures a WMCO-managed inbound firewall rule with the given name allows TCP traffic to the
+	// given local port
+	EnsureFirewallRule(string, int) error
+	// IsPortListening returns true if a process on the instance is listening for TCP connections on the given port
+	IsPortListening(int) (bool, error)
+	// GetListeningPorts returns the ports on which processes on the instance are listening for TCP connections, each
+	// mapped to the name of the process listening on it
+	GetListeningPorts() (map[int]string, error)
+	// SetPowerPlan makes the power plan with the given GUID the active power plan of the instance, if it is not
+	// already active. The plan must be one of the plans listed by `powercfg /list` on the instance.
+	SetPowerPlan(string) error
+	// SetDynamicPortRange sets the range of ports the instance allocates the local ports of outgoing TCP connections
+	// and UDP sockets from to the given number of ports starting at the given port, if it is not already that range
+	SetDynamicPortRange(int, int) error
+	// SetFirewallProfileState enables or disables the Windows firewall profile with the given name, one of Domain,
+	// Private or Public, if it is not already in that state
+	SetFirewallProfileState(string, bool) error
+	// GetLoggedOnUsers returns the names, in the form DOMAIN\user, of the users with an interactive session on the
+	// instance, such as through the console or RDP. An empty slice is returned if there are none.
+	GetLoggedOnUsers() ([]string, error)
+	// IsRebootPending returns true if the instance must be restarted to complete a change made by a prior update or
+	// configuration, such as a servicing operation or a computer rename
+	IsRebootPending() (bool, error)
+	// EnsurePathEntry ensures the given directory is an entry of the system PATH of the instance, adding it at the end
+	// of the PATH if it is not. The change is only seen by sessions and services started after it was made.
+	EnsurePathEntry(string) error
+	// CheckAdminPrivileges returns true if the user WMCO connects to the instance as is running with local
+	// administrator rights, meaning it is a member of the local Administrators group and its token is elevated
+	CheckAdminPrivileges() (bool, error)
+	// RunValidationScript runs the given PowerShell script on the instance, stopping it along with the processes it
+	// started if it does not exit within the given timeout. The output of the script is written to a log file in the
+	// WICD log directory, and its last lines are returned. An error is returned if the script exits with a non-zero
+	// exit code or is stopped.
+	RunValidationScript(string, time.Duration) (string, error)
+	// RemoveStaleTempFiles removes the files in WMCO's temporary directory on the instance which are not part of the
+	// current payload, such as scripts transferred by an earlier WMCO version. Temporary script files of RunScript are
+	// only removed once they are older than an hour, as the script may still be running.
+	RemoveStaleTempFiles() error
 }
 
 // windows implements the Windows interface
@@ -293,29 +806,167 @@ type windows struct {
 	defaultShellPowerShell bool
 	// filesToTransfer is the map of files needed for the windows VM
 	filesToTransfer map[*payload.FileInfo]string
+	// bootDiagnostics retrieves the console output of the instance from the cloud provider hosting it
+	bootDiagnostics bootdiagnostics.Provider
+	// rebootDetection configures how the instance is detected to have gone down after a reboot is requested
+	rebootDetection RebootDetection
+	// logPaths are the locations of the logs of the services on the instance
+	logPaths LogPaths
+}
+
+// LogPaths gives the locations of the logs of the services configured by WMCO, which are each kept in their own
+// directory under a common log directory
+type LogPaths struct {
+	// Root is the log directory holding the log directories of the services
+	Root string
+	// KubeletDir is the kubelet log directory
+	KubeletDir string
+	// KubeletLog is the kubelet log file
+	KubeletLog string
+	// KubeProxyDir is the kube-proxy log directory
+	KubeProxyDir string
+	// KubeProxyLog is the kube-proxy log file
+	KubeProxyLog string
+	// HybridOverlayDir is the hybrid-overlay log directory
+	HybridOverlayDir string
+	// HybridOverlayLog is the hybrid-overlay log file
+	HybridOverlayLog string
+	// ContainerdDir is the containerd log directory
+	ContainerdDir string
+	// ContainerdLog is the containerd log file
+	ContainerdLog string
+	// CSIProxyDir is the csi-proxy log directory
+	CSIProxyDir string
+	// CSIProxyLog is the csi-proxy log file
+	CSIProxyLog string
+	// WICDDir is the WICD log directory
+	WICDDir string
+}
+
+// NewLogPaths returns the locations of the logs of the services under the given log directory, which is the default
+// log directory if empty
+func NewLogPaths(root string) LogPaths {
+	if root == "" {
+		root = logDir
+	}
+	p := LogPaths{
+		Root:             root,
+		KubeletDir:       root + "\\kubelet",
+		KubeProxyDir:     root + "\\kube-proxy",
+		HybridOverlayDir: root + "\\hybrid-overlay",
+		ContainerdDir:    root + "\\containerd",
+		CSIProxyDir:      root + "\\csi-proxy",
+		WICDDir:          root + "\\wicd",
+	}
+	p.KubeletLog = p.KubeletDir + "\\kubelet.log"
+	p.KubeProxyLog = p.KubeProxyDir + "\\kube-proxy.log"
+	p.HybridOverlayLog = p.HybridOverlayDir + "\\hybrid-overlay.log"
+	p.ContainerdLog = p.ContainerdDir + "\\containerd.log"
+	p.CSIProxyLog = p.CSIProxyDir + "\\csi-proxy.log"
+	return p
+}
+
+// serviceDirs returns the log directories of the services
+func (p LogPaths) serviceDirs() []string {
+	return []string{p.KubeletDir, p.KubeProxyDir, p.HybridOverlayDir, p.ContainerdDir, p.CSIProxyDir, p.WICDDir}
+}
+
+// RebootDetection configures how an instance is detected to have gone down after a reboot is requested. Fields which
+// are 0 take their default value.
+type RebootDetection struct {
+	// Delay is how long to wait after requesting the reboot before checking whether the instance is still reachable
+	Delay time.Duration
+	// Interval is how often the instance is checked until it is no longer reachable
+	Interval time.Duration
+}
+
+// ServiceRecovery configures how the Windows service manager restarts a service after it crashes. Fields which are
+// unset take their default value.
+type ServiceRecovery struct {
+	// Delays are how long to wait before each successive restart. The last delay is used for any further restart.
+	Delays []time.Duration
+	// ResetPeriod is how long the service must run without crashing for the next restart to use the first delay again
+	ResetPeriod time.Duration
+}
+
+// withDefaults returns the ServiceRecovery with default values set for unset fields
+func (r *ServiceRecovery) withDefaults() ServiceRecovery {
+	out := ServiceRecovery{Delays: defaultWICDRecoveryDelays, ResetPeriod: defaultWICDRecoveryResetPeriod}
+	if r == nil {
+		return out
+	}
+	if len(r.Delays) > 0 {
+		out.Delays = r.Delays
+	}
+	if r.ResetPeriod > 0 {
+		out.ResetPeriod = r.ResetPeriod
+	}
+	return out
+}
+
+// withDefaults returns the RebootDetection with default values set for unset fields
+func (r *RebootDetection) withDefaults() RebootDetection {
+	out := RebootDetection{Delay: defaultRebootDelay, Interval: retry.WindowsAPIInterval}
+	if r == nil {
+		return out
+	}
+	if r.Delay > 0 {
+		out.Delay = r.Delay
+	}
+	if r.Interval > 0 {
+		out.Interval = r.Interval
+	}
+	return out
+}
+
+// Options configures how New connects to and configures an instance. The zero value uses the defaults of each option.
+type Options struct {
+	// ClusterDNS is the IP address of the DNS server used by the containers of the instance. It is only needed to
+	// configure the instance.
+	ClusterDNS string
+	// Platform is the platform the instance runs on, which selects the payload files transferred to the instance and
+	// the source of its boot diagnostics. Platform specific behavior is skipped if nil.
+	Platform *config.PlatformType
+	// RebootDetection configures how the instance is detected to have gone down after a reboot. Reboots are detected
+	// using the default values if nil.
+	RebootDetection *RebootDetection
+	// SSHAlgorithms are the algorithms used to connect to the instance. The SSH library's default algorithms are used
+	// if nil.
+	SSHAlgorithms *SSHAlgorithms
+	// HostKeys configures how the host key of the instance is verified. The host key is not verified if nil.
+	HostKeys *HostKeyVerification
+	// SFTPOptions configures how files are transferred to the instance. The default SFTP options are used if nil.
+	SFTPOptions *SFTPOptions
+	// LogDir is the directory the services of the instance log to. The default log directory is used if empty.
+	LogDir string
 }
 
-// New returns a new Windows instance constructed from the given WindowsVM
-func New(clusterDNS string, instanceInfo *instance.Info, signer ssh.Signer, platform *config.PlatformType) (Windows, error) {
+// New returns a new Windows instance for the given instance, connected to over SSH with the given signer and
+// configured by the given options
+func New(instanceInfo *instance.Info, signer ssh.Signer, opts Options) (Windows, error) {
 	log := ctrl.Log.WithName(fmt.Sprintf("wc %s", instanceInfo.Address))
 	log.V(1).Info("initializing SSH connection")
-	conn, err := newSshConnectivity(instanceInfo.Username, instanceInfo.Address, signer, log)
+	conn, err := newSshConnectivity(instanceInfo.Username, instanceInfo.Address, instanceInfo.GetSSHPort(), signer,
+		opts.SSHAlgorithms, opts.HostKeys, opts.SFTPOptions, log)
 	if err != nil {
 		return nil, fmt.Errorf("unable to setup VM %s sshConnectivity: %w", instanceInfo.Address, err)
 	}
 
-	files, err := createPayload(platform)
+	files, err := createPayload(opts.Platform)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create payload: %w", err)
 	}
 
 	return &windows{
 			interact:               conn,
-			clusterDNS:             clusterDNS,
+			clusterDNS:             opts.ClusterDNS,
 			instance:               instanceInfo,
 			log:                    log,
 			defaultShellPowerShell: defaultShellPowershell(conn),
 			filesToTransfer:        files,
+			bootDiagnostics:        bootdiagnostics.New(opts.Platform),
+			rebootDetection:        opts.RebootDetection.withDefaults(),
+			logPaths:               NewLogPaths(opts.LogDir),
 		},
 		nil
 }
@@ -339,6 +990,27 @@ func (vm *windows) GetIPv4Address() string {
 	return vm.instance.IPv4Address
 }
 
+func (vm *windows) GetBuildNumber() (int, error) {
+	out, err := vm.Run("(Get-ItemProperty 'HKLM:\\SOFTWARE\\Microsoft\\Windows NT\\CurrentVersion').CurrentBuildNumber",
+		true)
+	if err != nil {
+		return 0, fmt.Errorf("error getting Windows build number with output %s: %w", out, err)
+	}
+	build, err := strconv.Atoi(strings.TrimSpace(out))
+	if err != nil {
+		return 0, fmt.Errorf("error parsing Windows build number %q: %w", out, err)
+	}
+	return build, nil
+}
+
+func (vm *windows) GetComputerInfo() (*ComputerInfo, error) {
+	out, err := vm.Run(computerInfoCmd, true)
+	if err != nil {
+		return nil, fmt.Errorf("error getting computer info with output %s: %w", out, err)
+	}
+	return parseComputerInfo(out)
+}
+
 func (vm *windows) GetHostname() (string, error) {
 	hostName, err := vm.Run(GetHostnameFQDNCommand, true)
 	if err != nil {
@@ -382,14 +1054,19 @@ func (vm *windows) EnsureFileContent(contents []byte, filename string, remoteDir
 func (vm *windows) EnsureFile(file *payload.FileInfo, remoteDir string) error {
 	// Only copy the file to the Windows VM if it does not already exist wth the desired content
 	remotePath := remoteDir + "\\" + filepath.Base(file.Path)
-	fileExists, err := vm.FileExists(remotePath, file.SHA256)
+	found, err := vm.fileChecksum(remotePath)
 	if err != nil {
 		return fmt.Errorf("error checking if file '%s' exists on the Windows VM: %w", remotePath, err)
 	}
-	if fileExists {
+	if found == file.SHA256 {
 		// The file already exists with the expected content, do nothing
+		vm.log.V(1).Info("file already exists on VM with expected content", "file", remotePath)
 		return nil
 	}
+	if found != "" {
+		vm.log.Info("replacing file with unexpected checksum", "file", remotePath, "expected", file.SHA256,
+			"found", found)
+	}
 	f, err := os.Open(file.Path)
 	if err != nil {
 		return fmt.Errorf("error opening %s file to be transferred: %w", file.Path, err)
@@ -412,7 +1089,11 @@ func (vm *windows) EnsureFile(file *payload.FileInfo, remoteDir string) error {
 	}()
 
 	if err := vm.interact.transfer(c, f, filepath.Base(file.Path), remoteDir); err != nil {
-		return fmt.Errorf("unable to transfer %s to remote dir %s: %w", file.Path, remoteDir, err)
+		err = fmt.Errorf("unable to transfer %s to remote dir %s: %w", file.Path, remoteDir, err)
+		if found != "" {
+			return &ChecksumMismatchErr{Path: remotePath, Expected: file.SHA256, Found: found, err: err}
+		}
+		return err
 	}
 	return nil
 }
@@ -440,6 +1121,13 @@ func (vm *windows) FileExists(path, checksum string) (bool, error) {
 	return false, nil
 }
 
+func (vm *windows) EnsureDirectory(dir string) error {
+	if out, err := vm.Run(mkdirCmd(dir), false); err != nil {
+		return fmt.Errorf("unable to create remote directory %s, out: %s: %w", dir, out, err)
+	}
+	return nil
+}
+
 func (vm *windows) ReplaceDir(files map[string][]byte, remoteDir string) error {
 	vm.log.V(1).Info("overwriting", "remote destination dir", remoteDir, "number of files", len(files))
 
@@ -484,18 +1172,36 @@ func (vm *windows) Run(cmd string, psCmd bool) (string, error) {
 	return out, nil
 }
 
+// runPowerShellWithInput runs the given PowerShell command, writing the given input to its stdin. This is used to
+// hand secrets to a command, as the input is neither logged nor visible in the process list of the instance.
+func (vm *windows) runPowerShellWithInput(cmd, input string) (string, error) {
+	if !vm.defaultShellPowerShell {
+		cmd = formatRemotePowerShellCommand(cmd)
+	}
+	out, err := vm.interact.runWithInput(cmd, input)
+	if err != nil {
+		return out, fmt.Errorf("error running %s: %w", cmd, err)
+	}
+	vm.log.V(1).Info("run", "cmd", cmd, "out", out)
+	return out, nil
+}
+
 // RebootAndReinitialize restarts the Windows instance and re-initializes the SSH connection for further configuration
 func (vm *windows) RebootAndReinitialize() error {
 	vm.log.Info("rebooting instance")
 	if _, err := vm.Run("Restart-Computer -Force", true); err != nil {
 		return fmt.Errorf("error rebooting the Windows VM: %w", err)
 	}
+	// Restart-Computer returns before the instance has started shutting down, give the shutdown time to begin before
+	// checking whether the instance is still reachable
+	time.Sleep(vm.rebootDetection.Delay)
 	// Wait for instance to be unreachable via SSH, implies reboot is underway
 	if err := vm.waitUntilUnreachable(); err != nil {
 		return fmt.Errorf("instance reboot failed to start: %w", err)
 	}
 	// Wait for instance to come back online and reinitialize the SSH connection after the reboot
 	if err := vm.reinitialize(); err != nil {
+		vm.logBootDiagnostics()
 		return fmt.Errorf("error reinitializing SSH connection after VM reboot: %w", err)
 	}
 	vm.log.V(1).Info("successful reboot")
@@ -525,82 +1231,1186 @@ func (vm *windows) RemoveFilesAndNetworks() error {
 	if err := vm.EnsureHNSNetworksAreRemoved(); err != nil {
 		return fmt.Errorf("unable to ensure HNS networks are removed: %w", err)
 	}
+	return vm.RemoveFiles()
+}
+
+func (vm *windows) RemoveFiles() error {
+	if err := vm.removeScheduledTasks(); err != nil {
+		return err
+	}
+	if err := vm.removeFirewallRules(); err != nil {
+		return err
+	}
 	if err := vm.removeDirectories(); err != nil {
 		return fmt.Errorf("unable to remove created directories: %w", err)
 	}
 	return nil
 }
 
-func (vm *windows) Bootstrap(desiredVer, watchNamespace, wicdKubeconfigContents string) error {
-	vm.log.Info("configuring")
+func (vm *windows) Bootstrap(desiredVer, watchNamespace, wicdKubeconfigContents string, minFreeMemory uint64) error {
+	vm.log.Info("configuring")
+
+	// Configuration fails with opaque permission errors partway through if the user is not an administrator
+	admin, err := vm.CheckAdminPrivileges()
+	if err != nil {
+		return err
+	}
+	if !admin {
+		return fmt.Errorf("user %s lacks local administrator rights on the instance, it must be a member of the "+
+			"local Administrators group", vm.instance.Username)
+	}
+
+	// Stop any services that may be running. This prevents the node being shown as Ready after a failed configuration.
+	if err := vm.RunWICDCleanup(watchNamespace, wicdKubeconfigContents); err != nil {
+		return fmt.Errorf("unable to cleanup the Windows instance: %w", err)
+	}
+
+	if err := vm.ensureNoPendingReboot(); err != nil {
+		return err
+	}
+	if err := vm.checkRequiredPorts(); err != nil {
+		return err
+	}
+	if err := vm.ensureHostNameAndContainersFeature(minFreeMemory); err != nil {
+		return err
+	}
+	// HNS is only present once the Containers feature is enabled
+	if err := vm.checkKubeProxySupport(); err != nil {
+		return err
+	}
+	if err := vm.ensureNoPendingRenameOfTransferredFiles(); err != nil {
+		return err
+	}
+	if err := vm.createDirectories(); err != nil {
+		return fmt.Errorf("error creating directories on Windows VM: %w", err)
+	}
+	if err := vm.transferFiles(); err != nil {
+		return fmt.Errorf("error transferring files to Windows VM: %w", err)
+	}
+	if err := vm.EnsureCredentialFileACLs(); err != nil {
+		return err
+	}
+	if err := vm.ensureNodeMetricsTask(); err != nil {
+		return err
+	}
+
+	wicdBootstrapCmd := fmt.Sprintf("%s bootstrap --desired-version %s --kubeconfig %s --namespace %s",
+		wicdPath, desiredVer, wicdKubeconfigPath, watchNamespace)
+	if out, err := vm.Run(wicdBootstrapCmd, true); err != nil {
+		vm.log.Info("failed to bootstrap node", "command", wicdBootstrapCmd, "output", out)
+		return err
+	}
+	return nil
+}
+
+// ConfigureWICD starts the Windows Instance Config Daemon service
+func (vm *windows) ConfigureWICD(watchNamespace, wicdKubeconfigContents string, recovery *ServiceRecovery,
+	account string) error {
+	if err := vm.ensureWICDFilesExist(wicdKubeconfigContents); err != nil {
+		return err
+	}
+	wicdServiceArgs := fmt.Sprintf("controller --windows-service --log-dir %s --kubeconfig %s --namespace %s",
+		vm.logPaths.WICDDir, wicdKubeconfigPath, watchNamespace)
+	wicdServiceArgs = fmt.Sprintf("%s --ca-bundle %s", wicdServiceArgs, TrustedCABundlePath)
+	wicdService, err := newWICDService(wicdServiceArgs, recovery)
+	if err != nil {
+		return err
+	}
+	// The account is set before WICD is started, so that it never configures the instance as another account. The
+	// service must exist first, as the virtual account of a service only exists along with the service.
+	serviceExists, err := vm.serviceExists(WicdServiceName)
+	if err != nil {
+		return fmt.Errorf("error checking if %s Windows service exists: %w", WicdServiceName, err)
+	}
+	if !serviceExists {
+		if err := vm.createService(wicdService); err != nil {
+			return fmt.Errorf("error creating %s Windows service: %w", WicdServiceName, err)
+		}
+	}
+	changed, err := vm.ensureServiceAccount(WicdServiceName, account)
+	if err != nil {
+		return err
+	}
+	if changed {
+		if err := vm.ensureServiceNotRunning(wicdService); err != nil {
+			return fmt.Errorf("error stopping %s Windows service to change its account: %w", WicdServiceName, err)
+		}
+	}
+	if err := vm.ensureServiceIsRunning(wicdService); err != nil {
+		return fmt.Errorf("error ensuring %s Windows service has started running: %w", WicdServiceName, err)
+	}
+	vm.log.Info("configured", "service", WicdServiceName, "args", wicdServiceArgs)
+	return nil
+}
+
+func (vm *windows) SetWICDRecoveryActions(recovery *ServiceRecovery) error {
+	// the arguments are only used when the service is created
+	wicdService, err := newWICDService("", recovery)
+	if err != nil {
+		return err
+	}
+	if err := vm.setRecoveryActions(wicdService); err != nil {
+		return fmt.Errorf("error setting recovery actions for the %s Windows service: %w", WicdServiceName, err)
+	}
+	return nil
+}
+
+func (vm *windows) SetWICDServiceAccount(account string) error {
+	changed, err := vm.ensureServiceAccount(WicdServiceName, account)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+	return vm.RestartService(WicdServiceName)
+}
+
+// ensureServiceAccount ensures the service with the given name logs on as the given account, or as LocalSystem if the
+// account is empty, returning true if the account had to be changed. The change takes effect once the service is
+// restarted.
+func (vm *windows) ensureServiceAccount(serviceName, account string) (bool, error) {
+	if account == "" {
+		account = localSystemAccount
+	}
+	out, err := vm.Run(serviceQueryCmd+serviceName, false)
+	if err != nil {
+		return false, fmt.Errorf("error querying %s service config with output %s: %w", serviceName, out, err)
+	}
+	current, err := parseServiceStartName(out)
+	if err != nil {
+		return false, fmt.Errorf("error parsing %s service config: %w", serviceName, err)
+	}
+	if strings.EqualFold(current, account) {
+		return false, nil
+	}
+	if account != localSystemAccount {
+		if out, err = vm.Run(serviceAccountCheckCmd(account), true); err != nil {
+			return false, fmt.Errorf("account %s cannot be used to run the %s service: %s: %w", account, serviceName,
+				strings.TrimSpace(out), err)
+		}
+	}
+	// accounts without a password, such as virtual and group managed service accounts, are given no password
+	if out, err = vm.Run(fmt.Sprintf("sc.exe config %s obj= \"%s\"", serviceName, account), false); err != nil {
+		return false, fmt.Errorf("error setting account of %s service to %s with output %s: %w", serviceName,
+			account, out, err)
+	}
+	vm.log.Info("changed service account", "service", serviceName, "account", account, "previousAccount", current)
+	return true, nil
+}
+
+// serviceAccountCheckCmd returns the PowerShell command which fails if the given account does not exist, or is not a
+// direct member of the local Administrators group
+func serviceAccountCheckCmd(account string) string {
+	return "$ErrorActionPreference = 'Stop'; " +
+		"try { $sid = (New-Object System.Security.Principal.NTAccount('" + account + "'))." +
+		"Translate([System.Security.Principal.SecurityIdentifier]).Value } " +
+		"catch { throw 'account does not exist' }; " +
+		"if (-not (Get-LocalGroupMember -SID '" + AdministratorsSID + "' | " +
+		"Where-Object { $_.SID.Value -eq $sid })) { throw 'account is not a member of the local Administrators group' }"
+}
+
+// parseServiceStartName returns the value of the SERVICE_START_NAME field, the account the service logs on as, from
+// the given `sc.exe qc` output
+func parseServiceStartName(scOutput string) (string, error) {
+	for _, line := range strings.Split(scOutput, "\n") {
+		field, value, found := strings.Cut(strings.TrimSpace(line), ":")
+		if found && strings.TrimSpace(field) == serviceStartNameField {
+			return strings.TrimSpace(value), nil
+		}
+	}
+	return "", fmt.Errorf("%s not found", serviceStartNameField)
+}
+
+// newWICDService returns the WICD service object with the given arguments, which the Windows service manager restarts
+// after crashes as given by the ServiceRecovery
+func newWICDService(args string, recovery *ServiceRecovery) (*service, error) {
+	r := recovery.withDefaults()
+	recoveryActions := make([]recoveryAction, 0, len(r.Delays))
+	for _, delay := range r.Delays {
+		recoveryActions = append(recoveryActions, recoveryAction{actionType: serviceRestart, delay: delay})
+	}
+	wicdService, err := newService(wicdPath, WicdServiceName, args, nil, recoveryActions, r.ResetPeriod)
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s service object: %w", WicdServiceName, err)
+	}
+	return wicdService, nil
+}
+
+func (vm *windows) SetTimezone(tz string) error {
+	out, err := vm.Run("(Get-TimeZone).Id", true)
+	if err != nil {
+		return fmt.Errorf("error getting current timezone with output %s: %w", out, err)
+	}
+	if strings.TrimSpace(out) == tz {
+		return nil
+	}
+	out, err = vm.Run("Get-TimeZone -ListAvailable | Where-Object { $_.Id -eq '"+tz+"' } | "+
+		"Select-Object -ExpandProperty Id", true)
+	if err != nil {
+		return fmt.Errorf("error listing available timezones with output %s: %w", out, err)
+	}
+	if strings.TrimSpace(out) != tz {
+		return fmt.Errorf("timezone %s is not available on the instance", tz)
+	}
+	out, err = vm.Run("Set-TimeZone -Id '"+tz+"'", true)
+	if err != nil {
+		if isPermissionError(out) {
+			return fmt.Errorf("user %s lacks the privileges required to set the timezone: %w",
+				vm.instance.Username, err)
+		}
+		return fmt.Errorf("error setting timezone to %s with output %s: %w", tz, out, err)
+	}
+	vm.log.Info("set timezone", "timezone", tz)
+	return nil
+}
+
+func (vm *windows) GetVSphereGuestIP() (string, error) {
+	out, err := vm.Run(vSphereGuestIPCommand, true)
+	if err != nil {
+		return "", fmt.Errorf("error getting the VMware Tools reported IP, with output %s: %w", out, err)
+	}
+	ip := strings.TrimSpace(out)
+	if net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("VMware Tools reported invalid IP %q", ip)
+	}
+	return ip, nil
+}
+
+func (vm *windows) GetFreeMemory() (uint64, error) {
+	// FreePhysicalMemory is given in kilobytes
+	out, err := vm.Run("(Get-CimInstance Win32_OperatingSystem).FreePhysicalMemory", true)
+	if err != nil {
+		return 0, fmt.Errorf("error getting free memory with output %s: %w", out, err)
+	}
+	freeKB, err := strconv.ParseUint(strings.TrimSpace(out), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse free memory %q: %w", out, err)
+	}
+	return freeKB * 1024, nil
+}
+
+func (vm *windows) GetInterfaceMTU(alias string) (int, error) {
+	out, err := vm.Run("(Get-NetIPInterface -InterfaceAlias '"+alias+"' -AddressFamily IPv4).NlMtu", true)
+	if err != nil {
+		return 0, fmt.Errorf("error getting MTU of interface %s with output %s: %w", alias, out, err)
+	}
+	mtu, err := strconv.Atoi(strings.TrimSpace(out))
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse MTU %q of interface %s: %w", out, alias, err)
+	}
+	return mtu, nil
+}
+
+func (vm *windows) GetHNSNetworkInterfaceAlias(network string) (string, error) {
+	out, err := vm.Run(hnsNetworkInterfaceAliasCmd(network), true)
+	if err != nil {
+		return "", fmt.Errorf("error getting interface of HNS network %s with output %s: %w", network, out, err)
+	}
+	alias := strings.TrimSpace(out)
+	if alias == "" {
+		return "", fmt.Errorf("no interface found for HNS network %s", network)
+	}
+	return alias, nil
+}
+
+func (vm *windows) GetNetworkAdapters() ([]NetworkAdapter, error) {
+	out, err := vm.Run(networkAdaptersCmd, true)
+	if err != nil {
+		return nil, fmt.Errorf("error getting network adapters with output %s: %w", out, err)
+	}
+	return parseNetworkAdapters(out)
+}
+
+func (vm *windows) GetFileACL(path string) (*FileACL, error) {
+	out, err := vm.Run(fileACLCmd(path), true)
+	if err != nil {
+		return nil, fmt.Errorf("error getting ACL of %s with output %s: %w", path, out, err)
+	}
+	return parseFileACL(out)
+}
+
+func (vm *windows) EnsureFileACL(path, owner string, allowedSIDs []string) error {
+	if err := validateFileACL(owner, allowedSIDs); err != nil {
+		return fmt.Errorf("invalid ACL for %s: %w", path, err)
+	}
+	acl, err := vm.GetFileACL(path)
+	if err != nil {
+		return err
+	}
+	if acl.matches(owner, allowedSIDs) {
+		return nil
+	}
+	// Inherited rules are removed along with inheritance, while explicit rules for other SIDs, and rules denying access
+	// to the allowed SIDs, must be removed individually. Grants to the allowed SIDs are replaced by full control.
+	cmds := []string{fmt.Sprintf("icacls '%s' /inheritance:r /grant:r %s", path, icaclsGrants(allowedSIDs))}
+	for _, sid := range acl.unexpectedSIDs(allowedSIDs) {
+		cmds = append(cmds, fmt.Sprintf("icacls '%s' /remove *%s", path, sid))
+	}
+	for _, rule := range acl.Rules {
+		if !rule.Allow && slices.Contains(allowedSIDs, rule.SID) {
+			cmds = append(cmds, fmt.Sprintf("icacls '%s' /remove:d *%s", path, rule.SID))
+		}
+	}
+	cmds = append(cmds, fmt.Sprintf("icacls '%s' /setowner *%s", path, owner))
+	for _, cmd := range cmds {
+		if out, err := vm.Run(cmd, true); err != nil {
+			return fmt.Errorf("error setting ACL of %s with output %s: %w", path, out, err)
+		}
+	}
+	if acl, err = vm.GetFileACL(path); err != nil {
+		return err
+	}
+	if !acl.matches(owner, allowedSIDs) {
+		return fmt.Errorf("ACL of %s does not match the expected ACL after being set: %+v", path, *acl)
+	}
+	vm.log.Info("restricted file access", "path", path, "owner", owner, "allowed", allowedSIDs)
+	return nil
+}
+
+func (vm *windows) EnsureCredentialFileACLs() error {
+	for _, path := range credentialFiles {
+		out, err := vm.Run(resolveFileCmd(path), true)
+		if err != nil {
+			return fmt.Errorf("error resolving %s with output %s: %w", path, out, err)
+		}
+		target := strings.TrimSpace(out)
+		if target == "" {
+			continue
+		}
+		if err = vm.EnsureFileACL(target, AdministratorsSID, CredentialFileSIDs); err != nil {
+			return fmt.Errorf("unable to restrict access to credential file: %w", err)
+		}
+	}
+	return nil
+}
+
+func (vm *windows) SetPagefile(sizeMB int) (bool, error) {
+	// Automatic management of the pagefile must be disabled for the Win32_PageFileSetting values to be used. The
+	// maximum size is only ever increased, so that an existing larger pagefile is not shrunk.
+	cmd := fmt.Sprintf("$size = %d; $cs = Get-CimInstance Win32_ComputerSystem; "+
+		"$pf = Get-CimInstance Win32_PageFileSetting | Select-Object -First 1; "+
+		"if (!$cs.AutomaticManagedPagefile -and $pf -and $pf.InitialSize -ge $size -and $pf.MaximumSize -ge $size) "+
+		"{ '%s'; exit }; "+
+		"if ($cs.AutomaticManagedPagefile) "+
+		"{ Set-CimInstance -InputObject $cs -Property @{AutomaticManagedPagefile=$false} }; "+
+		"$pf = Get-CimInstance Win32_PageFileSetting | Select-Object -First 1; "+
+		"if (!$pf) { $pf = New-CimInstance -ClassName Win32_PageFileSetting -Property @{Name='C:\\pagefile.sys'} }; "+
+		"Set-CimInstance -InputObject $pf -Property @{InitialSize=[uint32]$size; "+
+		"MaximumSize=[uint32][Math]::Max($size, $pf.MaximumSize)}", sizeMB, pagefileUnchanged)
+	out, err := vm.Run(cmd, true)
+	if err != nil {
+		if isPermissionError(out) {
+			return false, fmt.Errorf("user %s lacks the privileges required to set the pagefile: %w",
+				vm.instance.Username, err)
+		}
+		return false, fmt.Errorf("error setting pagefile size to %dMB with output %s: %w", sizeMB, out, err)
+	}
+	if strings.TrimSpace(out) == pagefileUnchanged {
+		return false, nil
+	}
+	vm.log.Info("set pagefile size, reboot required", "sizeMB", sizeMB)
+	return true, nil
+}
+
+func (vm *windows) SetCrashDumpPolicy(enabled bool, dumpType string) (bool, error) {
+	cmd, err := crashDumpPolicyCmd(enabled, dumpType)
+	if err != nil {
+		return false, err
+	}
+	out, err := vm.Run(cmd, true)
+	if err != nil {
+		if isPermissionError(out) {
+			return false, fmt.Errorf("user %s lacks the privileges required to set the crash dump policy: %w",
+				vm.instance.Username, err)
+		}
+		return false, fmt.Errorf("error setting crash dump policy with output %s: %w", out, err)
+	}
+	if strings.TrimSpace(out) == crashDumpPolicyUnchanged {
+		return false, nil
+	}
+	vm.log.Info("set crash dump policy, reboot required", "enabled", enabled, "type", dumpType)
+	return true, nil
+}
+
+// crashDumpPolicyCmd returns the PowerShell command which sets the CrashControl registry values for the given crash
+// dump policy, outputting crashDumpPolicyUnchanged if they already have the expected values. The values are created
+// as DWORDs, as the AlwaysKeepMemoryDump value is not present by default.
+func crashDumpPolicyCmd(enabled bool, dumpType string) (string, error) {
+	// the values of CrashDumpEnabled for each dump type, 0 disabling crash dumps
+	crashDumpEnabledValues := map[string]int{"Complete": 1, "Kernel": 2, "Small": 3, "Automatic": 7}
+	values := "CrashDumpEnabled = 0"
+	if enabled {
+		crashDumpEnabled, ok := crashDumpEnabledValues[dumpType]
+		if !ok {
+			return "", fmt.Errorf("invalid crash dump type %q, must be one of Small, Kernel, Automatic or Complete",
+				dumpType)
+		}
+		// dumps are otherwise deleted when the instance is low on disk space
+		values = fmt.Sprintf("CrashDumpEnabled = %d; AlwaysKeepMemoryDump = 1", crashDumpEnabled)
+	}
+	return "$k = '" + crashControlKey + "'; $p = Get-ItemProperty -Path $k; $changed = $false; " +
+		"$want = [ordered]@{" + values + "}; " +
+		"foreach ($n in $want.Keys) { if ($p.$n -ne $want[$n]) { " +
+		"New-ItemProperty -Path $k -Name $n -Value $want[$n] -PropertyType DWord -Force | Out-Null; " +
+		"$changed = $true } }; " +
+		"if (-not $changed) { '" + crashDumpPolicyUnchanged + "' }", nil
+}
+
+func (vm *windows) EnsureSChannelProtocols(disabled []string) (bool, error) {
+	cmd, err := disableSChannelProtocolsCmd(disabled)
+	if err != nil {
+		return false, err
+	}
+	out, err := vm.Run(cmd, true)
+	if err != nil {
+		if isPermissionError(out) {
+			return false, fmt.Errorf("user %s lacks the privileges required to disable SChannel protocols: %w",
+				vm.instance.Username, err)
+		}
+		return false, fmt.Errorf("error disabling SChannel protocols with output %s: %w", out, err)
+	}
+	if strings.TrimSpace(out) == schannelProtocolsUnchanged {
+		return false, nil
+	}
+	vm.log.Info("disabled SChannel protocols, reboot required", "protocols", disabled)
+	return true, nil
+}
+
+// disableSChannelProtocolsCmd returns the PowerShell command which sets the Enabled and DisabledByDefault registry
+// values of the client and server subkeys of the given SChannel protocols so that they are disabled, outputting
+// schannelProtocolsUnchanged if they already are. The subkeys are not present by default, and are created if needed.
+func disableSChannelProtocolsCmd(protocols []string) (string, error) {
+	// the names of the registry subkeys of the protocols which can be disabled
+	protocolKeys := map[string]string{"SSL2.0": "SSL 2.0", "SSL3.0": "SSL 3.0", "TLS1.0": "TLS 1.0", "TLS1.1": "TLS 1.1"}
+	if len(protocols) == 0 {
+		return "", fmt.Errorf("no SChannel protocols given")
+	}
+	keys := make([]string, 0, len(protocols))
+	for _, protocol := range protocols {
+		key, ok := protocolKeys[protocol]
+		if !ok {
+			return "", fmt.Errorf("invalid SChannel protocol %q, must be one of SSL2.0, SSL3.0, TLS1.0 or TLS1.1",
+				protocol)
+		}
+		keys = append(keys, "'"+key+"'")
+	}
+	return "$changed = $false; foreach ($p in @(" + strings.Join(keys, ", ") + ")) { " +
+		"foreach ($r in @('Client', 'Server')) { $k = '" + schannelProtocolsKey + "\\' + $p + '\\' + $r; " +
+		"if (-not (Test-Path -Path $k)) { New-Item -Path $k -Force | Out-Null }; $v = Get-ItemProperty -Path $k; " +
+		"if ($v.Enabled -ne 0 -or $v.DisabledByDefault -ne 1) { " +
+		"New-ItemProperty -Path $k -Name Enabled -Value 0 -PropertyType DWord -Force | Out-Null; " +
+		"New-ItemProperty -Path $k -Name DisabledByDefault -Value 1 -PropertyType DWord -Force | Out-Null; " +
+		"$changed = $true } } }; " +
+		"if (-not $changed) { '" + schannelProtocolsUnchanged + "' }", nil
+}
+
+func (vm *windows) TestRegistryConnectivity(registries []string) (map[string]error, error) {
+	results := make(map[string]error, len(registries))
+	for _, registry := range registries {
+		proxy, err := registryProxy(registry)
+		if err != nil {
+			results[registry] = err
+			continue
+		}
+		// The proxy credentials, if any, are given through stdin so they do not show up in the command
+		input := ""
+		if proxy != nil && proxy.User != nil {
+			password, _ := proxy.User.Password()
+			input = proxy.User.Username() + "\n" + password + "\n"
+		}
+		out, err := vm.runPowerShellWithInput(registryProbeCmd(registry, proxy), input)
+		if err != nil {
+			return results, fmt.Errorf("error checking connectivity to registry %s with output %s: %w", registry, out,
+				err)
+		}
+		if out = strings.TrimSpace(out); out != registryReachable {
+			results[registry] = fmt.Errorf("unable to reach registry %s (proxy %q): %s", registry, proxy.Redacted(),
+				out)
+			continue
+		}
+		results[registry] = nil
+	}
+	return results, nil
+}
+
+func (vm *windows) TestConnectivity(host string, port int) (*ConnectivityResult, error) {
+	if !connectivityHostRegex.MatchString(host) {
+		return nil, fmt.Errorf("invalid host %q", host)
+	}
+	if port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port %d", port)
+	}
+	out, err := vm.Run(connectivityTestCmd(host, port), true)
+	if err != nil {
+		return nil, fmt.Errorf("error checking connectivity to %s with output %s: %w",
+			net.JoinHostPort(host, strconv.Itoa(port)), out, err)
+	}
+	return parseConnectivityResult(out)
+}
+
+func (vm *windows) SetNTPServers(servers []string) error {
+	if err := vm.ensureTimeServiceRunning(); err != nil {
+		return err
+	}
+	peers := strings.Join(servers, " ")
+	out, err := vm.Run("$p = Get-ItemProperty -Path "+w32timeParametersKey+"; "+
+		"if ($p.Type -eq 'NTP' -and $p.NtpServer -eq '"+peers+"') { '"+ntpServersUnchanged+"' }", true)
+	if err != nil {
+		return fmt.Errorf("error getting current NTP servers with output %s: %w", out, err)
+	}
+	if strings.TrimSpace(out) == ntpServersUnchanged {
+		return nil
+	}
+	out, err = vm.Run("w32tm /config /manualpeerlist:'"+peers+"' /syncfromflags:manual /update", true)
+	if err != nil {
+		if isPermissionError(out) {
+			return fmt.Errorf("user %s lacks the privileges required to set the NTP servers: %w",
+				vm.instance.Username, err)
+		}
+		return fmt.Errorf("error setting NTP servers to %s with output %s: %w", peers, out, err)
+	}
+	vm.log.Info("set NTP servers", "servers", servers)
+	// The servers may not be reachable yet, the time service keeps retrying on its own so this is not an error
+	if out, err = vm.Run("w32tm /resync", true); err != nil {
+		vm.log.Info("WARNING: unable to resync time with the NTP servers", "output", out)
+	}
+	return nil
+}
+
+func (vm *windows) UpdateWICDKubeconfig(contents string) error {
+	upToDate, err := vm.FileExists(wicdKubeconfigPath, fmt.Sprintf("%x", sha256.Sum256([]byte(contents))))
+	if err != nil {
+		return fmt.Errorf("error checking %s: %w", wicdKubeconfigPath, err)
+	}
+	if upToDate {
+		return nil
+	}
+	if err = vm.ensureWICDKubeconfig(contents); err != nil {
+		return err
+	}
+	if err = vm.EnsureFileACL(wicdKubeconfigPath, AdministratorsSID, CredentialFileSIDs); err != nil {
+		return err
+	}
+	return vm.RestartService(WicdServiceName)
+}
+
+func (vm *windows) EnsureHNSEndpointPolicies(contents string) error {
+	upToDate, err := vm.FileExists(HNSEndpointPoliciesPath, fmt.Sprintf("%x", sha256.Sum256([]byte(contents))))
+	if err != nil {
+		return fmt.Errorf("error checking %s: %w", HNSEndpointPoliciesPath, err)
+	}
+	if upToDate {
+		return nil
+	}
+	dir, fileName := SplitPath(HNSEndpointPoliciesPath)
+	if err = vm.EnsureFileContent([]byte(contents), fileName, dir); err != nil {
+		return err
+	}
+	vm.log.Info("updated HNS endpoint policies")
+	return nil
+}
+
+func (vm *windows) GetCNIConfig() (string, error) {
+	out, err := vm.Run("Get-Content -Raw -Path '"+CNIConfigPath+"'", true)
+	if err != nil {
+		return "", fmt.Errorf("error reading %s with output %s: %w", CNIConfigPath, out, err)
+	}
+	return out, nil
+}
+
+func (vm *windows) GetContainerdConfig() (string, error) {
+	out, err := vm.Run("if (Test-Path -Path '"+ContainerdConfPath+"') { Get-Content -Raw -Path '"+
+		ContainerdConfPath+"' }", true)
+	if err != nil {
+		return "", fmt.Errorf("error reading %s with output %s: %w", ContainerdConfPath, out, err)
+	}
+	return out, nil
+}
+
+func (vm *windows) RepairCNIConfig() error {
+	script, err := payload.NewFileInfo(payload.NetworkConfigurationScript)
+	if err != nil {
+		return fmt.Errorf("error getting info on %s: %w", payload.NetworkConfigurationScript, err)
+	}
+	if err = vm.EnsureFile(script, remoteDir); err != nil {
+		return fmt.Errorf("error transferring network configuration script: %w", err)
+	}
+	// WICD runs the script, which rewrites the CNI config if it differs, each time it reconciles kube-proxy
+	return vm.RestartService(WicdServiceName)
+}
+
+func (vm *windows) ValidateCNIConsistency() error {
+	contents, err := vm.GetCNIConfig()
+	if err != nil {
+		return err
+	}
+	referenced, err := cniConfigPlugins(contents)
+	if err != nil {
+		return err
+	}
+	expected := make(map[string]string)
+	for file, dest := range vm.filesToTransfer {
+		if dest == cniDir {
+			expected[strings.TrimSuffix(filepath.Base(file.Path), ".exe")] = file.SHA256
+		}
+	}
+	found := make(map[string]string)
+	for _, plugin := range referenced {
+		if _, ok := expected[plugin]; !ok {
+			continue
+		}
+		if found[plugin], err = vm.fileChecksum(cniDir + "\\" + plugin + ".exe"); err != nil {
+			return fmt.Errorf("error checking CNI plugin %s: %w", plugin, err)
+		}
+	}
+	if problems := cniPluginProblems(referenced, expected, found); len(problems) > 0 {
+		return fmt.Errorf("CNI config %s is inconsistent with the CNI plugins in %s: %s", CNIConfigPath, cniDir,
+			strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// cniPluginConfig is the part of a CNI network config, or of a plugin of a CNI network config list, which references
+// CNI plugins
+type cniPluginConfig struct {
+	Type string `json:"type"`
+	IPAM *struct {
+		Type string `json:"type"`
+	} `json:"ipam"`
+}
+
+// cniConfigPlugins returns the names of the CNI plugins referenced by the given CNI network config or network config
+// list, including IPAM plugins, in the order they are referenced
+func cniConfigPlugins(contents string) ([]string, error) {
+	var config struct {
+		cniPluginConfig
+		Plugins []cniPluginConfig `json:"plugins"`
+	}
+	if err := json.Unmarshal([]byte(contents), &config); err != nil {
+		return nil, fmt.Errorf("unable to parse CNI config %s: %w", CNIConfigPath, err)
+	}
+	var plugins []string
+	for _, plugin := range append([]cniPluginConfig{config.cniPluginConfig}, config.Plugins...) {
+		names := []string{plugin.Type}
+		if plugin.IPAM != nil {
+			names = append(names, plugin.IPAM.Type)
+		}
+		for _, name := range names {
+			if name != "" && !slices.Contains(plugins, name) {
+				plugins = append(plugins, name)
+			}
+		}
+	}
+	if len(plugins) == 0 {
+		return nil, fmt.Errorf("CNI config %s references no CNI plugins", CNIConfigPath)
+	}
+	return plugins, nil
+}
+
+// cniPluginProblems describes each of the referenced CNI plugins which is not provided by WMCO, or whose binary on
+// the instance is missing or has a different checksum than expected. The expected and found checksums are keyed by
+// plugin name, with an empty found checksum meaning the binary is missing.
+func cniPluginProblems(referenced []string, expected, found map[string]string) []string {
+	var problems []string
+	for _, plugin := range referenced {
+		want, ok := expected[plugin]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("plugin %s is not provided by WMCO", plugin))
+		case found[plugin] == "":
+			problems = append(problems, fmt.Sprintf("plugin %s is missing", plugin))
+		case found[plugin] != want:
+			problems = append(problems, fmt.Sprintf("plugin %s has SHA256 checksum %s instead of the expected %s",
+				plugin, found[plugin], want))
+		}
+	}
+	return problems
+}
+
+func (vm *windows) ValidateContainerdCNIConfig() error {
+	binPath, err := vm.getServiceBinaryPath(ContainerdServiceName)
+	if err != nil {
+		return err
+	}
+	// the config is dumped by the binary the service runs, with the config file it is given, so that the imports
+	// and defaults which apply to the running containerd are taken into account
+	cmd, err := containerdConfigDumpCmd(binPath)
+	if err != nil {
+		return err
+	}
+	out, err := vm.Run(cmd, true)
+	if err != nil {
+		return fmt.Errorf("error dumping %s config with output %s: %w", ContainerdServiceName, out, err)
+	}
+	binDir, confDir, err := containerdCNIDirs(out)
+	if err != nil {
+		return err
+	}
+	var problems []string
+	if !sameWindowsPath(confDir, CniConfDir) {
+		problems = append(problems, fmt.Sprintf("conf_dir is %q instead of %q", confDir, CniConfDir))
+	}
+	if !sameWindowsPath(binDir, cniDir) {
+		problems = append(problems, fmt.Sprintf("bin_dir is %q instead of %q", binDir, cniDir))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s is not using the CNI directories managed by WMCO, pod networking will fail: %s",
+			ContainerdServiceName, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// containerdConfigDumpCmd returns the PowerShell command which outputs the effective config of the containerd run by
+// the given service command, which must give the containerd binary followed by its arguments
+func containerdConfigDumpCmd(serviceCmd string) (string, error) {
+	args := splitCommand(serviceCmd)
+	if len(args) == 0 {
+		return "", fmt.Errorf("%s service has no command", ContainerdServiceName)
+	}
+	cmd := "& '" + args[0] + "'"
+	for i, arg := range args {
+		name, value, hasValue := strings.Cut(arg, "=")
+		if name != "--config" && name != "-c" {
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("%s service command gives %s without a value", ContainerdServiceName, name)
+			}
+			value = args[i+1]
+		}
+		cmd += " --config '" + value + "'"
+		break
+	}
+	return cmd + " config dump", nil
+}
+
+// containerdCNIDirs returns the CNI plugin and config directories given by the CNI table of the CRI plugin in the
+// given TOML containerd config, as output by `containerd config dump`
+func containerdCNIDirs(config string) (string, string, error) {
+	var binDir, confDir string
+	inCNITable := false
+	for _, line := range strings.Split(config, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			// the CRI plugin is io.containerd.grpc.v1.cri in version 2 configs and io.containerd.cri.v1.runtime in
+			// version 3 configs
+			inCNITable = strings.HasPrefix(line, "[plugins.\"io.containerd.") && strings.HasSuffix(line, ".cni]")
+			continue
+		}
+		if !inCNITable {
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		var target *string
+		switch strings.TrimSpace(key) {
+		case "bin_dir":
+			target = &binDir
+		case "conf_dir":
+			target = &confDir
+		default:
+			continue
+		}
+		parsed, err := tomlString(strings.TrimSpace(value))
+		if err != nil {
+			return "", "", fmt.Errorf("invalid containerd CNI option %s: %w", strings.TrimSpace(key), err)
+		}
+		*target = parsed
+	}
+	if binDir == "" || confDir == "" {
+		return "", "", fmt.Errorf("containerd config does not give both the CNI bin_dir and conf_dir")
+	}
+	return binDir, confDir, nil
+}
+
+// tomlString returns the value of the given TOML basic or literal string
+func tomlString(value string) (string, error) {
+	if len(value) >= 2 && strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'") {
+		return value[1 : len(value)-1], nil
+	}
+	// the escapes of TOML basic strings used in paths are the same as those of Go string literals
+	unquoted, err := strconv.Unquote(value)
+	if err != nil || !strings.HasPrefix(value, "\"") {
+		return "", fmt.Errorf("%s is not a TOML string", value)
+	}
+	return unquoted, nil
+}
+
+// sameWindowsPath returns true if the given Windows paths are the same, ignoring case and trailing separators
+func sameWindowsPath(a, b string) bool {
+	return strings.EqualFold(strings.TrimRight(a, "\\/"), strings.TrimRight(b, "\\/"))
+}
+
+func (vm *windows) GetWICDKubeconfigServer() (string, error) {
+	// The server is read on the instance so that the credentials in the kubeconfig are not sent back
+	out, err := vm.Run("(Get-Content -Raw -Path '"+wicdKubeconfigPath+"' | ConvertFrom-Json).clusters[0].cluster.server",
+		true)
+	if err != nil {
+		return "", fmt.Errorf("error reading server from %s with output %s: %w", wicdKubeconfigPath, out, err)
+	}
+	server := strings.TrimSpace(out)
+	if server == "" {
+		return "", fmt.Errorf("%s does not give a server", wicdKubeconfigPath)
+	}
+	return server, nil
+}
+
+func (vm *windows) RestartService(name string) error {
+	out, err := vm.Run(runningDependentServicesCmd(name), true)
+	if err != nil {
+		return fmt.Errorf("error getting running services depending on %s with output: %s: %w", name, out, err)
+	}
+	dependents := orderDependentServices(parseServiceNames(out))
+	for _, dependent := range dependents {
+		if err = vm.ensureServiceNotRunning(&service{name: dependent}); err != nil {
+			return fmt.Errorf("error stopping %s service, which depends on %s: %w", dependent, name, err)
+		}
+	}
+	svc := &service{name: name}
+	if err = vm.ensureServiceNotRunning(svc); err != nil {
+		return fmt.Errorf("error stopping %s service: %w", name, err)
+	}
+	if err = vm.startService(svc); err != nil {
+		return err
+	}
+	// dependents are ordered so that each is stopped before the services it depends on, so start them in reverse
+	for i := len(dependents) - 1; i >= 0; i-- {
+		if err = vm.startService(&service{name: dependents[i]}); err != nil {
+			return fmt.Errorf("error starting %s service after restarting %s: %w", dependents[i], name, err)
+		}
+	}
+	vm.log.Info("restarted", "service", name, "dependents", dependents)
+	return nil
+}
+
+// runningDependentServicesCmd returns the PowerShell command which outputs the names of the running services which
+// depend on the given service, directly or indirectly, one per line
+func runningDependentServicesCmd(name string) string {
+	return "(Get-Service -Name '" + name + "' -ErrorAction Stop).DependentServices | " +
+		"Where-Object { $_.Status -eq 'Running' } | ForEach-Object { $_.Name }"
+}
+
+// parseServiceNames returns the service names given one per line in the given output
+func parseServiceNames(out string) []string {
+	var names []string
+	for _, line := range strings.Split(out, "\n") {
+		if name := strings.TrimSpace(line); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// orderDependentServices returns the given services in the order they must be stopped in, as given by
+// RequiredServices. Services not installed by WMCO may depend on any of them, so they are stopped first, in the order
+// given.
+func orderDependentServices(services []string) []string {
+	rank := func(name string) int {
+		for i, required := range RequiredServices {
+			if strings.EqualFold(name, required) {
+				return i
+			}
+		}
+		return -1
+	}
+	ordered := slices.Clone(services)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return rank(ordered[i]) < rank(ordered[j])
+	})
+	return ordered
+}
+
+func (vm *windows) RenewKubeletServingCert(certDir string) error {
+	out, err := vm.Run("Remove-Item -Path '"+certDir+"\\"+kubeletServingCertFiles+"' -Force", true)
+	if err != nil {
+		return fmt.Errorf("error removing kubelet serving certificate with output %s: %w", out, err)
+	}
+	vm.log.Info("removed kubelet serving certificate")
+	return vm.RestartService(KubeletServiceName)
+}
+
+func (vm *windows) GetServiceLastExitCode(name string) (int, error) {
+	if !slices.Contains(RequiredServices, name) {
+		return 0, fmt.Errorf("%s is not a WMCO-managed service", name)
+	}
+	out, err := vm.Run(serviceStateQueryCmd+name, false)
+	if err != nil {
+		return 0, fmt.Errorf("error querying %s service state with output %s: %w", name, out, err)
+	}
+	code, err := parseServiceExitCode(out)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing %s service state: %w", name, err)
+	}
+	return code, nil
+}
+
+func (vm *windows) GetContainerdState() error {
+	running, err := vm.isRunning(ContainerdServiceName)
+	if err != nil {
+		return fmt.Errorf("error querying %s service: %w", ContainerdServiceName, err)
+	}
+	if !running {
+		return fmt.Errorf("%s service is not running", ContainerdServiceName)
+	}
+	out, err := vm.Run(containerdStateCmd(), true)
+	if err != nil {
+		return fmt.Errorf("error checking %s CRI endpoint with output %s: %w", ContainerdServiceName, out, err)
+	}
+	return parseContainerdState(out)
+}
+
+func (vm *windows) AddLogValues(keysAndValues ...interface{}) {
+	vm.log = vm.log.WithValues(keysAndValues...)
+	vm.interact.addLogValues(keysAndValues...)
+}
+
+func (vm *windows) SetPowerPlan(guid string) error {
+	out, err := vm.Run("powercfg /getactivescheme", false)
+	if err != nil {
+		return fmt.Errorf("error getting active power plan with output %s: %w", out, err)
+	}
+	if strings.Contains(strings.ToLower(out), guid) {
+		return nil
+	}
+	out, err = vm.Run("powercfg /setactive "+guid, false)
+	if err != nil {
+		if isPermissionError(out) {
+			return fmt.Errorf("user %s lacks the privileges required to set the power plan: %w",
+				vm.instance.Username, err)
+		}
+		return fmt.Errorf("error setting power plan to %s with output %s: %w", guid, out, err)
+	}
+	vm.log.Info("set power plan", "guid", guid)
+	return nil
+}
+
+func (vm *windows) SetDynamicPortRange(start, num int) error {
+	for _, protocol := range []string{"tcp", "udp"} {
+		out, err := vm.Run("netsh int ipv4 show dynamicport "+protocol, false)
+		if err != nil {
+			return fmt.Errorf("error getting %s dynamic port range with output %s: %w", protocol, out, err)
+		}
+		currentStart, currentNum, err := parseDynamicPortRange(out)
+		if err != nil {
+			return fmt.Errorf("unable to parse %s dynamic port range: %w", protocol, err)
+		}
+		if currentStart == start && currentNum == num {
+			continue
+		}
+		out, err = vm.Run(fmt.Sprintf("netsh int ipv4 set dynamicport %s start=%d num=%d", protocol, start, num),
+			false)
+		if err != nil {
+			if isPermissionError(out) {
+				return fmt.Errorf("user %s lacks the privileges required to set the dynamic port range: %w",
+					vm.instance.Username, err)
+			}
+			return fmt.Errorf("error setting %s dynamic port range with output %s: %w", protocol, out, err)
+		}
+		vm.log.Info("set dynamic port range", "protocol", protocol, "start", start, "num", num,
+			"previousStart", currentStart, "previousNum", currentNum)
+	}
+	return nil
+}
+
+func (vm *windows) GetRegistryValue(key, name string) (string, error) {
+	out, err := vm.Run("$v = (Get-Item -Path '"+key+"' -ErrorAction Stop).GetValue('"+name+"', $null, "+
+		"'DoNotExpandEnvironmentNames'); if ($v -eq $null) { throw 'registry value not found' }; "+
+		"[Convert]::ToBase64String([Text.Encoding]::UTF8.GetBytes($v))", true)
+	if err != nil {
+		return "", fmt.Errorf("error getting registry value %s of %s with output %s: %w", name, key, out, err)
+	}
+	value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(out))
+	if err != nil {
+		return "", fmt.Errorf("unable to decode registry value %s of %s: %w", name, key, err)
+	}
+	return string(value), nil
+}
+
+func (vm *windows) EnsureRegistryValue(key, name, value string) (bool, error) {
+	current, err := vm.GetRegistryValue(key, name)
+	if err == nil && current == value {
+		return false, nil
+	}
+	// The value is passed encoded, as registry values may contain characters which would be interpreted by the shell,
+	// such as the environment variable references of REG_EXPAND_SZ values
+	out, err := vm.Run("$v = [Text.Encoding]::UTF8.GetString([Convert]::FromBase64String('"+
+		base64.StdEncoding.EncodeToString([]byte(value))+"')); "+
+		"Set-ItemProperty -Path '"+key+"' -Name '"+name+"' -Value $v", true)
+	if err != nil {
+		if isPermissionError(out) {
+			return false, fmt.Errorf("user %s lacks the privileges required to set registry value %s of %s: %w",
+				vm.instance.Username, name, key, err)
+		}
+		return false, fmt.Errorf("error setting registry value %s of %s with output %s: %w", name, key, out, err)
+	}
+	vm.log.Info("set registry value", "key", key, "name", name)
+	return true, nil
+}
+
+func (vm *windows) GetBootDiagnostics() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), bootDiagnosticsTimeout)
+	defer cancel()
+	return vm.bootDiagnostics.Get(ctx, vm.instance.ProviderID)
+}
+
+func (vm *windows) GetLoggedOnUsers() ([]string, error) {
+	// `query user` is not used as its output is localized. Logon sessions are kept for a while after their users log
+	// off, so only sessions which still have processes are considered.
+	out, err := vm.Run("Get-CimInstance Win32_LogonSession -Filter '"+interactiveLogonTypesFilter+"' | "+
+		"Where-Object { @(Get-CimAssociatedInstance -InputObject $_ -ResultClassName Win32_Process).Count -gt 0 } | "+
+		"Get-CimAssociatedInstance -Association Win32_LoggedOnUser | "+
+		"ForEach-Object { $_.Domain + '\\' + $_.Name } | Sort-Object -Unique", true)
+	if err != nil {
+		return nil, fmt.Errorf("error getting logged on users with output %s: %w", out, err)
+	}
+	return parseLoggedOnUsers(out), nil
+}
+
+func (vm *windows) IsRebootPending() (bool, error) {
+	out, err := vm.Run(rebootPendingCmd(), true)
+	if err != nil {
+		return false, fmt.Errorf("error checking for a pending reboot with output %s: %w", out, err)
+	}
+	pending, err := strconv.ParseBool(strings.TrimSpace(out))
+	if err != nil {
+		return false, fmt.Errorf("unable to parse pending reboot state %q: %w", out, err)
+	}
+	return pending, nil
+}
+
+func (vm *windows) GetPendingFileRenameOperations() ([]string, error) {
+	out, err := vm.Run("ConvertTo-Json -Compress -InputObject @((Get-ItemProperty -Path '"+sessionManagerKey+"' "+
+		"-Name PendingFileRenameOperations -ErrorAction SilentlyContinue).PendingFileRenameOperations)", true)
+	if err != nil {
+		return nil, fmt.Errorf("error getting pending file rename operations with output %s: %w", out, err)
+	}
+	return parsePendingFileRenameOperations(out)
+}
+
+func (vm *windows) EnsurePathEntry(dir string) error {
+	path, err := vm.GetRegistryValue(systemEnvironmentKey, pathValue)
+	if err != nil {
+		return fmt.Errorf("error getting system PATH: %w", err)
+	}
+	updated, added := addPathEntry(path, dir)
+	if !added {
+		return nil
+	}
+	if _, err = vm.EnsureRegistryValue(systemEnvironmentKey, pathValue, updated); err != nil {
+		return fmt.Errorf("error adding %s to system PATH: %w", dir, err)
+	}
+	vm.log.Info("added directory to system PATH, only new sessions will see the change", "directory", dir)
+	return nil
+}
+
+func (vm *windows) CheckAdminPrivileges() (bool, error) {
+	out, err := vm.Run(adminPrivilegesCmd, true)
+	if err != nil {
+		return false, fmt.Errorf("error checking administrator rights with output %s: %w", out, err)
+	}
+	admin, err := strconv.ParseBool(strings.TrimSpace(out))
+	if err != nil {
+		return false, fmt.Errorf("unable to parse administrator rights check result %q: %w", out, err)
+	}
+	return admin, nil
+}
+
+func (vm *windows) RunScript(script string, args map[string]string) (string, error) {
+	return vm.runScriptFile(script, func(scriptPath string) (string, error) {
+		return runScriptCmd(scriptPath, args)
+	})
+}
 
-	// Stop any services that may be running. This prevents the node being shown as Ready after a failed configuration.
-	if err := vm.RunWICDCleanup(watchNamespace, wicdKubeconfigContents); err != nil {
-		return fmt.Errorf("unable to cleanup the Windows instance: %w", err)
+func (vm *windows) RunValidationScript(script string, timeout time.Duration) (string, error) {
+	logPath := vm.logPaths.WICDDir + "\\" + validationLogFile
+	out, err := vm.runScriptFile(script, func(scriptPath string) (string, error) {
+		return validationScriptCmd(scriptPath, logPath, timeout), nil
+	})
+	if err != nil {
+		return out, fmt.Errorf("validation failed, full output is in %s: %w", logPath, err)
 	}
+	return out, nil
+}
 
-	if err := vm.ensureHostNameAndContainersFeature(); err != nil {
-		return err
+// runScriptFile uploads the given PowerShell script to a temporary file on the instance and runs the command returned
+// by cmdFn for the path of the file, returning the combined output of stdout and stderr. The temporary file is removed
+// once the command exits.
+func (vm *windows) runScriptFile(script string, cmdFn func(string) (string, error)) (string, error) {
+	filename := scriptFilePrefix + rand.String(8) + ".ps1"
+	scriptPath := remoteDir + "\\" + filename
+	cmd, err := cmdFn(scriptPath)
+	if err != nil {
+		return "", err
 	}
-	if err := vm.createDirectories(); err != nil {
-		return fmt.Errorf("error creating directories on Windows VM: %w", err)
+
+	c, err := vm.interact.createSFTPClient()
+	if err != nil {
+		return "", fmt.Errorf("failed to create SFTP client: %w", err)
 	}
-	if err := vm.transferFiles(); err != nil {
-		return fmt.Errorf("error transferring files to Windows VM: %w", err)
+	defer func() {
+		if err := c.Close(); err != nil {
+			vm.log.Error(err, "error closing SFTP connection")
+		}
+	}()
+	if err := vm.interact.transfer(c, strings.NewReader(script), filename, remoteDir); err != nil {
+		return "", fmt.Errorf("unable to copy script to remote dir %s: %w", remoteDir, err)
 	}
+	defer func() {
+		if err := c.Remove(scriptPath); err != nil {
+			vm.log.Error(err, "error removing script", "path", scriptPath)
+		}
+	}()
 
-	wicdBootstrapCmd := fmt.Sprintf("%s bootstrap --desired-version %s --kubeconfig %s --namespace %s",
-		wicdPath, desiredVer, wicdKubeconfigPath, watchNamespace)
-	if out, err := vm.Run(wicdBootstrapCmd, true); err != nil {
-		vm.log.Info("failed to bootstrap node", "command", wicdBootstrapCmd, "output", out)
-		return err
+	out, err := vm.Run(cmd, true)
+	if err != nil {
+		return out, fmt.Errorf("error running script %s: %w", scriptPath, err)
 	}
-	return nil
+	return out, nil
 }
 
-// ConfigureWICD starts the Windows Instance Config Daemon service
-func (vm *windows) ConfigureWICD(watchNamespace, wicdKubeconfigContents string) error {
-	if err := vm.ensureWICDFilesExist(wicdKubeconfigContents); err != nil {
-		return err
-	}
-	wicdServiceArgs := fmt.Sprintf("controller --windows-service --log-dir %s --kubeconfig %s --namespace %s",
-		wicdLogDir, wicdKubeconfigPath, watchNamespace)
-	wicdServiceArgs = fmt.Sprintf("%s --ca-bundle %s", wicdServiceArgs, TrustedCABundlePath)
-	// if WICD crashes, attempt to restart WICD after 10, 30, and 60 seconds, and then every 2 minutes after that.
-	// reset this counter 5 min after a period with no crashes
-	recoveryActions := []recoveryAction{
-		{
-			actionType: serviceRestart,
-			delay:      10,
-		},
-		{
-			actionType: serviceRestart,
-			delay:      30,
-		},
-		{
-			actionType: serviceRestart,
-			delay:      60,
-		},
-		{
-			actionType: serviceRestart,
-			delay:      120,
-		},
-	}
-	// if WICD has not crashed in the past 5 minutes, reset the crash counter
-	recoveryPeriod := 300
-	wicdService, err := newService(wicdPath, WicdServiceName, wicdServiceArgs, nil, recoveryActions, recoveryPeriod)
+func (vm *windows) RemoveStaleTempFiles() error {
+	out, err := vm.Run(removeStaleTempFilesCmd(tempPayloadFiles()), true)
 	if err != nil {
-		return fmt.Errorf("error creating %s service object: %w", WicdServiceName, err)
+		return fmt.Errorf("error removing stale files from %s with output %s: %w", remoteDir, out, err)
 	}
-	if err := vm.ensureServiceIsRunning(wicdService); err != nil {
-		return fmt.Errorf("error ensuring %s Windows service has started running: %w", WicdServiceName, err)
+	if removed := strings.Fields(out); len(removed) > 0 {
+		vm.log.Info("removed stale temporary files", "directory", remoteDir, "files", removed)
+	}
+	return nil
+}
+
+func (vm *windows) Close() error {
+	if err := vm.interact.close(); err != nil {
+		return fmt.Errorf("error closing SSH connection to %s: %w", vm.GetIPv4Address(), err)
 	}
-	vm.log.Info("configured", "service", WicdServiceName, "args", wicdServiceArgs)
 	return nil
 }
 
 // Interface helper methods
 
+// ensureTimeServiceRunning ensures the Windows Time service is enabled and running, as it is responsible for
+// synchronizing the time with the configured NTP servers
+func (vm *windows) ensureTimeServiceRunning() error {
+	out, err := vm.Run("(Get-Service -Name "+w32timeServiceName+").StartType", true)
+	if err != nil {
+		return fmt.Errorf("error querying %s service with output %s: %w", w32timeServiceName, out, err)
+	}
+	if strings.TrimSpace(out) == "Disabled" {
+		if out, err = vm.Run("Set-Service -Name "+w32timeServiceName+" -StartupType Automatic", true); err != nil {
+			return fmt.Errorf("error enabling %s service with output %s: %w", w32timeServiceName, out, err)
+		}
+		vm.log.Info("enabled disabled service", "service", w32timeServiceName)
+	}
+	if out, err = vm.Run("Start-Service -Name "+w32timeServiceName, true); err != nil {
+		return fmt.Errorf("error starting %s service with output %s: %w", w32timeServiceName, out, err)
+	}
+	return nil
+}
+
 // ensureWICDFilesExist ensures all files required for WICD to run exist. If needed, creates the destination directory,
 // WICD binary, and kubeconfig.
 func (vm *windows) ensureWICDFilesExist(wicdKubeconfig string) error {
@@ -618,8 +2428,9 @@ func (vm *windows) ensureWICDFilesExist(wicdKubeconfig string) error {
 }
 
 // ensureHostNameAndContainersFeature ensures hostname of the Windows VM matches the expected name
-// and the required Windows feature is enabled.
-func (vm *windows) ensureHostNameAndContainersFeature() error {
+// and the required Windows feature is enabled. minFreeMemory is passed through to checkFreeMemory before the feature
+// is installed.
+func (vm *windows) ensureHostNameAndContainersFeature(minFreeMemory uint64) error {
 	rebootNeeded := false
 	// Set the hostName of the Windows VM if needed
 	if vm.instance.NewHostname != "" {
@@ -639,6 +2450,9 @@ func (vm *windows) ensureHostNameAndContainersFeature() error {
 		return err
 	}
 	if !isContainersFeatureEnabled {
+		if err := vm.checkFreeMemory(minFreeMemory); err != nil {
+			return err
+		}
 		if err := vm.enableContainersWindowsFeature(); err != nil {
 			return fmt.Errorf("error enabling Windows Containers feature: %w", err)
 		}
@@ -655,6 +2469,93 @@ func (vm *windows) ensureHostNameAndContainersFeature() error {
 	return nil
 }
 
+// ensureNoPendingReboot restarts the instance if a reboot is pending, as Windows features cannot be reliably installed
+// until it is done. A reboot which is still pending after the restart is logged, as some updates require multiple
+// restarts.
+func (vm *windows) ensureNoPendingReboot() error {
+	pending, err := vm.IsRebootPending()
+	if err != nil {
+		return err
+	}
+	if !pending {
+		return nil
+	}
+	vm.log.Info("reboot pending, restarting instance before configuration")
+	if err = vm.RebootAndReinitialize(); err != nil {
+		return fmt.Errorf("error completing pending reboot: %w", err)
+	}
+	if pending, err = vm.IsRebootPending(); err != nil {
+		return err
+	}
+	if pending {
+		vm.log.Info("WARNING: reboot is still pending after restarting the instance")
+	}
+	return nil
+}
+
+// ensureNoPendingRenameOfTransferredFiles restarts the instance if any of the files transferred to it while
+// bootstrapping is pending a rename, replacement or deletion. Such a file is locked until the instance is restarted,
+// which fails its transfer with a sharing violation. Pending operations on other files are left for the next restart.
+func (vm *windows) ensureNoPendingRenameOfTransferredFiles() error {
+	pending, err := vm.GetPendingFileRenameOperations()
+	if err != nil {
+		return err
+	}
+	targets := make([]string, 0, len(vm.filesToTransfer))
+	for file, dir := range vm.filesToTransfer {
+		targets = append(targets, dir+"\\"+filepath.Base(file.Path))
+	}
+	conflicts := pendingRenameConflicts(pending, targets)
+	if len(conflicts) == 0 {
+		return nil
+	}
+	vm.log.Info("files to be transferred are pending a rename, restarting instance before transferring them",
+		"files", conflicts, "pending", pending)
+	if err = vm.RebootAndReinitialize(); err != nil {
+		return fmt.Errorf("error completing pending file rename operations: %w", err)
+	}
+	return nil
+}
+
+// checkFreeMemory ensures the instance has enough free memory to install the Windows Containers feature and reboot.
+// If minFreeMemory is 0, a warning is logged when free memory is below defaultMinFreeMemory instead of failing.
+func (vm *windows) checkFreeMemory(minFreeMemory uint64) error {
+	freeMemory, err := vm.GetFreeMemory()
+	if err != nil {
+		return err
+	}
+	if minFreeMemory == 0 {
+		if freeMemory < defaultMinFreeMemory {
+			vm.log.Info("WARNING: low free memory may cause the Containers feature installation to fail",
+				"freeBytes", freeMemory, "recommendedBytes", defaultMinFreeMemory)
+		}
+		return nil
+	}
+	if freeMemory < minFreeMemory {
+		return fmt.Errorf("instance has %d bytes of free memory, at least %d are required to install the %s "+
+			"feature", freeMemory, minFreeMemory, containersFeatureName)
+	}
+	return nil
+}
+
+// checkKubeProxySupport returns an error if the instance's Windows build or HNS state do not support kube-proxy's
+// kernelspace proxier, which is the only proxy mode available on Windows
+func (vm *windows) checkKubeProxySupport() error {
+	build, err := vm.GetBuildNumber()
+	if err != nil {
+		return err
+	}
+	// HNS may be started on demand, so an attempt is made to start it before checking its status. An empty status
+	// means the service does not exist.
+	hnsStatus, err := vm.Run("$s = Get-Service -Name "+hnsServiceName+" -ErrorAction SilentlyContinue; "+
+		"if ($s -and $s.Status -ne 'Running') { Start-Service -Name "+hnsServiceName+
+		" -ErrorAction SilentlyContinue; $s.Refresh() }; $s.Status", true)
+	if err != nil {
+		return fmt.Errorf("error querying %s service with output %s: %w", hnsServiceName, hnsStatus, err)
+	}
+	return validateKubeProxySupport(build, strings.TrimSpace(hnsStatus))
+}
+
 // isHostNameChangeNeeded tells if we need to update the host name of the Windows VM
 func (vm *windows) isHostNameChangeNeeded() (bool, error) {
 	hostName, err := vm.GetHostname()
@@ -682,6 +2583,19 @@ func (vm *windows) createDirectories() error {
 			return fmt.Errorf("unable to create remote directory %s: %w", dir, err)
 		}
 	}
+	return vm.EnsureLogDirectories()
+}
+
+func (vm *windows) EnsureLogDirectories() error {
+	// the default log directories are among the required directories
+	if vm.logPaths.Root == logDir {
+		return nil
+	}
+	for _, dir := range vm.logPaths.serviceDirs() {
+		if err := vm.EnsureDirectory(dir); err != nil {
+			return fmt.Errorf("error creating log directory: %w", err)
+		}
+	}
 	return nil
 }
 
@@ -782,18 +2696,24 @@ func (vm *windows) setRecoveryActions(svc *service) error {
 	if len(svc.recoveryActions) == 0 {
 		return nil
 	}
-	actions := fmt.Sprintf("%s/%d", svc.recoveryActions[0].actionType, svc.recoveryActions[0].delay)
-	for _, action := range svc.recoveryActions[1:] {
-		actions = fmt.Sprintf("%s/%s/%d", actions, action.actionType, action.delay)
-	}
-	cmd := fmt.Sprintf("sc.exe failure %s reset= %d actions= %s", svc.name, svc.recoveryPeriod, actions)
-	out, err := vm.Run(cmd, false)
+	out, err := vm.Run(recoveryActionsCmd(svc), false)
 	if err != nil {
 		return fmt.Errorf("failed to set recovery actions with stdout: %s: %w", out, err)
 	}
 	return nil
 }
 
+// recoveryActionsCmd returns the command setting the recovery actions of the given service, which must have at least
+// one. sc.exe takes the delays of the actions in milliseconds and the reset period in seconds.
+func recoveryActionsCmd(svc *service) string {
+	actions := make([]string, 0, len(svc.recoveryActions))
+	for _, action := range svc.recoveryActions {
+		actions = append(actions, fmt.Sprintf("%s/%d", action.actionType, action.delay.Milliseconds()))
+	}
+	return fmt.Sprintf("sc.exe failure %s reset= %d actions= %s", svc.name, int(svc.recoveryPeriod.Seconds()),
+		strings.Join(actions, "/"))
+}
+
 // ensureServiceNotRunning stops a service if it exists and is running
 func (vm *windows) ensureServiceNotRunning(svc *service) error {
 	if svc == nil {
@@ -916,6 +2836,39 @@ func (vm *windows) serviceExists(serviceName string) (bool, error) {
 	return true, nil
 }
 
+// containerdStateCmd returns the PowerShell command which queries containerd's CRI endpoint with crictl, or, if
+// crictl is not on the PATH, connects to the endpoint's named pipe. The command outputs whether the endpoint responded.
+func containerdStateCmd() string {
+	timeout := strconv.Itoa(containerdStateTimeoutSeconds)
+	return "$crictl = Get-Command crictl.exe -ErrorAction SilentlyContinue; " +
+		"if ($crictl) { " +
+		"& $crictl.Source --runtime-endpoint 'npipe://./pipe/" + containerdPipeName + "' --timeout " + timeout +
+		"s info *> $null; " +
+		"if ($LASTEXITCODE -eq 0) { '" + criResponsive + "' } else { '" + criUnresponsive + "' } " +
+		"} else { " +
+		"$pipe = New-Object System.IO.Pipes.NamedPipeClientStream('.', '" + containerdPipeName + "', " +
+		"[System.IO.Pipes.PipeDirection]::InOut); " +
+		"try { $pipe.Connect(" + timeout + "000); '" + pipeResponsive + "' } " +
+		"catch { '" + pipeUnresponsive + "' } finally { $pipe.Dispose() } }"
+}
+
+// parseContainerdState returns an error if the given output of containerdStateCmd shows containerd's CRI endpoint
+// did not respond
+func parseContainerdState(out string) error {
+	switch strings.TrimSpace(out) {
+	case criResponsive, pipeResponsive:
+		return nil
+	case criUnresponsive:
+		return fmt.Errorf("%s service is running but its CRI endpoint did not respond within %ds",
+			ContainerdServiceName, containerdStateTimeoutSeconds)
+	case pipeUnresponsive:
+		return fmt.Errorf("%s service is running but its named pipe %s could not be connected to within %ds",
+			ContainerdServiceName, containerdPipeName, containerdStateTimeoutSeconds)
+	default:
+		return fmt.Errorf("unexpected output checking %s CRI endpoint: %s", ContainerdServiceName, out)
+	}
+}
+
 // isRunning checks the status of given service
 func (vm *windows) isRunning(serviceName string) (bool, error) {
 	out, err := vm.Run("sc.exe query "+serviceName, false)
@@ -945,6 +2898,23 @@ func (vm *windows) startService(svc *service) error {
 }
 
 // newFileInfo returns a pointer to a FileInfo object created from the specified file on the Windows VM
+// fileChecksum returns the SHA256 checksum of the file at the given path on the Windows VM, or an empty string if
+// the file does not exist
+func (vm *windows) fileChecksum(path string) (string, error) {
+	out, err := vm.Run("Test-Path "+path, true)
+	if err != nil {
+		return "", fmt.Errorf("error checking if file %s exists: %w", path, err)
+	}
+	if strings.TrimSpace(out) != "True" {
+		return "", nil
+	}
+	remoteFile, err := vm.newFileInfo(path)
+	if err != nil {
+		return "", fmt.Errorf("error getting info on file '%s' on the Windows VM: %w", path, err)
+	}
+	return remoteFile.SHA256, nil
+}
+
 func (vm *windows) newFileInfo(path string) (*payload.FileInfo, error) {
 	// Get-FileHash returns an object with multiple properties, we are interested in the `Hash` property
 	command := "$out = Get-FileHash " + path + " -Algorithm SHA256; $out.Hash"
@@ -1020,10 +2990,93 @@ func (vm *windows) isContainersFeatureEnabled() (bool, error) {
 	return strings.Contains(out, "Enabled"), nil
 }
 
+// parseDynamicPortRange returns the start port and number of ports of the dynamic port range given by the output of
+// `netsh int ipv4 show dynamicport`. The values are read in the order they are output, after the colon of each line,
+// as the labels of the output are localized.
+func parseDynamicPortRange(out string) (int, int, error) {
+	var values []int
+	for _, line := range strings.Split(out, "\n") {
+		_, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return 0, 0, fmt.Errorf("unexpected output %q", out)
+		}
+		values = append(values, n)
+	}
+	if len(values) != 2 {
+		return 0, 0, fmt.Errorf("unexpected output %q", out)
+	}
+	return values[0], values[1], nil
+}
+
+// parsePendingFileRenameOperations returns the paths given by the given JSON array of the entries of the
+// PendingFileRenameOperations registry value. The entries are pairs of a source path and a destination path, which is
+// empty for a deletion, given in the NT namespace form \??\C:\path and prefixed with ! when the destination is
+// replaced.
+func parsePendingFileRenameOperations(out string) ([]string, error) {
+	var entries []string
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out)), &entries); err != nil {
+		return nil, fmt.Errorf("unable to parse pending file rename operations %q: %w", out, err)
+	}
+	paths := []string{}
+	for _, entry := range entries {
+		path := strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(entry), "!"), "\\??\\")
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// pendingRenameConflicts returns the given target paths which are among the given paths pending a rename. Windows
+// paths are case insensitive.
+func pendingRenameConflicts(pending, targets []string) []string {
+	var conflicts []string
+	for _, target := range targets {
+		for _, path := range pending {
+			if strings.EqualFold(path, target) {
+				conflicts = append(conflicts, target)
+				break
+			}
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// parseLoggedOnUsers returns the user names listed, one per line, in the given command output
+func parseLoggedOnUsers(out string) []string {
+	users := []string{}
+	for _, line := range strings.Split(out, "\n") {
+		if user := strings.TrimSpace(line); user != "" {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
+// logBootDiagnostics logs the console output of the instance on a best-effort basis, to help troubleshoot an instance
+// which did not come back from a reboot
+func (vm *windows) logBootDiagnostics() {
+	output, err := vm.GetBootDiagnostics()
+	if err != nil {
+		if errors.Is(err, bootdiagnostics.ErrUnavailable) {
+			vm.log.Info("boot diagnostics unavailable")
+			return
+		}
+		vm.log.Error(err, "unable to get boot diagnostics")
+		return
+	}
+	vm.log.Info("boot diagnostics", "consoleOutput", output)
+}
+
 // waitUntilUnreachable tries to run a dummy command until it fails to see if the instance is reachable via SSH
 func (vm *windows) waitUntilUnreachable() error {
-	return wait.PollUntilContextTimeout(context.TODO(), retry.WindowsAPIInterval, retry.ResourceChangeTimeout, true,
-		func(ctx context.Context) (bool, error) {
+	return wait.PollUntilContextTimeout(context.TODO(), vm.rebootDetection.Interval, retry.ResourceChangeTimeout,
+		true, func(ctx context.Context) (bool, error) {
 			_, err := vm.Run("Get-Help", true)
 			return (err != nil), nil
 		})
@@ -1052,6 +3105,265 @@ func (vm *windows) deconfigureWICD() error {
 
 // Generic helper methods
 
+// validateKubeProxySupport returns an error describing why the given Windows build and HNS service status do not
+// support kube-proxy's kernelspace proxier, if that is the case
+func validateKubeProxySupport(build int, hnsStatus string) error {
+	if build < minKubeProxyKernelspaceBuild {
+		return fmt.Errorf("kube-proxy kernelspace mode requires Windows build %d or later, detected build %d (HNS "+
+			"service status %q): upgrade the instance to a supported Windows Server version",
+			minKubeProxyKernelspaceBuild, build, hnsStatus)
+	}
+	switch hnsStatus {
+	case "Running":
+		return nil
+	case "":
+		return fmt.Errorf("kube-proxy kernelspace mode requires the %s service, which is not present on build %d: "+
+			"ensure the %s feature is installed", hnsServiceName, build, containersFeatureName)
+	default:
+		return fmt.Errorf("kube-proxy kernelspace mode requires the %s service to be running, detected status %q on "+
+			"build %d: ensure the %s service is enabled", hnsServiceName, hnsStatus, build, hnsServiceName)
+	}
+}
+
+// StopContainerTasksCmd returns the PowerShell command which asks containerd to stop all running Kubernetes
+// containers, waiting up to the given timeout for them to exit. The command fails if any containers are still running
+// once the timeout is reached. Nothing is done if the containerd CLI is not present.
+func StopContainerTasksCmd(timeout time.Duration) string {
+	ctr := fmt.Sprintf("& '%s' -n %s tasks", CtrPath, containerdK8sNamespace)
+	return fmt.Sprintf("if (!(Test-Path '%s')) { exit 0 }; "+
+		"foreach ($task in (%s ls -q)) { %s kill $task }; "+
+		"$deadline = (Get-Date).AddSeconds(%d); "+
+		"while ((%s ls -q) -and ((Get-Date) -lt $deadline)) { Start-Sleep -Seconds 1 }; "+
+		"if (%s ls -q) { exit 1 }", CtrPath, ctr, ctr, int(timeout.Seconds()), ctr, ctr)
+}
+
+// registryProxy returns the URL of the proxy the given registry host should be contacted through, based on WMCO's
+// proxy environment variables, which reflect the cluster-wide proxy. Nil is returned if no proxy is needed.
+func registryProxy(registry string) (*url.URL, error) {
+	req, err := http.NewRequest(http.MethodHead, "https://"+registry+"/v2/", nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid registry %s: %w", registry, err)
+	}
+	proxyURL, err := http.ProxyFromEnvironment(req)
+	if err != nil {
+		// the error can contain the proxy URL, including its credentials, so it is not wrapped
+		return nil, fmt.Errorf("invalid proxy configuration for registry %s", registry)
+	}
+	return proxyURL, nil
+}
+
+// registryProbeCmd returns the PowerShell command which makes a HEAD request to the registry API endpoint of the given
+// host, through the given proxy if not nil. Any HTTP response, including an authentication challenge, means the
+// registry is reachable. The credentials of the proxy are not part of the command, if the proxy has any the command
+// reads the username and password from the first two lines of stdin.
+func registryProbeCmd(registry string, proxy *url.URL) string {
+	cmd := "[Net.ServicePointManager]::SecurityProtocol = [Net.SecurityProtocolType]::Tls12; " +
+		"$params = @{Uri='https://" + registry + "/v2/'; Method='Head'; UseBasicParsing=$true; TimeoutSec=" +
+		strconv.Itoa(registryProbeTimeoutSeconds) + "}; "
+	if proxy != nil {
+		withoutUser := *proxy
+		withoutUser.User = nil
+		cmd += "$params.Proxy = '" + withoutUser.String() + "'; "
+		if proxy.User != nil {
+			cmd += "$u = [Console]::In.ReadLine(); $p = [Console]::In.ReadLine(); " +
+				"$params.ProxyCredential = New-Object System.Management.Automation.PSCredential($u, " +
+				"(ConvertTo-SecureString $p -AsPlainText -Force)); "
+		}
+	}
+	return cmd + "try { Invoke-WebRequest @params | Out-Null; '" + registryReachable + "' } " +
+		"catch { if ($_.Exception.Response) { '" + registryReachable + "' } else { $_.Exception.Message } }"
+}
+
+// parseNetworkAdapters parses the JSON output of networkAdaptersCmd, which is either a list of adapters or, if the
+// output was not wrapped in a list by the PowerShell version on the instance, a single adapter
+func parseNetworkAdapters(out string) ([]NetworkAdapter, error) {
+	out = strings.TrimSpace(out)
+	if out == "" {
+		return []NetworkAdapter{}, nil
+	}
+	if strings.HasPrefix(out, "{") {
+		out = "[" + out + "]"
+	}
+	adapters := []NetworkAdapter{}
+	if err := json.Unmarshal([]byte(out), &adapters); err != nil {
+		return nil, fmt.Errorf("unable to parse network adapters %q: %w", out, err)
+	}
+	sort.Slice(adapters, func(i, j int) bool { return adapters[i].Index < adapters[j].Index })
+	for i := range adapters {
+		if adapters[i].IPv4Addresses == nil {
+			adapters[i].IPv4Addresses = []string{}
+		}
+	}
+	return adapters, nil
+}
+
+// connectivityTestCmd returns the PowerShell command which checks if a TCP connection can be opened to the given host
+// and port using Test-NetConnection, outputting the result as JSON
+func connectivityTestCmd(host string, port int) string {
+	return "$r = Test-NetConnection -ComputerName '" + host + "' -Port " + strconv.Itoa(port) +
+		" -WarningAction SilentlyContinue; " +
+		"ConvertTo-Json -Compress -InputObject @{tcpTestSucceeded = [bool]$r.TcpTestSucceeded; " +
+		"remoteAddress = [string]$r.RemoteAddress; pingSucceeded = [bool]$r.PingSucceeded; " +
+		"roundTripMs = [int64]$r.PingReplyDetails.RoundtripTime}"
+}
+
+// parseComputerInfo parses the JSON output of computerInfoCmd
+func parseComputerInfo(out string) (*ComputerInfo, error) {
+	info := &ComputerInfo{}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out)), info); err != nil {
+		return nil, fmt.Errorf("unable to parse computer info %q: %w", out, err)
+	}
+	if info.Build == 0 {
+		return nil, fmt.Errorf("computer info %q has no build number", out)
+	}
+	return info, nil
+}
+
+// parseServiceExitCode returns the exit code given by the given `sc.exe queryex` output, which is the service specific
+// exit code if the Win32 exit code indicates the service reported one
+func parseServiceExitCode(scOutput string) (int, error) {
+	codes := make(map[string]int)
+	for _, line := range strings.Split(scOutput, "\n") {
+		field, value, found := strings.Cut(strings.TrimSpace(line), ":")
+		field = strings.TrimSpace(field)
+		if !found || (field != win32ExitCodeField && field != serviceExitCodeField) {
+			continue
+		}
+		// the decimal code is followed by its hexadecimal form, such as 1067  (0x42b)
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			return 0, fmt.Errorf("malformed %s line %q", field, line)
+		}
+		code, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("malformed %s line %q: %w", field, line, err)
+		}
+		codes[field] = int(code)
+	}
+	win32Code, found := codes[win32ExitCodeField]
+	if !found {
+		return 0, fmt.Errorf("%s not found", win32ExitCodeField)
+	}
+	if win32Code != errorServiceSpecificError {
+		return win32Code, nil
+	}
+	serviceCode, found := codes[serviceExitCodeField]
+	if !found {
+		return 0, fmt.Errorf("%s not found", serviceExitCodeField)
+	}
+	return serviceCode, nil
+}
+
+// parseConnectivityResult parses the output of the command returned by connectivityTestCmd
+func parseConnectivityResult(out string) (*ConnectivityResult, error) {
+	result := &ConnectivityResult{}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out)), result); err != nil {
+		return nil, fmt.Errorf("unable to parse connectivity test result %q: %w", out, err)
+	}
+	return result, nil
+}
+
+// fileACLCmd returns the PowerShell command which outputs the owner and access rules of the file at the given path as
+// JSON. SIDs are output rather than account names, which are localized.
+func fileACLCmd(path string) string {
+	sidType := "[System.Security.Principal.SecurityIdentifier]"
+	return "$acl = Get-Acl -LiteralPath '" + path + "'; " +
+		"$rules = @($acl.GetAccessRules($true, $true, " + sidType + ") | ForEach-Object { @{" +
+		"sid = $_.IdentityReference.Value; rights = $_.FileSystemRights.ToString(); " +
+		"allow = ($_.AccessControlType -eq 'Allow')} }); " +
+		"ConvertTo-Json -Compress -Depth 3 -InputObject @{owner = $acl.GetOwner(" + sidType + ").Value; " +
+		"protected = $acl.AreAccessRulesProtected; rules = $rules}"
+}
+
+// resolveFileCmd returns the PowerShell command which outputs the path of the file at the given path, following it if
+// it is a symbolic link, as the ACL of the link itself does not protect the file it points to. Nothing is output if
+// the file does not exist.
+func resolveFileCmd(path string) string {
+	return "$f = Get-Item -LiteralPath '" + path + "' -ErrorAction SilentlyContinue; " +
+		"if ($f.LinkType -eq 'SymbolicLink') { [IO.Path]::Combine($f.DirectoryName, @($f.Target)[0]) } " +
+		"elseif ($f) { $f.FullName }"
+}
+
+// parseFileACL parses the JSON output of fileACLCmd
+func parseFileACL(out string) (*FileACL, error) {
+	acl := &FileACL{}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out)), acl); err != nil {
+		return nil, fmt.Errorf("unable to parse file ACL %q: %w", out, err)
+	}
+	if acl.Owner == "" {
+		return nil, fmt.Errorf("file ACL %q has no owner", out)
+	}
+	return acl, nil
+}
+
+// validateFileACL returns an error if the given owner and allowed SIDs cannot be used to restrict access to a file
+func validateFileACL(owner string, allowedSIDs []string) error {
+	if !sidRegex.MatchString(owner) {
+		return fmt.Errorf("owner %q is not a SID", owner)
+	}
+	if len(allowedSIDs) == 0 {
+		return fmt.Errorf("at least one SID must be allowed access")
+	}
+	for _, sid := range allowedSIDs {
+		if !sidRegex.MatchString(sid) {
+			return fmt.Errorf("allowed SID %q is not a SID", sid)
+		}
+	}
+	return nil
+}
+
+// matches returns true if the ACL is owned by the given SID, does not inherit rules and only grants full control to
+// exactly the given SIDs
+func (a *FileACL) matches(owner string, allowedSIDs []string) bool {
+	if a.Owner != owner || !a.Protected {
+		return false
+	}
+	granted := make(map[string]bool)
+	for _, rule := range a.Rules {
+		if !rule.Allow || rule.Rights != fullControlRights || !slices.Contains(allowedSIDs, rule.SID) {
+			return false
+		}
+		granted[rule.SID] = true
+	}
+	for _, sid := range allowedSIDs {
+		if !granted[sid] {
+			return false
+		}
+	}
+	return true
+}
+
+// unexpectedSIDs returns the SIDs which have access rules in the ACL but are not part of the given allowed SIDs
+func (a *FileACL) unexpectedSIDs(allowedSIDs []string) []string {
+	var sids []string
+	for _, rule := range a.Rules {
+		if !slices.Contains(allowedSIDs, rule.SID) && !slices.Contains(sids, rule.SID) {
+			sids = append(sids, rule.SID)
+		}
+	}
+	return sids
+}
+
+// icaclsGrants returns the icacls arguments granting full control to each of the given SIDs
+func icaclsGrants(sids []string) string {
+	grants := make([]string, 0, len(sids))
+	for _, sid := range sids {
+		grants = append(grants, "*"+sid+":F")
+	}
+	return strings.Join(grants, " ")
+}
+
+// rebootPendingCmd returns the PowerShell command which outputs True if any of rebootPendingKeys exist, or if the
+// computer has been renamed since it was started
+func rebootPendingCmd() string {
+	cmd := ""
+	for _, key := range rebootPendingKeys {
+		cmd += "(Test-Path '" + key + "') -or "
+	}
+	return cmd + "((Get-ItemProperty '" + activeComputerNameKey + "').ComputerName -ne " +
+		"(Get-ItemProperty '" + computerNameKey + "').ComputerName)"
+}
+
 // formatRemotePowerShellCommand returns a formatted string, prepended with the required PowerShell prefix and
 // surrounding quotes needed to execute the given command on a remote Windows VM
 func formatRemotePowerShellCommand(command string) string {
@@ -1059,6 +3371,68 @@ func formatRemotePowerShellCommand(command string) string {
 	return fmt.Sprintf("%s \"%s\"", remotePowerShellCmdPrefix, command)
 }
 
+// addPathEntry returns the given PATH with the given directory appended, and true, if the directory is not already one
+// of its entries. Entries are compared case-insensitively, ignoring trailing backslashes.
+func addPathEntry(path, dir string) (string, bool) {
+	normalize := func(entry string) string {
+		return strings.ToLower(strings.TrimRight(strings.TrimSpace(entry), "\\"))
+	}
+	for _, entry := range strings.Split(path, ";") {
+		if normalize(entry) == normalize(dir) {
+			return path, false
+		}
+	}
+	if path == "" || strings.HasSuffix(path, ";") {
+		return path + dir, true
+	}
+	return path + ";" + dir, true
+}
+
+// isPermissionError returns true if the given command output indicates the command failed due to missing privileges
+func isPermissionError(out string) bool {
+	return strings.Contains(out, accessDenied) || strings.Contains(out, privilegeNotHeld)
+}
+
+// runScriptCmd returns the PowerShell command which runs the script at the given path in a new PowerShell process,
+// passing it the given arguments. Arguments are ordered by parameter name, so that the command is deterministic.
+func runScriptCmd(scriptPath string, args map[string]string) (string, error) {
+	names := make([]string, 0, len(args))
+	for name, value := range args {
+		if !scriptParameterRegex.MatchString(name) {
+			return "", fmt.Errorf("invalid script parameter name %q", name)
+		}
+		// the command is run wrapped in double quotes
+		if strings.Contains(value, "\"") {
+			return "", fmt.Errorf("value of script parameter %s cannot contain double quotes", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	cmd := "& powershell.exe -NonInteractive -ExecutionPolicy Bypass -File '" + scriptPath + "'"
+	for _, name := range names {
+		cmd += " -" + name + " '" + strings.ReplaceAll(args[name], "'", "''") + "'"
+	}
+	return cmd, nil
+}
+
+// validationScriptCmd returns the PowerShell command which runs the script at the given path in a new PowerShell
+// process, writing its output to the file at the given log path. The process is stopped along with its child processes
+// if it does not exit within the given timeout. The command prints the last lines of the output of the script, and
+// exits with the exit code of the script, or 1 if the script was stopped.
+func validationScriptCmd(scriptPath, logPath string, timeout time.Duration) string {
+	return fmt.Sprintf("$log = '%s'; $errLog = $log + '.err'; "+
+		"New-Item -ItemType Directory -Force -Path (Split-Path $log) | Out-Null; "+
+		"$p = Start-Process -FilePath powershell.exe -NoNewWindow -PassThru -RedirectStandardOutput $log "+
+		"-RedirectStandardError $errLog -ArgumentList '-NonInteractive','-ExecutionPolicy','Bypass','-File','%s'; "+
+		// the handle must be opened for the exit code to be available once the process has exited
+		"$h = $p.Handle; $timedOut = !$p.WaitForExit(%d); "+
+		"if ($timedOut) { taskkill.exe /T /F /PID $p.Id | Out-Null; $p.WaitForExit() }; "+
+		"Get-Content $errLog | Add-Content $log; Remove-Item $errLog -Force; "+
+		"Get-Content $log -Tail %d; "+
+		"if ($timedOut) { Write-Output 'validation script did not exit within %s'; exit 1 }; exit $p.ExitCode",
+		logPath, scriptPath, timeout.Milliseconds(), validationOutputLines, timeout)
+}
+
 // mkdirCmd returns the Windows command to create a directory if it does not exists
 func mkdirCmd(dirName string) string {
 	// trailing space required due to directories ending in `\` causing issues on VMs with PowerShell as the shell.
@@ -1076,11 +3450,42 @@ func rmK8sFilesCmd() string {
 		K8sDir, K8sDir, wicdPath, wicdKubeconfigPath)
 }
 
+// tempPayloadFiles returns the sorted names of the payload files transferred to remoteDir
+func tempPayloadFiles() []string {
+	var names []string
+	for src, dest := range getFilesToTransfer(nil) {
+		if dest == remoteDir {
+			names = append(names, filepath.Base(src))
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// removeStaleTempFilesCmd returns the PowerShell command removing the entries of remoteDir other than the given files
+// and the script files of RunScript younger than staleScriptAge, printing the name of each removed entry
+func removeStaleTempFilesCmd(keep []string) string {
+	return fmt.Sprintf("$cutoff = (Get-Date).AddMinutes(-%d); $keep = @('%s'); "+
+		"Get-ChildItem -LiteralPath '%s' -Force -ErrorAction SilentlyContinue | "+
+		"Where-Object { $keep -notcontains $_.Name -and "+
+		"-not ($_.Name -like '%s*.ps1' -and $_.LastWriteTime -gt $cutoff) } | "+
+		"ForEach-Object { Remove-Item -LiteralPath $_.FullName -Recurse -Force -ErrorAction Stop; $_.Name }",
+		int(staleScriptAge.Minutes()), strings.Join(keep, "','"), remoteDir, scriptFilePrefix)
+}
+
 // getHNSNetworkCmd returns the Windows command to get HNS network by name
 func getHNSNetworkCmd(networkName string) string {
 	return "Get-HnsNetwork | where { $_.Name -eq '" + networkName + "'}"
 }
 
+// hnsNetworkInterfaceAliasCmd returns the PowerShell command which outputs the alias of the host vEthernet adapter of
+// the HNS network with the given name, which is assigned the management IP of the network
+func hnsNetworkInterfaceAliasCmd(networkName string) string {
+	return "$n = @(" + getHNSNetworkCmd(networkName) + ")[0]; " +
+		"if (-not $n) { throw 'HNS network " + networkName + " not found' }; " +
+		"(Get-NetIPAddress -IPAddress $n.ManagementIP -AddressFamily IPv4).InterfaceAlias"
+}
+
 // SplitPath splits a Windows file path into the directory and base file name.
 // Example: 'C:\\k\\bootstrap-kubeconfig' --> dir: 'C:\\k\\', fileName: 'bootstrap-kubeconfig'
 func SplitPath(filepath string) (dir string, fileName string) {
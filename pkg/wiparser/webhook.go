@@ -0,0 +1,61 @@
+package wiparser
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// ValidatingWebhookPath is the path the instance ConfigMap validating webhook is served at
+const ValidatingWebhookPath = "/validate-windows-instances"
+
+//+kubebuilder:webhook:path=/validate-windows-instances,mutating=false,failurePolicy=ignore,sideEffects=None,groups="",resources=configmaps,verbs=create;update,versions=v1,name=vwindows-instances.windowsmachineconfig.openshift.io,admissionReviewVersions=v1,timeoutSeconds=10
+
+// Validator is an admission handler rejecting instance ConfigMaps with entries which cannot be parsed, so that
+// mistakes are reported when the ConfigMap is written rather than when its instances are reconciled. Other ConfigMaps
+// are always allowed.
+type Validator struct {
+	// namespace is the namespace the instance ConfigMap is read from
+	namespace string
+	// defaultUsername is the username of instances whose entry does not give one
+	defaultUsername string
+	decoder         admission.Decoder
+}
+
+// NewValidator returns a Validator of the instance ConfigMap in the given namespace
+func NewValidator(scheme *runtime.Scheme, namespace, defaultUsername string) *Validator {
+	return &Validator{
+		namespace:       namespace,
+		defaultUsername: defaultUsername,
+		decoder:         admission.NewDecoder(scheme),
+	}
+}
+
+// Handle validates the entries of the instance ConfigMap given by the request
+func (v *Validator) Handle(_ context.Context, req admission.Request) admission.Response {
+	if req.Namespace != v.namespace || req.Name != InstanceConfigMap {
+		return admission.Allowed("")
+	}
+	configMap := &core.ConfigMap{}
+	if err := v.decoder.Decode(req, configMap); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	return validationResponse(Validate(configMap.Data, v.defaultUsername))
+}
+
+// validationResponse returns the admission response for an instance ConfigMap with the given invalid entries
+func validationResponse(parseErrs []*ParseError) admission.Response {
+	if len(parseErrs) == 0 {
+		return admission.Allowed("")
+	}
+	reasons := make([]string, 0, len(parseErrs))
+	for _, parseErr := range parseErrs {
+		reasons = append(reasons, parseErr.Error())
+	}
+	return admission.Denied(fmt.Sprintf("%s ConfigMap is invalid: %s", InstanceConfigMap, strings.Join(reasons, "; ")))
+}
@@ -4,11 +4,14 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"slices"
+	"strconv"
 	"strings"
 
 	core "k8s.io/api/core/v1"
 	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
 	kubeTypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/openshift/windows-machine-config-operator/pkg/instance"
@@ -18,8 +21,35 @@ import (
 // InstanceConfigMap is the name of the ConfigMap where VMs to be configured should be described.
 const InstanceConfigMap = "windows-instances"
 
-// GetInstances returns a list of Windows instances by parsing the Windows instance configMap.
-func GetInstances(c client.Client, namespace string) ([]*instance.Info, error) {
+const (
+	// usernameField is the field of an instance entry giving the user to SSH into the instance as
+	usernameField = "username"
+	// portField is the field of an instance entry giving the port the instance's SSH server listens on
+	portField = "port"
+	// registerNodeField is the field of an instance entry giving whether kubelet should register the instance's Node.
+	// It is set to false when the Node is registered out-of-band.
+	registerNodeField = "registerNode"
+)
+
+// ParseErrorAnnotation is applied to the instance ConfigMap while one of its entries cannot be parsed. Its value is
+// the JSON representation of the ParseError describing the failure, and it is removed once all entries are valid.
+const ParseErrorAnnotation = "windowsmachineconfig.openshift.io/parse-error"
+
+// ParseError describes an entry of the instance ConfigMap which could not be parsed
+type ParseError struct {
+	// Entry is the key of the invalid entry, which is the address of the instance
+	Entry string `json:"entry"`
+	// Reason describes why the entry is invalid
+	Reason string `json:"reason"`
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("invalid entry %s: %s", e.Entry, e.Reason)
+}
+
+// GetInstances returns a list of Windows instances by parsing the Windows instance configMap. Instances without a
+// username are given the default username.
+func GetInstances(c client.Client, namespace, defaultUsername string) ([]*instance.Info, error) {
 	configMap := &core.ConfigMap{}
 	err := c.Get(context.TODO(), kubeTypes.NamespacedName{Namespace: namespace,
 		Name: InstanceConfigMap}, configMap)
@@ -33,7 +63,7 @@ func GetInstances(c client.Client, namespace string) ([]*instance.Info, error) {
 		return nil, fmt.Errorf("error listing nodes: %w", err)
 	}
 
-	windowsInstances, err := Parse(configMap.Data, nodes)
+	windowsInstances, err := Parse(configMap.Data, nodes, defaultUsername)
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse instances from ConfigMap %s: %w", configMap.Name, err)
 	}
@@ -43,56 +73,184 @@ func GetInstances(c client.Client, namespace string) ([]*instance.Info, error) {
 // Parse returns the list of instances specified in the Windows instances data. This function should be passed a list
 // of Nodes in the cluster, as each instance returned will contain a reference to its associated Node, if it has one
 // in the given NodeList. If an instance does not have an associated node from the NodeList, the node reference will
-// be nil.
-func Parse(instancesData map[string]string, nodes *core.NodeList) ([]*instance.Info, error) {
+// be nil. Instances whose entry does not give a username are given the default username.
+func Parse(instancesData map[string]string, nodes *core.NodeList, defaultUsername string) ([]*instance.Info, error) {
 	if nodes == nil {
 		return nil, fmt.Errorf("nodes cannot be nil")
 	}
 	instances := make([]*instance.Info, 0)
 	// Get information about the instances from each entry. The expected key/value format for each entry is:
-	// <address>: username=<username>
+	// <address>: username=<username>[,port=<port>][,registerNode=<bool>], where the value may be left empty to use
+	// the default username and SSH port, with kubelet registering the Node
 	for address, data := range instancesData {
-		username, err := extractUsername(data)
+		instanceInfo, err := parseEntry(address, data, nodes, defaultUsername)
 		if err != nil {
-			return instances, fmt.Errorf("unable to get username for %s: %w", address, err)
+			return nil, err
 		}
+		instances = append(instances, instanceInfo)
+	}
+	return instances, nil
+}
 
-		// Node is only guaranteed to be found when looking for its IP address
-		ip, err := net.ResolveIPAddr("ip4", address)
-		if err != nil {
-			return nil, err
+// Validate returns a ParseError for each entry of the Windows instances data which is not well formed, ordered by
+// entry. Unlike Parse, it does not stop at the first invalid entry, so that every problem can be reported at once.
+// Addresses are only checked to be IPv4 addresses or DNS names, DNS names are not resolved, so that instances can be
+// added before their DNS record exists.
+func Validate(instancesData map[string]string, defaultUsername string) []*ParseError {
+	var parseErrs []*ParseError
+	for address, data := range instancesData {
+		if _, err := parseEntryData(address, data, defaultUsername); err != nil {
+			parseErrs = append(parseErrs, err)
 		}
+	}
+	slices.SortFunc(parseErrs, func(a, b *ParseError) int { return strings.Compare(a.Entry, b.Entry) })
+	return parseErrs
+}
 
-		// Create instance info with the associated node if the described instance has one.
-		// Address validation occurs upon construction.
-		instanceInfo, err := instance.NewInfo(address, username, "", false, nodeutil.FindByAddress(ip.String(), nodes))
-		if err != nil {
-			return nil, err
+// entryData holds the fields of an instance entry
+type entryData struct {
+	username     string
+	port         int
+	registerNode bool
+}
+
+// parseEntryData returns the fields of the given instance entry, checking that its address is an IPv4 address or a
+// DNS name without resolving it
+func parseEntryData(address, data, defaultUsername string) (*entryData, *ParseError) {
+	if ip := net.ParseIP(address); ip != nil {
+		if ip.To4() == nil {
+			return nil, &ParseError{Entry: address, Reason: "address must be an IPv4 address or a DNS name"}
 		}
-		instances = append(instances, instanceInfo)
+	} else if errs := validation.IsDNS1123Subdomain(strings.ToLower(address)); len(errs) > 0 {
+		return nil, &ParseError{Entry: address, Reason: fmt.Sprintf("address must be an IPv4 address or a DNS "+
+			"name: %s", strings.Join(errs, ", "))}
 	}
-	return instances, nil
+	username, err := extractUsername(data, defaultUsername)
+	if err != nil {
+		return nil, &ParseError{Entry: address, Reason: fmt.Sprintf("unable to get username: %s", err)}
+	}
+	port, err := extractSSHPort(data)
+	if err != nil {
+		return nil, &ParseError{Entry: address, Reason: fmt.Sprintf("unable to get port: %s", err)}
+	}
+	registerNode, err := extractRegisterNode(data)
+	if err != nil {
+		return nil, &ParseError{Entry: address, Reason: fmt.Sprintf("unable to get registerNode: %s", err)}
+	}
+	return &entryData{username: username, port: port, registerNode: registerNode}, nil
+}
+
+// parseEntry returns the instance described by the given instance entry, referencing its Node if it is in the given
+// NodeList
+func parseEntry(address, data string, nodes *core.NodeList, defaultUsername string) (*instance.Info, *ParseError) {
+	fields, parseErr := parseEntryData(address, data, defaultUsername)
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	// Node is only guaranteed to be found when looking for its IP address
+	ip, err := net.ResolveIPAddr("ip4", address)
+	if err != nil {
+		return nil, &ParseError{Entry: address, Reason: err.Error()}
+	}
+
+	// Create instance info with the associated node if the described instance has one.
+	// Address validation occurs upon construction.
+	instanceInfo, err := instance.NewInfo(address, fields.username, "",
+		nodeutil.FindByAddress(ip.String(), nodes))
+	if err != nil {
+		return nil, &ParseError{Entry: address, Reason: err.Error()}
+	}
+	instanceInfo.SSHPort = fields.port
+	instanceInfo.ExternallyRegistered = !fields.registerNode
+	return instanceInfo, nil
 }
 
-// GetNodeUsername retrieves the username associated with the given node from the instance ConfigMap data
-func GetNodeUsername(instancesData map[string]string, node *core.Node) (string, error) {
+// GetNodeUsername retrieves the username associated with the given node from the instance ConfigMap data, returning
+// the default username if the node's entry does not give one
+func GetNodeUsername(instancesData map[string]string, node *core.Node, defaultUsername string) (string, error) {
 	if node == nil {
 		return "", fmt.Errorf("cannot get username for nil node")
 	}
 	// Find entry in ConfigMap that is associated to node via address
 	for _, address := range node.Status.Addresses {
 		if value, found := instancesData[address.Address]; found {
-			return extractUsername(value)
+			return extractUsername(value, defaultUsername)
 		}
 	}
 	return "", fmt.Errorf("unable to find instance associated with node %s", node.GetName())
 }
 
-// extractUsername returns the username string from data in the form username=<username>
-func extractUsername(value string) (string, error) {
-	splitData := strings.SplitN(value, "=", 2)
-	if len(splitData) == 0 || splitData[0] != "username" {
-		return "", fmt.Errorf("data has an incorrect format")
+// extractUsername returns the username string from data in the form username=<username>[,port=<port>]. The default
+// username is returned if the data does not give one.
+func extractUsername(value, defaultUsername string) (string, error) {
+	fields, err := splitEntryData(value)
+	if err != nil {
+		return "", err
+	}
+	username, present := fields[usernameField]
+	if !present {
+		return defaultUsername, nil
+	}
+	if username == "" {
+		return "", fmt.Errorf("username cannot be empty")
+	}
+	return username, nil
+}
+
+// extractSSHPort returns the SSH port from data in the form username=<username>[,port=<port>]. Zero is returned if the
+// data does not give a port, so that the default port is used.
+func extractSSHPort(value string) (int, error) {
+	fields, err := splitEntryData(value)
+	if err != nil {
+		return 0, err
+	}
+	portValue, present := fields[portField]
+	if !present {
+		return 0, nil
+	}
+	port, err := strconv.Atoi(portValue)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("port %q must be an integer between 1 and 65535", portValue)
+	}
+	return port, nil
+}
+
+// extractRegisterNode returns whether kubelet should register the Node of the instance described by the given entry
+// data. kubelet registers the Node unless the data gives registerNode=false.
+func extractRegisterNode(value string) (bool, error) {
+	fields, err := splitEntryData(value)
+	if err != nil {
+		return false, err
+	}
+	registerNodeValue, present := fields[registerNodeField]
+	if !present {
+		return true, nil
+	}
+	registerNode, err := strconv.ParseBool(registerNodeValue)
+	if err != nil {
+		return false, fmt.Errorf("registerNode %q must be true or false", registerNodeValue)
+	}
+	return registerNode, nil
+}
+
+// splitEntryData returns the fields of the comma separated <field>=<value> pairs making up an instance entry's data
+func splitEntryData(value string) (map[string]string, error) {
+	fields := make(map[string]string)
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return fields, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		splitData := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(splitData) != 2 || (splitData[0] != usernameField && splitData[0] != portField &&
+			splitData[0] != registerNodeField) {
+			return nil, fmt.Errorf("data has an incorrect format")
+		}
+		if _, present := fields[splitData[0]]; present {
+			return nil, fmt.Errorf("%s given more than once", splitData[0])
+		}
+		fields[splitData[0]] = strings.TrimSpace(splitData[1])
 	}
-	return splitData[1], nil
+	return fields, nil
 }
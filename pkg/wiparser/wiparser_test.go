@@ -1,12 +1,18 @@
 package wiparser
 
 import (
+	"context"
+	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
 	core "k8s.io/api/core/v1"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
 	"github.com/openshift/windows-machine-config-operator/pkg/instance"
 )
@@ -27,6 +33,79 @@ func TestParse(t *testing.T) {
 			expectedOut: nil,
 			expectedErr: true,
 		},
+		{
+			name:        "empty username",
+			input:       map[string]string{"localhost": "username="},
+			nodeList:    &core.NodeList{},
+			expectedOut: nil,
+			expectedErr: true,
+		},
+		{
+			name:        "username not given",
+			input:       map[string]string{"localhost": ""},
+			nodeList:    &core.NodeList{},
+			expectedOut: []*instance.Info{{Address: "localhost", IPv4Address: "127.0.0.1", Username: "Administrator"}},
+			expectedErr: false,
+		},
+		{
+			name:        "username and port",
+			input:       map[string]string{"localhost": "username=core,port=2222"},
+			nodeList:    &core.NodeList{},
+			expectedOut: []*instance.Info{{Address: "localhost", IPv4Address: "127.0.0.1", Username: "core", SSHPort: 2222}},
+			expectedErr: false,
+		},
+		{
+			name:     "port without username",
+			input:    map[string]string{"localhost": "port=2222"},
+			nodeList: &core.NodeList{},
+			expectedOut: []*instance.Info{{Address: "localhost", IPv4Address: "127.0.0.1", Username: "Administrator",
+				SSHPort: 2222}},
+			expectedErr: false,
+		},
+		{
+			name:        "port out of range",
+			input:       map[string]string{"localhost": "username=core,port=65536"},
+			nodeList:    &core.NodeList{},
+			expectedOut: nil,
+			expectedErr: true,
+		},
+		{
+			name:        "non-numeric port",
+			input:       map[string]string{"localhost": "username=core,port=ssh"},
+			nodeList:    &core.NodeList{},
+			expectedOut: nil,
+			expectedErr: true,
+		},
+		{
+			name:        "port given twice",
+			input:       map[string]string{"localhost": "port=22,port=2222"},
+			nodeList:    &core.NodeList{},
+			expectedOut: nil,
+			expectedErr: true,
+		},
+		{
+			name:     "externally registered node",
+			input:    map[string]string{"localhost": "username=core,registerNode=false"},
+			nodeList: &core.NodeList{},
+			expectedOut: []*instance.Info{{Address: "localhost", IPv4Address: "127.0.0.1", Username: "core",
+				ExternallyRegistered: true}},
+			expectedErr: false,
+		},
+		{
+			name:     "node registered by kubelet",
+			input:    map[string]string{"localhost": "port=2222,registerNode=true"},
+			nodeList: &core.NodeList{},
+			expectedOut: []*instance.Info{{Address: "localhost", IPv4Address: "127.0.0.1", Username: "Administrator",
+				SSHPort: 2222}},
+			expectedErr: false,
+		},
+		{
+			name:        "invalid registerNode",
+			input:       map[string]string{"localhost": "username=core,registerNode=no"},
+			nodeList:    &core.NodeList{},
+			expectedOut: nil,
+			expectedErr: true,
+		},
 		{
 			name:        "invalid DNS address",
 			input:       map[string]string{"notlocalhost": "username=core"},
@@ -139,9 +218,11 @@ func TestParse(t *testing.T) {
 	}
 	for _, test := range testCases {
 		t.Run(test.name, func(t *testing.T) {
-			out, err := Parse(test.input, test.nodeList)
+			out, err := Parse(test.input, test.nodeList, "Administrator")
 			if test.expectedErr {
-				assert.Error(t, err)
+				var parseErr *ParseError
+				require.ErrorAs(t, err, &parseErr)
+				assert.Contains(t, test.input, parseErr.Entry)
 				return
 			}
 			require.NoError(t, err)
@@ -197,6 +278,27 @@ func TestGetNodeUsername(t *testing.T) {
 			expectedOut: "",
 			expectedErr: true,
 		},
+		{
+			name:        "empty username in map data",
+			data:        map[string]string{"111.1.1.1": "username="},
+			node:        testNode,
+			expectedOut: "",
+			expectedErr: true,
+		},
+		{
+			name:        "username not given in map data",
+			data:        map[string]string{"111.1.1.1": ""},
+			node:        testNode,
+			expectedOut: "Administrator",
+			expectedErr: false,
+		},
+		{
+			name:        "username and port in map data",
+			data:        map[string]string{"111.1.1.1": "username=core, port=2222"},
+			node:        testNode,
+			expectedOut: "core",
+			expectedErr: false,
+		},
 		{
 			name:        "node not in map data",
 			data:        map[string]string{"localhost": "username=core"},
@@ -221,7 +323,7 @@ func TestGetNodeUsername(t *testing.T) {
 	}
 	for _, test := range testCases {
 		t.Run(test.name, func(t *testing.T) {
-			out, err := GetNodeUsername(test.data, test.node)
+			out, err := GetNodeUsername(test.data, test.node, "Administrator")
 			if test.expectedErr {
 				assert.Error(t, err)
 				return
@@ -231,3 +333,107 @@ func TestGetNodeUsername(t *testing.T) {
 		})
 	}
 }
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		name            string
+		input           map[string]string
+		expectedEntries []string
+	}{
+		{
+			name:            "valid entries",
+			input:           map[string]string{"localhost": "username=core", "127.0.0.2": "port=2222"},
+			expectedEntries: nil,
+		},
+		{
+			name: "every invalid entry is reported",
+			input: map[string]string{"localhost": "username=core", "127.0.0.3": "username=", "127.0.0.2": "port=0",
+				"127.0.0.4": "registerNode=yes"},
+			expectedEntries: []string{"127.0.0.2", "127.0.0.3", "127.0.0.4"},
+		},
+		{
+			name:            "invalid address",
+			input:           map[string]string{"not a valid address": "username=core"},
+			expectedEntries: []string{"not a valid address"},
+		},
+		{
+			name:            "DNS names are not resolved",
+			input:           map[string]string{"winhost.not-created-yet.invalid": "username=core"},
+			expectedEntries: nil,
+		},
+		{
+			name:            "IPv6 address",
+			input:           map[string]string{"fd00::5": "username=core"},
+			expectedEntries: []string{"fd00::5"},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			var entries []string
+			for _, parseErr := range Validate(test.input, "Administrator") {
+				assert.NotEmpty(t, parseErr.Reason)
+				entries = append(entries, parseErr.Entry)
+			}
+			assert.Equal(t, test.expectedEntries, entries)
+		})
+	}
+}
+
+func TestValidatorHandle(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	validator := NewValidator(scheme, "wmco", "Administrator")
+
+	testCases := []struct {
+		name            string
+		configMap       core.ConfigMap
+		expectedAllowed bool
+		expectedReasons []string
+	}{
+		{
+			name: "valid instance ConfigMap",
+			configMap: core.ConfigMap{ObjectMeta: meta.ObjectMeta{Name: InstanceConfigMap, Namespace: "wmco"},
+				Data: map[string]string{"localhost": "username=core"}},
+			expectedAllowed: true,
+		},
+		{
+			name: "invalid instance ConfigMap",
+			configMap: core.ConfigMap{ObjectMeta: meta.ObjectMeta{Name: InstanceConfigMap, Namespace: "wmco"},
+				Data: map[string]string{"localhost": "username=core", "127.0.0.2": "username=",
+					"127.0.0.3": "port=70000"}},
+			expectedAllowed: false,
+			expectedReasons: []string{"windows-instances ConfigMap is invalid: invalid entry 127.0.0.2: unable to get " +
+				"username", "; invalid entry 127.0.0.3: unable to get port"},
+		},
+		{
+			name: "other ConfigMap",
+			configMap: core.ConfigMap{ObjectMeta: meta.ObjectMeta{Name: "other", Namespace: "wmco"},
+				Data: map[string]string{"127.0.0.2": "username="}},
+			expectedAllowed: true,
+		},
+		{
+			name: "instance ConfigMap in other namespace",
+			configMap: core.ConfigMap{ObjectMeta: meta.ObjectMeta{Name: InstanceConfigMap, Namespace: "other"},
+				Data: map[string]string{"127.0.0.2": "username="}},
+			expectedAllowed: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			raw, err := json.Marshal(test.configMap)
+			require.NoError(t, err)
+			req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Name:      test.configMap.GetName(),
+				Namespace: test.configMap.GetNamespace(),
+				Operation: admissionv1.Update,
+				Object:    runtime.RawExtension{Raw: raw},
+			}}
+			resp := validator.Handle(context.TODO(), req)
+			assert.Equal(t, test.expectedAllowed, resp.Allowed)
+			for _, reason := range test.expectedReasons {
+				require.NotNil(t, resp.Result)
+				assert.Contains(t, resp.Result.Message, reason)
+			}
+		})
+	}
+}